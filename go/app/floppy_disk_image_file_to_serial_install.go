@@ -24,343 +24,9835 @@ floppy_disk_image_file_to_serial_install reads a disk image file and produces ou
 corresponding to a series of commands to the apple ][ system monitor which would result
 in the writing of a single track of a floppy disk compatible with the Apple Disk II
 floppy drive. The disk must have previously been formatted using the ProDOS disk formatting
-utility. This format comprises 35 tracks of 16 sectors per track. Sectors contain 256 bytes.
+utility or the DOS 3.3 INIT command (see -target-format). This format comprises 35 tracks of 16
+sectors per track. Sectors contain 256 bytes.
 
 Note : the output serial commands rely on the availabilty of the RWTS routine in memory. So the
 apple ][ computer must have been booted into DOS before receiving the transmission of the series
 of commands. The code has been seen to function correctly with the DOS3.3 RWTS routine, and
 follows the example subroutine published in the Apple II "The DOS Manual" Copyright (c) 1980, 1981
-by APPLE COMPUTER, INC. (pages 94-98)
+by APPLE COMPUTER, INC. (pages 94-98). With -profile bootstrap, DOS is not needed (see README.md).
 
 The word Apple and The Apple Logo are registered trademarks of APPLE COMPUTER INC.
 
-Usage: 
-	floppy_disk_image_file_to_serial_install diskImageFilepath trackNum
+Usage:
+	floppy_disk_image_file_to_serial_install [install] [flags] diskImageFilepath trackNum
+	floppy_disk_image_file_to_serial_install [install] -all-tracks [flags] diskImageFilepath
+	floppy_disk_image_file_to_serial_install subcommand [flags] arguments
 
-diskImageFilepath must refer to a file in ProDOS sector order format (such as *.PO files)
-trackNum must be an integer in the range [0,34]
+diskImageFilepath must refer to a disk image file in ProDOS sector order (such as *.PO files) or
+DOS3.3 sector order (such as *.DO files), or to a nibble, WOZ or 2MG image; the format is detected and
+reported. trackNum must be an integer in the range [0,34].
+
+Besides install, the subcommands dump tracks of a disk back to an image, convert, split, check and
+change disk images, and send files with YMODEM, ZMODEM or XMODEM. -help lists the subcommands, and
+"subcommand -help" lists the flags of one of them. README.md describes each of them in more detail.
 */
 package main
 
 import "bufio"
+import "bytes"
+import "compress/gzip"
+import "crypto/aes"
+import "crypto/cipher"
+import "crypto/md5"
+import cryptorand "crypto/rand"
+import "crypto/sha1"
+import "crypto/sha256"
+import "encoding/binary"
+import "encoding/csv"
+import "encoding/hex"
+import "encoding/json"
 import "errors"
+import "flag"
 import "fmt"
+import "github.com/bassjack1/apple2disk/go/diskimage"
+import "github.com/bassjack1/apple2disk/go/emulator"
+import "github.com/bassjack1/apple2disk/go/failure"
+import "github.com/bassjack1/apple2disk/go/filesystem"
+import "github.com/bassjack1/apple2disk/go/gcr"
+import "github.com/bassjack1/apple2disk/go/protocol"
+import "github.com/bassjack1/apple2disk/go/simulator"
+import "hash"
+import "hash/crc32"
+import "image"
+import "image/color"
+import "image/png"
 import "io"
+import "io/ioutil"
+import "math"
+import "math/rand"
+import "net"
+import "net/http"
+import "net/url"
 import "os"
+import "os/exec"
+import "path/filepath"
+import "regexp"
 import "strconv"
 import "strings"
+import "sync"
+import "time"
 
-// readDiskImageFromFile fills the diskImage slice with data read directly from file diskImageFilePath.
-// It also reports the count of read bytes to stderr.
-func readDiskImageFromFile(diskImage *[]byte, diskImageFilepath string) {
-	var f *os.File
-	var err error
-	f, err = os.Open(diskImageFilepath)
+// RWTS command codes, stored in the command byte of the IOB.
+const RWTS_COMMAND_READ = 0x01
+const RWTS_COMMAND_WRITE = 0x02
+
+// diskImageDownloadMaxBytes limits the size of a disk image fetched from a URL, and
+// diskImageChecksum, when not empty, is the SHA-256 (in hexadecimal) the image file must have.
+var diskImageDownloadMaxBytes int = 32 * 1024 * 1024
+var diskImageChecksum string
+
+// diskImageIsDos33Order is set when the 140K disk image files given are known to be in DOS3.3 sector
+// order, so that their sector order is not detected.
+var diskImageIsDos33Order bool
+
+// diskImageReadOrder names the sector order (see SECTOR_INTERLEAVES) of the plain disk image file
+// last read by readDiskImageFromFile, or is empty when the file was a nibble, WOZ or 2MG image, so
+// that an image changed in place can be written back in the order it was read.
+var diskImageReadOrder diskimage.SectorOrder
+
+// diskImageVolume is the volume number given by the header of the 2MG image last read, or -1 when the
+// header gives none or the image was not a 2MG image, and diskImageIsLocked is set when that header
+// marks the image locked, so that it must not be changed.
+var diskImageVolume int = -1
+var diskImageIsLocked bool
+
+// targetDiskSlot and targetDiskDrive are the slot of the Disk II controller and the drive (1 or 2) on
+// it which the RWTS client and the bootstrap writer write to.
+var targetDiskSlot int = 6
+var targetDiskDrive int = 1
+
+// targetDiskVolume is the volume number the RWTS client expects in the address fields of the
+// destination disk, 0 accepting any volume. The bootstrap writer formats tracks with it, or with 254
+// when it is 0.
+var targetDiskVolume byte
+
+// clientAddress is the memory address the client programs are loaded at (the read back program takes
+// the page after them), and bufferAddress that of the data buffer the tracks are loaded into and read
+// back into. Both are at the start of a memory page, and the addresses in the client programs and the
+// IOB are moved to them.
+var clientAddress int = 0x0C00
+var bufferAddress int = 0x2000
+
+// diskImageInterleave, when not empty, names the sector order (see SECTOR_INTERLEAVES) the 140K disk
+// image files given are in, so that their sector order is not detected.
+var diskImageInterleave diskimage.SectorOrder
+
+// diskImageIs13Sector is set when the image last read by readDiskImageFromFile is a 13-sector image
+// (a .D13 image, or a nibble image of a 13-sector disk), which is then kept as 13 sectors per track.
+var diskImageIs13Sector bool
+
+// skipBadSectors, when set, lets a nibble or WOZ image with unrecoverable sectors be read, leaving
+// those sectors filled with zeros.
+var skipBadSectors bool
+
+// DISK_IMAGE_READ_CHUNK_SIZE is the count of bytes read from a disk image at a time, one track of a
+// floppy image.
+const DISK_IMAGE_READ_CHUNK_SIZE = 0x1000
+
+// errDiskImageTooLarge is returned by streamDiskImageTracks when the image holds more bytes than
+// allowed.
+var errDiskImageTooLarge error = errors.New("disk image larger than the limit")
+
+// streamDiskImageTracks reads r one track (DISK_IMAGE_READ_CHUNK_SIZE bytes) at a time, handing each
+// track to handleTrack as soon as it is read, the last one being shorter when r does not hold a whole
+// number of tracks. The slice handed over is reused for the next track. It stores the count of bytes
+// read into byteCount, and returns errDiskImageTooLarge, having stopped reading, when r holds more than
+// maxBytes bytes (unless maxBytes is 0), or the error of reading r or of handleTrack.
+func streamDiskImageTracks(byteCount *int, r io.Reader, maxBytes int, handleTrack func(track []byte) error) error {
+	var chunk []byte = make([]byte, DISK_IMAGE_READ_CHUNK_SIZE)
+	*byteCount = 0
+	for {
+		var n int
+		n, err := io.ReadFull(r, chunk)
+		*byteCount = *byteCount + n
+		if maxBytes > 0 && *byteCount > maxBytes {
+			return errDiskImageTooLarge
+		}
+		if n > 0 {
+			var handleErr error = handleTrack(chunk[:n])
+			if handleErr != nil {
+				return handleErr
+			}
+		}
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// readDiskImageStream appends the bytes of r to the diskImage slice one track at a time, as
+// streamDiskImageTracks reads them, making room for sizeHint bytes first when the size is known (so
+// that large hard disk images are not copied as they grow), and writes each track to checksumOutput
+// when it is not nil. It returns errDiskImageTooLarge, having stopped reading, when r holds more than
+// maxBytes bytes (unless maxBytes is 0), or the error of reading r.
+func readDiskImageStream(diskImage *[]byte, r io.Reader, sizeHint int64, maxBytes int, checksumOutput io.Writer) error {
+	if sizeHint > 0 && (maxBytes == 0 || sizeHint <= int64(maxBytes)) {
+		var grown []byte = make([]byte, len(*diskImage), int64(len(*diskImage))+sizeHint)
+		copy(grown, *diskImage)
+		*diskImage = grown
+	}
+	var byteCount int
+	return streamDiskImageTracks(&byteCount, r, maxBytes, func(track []byte) error {
+		*diskImage = append(*diskImage, track...)
+		if checksumOutput != nil {
+			checksumOutput.Write(track)
+		}
+		return nil
+	})
+}
+
+// openDiskImageStream opens the image file diskImageFilepath to be read a track at a time by
+// streamDiskImageTracks, without ever being held whole in memory, when its blocks need no conversion:
+// a plain ProDOS order block image of another size than the floppy images, such as an .hdv hard disk
+// image, or a 2MG image holding one. It returns the file, storing into data the reader of its blocks
+// (starting at the first one) and into byteCount their count of bytes. It returns a nil file when the
+// image is to be read whole by readDiskImageFromFile instead: an image fetched from a URL, compressed,
+// encrypted, to be checked against diskImageChecksum or taken in diskImageInterleave, a floppy image (whose sector
+// order may need detecting), and the other formats.
+func openDiskImageStream(data *io.Reader, byteCount *int, diskImageFilepath string) (*os.File, error) {
+	var lowerFilepath string = strings.ToLower(diskImageFilepath)
+	if strings.HasPrefix(lowerFilepath, "http://") || strings.HasPrefix(lowerFilepath, "https://") || strings.HasSuffix(lowerFilepath, ".gz") {
+		return nil, nil
+	}
+	if diskImageChecksum != "" || diskImageInterleave != "" || GEOMETRY_OF_EXTENSION[filepath.Ext(lowerFilepath)] != "" {
+		return nil, nil
+	}
+	file, err := os.Open(diskImageFilepath)
+	if err != nil {
+		return nil, err
+	}
+	fileInfo, err := file.Stat()
 	if err != nil {
-		panic(err)
+		file.Close()
+		return nil, err
+	}
+	var header []byte = make([]byte, TWO_IMG_HEADER_SIZE)
+	var headerLength int
+	headerLength, err = io.ReadFull(file, header)
+	if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+		file.Close()
+		return nil, err
+	}
+	header = header[:headerLength]
+	var dataOffset int64 = 0
+	var dataLength int64 = fileInfo.Size()
+	if headerLength == TWO_IMG_HEADER_SIZE && string(header[0:4]) == "2IMG" {
+		if binary.LittleEndian.Uint32(header[0x0C:0x10]) != TWO_IMG_FORMAT_PRODOS_ORDER {
+			file.Close()
+			return nil, nil
+		}
+		readTwoImgFlags(header)
+		dataOffset = int64(binary.LittleEndian.Uint32(header[0x18:0x1C]))
+		dataLength = int64(binary.LittleEndian.Uint32(header[0x1C:0x20]))
+		if dataLength == 0 {
+			// some images only give the count of ProDOS blocks
+			dataLength = int64(binary.LittleEndian.Uint32(header[0x14:0x18])) * diskimage.PRODOS_BLOCK_SIZE
+		}
+		if dataOffset < TWO_IMG_HEADER_SIZE || dataLength > fileInfo.Size()-dataOffset {
+			file.Close()
+			return nil, failure.CodedErrorf(failure.KIND_2MG, "2MG image data at offset %d of %d bytes is outside the file", dataOffset, dataLength)
+		}
+	} else if headerLength >= 4 && (string(header[0:4]) == "WOZ1" || string(header[0:4]) == "WOZ2") {
+		file.Close()
+		return nil, nil
+	} else if isEncryptedData(header) {
+		file.Close()
+		return nil, nil
 	}
-	defer f.Close()
-	var bufr *bufio.Reader = bufio.NewReader(f)
-	for f != nil {
-		var b byte
-		b, err = bufr.ReadByte()
+	if dataLength == diskimage.FLOPPY_IMAGE_SIZE || dataLength == gcr.D13_IMAGE_SIZE || dataLength == gcr.NIB_IMAGE_SIZE {
+		file.Close()
+		return nil, nil
+	}
+	if dataOffset == 0 {
+		err = validateDiskImageByteCount(int(dataLength), diskImageFilepath, diskImageFilepath)
 		if err != nil {
-			if errors.Is(err, io.EOF) {
-				f = nil
-			} else {
-				panic(err)
-			}
-		} else {
-			*diskImage = append(*diskImage, b)
+			file.Close()
+			return nil, err
+		}
+	}
+	_, err = file.Seek(dataOffset, io.SeekStart)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	*data = io.LimitReader(file, dataLength)
+	*byteCount = int(dataLength)
+	if dataOffset == 0 {
+		fmt.Fprintf(os.Stderr, "streaming %d bytes from file %s, a ProDOS sector order block image\n", dataLength, diskImageFilepath)
+	} else {
+		fmt.Fprintf(os.Stderr, "streaming %d bytes from file %s, a 2MG image in ProDOS sector order\n", dataLength, diskImageFilepath)
+	}
+	return file, nil
+}
+
+// readDiskImageFromFile fills the diskImage slice with data read directly from file diskImageFilePath,
+// streamed a track at a time by readDiskImageStream. It also reports the count of read bytes to
+// stderr. diskImageFilepath may also be an http or https URL, which is fetched up to
+// diskImageDownloadMaxBytes. The file is checked against diskImageChecksum when set, an encrypted file
+// is then decrypted with the key file diskImageKeyFilepath, and a file whose name ends in .gz (before
+// any .enc) is decompressed after that. The format of the image is then detected and
+// reported, and nibble, WOZ and 2MG images are unwrapped and DOS3.3 order images converted to ProDOS
+// sector order, so that the rest of the program sees the same order whatever the file holds. 13-sector images, and nibble images of 13-sector disks, are kept as 13 sectors per track
+// instead, setting diskImageIs13Sector. It returns an error when the file cannot be read, or does not
+// hold an image of an accepted size or the expected checksum, or when diskImageInterleave names no
+// sector order.
+func readDiskImageFromFile(diskImage *[]byte, diskImageFilepath string) error {
+	_, found := diskimage.SECTOR_INTERLEAVES[diskImageInterleave]
+	if diskImageInterleave != "" && !found {
+		return failure.CodedErrorf(failure.KIND_UNKNOWN_VALUE, "unknown sector interleave: %s", diskImageInterleave)
+	}
+	diskImageVolume = -1
+	diskImageIsLocked = false
+	var f io.ReadCloser
+	var err error
+	var isUrl bool = strings.HasPrefix(diskImageFilepath, "http://") || strings.HasPrefix(diskImageFilepath, "https://")
+	var sizeHint int64
+	var maxBytes int = 0
+	if isUrl {
+		var response *http.Response
+		response, err = http.Get(diskImageFilepath)
+		if err != nil {
+			return err
+		}
+		if response.StatusCode != http.StatusOK {
+			response.Body.Close()
+			return failure.CodedErrorf(failure.KIND_FETCH_FAILED, "fetching %s failed: %s", diskImageFilepath, response.Status)
+		}
+		f = response.Body
+		defer f.Close()
+		sizeHint = response.ContentLength
+		maxBytes = diskImageDownloadMaxBytes
+	} else {
+		var file *os.File
+		file, err = os.Open(diskImageFilepath)
+		if err != nil {
+			return err
+		}
+		f = file
+		defer f.Close()
+		var fileInfo os.FileInfo
+		fileInfo, err = file.Stat()
+		if err != nil {
+			return err
 		}
+		sizeHint = fileInfo.Size()
+	}
+	var imageHash hash.Hash
+	if diskImageChecksum != "" {
+		imageHash = sha256.New()
+	}
+	var checksumOutput io.Writer
+	if imageHash != nil {
+		checksumOutput = imageHash
+	}
+	err = readDiskImageStream(diskImage, f, sizeHint, maxBytes, checksumOutput)
+	if errors.Is(err, errDiskImageTooLarge) {
+		return failure.CodedErrorf(failure.KIND_DOWNLOAD_TOO_LARGE, "%s is larger than the download limit of %d bytes", diskImageFilepath, diskImageDownloadMaxBytes)
+	}
+	if err != nil {
+		return fmt.Errorf("reading %s: %w", diskImageFilepath, err)
 	}
 	fmt.Fprintf(os.Stderr, "read %d bytes from file %s\n", len(*diskImage), diskImageFilepath)
+	if imageHash != nil {
+		var checksum string = fmt.Sprintf("%x", imageHash.Sum(nil))
+		if checksum != strings.ToLower(diskImageChecksum) {
+			return failure.CodedErrorf(failure.KIND_SHA256_MISMATCH, "%s has SHA-256 %s, expected %s", diskImageFilepath, checksum, diskImageChecksum)
+		}
+	}
+	var fileName string = diskImageFilepath
+	if isUrl {
+		var imageUrl *url.URL
+		imageUrl, err = url.Parse(diskImageFilepath)
+		if err != nil {
+			return err
+		}
+		fileName = imageUrl.Path
+	}
+	if isEncryptedData(*diskImage) {
+		err = decryptDataWithKeyFile(diskImage, diskImageFilepath)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "decrypted to %d bytes\n", len(*diskImage))
+		if strings.HasSuffix(strings.ToLower(fileName), ENCRYPTED_FILE_EXTENSION) {
+			fileName = fileName[:len(fileName)-len(ENCRYPTED_FILE_EXTENSION)]
+		}
+	}
+	if strings.HasSuffix(strings.ToLower(fileName), ".gz") {
+		var gzipReader *gzip.Reader
+		gzipReader, err = gzip.NewReader(bytes.NewReader(*diskImage))
+		if err != nil {
+			return err
+		}
+		// the gzip reader keeps the compressed bytes
+		*diskImage = nil
+		err = readDiskImageStream(diskImage, gzipReader, 0, diskImageDownloadMaxBytes, nil)
+		if errors.Is(err, errDiskImageTooLarge) {
+			return failure.CodedErrorf(failure.KIND_DOWNLOAD_TOO_LARGE, "%s decompresses to more than %d bytes", diskImageFilepath, diskImageDownloadMaxBytes)
+		}
+		if err != nil {
+			return fmt.Errorf("decompressing %s: %w", diskImageFilepath, err)
+		}
+		fmt.Fprintf(os.Stderr, "decompressed to %d bytes\n", len(*diskImage))
+	}
+	err = validateDiskImageSize(*diskImage, diskImageFilepath, fileName)
+	if err != nil {
+		return err
+	}
+	diskImageIs13Sector = false
+	if diskImageInterleave != "" && len(*diskImage) == diskimage.FLOPPY_IMAGE_SIZE {
+		fmt.Fprintf(os.Stderr, "taking the image in %s sector order (-interleave)\n", diskImageInterleave)
+		err = diskimage.ReorderDiskImageSectors(*diskImage, diskImageInterleave, diskimage.SECTOR_ORDER_PRODOS)
+		if err != nil {
+			return err
+		}
+		diskImageReadOrder = diskImageInterleave
+		return nil
+	}
+	var format string
+	var reason string
+	err = detectDiskImageFormat(&format, &reason, *diskImage, fileName, diskImageIsDos33Order)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "detected %s image (%s)\n", format, reason)
+	var isDos33Order bool = format == IMAGE_FORMAT_DOS33_ORDER
+	diskImageReadOrder = diskimage.SECTOR_ORDER_PRODOS
+	if isDos33Order {
+		diskImageReadOrder = diskimage.SECTOR_ORDER_DOS
+	}
+	if format == IMAGE_FORMAT_NIBBLE || format == IMAGE_FORMAT_WOZ || format == IMAGE_FORMAT_2MG || format == IMAGE_FORMAT_13_SECTOR {
+		diskImageReadOrder = ""
+	}
+	if format == IMAGE_FORMAT_NIBBLE && gcr.NibbleImageIs13Sector(*diskImage) {
+		err = gcr.ConvertNibbleImageTo13SectorImage(diskImage, skipBadSectors)
+		if err != nil {
+			return fmt.Errorf("%s: %w", diskImageFilepath, err)
+		}
+		format = IMAGE_FORMAT_13_SECTOR
+	}
+	if format == IMAGE_FORMAT_13_SECTOR {
+		// 13-sector images are kept as they are, having no 16 sector order
+		diskImageIs13Sector = true
+		return nil
+	}
+	if format == IMAGE_FORMAT_NIBBLE {
+		err = gcr.ConvertNibbleImageToDos33Order(diskImage, skipBadSectors)
+		isDos33Order = true
+	} else if format == IMAGE_FORMAT_WOZ {
+		err = gcr.ConvertWozImageToDos33Order(diskImage, skipBadSectors)
+		isDos33Order = true
+	} else if format == IMAGE_FORMAT_2MG {
+		err = extractTwoImgData(&isDos33Order, diskImage)
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %w", diskImageFilepath, err)
+	}
+	if isDos33Order {
+		if len(*diskImage) != diskimage.FLOPPY_IMAGE_SIZE {
+			return failure.CodedErrorf(failure.KIND_DOS33_IMAGE_SIZE, "DOS 3.3 sector order images must hold %d bytes, not %d", diskimage.FLOPPY_IMAGE_SIZE, len(*diskImage))
+		}
+		err = diskimage.ConvertDiskImageFromDos33OrderToProdosOrder(*diskImage)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
-// diskImageStartPosOfTrackSector returns an integer offset corresponding to the start of a
-// specified track/sector in a raw disk image.  trackNum must be in [0,34], sectorNum must be in [0,15].
-func diskImageStartPosOfTrackSector(trackNum int, sectorNum int) int {
-	//     0x000TTSBB              0x000TTSBB
-	return 0x00001000 * trackNum + 0x00000100 * sectorNum
-}
-
-// Sector suffling section begin
-
-// readSectorDataToBuffer fills the sectorBuffer slice with one sector of data
-// from diskImage starting at the offset for track,sector.
-func readSectorDataToBuffer(sectorBuffer *[0x0100]byte, diskImage []byte, track int, sector int) {
-	var sourceBytesPos int = diskImageStartPosOfTrackSector(track, sector)
-	var destinationPos int = 0
-	for destinationPos < 0x0100 {
-		sectorBuffer[destinationPos] = diskImage[sourceBytesPos]
-		destinationPos = destinationPos + 1
-		sourceBytesPos = sourceBytesPos + 1
-	}
-}
-
-// writeSectorDataFromBuffer overwrites one sector of data in diskImage starting at the
-// offset for track,sector with the data stored in the sectorBuffer.
-func writeSectorDataFromBuffer(sectorBuffer *[0x0100]byte, diskImage []byte, track int, sector int) {
-	var destinationBytesPos int = diskImageStartPosOfTrackSector(track, sector)
-	var sourcePos int = 0
-	for sourcePos < 0x0100 {
-		diskImage[destinationBytesPos] = sectorBuffer[sourcePos]
-		sourcePos = sourcePos + 1
-		destinationBytesPos = destinationBytesPos + 1
-	}
-}
-
-// writeSectorDataFromBuffer overwrites one sector of data in diskImage starting at the
-// offset for track,destinationSector with the data from the diskImage starting at the
-// offset for track,sourceSector.
-func copySectorDataInImage(diskImage []byte, track int, sourceSector int, destinationSector int) {
-	var sourceBytesPos int = diskImageStartPosOfTrackSector(track, sourceSector)
-	var destinationBytesPos int = diskImageStartPosOfTrackSector(track, destinationSector)
-	var bytesCopied = 0
-	for bytesCopied < 0x0100 {
-		diskImage[destinationBytesPos] = diskImage[sourceBytesPos]
-		destinationBytesPos = destinationBytesPos + 1
-		sourceBytesPos = sourceBytesPos + 1
-		bytesCopied = bytesCopied + 1
-	}
-}
-
-// convertDiskImageFromProdosOrderToDos33Order reorders the content of the passed in DiskImage by
-// rearranging the sectors of each track into a new order. Exactly how this worked is still somehwat
-// unclear. Several attempts at reordering were made before this one was found to be successful.
-// Some of the online references which were helpful towards understanding the issue were:
-// https://stason.org/TULARC/pc/apple2/faq/10-006-What-are-DSK-PO-DO-HDV-NIB-and-2MG-disk-image.html
-// https://retrocomputing.stackexchange.com/questions/85/whats-the-difference-between-dos-ordered-and-prodos-ordered-disk-images
-// https://nerdlypleasures.blogspot.com/2021/02/the-woz-format-accurate-preservation-of.html
-// https://comp.sys.apple2.narkive.com/JY05JygH/reference-for-layout-of-prodos-and-dos-3-3-sector-ordering
-// https://retrocomputing.stackexchange.com/questions/15056/converting-apple-ii-prodos-blocks-to-dos-tracks-and-sectors
-// The last article has a comment by user "fadden" pointing to ciderpress code here:
-// https://github.com/fadden/ciderpress/blob/master/diskimg/DiskImg.cpp
-// This code was the most informative, although the issue is still confusing.
-// Some of the documentation claims that prodos physical sector arrangment on the
-// disk is non-sequential. However a nibble stream editor for tracks showed that
-// a ProDOS formatted or a DOS3.3 formatted disk had the same physical sector ordering:
-// 0x00,0x01,0x02,0x03,0x04,0x05,0x06,0x07,0x08,0x09,0x0A,0x0B,0x0C,0x0D,0x0E,0x0F
-// But the translation of logical blocks (512 bytes per block) into sector pairs is
-// somewhat opaque. It seems as though there is a re-ordering of sectors under prodos
-// which differs from the web references above, or the .PO file format is not actually in 
-// logical bock sequential order. The order which worked here is to write each track (16
-// 256 byte sectors) in this physical sector ordering:
-// 0x00,0x0E,0x0D,0x0C,0x0B,0x0A,0x09,0x08,0x07,0x06,0x05,0x04,0x03,0x02,0x01,0x0F
-func convertDiskImageFromProdosOrderToDos33Order(diskImage []byte) {
-	var sectorBuffer [0x0100]byte
-	for track := 0x00; track < 0x23; track = track + 1 {
-		// rotation group 1
-		readSectorDataToBuffer(&sectorBuffer, diskImage, track, 0x01) // 0x01 -> 0x0E
-		copySectorDataInImage(diskImage, track, 0x0E, 0x01) // 0x0E -> 0x01
-		writeSectorDataFromBuffer(&sectorBuffer, diskImage, track, 0x0E)
-		// rotation group 2
-		readSectorDataToBuffer(&sectorBuffer, diskImage, track, 0x02) // 0x02 -> 0x0D
-		copySectorDataInImage(diskImage, track, 0x0D, 0x02) // 0x0D -> 0x02
-		writeSectorDataFromBuffer(&sectorBuffer, diskImage, track, 0x0D)
-		// rotation group 3
-		readSectorDataToBuffer(&sectorBuffer, diskImage, track, 0x03) // 0x03 -> 0x0C
-		copySectorDataInImage(diskImage, track, 0x0C, 0x03) // 0x0C -> 0x03
-		writeSectorDataFromBuffer(&sectorBuffer, diskImage, track, 0x0C)
-		// rotation group 4
-		readSectorDataToBuffer(&sectorBuffer, diskImage, track, 0x04) // 0x04 -> 0x0B
-		copySectorDataInImage(diskImage, track, 0x0B, 0x04) // 0x0B -> 0x04
-		writeSectorDataFromBuffer(&sectorBuffer, diskImage, track, 0x0B)
-		// rotation group 5
-		readSectorDataToBuffer(&sectorBuffer, diskImage, track, 0x05) // 0x05 -> 0x0A
-		copySectorDataInImage(diskImage, track, 0x0A, 0x05) // 0x0A -> 0x05
-		writeSectorDataFromBuffer(&sectorBuffer, diskImage, track, 0x0A)
-		// rotation group 6
-		readSectorDataToBuffer(&sectorBuffer, diskImage, track, 0x06) // 0x06 -> 0x09
-		copySectorDataInImage(diskImage, track, 0x09, 0x06) // 0x09 -> 0x06
-		writeSectorDataFromBuffer(&sectorBuffer, diskImage, track, 0x09)
-		// rotation group 7
-		readSectorDataToBuffer(&sectorBuffer, diskImage, track, 0x07) // 0x07 -> 0x08
-		copySectorDataInImage(diskImage, track, 0x08, 0x07) // 0x08 -> 0x07
-		writeSectorDataFromBuffer(&sectorBuffer, diskImage, track, 0x08)
-	}
-}
-// Sector suffling section end
+// Multi-floppy spanning section begin
 
-// generateLineStartPad creates a block of space characters to be prepended to each line to be
-// sent over the serial connection. This pad is to allow for the loss of a variable number of
-// bytes which are lost during the processing of the previous line by the apple ][ monitor.
-// The pad is set into the string pointed to by lineStartPad.
-func generateLineStartPad(lineStartPad *string) {
-	const LINE_START_PAD_LENGTH = 16
-	*lineStartPad = strings.Repeat(" ", LINE_START_PAD_LENGTH)
+// splitDiskImageIntoFloppyImages writes the content of diskImage into a series of 140K chunk files,
+// as splitDiskImageStreamIntoFloppyImages does for an image read a track at a time.
+func splitDiskImageIntoFloppyImages(diskImage []byte, chunkFilepathPrefix string) error {
+	return splitDiskImageStreamIntoFloppyImages(bytes.NewReader(diskImage), chunkFilepathPrefix)
 }
 
-// generateMemoryAddress generates a hexadecimal formatted address for the apple ][ monitor.
-// The targetStartAddress parameter holds the input address, and the output is stored in the
-// string pointed to by memoryAddress.
-func generateMemoryAddress(memoryAddress *string, targetStartAddress int) {
-	if targetStartAddress < 0 {
-		*memoryAddress = fmt.Sprintf("")
-	} else {
-		*memoryAddress = fmt.Sprintf("%02X", targetStartAddress)
+// splitDiskImageStreamIntoFloppyImages writes the image read from data a track at a time into a
+// series of 140K chunk files named chunkFilepathPrefix_NN.PO, zero filling the end of the final chunk,
+// and writes a manifest named chunkFilepathPrefix.manifest listing the chunk files in order with their
+// first block and block count. Only the chunk being filled is held in memory. The manifest also
+// records the size of the original image so that joining can restore it exactly. The chunks are plain
+// runs of blocks, not ProDOS volumes of their own.
+func splitDiskImageStreamIntoFloppyImages(data io.Reader, chunkFilepathPrefix string) error {
+	const BLOCKS_PER_FLOPPY = diskimage.FLOPPY_IMAGE_SIZE / diskimage.PRODOS_BLOCK_SIZE
+	var manifest strings.Builder
+	var chunk []byte = make([]byte, 0, diskimage.FLOPPY_IMAGE_SIZE)
+	var chunkNum int = 1
+	var writeChunk func() error = func() error {
+		var firstBlock int = (chunkNum - 1) * BLOCKS_PER_FLOPPY
+		var chunkFilepath string = fmt.Sprintf("%s_%02d.PO", chunkFilepathPrefix, chunkNum)
+		// the final chunk is zero filled
+		chunk = append(chunk, make([]byte, diskimage.FLOPPY_IMAGE_SIZE-len(chunk))...)
+		var err error = ioutil.WriteFile(chunkFilepath, chunk, 0644)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(&manifest, "chunk %s %d %d\n", filepath.Base(chunkFilepath), firstBlock, BLOCKS_PER_FLOPPY)
+		fmt.Fprintf(os.Stderr, "wrote blocks %d through %d to file %s\n", firstBlock, firstBlock+BLOCKS_PER_FLOPPY-1, chunkFilepath)
+		chunkNum = chunkNum + 1
+		chunk = chunk[:0]
+		return nil
+	}
+	var byteCount int
+	var err error = streamDiskImageTracks(&byteCount, data, 0, func(track []byte) error {
+		// tracks divide a floppy image evenly, so a track never spans two chunks
+		chunk = append(chunk, track...)
+		if len(chunk) == diskimage.FLOPPY_IMAGE_SIZE {
+			return writeChunk()
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if len(chunk) > 0 {
+		err = writeChunk()
+		if err != nil {
+			return err
+		}
+	}
+	var manifestFilepath string = chunkFilepathPrefix + ".manifest"
+	err = ioutil.WriteFile(manifestFilepath, []byte(fmt.Sprintf("# apple2disk floppy span manifest\nsize %d\n%s", byteCount, manifest.String())), 0644)
+	if err != nil {
+		return err
 	}
+	fmt.Fprintf(os.Stderr, "wrote manifest of %d chunks to file %s\n", chunkNum-1, manifestFilepath)
+	return nil
 }
 
-// generateByteWriteGroupStringFromBytes takes the byteWriteGroup slice as input and stores
-// an appropriate string sequence of hexadecimal numbers for the apple ][ monitor, stored in
-// the string pointed to by byteWriteGroupString.
-func generateByteWriteGroupStringFromBytes(byteWriteGroupString *string, byteWriteGroup []byte) {
-	var sb strings.Builder
-	for i, b := range byteWriteGroup {
-		var s string
-		var err error
-		if (i == len(byteWriteGroup) - 1) {
-			s = fmt.Sprintf("%02X", b)
+// joinFloppyImagesIntoDiskImage reads the manifest written by splitDiskImageIntoFloppyImages and
+// fills the diskImage slice with the blocks of each listed chunk file, trimmed to the recorded size
+// of the original image. Chunk files are looked up relative to the directory of the manifest.
+func joinFloppyImagesIntoDiskImage(diskImage *[]byte, manifestFilepath string) error {
+	var manifest []byte
+	var err error
+	manifest, err = ioutil.ReadFile(manifestFilepath)
+	if err != nil {
+		return err
+	}
+	var imageSize int = -1
+	for lineNum, line := range strings.Split(string(manifest), "\n") {
+		var fields []string = strings.Fields(line)
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		if fields[0] == "size" && len(fields) == 2 {
+			imageSize, err = strconv.Atoi(fields[1])
+			if err != nil {
+				return failure.CodedErrorf(failure.KIND_BAD_MANIFEST, "manifest %s line %d: %w", manifestFilepath, lineNum+1, err)
+			}
+		} else if fields[0] == "chunk" && len(fields) == 4 {
+			var firstBlock, blockCount int
+			firstBlock, err = strconv.Atoi(fields[2])
+			if err == nil {
+				blockCount, err = strconv.Atoi(fields[3])
+			}
+			if err != nil {
+				return failure.CodedErrorf(failure.KIND_BAD_MANIFEST, "manifest %s line %d: %w", manifestFilepath, lineNum+1, err)
+			}
+			var chunk []byte
+			err = readDiskImageFromFile(&chunk, filepath.Join(filepath.Dir(manifestFilepath), fields[1]))
+			if err != nil {
+				return failure.CodedErrorf(failure.KIND_BAD_MANIFEST, "chunk file %s of manifest %s: %w", fields[1], manifestFilepath, err)
+			}
+			if len(chunk) < blockCount*diskimage.PRODOS_BLOCK_SIZE {
+				return failure.CodedErrorf(failure.KIND_BAD_CHUNK_FILE, "chunk file %s holds %d bytes, fewer than its %d blocks", fields[1], len(chunk), blockCount)
+			}
+			if len(*diskImage) != firstBlock*diskimage.PRODOS_BLOCK_SIZE {
+				return failure.CodedErrorf(failure.KIND_BAD_CHUNK_FILE, "chunk file %s starts at block %d, expected block %d", fields[1], firstBlock, len(*diskImage)/diskimage.PRODOS_BLOCK_SIZE)
+			}
+			*diskImage = append(*diskImage, chunk[:blockCount*diskimage.PRODOS_BLOCK_SIZE]...)
 		} else {
-			s = fmt.Sprintf("%02X ", b)
+			return failure.CodedErrorf(failure.KIND_BAD_MANIFEST, "unrecognized line %d in manifest %s: %s", lineNum+1, manifestFilepath, line)
 		}
-		_, err = sb.WriteString(s)
+	}
+	if imageSize < 0 || imageSize > len(*diskImage) {
+		return failure.CodedErrorf(failure.KIND_BAD_MANIFEST, "manifest %s does not account for the full image size", manifestFilepath)
+	}
+	*diskImage = (*diskImage)[:imageSize]
+	return nil
+}
+
+// Multi-floppy spanning section end
+
+// 2MG image section begin
+
+// A 2MG image starts with a 64 byte header: 2IMG, the creator, the header size, the version, the
+// image format (0 for DOS3.3 sector order, 1 for ProDOS sector order and 2 for a nibble image),
+// flags, the ProDOS block count, and the offset and length of the disk data, all little endian. The
+// flags hold a volume number in their low byte, which is only meaningful when bit 8 is set, and bit 31
+// is set when the image is locked.
+const TWO_IMG_HEADER_SIZE = 0x40
+const TWO_IMG_FORMAT_DOS33_ORDER = 0
+const TWO_IMG_FORMAT_PRODOS_ORDER = 1
+const TWO_IMG_FORMAT_NIBBLE = 2
+const TWO_IMG_FLAG_VOLUME_MASK = 0x000000FF
+const TWO_IMG_FLAG_VOLUME_SET = 0x00000100
+const TWO_IMG_FLAG_LOCKED = 0x80000000
+
+// readTwoImgFlags sets diskImageVolume and diskImageIsLocked from the flags of the 2MG image header,
+// reporting them to stderr.
+func readTwoImgFlags(header []byte) {
+	var flags uint32 = binary.LittleEndian.Uint32(header[0x10:0x14])
+	diskImageVolume = -1
+	if flags&TWO_IMG_FLAG_VOLUME_SET != 0 {
+		diskImageVolume = int(flags & TWO_IMG_FLAG_VOLUME_MASK)
+		fmt.Fprintf(os.Stderr, "2MG image is of volume %d\n", diskImageVolume)
+	}
+	diskImageIsLocked = flags&TWO_IMG_FLAG_LOCKED != 0
+	if diskImageIsLocked {
+		fmt.Fprintf(os.Stderr, "2MG image is locked\n")
+	}
+}
+
+// refuseLockedDiskImage returns an error when the header of the 2MG image last read, from the file
+// diskImageFilepath, marks it locked, for the operations which change an image, unless force is set.
+func refuseLockedDiskImage(diskImageFilepath string, force bool) error {
+	if diskImageIsLocked && !force {
+		return failure.CodedErrorf(failure.KIND_LOCKED_IMAGE, "%s is locked by its 2MG header", diskImageFilepath)
+	}
+	if diskImageIsLocked {
+		fmt.Fprintf(os.Stderr, "changing %s although it is locked by its 2MG header\n", diskImageFilepath)
+	}
+	return nil
+}
+
+// extractTwoImgData replaces the content of a 2MG image in diskImage with the disk data it holds, and
+// sets isDos33Order according to the image format in the header. Nibble data is decoded into DOS3.3
+// sector order. diskImageVolume and diskImageIsLocked are set from the flags in the header.
+func extractTwoImgData(isDos33Order *bool, diskImage *[]byte) error {
+	var header []byte = *diskImage
+	if len(header) < TWO_IMG_HEADER_SIZE || string(header[0:4]) != "2IMG" {
+		return failure.CodedErrorf(failure.KIND_2MG, "not a 2MG image")
+	}
+	var format uint32 = binary.LittleEndian.Uint32(header[0x0C:0x10])
+	var dataOffset int = int(binary.LittleEndian.Uint32(header[0x18:0x1C]))
+	var dataLength int = int(binary.LittleEndian.Uint32(header[0x1C:0x20]))
+	if format == TWO_IMG_FORMAT_PRODOS_ORDER && dataLength == 0 {
+		// some images only give the count of ProDOS blocks
+		dataLength = int(binary.LittleEndian.Uint32(header[0x14:0x18])) * diskimage.PRODOS_BLOCK_SIZE
+	}
+	if dataOffset < TWO_IMG_HEADER_SIZE || dataOffset > len(header) || dataLength > len(header)-dataOffset {
+		return failure.CodedErrorf(failure.KIND_2MG, "2MG image data at offset %d of %d bytes is outside the file of %d bytes", dataOffset, dataLength, len(header))
+	}
+	readTwoImgFlags(header)
+	*diskImage = header[dataOffset : dataOffset+dataLength]
+	if format == TWO_IMG_FORMAT_DOS33_ORDER {
+		*isDos33Order = true
+		fmt.Fprintf(os.Stderr, "2MG image holds %d bytes in DOS3.3 sector order\n", dataLength)
+	} else if format == TWO_IMG_FORMAT_PRODOS_ORDER {
+		*isDos33Order = false
+		fmt.Fprintf(os.Stderr, "2MG image holds %d bytes in ProDOS sector order\n", dataLength)
+	} else if format == TWO_IMG_FORMAT_NIBBLE {
+		var err error = gcr.ConvertNibbleImageToDos33Order(diskImage, skipBadSectors)
 		if err != nil {
-			panic(err)
+			return failure.CodedErrorf(failure.KIND_2MG, "2MG nibble data: %w", err)
 		}
+		*isDos33Order = true
+	} else {
+		return failure.CodedErrorf(failure.KIND_2MG, "2MG image format %d is not supported", format)
 	}
-	*byteWriteGroupString = sb.String()
+	return nil
 }
 
-// writeCommandsToFillAppleMemorySegment outputs a carriage return terminated line of text which
-// is a command to the apple ][ monitor which fills a block of memory starting at address
-// targetStartAddress, with bytes from the sourceBytes slice starting at position sourceBytesStartPos
-// and including the number of bytes specified in writeByteCount. Each line is prepended with lineStartPad.
-func writeCommandsToFillAppleMemorySegment(sourceBytes []byte, lineStartPad string, targetStartAddress int, sourceBytesStartPos int, writeByteCount int) {
-	var memoryAddress string
-	generateMemoryAddress(&memoryAddress, targetStartAddress)
-	var byteWriteGroupString string
-	var sourceBytesEndPos int = sourceBytesStartPos + writeByteCount
-	if sourceBytesEndPos > len(sourceBytes) {
-		// make sure we don't run off the end of sourceBytes
-		sourceBytesEndPos = len(sourceBytes)
+// 2MG image section end
+
+// Image format detection section begin
+
+// The disk image formats told apart by detectDiskImageFormat.
+const IMAGE_FORMAT_PRODOS_ORDER = "ProDOS sector order"
+const IMAGE_FORMAT_DOS33_ORDER = "DOS3.3 sector order"
+const IMAGE_FORMAT_NIBBLE = "nibble"
+const IMAGE_FORMAT_WOZ = "WOZ"
+const IMAGE_FORMAT_2MG = "2MG"
+const IMAGE_FORMAT_13_SECTOR = "13-sector"
+
+// diskImageGeometry is a disk whose images hold byteCount bytes, named as in the messages.
+type diskImageGeometry struct {
+	name      string
+	byteCount int
+}
+
+// DISK_IMAGE_GEOMETRIES lists the disks whose images are recognized by their size: 5.25" floppies in
+// sectors (16 or 13 per track) and in nibbles, 3.5" disks, and the largest ProDOS volume.
+var DISK_IMAGE_GEOMETRIES []diskImageGeometry = []diskImageGeometry{
+	{"140K", diskimage.FLOPPY_IMAGE_SIZE},
+	{"13-sector", gcr.D13_IMAGE_SIZE},
+	{"nibble", gcr.NIB_IMAGE_SIZE},
+	{"400K", 400 * 1024},
+	{"800K", 800 * 1024},
+	{"32MB", 0xFFFF * diskimage.PRODOS_BLOCK_SIZE}}
+
+// GEOMETRY_OF_EXTENSION names the geometry the images with each extension must have. Images with
+// other extensions hold any whole number of ProDOS blocks.
+var GEOMETRY_OF_EXTENSION map[string]string = map[string]string{".do": "140K", ".dsk": "140K", ".d13": "13-sector", ".nib": "nibble"}
+
+// formatThousands returns n in decimal with commas between each group of three digits.
+func formatThousands(n int) string {
+	var digits string = strconv.Itoa(n)
+	var formatted string = ""
+	for len(digits) > 3 {
+		formatted = "," + digits[len(digits)-3:] + formatted
+		digits = digits[:len(digits)-3]
 	}
-	var byteWriteGroup []byte = sourceBytes[sourceBytesStartPos : sourceBytesEndPos]
-	generateByteWriteGroupStringFromBytes(&byteWriteGroupString, byteWriteGroup)
-	fmt.Printf("%s%s:%s\r", lineStartPad, memoryAddress, byteWriteGroupString)
+	return digits + formatted
 }
 
-// writeCommandsToLoadDiskTrackToMemory outputs a sequence of commands to the apple ][ monitor which
-// fill the 2KB of memory between address 0x1000 and memory address 0x1FFF with 16 sectors worth of
-// data for transfer to the apple II disk. The 16 sectors correspond to 1 complete track from the
-// diskImage slice corresponding to the trackNum input. Each command line fills SEGMENT_SIZE count
-// of bytes. SEGMENT_SIZE was determened through trial and error to be best set at 8 ... when sending
-// commands over a serial connection at 2400 baud with data format including 7 data bytes and 1 stop
-// byte. The 16 space pad at the start of each line was adequate to avoid data loss during processing
-// of each line, however, in order to sychronize timing of the loss of line start padding, commands
-// of gradually increasing SEGMENT_SIZE was needed. So at the beginning of the transfer of a track,
-// the first segment transfer command is repeated with byte count starting at 0 and ending at 8. This
-// led to losing 12 or 13 characters from the 16 space pad regularly when executing each command.
-// Use of hardware flow control might avoid the need for this pad.
-func writeCommandsToLoadDiskTrackToMemory(diskImage []byte, trackNum int, SEGMENT_SIZE int) {
-	if trackNum < 0x0 || trackNum > 0x22 {
-		panic(fmt.Sprintf("illegal track number encountered: %d\n", trackNum))
+// describeImageSize returns the size of an image of byteCount bytes against geometry, such as
+// "143,358 bytes, 2 short of a 140K image".
+func describeImageSize(byteCount int, geometry diskImageGeometry) string {
+	var article string = "a"
+	if strings.HasPrefix(geometry.name, "8") {
+		article = "an"
 	}
-	var diskImageWriteByteCount int = 0x1000
-	var sourceBytesStartPos int = diskImageStartPosOfTrackSector(trackNum, 0x00)
-	var lineStartPad string
-	generateLineStartPad(&lineStartPad)
-	var bytesWritten int = 0
-	var targetStartAddress = 0x2000
-	var firstCommand bool = true
-	for bytesWritten < diskImageWriteByteCount {
-		if firstCommand {
-			// ramp up data stream by doing access and extra dumplicated short writes .. to get the "rhythm" going
-			writeCommandsToFillAppleMemorySegment(diskImage, lineStartPad, targetStartAddress, sourceBytesStartPos, SEGMENT_SIZE - 8)
-			writeCommandsToFillAppleMemorySegment(diskImage, lineStartPad, targetStartAddress, sourceBytesStartPos, SEGMENT_SIZE - 7)
-			writeCommandsToFillAppleMemorySegment(diskImage, lineStartPad, targetStartAddress, sourceBytesStartPos, SEGMENT_SIZE - 6)
-			writeCommandsToFillAppleMemorySegment(diskImage, lineStartPad, targetStartAddress, sourceBytesStartPos, SEGMENT_SIZE - 5)
-			writeCommandsToFillAppleMemorySegment(diskImage, lineStartPad, targetStartAddress, sourceBytesStartPos, SEGMENT_SIZE - 4)
-			writeCommandsToFillAppleMemorySegment(diskImage, lineStartPad, targetStartAddress, sourceBytesStartPos, SEGMENT_SIZE - 3)
-			writeCommandsToFillAppleMemorySegment(diskImage, lineStartPad, targetStartAddress, sourceBytesStartPos, SEGMENT_SIZE - 2)
-			writeCommandsToFillAppleMemorySegment(diskImage, lineStartPad, targetStartAddress, sourceBytesStartPos, SEGMENT_SIZE - 1)
-			writeCommandsToFillAppleMemorySegment(diskImage, lineStartPad, targetStartAddress, sourceBytesStartPos, SEGMENT_SIZE)
-			firstCommand = false
-		}
-		writeCommandsToFillAppleMemorySegment(diskImage, lineStartPad, targetStartAddress, sourceBytesStartPos, SEGMENT_SIZE)
-		targetStartAddress = targetStartAddress + SEGMENT_SIZE
-		bytesWritten = bytesWritten + SEGMENT_SIZE
-		sourceBytesStartPos = sourceBytesStartPos + SEGMENT_SIZE
+	if byteCount < geometry.byteCount {
+		return fmt.Sprintf("%s bytes, %s short of %s %s image", formatThousands(byteCount), formatThousands(geometry.byteCount-byteCount), article, geometry.name)
 	}
+	return fmt.Sprintf("%s bytes, %s bytes over %s %s image", formatThousands(byteCount), formatThousands(byteCount-geometry.byteCount), article, geometry.name)
 }
 
-// writeCommandsToLoadRWTSClientProgramToMemory outputs a series of memory transfer commands to the
-// apple ][ monitor which loads into memory and then executes a machine language program which calls
-// the RWTS routine (already assumed to be loaded into memory and referenced indirectly by a vector
-// at location 0x0D39) 16 times to write the 16 sectors worth of data which has been loaded into the
-// memory range 0x1000 through 0x1FFF into sectors 0x00 through 0x0F of the apple II disk track which
-// is input in parameter trackNum. The machine langague routine is transferred in commands which load
-// segements of SEGMENT_SIZE, similar to the loading of the Disk Track buffer.
-func writeCommandsToLoadRWTSClientProgramToMemory(trackNum int, SEGMENT_SIZE int) {
-	var trackNumArray []byte = []byte{
-			'\x00', '\x01', '\x02', '\x03', '\x04', '\x05', '\x06', '\x07', '\x08', '\x09', '\x0A', '\x0B', '\x0C', '\x0D', '\x0E', '\x0F',
-			'\x10', '\x11', '\x12', '\x13', '\x14', '\x15', '\x16', '\x17', '\x18', '\x19', '\x1A', '\x1B', '\x1C', '\x1D', '\x1E', '\x1F',
-			'\x20', '\x21', '\x22' }
-	var trackNumByte = trackNumArray[trackNum]
-	var clientProgram []byte = []byte{
-			'\xA9', '\x0C', // load address of IOB for RWTS into A/Y
-			'\xA0', '\x1C',
-			'\x20', '\xD9', '\x03', // call RWTS
-			'\xB0', '\x12', // break on error
-			'\xA9', '\x0F', // we are done after writing final sector
-			'\xCD', '\x21', '\x0C',
-			'\xF0', '\x0A', //skip next iteration when done
-			'\xEE', '\x21', '\x0C', // modify IOB : advance to write next sector (sector is in '\x0C21')
-			'\xEE', '\x25', '\x0C', // modify IOB : advance to next memory page (buffer is in '\x0C25')
-			'\xF0', '\xE8', //iterate
-			'\xD0', '\xE6', //iterate
-			'\x60', // return from client
-			'\x00', // break
-			'\x01', '\x60', '\x01', '\x00', trackNumByte, '\x00', // slot / drive / vol / track / sector
-			'\x30', '\x0C', // DCT address is '\x0C2F
-			'\x00', '\x20', // data buffer address (starts at 0x2000)
-			'\x00', '\x00', '\x02', // write
-			'\x00', '\x00', '\x60', '\x01', // actual volumne / previous slot / drive
-			'\x00', '\x00', '\x00', // not used
-			'\x00', '\x01', '\xEF', '\xD8' } // DCT table (constant)
-	var clientWriteByteCount int = len(clientProgram)
-	var sourceBytesStartPos int = 0
-	var lineStartPad string
-	generateLineStartPad(&lineStartPad)
-	var bytesWritten int = 0
-	var targetStartAddress = 0x0C00
-	for bytesWritten < clientWriteByteCount {
-		writeCommandsToFillAppleMemorySegment(clientProgram, lineStartPad, targetStartAddress, sourceBytesStartPos, SEGMENT_SIZE)
-		targetStartAddress = targetStartAddress + SEGMENT_SIZE
-		bytesWritten = bytesWritten + SEGMENT_SIZE
-		sourceBytesStartPos = sourceBytesStartPos + SEGMENT_SIZE
+// imageSizeErrorKind returns the kind of the failure for an image of byteCount bytes instead of the
+// size of geometry: truncated when it is shorter, and of an unrecognized format when it is longer.
+func imageSizeErrorKind(byteCount int, geometry diskImageGeometry) failure.ErrorKind {
+	if byteCount < geometry.byteCount {
+		return failure.KIND_TRUNCATED_IMAGE
 	}
+	return failure.KIND_OVERSIZED_IMAGE
 }
 
-// executeClient outputs a command which executes the machine language program and
-// reports the written track to stderr.
-func executeClient(trackNum int) {
-	fmt.Fprintf(os.Stderr, "executing binary client program to write track %d\n", trackNum)
-	var lineStartPad string
-	generateLineStartPad(&lineStartPad)
-	fmt.Printf("%sC00G\r", lineStartPad)
+// validateDiskImageSize checks the length of diskImage, read from the file (or URL path) fileName,
+// before any work is done on it. Images with a WOZ or 2MG signature are checked as they are read.
+// Images whose extension is listed in GEOMETRY_OF_EXTENSION must have that size, and the others must
+// hold a whole number of ProDOS blocks; otherwise the error returned gives the size against the
+// nearest geometry.
+func validateDiskImageSize(diskImage []byte, diskImageFilepath string, fileName string) error {
+	if len(diskImage) >= 4 && (string(diskImage[0:4]) == "WOZ1" || string(diskImage[0:4]) == "WOZ2" || string(diskImage[0:4]) == "2IMG") {
+		return nil
+	}
+	return validateDiskImageByteCount(len(diskImage), diskImageFilepath, fileName)
 }
 
-// floppy_disk_image_file_to_serial_install main routine parses the desired track number and the
-// disk image filepath from command line arguments. It outputs the full series of apple ][ monitor
-// commands to load the apple ][ memory buffer with data for the requested track, and to load and
-// execute the machine language routine which will write the data to the apple II Disk track via
-// the Dos3.3 RWTS subroutine. Note that before transfer, the sector order is reordered for proper
-// ProDOS block access during disk use.
-func main() {
-	const SEGMENT_SIZE = 8
-	var diskImageFilepath string = os.Args[1]
-	var trackNumString string = os.Args[2]
-	var trackNumInt int
-	trackNumInt, err := strconv.Atoi(trackNumString)
-	if err != nil {
-		panic(err)
+// validateDiskImageByteCount checks byteCount, the size of an image without a WOZ or 2MG signature
+// read from the file (or URL path) fileName, as validateDiskImageSize does.
+func validateDiskImageByteCount(byteCount int, diskImageFilepath string, fileName string) error {
+	if byteCount == 0 {
+		return failure.CodedErrorf(failure.KIND_EMPTY_IMAGE, "%s is empty", diskImageFilepath)
 	}
-	var diskImage []byte
-	readDiskImageFromFile(&diskImage, diskImageFilepath)
-	convertDiskImageFromProdosOrderToDos33Order(diskImage)
-	writeCommandsToLoadDiskTrackToMemory(diskImage, trackNumInt, SEGMENT_SIZE)
-	writeCommandsToLoadRWTSClientProgramToMemory(trackNumInt, SEGMENT_SIZE)
-	executeClient(trackNumInt)
+	var extension string = filepath.Ext(strings.TrimSuffix(strings.ToLower(fileName), ".gz"))
+	var geometryName string = GEOMETRY_OF_EXTENSION[extension]
+	var nearestGeometry diskImageGeometry = DISK_IMAGE_GEOMETRIES[0]
+	for _, geometry := range DISK_IMAGE_GEOMETRIES {
+		if geometry.name == geometryName {
+			if byteCount != geometry.byteCount {
+				return failure.CodedErrorf(imageSizeErrorKind(byteCount, geometry), "%s is %s", diskImageFilepath, describeImageSize(byteCount, geometry))
+			}
+			return nil
+		}
+		var distance int = byteCount - geometry.byteCount
+		var nearestDistance int = byteCount - nearestGeometry.byteCount
+		if distance*distance < nearestDistance*nearestDistance {
+			nearestGeometry = geometry
+		}
+	}
+	if byteCount%diskimage.PRODOS_BLOCK_SIZE != 0 && byteCount != gcr.NIB_IMAGE_SIZE && byteCount != gcr.D13_IMAGE_SIZE {
+		return failure.CodedErrorf(imageSizeErrorKind(byteCount, nearestGeometry), "%s is %s", diskImageFilepath, describeImageSize(byteCount, nearestGeometry))
+	}
+	return nil
+}
+
+// checkFloppyImageSize returns an error when diskImage, read from diskImageFilepath, is too small to
+// hold the 35 tracks of a 140K floppy image.
+func checkFloppyImageSize(diskImage []byte, diskImageFilepath string) error {
+	if len(diskImage) < diskimage.FLOPPY_IMAGE_SIZE {
+		return failure.CodedErrorf(failure.KIND_NOT_FLOPPY_INSTALL, "%s is %s, and tracks are installed from 140K floppy images", diskImageFilepath, describeImageSize(len(diskImage), DISK_IMAGE_GEOMETRIES[0]))
+	}
+	return nil
+}
+
+// holdsProdosVolumeDirectory returns true when block 2 of diskImage (taken in ProDOS sector order)
+// holds a volume directory key block: no previous block, a volume directory header, and the usual
+// entry length and count of entries per block.
+func holdsProdosVolumeDirectory(diskImage []byte) bool {
+	var blockPos int = 2 * diskimage.PRODOS_BLOCK_SIZE
+	if blockPos+diskimage.PRODOS_BLOCK_SIZE > len(diskImage) {
+		return false
+	}
+	var block []byte = diskImage[blockPos : blockPos+diskimage.PRODOS_BLOCK_SIZE]
+	return block[0x00] == 0x00 && block[0x01] == 0x00 && block[0x04]>>4 == 0x0F && block[0x04]&0x0F != 0 && block[0x23] == 0x27 && block[0x24] == 0x0D
+}
+
+// countDos33CatalogSectors returns the count of catalog sectors found by following the catalog links
+// from the VTOC of diskImage (taken in DOS3.3 sector order), or 0 when there is no DOS 3.3 VTOC. A
+// link to a sector which does not continue the catalog ends the count.
+func countDos33CatalogSectors(diskImage []byte) int {
+	var vtoc []byte = diskimage.Dos33SectorOfImage(diskImage, 0x11, 0x00)
+	if vtoc[0x03] != 0x03 || vtoc[0x27] != 0x7A || vtoc[0x34] != 0x23 || vtoc[0x35] != 0x10 {
+		return 0
+	}
+	var catalogTrack int = int(vtoc[0x01])
+	var catalogSector int = int(vtoc[0x02])
+	var count int = 0
+	for catalogTrack > 0x00 && catalogTrack < 0x23 && catalogSector < 0x10 && count < 0x10 {
+		var catalogSectorData []byte = diskimage.Dos33SectorOfImage(diskImage, catalogTrack, catalogSector)
+		count = count + 1
+		catalogTrack = int(catalogSectorData[0x01])
+		catalogSector = int(catalogSectorData[0x02])
+	}
+	return count
+}
+
+// detectDiskImageFormat stores into format the format of the disk image file fileName holding
+// diskImage, and into reason what it was told by. WOZ and 2MG images are known by the signature they
+// start with, and nibble images by their size. For 140K images the sector order is found from their
+// content: a ProDOS volume directory or a DOS 3.3 catalog is looked for in either order. When the
+// content does not tell, the .do extension means DOS3.3 sector order and any other ProDOS order.
+// dos33OrderGiven (the -dos-order option) decides the order of 140K images without looking further.
+func detectDiskImageFormat(format *string, reason *string, diskImage []byte, fileName string, dos33OrderGiven bool) error {
+	var extension string = filepath.Ext(strings.TrimSuffix(strings.ToLower(fileName), ".gz"))
+	if len(diskImage) >= gcr.WOZ_HEADER_SIZE && (string(diskImage[0:4]) == "WOZ1" || string(diskImage[0:4]) == "WOZ2") {
+		*format = IMAGE_FORMAT_WOZ
+		*reason = fmt.Sprintf("%s signature", diskImage[0:4])
+		return nil
+	}
+	if len(diskImage) >= TWO_IMG_HEADER_SIZE && string(diskImage[0:4]) == "2IMG" {
+		*format = IMAGE_FORMAT_2MG
+		*reason = "2IMG signature"
+		return nil
+	}
+	if len(diskImage) == gcr.D13_IMAGE_SIZE || extension == ".d13" {
+		*format = IMAGE_FORMAT_13_SECTOR
+		*reason = fmt.Sprintf("%d byte image", len(diskImage))
+		if extension == ".d13" {
+			*reason = "extension .d13"
+		}
+		return nil
+	}
+	if len(diskImage) == gcr.NIB_IMAGE_SIZE || extension == ".nib" {
+		*format = IMAGE_FORMAT_NIBBLE
+		*reason = fmt.Sprintf("%d byte image", len(diskImage))
+		if extension == ".nib" {
+			*reason = "extension .nib"
+		}
+		return nil
+	}
+	if len(diskImage) != diskimage.FLOPPY_IMAGE_SIZE {
+		*format = IMAGE_FORMAT_PRODOS_ORDER
+		*reason = fmt.Sprintf("%d byte block image", len(diskImage))
+		return nil
+	}
+	if dos33OrderGiven {
+		*format = IMAGE_FORMAT_DOS33_ORDER
+		*reason = "-dos-order"
+		return nil
+	}
+	// the sector shuffle swaps pairs of sectors, so the shuffled copy is the image taken in the other order
+	var shuffledImage []byte
+	var err error = diskimage.ReorderedDiskImageSectors(&shuffledImage, diskImage, diskimage.SECTOR_ORDER_PRODOS, diskimage.SECTOR_ORDER_DOS)
+	if err != nil {
+		return err
+	}
+	if holdsProdosVolumeDirectory(diskImage) != holdsProdosVolumeDirectory(shuffledImage) {
+		*format = IMAGE_FORMAT_PRODOS_ORDER
+		if holdsProdosVolumeDirectory(shuffledImage) {
+			*format = IMAGE_FORMAT_DOS33_ORDER
+		}
+		*reason = "ProDOS volume directory found in this order"
+		return nil
+	}
+	var dos33OrderCount int = countDos33CatalogSectors(diskImage)
+	var prodosOrderCount int = countDos33CatalogSectors(shuffledImage)
+	if dos33OrderCount != prodosOrderCount {
+		*format = IMAGE_FORMAT_DOS33_ORDER
+		if prodosOrderCount > dos33OrderCount {
+			*format = IMAGE_FORMAT_PRODOS_ORDER
+		}
+		*reason = "DOS 3.3 catalog found in this order"
+		return nil
+	}
+	*format = IMAGE_FORMAT_PRODOS_ORDER
+	*reason = "content does not tell, assumed from extension " + extension
+	if extension == ".do" {
+		*format = IMAGE_FORMAT_DOS33_ORDER
+	} else if extension != ".po" {
+		*reason = "content does not tell, assumed"
+	}
+	return nil
+}
+
+// Image format detection section end
+
+// Image conversion section begin
+
+// ORDER_OF_EXTENSION maps the file name extensions which tell the sector order of a 140K image to the
+// name of that order (see SECTOR_INTERLEAVES).
+// A .nib file is written as a nibble image instead, which the nibble order names, and a .d13 file as a
+// 13-sector image, which the 13-sector order names.
+var ORDER_OF_EXTENSION map[string]string = map[string]string{".po": "prodos", ".do": "dos", ".dsk": "dos", ".nib": "nibble", ".d13": "13-sector"}
+
+// convertDiskImageFile reads the 140K disk image file inputFilepath, in whatever format and sector
+// order it is, and writes its sectors to the file outputFilepath in the sector order named
+// outputOrder. When outputOrder is empty, the order is taken from the extension of outputFilepath. A
+// 13-sector image (or nibble image of a 13-sector disk) is written only as a 13-sector image or as a
+// nibble image, 5-and-3 encoded, as its sectors do not fit a 16 sector disk.
+func convertDiskImageFile(inputFilepath string, outputFilepath string, outputOrder string) error {
+	if outputOrder == "" {
+		var found bool
+		outputOrder, found = ORDER_OF_EXTENSION[strings.ToLower(filepath.Ext(outputFilepath))]
+		if !found {
+			return failure.CodedErrorf(failure.KIND_OPTION_CONFLICT, "the sector order of %s needs convert -order, as its name does not tell", outputFilepath)
+		}
+	}
+	var diskImage []byte
+	var err error = readDiskImageFromFile(&diskImage, inputFilepath)
+	if err != nil {
+		return err
+	}
+	if diskImageIs13Sector {
+		if outputOrder == "nibble" {
+			gcr.Convert13SectorImageToNibbleImage(&diskImage)
+		} else if outputOrder != "13-sector" {
+			return failure.CodedErrorf(failure.KIND_13_SECTOR, "%s is a 13-sector image, which converts only to 13-sector or nibble order", inputFilepath)
+		}
+		err = writeDiskImageWithJournal(diskImage, outputFilepath, "convert")
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "wrote %d bytes in %s order to file %s\n", len(diskImage), outputOrder, outputFilepath)
+		return nil
+	}
+	if outputOrder == "13-sector" {
+		return failure.CodedErrorf(failure.KIND_13_SECTOR, "%s is not a 13-sector image, so it cannot be written in 13-sector order", inputFilepath)
+	}
+	if len(diskImage) != diskimage.FLOPPY_IMAGE_SIZE {
+		return failure.CodedErrorf(failure.KIND_NOT_FLOPPY, "%s is not a 140K floppy image", inputFilepath)
+	}
+	if outputOrder == "nibble" {
+		err = diskimage.ConvertDiskImageFromProdosOrderToDos33Order(diskImage)
+		if err != nil {
+			return err
+		}
+		err = gcr.ConvertDos33OrderImageToNibbleImage(&diskImage)
+		if err != nil {
+			return err
+		}
+	} else {
+		err = diskimage.ReorderDiskImageSectors(diskImage, diskimage.SECTOR_ORDER_PRODOS, diskimage.SectorOrder(outputOrder))
+		if err != nil {
+			return err
+		}
+	}
+	err = writeDiskImageWithJournal(diskImage, outputFilepath, "convert")
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "wrote %d bytes in %s sector order to file %s\n", len(diskImage), outputOrder, outputFilepath)
+	return nil
+}
+
+// Image conversion section end
+
+// Transfer timing section begin
+
+// commandStreamWriter writes the command stream to stdout, counting the characters written so that
+// the time needed to transmit them can be reported. Spaces at the start of a line are counted as
+// padding, and characters written while rampingUp is set are counted as ramp-up. When highBit is set
+// the high bit of each character is set as it is written, and when dataBits is 7 every character
+// written must fit in 7 bits. Each carriage return ending a command line is written as lineEnding.
+// The first write error is kept in err, and every later write returns it without writing, so that the
+// commands written with fmt.Fprintf need only be checked for it at the end of each track.
+type commandStreamWriter struct {
+	output          io.Writer
+	dataBits        int
+	highBit         bool
+	lineEnding      byte
+	charCount       int
+	lineCount       int
+	padCharCount    int
+	rampUpCharCount int
+	rampingUp       bool
+	atLineStart     bool
+	keepaliveLength int
+	startTime       time.Time
+	err             error
+}
+
+// commandOutput receives all of the apple ][ monitor commands generated by this program.
+var commandOutput commandStreamWriter = commandStreamWriter{output: os.Stdout, lineEnding: '\r', atLineStart: true}
+
+// Write implements io.Writer, writing p to the output (stdout unless changed) and updating the
+// character counts. A "line_sent" progress event is emitted for each line once it has been written.
+// A failed write, such as when the serial link drops, is kept in err and returned by every later write.
+func (w *commandStreamWriter) Write(p []byte) (int, error) {
+	if w.err != nil {
+		return 0, w.err
+	}
+	if w.charCount == 0 {
+		w.startTime = time.Now()
+	}
+	if w.highBit {
+		var highBitChars []byte = make([]byte, len(p))
+		for i, b := range p {
+			highBitChars[i] = b | 0x80
+		}
+		p = highBitChars
+	}
+	if w.dataBits == 7 {
+		for _, b := range p {
+			if b >= 0x80 {
+				w.err = failure.CodedErrorf(failure.KIND_7_DATA_BITS, "character 0x%02X cannot be sent with 7 data bits", b)
+				return 0, w.err
+			}
+		}
+	}
+	var sent []byte = p
+	if w.lineEnding != '\r' {
+		sent = make([]byte, len(p))
+		for i, b := range p {
+			sent[i] = b
+			if b&0x7F == '\r' {
+				sent[i] = b&0x80 | w.lineEnding
+			}
+		}
+	}
+	n, err := w.output.Write(sent)
+	if err != nil {
+		w.err = failure.CodedErrorf(failure.KIND_WRITE_FAILED, "writing the commands failed after %d lines: %w", w.lineCount, err)
+		err = w.err
+	}
+	for _, b := range p[:n] {
+		b = b & 0x7F
+		w.charCount = w.charCount + 1
+		if w.rampingUp {
+			w.rampUpCharCount = w.rampUpCharCount + 1
+		}
+		if b == '\r' {
+			w.atLineStart = true
+			w.lineCount = w.lineCount + 1
+			emitProgressEvent("line_sent", w.lineCount, w.charCount)
+		} else if b != ' ' {
+			w.atLineStart = false
+		} else if w.atLineStart {
+			w.padCharCount = w.padCharCount + 1
+		}
+	}
+	return n, err
+}
+
+// writeKeepalive writes, at the start of a line, a space to the output which is left out of the
+// character counts, to keep the serial line busy while the transfer waits. Through a trackPipeline the
+// space is only written when the line is idle, with every track handed over already sent. The spaces
+// written make up a line of at most MAX_KEEPALIVE_LINE_LENGTH spaces, short of the monitor input line
+// limit, ended by endKeepalive.
+func (w *commandStreamWriter) writeKeepalive() {
+	const MAX_KEEPALIVE_LINE_LENGTH = 240
+	if w.err != nil || !w.atLineStart {
+		return
+	}
+	if w.keepaliveLength == MAX_KEEPALIVE_LINE_LENGTH {
+		w.endKeepalive()
+	}
+	var space byte = ' '
+	if w.highBit {
+		space = space | 0x80
+	}
+	var pipeline *trackPipeline
+	pipeline, ok := w.output.(*trackPipeline)
+	if ok {
+		if pipeline.writeIdle([]byte{space}) {
+			w.keepaliveLength = w.keepaliveLength + 1
+		}
+		return
+	}
+	_, err := w.output.Write([]byte{space})
+	if err != nil {
+		w.err = failure.CodedErrorf(failure.KIND_WRITE_FAILED, "writing a keepalive failed after %d lines: %w", w.lineCount, err)
+		return
+	}
+	w.keepaliveLength = w.keepaliveLength + 1
+}
+
+// endKeepalive ends the line of the spaces written by writeKeepalive, if any, so that the next command
+// line starts on a line of its own.
+func (w *commandStreamWriter) endKeepalive() {
+	if w.err != nil || w.keepaliveLength == 0 {
+		return
+	}
+	var lineEnding byte = w.lineEnding
+	if w.highBit {
+		lineEnding = lineEnding | 0x80
+	}
+	w.keepaliveLength = 0
+	var pipeline *trackPipeline
+	pipeline, ok := w.output.(*trackPipeline)
+	if ok {
+		// the spaces were handed over on their own, so the line ending goes right after them
+		pipeline.tracks <- []byte{lineEnding}
+		return
+	}
+	_, err := w.output.Write([]byte{lineEnding})
+	if err != nil {
+		w.err = failure.CodedErrorf(failure.KIND_WRITE_FAILED, "writing a keepalive failed after %d lines: %w", w.lineCount, err)
+	}
+}
+
+// writeBinary writes the bytes p to the output as they are, for a receiver program reading them from
+// the serial card, and updates the character count. The next line is taken to start afterwards. A
+// failed write is kept in err as for Write.
+func (w *commandStreamWriter) writeBinary(p []byte) {
+	if w.err != nil {
+		return
+	}
+	if w.charCount == 0 {
+		w.startTime = time.Now()
+	}
+	n, err := w.output.Write(p)
+	if err != nil {
+		w.err = failure.CodedErrorf(failure.KIND_WRITE_FAILED, "writing the commands failed after %d lines: %w", w.lineCount, err)
+	}
+	w.charCount = w.charCount + n
+	w.atLineStart = true
+}
+
+// parseLineFormat stores into bitsPerChar the number of bits sent on a serial line at baud bits per
+// second for each character with the framing described by framing, such as "7N2" for 7 data bits, no
+// parity and 2 stop bits. The start bit is included. It returns an error when baud is not positive or
+// framing is not of that form.
+func parseLineFormat(bitsPerChar *int, baud int, framing string) error {
+	if baud <= 0 {
+		return failure.CodedErrorf(failure.KIND_ILLEGAL_BAUD, "illegal baud rate encountered: %d", baud)
+	}
+	if len(framing) != 3 || strings.IndexByte("78", framing[0]) < 0 || strings.IndexByte("NEO", framing[1]) < 0 || strings.IndexByte("12", framing[2]) < 0 {
+		return failure.CodedErrorf(failure.KIND_ILLEGAL_FRAMING, "framing must be data bits (7 or 8), parity (N, E or O) and stop bits (1 or 2), like 7N2, not %s", framing)
+	}
+	*bitsPerChar = 1 + int(framing[0]-'0') + int(framing[2]-'0')
+	if framing[1] != 'N' {
+		*bitsPerChar = *bitsPerChar + 1
+	}
+	return nil
+}
+
+// transmissionSeconds returns the time in seconds needed to send charCount characters at baud bits
+// per second with bitsPerChar bits per character.
+func transmissionSeconds(charCount int, baud int, bitsPerChar int) float64 {
+	return float64(charCount*bitsPerChar) / float64(baud)
+}
+
+// reportTransferTiming writes to stderr the theoretical minimum time needed to transmit the command
+// stream written so far for subject (such as "track 5") at the given baud rate and framing, with the
+// part of it spent on line start padding and ramp-up, and the part that the payloadByteCount
+// transferred bytes alone would need (2 hexadecimal digits and a separator each). This is compared
+// against the measured wall time spent writing the stream to stdout, which reflects the actual
+// transfer only when stdout is the serial device itself (writes then block at the line rate). With
+// -dry-run nothing is written, so there is no measured time to compare. Nothing is reported for a
+// line format parseLineFormat does not accept, which main has checked already.
+func reportTransferTiming(subject string, payloadByteCount int, baud int, framing string) {
+	var bitsPerChar int
+	if parseLineFormat(&bitsPerChar, baud, framing) != nil {
+		return
+	}
+	var measuredSeconds float64 = time.Since(commandOutput.startTime).Seconds()
+	var theoreticalSeconds float64 = transmissionSeconds(commandOutput.charCount, baud, bitsPerChar)
+	fmt.Fprintf(os.Stderr, "%s: %d characters at %d baud %s (%d bits per character)\n", subject, commandOutput.charCount, baud, framing, bitsPerChar)
+	fmt.Fprintf(os.Stderr, "  theoretical minimum %.1f s\n", theoreticalSeconds)
+	fmt.Fprintf(os.Stderr, "    line start padding %d characters, %.1f s\n", commandOutput.padCharCount, transmissionSeconds(commandOutput.padCharCount, baud, bitsPerChar))
+	fmt.Fprintf(os.Stderr, "    ramp-up %d characters, %.1f s\n", commandOutput.rampUpCharCount, transmissionSeconds(commandOutput.rampUpCharCount, baud, bitsPerChar))
+	fmt.Fprintf(os.Stderr, "    %d bytes of data alone %d characters, %.1f s\n", payloadByteCount, 3*payloadByteCount, transmissionSeconds(3*payloadByteCount, baud, bitsPerChar))
+	if commandOutput.output == ioutil.Discard {
+		fmt.Fprintf(os.Stderr, "  dry run, no commands written\n")
+		return
+	}
+	fmt.Fprintf(os.Stderr, "  measured %.1f s writing to stdout (%.0f%% of theoretical)\n", measuredSeconds, 100*measuredSeconds/theoreticalSeconds)
+}
+
+// Transfer timing section end
+
+// readYmodemFile fills file with the data to send for filePath, which names either a host file, or
+// a file held in a disk image as diskImageFilepath:fileName (sent in its host form, as for cmp).
+func readYmodemFile(file *protocol.YmodemFile, filePath string) error {
+	var info os.FileInfo
+	info, err := os.Stat(filePath)
+	if err == nil {
+		file.Data, err = ioutil.ReadFile(filePath)
+		if err != nil {
+			return err
+		}
+		file.Name = filepath.Base(filePath)
+		file.ModTime = info.ModTime()
+		return nil
+	}
+	var separatorPos int = strings.LastIndex(filePath, ":")
+	if separatorPos < 0 {
+		return err
+	}
+	var diskImage []byte
+	err = readDiskImageFromFile(&diskImage, filePath[:separatorPos])
+	if err != nil {
+		return err
+	}
+	err = filesystem.ReadFileFromDiskImage(&file.Data, diskImage, filePath[separatorPos+1:])
+	if err != nil {
+		return fmt.Errorf("%s: %w", filePath[:separatorPos], err)
+	}
+	file.Name = filepath.Base(filePath[separatorPos+1:])
+	file.ModTime = time.Now()
+	return nil
+}
+
+// Chunked output section begin
+
+// chunkedFileWriter writes the command stream into a series of files named filepathPrefix_001.txt,
+// filepathPrefix_002.txt, ... each holding at most maxBytes bytes, for terminal programs which cannot
+// send large files. Files are only split between command lines. Close must be called after the last
+// write, to finish the final file and write the manifest listing the files in sending order. The
+// first error is kept in err, and returned by every later write.
+type chunkedFileWriter struct {
+	filepathPrefix string
+	maxBytes       int
+	line           []byte
+	chunk          []byte
+	chunkFilepaths []string
+	err            error
+}
+
+// Write implements io.Writer, collecting p into complete command lines and adding each line to the
+// current chunk, or to a new chunk when the current one would grow beyond maxBytes. Lines end with a
+// carriage return or, with -line-ending lf, a line feed.
+func (w *chunkedFileWriter) Write(p []byte) (int, error) {
+	for i, b := range p {
+		if w.err != nil {
+			return i, w.err
+		}
+		w.line = append(w.line, b)
+		if b&0x7F == '\r' || b&0x7F == '\n' {
+			w.err = w.addLine()
+		}
+	}
+	return len(p), w.err
+}
+
+// addLine moves the collected line into the current chunk, writing out the current chunk first
+// when there is not enough room left for the line.
+func (w *chunkedFileWriter) addLine() error {
+	if len(w.line) > w.maxBytes {
+		return failure.CodedErrorf(failure.KIND_CHUNK_TOO_SMALL, "a command line of %d bytes does not fit in chunks of %d bytes", len(w.line), w.maxBytes)
+	}
+	if len(w.chunk)+len(w.line) > w.maxBytes {
+		var err error = w.writeChunk()
+		if err != nil {
+			return err
+		}
+	}
+	w.chunk = append(w.chunk, w.line...)
+	w.line = nil
+	return nil
+}
+
+// writeChunk writes the current chunk to the next numbered file.
+func (w *chunkedFileWriter) writeChunk() error {
+	var chunkFilepath string = fmt.Sprintf("%s_%03d.txt", w.filepathPrefix, len(w.chunkFilepaths)+1)
+	var err error = ioutil.WriteFile(chunkFilepath, w.chunk, 0644)
+	if err != nil {
+		return err
+	}
+	w.chunkFilepaths = append(w.chunkFilepaths, chunkFilepath)
+	w.chunk = nil
+	return nil
+}
+
+// Close writes any remaining lines to a final chunk file, and writes the names of all chunk files in
+// sending order, one per line, to the manifest file filepathPrefix.manifest.
+func (w *chunkedFileWriter) Close() error {
+	if w.err == nil && len(w.line) > 0 {
+		w.err = w.addLine()
+	}
+	if w.err == nil && len(w.chunk) > 0 {
+		w.err = w.writeChunk()
+	}
+	if w.err != nil {
+		return w.err
+	}
+	var manifest strings.Builder
+	for _, chunkFilepath := range w.chunkFilepaths {
+		fmt.Fprintf(&manifest, "%s\n", filepath.Base(chunkFilepath))
+	}
+	var manifestFilepath string = w.filepathPrefix + ".manifest"
+	var err error = ioutil.WriteFile(manifestFilepath, []byte(manifest.String()), 0644)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "wrote %d chunk files listed in send order in %s\n", len(w.chunkFilepaths), manifestFilepath)
+	return nil
+}
+
+// Chunked output section end
+
+// Output file section begin
+
+// commandOutputFile is the file the command stream is written to with -output, or nil.
+var commandOutputFile *os.File
+
+// LINE_ENDINGS maps the names accepted by -line-ending to the character ending each command line.
+var LINE_ENDINGS map[string]byte = map[string]byte{"cr": '\r', "lf": '\n'}
+
+// openCommandOutputFile closes the current output file, if any, and sends the command stream to the
+// file outputFilepath (created or truncated) instead.
+func openCommandOutputFile(outputFilepath string) error {
+	var err error = closeCommandOutputFile()
+	if err != nil {
+		return err
+	}
+	commandOutputFile, err = os.Create(outputFilepath)
+	if err != nil {
+		return err
+	}
+	commandOutput.output = commandOutputFile
+	fmt.Fprintf(os.Stderr, "writing commands to file %s\n", outputFilepath)
+	return nil
+}
+
+// closeCommandOutputFile closes the current output file, if any.
+func closeCommandOutputFile() error {
+	if commandOutputFile == nil {
+		return nil
+	}
+	var err error = commandOutputFile.Close()
+	commandOutputFile = nil
+	return err
+}
+
+// isPerTrackOutputFilepath tells whether an -output file path is a pattern, like track%02d.mon, naming
+// a file for each track.
+func isPerTrackOutputFilepath(outputFilepath string) bool {
+	return strings.Contains(outputFilepath, "%")
+}
+
+// startTrackOutputFile opens the file for track trackNum named by the per-track pattern
+// outputFilepath. Nothing is done when outputFilepath is not a per-track pattern.
+func startTrackOutputFile(outputFilepath string, trackNum int) error {
+	if isPerTrackOutputFilepath(outputFilepath) {
+		return openCommandOutputFile(fmt.Sprintf(outputFilepath, trackNum))
+	}
+	return nil
+}
+
+// Output file section end
+
+// Track pipeline section begin
+
+// trackPipeline writes the commands of a whole-disk install to output in a goroutine, so that the
+// commands of the next track are prepared while those of the current one are sent, and the serial
+// line is never left waiting. The commands are collected in pending until the track ends, and then
+// handed over on tracks, which holds no track of its own: the next track waits there until the one
+// before it is sent. done is closed once every track has been written, and failed when a write fails,
+// with the error in err.
+type trackPipeline struct {
+	output  io.Writer
+	pending bytes.Buffer
+	tracks  chan []byte
+	done    chan struct{}
+	failed  chan struct{}
+	err     error
+}
+
+// trackPipelining is set when the commands of whole-disk installs are prepared a track ahead of the
+// serial line, which needs nothing to be waiting for answers from the apple ][ in between.
+var trackPipelining bool
+
+// startTrackPipeline starts writing the commands of commandOutput to its output through a
+// trackPipeline when trackPipelining is set, and returns it, or returns nil otherwise.
+func startTrackPipeline() *trackPipeline {
+	if !trackPipelining {
+		return nil
+	}
+	var pipeline *trackPipeline = &trackPipeline{output: commandOutput.output, tracks: make(chan []byte), done: make(chan struct{}), failed: make(chan struct{})}
+	go pipeline.send()
+	commandOutput.output = pipeline
+	return pipeline
+}
+
+// send writes each track handed over to the output, until tracks is closed. After a failed write the
+// tracks are only drained.
+func (p *trackPipeline) send() {
+	defer close(p.done)
+	for track := range p.tracks {
+		if p.err != nil {
+			continue
+		}
+		_, err := p.output.Write(track)
+		if err != nil {
+			p.err = err
+			close(p.failed)
+		}
+	}
+}
+
+// Write implements io.Writer, collecting b into the commands of the current track. The error of a
+// failed write of an earlier track is returned instead.
+func (p *trackPipeline) Write(b []byte) (int, error) {
+	select {
+	case <-p.failed:
+		return 0, p.err
+	default:
+	}
+	return p.pending.Write(b)
+}
+
+// endTrack hands the commands of the track just prepared over to be sent, waiting until the track
+// before it has been sent.
+func (p *trackPipeline) endTrack() {
+	if p == nil {
+		return
+	}
+	var track []byte = make([]byte, p.pending.Len())
+	copy(track, p.pending.Bytes())
+	p.pending.Reset()
+	p.tracks <- track
+}
+
+// writeIdle hands b over to be sent when the output is idle, with no commands of the current track
+// collected and every track handed over sent, and tells whether it was handed over.
+func (p *trackPipeline) writeIdle(b []byte) bool {
+	if p.pending.Len() > 0 {
+		return false
+	}
+	select {
+	case p.tracks <- b:
+		return true
+	default:
+		return false
+	}
+}
+
+// finish hands over the commands left, waits until every track has been sent, and restores the output
+// of commandOutput. It returns the error of commandOutput, which is given that of a failed write of the
+// last track, as it is when written directly. It can be called on a nil pipeline, which only returns
+// the error.
+func (p *trackPipeline) finish() error {
+	if p != nil {
+		p.endTrack()
+		close(p.tracks)
+		<-p.done
+		commandOutput.output = p.output
+		if p.err != nil {
+			failCommandStream(failure.CodedErrorf(failure.KIND_WRITE_FAILED, "writing the commands failed after %d lines: %w", commandOutput.lineCount, p.err))
+		}
+	}
+	return commandOutput.err
+}
+
+// Track pipeline section end
+
+// Pacing section begin
+
+// MONITOR_LINE_PROCESSING_TIME is the assumed time the apple ][ monitor spends processing a command
+// line once its carriage return is received (moving the cursor to the next line, which may scroll the
+// screen, and executing the command), during which received characters are lost. At 2400 baud with 10
+// bits per character, 12 or 13 characters of line start padding were regularly lost.
+const MONITOR_LINE_PROCESSING_TIME = 52 * time.Millisecond
+
+// PAD_MARGIN is the number of line start padding characters kept beyond those expected to be lost.
+const PAD_MARGIN = 3
+
+// ECHO_MARGIN is the number of screen columns kept clear at the end of the echoed command line, so
+// that the screen does not scroll in the middle of receiving a line even when more of the padding
+// than expected survives.
+const ECHO_MARGIN = 8
+
+// derivePacing computes the line start pad length and the segment size (bytes per memory fill
+// command) for a serial line at baud bits per second with bitsPerChar bits per character. The pad must
+// cover the characters lost while the monitor processes the previous line:
+//	lost characters = ceiling(lineProcessingTime * baud / bitsPerChar)
+//	LINE_START_PAD_LENGTH = lost characters + PAD_MARGIN
+// The surviving padding, the monitor prompt, the address with its colon (5 characters) and the bytes
+// (3 characters each, less the final separator) are echoed, and must fit on one screen line:
+//	SEGMENT_SIZE = floor((SCREEN_COLUMNS - ECHO_MARGIN - PAD_MARGIN - 1 - 5 + 1) / 3)
+// At 2400 baud with 10 bits per character these give the original 16 character pad and 8 byte
+// segments. The results are stored into segmentSize and lineStartPadLength.
+func derivePacing(segmentSize *int, lineStartPadLength *int, baud int, bitsPerChar int, lineProcessingTime time.Duration) {
+	var charTime float64 = float64(bitsPerChar) / float64(baud)
+	var lostChars int = int(math.Ceil(lineProcessingTime.Seconds() / charTime))
+	*lineStartPadLength = lostChars + PAD_MARGIN
+	*segmentSize = (emulator.SCREEN_COLUMNS - ECHO_MARGIN - PAD_MARGIN - 1 - 5 + 1) / 3
+}
+
+// rampUpLineCount is the count of lines in the ramp-up sequence sent before the first memory fill
+// command of a transfer: the first segment is filled repeatedly with a growing byte count, ending
+// with SEGMENT_SIZE bytes, so that the loss of line start padding settles into its steady rhythm.
+var rampUpLineCount int = 9
+
+// deriveRampUpLineCount returns the count of ramp-up lines for segments of segmentSize bytes: one
+// line for each byte count from an empty fill command up to the full segment,
+//	ramp-up lines = SEGMENT_SIZE + 1
+// so that the time the monitor spends processing each line grows by a single byte at a time. With 8
+// byte segments this gives the original 9 lines.
+func deriveRampUpLineCount(segmentSize int) int {
+	return segmentSize + 1
+}
+
+// explainPacing writes to stdout the calculation made by derivePacing for the given serial settings.
+func explainPacing(baud int, framing string, lineProcessingTime time.Duration) error {
+	var bitsPerChar int
+	var err error = parseLineFormat(&bitsPerChar, baud, framing)
+	if err != nil {
+		return err
+	}
+	var segmentSize, lineStartPadLength int
+	derivePacing(&segmentSize, &lineStartPadLength, baud, bitsPerChar, lineProcessingTime)
+	var charTime float64 = float64(bitsPerChar) / float64(baud)
+	fmt.Printf("serial line: %d baud, framing %s = 1 start + %c data + %s parity + %c stop = %d bits per character\n",
+		baud, framing, framing[0], map[byte]string{'N': "0", 'E': "1", 'O': "1"}[framing[1]], framing[2], bitsPerChar)
+	fmt.Printf("character time: %d / %d = %.3f ms\n", bitsPerChar, baud, 1000*charTime)
+	fmt.Printf("characters lost while the monitor processes a line: ceiling(%.1f ms / %.3f ms) = %d\n",
+		1000*lineProcessingTime.Seconds(), 1000*charTime, lineStartPadLength-PAD_MARGIN)
+	fmt.Printf("line start pad length: %d lost + %d margin = %d\n", lineStartPadLength-PAD_MARGIN, PAD_MARGIN, lineStartPadLength)
+	fmt.Printf("segment size: floor((%d screen columns - %d echo margin - %d surviving pad - 1 prompt - 5 address + 1) / 3) = %d bytes\n",
+		emulator.SCREEN_COLUMNS, ECHO_MARGIN, PAD_MARGIN, segmentSize)
+	fmt.Printf("ramp-up: %d segment size + 1 = %d lines filling 0 through %d bytes\n", segmentSize, deriveRampUpLineCount(segmentSize), segmentSize)
+	var lineChars int = lineStartPadLength + 5 + 3*segmentSize - 1 + 1
+	fmt.Printf("command line: %d characters for %d bytes, %.1f bytes per second\n", lineChars, segmentSize, float64(segmentSize)/(float64(lineChars)*charTime))
+	return nil
+}
+
+// Pacing section end
+
+// Calibration section begin
+
+// CALIBRATION_BLOCK_SIZE is the count of bytes loaded into the data buffer by each calibration probe,
+// and CALIBRATION_CONFIRM_BLOCK_SIZE the count loaded to confirm the segment size and pad length chosen.
+const CALIBRATION_BLOCK_SIZE = 0x80
+const CALIBRATION_CONFIRM_BLOCK_SIZE = 0x0400
+
+// CALIBRATION_QUIET_TIME is how long the serial line must stay quiet before a probe is started, so
+// that what the apple ][ still sends after a failed probe is not taken for the next memory dump.
+const CALIBRATION_QUIET_TIME = 500 * time.Millisecond
+
+// tuningProfile holds the segment size and pad length found by calibrating a serial line at a baud
+// rate and framing, kept in a tuning profile file for the transfers which follow.
+type tuningProfile struct {
+	Baud        int    `json:"baud"`
+	Framing     string `json:"framing"`
+	SegmentSize int    `json:"segment_size"`
+	PadLength   int    `json:"pad_length"`
+	Calibrated  string `json:"calibrated"`
+}
+
+// calibrationResult is the shortest pad length with which a probe with segments of segmentSize bytes
+// loaded memory intact.
+type calibrationResult struct {
+	segmentSize int
+	padLength   int
+}
+
+// drainCalibrationInput drops the characters arriving on input until none has arrived for
+// CALIBRATION_QUIET_TIME.
+func drainCalibrationInput(input chan byte) error {
+	for {
+		select {
+		case _, ok := <-input:
+			if !ok {
+				return protocol.ClosedInputError(failure.KIND_SERIAL_PORT_CLOSED, "serial port closed while calibrating")
+			}
+		case <-time.After(CALIBRATION_QUIET_TIME):
+			return nil
+		}
+	}
+}
+
+// probeCalibration loads byteCount bytes of a test pattern, which differs from that of the probe before
+// it at every byte, into the data buffer with memory fill commands of SEGMENT_SIZE bytes preceded by
+// LINE_START_PAD_LENGTH spaces (and the ramp-up lines for that segment size), then displays the buffer
+// with the monitor and tells whether the dump read by verifier shows the pattern intact.
+func probeCalibration(verifier *memoryVerifier, probeNum int, byteCount int, SEGMENT_SIZE int, LINE_START_PAD_LENGTH int) (bool, error) {
+	var err error = drainCalibrationInput(verifier.input)
+	if err != nil {
+		return false, err
+	}
+	var pattern []byte = make([]byte, byteCount)
+	for i := 0; i < byteCount; i = i + 1 {
+		pattern[i] = byte(i*0x25 + probeNum*0x4B + 1)
+	}
+	rampUpLineCount = deriveRampUpLineCount(SEGMENT_SIZE)
+	writeCommandsToLoadDiskBytesToMemory(pattern, 0, byteCount, SEGMENT_SIZE, LINE_START_PAD_LENGTH)
+	var lineStartPad string
+	generateLineStartPad(&lineStartPad, LINE_START_PAD_LENGTH)
+	var dumpCommand string
+	generateMemoryDumpCommand(&dumpCommand, bufferAddress, byteCount)
+	fmt.Fprintf(&commandOutput, "%s%s\r", lineStartPad, dumpCommand)
+	var dump []byte = make([]byte, byteCount)
+	var seen []bool = make([]bool, byteCount)
+	err = verifier.awaitMemoryDump(dump, seen, bufferAddress)
+	endProgressLine()
+	if err == nil {
+		err = commandOutput.err
+	}
+	if err != nil {
+		return false, err
+	}
+	for pos := 0; pos < byteCount; pos = pos + 1 {
+		if !seen[pos] || dump[pos] != pattern[pos] {
+			fmt.Fprintf(os.Stderr, "segment size %d, pad length %d: characters lost\n", SEGMENT_SIZE, LINE_START_PAD_LENGTH)
+			return false, nil
+		}
+	}
+	fmt.Fprintf(os.Stderr, "segment size %d, pad length %d: loaded intact\n", SEGMENT_SIZE, LINE_START_PAD_LENGTH)
+	return true, nil
+}
+
+// calibratePacing finds, over the serial line at baud with framing whose answers arrive on input, the
+// segment size and pad length which load memory fastest without the monitor losing characters, and
+// records them in the tuning profile file tuningFilepath. Segment sizes are probed from 1 upwards, each
+// with pad lengths from the shortest which worked for the segment size before it, since longer lines
+// never take the monitor less time to process, up to PAD_MARGIN more; the probes end with the first
+// segment size which none of those pad lengths loads intact, or with the longest segment which fits on
+// a screen line. maxPadLength bounds the pad lengths tried for the first segment size. The results,
+// with PAD_MARGIN spaces added to the pad, are then tried in the order of their speed on a longer
+// block, and the first which loads it intact is recorded.
+func calibratePacing(input chan byte, tuningFilepath string, baud int, framing string, maxPadLength int) error {
+	var bitsPerChar int
+	var err error = parseLineFormat(&bitsPerChar, baud, framing)
+	if err != nil {
+		return err
+	}
+	var verifier *memoryVerifier = &memoryVerifier{input: input, baud: baud, bitsPerChar: bitsPerChar}
+	var maxSegmentSize int = (emulator.SCREEN_COLUMNS - 1 - 5 + 1) / 3
+	var results []calibrationResult
+	var probeNum int = 0
+	var padLength int = 0
+	for segmentSize := 1; segmentSize <= maxSegmentSize; segmentSize = segmentSize + 1 {
+		var lastPadLength int = maxPadLength
+		if len(results) > 0 {
+			lastPadLength = padLength + PAD_MARGIN
+		}
+		var intact bool = false
+		for ; padLength <= lastPadLength; padLength = padLength + 1 {
+			probeNum = probeNum + 1
+			intact, err = probeCalibration(verifier, probeNum, CALIBRATION_BLOCK_SIZE, segmentSize, padLength)
+			if err != nil {
+				return err
+			}
+			if intact {
+				break
+			}
+		}
+		if !intact {
+			break
+		}
+		results = append(results, calibrationResult{segmentSize: segmentSize, padLength: padLength})
+	}
+	if len(results) == 0 {
+		return failure.CodedErrorf(failure.KIND_CALIBRATION_FAILED, "calibration found no pad length up to %d with which the monitor loaded memory intact", maxPadLength)
+	}
+	for len(results) > 0 {
+		// the fastest left first: the fewest characters sent for each byte loaded
+		var fastest int = 0
+		for i, result := range results {
+			if (result.padLength+PAD_MARGIN+5+3*result.segmentSize)*results[fastest].segmentSize < (results[fastest].padLength+PAD_MARGIN+5+3*results[fastest].segmentSize)*result.segmentSize {
+				fastest = i
+			}
+		}
+		var result calibrationResult = results[fastest]
+		results = append(results[:fastest], results[fastest+1:]...)
+		probeNum = probeNum + 1
+		intact, err := probeCalibration(verifier, probeNum, CALIBRATION_CONFIRM_BLOCK_SIZE, result.segmentSize, result.padLength+PAD_MARGIN)
+		if err != nil {
+			return err
+		}
+		if intact {
+			var profile tuningProfile = tuningProfile{Baud: baud, Framing: framing, SegmentSize: result.segmentSize, PadLength: result.padLength + PAD_MARGIN, Calibrated: time.Now().UTC().Format(time.RFC3339)}
+			var data []byte
+			data, err = json.MarshalIndent(profile, "", "  ")
+			if err != nil {
+				return err
+			}
+			err = ioutil.WriteFile(tuningFilepath, append(data, '\n'), 0644)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(os.Stderr, "calibrated %d baud %s: segment size %d, pad length %d, recorded in %s\n", baud, framing, profile.SegmentSize, profile.PadLength, tuningFilepath)
+			return nil
+		}
+	}
+	return failure.CodedErrorf(failure.KIND_CALIBRATION_FAILED, "calibration found no segment size and pad length which loaded a block of %d bytes intact", CALIBRATION_CONFIRM_BLOCK_SIZE)
+}
+
+// readTuningProfile stores into segmentSize and lineStartPadLength those recorded in the tuning profile
+// file tuningFilepath, which must have been calibrated at baud with framing.
+func readTuningProfile(segmentSize *int, lineStartPadLength *int, tuningFilepath string, baud int, framing string) error {
+	var data []byte
+	data, err := ioutil.ReadFile(tuningFilepath)
+	if err != nil {
+		return err
+	}
+	var profile tuningProfile
+	err = json.Unmarshal(data, &profile)
+	if err != nil {
+		return fmt.Errorf("tuning profile %s could not be read: %w", tuningFilepath, err)
+	}
+	if profile.Baud != baud || profile.Framing != framing {
+		return failure.CodedErrorf(failure.KIND_TUNING_MISMATCH, "tuning profile %s was calibrated at %d baud %s, not %d baud %s", tuningFilepath, profile.Baud, profile.Framing, baud, framing)
+	}
+	if profile.SegmentSize < 1 || profile.PadLength < 0 {
+		return fmt.Errorf("tuning profile %s could not be read: segment size %d and pad length %d", tuningFilepath, profile.SegmentSize, profile.PadLength)
+	}
+	*segmentSize = profile.SegmentSize
+	*lineStartPadLength = profile.PadLength
+	return nil
+}
+
+// Calibration section end
+
+// monitorSimulation is set when the commands are fed to a simulated monitor instead of being written,
+// and is nil otherwise.
+var monitorSimulation *simulator.MonitorSimulator
+
+// startMonitorSimulation sets up monitorSimulation to receive the command stream at baud bits per
+// second with bitsPerChar bits per character, through faults when not nil, writing its memory to
+// memoryFilepath (when not empty) once the simulation is reported.
+func startMonitorSimulation(baud int, bitsPerChar int, lineProcessingTime time.Duration, clientTime time.Duration, flowControl bool, faults *simulator.CharacterFaults, memoryFilepath string) {
+	monitorSimulation = simulator.NewMonitorSimulator(baud, bitsPerChar, lineProcessingTime, clientTime, flowControl, faults, memoryFilepath, clientAddress, bufferAddress)
+	commandOutput.output = monitorSimulation
+}
+
+// reportMonitorSimulation reports the simulated install, as simulator.MonitorSimulator.Report does,
+// when the commands were fed to a simulated monitor.
+func reportMonitorSimulation(diskImage []byte, trackNums []int, clientStrategy string, dataOnly bool) error {
+	if monitorSimulation == nil {
+		return nil
+	}
+	endProgressLine()
+	return monitorSimulation.Report(diskImage, trackNums, clientStrategy, dataOnly)
+}
+
+// Client check section begin
+
+// RWTS_ENTRY_ADDRESS is the address of the RWTS entry point called by the client programs.
+const RWTS_ENTRY_ADDRESS = 0x03D9
+
+// MONITOR_COUT_ADDRESS and MONITOR_PRBYTE_ADDRESS are the monitor routines which print the character
+// and the byte (in hexadecimal) in the accumulator.
+const MONITOR_COUT_ADDRESS = 0xFDED
+const MONITOR_PRBYTE_ADDRESS = 0xFDDA
+
+// CLIENT_CHECK_MAX_INSTRUCTIONS is the count of instructions after which a client program checked is
+// taken to never return.
+const CLIENT_CHECK_MAX_INSTRUCTIONS = 100000
+
+// rwtsCall is the IOB passed in one call of the mock RWTS.
+type rwtsCall struct {
+	slot          int
+	drive         int
+	volume        int
+	track         int
+	sector        int
+	bufferAddress int
+	command       byte
+}
+
+// mockRwts stands for the RWTS and the monitor print routines while a client program runs on a
+// cpu6502. It records each call, writes the sectors into and reads them out of sectors (indexed by
+// track and sector), and fails the call counted by failingCall (from 0, or none when negative) with a
+// drive error. The characters printed are collected in printed.
+type mockRwts struct {
+	calls       []rwtsCall
+	sectors     map[int][]byte
+	failingCall int
+	printed     []byte
+}
+
+// callRwts carries out the RWTS call for the IOB whose address is in the accumulator (high byte) and
+// the Y register (low byte), returning with the carry set on an error, as the RWTS does.
+func (r *mockRwts) callRwts(c *emulator.Cpu6502) {
+	var iobAddress int = int(c.A)<<8 | int(c.Y)
+	var iob []byte = c.Memory[iobAddress : iobAddress+0x11]
+	var call rwtsCall = rwtsCall{
+		slot:          int(iob[0x01]) >> 4,
+		drive:         int(iob[0x02]),
+		volume:        int(iob[0x03]),
+		track:         int(iob[0x04]),
+		sector:        int(iob[0x05]),
+		bufferAddress: int(iob[0x08]) | int(iob[0x09])<<8,
+		command:       iob[0x0C]}
+	r.calls = append(r.calls, call)
+	var returnCode byte = '\x00'
+	if len(r.calls)-1 == r.failingCall || call.track > 0x22 || call.sector > 0x0F {
+		returnCode = '\x40'
+	} else if call.command == RWTS_COMMAND_WRITE {
+		r.sectors[call.track<<4|call.sector] = append([]byte{}, c.Memory[call.bufferAddress:call.bufferAddress+0x0100]...)
+	} else if call.command == RWTS_COMMAND_READ {
+		var sector []byte = r.sectors[call.track<<4|call.sector]
+		if sector == nil {
+			sector = make([]byte, 0x0100)
+		}
+		copy(c.Memory[call.bufferAddress:call.bufferAddress+0x0100], sector)
+	}
+	iob[0x0D] = returnCode
+	iob[0x0E] = targetDiskVolume
+	iob[0x0F] = iob[0x01]
+	iob[0x10] = iob[0x02]
+	c.SetFlag(emulator.FLAG_CARRY, returnCode != '\x00')
+}
+
+// printChar collects the character in the accumulator, as COUT prints it.
+func (r *mockRwts) printChar(c *emulator.Cpu6502) {
+	r.printed = append(r.printed, c.A)
+}
+
+// printByte collects the accumulator in hexadecimal, as PRBYTE prints it.
+func (r *mockRwts) printByte(c *emulator.Cpu6502) {
+	r.printed = append(r.printed, []byte(fmt.Sprintf("%02X", c.A))...)
+}
+
+// startClientCheck returns a cpu6502 whose memory holds the RWTS client program for track trackNum
+// and clientStrategy, with a different byte pattern in each page of the track buffer, and whose
+// traps call rwts. It returns the error of generateRWTSClientProgram when the program cannot be
+// generated.
+func startClientCheck(rwts *mockRwts, trackNum int, clientStrategy string) (*emulator.Cpu6502, error) {
+	var c *emulator.Cpu6502 = &emulator.Cpu6502{}
+	c.Traps = map[int]func(c *emulator.Cpu6502){RWTS_ENTRY_ADDRESS: rwts.callRwts, MONITOR_COUT_ADDRESS: rwts.printChar, MONITOR_PRBYTE_ADDRESS: rwts.printByte}
+	var clientProgram []byte
+	var err error = generateRWTSClientProgram(&clientProgram, trackNum, RWTS_COMMAND_WRITE, clientStrategy)
+	if err != nil {
+		return nil, err
+	}
+	copy(c.Memory[clientAddress:], clientProgram)
+	for i := 0; i < 0x1000; i = i + 1 {
+		c.Memory[bufferAddress+i] = byte(i*7 + (i>>8)*0x35 + trackNum)
+	}
+	return c, nil
+}
+
+// expectedRwtsCalls returns the RWTS calls the client program for clientStrategy makes for track
+// trackNum with rwtsCommand, into the buffer starting at startBufferAddress: a call for each sector in
+// ascending or descending order, or a call for sector sectorNum alone with the sector strategy.
+func expectedRwtsCalls(trackNum int, rwtsCommand byte, clientStrategy string, startBufferAddress int, sectorNum int) []rwtsCall {
+	var calls []rwtsCall
+	for i := 0x00; i < 0x10; i = i + 1 {
+		var sector int = i
+		if clientStrategy == "descending" {
+			sector = 0x0F - i
+		} else if clientStrategy == "sector" {
+			sector = sectorNum
+		}
+		var bufferAddress int = startBufferAddress + sector*0x0100
+		if clientStrategy == "sector" {
+			bufferAddress = startBufferAddress
+		}
+		calls = append(calls, rwtsCall{slot: targetDiskSlot, drive: targetDiskDrive, volume: int(targetDiskVolume), track: trackNum, sector: sector, bufferAddress: bufferAddress, command: rwtsCommand})
+		if clientStrategy == "sector" {
+			break
+		}
+	}
+	return calls
+}
+
+// checkClientRun runs the program at startAddress on c, reporting to output how it stopped and the
+// count of RWTS calls it made, and adds a problem to problems for each call which differs from
+// expectedCalls and when it did not stop as expectedStop tells.
+func checkClientRun(output io.Writer, problems *[]string, subject string, c *emulator.Cpu6502, rwts *mockRwts, startAddress int, expectedCalls []rwtsCall, expectedStop string) {
+	rwts.calls = nil
+	var stop string = emulator.RunMachineCode(c, startAddress, CLIENT_CHECK_MAX_INSTRUCTIONS)
+	fmt.Fprintf(output, "%s: %d RWTS calls, %s after %d instructions\n", subject, len(rwts.calls), stop, c.InstructionCount)
+	if stop != expectedStop {
+		*problems = append(*problems, fmt.Sprintf("%s: %s instead of having %s", subject, stop, expectedStop))
+	}
+	if len(rwts.calls) != len(expectedCalls) {
+		*problems = append(*problems, fmt.Sprintf("%s: %d RWTS calls instead of %d", subject, len(rwts.calls), len(expectedCalls)))
+	}
+	for i, call := range rwts.calls {
+		if i < len(expectedCalls) && call != expectedCalls[i] {
+			var expected rwtsCall = expectedCalls[i]
+			*problems = append(*problems, fmt.Sprintf("%s: call %d was for slot %d drive %d volume %d track %d sector %d buffer %04X command %d, instead of slot %d drive %d volume %d track %d sector %d buffer %04X command %d",
+				subject, i+1, call.slot, call.drive, call.volume, call.track, call.sector, call.bufferAddress, call.command,
+				expected.slot, expected.drive, expected.volume, expected.track, expected.sector, expected.bufferAddress, expected.command))
+		}
+	}
+}
+
+// checkClientPrograms runs the RWTS client program of each client strategy, with the slot, drive,
+// volume and addresses set, on a cpu6502 with a mock RWTS, and reports to output whether each:
+//
+//   - writes the sectors of a track from the pages of the track buffer in its order and returns,
+//   - does so again for the next track after writeCommandsToResetClientIob resets its IOB,
+//   - stops at its break when the RWTS reports an error,
+//   - with the read back program, reads the track back and prints the checksums of what it wrote.
+//
+// It returns whether no problems were found.
+func checkClientPrograms(output io.Writer) bool {
+	var problems []string
+	const TRACK_NUM = 0x11
+	var expectedStop string = "returned"
+	for _, clientStrategy := range []string{"track", "descending", "sector"} {
+		var rwts *mockRwts = &mockRwts{sectors: map[int][]byte{}, failingCall: -1}
+		c, err := startClientCheck(rwts, TRACK_NUM, clientStrategy)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("%s client: %s", clientStrategy, err))
+			continue
+		}
+		var subject string = fmt.Sprintf("%s client writing track %d", clientStrategy, TRACK_NUM)
+		checkClientRun(output, &problems, subject, c, rwts, clientAddress, expectedRwtsCalls(TRACK_NUM, RWTS_COMMAND_WRITE, clientStrategy, bufferAddress, 0x00), expectedStop)
+		if clientStrategy == "sector" {
+			// the sector is stored into the IOB before each run
+			c.Memory[clientAddress+0x21] = '\x05'
+			subject = fmt.Sprintf("%s client writing track %d sector %d", clientStrategy, TRACK_NUM, 0x05)
+			checkClientRun(output, &problems, subject, c, rwts, clientAddress, expectedRwtsCalls(TRACK_NUM, RWTS_COMMAND_WRITE, clientStrategy, bufferAddress, 0x05), expectedStop)
+		} else {
+			var iobReset []byte
+			err = generateClientIobReset(&iobReset, TRACK_NUM+1, clientStrategy)
+			if err != nil {
+				problems = append(problems, fmt.Sprintf("%s client IOB reset: %s", clientStrategy, err))
+				continue
+			}
+			copy(c.Memory[clientAddress+0x20:], iobReset)
+			subject = fmt.Sprintf("%s client writing track %d after the IOB reset", clientStrategy, TRACK_NUM+1)
+			checkClientRun(output, &problems, subject, c, rwts, clientAddress, expectedRwtsCalls(TRACK_NUM+1, RWTS_COMMAND_WRITE, clientStrategy, bufferAddress, 0x00), expectedStop)
+		}
+		rwts = &mockRwts{sectors: map[int][]byte{}, failingCall: 0x02}
+		c, err = startClientCheck(rwts, TRACK_NUM, clientStrategy)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("%s client: %s", clientStrategy, err))
+			continue
+		}
+		var expectedCalls []rwtsCall = expectedRwtsCalls(TRACK_NUM, RWTS_COMMAND_WRITE, clientStrategy, bufferAddress, 0x00)
+		if len(expectedCalls) > 0x03 {
+			expectedCalls = expectedCalls[:0x03]
+		} else {
+			rwts.failingCall = 0x00
+		}
+		subject = fmt.Sprintf("%s client failing on RWTS call %d", clientStrategy, rwts.failingCall+1)
+		checkClientRun(output, &problems, subject, c, rwts, clientAddress, expectedCalls, fmt.Sprintf("stopped at the break at %04X", clientAddress+0x1B))
+		if clientStrategy == "sector" {
+			continue
+		}
+		rwts = &mockRwts{sectors: map[int][]byte{}, failingCall: -1}
+		c, err = startClientCheck(rwts, TRACK_NUM, clientStrategy)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("%s client: %s", clientStrategy, err))
+			continue
+		}
+		var readBackProgram []byte
+		generateReadBackProgram(&readBackProgram, clientStrategy)
+		copy(c.Memory[clientAddress+0x0100:], readBackProgram)
+		c.Memory[clientAddress+0x08] = READ_BACK_CLIENT_ERROR_BRANCH
+		emulator.RunMachineCode(c, clientAddress, CLIENT_CHECK_MAX_INSTRUCTIONS)
+		subject = fmt.Sprintf("%s read back program reading track %d", clientStrategy, TRACK_NUM)
+		checkClientRun(output, &problems, subject, c, rwts, clientAddress+0x0100, expectedRwtsCalls(TRACK_NUM, RWTS_COMMAND_READ, clientStrategy, bufferAddress+0x1000, 0x00), expectedStop)
+		// the checksums printed are those of the track as written, in an image holding it
+		var diskImage []byte = make([]byte, diskimage.FLOPPY_IMAGE_SIZE)
+		copy(diskImage[diskimage.DiskImageStartPosOfTrackSector(TRACK_NUM, 0x00):], c.Memory[bufferAddress:bufferAddress+0x1000])
+		var failedTrackCount int
+		// the track is given, so the capture is not searched for the tracks it names
+		checkReadBackCapture(&failedTrackCount, diskImage, []int{TRACK_NUM}, rwts.printed)
+		if failedTrackCount > 0 {
+			problems = append(problems, fmt.Sprintf("%s: printed %q, not the checksums of the track", subject, filesystem.StripHighBits(rwts.printed)))
+		}
+	}
+	return filesystem.ReportCheckProblems(output, problems)
+}
+
+// Client check section end
+
+// Progress events section begin
+
+// progressEvent is one line of the JSON progress event stream. Line and Chars are the count of
+// command lines and characters written so far to the command stream.
+type progressEvent struct {
+	Event string `json:"event"`
+	Time  string `json:"time"`
+	Track int    `json:"track"`
+	Line  int    `json:"line,omitempty"`
+	Chars int    `json:"chars,omitempty"`
+}
+
+// progressEventOutput receives the JSON progress event stream, one event per line, when it is not nil.
+var progressEventOutput io.Writer
+
+// progressEventTrack is the track number placed in each progress event.
+var progressEventTrack int
+
+// emitProgressEvent writes an event named eventName for the current progressEventTrack to
+// progressEventOutput, if it is set, after updating the progress report, the track grid and the
+// transfer session, and acting on the keys read from the control input. Events are "track_started",
+// "line_sent", "track_verifying" (when the apple ][ is waited for to check the track), "track_failed"
+// (when the track does not check) and "track_finished", and "track_paused" when the transfer waits
+// before the track for the control input to let it go on.
+func emitProgressEvent(eventName string, lineCount int, charCount int) {
+	reportProgress(eventName, charCount)
+	updateTrackGrid(eventName)
+	awaitTransferControl(eventName, lineCount, charCount)
+	recordSessionProgress(eventName, charCount)
+	writeProgressEvent(eventName, lineCount, charCount)
+}
+
+// writeProgressEvent writes an event named eventName for the current progressEventTrack to
+// progressEventOutput, if it is set.
+func writeProgressEvent(eventName string, lineCount int, charCount int) {
+	if progressEventOutput == nil {
+		return
+	}
+	var event progressEvent = progressEvent{
+		Event: eventName,
+		Time:  time.Now().Format(time.RFC3339Nano),
+		Track: progressEventTrack,
+		Line:  lineCount,
+		Chars: charCount,
+	}
+	// a struct of strings and numbers always marshals
+	var eventLine []byte
+	eventLine, _ = json.Marshal(event)
+	_, err := progressEventOutput.Write(append(eventLine, '\n'))
+	if err != nil {
+		failCommandStream(fmt.Errorf("writing the progress events: %w", err))
+	}
+}
+
+// failCommandStream keeps err as the error of commandOutput, unless it holds one already, so that the
+// failures of what the writes of the command stream do on the side (the progress events, the track grid
+// and the session file) stop the transfer at the end of the track as a failed write does.
+func failCommandStream(err error) {
+	if commandOutput.err == nil {
+		commandOutput.err = err
+	}
+}
+
+// openProgressEventOutput sets progressEventOutput to the file eventsFilepath (created or truncated)
+// when it is not empty, or otherwise to the already open file descriptor eventsFd when it is not
+// negative.
+func openProgressEventOutput(eventsFilepath string, eventsFd int) error {
+	if eventsFilepath != "" {
+		var f *os.File
+		f, err := os.Create(eventsFilepath)
+		if err != nil {
+			return err
+		}
+		progressEventOutput = f
+	} else if eventsFd >= 0 {
+		progressEventOutput = os.NewFile(uintptr(eventsFd), fmt.Sprintf("fd %d", eventsFd))
+	}
+	return nil
+}
+
+// controlInput receives the keys read from the control input, given by -control-fd to a program
+// driving the transfer, or is nil when there is none.
+var controlInput chan byte
+
+// controlPauseRequested tells whether the control input asked the transfer to pause before the next
+// track.
+var controlPauseRequested bool
+
+// openTransferControl starts reading the keys of the control input from the already open file
+// descriptor controlFd, when it is not negative.
+func openTransferControl(controlFd int) {
+	if controlFd < 0 {
+		return
+	}
+	var f *os.File = os.NewFile(uintptr(controlFd), fmt.Sprintf("fd %d", controlFd))
+	var keys chan byte = make(chan byte, 16)
+	controlInput = keys
+	go func() {
+		var key []byte = make([]byte, 1)
+		for {
+			_, err := f.Read(key)
+			if err != nil {
+				close(keys)
+				return
+			}
+			keys <- key[0]
+		}
+	}()
+}
+
+// awaitTransferControl acts, if there is a control input, on the keys read from it before a progress
+// event named eventName: p asks the transfer to pause before the next track, and then to go on. When
+// a pause was asked for, the transfer waits at the start of the next track, after a "track_paused"
+// event, until it is asked to go on.
+func awaitTransferControl(eventName string, lineCount int, charCount int) {
+	if controlInput == nil {
+		return
+	}
+	for pending := true; pending; {
+		select {
+		case key, ok := <-controlInput:
+			if ok && (key == 'p' || key == 'P') {
+				controlPauseRequested = !controlPauseRequested
+			}
+			pending = ok
+		default:
+			pending = false
+		}
+	}
+	if eventName != "track_started" || !controlPauseRequested {
+		return
+	}
+	writeProgressEvent("track_paused", lineCount, charCount)
+	var pauseStartTime time.Time = time.Now()
+	for controlPauseRequested {
+		var key byte
+		var ok bool = awaitKeyKeepingAlive(&key, controlInput)
+		if !ok {
+			failCommandStream(fmt.Errorf("control input closed while the transfer was paused"))
+			break
+		}
+		if key == 'p' || key == 'P' {
+			controlPauseRequested = false
+		}
+	}
+	shiftTransmissionClock(time.Since(pauseStartTime))
+}
+
+// Progress events section end
+
+// Progress report section begin
+
+// progressReport holds what is known of the transfer whose progress is reported to stderr: the number
+// of tracks it holds, the characters expected for each track (estimated from the track data until a
+// track has been sent, and then measured), and the line rate used to estimate the time remaining.
+type progressReport struct {
+	trackCount      int
+	tracksDone      int
+	trackCharCount  int
+	trackStartChars int
+	baud            int
+	bitsPerChar     int
+	percentShown    int
+	lineOpen        bool
+}
+
+// progress is the transfer whose progress is reported, or nil when no progress is reported.
+var progress *progressReport
+
+// estimateTrackCharCount returns the characters of the memory fill commands loading one track of
+// 4096 bytes, SEGMENT_SIZE bytes per line with LINE_START_PAD_LENGTH spaces before each (or for the
+// compact encoding, without the runs of equal bytes), or of the binary transfer of the track.
+func estimateTrackCharCount(SEGMENT_SIZE int, LINE_START_PAD_LENGTH int) int {
+	if binaryTransfer != nil {
+		// the command running the receiver, the pad, the sync character and the bytes
+		return 2*LINE_START_PAD_LENGTH + 5 + 1 + 0x1000
+	}
+	if compactEncoding {
+		// a colon instead of the address leaves room for more bytes on each line
+		var compactLineCount int = (0x1000 + (3*SEGMENT_SIZE+3)/3 - 1) / ((3*SEGMENT_SIZE + 3) / 3)
+		return compactLineCount * (LINE_START_PAD_LENGTH + 6 + 3*SEGMENT_SIZE)
+	}
+	var lineCount int = (0x1000 + SEGMENT_SIZE - 1) / SEGMENT_SIZE
+	// each line is the pad, "2000:", 3 characters per byte and a carriage return
+	return lineCount * (LINE_START_PAD_LENGTH + 6 + 3*SEGMENT_SIZE)
+}
+
+// startProgressReport begins reporting the progress of a transfer of trackCount tracks to stderr,
+// each expected to take about trackCharCount characters sent at baud bits per second with
+// bitsPerChar bits per character.
+func startProgressReport(trackCount int, trackCharCount int, baud int, bitsPerChar int) {
+	progress = &progressReport{trackCount: trackCount, trackCharCount: trackCharCount, baud: baud, bitsPerChar: bitsPerChar, percentShown: -1}
+}
+
+// formatRemainingTime formats a number of seconds like 1h02m03s, 2m03s or 3s.
+func formatRemainingTime(seconds float64) string {
+	var total int = int(math.Ceil(seconds))
+	if total >= 3600 {
+		return fmt.Sprintf("%dh%02dm%02ds", total/3600, total/60%60, total%60)
+	} else if total >= 60 {
+		return fmt.Sprintf("%dm%02ds", total/60, total%60)
+	}
+	return fmt.Sprintf("%ds", total)
+}
+
+// endProgressLine ends the line of the progress report being rewritten in place, if there is one, so
+// that another message can be written to stderr.
+func endProgressLine() {
+	if progress != nil && progress.lineOpen {
+		fmt.Fprintf(os.Stderr, "\n")
+		progress.lineOpen = false
+	}
+}
+
+// reportProgress updates the progress report on stderr for a progress event named eventName, with
+// charCount characters written so far to the command stream. The line showing the current track is
+// rewritten in place whenever its whole percentage changes, and is ended once the track is sent.
+// After the first track, the characters expected for each of the remaining tracks are those sent
+// for the tracks before.
+func reportProgress(eventName string, charCount int) {
+	if progress == nil {
+		return
+	}
+	if eventName == "track_started" {
+		if progress.tracksDone > 0 {
+			progress.trackCharCount = charCount / progress.tracksDone
+		}
+		progress.trackStartChars = charCount
+		progress.percentShown = -1
+	}
+	var trackChars int = charCount - progress.trackStartChars
+	if trackChars > progress.trackCharCount {
+		trackChars = progress.trackCharCount
+	}
+	if eventName == "track_finished" {
+		trackChars = progress.trackCharCount
+	}
+	var totalChars int = progress.trackCount * progress.trackCharCount
+	var doneChars int = progress.tracksDone*progress.trackCharCount + trackChars
+	// with no tracks (or no characters) to send, there is nothing left to wait for
+	var percent int = 100
+	if totalChars > 0 {
+		percent = 100 * doneChars / totalChars
+	}
+	if percent == progress.percentShown && eventName == "line_sent" {
+		return
+	}
+	progress.percentShown = percent
+	var remainingSeconds float64 = transmissionSeconds(totalChars-doneChars, progress.baud, progress.bitsPerChar)
+	fmt.Fprintf(os.Stderr, "\rtrack %d (%d of %d): %3d%%, %d characters sent, about %s remaining ", progressEventTrack, progress.tracksDone+1, progress.trackCount, percent, charCount, formatRemainingTime(remainingSeconds))
+	progress.lineOpen = true
+	if eventName == "track_finished" {
+		endProgressLine()
+		progress.tracksDone = progress.tracksDone + 1
+	}
+}
+
+// Progress report section end
+
+// Track grid section begin
+
+// TRACK_GRID_COLUMNS is the count of tracks on each row of the track grid.
+const TRACK_GRID_COLUMNS = 12
+
+// TRACK_GRID_REDRAW_TIME is the shortest time between redraws of the track grid as lines are sent.
+const TRACK_GRID_REDRAW_TIME = 250 * time.Millisecond
+
+// TRACK_STATE_SYMBOLS are the symbols shown in the track grid for the state of each track.
+var TRACK_STATE_SYMBOLS map[string]string = map[string]string{
+	"pending":   ".",
+	"sending":   ">",
+	"verifying": "?",
+	"done":      "#",
+	"failed":    "X",
+}
+
+// trackGrid is the terminal user interface showing the state of each of the tracks trackNums of a
+// transfer in a grid, kept at the top of the terminal tty while the other messages scroll below it,
+// with the throughput since startTime. The keys pressed arrive on keys: p pauses the transfer before
+// the next track, and resumes it, and r retries the tracks which failed once the others are done.
+// sttyState holds the settings of the tty to restore at the end.
+type trackGrid struct {
+	tty            *os.File
+	trackNums      []int
+	states         map[int]string
+	keys           chan byte
+	sttyState      string
+	rowCount       int
+	startTime      time.Time
+	tracksDone     int
+	pauseRequested bool
+	retryRequested bool
+	status         string
+	lastDrawTime   time.Time
+}
+
+// grid is the track grid shown, or nil when there is none.
+var grid *trackGrid
+
+// runStty runs stty with args on the terminal tty, and stores what it writes into output.
+func runStty(output *string, tty *os.File, args ...string) error {
+	var cmd *exec.Cmd = exec.Command("stty", args...)
+	cmd.Stdin = tty
+	cmd.Stderr = os.Stderr
+	written, err := cmd.Output()
+	if err != nil {
+		return fmt.Errorf("setting up the terminal with stty %s failed: %w", strings.Join(args, " "), err)
+	}
+	*output = strings.TrimSpace(string(written))
+	return nil
+}
+
+// startTrackGrid shows the track grid for the tracks trackNums on the terminal, which must be the
+// controlling terminal of the program, and starts reading the keys pressed on it one at a time.
+func startTrackGrid(trackNums []int) error {
+	tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+	if err != nil {
+		return failure.CodedErrorf(failure.KIND_OPTION_CONFLICT, "the track grid needs a terminal: %w", err)
+	}
+	grid = &trackGrid{tty: tty, trackNums: trackNums, states: map[int]string{}, keys: make(chan byte, 16), startTime: time.Now()}
+	for _, trackNum := range trackNums {
+		grid.states[trackNum] = "pending"
+	}
+	var rowCount, columnCount int
+	var size string
+	err = runStty(&size, tty, "size")
+	if err == nil {
+		_, err = fmt.Sscanf(size, "%d %d", &rowCount, &columnCount)
+	}
+	if err != nil || rowCount == 0 {
+		rowCount = 24
+	}
+	grid.rowCount = rowCount
+	var ignored string
+	err = runStty(&grid.sttyState, tty, "-g")
+	if err == nil {
+		err = runStty(&ignored, tty, "-icanon", "-echo", "min", "1")
+	}
+	if err != nil {
+		grid = nil
+		tty.Close()
+		return err
+	}
+	var keys chan byte = grid.keys
+	go func() {
+		var key []byte = make([]byte, 1)
+		for {
+			_, err := tty.Read(key)
+			if err != nil {
+				close(keys)
+				return
+			}
+			keys <- key[0]
+		}
+	}()
+	var gridLineCount int = grid.lineCount()
+	// clear the screen, and scroll the messages below the grid
+	fmt.Fprintf(tty, "\x1b[2J\x1b[%d;%dr\x1b[%d;1H", gridLineCount+1, rowCount, rowCount)
+	grid.draw()
+	return nil
+}
+
+// lineCount returns the count of terminal lines the track grid takes: a row for every
+// TRACK_GRID_COLUMNS tracks, the legend, the throughput, the status and a blank line.
+func (g *trackGrid) lineCount() int {
+	return (len(g.trackNums)+TRACK_GRID_COLUMNS-1)/TRACK_GRID_COLUMNS + 4
+}
+
+// draw redraws the track grid at the top of the terminal, leaving the cursor where it was.
+func (g *trackGrid) draw() {
+	var sb strings.Builder
+	sb.WriteString("\x1b7\x1b[1;1H")
+	for rowStart := 0; rowStart < len(g.trackNums); rowStart = rowStart + TRACK_GRID_COLUMNS {
+		for i := rowStart; i < rowStart+TRACK_GRID_COLUMNS && i < len(g.trackNums); i = i + 1 {
+			fmt.Fprintf(&sb, " %2d%s", g.trackNums[i], TRACK_STATE_SYMBOLS[g.states[g.trackNums[i]]])
+		}
+		sb.WriteString("\x1b[K\r\n")
+	}
+	sb.WriteString(" . pending  > sending  ? verifying  # done  X failed\x1b[K\r\n")
+	var elapsedSeconds float64 = time.Since(g.startTime).Seconds()
+	if commandOutput.charCount > 0 && elapsedSeconds > 0 {
+		fmt.Fprintf(&sb, " %d of %d tracks, %d characters in %s: %.0f characters/s, %.0f track bytes/s\x1b[K\r\n", g.tracksDone, len(g.trackNums), commandOutput.charCount,
+			formatRemainingTime(elapsedSeconds), float64(commandOutput.charCount)/elapsedSeconds, float64(g.tracksDone*0x1000)/elapsedSeconds)
+	} else {
+		fmt.Fprintf(&sb, " %d of %d tracks\x1b[K\r\n", g.tracksDone, len(g.trackNums))
+	}
+	var status string = g.status
+	if status == "" {
+		status = "p: pause before the next track"
+		if g.pauseRequested {
+			status = "pausing before the next track, p: go on"
+		}
+		if readBackVerification != nil {
+			if g.retryRequested {
+				status = status + ", failed tracks will be retried"
+			} else {
+				status = status + ", r: retry failed tracks at the end"
+			}
+		}
+	}
+	fmt.Fprintf(&sb, " %s\x1b[K\r\n\x1b[K\x1b8", status)
+	fmt.Fprint(g.tty, sb.String())
+	g.lastDrawTime = time.Now()
+}
+
+// handleKey acts on key pressed on the terminal.
+func (g *trackGrid) handleKey(key byte) {
+	switch key {
+	case 'p', 'P':
+		g.pauseRequested = !g.pauseRequested
+	case 'r', 'R':
+		if readBackVerification != nil {
+			g.retryRequested = true
+		}
+	}
+}
+
+// awaitKey waits for a key pressed on the terminal, and stores it into key.
+func (g *trackGrid) awaitKey(key *byte) error {
+	var pressed byte
+	var ok bool = awaitKeyKeepingAlive(&pressed, g.keys)
+	if !ok {
+		return fmt.Errorf("terminal closed while waiting for a key")
+	}
+	*key = pressed
+	return nil
+}
+
+// keepaliveInterval, when not zero, is how often a space is sent on the serial line while the transfer
+// waits on the host side, so that a bridge dropping idle connections (such as a telnet bridge or a WiFi
+// modem) keeps the session through a long pause.
+var keepaliveInterval time.Duration
+
+// awaitKeyKeepingAlive waits for a key read from keys, and stores it into key, sending a keepalive
+// space every keepaliveInterval meanwhile. It tells whether a key was read before keys was closed.
+func awaitKeyKeepingAlive(key *byte, keys chan byte) bool {
+	if keepaliveInterval <= 0 {
+		pressed, ok := <-keys
+		*key = pressed
+		return ok
+	}
+	var ticker *time.Ticker = time.NewTicker(keepaliveInterval)
+	defer ticker.Stop()
+	defer commandOutput.endKeepalive()
+	for {
+		select {
+		case pressed, ok := <-keys:
+			*key = pressed
+			return ok
+		case <-ticker.C:
+			commandOutput.writeKeepalive()
+		}
+	}
+}
+
+// shiftTransmissionClock moves the time the command stream started by pause, as if it had started that
+// much later, so that the times it is expected to take on the serial line leave out a pause.
+func shiftTransmissionClock(pause time.Duration) {
+	commandOutput.startTime = commandOutput.startTime.Add(pause)
+	var bridge *tcpPort
+	bridge, ok := commandOutput.output.(*tcpPort)
+	if ok {
+		bridge.startTime = bridge.startTime.Add(pause)
+	}
+}
+
+// updateTrackGrid updates, if there is a track grid, the state of the current progressEventTrack for a
+// progress event named eventName, after acting on the keys pressed. A track is sending from its
+// "track_started" event, verifying from its "track_verifying" event, and done or failed (on a
+// "track_failed" event) once it is finished. When a pause was asked for, the transfer waits at the
+// start of the next track until it is asked to go on.
+func updateTrackGrid(eventName string) {
+	if grid == nil {
+		return
+	}
+	for pending := true; pending; {
+		select {
+		case key, ok := <-grid.keys:
+			if ok {
+				grid.handleKey(key)
+			} else {
+				pending = false
+			}
+		default:
+			pending = false
+		}
+	}
+	if eventName == "track_started" && grid.pauseRequested {
+		grid.status = fmt.Sprintf("paused before track %d, p: go on", progressEventTrack)
+		grid.draw()
+		var pauseStartTime time.Time = time.Now()
+		for grid.pauseRequested {
+			var key byte
+			var err error = grid.awaitKey(&key)
+			if err != nil {
+				failCommandStream(err)
+				break
+			}
+			grid.handleKey(key)
+		}
+		var pause time.Duration = time.Since(pauseStartTime)
+		shiftTransmissionClock(pause)
+		grid.startTime = grid.startTime.Add(pause)
+		grid.status = ""
+	}
+	switch eventName {
+	case "track_started":
+		grid.states[progressEventTrack] = "sending"
+	case "track_verifying":
+		grid.states[progressEventTrack] = "verifying"
+	case "track_failed":
+		grid.states[progressEventTrack] = "failed"
+	case "track_finished":
+		if grid.states[progressEventTrack] != "failed" {
+			grid.states[progressEventTrack] = "done"
+			grid.tracksDone = grid.tracksDone + 1
+		}
+	case "line_sent":
+		if time.Since(grid.lastDrawTime) < TRACK_GRID_REDRAW_TIME {
+			return
+		}
+	}
+	grid.draw()
+}
+
+// awaitTrackRetry tells, when there is a track grid and some tracks failed read back verification,
+// whether they are to be sent again: right away when a retry was asked for during the transfer, and
+// otherwise once r is pressed (or not, once q is pressed). The failed tracks go back to pending.
+func awaitTrackRetry() (bool, error) {
+	if grid == nil || readBackVerification == nil || len(readBackVerification.failedTrackNums) == 0 {
+		return false, nil
+	}
+	if !grid.retryRequested {
+		grid.status = fmt.Sprintf("%d tracks failed, r: retry them, q: give up", len(readBackVerification.failedTrackNums))
+		grid.draw()
+		for !grid.retryRequested {
+			var key byte
+			var err error = grid.awaitKey(&key)
+			if err != nil {
+				return false, err
+			}
+			if key == 'q' || key == 'Q' {
+				grid.status = ""
+				grid.draw()
+				return false, nil
+			}
+			grid.handleKey(key)
+		}
+	}
+	grid.retryRequested = false
+	grid.status = ""
+	for _, trackNum := range readBackVerification.failedTrackNums {
+		grid.states[trackNum] = "pending"
+	}
+	grid.draw()
+	return true, nil
+}
+
+// finishTrackGrid, if there is a track grid, draws it a last time, gives back the whole terminal to
+// scrolling with the cursor below the messages, and restores the settings of the terminal.
+func finishTrackGrid() error {
+	if grid == nil {
+		return nil
+	}
+	grid.status = "finished"
+	grid.draw()
+	fmt.Fprintf(grid.tty, "\x1b[r\x1b[%d;1H", grid.rowCount)
+	var ignored string
+	var err error = runStty(&ignored, grid.tty, grid.sttyState)
+	grid = nil
+	return err
+}
+
+// Track grid section end
+
+// Terminal section begin
+
+// TERMINAL_SEND_KEY is the key (ctrl-]) which ends the terminal of -terminal and sends the commands, and
+// TERMINAL_QUIT_KEY the key (ctrl-\) which ends it without sending them. The other keys go to the
+// apple ][, ctrl-C and ctrl-X included.
+const TERMINAL_SEND_KEY = 0x1D
+const TERMINAL_QUIT_KEY = 0x1C
+
+// runTerminal connects the terminal the program runs in to the apple ][ through the serial line, writing
+// the keys pressed to port and showing the characters arriving on input, so that the operator can get
+// to the monitor (such as with CALL -151) before the commands are sent. It returns once the send key is
+// pressed, and returns an error once the quit key is pressed or the terminal or serial line fails. The
+// characters shown have their high bit cleared, and each carriage return ends a line.
+func runTerminal(port io.Writer, input chan byte) error {
+	tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+	if err != nil {
+		return failure.CodedErrorf(failure.KIND_OPTION_CONFLICT, "-terminal needs a terminal: %w", err)
+	}
+	defer tty.Close()
+	var sttyState, ignored string
+	err = runStty(&sttyState, tty, "-g")
+	if err == nil {
+		err = runStty(&ignored, tty, "-icanon", "-echo", "-icrnl", "-isig", "min", "1")
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "terminal connected to the apple ][: ctrl-] sends the commands, ctrl-\\ quits\n")
+	var done chan struct{} = make(chan struct{})
+	var relayDone chan struct{} = make(chan struct{})
+	go func() {
+		defer close(relayDone)
+		for {
+			select {
+			case c, ok := <-input:
+				if !ok {
+					return
+				}
+				c = c & 0x7F
+				if c == '\r' {
+					tty.Write([]byte("\r\n"))
+				} else if c != '\n' {
+					tty.Write([]byte{c})
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+	var key []byte = make([]byte, 1)
+	for {
+		_, err = tty.Read(key)
+		if err != nil {
+			err = fmt.Errorf("terminal closed before the commands were sent: %w", err)
+			break
+		}
+		if key[0] == TERMINAL_SEND_KEY {
+			break
+		}
+		if key[0] == TERMINAL_QUIT_KEY {
+			err = failure.CodedErrorf(failure.KIND_TERMINAL_QUIT, "terminal quit without sending the commands")
+			break
+		}
+		_, err = port.Write(key)
+		if err != nil {
+			err = failure.CodedErrorf(failure.KIND_WRITE_FAILED, "writing a key to the serial line failed: %w", err)
+			break
+		}
+	}
+	close(done)
+	<-relayDone
+	fmt.Fprintf(tty, "\r\n")
+	var restoreErr error = runStty(&ignored, tty, sttyState)
+	if err == nil {
+		err = restoreErr
+	}
+	return err
+}
+
+// Terminal section end
+
+// Session section begin
+
+// transferSession is the state of a transfer of several tracks (or block groups), kept in a session
+// file so that an interrupted transfer can be resumed: the disk image, its SHA-1 (after any partition
+// selection), the profile, the tracks of the transfer and those completed so far. A track is completed
+// once the serial line has had time to send its commands and the spaces covering its write, at baud
+// with bitsPerChar bits per character; pending holds the tracks whose commands have been written but
+// may not have left yet.
+type transferSession struct {
+	Image       string `json:"image"`
+	Sha1        string `json:"sha1"`
+	Profile     string `json:"profile"`
+	Tracks      []int  `json:"tracks"`
+	Completed   []int  `json:"completed"`
+	filepath    string
+	baud        int
+	bitsPerChar int
+	pending     []sessionTrack
+}
+
+// sessionTrack is a track whose commands end at charCount characters into the command stream.
+type sessionTrack struct {
+	trackNum  int
+	charCount int
+}
+
+// session is the transfer session being recorded, if there is one.
+var session *transferSession
+
+// startTransferSession starts recording the transfer of the tracks pointed to by trackNums of the
+// diskImage read from diskImageFilepath into the session file sessionFilepath. With resume, the
+// transfer is instead continued from the session recorded there, which must be of the same image and
+// profile, and the tracks it completed are removed from trackNums.
+func startTransferSession(trackNums *[]int, sessionFilepath string, diskImageFilepath string, diskImage []byte, profile string, resume bool, baud int, bitsPerChar int) error {
+	var sha1Sum [sha1.Size]byte = sha1.Sum(diskImage)
+	var imageSha1 string = hex.EncodeToString(sha1Sum[:])
+	session = &transferSession{Image: diskImageFilepath, Sha1: imageSha1, Profile: profile, Tracks: *trackNums, Completed: []int{}}
+	if resume {
+		var data []byte
+		data, err := ioutil.ReadFile(sessionFilepath)
+		if os.IsNotExist(err) {
+			return failure.CodedErrorf(failure.KIND_BAD_SESSION, "no session to resume in %s", sessionFilepath)
+		}
+		if err != nil {
+			return err
+		}
+		var recorded transferSession
+		err = json.Unmarshal(data, &recorded)
+		if err != nil {
+			return failure.CodedErrorf(failure.KIND_BAD_SESSION, "session file %s could not be read: %w", sessionFilepath, err)
+		}
+		if recorded.Sha1 != imageSha1 {
+			return failure.CodedErrorf(failure.KIND_BAD_SESSION, "session file %s is of another disk image (%s), or the image has changed since", sessionFilepath, recorded.Image)
+		}
+		if recorded.Profile != profile {
+			return failure.CodedErrorf(failure.KIND_BAD_SESSION, "session file %s is of a transfer with profile %q, not %q", sessionFilepath, recorded.Profile, profile)
+		}
+		var completed map[int]bool = make(map[int]bool)
+		for _, trackNum := range recorded.Completed {
+			completed[trackNum] = true
+		}
+		var remainingTrackNums []int
+		for _, trackNum := range *trackNums {
+			if !completed[trackNum] {
+				remainingTrackNums = append(remainingTrackNums, trackNum)
+			}
+		}
+		fmt.Fprintf(os.Stderr, "resuming session %s: %d of %d tracks completed, %d remaining\n", sessionFilepath, len(*trackNums)-len(remainingTrackNums), len(*trackNums), len(remainingTrackNums))
+		session.Tracks = recorded.Tracks
+		session.Completed = recorded.Completed
+		*trackNums = remainingTrackNums
+	}
+	session.filepath = sessionFilepath
+	session.baud = baud
+	session.bitsPerChar = bitsPerChar
+	return writeTransferSession()
+}
+
+// writeTransferSession writes the session to its session file.
+func writeTransferSession() error {
+	// a struct of strings and numbers always marshals
+	var data []byte
+	data, _ = json.MarshalIndent(session, "", "  ")
+	var err error = ioutil.WriteFile(session.filepath, append(data, '\n'), 0644)
+	if err != nil {
+		return failure.CodedErrorf(failure.KIND_BAD_SESSION, "writing the session file: %w", err)
+	}
+	return nil
+}
+
+// recordSessionProgress records in the session, if there is one, the current progressEventTrack as
+// pending on a "track_finished" progress event at charCount characters into the command stream, and
+// the pending tracks whose characters the serial line has had time to send as completed, unless they
+// failed read back verification.
+func recordSessionProgress(eventName string, charCount int) {
+	if session == nil {
+		return
+	}
+	if eventName == "track_finished" {
+		session.pending = append(session.pending, sessionTrack{trackNum: progressEventTrack, charCount: charCount})
+	}
+	var completedCount int = 0
+	// a track whose read back line has been checked is known to be written
+	for completedCount < len(session.pending) && (readBackVerification != nil || transmissionSeconds(session.pending[completedCount].charCount, session.baud, session.bitsPerChar) <= time.Since(commandOutput.startTime).Seconds()) {
+		if !hasTrackFailedVerification(session.pending[completedCount].trackNum) {
+			session.Completed = append(session.Completed, session.pending[completedCount].trackNum)
+		}
+		completedCount = completedCount + 1
+	}
+	if completedCount > 0 {
+		session.pending = session.pending[completedCount:]
+		var err error = writeTransferSession()
+		if err != nil {
+			failCommandStream(err)
+		}
+	}
+}
+
+// finishTransferSession removes the session file, if there is one, once the whole transfer is sent.
+func finishTransferSession() error {
+	if session == nil {
+		return nil
+	}
+	var err error = os.Remove(session.filepath)
+	session = nil
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// Session section end
+
+// Install process section begin
+
+// installProcess is an install run as another process by the daemon and duplicate subcommands, with
+// its progress events arriving on events, the keys of its control input sent on control, and its
+// failure, if any, reported on stderr as with -errors-json. Its other messages are written to the
+// stderr of the program, after its name.
+type installProcess struct {
+	name    string
+	cmd     *exec.Cmd
+	events  *os.File
+	control *os.File
+	stderr  io.ReadCloser
+}
+
+// checkInstallArgs returns an error when the arguments args of an install run as another process hold
+// one of the flags reserved, which are given to it by the program running it.
+func checkInstallArgs(args []string, reserved []string) error {
+	if len(args) == 0 {
+		return failure.CodedErrorf(failure.KIND_BAD_TRANSFER_ARGS, "a transfer needs the arguments of install")
+	}
+	for _, arg := range args {
+		var name string = strings.SplitN(strings.TrimLeft(arg, "-"), "=", 2)[0]
+		for _, reservedName := range reserved {
+			if strings.HasPrefix(arg, "-") && name == reservedName {
+				return failure.CodedErrorf(failure.KIND_BAD_TRANSFER_ARGS, "-%s is given to the transfers by the program running them", reservedName)
+			}
+		}
+	}
+	return nil
+}
+
+// transferLine returns the serial port or TCP bridge the install arguments args send to, or an empty
+// string when they send to stdout.
+func transferLine(args []string) string {
+	for i, arg := range args {
+		var name string = strings.TrimLeft(arg, "-")
+		if !strings.HasPrefix(arg, "-") {
+			continue
+		}
+		if (name == "port" || name == "tcp") && i+1 < len(args) {
+			return args[i+1]
+		}
+		if strings.HasPrefix(name, "port=") || strings.HasPrefix(name, "tcp=") {
+			return strings.SplitN(name, "=", 2)[1]
+		}
+	}
+	return ""
+}
+
+// startInstallProcess starts into process install with args, and the transfer session
+// sessionFilepath, which is resumed when resume is set and the session file is there, naming the
+// process name in its messages.
+func startInstallProcess(process *installProcess, args []string, sessionFilepath string, resume bool, name string) error {
+	executable, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	var installArgs []string = []string{"install", "-quiet", "-errors-json", "-session", sessionFilepath, "-events-fd", "3", "-control-fd", "4"}
+	_, err = os.Stat(sessionFilepath)
+	if resume && err == nil {
+		installArgs = append(installArgs, "-resume")
+	}
+	process.name = name
+	process.cmd = exec.Command(executable, append(installArgs, args...)...)
+	process.cmd.Stdout = os.Stdout
+	process.stderr, err = process.cmd.StderrPipe()
+	if err != nil {
+		return err
+	}
+	eventsReader, eventsWriter, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	controlReader, controlWriter, err := os.Pipe()
+	if err != nil {
+		eventsReader.Close()
+		eventsWriter.Close()
+		return err
+	}
+	process.cmd.ExtraFiles = []*os.File{eventsWriter, controlReader}
+	err = process.cmd.Start()
+	eventsWriter.Close()
+	controlReader.Close()
+	if err != nil {
+		eventsReader.Close()
+		controlWriter.Close()
+		return err
+	}
+	process.events = eventsReader
+	process.control = controlWriter
+	return nil
+}
+
+// wait passes each progress event of the process to onEvent until it ends, and returns the failure it
+// reported, if any.
+func (process *installProcess) wait(onEvent func(event progressEvent)) error {
+	defer process.control.Close()
+	var report failure.ErrorReport
+	var stderrDone chan bool = make(chan bool)
+	go func() {
+		// the failure, if any, is the line of JSON
+		var scanner *bufio.Scanner = bufio.NewScanner(process.stderr)
+		for scanner.Scan() {
+			if json.Unmarshal(scanner.Bytes(), &report) != nil {
+				fmt.Fprintf(os.Stderr, "%s: %s\n", process.name, scanner.Text())
+			}
+		}
+		stderrDone <- true
+	}()
+	var scanner *bufio.Scanner = bufio.NewScanner(process.events)
+	for scanner.Scan() {
+		var event progressEvent
+		if json.Unmarshal(scanner.Bytes(), &event) == nil {
+			onEvent(event)
+		}
+	}
+	process.events.Close()
+	<-stderrDone
+	var err error = process.cmd.Wait()
+	if err != nil && report.Message != "" {
+		return errors.New(report.Message)
+	}
+	return err
+}
+
+// Install process section end
+
+// Transfer queue section begin
+
+// QUEUE_RESERVED_FLAGS are the flags of install the daemon gives the transfers of its queue itself,
+// which the arguments of a transfer may not hold.
+var QUEUE_RESERVED_FLAGS []string = []string{"session", "resume", "events", "events-fd", "control-fd", "tui", "terminal", "quiet", "errors-json", "debug"}
+
+// DUPLICATE_RESERVED_FLAGS are the flags of install duplicate gives each of its copies itself.
+var DUPLICATE_RESERVED_FLAGS []string = append([]string{"port", "tcp"}, QUEUE_RESERVED_FLAGS...)
+
+// QUEUE_TUI_ROWS is the count of transfers shown in the queue status on the terminal.
+const QUEUE_TUI_ROWS = 10
+
+// queuedTransfer is a transfer of the transfer queue: the arguments of the install subcommand
+// carrying it out, and its state: queued (waiting for its turn), running, pausing (running, and
+// asked to pause before its next track), paused (at a track boundary when it was running, or held
+// back when it was queued), done or failed. Track, TracksDone and TrackCount follow its progress
+// events and session file while it runs, and Message holds the failure it reported. busy tells
+// whether it is being run, and control sends the control keys of the install running it.
+type queuedTransfer struct {
+	Id         int      `json:"id"`
+	Args       []string `json:"args"`
+	State      string   `json:"state"`
+	Track      int      `json:"track"`
+	TracksDone int      `json:"tracks_done"`
+	TrackCount int      `json:"track_count"`
+	Message    string   `json:"message,omitempty"`
+	busy       bool
+	control    io.Writer
+}
+
+// transferQueue is the queue of transfers served by the daemon subcommand, run in the order of
+// Transfers, one at a time on each serial line, recorded in the queue file at filepath on every change so that it outlives
+// the daemon. mutex guards it, wake is signalled when a transfer may have become ready to run, and
+// changed is called on every change, with mutex held, to show the new state. When keyFilepath is set,
+// the queue file is encrypted with its key, and the transfers are given it to decrypt their images.
+type transferQueue struct {
+	NextId      int               `json:"next_id"`
+	Transfers   []*queuedTransfer `json:"transfers"`
+	filepath    string
+	keyFilepath string
+	key         []byte
+	mutex       sync.Mutex
+	wake        chan bool
+	changed     func()
+}
+
+// readTransferQueue sets up queue from the queue file queueFilepath, or as an empty queue recorded
+// there when there is no such file, encrypted with the key in keyFilepath unless it is empty. The
+// transfers which were running when the daemon stopped are queued again, to be resumed from their
+// sessions.
+func readTransferQueue(queue *transferQueue, queueFilepath string, keyFilepath string) error {
+	queue.filepath = queueFilepath
+	queue.keyFilepath = keyFilepath
+	if keyFilepath != "" {
+		var err error = readEncryptionKey(&queue.key, keyFilepath)
+		if err != nil {
+			return err
+		}
+	}
+	queue.wake = make(chan bool, 1)
+	queue.changed = func() {}
+	queue.NextId = 1
+	queue.Transfers = []*queuedTransfer{}
+	data, err := ioutil.ReadFile(queueFilepath)
+	if os.IsNotExist(err) {
+		return queue.write()
+	}
+	if err != nil {
+		return err
+	}
+	if isEncryptedData(data) {
+		if queue.key == nil {
+			return failure.CodedErrorf(failure.KIND_ENCRYPTION, "queue file %s is encrypted, and no key file was given (-key-file)", queueFilepath)
+		}
+		err = decryptData(&data, data, queue.key, queueFilepath)
+		if err != nil {
+			return err
+		}
+	}
+	err = json.Unmarshal(data, queue)
+	if err != nil {
+		return failure.CodedErrorf(failure.KIND_BAD_QUEUE, "queue file %s could not be read: %w", queueFilepath, err)
+	}
+	for _, transfer := range queue.Transfers {
+		if transfer.State == "running" || transfer.State == "pausing" {
+			transfer.State = "queued"
+		}
+	}
+	return queue.write()
+}
+
+// write records the queue in its queue file, encrypted when it has a key, and shows its new state. It
+// is called with mutex held.
+func (queue *transferQueue) write() error {
+	// a struct of strings and numbers always marshals
+	var data []byte
+	data, _ = json.MarshalIndent(queue, "", "  ")
+	data = append(data, '\n')
+	var err error
+	if queue.key != nil {
+		err = encryptData(&data, data, queue.key)
+	}
+	if err == nil {
+		err = ioutil.WriteFile(queue.filepath, data, 0644)
+	}
+	queue.changed()
+	if err != nil {
+		return failure.CodedErrorf(failure.KIND_BAD_QUEUE, "writing the queue file: %w", err)
+	}
+	return nil
+}
+
+// sessionFilepath returns the session file of the transfer of the queue.
+func (queue *transferQueue) sessionFilepath(transfer *queuedTransfer) string {
+	return fmt.Sprintf("%s.%d.session", queue.filepath, transfer.Id)
+}
+
+// signal wakes up the goroutine running the transfers of the queue, if it is waiting.
+func (queue *transferQueue) signal() {
+	select {
+	case queue.wake <- true:
+	default:
+	}
+}
+
+// find stores into transfer the transfer of the queue numbered id. It is called with mutex held.
+func (queue *transferQueue) find(transfer **queuedTransfer, id int) error {
+	for _, queued := range queue.Transfers {
+		if queued.Id == id {
+			*transfer = queued
+			return nil
+		}
+	}
+	return failure.CodedErrorf(failure.KIND_TRANSFER_NOT_FOUND, "no transfer %d in the queue", id)
+}
+
+// enqueue adds a transfer carrying out install with args to the end of the queue, storing it into
+// transfer. The flags the daemon gives install itself are refused.
+func (queue *transferQueue) enqueue(transfer **queuedTransfer, args []string) error {
+	var err error = checkInstallArgs(args, QUEUE_RESERVED_FLAGS)
+	if err != nil {
+		return err
+	}
+	queue.mutex.Lock()
+	defer queue.mutex.Unlock()
+	*transfer = &queuedTransfer{Id: queue.NextId, Args: args, State: "queued"}
+	queue.NextId = queue.NextId + 1
+	queue.Transfers = append(queue.Transfers, *transfer)
+	queue.signal()
+	return queue.write()
+}
+
+// pause asks the transfer numbered id to pause before its next track when it is running, or holds it
+// back when it is queued.
+func (queue *transferQueue) pause(id int) error {
+	queue.mutex.Lock()
+	defer queue.mutex.Unlock()
+	var transfer *queuedTransfer
+	var err error = queue.find(&transfer, id)
+	if err != nil {
+		return err
+	}
+	switch transfer.State {
+	case "queued":
+		transfer.State = "paused"
+	case "running":
+		_, err = transfer.control.Write([]byte("p"))
+		if err != nil {
+			return err
+		}
+		transfer.State = "pausing"
+	case "pausing", "paused":
+	default:
+		return failure.CodedErrorf(failure.KIND_TRANSFER_STATE, "transfer %d is %s, and cannot be paused", id, transfer.State)
+	}
+	return queue.write()
+}
+
+// resume lets the paused transfer numbered id go on, or takes back the pause asked of it, and queues
+// again a transfer held back or failed, which continues from its session.
+func (queue *transferQueue) resume(id int) error {
+	queue.mutex.Lock()
+	defer queue.mutex.Unlock()
+	var transfer *queuedTransfer
+	var err error = queue.find(&transfer, id)
+	if err != nil {
+		return err
+	}
+	if (transfer.State == "paused" || transfer.State == "pausing") && transfer.control != nil {
+		_, err = transfer.control.Write([]byte("p"))
+		if err != nil {
+			return err
+		}
+		transfer.State = "running"
+	} else if transfer.State == "paused" || transfer.State == "failed" {
+		transfer.State = "queued"
+		transfer.Message = ""
+		queue.signal()
+	} else if transfer.State != "queued" && transfer.State != "running" {
+		return failure.CodedErrorf(failure.KIND_TRANSFER_STATE, "transfer %d is %s, and cannot be resumed", id, transfer.State)
+	}
+	return queue.write()
+}
+
+// move moves the transfer numbered id to position (counting from 1) in the queue, or to its end when
+// position is past it.
+func (queue *transferQueue) move(id int, position int) error {
+	queue.mutex.Lock()
+	defer queue.mutex.Unlock()
+	if position < 1 {
+		return failure.CodedErrorf(failure.KIND_BAD_TRANSFER_ARGS, "illegal queue position encountered: %d", position)
+	}
+	var transfer *queuedTransfer
+	var err error = queue.find(&transfer, id)
+	if err != nil {
+		return err
+	}
+	var transfers []*queuedTransfer
+	for _, queued := range queue.Transfers {
+		if queued != transfer {
+			transfers = append(transfers, queued)
+		}
+	}
+	if position > len(transfers) {
+		position = len(transfers) + 1
+	}
+	transfers = append(transfers[:position-1], append([]*queuedTransfer{transfer}, transfers[position-1:]...)...)
+	queue.Transfers = transfers
+	return queue.write()
+}
+
+// remove takes the transfer numbered id off the queue, with its session, unless it is running.
+func (queue *transferQueue) remove(id int) error {
+	queue.mutex.Lock()
+	defer queue.mutex.Unlock()
+	var transfer *queuedTransfer
+	var err error = queue.find(&transfer, id)
+	if err != nil {
+		return err
+	}
+	if transfer.control != nil {
+		return failure.CodedErrorf(failure.KIND_TRANSFER_STATE, "transfer %d is %s, and cannot be removed until it ends", id, transfer.State)
+	}
+	var transfers []*queuedTransfer = []*queuedTransfer{}
+	for _, queued := range queue.Transfers {
+		if queued != transfer {
+			transfers = append(transfers, queued)
+		}
+	}
+	queue.Transfers = transfers
+	err = os.Remove(queue.sessionFilepath(transfer))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return queue.write()
+}
+
+// runTransfers runs the transfers of the queue as they become ready, in queue order, one at a time
+// on each serial line (or TCP bridge, or stdout), for as long as the daemon runs.
+func (queue *transferQueue) runTransfers() {
+	for {
+		queue.mutex.Lock()
+		var busyLines map[string]bool = make(map[string]bool)
+		for _, transfer := range queue.Transfers {
+			if transfer.busy {
+				busyLines[transferLine(transfer.Args)] = true
+			}
+		}
+		for _, transfer := range queue.Transfers {
+			var line string = transferLine(transfer.Args)
+			if transfer.State != "queued" || transfer.busy || busyLines[line] {
+				continue
+			}
+			busyLines[line] = true
+			transfer.busy = true
+			go func(transfer *queuedTransfer) {
+				var err error = queue.runTransfer(transfer)
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "transfer %d: %s\n", transfer.Id, err)
+				}
+				queue.mutex.Lock()
+				transfer.busy = false
+				queue.mutex.Unlock()
+				queue.signal()
+			}(transfer)
+		}
+		queue.mutex.Unlock()
+		<-queue.wake
+	}
+}
+
+// runTransfer carries out the transfer with install run as another process, in its own session, and
+// records its state as it goes, and as it ends: done, or failed with the failure it reported. A
+// transfer whose session file is left from before is resumed.
+func (queue *transferQueue) runTransfer(transfer *queuedTransfer) error {
+	var process installProcess
+	queue.mutex.Lock()
+	var args []string = transfer.Args
+	if queue.keyFilepath != "" {
+		args = append([]string{"-key-file", queue.keyFilepath}, args...)
+	}
+	var err error = startInstallProcess(&process, args, queue.sessionFilepath(transfer), true, fmt.Sprintf("transfer %d", transfer.Id))
+	if err != nil {
+		transfer.State = "failed"
+		transfer.Message = err.Error()
+		err = queue.write()
+		queue.mutex.Unlock()
+		return err
+	}
+	transfer.State = "running"
+	transfer.control = process.control
+	queue.write()
+	queue.mutex.Unlock()
+	err = process.wait(func(event progressEvent) {
+		queue.mutex.Lock()
+		queue.recordTransferEvent(transfer, event)
+		queue.mutex.Unlock()
+	})
+	queue.mutex.Lock()
+	defer queue.mutex.Unlock()
+	transfer.control = nil
+	transfer.State = "done"
+	if err != nil {
+		transfer.State = "failed"
+		transfer.Message = err.Error()
+	}
+	return queue.write()
+}
+
+// recordTransferEvent updates the state of the running transfer for its progress event, with the
+// tracks of its session file. It is called with mutex held.
+func (queue *transferQueue) recordTransferEvent(transfer *queuedTransfer, event progressEvent) {
+	switch event.Event {
+	case "track_paused":
+		transfer.State = "paused"
+	case "track_started":
+		if transfer.State == "paused" {
+			transfer.State = "running"
+		}
+	case "track_finished":
+	default:
+		return
+	}
+	transfer.Track = event.Track
+	var recorded transferSession
+	data, err := ioutil.ReadFile(queue.sessionFilepath(transfer))
+	if err == nil && json.Unmarshal(data, &recorded) == nil {
+		transfer.TrackCount = len(recorded.Tracks)
+		transfer.TracksDone = len(recorded.Completed)
+	}
+	queue.write()
+}
+
+// ServeHTTP implements http.Handler, serving the HTTP API of the queue: GET /transfers lists the
+// transfers, POST /transfers with {"args": [...]} enqueues one carrying out install with those
+// arguments, GET /transfers/id shows one, POST /transfers/id/pause, /resume and /move with
+// {"position": n} act on one, and DELETE /transfers/id removes one. Replies are JSON, failures being
+// reported as with -errors-json.
+func (queue *transferQueue) ServeHTTP(response http.ResponseWriter, request *http.Request) {
+	var names []string = strings.Split(strings.Trim(request.URL.Path, "/"), "/")
+	var err error
+	if names[0] != "transfers" || len(names) > 3 {
+		err = failure.CodedErrorf(failure.KIND_TRANSFER_NOT_FOUND, "no such resource: %s", request.URL.Path)
+	} else if len(names) == 1 && request.Method == http.MethodGet {
+		queue.mutex.Lock()
+		defer queue.mutex.Unlock()
+		writeJsonReply(response, http.StatusOK, queue.Transfers)
+		return
+	} else if len(names) == 1 && request.Method == http.MethodPost {
+		var body struct {
+			Args []string `json:"args"`
+		}
+		err = json.NewDecoder(request.Body).Decode(&body)
+		if err != nil {
+			err = failure.CodedErrorf(failure.KIND_BAD_TRANSFER_ARGS, "the request is not a JSON object with args: %w", err)
+		} else {
+			var transfer *queuedTransfer
+			err = queue.enqueue(&transfer, body.Args)
+			if err == nil {
+				queue.mutex.Lock()
+				defer queue.mutex.Unlock()
+				writeJsonReply(response, http.StatusCreated, transfer)
+				return
+			}
+		}
+	} else if len(names) == 1 {
+		err = failure.CodedErrorf(failure.KIND_BAD_TRANSFER_ARGS, "%s /transfers is not served", request.Method)
+	} else {
+		err = queue.serveTransfer(response, request, names[1:])
+		if err == nil {
+			return
+		}
+	}
+	var report failure.ErrorReport = failure.ClassifyFailure(err)
+	var status int = http.StatusBadRequest
+	if report.Code == failure.KIND_TRANSFER_NOT_FOUND.Code {
+		status = http.StatusNotFound
+	} else if report.Code == failure.KIND_TRANSFER_STATE.Code {
+		status = http.StatusConflict
+	} else if report.Code != failure.KIND_BAD_TRANSFER_ARGS.Code {
+		status = http.StatusInternalServerError
+	}
+	writeJsonReply(response, status, report)
+}
+
+// serveTransfer serves the requests of the HTTP API for one transfer, whose number and action (if
+// any) are given by names, replying with the transfer unless it returns an error.
+func (queue *transferQueue) serveTransfer(response http.ResponseWriter, request *http.Request, names []string) error {
+	id, err := strconv.Atoi(names[0])
+	if err != nil {
+		return failure.CodedErrorf(failure.KIND_TRANSFER_NOT_FOUND, "no transfer %s in the queue", names[0])
+	}
+	var action string = request.Method
+	if len(names) == 2 {
+		action = request.Method + " " + names[1]
+	}
+	switch action {
+	case http.MethodGet:
+	case http.MethodDelete:
+		err = queue.remove(id)
+		if err == nil {
+			response.WriteHeader(http.StatusNoContent)
+		}
+		return err
+	case "POST pause":
+		err = queue.pause(id)
+	case "POST resume":
+		err = queue.resume(id)
+	case "POST move":
+		var body struct {
+			Position int `json:"position"`
+		}
+		err = json.NewDecoder(request.Body).Decode(&body)
+		if err != nil {
+			return failure.CodedErrorf(failure.KIND_BAD_TRANSFER_ARGS, "the request is not a JSON object with position: %w", err)
+		}
+		err = queue.move(id, body.Position)
+	default:
+		return failure.CodedErrorf(failure.KIND_BAD_TRANSFER_ARGS, "%s %s is not served", request.Method, request.URL.Path)
+	}
+	if err != nil {
+		return err
+	}
+	queue.mutex.Lock()
+	defer queue.mutex.Unlock()
+	var transfer *queuedTransfer
+	err = queue.find(&transfer, id)
+	if err != nil {
+		return err
+	}
+	writeJsonReply(response, http.StatusOK, transfer)
+	return nil
+}
+
+// writeJsonReply writes value as the JSON body of an HTTP reply with status.
+func writeJsonReply(response http.ResponseWriter, status int, value interface{}) {
+	response.Header().Set("Content-Type", "application/json")
+	response.WriteHeader(status)
+	// the transfers and error reports are strings and numbers, which always marshal
+	var data []byte
+	data, _ = json.MarshalIndent(value, "", "  ")
+	response.Write(append(data, '\n'))
+}
+
+// showQueueStatus shows the state of the transfers of the queue at the top of the terminal tty, kept
+// there while the other messages scroll below it, along with the address the HTTP API is served at.
+// It is called with mutex held.
+func (queue *transferQueue) showQueueStatus(tty *os.File, address string) {
+	var sb strings.Builder
+	sb.WriteString("\x1b7\x1b[1;1H")
+	fmt.Fprintf(&sb, " transfer queue %s, served at http://%s/transfers\x1b[K\r\n", queue.filepath, address)
+	fmt.Fprintf(&sb, " %4s  %-8s %-9s %s\x1b[K\r\n", "id", "state", "tracks", "arguments")
+	for i := 0; i < QUEUE_TUI_ROWS; i = i + 1 {
+		if i == QUEUE_TUI_ROWS-1 && len(queue.Transfers) > QUEUE_TUI_ROWS {
+			fmt.Fprintf(&sb, " ... and %d more\x1b[K\r\n", len(queue.Transfers)-i)
+		} else if i < len(queue.Transfers) {
+			var transfer *queuedTransfer = queue.Transfers[i]
+			var tracks string
+			if transfer.TrackCount > 0 {
+				tracks = fmt.Sprintf("%d/%d", transfer.TracksDone, transfer.TrackCount)
+			}
+			var detail string = strings.Join(transfer.Args, " ")
+			if transfer.Message != "" {
+				detail = detail + ": " + transfer.Message
+			}
+			fmt.Fprintf(&sb, " %4d  %-8s %-9s %s\x1b[K\r\n", transfer.Id, transfer.State, tracks, detail)
+		} else {
+			sb.WriteString("\x1b[K\r\n")
+		}
+	}
+	sb.WriteString("\x1b[K\x1b8")
+	fmt.Fprint(tty, sb.String())
+}
+
+// Transfer queue section end
+
+// Session manifest section begin
+
+// sessionManifest is a session read from a JSON manifest: the steps carried out one after another,
+// and the flags given to install in each install step, such as those of the serial line.
+type sessionManifest struct {
+	InstallArgs []string      `json:"install_args"`
+	Steps       []sessionStep `json:"steps"`
+}
+
+// sessionStep is a step of a session: an install with the arguments Install, or the subcommand and
+// arguments Run, named Name in messages. Prompt, when given, is shown before the step, which waits
+// for the operator to press return, such as to put in another disk.
+type sessionStep struct {
+	Name    string   `json:"name"`
+	Prompt  string   `json:"prompt"`
+	Install []string `json:"install"`
+	Run     []string `json:"run"`
+}
+
+// sessionProgress is the state of a session recorded in its state file: the SHA-256 of the manifest
+// it is of, and the count of its steps completed.
+type sessionProgress struct {
+	Manifest  string `json:"manifest"`
+	Completed int    `json:"completed"`
+}
+
+// SESSION_STEP_SUBCOMMANDS are the subcommands a run step of a session may carry out. The others are
+// left out as they run until stopped, are interactive, or are sessions themselves.
+var SESSION_STEP_SUBCOMMANDS []string = []string{"dump", "undump", "check", "convert", "split", "join", "dos-master", "bootify", "add", "extract", "cmp", "ymodem", "zmodem", "xmodem", "apple3-client", "apple3", "catalog", "fsck", "diff", "hexdump", "poke", "hash", "verify", "duplicate", "calibrate"}
+
+// readSessionManifest fills manifest with the session in the JSON file manifestFilepath, and digest
+// with the SHA-256 of the file in hex. It returns an error when the file cannot be read, holds fields
+// other than those of sessionManifest, or a step which does not have exactly one of install and run,
+// installs with flags reserved for the session, or runs a subcommand not in SESSION_STEP_SUBCOMMANDS.
+func readSessionManifest(manifest *sessionManifest, digest *string, manifestFilepath string) error {
+	data, err := ioutil.ReadFile(manifestFilepath)
+	if err != nil {
+		return err
+	}
+	var decoder *json.Decoder = json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	err = decoder.Decode(manifest)
+	if err != nil {
+		return failure.CodedErrorf(failure.KIND_BAD_SESSION_MANIFEST, "manifest %s could not be read: %w", manifestFilepath, err)
+	}
+	if len(manifest.Steps) == 0 {
+		return failure.CodedErrorf(failure.KIND_BAD_SESSION_MANIFEST, "manifest %s has no steps", manifestFilepath)
+	}
+	for i, step := range manifest.Steps {
+		var stepName string = fmt.Sprintf("step %d", i+1)
+		if step.Name != "" {
+			stepName = fmt.Sprintf("step %d (%s)", i+1, step.Name)
+		}
+		if (len(step.Install) == 0) == (len(step.Run) == 0) {
+			return failure.CodedErrorf(failure.KIND_BAD_SESSION_MANIFEST, "%s of manifest %s needs either install or run", stepName, manifestFilepath)
+		}
+		if len(step.Install) > 0 {
+			err = checkInstallArgs(append(append([]string{}, manifest.InstallArgs...), step.Install...), QUEUE_RESERVED_FLAGS)
+			if err != nil {
+				return failure.CodedErrorf(failure.KIND_BAD_SESSION_MANIFEST, "%s of manifest %s: %w", stepName, manifestFilepath, err)
+			}
+			continue
+		}
+		var isStepSubcommand bool = false
+		for _, name := range SESSION_STEP_SUBCOMMANDS {
+			isStepSubcommand = isStepSubcommand || step.Run[0] == name
+		}
+		if !isStepSubcommand {
+			return failure.CodedErrorf(failure.KIND_BAD_SESSION_MANIFEST, "%s of manifest %s runs %s, which is not one of the subcommands of a session: %s", stepName, manifestFilepath, step.Run[0], strings.Join(SESSION_STEP_SUBCOMMANDS, ", "))
+		}
+	}
+	var sum [sha256.Size]byte = sha256.Sum256(data)
+	*digest = hex.EncodeToString(sum[:])
+	return nil
+}
+
+// writeSessionProgress records progress in the state file stateFilepath.
+func writeSessionProgress(progress sessionProgress, stateFilepath string) error {
+	// a struct of strings and numbers always marshals
+	var data []byte
+	data, _ = json.MarshalIndent(progress, "", "  ")
+	return ioutil.WriteFile(stateFilepath, append(data, '\n'), 0644)
+}
+
+// runSessionStep carries out step number stepNum of manifest: an install run as another process, in
+// its session file sessionFilepath which is resumed when resume is set, or the subcommand of a run
+// step run as another process on the stdin, stdout and stderr of the program. It returns the failure
+// of the step, if any.
+func runSessionStep(manifest *sessionManifest, stepNum int, sessionFilepath string, resume bool) error {
+	var step sessionStep = manifest.Steps[stepNum-1]
+	if len(step.Install) > 0 {
+		var process installProcess
+		var err error = startInstallProcess(&process, append(append([]string{}, manifest.InstallArgs...), step.Install...), sessionFilepath, resume, fmt.Sprintf("step %d", stepNum))
+		if err != nil {
+			return err
+		}
+		return process.wait(func(event progressEvent) {
+			if event.Event == "track_finished" {
+				fmt.Fprintf(os.Stderr, "step %d: track %d sent\n", stepNum, event.Track)
+			} else if event.Event == "track_failed" {
+				fmt.Fprintf(os.Stderr, "step %d: track %d failed\n", stepNum, event.Track)
+			}
+		})
+	}
+	executable, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	var cmd *exec.Cmd = exec.Command(executable, step.Run...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
+
+// Session manifest section end
+
+// Browse section begin
+
+// swappedSectorNum returns the sector number which sectorNum is exchanged with by the ProDOS to DOS3.3
+// sector shuffle. The shuffle only swaps pairs of sectors, so the same mapping serves both ways.
+func swappedSectorNum(sectorNum int) int {
+	if sectorNum == 0x00 || sectorNum == 0x0F {
+		return sectorNum
+	}
+	return 0x0F - sectorNum
+}
+
+// markSectorOwner records owner as the owner of sector sector of track track (in DOS3.3 sector order)
+// in sectorOwners, unless an owner was already recorded for it.
+func markSectorOwner(sectorOwners []string, track int, sector int, owner string) {
+	if track < 0 || track >= 0x23 || sector < 0 || sector > 0x0F {
+		return
+	}
+	if sectorOwners[track*0x10+sector] == "" {
+		sectorOwners[track*0x10+sector] = owner
+	}
+}
+
+// markProdosBlockOwner records owner as the owner of both sectors holding block blockNum of a ProDOS
+// floppy, and of the blocks referenced by it when indexLevel is 1 (index block) or 2 (master index
+// block).
+func markProdosBlockOwner(sectorOwners []string, diskImage []byte, blockNum int, indexLevel int, owner string) {
+	if blockNum == 0 || (blockNum+1)*diskimage.PRODOS_BLOCK_SIZE > len(diskImage) {
+		return
+	}
+	var track int = blockNum / 8
+	var sector int = (blockNum % 8) * 2
+	if sectorOwners[track*0x10+swappedSectorNum(sector)] != "" {
+		// already visited, which also stops a loop in damaged index blocks
+		return
+	}
+	markSectorOwner(sectorOwners, track, swappedSectorNum(sector), owner)
+	markSectorOwner(sectorOwners, track, swappedSectorNum(sector+1), owner)
+	if indexLevel == 0 {
+		return
+	}
+	var block []byte = diskimage.ProdosBlockOfImage(diskImage, blockNum)
+	for i := 0; i < 0x0100; i = i + 1 {
+		markProdosBlockOwner(sectorOwners, diskImage, int(block[i])|int(block[0x0100+i])<<8, indexLevel-1, owner)
+	}
+}
+
+// markProdosDirectoryOwners records the owners of the blocks of the directory with key block
+// keyBlockNum (named directoryPath) in the ProDOS ordered floppy diskImage, and of the blocks of every
+// file and subdirectory listed in it. Subdirectories whose key block is in visitedKeyBlocks, the
+// directories already recorded (which a damaged image can link into a loop), are not walked again, and
+// the walk of a damaged directory stops where the damage is found.
+func markProdosDirectoryOwners(sectorOwners []string, diskImage []byte, keyBlockNum int, directoryPath string, visitedKeyBlocks map[int]bool) {
+	visitedKeyBlocks[keyBlockNum] = true
+	filesystem.ForEachProdosDirectoryEntry(diskImage, keyBlockNum, func(entry []byte, blockNum int) bool {
+		markProdosBlockOwner(sectorOwners, diskImage, blockNum, 0, directoryPath)
+		var storageType byte = entry[0x00] >> 4
+		var entryKeyBlockNum int = filesystem.ProdosEntryKeyBlockNum(entry)
+		var entryPath string = directoryPath + "/" + filesystem.ProdosEntryName(entry)
+		if storageType >= 0x01 && storageType <= 0x03 {
+			markProdosBlockOwner(sectorOwners, diskImage, entryKeyBlockNum, int(storageType)-1, entryPath)
+		} else if storageType == 0x05 {
+			// extended (forked) file: only the key block holding the fork entries is followed
+			markProdosBlockOwner(sectorOwners, diskImage, entryKeyBlockNum, 0, entryPath)
+		} else if storageType == 0x0D && !visitedKeyBlocks[entryKeyBlockNum] {
+			markProdosDirectoryOwners(sectorOwners, diskImage, entryKeyBlockNum, entryPath, visitedKeyBlocks)
+		}
+		return true
+	})
+}
+
+// markDos33SectorOwners records the owners of the VTOC, the catalog sectors, and the track/sector lists
+// and data sectors of every file of the DOS 3.3 diskImage (in DOS3.3 sector order). The sectors of
+// tracks 0 through 2 not owned by a file are recorded as the DOS image.
+func markDos33SectorOwners(sectorOwners []string, diskImage []byte) {
+	markSectorOwner(sectorOwners, 0x11, 0x00, "VTOC")
+	var vtoc []byte = diskimage.Dos33SectorOfImage(diskImage, 0x11, 0x00)
+	var catalogTrack int = int(vtoc[0x01])
+	var catalogSector int = int(vtoc[0x02]) & 0x0F
+	var visitedSectors int = 0
+	for catalogTrack != 0 && catalogTrack < 0x23 && visitedSectors < 0x23*0x10 {
+		markSectorOwner(sectorOwners, catalogTrack, catalogSector, "catalog")
+		var catalogSectorData []byte = diskimage.Dos33SectorOfImage(diskImage, catalogTrack, catalogSector)
+		for entryPos := 0x0B; entryPos+0x23 <= 0x0100; entryPos = entryPos + 0x23 {
+			var tsListTrack int = int(catalogSectorData[entryPos])
+			var tsListSector int = int(catalogSectorData[entryPos+0x01]) & 0x0F
+			if tsListTrack == 0x00 || tsListTrack == 0xFF {
+				// unused or deleted entry
+				continue
+			}
+			var fileName string = strings.TrimRight(string(filesystem.StripHighBits(catalogSectorData[entryPos+0x03:entryPos+0x21])), " ")
+			for tsListTrack != 0 && tsListTrack < 0x23 && visitedSectors < 0x23*0x10 {
+				markSectorOwner(sectorOwners, tsListTrack, tsListSector, fileName)
+				var tsList []byte = diskimage.Dos33SectorOfImage(diskImage, tsListTrack, tsListSector)
+				for pairPos := 0x0C; pairPos < 0x0100; pairPos = pairPos + 2 {
+					if tsList[pairPos] != 0 {
+						markSectorOwner(sectorOwners, int(tsList[pairPos]), int(tsList[pairPos+1])&0x0F, fileName)
+					}
+				}
+				tsListTrack = int(tsList[0x01])
+				tsListSector = int(tsList[0x02]) & 0x0F
+				visitedSectors = visitedSectors + 1
+			}
+		}
+		catalogTrack = int(catalogSectorData[0x01])
+		catalogSector = int(catalogSectorData[0x02]) & 0x0F
+		visitedSectors = visitedSectors + 1
+	}
+	for track := 0x00; track < 0x03; track = track + 1 {
+		for sector := 0x00; sector < 0x10; sector = sector + 1 {
+			markSectorOwner(sectorOwners, track, sector, "DOS image")
+		}
+	}
+}
+
+// mapSectorOwners fills sectorOwners (indexed by track * 16 + sector, in DOS3.3 sector order) with the
+// name of the file or structure owning each sector of the floppy diskImage (in ProDOS sector order),
+// leaving free or unknown sectors empty. A ProDOS volume is mapped when the image holds one, and
+// otherwise the image is treated as a DOS 3.3 disk when it holds a DOS 3.3 VTOC.
+func mapSectorOwners(sectorOwners *[]string, diskImage []byte) error {
+	*sectorOwners = make([]string, 0x23*0x10)
+	var volumeName string
+	var totalBlocks int
+	if filesystem.ReadProdosVolumeHeader(&volumeName, &totalBlocks, diskImage, 0) {
+		// a block number of 0 stands for no block in index blocks, so block 0 is marked directly
+		markSectorOwner(*sectorOwners, 0x00, 0x00, "boot blocks")
+		markSectorOwner(*sectorOwners, 0x00, swappedSectorNum(0x01), "boot blocks")
+		markProdosBlockOwner(*sectorOwners, diskImage, 1, 0, "boot blocks")
+		var volumeHeader []byte = diskimage.ProdosBlockOfImage(diskImage, 0x02)
+		var bitmapBlockNum int = int(volumeHeader[0x04+0x23]) | int(volumeHeader[0x04+0x24])<<8
+		markProdosDirectoryOwners(*sectorOwners, diskImage, 0x02, "/"+volumeName, make(map[int]bool))
+		for blockNum := bitmapBlockNum; blockNum <= bitmapBlockNum+(totalBlocks-1)/0x1000; blockNum = blockNum + 1 {
+			markProdosBlockOwner(*sectorOwners, diskImage, blockNum, 0, "volume bitmap")
+		}
+		return nil
+	}
+	var dos33Image []byte
+	var err error = diskimage.ReorderedDiskImageSectors(&dos33Image, diskImage, diskimage.SECTOR_ORDER_PRODOS, diskimage.SECTOR_ORDER_DOS)
+	if err != nil {
+		return err
+	}
+	var vtoc []byte = diskimage.Dos33SectorOfImage(dos33Image, 0x11, 0x00)
+	if vtoc[0x03] == 0x03 && vtoc[0x34] == 0x23 && vtoc[0x35] == 0x10 {
+		markDos33SectorOwners(*sectorOwners, dos33Image)
+	}
+	return nil
+}
+
+// formatSectorHexDumpLine returns the 16 bytes of sector starting at linePos in hexadecimal, preceded
+// by linePos and followed by the characters they stand for (with the high bit cleared, and '.' for
+// control characters).
+func formatSectorHexDumpLine(sector []byte, linePos int) string {
+	var line strings.Builder
+	fmt.Fprintf(&line, "%02X:", linePos)
+	for i := linePos; i < linePos+0x10; i = i + 1 {
+		fmt.Fprintf(&line, " %02X", sector[i])
+	}
+	line.WriteString("  ")
+	for _, b := range filesystem.StripHighBits(sector[linePos : linePos+0x10]) {
+		if b < 0x20 || b == 0x7F {
+			b = '.'
+		}
+		line.WriteByte(b)
+	}
+	return line.String()
+}
+
+// printSectorHexDump prints the 256 bytes of sector as lines of 16 bytes (see formatSectorHexDumpLine).
+func printSectorHexDump(sector []byte) {
+	for linePos := 0x00; linePos < 0x0100; linePos = linePos + 0x10 {
+		fmt.Println(formatSectorHexDumpLine(sector, linePos))
+	}
+}
+
+// browseDiskImage lets the user step through the tracks and sectors of the floppy diskImage (in
+// ProDOS sector order) with commands read line by line from input, showing each sector in the DOS3.3
+// sector order that dump produces, together with the file or structure owning it. The commands are:
+// an empty line or n for the next sector, p for the previous one, t N and s N to go to track or
+// sector N (decimal, or hexadecimal with a 0x prefix), f NAME to go to the first sector owned by the
+// file NAME, m to show the owners of the sectors of the current track, c to list the owners with their
+// sector counts, and q to quit.
+func browseDiskImage(diskImage []byte, input io.Reader) error {
+	var sectorOwners []string
+	var err error = mapSectorOwners(&sectorOwners, diskImage)
+	if err != nil {
+		return err
+	}
+	var dos33Image []byte
+	err = diskimage.ReorderedDiskImageSectors(&dos33Image, diskImage, diskimage.SECTOR_ORDER_PRODOS, diskimage.SECTOR_ORDER_DOS)
+	if err != nil {
+		return err
+	}
+	var track, sector int
+	var scanner *bufio.Scanner = bufio.NewScanner(input)
+	for {
+		var owner string = sectorOwners[track*0x10+sector]
+		if owner == "" {
+			owner = "(free or unknown)"
+		}
+		fmt.Printf("track %d (0x%02X) sector %d (0x%02X): %s\n", track, track, sector, sector, owner)
+		printSectorHexDump(diskimage.Dos33SectorOfImage(dos33Image, track, sector))
+		fmt.Print("browse> ")
+		if !scanner.Scan() {
+			fmt.Println()
+			return nil
+		}
+		var fields []string = strings.Fields(scanner.Text())
+		var command string = "n"
+		if len(fields) > 0 {
+			command = fields[0]
+		}
+		var argument string = strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(scanner.Text()), command))
+		var position int = track*0x10 + sector
+		switch command {
+		case "n":
+			position = (position + 1) % len(sectorOwners)
+		case "p":
+			position = (position + len(sectorOwners) - 1) % len(sectorOwners)
+		case "t", "s":
+			var n int64
+			n, err := strconv.ParseInt(argument, 0, 0)
+			if err != nil || n < 0 || (command == "t" && n >= 0x23) || (command == "s" && n > 0x0F) {
+				fmt.Printf("illegal %s number: %s\n", map[string]string{"t": "track", "s": "sector"}[command], argument)
+			} else if command == "t" {
+				position = int(n)*0x10 + sector
+			} else {
+				position = track*0x10 + int(n)
+			}
+		case "f":
+			var found bool = false
+			for i, sectorOwner := range sectorOwners {
+				if !found && (sectorOwner == argument || strings.HasSuffix(sectorOwner, "/"+strings.ToUpper(argument))) {
+					position = i
+					found = true
+				}
+			}
+			if !found {
+				fmt.Printf("no sector owned by %s\n", argument)
+			}
+		case "m":
+			for i := 0x00; i < 0x10; i = i + 1 {
+				fmt.Printf("  sector 0x%X: %s\n", i, sectorOwners[track*0x10+i])
+			}
+		case "c":
+			var owners []string
+			var sectorCounts map[string]int = map[string]int{}
+			for _, sectorOwner := range sectorOwners {
+				if sectorOwner == "" {
+					sectorOwner = "(free or unknown)"
+				}
+				if sectorCounts[sectorOwner] == 0 {
+					owners = append(owners, sectorOwner)
+				}
+				sectorCounts[sectorOwner] = sectorCounts[sectorOwner] + 1
+			}
+			for _, sectorOwner := range owners {
+				fmt.Printf("  %4d sectors: %s\n", sectorCounts[sectorOwner], sectorOwner)
+			}
+		case "q":
+			return nil
+		default:
+			fmt.Printf("unknown command: %s (n, p, t N, s N, f NAME, m, c, q)\n", command)
+		}
+		track = position / 0x10
+		sector = position % 0x10
+	}
+}
+
+// dumpDiskImageSector prints track track sector sector of the floppy diskImage (in ProDOS sector
+// order) in hexadecimal and ASCII as the RWTS reads it, that is in the DOS3.3 sector order dump
+// produces. With bothOrders, the 256 bytes found at the same position of the image in ProDOS sector
+// order (as laid out in a .po file) follow, naming the DOS3.3 sector the shuffle takes them to.
+func dumpDiskImageSector(diskImage []byte, track int, sector int, bothOrders bool) error {
+	var dos33Image []byte
+	var err error = diskimage.ReorderedDiskImageSectors(&dos33Image, diskImage, diskimage.SECTOR_ORDER_PRODOS, diskimage.SECTOR_ORDER_DOS)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("track %d (0x%02X) sector %d (0x%02X), DOS3.3 sector order:\n", track, track, sector, sector)
+	printSectorHexDump(diskimage.Dos33SectorOfImage(dos33Image, track, sector))
+	if !bothOrders {
+		return nil
+	}
+	fmt.Printf("\ntrack %d (0x%02X) sector %d (0x%02X) of the image in ProDOS sector order (DOS3.3 sector %d):\n", track, track, sector, sector, swappedSectorNum(sector))
+	printSectorHexDump(diskimage.Dos33SectorOfImage(diskImage, track, sector))
+	return nil
+}
+
+// Browse section end
+
+// Poke section begin
+
+// parsePokeBytes fills pokeBytes with the bytes given in args, each argument holding one or more
+// bytes as pairs of hexadecimal digits, such as C8 or C8C5CCCCCF.
+func parsePokeBytes(pokeBytes *[]byte, args []string) error {
+	for _, arg := range args {
+		var argBytes []byte
+		argBytes, err := hex.DecodeString(arg)
+		if err != nil || len(argBytes) == 0 {
+			return failure.CodedErrorf(failure.KIND_NOT_HEXADECIMAL, "poke bytes %q are not hexadecimal, give bytes like C8 or C8C5CCCCCF", arg)
+		}
+		*pokeBytes = append(*pokeBytes, argBytes...)
+	}
+	return nil
+}
+
+// pokeDiskImageSector stores pokeBytes at offset offset of track track sector sector (in the DOS3.3
+// sector order hexdump shows) of the floppy diskImage (in ProDOS sector order), and prints each byte
+// changed with its old and new value, followed by the sector as it is now.
+func pokeDiskImageSector(diskImage []byte, track int, sector int, offset int, pokeBytes []byte) error {
+	if offset+len(pokeBytes) > 0x0100 {
+		return failure.SectorErrorf(failure.KIND_POKE_PAST_SECTOR, track, sector, "%d bytes at offset %d run past the end of track %d sector %d", len(pokeBytes), offset, track, sector)
+	}
+	var err error = diskimage.ConvertDiskImageFromProdosOrderToDos33Order(diskImage)
+	if err != nil {
+		return err
+	}
+	var sectorData []byte = diskimage.Dos33SectorOfImage(diskImage, track, sector)
+	var changedCount int = 0
+	for i, b := range pokeBytes {
+		if sectorData[offset+i] != b {
+			fmt.Printf("%02X: %02X -> %02X\n", offset+i, sectorData[offset+i], b)
+			sectorData[offset+i] = b
+			changedCount = changedCount + 1
+		}
+	}
+	err = diskimage.ConvertDiskImageFromDos33OrderToProdosOrder(diskImage)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("track %d (0x%02X) sector %d (0x%02X), %d of %d bytes changed:\n", track, track, sector, sector, changedCount, len(pokeBytes))
+	printSectorHexDump(sectorData)
+	return nil
+}
+
+// Poke section end
+
+// Image diff section begin
+
+// diffDiskImages writes to output each sector which differs between the floppy images diskImage and
+// otherDiskImage (both in ProDOS sector order), by track and sector in the DOS3.3 sector order dump
+// produces, with the count of differing bytes and the file or structure owning it in diskImage. With
+// showHex, the differing lines of 16 bytes of each sector follow, from diskImage marked with - and
+// from otherDiskImage marked with +. It stores into identical whether the images are identical, and
+// returns an error when either image cannot be taken in the DOS3.3 sector order.
+func diffDiskImages(identical *bool, output io.Writer, diskImage []byte, otherDiskImage []byte, showHex bool) error {
+	var sectorOwners []string
+	var err error = mapSectorOwners(&sectorOwners, diskImage)
+	if err != nil {
+		return err
+	}
+	var dos33Image []byte
+	err = diskimage.ReorderedDiskImageSectors(&dos33Image, diskImage, diskimage.SECTOR_ORDER_PRODOS, diskimage.SECTOR_ORDER_DOS)
+	if err != nil {
+		return err
+	}
+	var otherDos33Image []byte
+	err = diskimage.ReorderedDiskImageSectors(&otherDos33Image, otherDiskImage, diskimage.SECTOR_ORDER_PRODOS, diskimage.SECTOR_ORDER_DOS)
+	if err != nil {
+		return err
+	}
+	var differingSectorCount int = 0
+	var differingTrackCount int = 0
+	for track := 0x00; track < 0x23; track = track + 1 {
+		var isTrackDiffering bool = false
+		for sector := 0x00; sector < 0x10; sector = sector + 1 {
+			var sectorData []byte = diskimage.Dos33SectorOfImage(dos33Image, track, sector)
+			var otherSectorData []byte = diskimage.Dos33SectorOfImage(otherDos33Image, track, sector)
+			var differingByteCount int = 0
+			for i := 0; i < 0x0100; i = i + 1 {
+				if sectorData[i] != otherSectorData[i] {
+					differingByteCount = differingByteCount + 1
+				}
+			}
+			if differingByteCount == 0 {
+				continue
+			}
+			var owner string = sectorOwners[track*0x10+sector]
+			if owner == "" {
+				owner = "free or unknown"
+			}
+			fmt.Fprintf(output, "track %d sector %d differs: %d bytes (%s)\n", track, sector, differingByteCount, owner)
+			for linePos := 0x00; showHex && linePos < 0x0100; linePos = linePos + 0x10 {
+				if !bytes.Equal(sectorData[linePos:linePos+0x10], otherSectorData[linePos:linePos+0x10]) {
+					fmt.Fprintf(output, "  - %s\n  + %s\n", formatSectorHexDumpLine(sectorData, linePos), formatSectorHexDumpLine(otherSectorData, linePos))
+				}
+			}
+			differingSectorCount = differingSectorCount + 1
+			isTrackDiffering = true
+		}
+		if isTrackDiffering {
+			differingTrackCount = differingTrackCount + 1
+		}
+	}
+	if differingSectorCount > 0 {
+		fmt.Fprintf(output, "%d sectors differ on %d tracks\n", differingSectorCount, differingTrackCount)
+		*identical = false
+		return nil
+	}
+	fmt.Fprintf(output, "images are identical\n")
+	*identical = true
+	return nil
+}
+
+// Image diff section end
+
+// HGR section begin
+
+// HGR_SCREEN_SIZE is the size of one hi-res graphics page. Pictures are often saved 8 bytes short, as
+// BSAVE PIC,A$2000,L$1FF8, leaving out the unused screen holes at the end of the page.
+const HGR_SCREEN_SIZE = 0x2000
+const HGR_SHORT_SCREEN_SIZE = 0x1FF8
+
+// HGR_WIDTH and HGR_HEIGHT are the size of the hi-res screen in pixels.
+const HGR_WIDTH = 280
+const HGR_HEIGHT = 192
+
+// hgrRowOffset returns the offset in the hi-res page of the 40 bytes holding screen row y, following
+// the interleaved layout of the apple ][ video memory.
+func hgrRowOffset(y int) int {
+	return 0x0400*(y%8) + 0x0080*((y/8)%8) + 0x0028*(y/64)
+}
+
+// isHgrScreenSize reports whether a file of byteCount bytes may hold a hi-res screen.
+func isHgrScreenSize(byteCount int) bool {
+	return byteCount >= HGR_SHORT_SCREEN_SIZE && byteCount <= HGR_SCREEN_SIZE
+}
+
+// renderHgrScreen draws the hi-res page screen into picture, approximating the colors of an NTSC
+// display: two lit neighbouring pixels show white, and a single lit pixel shows violet or green (blue or
+// orange when the high bit of its byte is set) depending on whether its column is even or odd.
+func renderHgrScreen(picture *image.RGBA, screen []byte) {
+	var black color.RGBA = color.RGBA{0x00, 0x00, 0x00, 0xFF}
+	var white color.RGBA = color.RGBA{0xFF, 0xFF, 0xFF, 0xFF}
+	var colors [2][2]color.RGBA = [2][2]color.RGBA{
+		{color.RGBA{0xFF, 0x44, 0xFD, 0xFF}, color.RGBA{0x14, 0xF5, 0x3C, 0xFF}}, // violet, green
+		{color.RGBA{0x14, 0xCF, 0xFD, 0xFF}, color.RGBA{0xFF, 0x6A, 0x3C, 0xFF}}} // blue, orange
+	var fullScreen []byte = make([]byte, HGR_SCREEN_SIZE)
+	copy(fullScreen, screen)
+	for y := 0; y < HGR_HEIGHT; y = y + 1 {
+		var row []byte = fullScreen[hgrRowOffset(y) : hgrRowOffset(y)+0x28]
+		var lit [HGR_WIDTH]bool
+		for x := 0; x < HGR_WIDTH; x = x + 1 {
+			lit[x] = row[x/7]>>uint(x%7)&0x01 != 0
+		}
+		for x := 0; x < HGR_WIDTH; x = x + 1 {
+			var pixelColor color.RGBA = black
+			if lit[x] && ((x > 0 && lit[x-1]) || (x < HGR_WIDTH-1 && lit[x+1])) {
+				pixelColor = white
+			} else if lit[x] {
+				pixelColor = colors[row[x/7]>>7][x%2]
+			}
+			picture.SetRGBA(x, y, pixelColor)
+		}
+	}
+}
+
+// writeHgrScreenAsPng renders the hi-res page screen and writes it as a PNG file to pngFilepath.
+func writeHgrScreenAsPng(screen []byte, pngFilepath string) error {
+	if !isHgrScreenSize(len(screen)) {
+		return fmt.Errorf("%d bytes do not make a hi-res screen of %d bytes", len(screen), HGR_SCREEN_SIZE)
+	}
+	var picture *image.RGBA = image.NewRGBA(image.Rect(0, 0, HGR_WIDTH, HGR_HEIGHT))
+	renderHgrScreen(picture, screen)
+	var f *os.File
+	f, err := os.Create(pngFilepath)
+	if err != nil {
+		return err
+	}
+	err = png.Encode(f, picture)
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("writing %s: %w", pngFilepath, err)
+	}
+	err = f.Close()
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "wrote %dx%d picture to file %s\n", HGR_WIDTH, HGR_HEIGHT, pngFilepath)
+	return nil
+}
+
+// findProdosHgrFiles appends to picturePaths the path of every file in the directory with key block
+// keyBlockNum (named directoryPath) of the ProDOS ordered diskImage, and in its subdirectories, which
+// looks like a hi-res screen: a FOT file, or a BIN file loading at 0x2000 or 0x4000, of screen size.
+// Subdirectories whose key block is in visitedKeyBlocks, the directories already searched (which a
+// damaged image can link into a loop), are not searched again.
+func findProdosHgrFiles(picturePaths *[]string, diskImage []byte, keyBlockNum int, directoryPath string, visitedKeyBlocks map[int]bool) error {
+	visitedKeyBlocks[keyBlockNum] = true
+	var err error
+	var walkErr error = filesystem.ForEachProdosDirectoryEntry(diskImage, keyBlockNum, func(entry []byte, blockNum int) bool {
+		var storageType byte = entry[0x00] >> 4
+		var entryPath string = directoryPath + "/" + filesystem.ProdosEntryName(entry)
+		var eof int = int(entry[0x15]) | int(entry[0x16])<<8 | int(entry[0x17])<<16
+		var auxType int = int(entry[0x1F]) | int(entry[0x20])<<8
+		if storageType == 0x0D && !visitedKeyBlocks[filesystem.ProdosEntryKeyBlockNum(entry)] {
+			err = findProdosHgrFiles(picturePaths, diskImage, filesystem.ProdosEntryKeyBlockNum(entry), entryPath, visitedKeyBlocks)
+		} else if storageType >= 0x01 && storageType <= 0x03 && isHgrScreenSize(eof) &&
+			((entry[0x10] == 0x08 && auxType < 0x4000) || (entry[0x10] == 0x06 && (auxType == 0x2000 || auxType == 0x4000))) {
+			*picturePaths = append(*picturePaths, entryPath)
+		}
+		return err == nil
+	})
+	if err != nil {
+		return err
+	}
+	if walkErr != nil {
+		return fmt.Errorf("searching directory %s: %w", directoryPath+"/", walkErr)
+	}
+	return nil
+}
+
+// findDos33HgrFiles appends to picturePaths the name of every binary file of the DOS 3.3 diskImage (in
+// DOS3.3 sector order) which loads at 0x2000 or 0x4000 and is of screen size.
+func findDos33HgrFiles(picturePaths *[]string, diskImage []byte) {
+	var vtoc []byte = diskimage.Dos33SectorOfImage(diskImage, 0x11, 0x00)
+	var catalogTrack int = int(vtoc[0x01])
+	var catalogSector int = int(vtoc[0x02]) & 0x0F
+	var visitedSectors int = 0
+	for catalogTrack != 0 && catalogTrack < 0x23 && visitedSectors < 0x23*0x10 {
+		var catalogSectorData []byte = diskimage.Dos33SectorOfImage(diskImage, catalogTrack, catalogSector)
+		for entryPos := 0x0B; entryPos+0x23 <= 0x0100; entryPos = entryPos + 0x23 {
+			var tsListTrack int = int(catalogSectorData[entryPos])
+			if tsListTrack == 0x00 || tsListTrack == 0xFF || tsListTrack >= 0x23 || catalogSectorData[entryPos+0x02]&0x7F != 0x04 {
+				// unused or deleted entry, or not a binary file
+				continue
+			}
+			// the load address and length are the first 4 bytes of the first data sector
+			var tsList []byte = diskimage.Dos33SectorOfImage(diskImage, tsListTrack, int(catalogSectorData[entryPos+0x01])&0x0F)
+			if tsList[0x0C] == 0 || tsList[0x0C] >= 0x23 {
+				continue
+			}
+			var firstSector []byte = diskimage.Dos33SectorOfImage(diskImage, int(tsList[0x0C]), int(tsList[0x0D])&0x0F)
+			var address int = int(firstSector[0]) | int(firstSector[1])<<8
+			var length int = int(firstSector[2]) | int(firstSector[3])<<8
+			if (address == 0x2000 || address == 0x4000) && isHgrScreenSize(length) {
+				*picturePaths = append(*picturePaths, strings.TrimRight(string(filesystem.StripHighBits(catalogSectorData[entryPos+0x03:entryPos+0x21])), " "))
+			}
+		}
+		catalogTrack = int(catalogSectorData[0x01])
+		catalogSector = int(catalogSectorData[0x02]) & 0x0F
+		visitedSectors = visitedSectors + 1
+	}
+}
+
+// findHgrFiles fills picturePaths with the files of diskImage (in ProDOS sector order) which look like
+// hi-res screens, searching a ProDOS volume when the image holds one, and otherwise a DOS 3.3 disk.
+func findHgrFiles(picturePaths *[]string, diskImage []byte) error {
+	var volumeName string
+	var totalBlocks int
+	*picturePaths = nil
+	if filesystem.ReadProdosVolumeHeader(&volumeName, &totalBlocks, diskImage, 0) {
+		return findProdosHgrFiles(picturePaths, diskImage, 0x02, "", make(map[int]bool))
+	}
+	if len(diskImage) != diskimage.FLOPPY_IMAGE_SIZE {
+		return failure.CodedErrorf(failure.KIND_UNRECOGNIZED_FILESYSTEM, "image holds neither a ProDOS volume nor a DOS 3.3 disk")
+	}
+	var dos33Image []byte
+	var err error = diskimage.ReorderedDiskImageSectors(&dos33Image, diskImage, diskimage.SECTOR_ORDER_PRODOS, diskimage.SECTOR_ORDER_DOS)
+	if err != nil {
+		return err
+	}
+	err = filesystem.ValidateDos33Vtoc(dos33Image)
+	if err != nil {
+		return err
+	}
+	findDos33HgrFiles(picturePaths, dos33Image)
+	return nil
+}
+
+// HGR section end
+
+// Label section begin
+
+// pdfString returns text as a PDF literal string, escaping the characters with a special meaning.
+func pdfString(text string) string {
+	var replacer *strings.Replacer = strings.NewReplacer("\\", "\\\\", "(", "\\(", ")", "\\)")
+	return "(" + replacer.Replace(text) + ")"
+}
+
+// writeDiskLabelPdf writes a one page (US letter) PDF file to pdfFilepath holding a 5.25" square
+// sleeve insert with title and the list of fileNames in up to three columns, and below it a 2.75" by
+// 1.25" disk label with title and subtitle, each outlined for cutting.
+func writeDiskLabelPdf(pdfFilepath string, title string, subtitle string, fileNames []string) error {
+	const POINTS_PER_INCH = 72
+	const INSERT_SIZE = 5.25 * POINTS_PER_INCH
+	const FILE_FONT_SIZE = 7
+	const FILE_LINE_HEIGHT = 8
+	const COLUMN_COUNT = 3
+	var insertLeft float64 = POINTS_PER_INCH
+	var insertTop float64 = 10 * POINTS_PER_INCH
+	var content strings.Builder
+	// sleeve insert outline, title and file columns
+	fmt.Fprintf(&content, "0.5 w %.1f %.1f %.1f %.1f re S\n", insertLeft, insertTop-INSERT_SIZE, INSERT_SIZE, INSERT_SIZE)
+	fmt.Fprintf(&content, "BT /F1 16 Tf %.1f %.1f Td %s Tj ET\n", insertLeft+12, insertTop-28, pdfString(title))
+	fmt.Fprintf(&content, "BT /F1 9 Tf %.1f %.1f Td %s Tj ET\n", insertLeft+12, insertTop-42, pdfString(subtitle))
+	var linesPerColumn int = int(math.Floor((INSERT_SIZE - 60) / FILE_LINE_HEIGHT))
+	var columnWidth float64 = (INSERT_SIZE - 24) / COLUMN_COUNT
+	for i, fileName := range fileNames {
+		if i >= linesPerColumn*COLUMN_COUNT {
+			fmt.Fprintf(os.Stderr, "only the first %d of %d files fit on the sleeve insert\n", i, len(fileNames))
+			break
+		}
+		var x float64 = insertLeft + 12 + float64(i/linesPerColumn)*columnWidth
+		var y float64 = insertTop - 60 - float64(i%linesPerColumn)*FILE_LINE_HEIGHT
+		fmt.Fprintf(&content, "BT /F1 %d Tf %.1f %.1f Td %s Tj ET\n", FILE_FONT_SIZE, x, y, pdfString(fileName))
+	}
+	// disk label outline, title and subtitle
+	var labelTop float64 = insertTop - INSERT_SIZE - 0.5*POINTS_PER_INCH
+	fmt.Fprintf(&content, "%.1f %.1f %.1f %.1f re S\n", insertLeft, labelTop-1.25*POINTS_PER_INCH, 2.75*POINTS_PER_INCH, 1.25*POINTS_PER_INCH)
+	fmt.Fprintf(&content, "BT /F1 14 Tf %.1f %.1f Td %s Tj ET\n", insertLeft+10, labelTop-30, pdfString(title))
+	fmt.Fprintf(&content, "BT /F1 8 Tf %.1f %.1f Td %s Tj ET\n", insertLeft+10, labelTop-44, pdfString(subtitle))
+	fmt.Fprintf(&content, "BT /F1 8 Tf %.1f %.1f Td %s Tj ET\n", insertLeft+10, labelTop-56, pdfString(fmt.Sprintf("%d files", len(fileNames))))
+	var objects []string = []string{
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		"<< /Type /Pages /Kids [3 0 R] /Count 1 >>",
+		"<< /Type /Page /Parent 2 0 R /MediaBox [0 0 612 792] /Resources << /Font << /F1 4 0 R >> >> /Contents 5 0 R >>",
+		"<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica >>",
+		fmt.Sprintf("<< /Length %d >>\nstream\n%s\nendstream", content.Len(), content.String())}
+	var pdf strings.Builder
+	var objectPositions []int
+	pdf.WriteString("%PDF-1.4\n")
+	for i, object := range objects {
+		objectPositions = append(objectPositions, pdf.Len())
+		fmt.Fprintf(&pdf, "%d 0 obj\n%s\nendobj\n", i+1, object)
+	}
+	var xrefPos int = pdf.Len()
+	fmt.Fprintf(&pdf, "xref\n0 %d\n0000000000 65535 f \n", len(objects)+1)
+	for _, objectPos := range objectPositions {
+		fmt.Fprintf(&pdf, "%010d 00000 n \n", objectPos)
+	}
+	fmt.Fprintf(&pdf, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(objects)+1, xrefPos)
+	var err error = ioutil.WriteFile(pdfFilepath, []byte(pdf.String()), 0644)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "wrote label for %s (%d files) to file %s\n", title, len(fileNames), pdfFilepath)
+	return nil
+}
+
+// Label section end
+
+// Preview section begin
+
+// EMULATOR_COMMANDS holds the command line used to start each known emulator with a disk image in its
+// first drive, with %s standing for the image file path.
+var EMULATOR_COMMANDS map[string]string = map[string]string{
+	"linapple": "linapple --autoboot --d1 %s",
+	"applewin": "wine AppleWin.exe -d1 %s",
+	"microm8":  "microM8 %s"}
+
+// previewDiskImage writes diskImage (in ProDOS sector order) into a temporary .po file, runs the
+// emulator command line emulatorCommand (with %s replaced by the path of the temporary file) until it
+// exits, and then removes the temporary file, so that the original image is never touched.
+func previewDiskImage(diskImage []byte, emulatorCommand string) error {
+	var tempDirpath string
+	tempDirpath, err := ioutil.TempDir("", "floppy_disk_image_preview")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tempDirpath)
+	var tempFilepath string = filepath.Join(tempDirpath, "preview.po")
+	err = ioutil.WriteFile(tempFilepath, diskImage, 0644)
+	if err != nil {
+		return err
+	}
+	var commandArgs []string = strings.Fields(emulatorCommand)
+	for i, arg := range commandArgs {
+		commandArgs[i] = strings.Replace(arg, "%s", tempFilepath, -1)
+	}
+	fmt.Fprintf(os.Stderr, "starting emulator: %s\n", strings.Join(commandArgs, " "))
+	var cmd *exec.Cmd = exec.Command(commandArgs[0], commandArgs[1:]...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	err = cmd.Run()
+	if err != nil {
+		return fmt.Errorf("running emulator %s: %w", commandArgs[0], err)
+	}
+	fmt.Fprintf(os.Stderr, "emulator exited, removed temporary image %s\n", tempFilepath)
+	return nil
+}
+
+// Preview section end
+
+// Hash list section begin
+
+// readHashList fills titlesByHash with the titles of a published list of known-good image hashes, read
+// from hashListFilepath, keyed by their SHA-1 in lower case hexadecimal. The list is either JSON (an
+// object of title to SHA-1, or an array of objects with "title" and "sha1" members), or CSV lines of
+// title and SHA-1 (in either order, a header line is skipped as it holds no SHA-1).
+func readHashList(titlesByHash map[string][]string, hashListFilepath string) error {
+	var data []byte
+	data, err := ioutil.ReadFile(hashListFilepath)
+	if err != nil {
+		return err
+	}
+	var trimmedData string = strings.TrimSpace(string(data))
+	if strings.HasPrefix(trimmedData, "{") {
+		var hashesByTitle map[string]string
+		err = json.Unmarshal(data, &hashesByTitle)
+		if err != nil {
+			return fmt.Errorf("hash list %s: %w", hashListFilepath, err)
+		}
+		for title, hash := range hashesByTitle {
+			titlesByHash[strings.ToLower(hash)] = append(titlesByHash[strings.ToLower(hash)], title)
+		}
+		return nil
+	}
+	if strings.HasPrefix(trimmedData, "[") {
+		var entries []struct {
+			Title string `json:"title"`
+			Sha1  string `json:"sha1"`
+		}
+		err = json.Unmarshal(data, &entries)
+		if err != nil {
+			return fmt.Errorf("hash list %s: %w", hashListFilepath, err)
+		}
+		for _, entry := range entries {
+			titlesByHash[strings.ToLower(entry.Sha1)] = append(titlesByHash[strings.ToLower(entry.Sha1)], entry.Title)
+		}
+		return nil
+	}
+	var records [][]string
+	var csvReader *csv.Reader = csv.NewReader(strings.NewReader(string(data)))
+	csvReader.FieldsPerRecord = -1
+	records, err = csvReader.ReadAll()
+	if err != nil {
+		return fmt.Errorf("hash list %s: %w", hashListFilepath, err)
+	}
+	for _, record := range records {
+		if len(record) < 2 {
+			continue
+		}
+		for i := 0; i < 2; i = i + 1 {
+			var hash string = strings.ToLower(strings.TrimSpace(record[i]))
+			_, err = hex.DecodeString(hash)
+			if len(hash) == 40 && err == nil {
+				titlesByHash[hash] = append(titlesByHash[hash], strings.TrimSpace(record[1-i]))
+			}
+		}
+	}
+	return nil
+}
+
+// verifyDiskImageAgainstHashList reports whether the SHA-1 of diskImage is found in the hash list at
+// hashListFilepath, printing the titles it is listed under, or that it is not listed.
+func verifyDiskImageAgainstHashList(diskImage []byte, diskImageFilepath string, hashListFilepath string) (bool, error) {
+	var titlesByHash map[string][]string = map[string][]string{}
+	var err error = readHashList(titlesByHash, hashListFilepath)
+	if err != nil {
+		return false, err
+	}
+	var hash string = fmt.Sprintf("%x", sha1.Sum(diskImage))
+	var titles []string = titlesByHash[hash]
+	if len(titles) == 0 {
+		fmt.Printf("%s (SHA-1 %s) does not match any of the %d known-good images in %s\n", diskImageFilepath, hash, len(titlesByHash), hashListFilepath)
+		return false, nil
+	}
+	fmt.Printf("%s (SHA-1 %s) matches known-good image %s\n", diskImageFilepath, hash, strings.Join(titles, ", "))
+	return true, nil
+}
+
+// dataHashes holds the MD5, SHA-1 and CRC32 (IEEE) of some data, in lower case hexadecimal.
+type dataHashes struct {
+	Md5   string `json:"md5"`
+	Sha1  string `json:"sha1"`
+	Crc32 string `json:"crc32"`
+}
+
+// trackHashes holds the hashes of a track.
+type trackHashes struct {
+	Track int `json:"track"`
+	dataHashes
+}
+
+// diskImageHashReport holds the hashes of a disk image, and of each of its tracks when it is a 140K
+// floppy image.
+type diskImageHashReport struct {
+	Image string `json:"image"`
+	Bytes int    `json:"bytes"`
+	dataHashes
+	Tracks []trackHashes `json:"tracks,omitempty"`
+}
+
+// hashData stores the hashes of data into hashes.
+func hashData(hashes *dataHashes, data []byte) {
+	hashes.Md5 = fmt.Sprintf("%x", md5.Sum(data))
+	hashes.Sha1 = fmt.Sprintf("%x", sha1.Sum(data))
+	hashes.Crc32 = fmt.Sprintf("%08x", crc32.ChecksumIEEE(data))
+}
+
+// reportDiskImageHashes writes to output, as a JSON object on one line, the hashes of diskImage (in
+// ProDOS sector order, as verify hashes it) read from diskImageFilepath, and for a 140K
+// floppy image those of each of its tracks as the RWTS reads them, in the DOS3.3 sector order of the
+// images undump makes, so that a disk dumped back can be compared track by track. The tracks of a
+// 13-sector image are hashed as its 13 sectors of each track.
+func reportDiskImageHashes(output io.Writer, diskImage []byte, diskImageFilepath string) error {
+	var report diskImageHashReport = diskImageHashReport{Image: diskImageFilepath, Bytes: len(diskImage)}
+	hashData(&report.dataHashes, diskImage)
+	if len(diskImage) == diskimage.FLOPPY_IMAGE_SIZE {
+		var dos33Image []byte
+		var err error = diskimage.ReorderedDiskImageSectors(&dos33Image, diskImage, diskimage.SECTOR_ORDER_PRODOS, diskimage.SECTOR_ORDER_DOS)
+		if err != nil {
+			return err
+		}
+		for trackNum := 0x00; trackNum < 0x23; trackNum = trackNum + 1 {
+			var track trackHashes = trackHashes{Track: trackNum}
+			var trackStartPos int = diskimage.DiskImageStartPosOfTrackSector(trackNum, 0)
+			hashData(&track.dataHashes, dos33Image[trackStartPos:trackStartPos+0x1000])
+			report.Tracks = append(report.Tracks, track)
+		}
+	} else if diskImageIs13Sector {
+		for trackNum := 0x00; trackNum < 0x23; trackNum = trackNum + 1 {
+			var track trackHashes = trackHashes{Track: trackNum}
+			var trackStartPos int = gcr.D13ImageStartPosOfTrackSector(trackNum, 0)
+			hashData(&track.dataHashes, diskImage[trackStartPos:trackStartPos+gcr.D13_SECTOR_COUNT*0x0100])
+			report.Tracks = append(report.Tracks, track)
+		}
+	}
+	return writeDiskImageHashReport(output, report)
+}
+
+// reportDiskImageStreamHashes writes to output the hashes of the ProDOS ordered block image read from
+// data, which came from diskImageFilepath, as reportDiskImageHashes does for an image which is not a
+// floppy image, hashing it a track at a time as it is read. It returns the error of reading data.
+func reportDiskImageStreamHashes(output io.Writer, data io.Reader, diskImageFilepath string) error {
+	var md5Hash hash.Hash = md5.New()
+	var sha1Hash hash.Hash = sha1.New()
+	var crc32Hash hash.Hash32 = crc32.NewIEEE()
+	var hashOutput io.Writer = io.MultiWriter(md5Hash, sha1Hash, crc32Hash)
+	var byteCount int
+	var err error = streamDiskImageTracks(&byteCount, data, 0, func(track []byte) error {
+		_, err := hashOutput.Write(track)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	var report diskImageHashReport = diskImageHashReport{Image: diskImageFilepath, Bytes: byteCount}
+	report.Md5 = fmt.Sprintf("%x", md5Hash.Sum(nil))
+	report.Sha1 = fmt.Sprintf("%x", sha1Hash.Sum(nil))
+	report.Crc32 = fmt.Sprintf("%08x", crc32Hash.Sum32())
+	return writeDiskImageHashReport(output, report)
+}
+
+// writeDiskImageHashReport writes report to output as a JSON object on one line.
+func writeDiskImageHashReport(output io.Writer, report diskImageHashReport) error {
+	// a struct of strings and numbers always marshals
+	var line []byte
+	line, _ = json.Marshal(report)
+	var _, err = output.Write(append(line, '\n'))
+	return err
+}
+
+// Hash list section end
+
+// Journal section begin
+
+// JOURNAL_SECTOR_SIZE is the unit in which changes to an image file are recorded in its journal.
+const JOURNAL_SECTOR_SIZE = 0x0100
+
+// journalSector holds the original content of one changed sector of an image file, at byte offset
+// Offset.
+type journalSector struct {
+	Offset   int    `json:"offset"`
+	Original []byte `json:"original"`
+}
+
+// journalRecord describes one operation which wrote an image file: the sectors it changed with their
+// original content, the original file size (or Created when there was no file before), and the SHA-1
+// of the file it wrote, so that an undo can tell whether the file was changed again since.
+type journalRecord struct {
+	Operation    string          `json:"operation"`
+	Time         string          `json:"time"`
+	Created      bool            `json:"created,omitempty"`
+	OriginalSize int             `json:"original_size"`
+	ResultSha1   string          `json:"result_sha1"`
+	Sectors      []journalSector `json:"sectors"`
+}
+
+// journalFilepath returns the path of the journal kept beside the image file imageFilepath.
+func journalFilepath(imageFilepath string) string {
+	return imageFilepath + ".journal"
+}
+
+// writeDiskImageWithJournal writes diskImage to the file imageFilepath, after appending to its
+// journal a record of the operation (described by operation) holding the original content of every
+// sector it changes, so that the write can be rolled back with undoDiskImageOperations. An encrypted
+// file is written encrypted again with the key file diskImageKeyFilepath, its journal then holding
+// the original encrypted content.
+func writeDiskImageWithJournal(diskImage []byte, imageFilepath string, operation string) error {
+	var record journalRecord = journalRecord{Operation: operation, Time: time.Now().Format(time.RFC3339)}
+	var originalImage []byte
+	originalImage, err := ioutil.ReadFile(imageFilepath)
+	if os.IsNotExist(err) {
+		record.Created = true
+	} else if err != nil {
+		return err
+	}
+	if isEncryptedData(originalImage) {
+		var key []byte
+		err = readEncryptionKey(&key, diskImageKeyFilepath)
+		if err != nil {
+			return fmt.Errorf("%s is encrypted: %w", imageFilepath, err)
+		}
+		err = encryptData(&diskImage, diskImage, key)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "encrypted the image again, as file %s was\n", imageFilepath)
+	}
+	record.OriginalSize = len(originalImage)
+	for offset := 0; offset < len(originalImage); offset = offset + JOURNAL_SECTOR_SIZE {
+		var originalEnd int = offset + JOURNAL_SECTOR_SIZE
+		if originalEnd > len(originalImage) {
+			originalEnd = len(originalImage)
+		}
+		var newEnd int = originalEnd
+		if newEnd > len(diskImage) {
+			newEnd = len(diskImage)
+		}
+		if offset >= len(diskImage) || !bytes.Equal(originalImage[offset:originalEnd], diskImage[offset:newEnd]) {
+			record.Sectors = append(record.Sectors, journalSector{Offset: offset, Original: originalImage[offset:originalEnd]})
+		}
+	}
+	record.ResultSha1 = fmt.Sprintf("%x", sha1.Sum(diskImage))
+	// a struct of strings, numbers and byte slices always marshals
+	var line []byte
+	line, _ = json.Marshal(record)
+	var journal *os.File
+	journal, err = os.OpenFile(journalFilepath(imageFilepath), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(journal, "%s\n", line)
+	if err != nil {
+		journal.Close()
+		return fmt.Errorf("writing the journal of %s: %w", imageFilepath, err)
+	}
+	err = journal.Close()
+	if err != nil {
+		return fmt.Errorf("writing the journal of %s: %w", imageFilepath, err)
+	}
+	err = ioutil.WriteFile(imageFilepath, diskImage, 0644)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "journaled %d changed sectors of file %s\n", len(record.Sectors), imageFilepath)
+	return nil
+}
+
+// undoDiskImageOperations rolls back the last operationCount operations recorded in the journal of the
+// image file imageFilepath, newest first, restoring the original sectors (or removing the file when
+// the operation created it), and drops their records from the journal. It returns an error before
+// touching the file when the file no longer holds what the operation wrote.
+func undoDiskImageOperations(imageFilepath string, operationCount int) error {
+	var journalData []byte
+	journalData, err := ioutil.ReadFile(journalFilepath(imageFilepath))
+	if err != nil {
+		return err
+	}
+	var lines []string = strings.Split(strings.TrimSpace(string(journalData)), "\n")
+	if strings.TrimSpace(string(journalData)) == "" || operationCount > len(lines) {
+		return failure.CodedErrorf(failure.KIND_CANNOT_UNDO, "journal of %s holds %d operations, cannot undo %d", imageFilepath, len(lines), operationCount)
+	}
+	for undone := 0; undone < operationCount; undone = undone + 1 {
+		var record journalRecord
+		err = json.Unmarshal([]byte(lines[len(lines)-1]), &record)
+		if err != nil {
+			return fmt.Errorf("journal of %s, line %d: %w", imageFilepath, len(lines), err)
+		}
+		var diskImage []byte
+		diskImage, err = ioutil.ReadFile(imageFilepath)
+		if err != nil {
+			return err
+		}
+		if fmt.Sprintf("%x", sha1.Sum(diskImage)) != record.ResultSha1 {
+			return failure.CodedErrorf(failure.KIND_IMAGE_CHANGED, "%s was changed since the %s operation of %s, not undoing it", imageFilepath, record.Operation, record.Time)
+		}
+		if record.Created {
+			err = os.Remove(imageFilepath)
+		} else {
+			var restoredImage []byte = make([]byte, record.OriginalSize)
+			copy(restoredImage, diskImage)
+			for _, sector := range record.Sectors {
+				copy(restoredImage[sector.Offset:], sector.Original)
+			}
+			err = ioutil.WriteFile(imageFilepath, restoredImage, 0644)
+		}
+		if err != nil {
+			return err
+		}
+		lines = lines[:len(lines)-1]
+		var remainingJournal string = strings.Join(lines, "\n")
+		if remainingJournal != "" {
+			remainingJournal = remainingJournal + "\n"
+		}
+		err = ioutil.WriteFile(journalFilepath(imageFilepath), []byte(remainingJournal), 0644)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "undid %s operation of %s on file %s (%d sectors)\n", record.Operation, record.Time, imageFilepath, len(record.Sectors))
+	}
+	return nil
+}
+
+// Journal section end
+
+// Image encryption section begin
+
+// ENCRYPTED_IMAGE_MAGIC starts an encrypted file, followed by the nonce and the AES-256-GCM sealed
+// content, the magic being authenticated along with it.
+const ENCRYPTED_IMAGE_MAGIC = "A2DISKENC\x01"
+
+// ENCRYPTED_FILE_EXTENSION ends the name encrypt gives an encrypted file by default, left out of the
+// name when detecting the format of the image it holds.
+const ENCRYPTED_FILE_EXTENSION = ".enc"
+
+// ENCRYPTION_NONCE_SIZE is the byte count of the GCM nonce following ENCRYPTED_IMAGE_MAGIC.
+const ENCRYPTION_NONCE_SIZE = 12
+
+// ENCRYPTION_KEY_SIZE is the byte count of an AES-256 key, held in a key file as hexadecimal digits.
+const ENCRYPTION_KEY_SIZE = 32
+
+// diskImageKeyFilepath, when not empty, is the key file decrypting the encrypted disk image files
+// read, and encrypting the image files written over encrypted ones.
+var diskImageKeyFilepath string
+
+// isEncryptedData tells whether data is the content of an encrypted file.
+func isEncryptedData(data []byte) bool {
+	return bytes.HasPrefix(data, []byte(ENCRYPTED_IMAGE_MAGIC))
+}
+
+// readEncryptionKey fills key with the AES-256 key in the key file keyFilepath, which holds it as 64
+// hexadecimal digits.
+func readEncryptionKey(key *[]byte, keyFilepath string) error {
+	if keyFilepath == "" {
+		return failure.CodedErrorf(failure.KIND_ENCRYPTION, "no key file given to decrypt or encrypt with (-key-file)")
+	}
+	data, err := ioutil.ReadFile(keyFilepath)
+	if err != nil {
+		return err
+	}
+	*key, err = hex.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil || len(*key) != ENCRYPTION_KEY_SIZE {
+		return failure.CodedErrorf(failure.KIND_BAD_KEY, "key file %s does not hold %d hexadecimal digits", keyFilepath, 2*ENCRYPTION_KEY_SIZE)
+	}
+	return nil
+}
+
+// writeNewEncryptionKey writes a random AES-256 key to the key file keyFilepath, readable only by its
+// owner, refusing to replace an existing file, whose images could not be decrypted anymore.
+func writeNewEncryptionKey(keyFilepath string) error {
+	var key []byte = make([]byte, ENCRYPTION_KEY_SIZE)
+	_, err := cryptorand.Read(key)
+	if err != nil {
+		return err
+	}
+	file, err := os.OpenFile(keyFilepath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if os.IsExist(err) {
+		return failure.CodedErrorf(failure.KIND_BAD_KEY, "key file %s already exists", keyFilepath)
+	}
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(file, "%s\n", hex.EncodeToString(key))
+	if err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// newEncryptionCipher returns the AES-256-GCM cipher of key.
+func newEncryptionCipher(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// encryptData fills encrypted with the content of an encrypted file holding data, sealed with key
+// under a random nonce.
+func encryptData(encrypted *[]byte, data []byte, key []byte) error {
+	aead, err := newEncryptionCipher(key)
+	if err != nil {
+		return err
+	}
+	var nonce []byte = make([]byte, ENCRYPTION_NONCE_SIZE)
+	_, err = cryptorand.Read(nonce)
+	if err != nil {
+		return err
+	}
+	*encrypted = append(append([]byte(ENCRYPTED_IMAGE_MAGIC), nonce...), aead.Seal(nil, nonce, data, []byte(ENCRYPTED_IMAGE_MAGIC))...)
+	return nil
+}
+
+// decryptData fills data with the content of the encrypted file encrypted, named name in messages,
+// opened with key. It returns an error when the file was encrypted with another key, or was changed
+// since.
+func decryptData(data *[]byte, encrypted []byte, key []byte, name string) error {
+	aead, err := newEncryptionCipher(key)
+	if err != nil {
+		return err
+	}
+	var headerSize int = len(ENCRYPTED_IMAGE_MAGIC) + ENCRYPTION_NONCE_SIZE
+	if len(encrypted) < headerSize {
+		return failure.CodedErrorf(failure.KIND_ENCRYPTION, "encrypted file %s is cut short", name)
+	}
+	*data, err = aead.Open(nil, encrypted[len(ENCRYPTED_IMAGE_MAGIC):headerSize], encrypted[headerSize:], []byte(ENCRYPTED_IMAGE_MAGIC))
+	if err != nil {
+		return failure.CodedErrorf(failure.KIND_ENCRYPTION, "%s could not be decrypted: it was encrypted with another key, or has been changed since", name)
+	}
+	return nil
+}
+
+// decryptDataWithKeyFile replaces data, the content of the encrypted file name, with the content it
+// holds, decrypted with the key in diskImageKeyFilepath.
+func decryptDataWithKeyFile(data *[]byte, name string) error {
+	var key []byte
+	var err error = readEncryptionKey(&key, diskImageKeyFilepath)
+	if err != nil {
+		return fmt.Errorf("%s is encrypted: %w", name, err)
+	}
+	return decryptData(data, *data, key, name)
+}
+
+// Image encryption section end
+
+// Serial port section begin
+
+// sttyArgsOfSerialSettings fills sttyArgs with the stty arguments which set a serial port to raw
+// mode at baud with the data bits, parity and stop bits of framing, and with flowControl: "none",
+// "rtscts" (the apple ][ holds off the characters sent with the CTS line) or "xonxoff" (the apple ][
+// holds them off by sending XOFF and lets them go on by sending XON).
+func sttyArgsOfSerialSettings(sttyArgs *[]string, baud int, framing string, flowControl string) error {
+	var bitsPerChar int
+	var err error = parseLineFormat(&bitsPerChar, baud, framing)
+	if err != nil {
+		return err
+	}
+	*sttyArgs = []string{strconv.Itoa(baud), "raw", "-echo", "clocal", "cs" + framing[0:1]}
+	switch flowControl {
+	case "none":
+		*sttyArgs = append(*sttyArgs, "-crtscts", "-ixon", "-ixoff")
+	case "rtscts":
+		*sttyArgs = append(*sttyArgs, "crtscts", "-ixon", "-ixoff")
+	case "xonxoff":
+		*sttyArgs = append(*sttyArgs, "-crtscts", "ixon", "-ixoff")
+	default:
+		return failure.CodedErrorf(failure.KIND_UNKNOWN_VALUE, "unknown flow control: %s", flowControl)
+	}
+	switch framing[1] {
+	case 'N':
+		*sttyArgs = append(*sttyArgs, "-parenb")
+	case 'E':
+		*sttyArgs = append(*sttyArgs, "parenb", "-parodd")
+	case 'O':
+		*sttyArgs = append(*sttyArgs, "parenb", "parodd")
+	}
+	if framing[2] == '2' {
+		*sttyArgs = append(*sttyArgs, "cstopb")
+	} else {
+		*sttyArgs = append(*sttyArgs, "-cstopb")
+	}
+	return nil
+}
+
+// openSerialPort opens the serial device portFilepath for writing the command stream directly, and
+// sets it up with stty (run with the device as its stdin, which works with the stty of both Linux and
+// BSD/macOS) to the baud rate, framing and flow control of the serial line.
+func openSerialPort(port **os.File, portFilepath string, baud int, framing string, flowControl string) error {
+	var sttyArgs []string
+	var err error = sttyArgsOfSerialSettings(&sttyArgs, baud, framing, flowControl)
+	if err != nil {
+		return err
+	}
+	*port, err = os.OpenFile(portFilepath, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	var cmd *exec.Cmd = exec.Command("stty", sttyArgs...)
+	cmd.Stdin = *port
+	cmd.Stderr = os.Stderr
+	err = cmd.Run()
+	if err != nil {
+		(*port).Close()
+		return fmt.Errorf("setting up serial port %s with stty %s failed: %w", portFilepath, strings.Join(sttyArgs, " "), err)
+	}
+	if flowControl == "none" {
+		fmt.Fprintf(os.Stderr, "opened serial port %s at %d baud %s\n", portFilepath, baud, framing)
+	} else {
+		fmt.Fprintf(os.Stderr, "opened serial port %s at %d baud %s with %s flow control\n", portFilepath, baud, framing, flowControl)
+	}
+	return nil
+}
+
+// closeSerialPort waits until the characters written to port (the serial device, or the connection
+// to a bridge) so far have had time to leave at baud, so that closing it does not cut off the end of
+// the command stream, and then closes it.
+func closeSerialPort(port io.Closer, baud int, bitsPerChar int) error {
+	var remainingTime time.Duration = time.Duration(transmissionSeconds(commandOutput.charCount, baud, bitsPerChar)*float64(time.Second)) - time.Since(commandOutput.startTime)
+	if commandOutput.charCount > 0 && remainingTime > 0 {
+		time.Sleep(remainingTime)
+	}
+	return port.Close()
+}
+
+// Serial port section end
+
+// TCP section begin
+
+// TCP_PACING_AHEAD_TIME is how far the characters written to a bridge may get ahead of its serial
+// line. Small bridges such as WiFi modems drop what does not fit their buffers, rather than holding
+// off the connection, so the command stream is written no faster than the serial line sends it.
+const TCP_PACING_AHEAD_TIME = 250 * time.Millisecond
+
+// TCP_PACING_CHUNK_SIZE is the number of characters written to a bridge at a time.
+const TCP_PACING_CHUNK_SIZE = 16
+
+// The telnet commands which are understood when reading from a telnet bridge: the IAC character which
+// starts each command (and is sent twice for a 0xFF data byte), the start and end of a subnegotiation,
+// and the first and last of the option negotiations (WILL, WONT, DO and DONT), which take an option
+// byte.
+const TELNET_IAC = 0xFF
+const TELNET_SB = 0xFA
+const TELNET_SE = 0xF0
+const TELNET_WILL = 0xFB
+const TELNET_DONT = 0xFE
+
+// tcpPort is a TCP connection to a bridge (a WiFi modem, ser2net or the virtual serial port of an
+// emulator) whose serial line to the apple ][ runs at baud with bitsPerChar bits per character, and
+// which is used like the serial device of -port. With telnet, 0xFF bytes written are sent as telnet
+// IAC IAC, and the telnet commands read are dropped. charCount characters have been written since
+// startTime.
+type tcpPort struct {
+	conn        net.Conn
+	reader      *bufio.Reader
+	telnet      bool
+	baud        int
+	bitsPerChar int
+	charCount   int
+	startTime   time.Time
+}
+
+// openTcpPort connects to the bridge at address (host:port) for writing the command stream, and
+// reading the answers of the apple ][, over a serial line at baud with framing. The flowControl of
+// that line is set up on the bridge itself.
+func openTcpPort(port **tcpPort, address string, telnet bool, baud int, framing string, flowControl string) error {
+	var bitsPerChar int
+	var err error = parseLineFormat(&bitsPerChar, baud, framing)
+	if err != nil {
+		return err
+	}
+	conn, err := net.DialTimeout("tcp", address, 10*time.Second)
+	if err != nil {
+		return failure.CodedErrorf(failure.KIND_CONNECTION_FAILED, "connecting to %s failed: %w", address, err)
+	}
+	*port = &tcpPort{conn: conn, reader: bufio.NewReader(conn), telnet: telnet, baud: baud, bitsPerChar: bitsPerChar}
+	var protocol string = "tcp"
+	if telnet {
+		protocol = "telnet"
+	}
+	if flowControl == "none" {
+		fmt.Fprintf(os.Stderr, "connected to %s (%s) for a serial line at %d baud %s\n", address, protocol, baud, framing)
+	} else {
+		fmt.Fprintf(os.Stderr, "connected to %s (%s) for a serial line at %d baud %s with %s flow control\n", address, protocol, baud, framing, flowControl)
+	}
+	return nil
+}
+
+// Write implements io.Writer, writing p to the bridge TCP_PACING_CHUNK_SIZE characters at a time, each
+// chunk no sooner than TCP_PACING_AHEAD_TIME before the serial line has sent the characters before it.
+func (t *tcpPort) Write(p []byte) (int, error) {
+	if t.charCount == 0 {
+		t.startTime = time.Now()
+	}
+	var written int = 0
+	for written < len(p) {
+		var chunkEnd int = written + TCP_PACING_CHUNK_SIZE
+		if chunkEnd > len(p) {
+			chunkEnd = len(p)
+		}
+		var waitTime time.Duration = time.Duration(transmissionSeconds(t.charCount, t.baud, t.bitsPerChar)*float64(time.Second)) - TCP_PACING_AHEAD_TIME - time.Since(t.startTime)
+		if waitTime > 0 {
+			time.Sleep(waitTime)
+		}
+		var chunk []byte = p[written:chunkEnd]
+		if t.telnet {
+			chunk = bytes.Replace(chunk, []byte{TELNET_IAC}, []byte{TELNET_IAC, TELNET_IAC}, -1)
+		}
+		var _, err = t.conn.Write(chunk)
+		if err != nil {
+			return written, err
+		}
+		t.charCount = t.charCount + chunkEnd - written
+		written = chunkEnd
+	}
+	return written, nil
+}
+
+// Read implements io.Reader, reading the characters sent by the apple ][ through the bridge, without
+// the telnet commands of a telnet bridge (which are not answered, leaving the options at their
+// defaults).
+func (t *tcpPort) Read(p []byte) (int, error) {
+	if !t.telnet {
+		return t.reader.Read(p)
+	}
+	var n int = 0
+	for len(p) > 0 && (n == 0 || (n < len(p) && t.reader.Buffered() > 0)) {
+		b, err := t.reader.ReadByte()
+		if err != nil {
+			return n, err
+		}
+		if b != TELNET_IAC {
+			p[n] = b
+			n = n + 1
+			continue
+		}
+		b, err = t.reader.ReadByte()
+		if err != nil {
+			return n, err
+		}
+		if b == TELNET_IAC {
+			p[n] = b
+			n = n + 1
+		} else if b >= TELNET_WILL && b <= TELNET_DONT {
+			_, err = t.reader.ReadByte()
+		} else if b == TELNET_SB {
+			// skip the subnegotiation up to IAC SE
+			var previous byte
+			for err == nil && !(previous == TELNET_IAC && b == TELNET_SE) {
+				previous = b
+				b, err = t.reader.ReadByte()
+			}
+		}
+		if err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// Close implements io.Closer, closing the connection to the bridge.
+func (t *tcpPort) Close() error {
+	return t.conn.Close()
+}
+
+// TCP section end
+
+// Binary transfer section begin
+
+// With a binary transfer, the track data is sent as raw bytes to a receiver program, which reads them
+// from the 6551 ACIA of a Super Serial Card (or compatible) directly and stores them into the track
+// buffer. The address of the receiver program, which takes the memory of the checked line stub, and
+// the character which starts the bytes of a track are:
+const BINARY_RECEIVER_ADDRESS = 0x0E00
+const BINARY_RECEIVER_SYNC = 0x16
+
+// binaryReceiver is the receiver program of a binary transfer, reading the serial card in slot slot,
+// which is loaded with the first track.
+type binaryReceiver struct {
+	slot   int
+	loaded bool
+}
+
+// binaryTransfer is set when the track data is sent as raw bytes to the receiver program, and is nil
+// otherwise.
+var binaryTransfer *binaryReceiver
+
+// writeCommandsToLoadBinaryReceiverToMemory outputs the memory transfer commands which load the
+// receiver program. It waits for BINARY_RECEIVER_SYNC, ignoring the characters before it (the line
+// start padding sent while the monitor processes the command running it), and then stores the next
+// 4096 bytes received into the memory starting at 0x2000 (or -buffer-address) before returning to the
+// monitor. The data and status registers of the ACIA of the card in slot slot are at 0xC088 and 0xC089
+// plus 16 times the slot.
+func writeCommandsToLoadBinaryReceiverToMemory(slot int, SEGMENT_SIZE int, LINE_START_PAD_LENGTH int) {
+	var statusLow byte = byte(0x89 + slot<<4)
+	var dataLow byte = byte(0x88 + slot<<4)
+	var receiverProgram []byte = []byte{
+		'\xA9', byte(bufferAddress >> 8), // store at the buffer, through '\x06'
+		'\x85', '\x07',
+		'\xA9', '\x00',
+		'\x85', '\x06',
+		'\xA2', '\x10', // page count
+		'\xAD', statusLow, '\xC0', // wait for a character
+		'\x29', '\x08',
+		'\xF0', '\xF9',
+		'\xAD', dataLow, '\xC0',
+		'\xC9', BINARY_RECEIVER_SYNC,
+		'\xD0', '\xF2', // skip it until the sync character
+		'\xA0', '\x00',
+		'\xAD', statusLow, '\xC0', // wait for a byte
+		'\x29', '\x08',
+		'\xF0', '\xF9',
+		'\xAD', dataLow, '\xC0',
+		'\x91', '\x06', // store it
+		'\xC8',
+		'\xD0', '\xF1', //iterate
+		'\xE6', '\x07',
+		'\xCA',
+		'\xD0', '\xEC', //iterate
+		'\x60'} // return
+	var lineStartPad string
+	generateLineStartPad(&lineStartPad, LINE_START_PAD_LENGTH)
+	for sourceBytesStartPos := 0; sourceBytesStartPos < len(receiverProgram); sourceBytesStartPos = sourceBytesStartPos + SEGMENT_SIZE {
+		writeCommandsToFillAppleMemorySegment(receiverProgram, lineStartPad, BINARY_RECEIVER_ADDRESS+sourceBytesStartPos, sourceBytesStartPos, SEGMENT_SIZE)
+	}
+}
+
+// writeBinaryToLoadDiskTrackToMemory outputs the command which runs the receiver program (loading it
+// first, with the first track), followed by line start padding covering the time the monitor takes to
+// process the command, BINARY_RECEIVER_SYNC, and the 4096 bytes of track trackNum of the diskImage
+// slice as raw bytes.
+func writeBinaryToLoadDiskTrackToMemory(diskImage []byte, trackNum int, SEGMENT_SIZE int, LINE_START_PAD_LENGTH int) {
+	if !binaryTransfer.loaded {
+		writeCommandsToLoadBinaryReceiverToMemory(binaryTransfer.slot, SEGMENT_SIZE, LINE_START_PAD_LENGTH)
+		binaryTransfer.loaded = true
+	}
+	var lineStartPad string
+	generateLineStartPad(&lineStartPad, LINE_START_PAD_LENGTH)
+	var executeCommand string
+	generateExecuteCommand(&executeCommand, BINARY_RECEIVER_ADDRESS)
+	fmt.Fprintf(&commandOutput, "%s%s\r", lineStartPad, executeCommand)
+	var trackStartPos int = diskimage.DiskImageStartPosOfTrackSector(trackNum, 0x00)
+	commandOutput.writeBinary(append(append([]byte(lineStartPad), BINARY_RECEIVER_SYNC), diskImage[trackStartPos:trackStartPos+0x1000]...))
+}
+
+// Binary transfer section end
+
+// Checked lines section begin
+
+// With checked lines, each memory fill command stores its target address, byte count and checksum
+// together with its bytes into a staging area, and runs a stub on the same line which checks them and
+// answers with an ACK or NAK character. The address of the staging area and of the stub program, and
+// the answers (as sent by the apple ][ with the high bit set) are:
+const CHECKED_LINE_STAGING_ADDRESS = 0x0F00
+const CHECKED_LINE_STUB_ADDRESS = 0x0E00
+const CHECKED_LINE_ACK = 0x06
+const CHECKED_LINE_NAK = 0x15
+
+// CHECKED_LINE_MAX_RETRIES is the count of times a line is sent before giving up.
+const CHECKED_LINE_MAX_RETRIES = 10
+
+// checkedLineLink is the connection to the apple ][ when sending checked lines: characters it sends
+// (the echo of the commands, and the answers of the stub) arrive on input, and timeout is the time
+// allowed for the answer to a line beyond the time taken to send it and have it echoed.
+type checkedLineLink struct {
+	input           chan byte
+	baud            int
+	bitsPerChar     int
+	timeout         time.Duration
+	lineCount       int
+	retransmitCount int
+}
+
+// checkedLines is set when memory fill commands are sent as checked lines, and is nil otherwise.
+var checkedLines *checkedLineLink
+
+// writeCommandsToLoadCheckedLineStubToMemory outputs the memory transfer commands (unchecked) which
+// load the stub program. It adds up the address, the byte count and the bytes in the staging area, and
+// when the sum matches the checksum stored with them, copies the bytes to the address and prints ACK,
+// and otherwise prints NAK. The stored checksum is then changed, so that a later line which does not
+// reach the staging area cannot be acknowledged with the bytes left there.
+func writeCommandsToLoadCheckedLineStubToMemory(SEGMENT_SIZE int, LINE_START_PAD_LENGTH int) {
+	var stubProgram []byte = []byte{
+			'\xAD', '\x00', '\x0F', // add up address and byte count
+			'\x18',
+			'\x6D', '\x01', '\x0F',
+			'\x18',
+			'\x6D', '\x02', '\x0F',
+			'\xAE', '\x02', '\x0F', // add up the bytes (from '\x0F04')
+			'\xF0', '\x07', //skip when there are none
+			'\x18',
+			'\x7D', '\x03', '\x0F',
+			'\xCA',
+			'\xD0', '\xF9', //iterate
+			'\xCD', '\x03', '\x0F', // compare with checksum
+			'\xD0', '\x21', // answer NAK when different
+			'\xAE', '\x02', '\x0F',
+			'\xF0', '\x18', // answer ACK when there are no bytes to copy
+			'\xAD', '\x00', '\x0F', // modify program : copy to the address of the line
+			'\x8D', '\x33', '\x0E',
+			'\xAD', '\x01', '\x0F',
+			'\x8D', '\x34', '\x0E',
+			'\xA0', '\x00', // copy the bytes
+			'\xB9', '\x04', '\x0F',
+			'\x99', '\x00', '\x00',
+			'\xC8',
+			'\xCA',
+			'\xD0', '\xF6', //iterate
+			'\xA9', CHECKED_LINE_ACK | '\x80', // answer ACK
+			'\xD0', '\x02',
+			'\xA9', CHECKED_LINE_NAK | '\x80', // answer NAK
+			'\x20', '\xED', '\xFD',
+			'\xEE', '\x03', '\x0F', // spoil the checksum
+			'\x60' } // return
+	var lineStartPad string
+	generateLineStartPad(&lineStartPad, LINE_START_PAD_LENGTH)
+	for sourceBytesStartPos := 0; sourceBytesStartPos < len(stubProgram); sourceBytesStartPos = sourceBytesStartPos + SEGMENT_SIZE {
+		writeCommandsToFillAppleMemorySegment(stubProgram, lineStartPad, CHECKED_LINE_STUB_ADDRESS+sourceBytesStartPos, sourceBytesStartPos, SEGMENT_SIZE)
+	}
+}
+
+// awaitAnswer waits for the stub to answer a line of lineLength characters, skipping the echo of the
+// line. It returns true for ACK, and false for NAK or when no answer came in time.
+func (l *checkedLineLink) awaitAnswer(lineLength int) (bool, error) {
+	var deadline time.Time = time.Now().Add(l.timeout + time.Duration(2*transmissionSeconds(lineLength, l.baud, l.bitsPerChar)*float64(time.Second)))
+	for {
+		select {
+		case b, ok := <-l.input:
+			if !ok {
+				return false, protocol.ClosedInputError(failure.KIND_SERIAL_PORT_CLOSED, "serial port closed while waiting for a checked line answer")
+			}
+			if b&0x7F == CHECKED_LINE_ACK {
+				return true, nil
+			}
+			if b&0x7F == CHECKED_LINE_NAK {
+				return false, nil
+			}
+		case <-time.After(time.Until(deadline)):
+			return false, nil
+		}
+	}
+}
+
+// discardInput drops the characters received so far, so that a late answer to an earlier try of a
+// line is not taken as the answer to the next one.
+func (l *checkedLineLink) discardInput() {
+	for {
+		select {
+		case _, ok := <-l.input:
+			if !ok {
+				return
+			}
+		default:
+			return
+		}
+	}
+}
+
+// writeCheckedFillCommand outputs the command which stores the bytes byteWriteGroup for address
+// targetStartAddress into the staging area, together with the address, the byte count and the
+// checksum (the sum of all of them), and runs the stub. The line is sent again until the stub answers
+// ACK, up to CHECKED_LINE_MAX_RETRIES times. A line never acknowledged fails the command stream, which
+// is then checked at the end of the track, and nothing more is sent.
+func writeCheckedFillCommand(byteWriteGroup []byte, lineStartPad string, targetStartAddress int) {
+	var stagedBytes []byte = []byte{byte(targetStartAddress), byte(targetStartAddress >> 8), byte(len(byteWriteGroup)), '\x00'}
+	stagedBytes = append(stagedBytes, byteWriteGroup...)
+	var checksum byte = 0
+	for i, b := range stagedBytes {
+		if i != 3 {
+			checksum = checksum + b
+		}
+	}
+	stagedBytes[3] = checksum
+	var memoryAddress string
+	generateMemoryAddress(&memoryAddress, CHECKED_LINE_STAGING_ADDRESS)
+	var byteWriteGroupString string
+	generateByteWriteGroupStringFromBytes(&byteWriteGroupString, stagedBytes)
+	var stubAddress string
+	generateMemoryAddress(&stubAddress, CHECKED_LINE_STUB_ADDRESS)
+	// N ends the store command, so that the address of the stub is not stored as another byte
+	var line string = fmt.Sprintf("%s%s:%s N %sG\r", lineStartPad, memoryAddress, byteWriteGroupString, stubAddress)
+	checkedLines.lineCount = checkedLines.lineCount + 1
+	for tries := 0; tries < CHECKED_LINE_MAX_RETRIES && commandOutput.err == nil; tries = tries + 1 {
+		if tries > 0 {
+			checkedLines.retransmitCount = checkedLines.retransmitCount + 1
+			endProgressLine()
+			fmt.Fprintf(os.Stderr, "sending line for %04X again\n", targetStartAddress)
+		}
+		checkedLines.discardInput()
+		fmt.Fprint(&commandOutput, line)
+		acknowledged, err := checkedLines.awaitAnswer(len(line))
+		if err != nil {
+			failCommandStream(err)
+			return
+		}
+		if acknowledged {
+			return
+		}
+	}
+	failCommandStream(failure.CodedErrorf(failure.KIND_NOT_ACKNOWLEDGED, "line for %04X was not acknowledged after %d tries", targetStartAddress, CHECKED_LINE_MAX_RETRIES))
+}
+
+// reportCheckedLines writes to stderr the count of checked lines sent, and how many of them had to
+// be sent again.
+func reportCheckedLines() {
+	fmt.Fprintf(os.Stderr, "sent %d checked lines, %d of them again\n", checkedLines.lineCount, checkedLines.retransmitCount)
+}
+
+// Checked lines section end
+
+// generateLineStartPad creates a block of space characters to be prepended to each line to be
+// sent over the serial connection. This pad is to allow for the loss of a variable number of
+// bytes which are lost during the processing of the previous line by the apple ][ monitor.
+// The pad is set into the string pointed to by lineStartPad.
+func generateLineStartPad(lineStartPad *string, LINE_START_PAD_LENGTH int) {
+	*lineStartPad = strings.Repeat(" ", LINE_START_PAD_LENGTH)
+}
+
+// generateMemoryAddress generates a hexadecimal formatted address for the apple ][ monitor.
+// The targetStartAddress parameter holds the input address, and the output is stored in the
+// string pointed to by memoryAddress.
+func generateMemoryAddress(memoryAddress *string, targetStartAddress int) {
+	if targetStartAddress < 0 {
+		*memoryAddress = fmt.Sprintf("")
+	} else {
+		*memoryAddress = fmt.Sprintf("%02X", targetStartAddress)
+	}
+}
+
+// generateByteWriteGroupStringFromBytes takes the byteWriteGroup slice as input and stores
+// an appropriate string sequence of hexadecimal numbers for the apple ][ monitor, stored in
+// the string pointed to by byteWriteGroupString.
+func generateByteWriteGroupStringFromBytes(byteWriteGroupString *string, byteWriteGroup []byte) {
+	var sb strings.Builder
+	for i, b := range byteWriteGroup {
+		var s string
+		if (i == len(byteWriteGroup) - 1) {
+			s = fmt.Sprintf("%02X", b)
+		} else {
+			s = fmt.Sprintf("%02X ", b)
+		}
+		// writing to a strings.Builder always succeeds
+		sb.WriteString(s)
+	}
+	*byteWriteGroupString = sb.String()
+}
+
+// writeCommandsToFillAppleMemorySegment outputs a carriage return terminated line of text which
+// is a command to the apple ][ monitor which fills a block of memory starting at address
+// targetStartAddress, with bytes from the sourceBytes slice starting at position sourceBytesStartPos
+// and including the number of bytes specified in writeByteCount. Each line is prepended with lineStartPad.
+// When checkedLines is set, the line is sent as a checked line by writeCheckedFillCommand instead.
+func writeCommandsToFillAppleMemorySegment(sourceBytes []byte, lineStartPad string, targetStartAddress int, sourceBytesStartPos int, writeByteCount int) {
+	var memoryAddress string
+	generateMemoryAddress(&memoryAddress, targetStartAddress)
+	var byteWriteGroupString string
+	var sourceBytesEndPos int = sourceBytesStartPos + writeByteCount
+	if sourceBytesEndPos > len(sourceBytes) {
+		// make sure we don't run off the end of sourceBytes
+		sourceBytesEndPos = len(sourceBytes)
+	}
+	var byteWriteGroup []byte = sourceBytes[sourceBytesStartPos : sourceBytesEndPos]
+	if checkedLines != nil {
+		writeCheckedFillCommand(byteWriteGroup, lineStartPad, targetStartAddress)
+		return
+	}
+	generateByteWriteGroupStringFromBytes(&byteWriteGroupString, byteWriteGroup)
+	fmt.Fprintf(&commandOutput, "%s%s:%s\r", lineStartPad, memoryAddress, byteWriteGroupString)
+}
+
+// COMPACT_RUN_MIN_LENGTH and COMPACT_RUN_MAX_LENGTH are the lengths of the runs of equal bytes which
+// the compact encoding fills with the monitor move command. Longer runs are split, so that the move
+// does not keep the monitor busy for long.
+const COMPACT_RUN_MIN_LENGTH = 16
+const COMPACT_RUN_MAX_LENGTH = 0x0100
+
+// compactEncoding is set when memory is filled with the compact encoding of
+// writeCompactCommandsToFillAppleMemory.
+var compactEncoding bool
+
+// writeCompactCommandsToFillAppleMemory outputs the commands which fill the memory starting at address
+// targetStartAddress with sourceBytes, in fewer characters than memory fill commands of SEGMENT_SIZE
+// bytes. Each line holds as many bytes as fit in the length of such a command, and the lines after the
+// first start with a colon alone, which makes the monitor store the bytes after the last one it stored.
+// Bytes below 0x10 are given with a single digit. A run of COMPACT_RUN_MIN_LENGTH or more equal bytes is
+// stored once and copied along with the monitor move command (the source overlapping the destination
+// by one byte), after N ends the store command; spaces follow the line to cover the time of the move.
+func writeCompactCommandsToFillAppleMemory(sourceBytes []byte, lineStartPad string, targetStartAddress int, SEGMENT_SIZE int) {
+	var maxLineLength int = 5 + 3*SEGMENT_SIZE - 1
+	var line string = ""
+	var needAddress bool = true
+	var pos int = 0
+	for pos < len(sourceBytes) {
+		var runLength int = 1
+		for pos+runLength < len(sourceBytes) && runLength < COMPACT_RUN_MAX_LENGTH && sourceBytes[pos+runLength] == sourceBytes[pos] {
+			runLength = runLength + 1
+		}
+		var address int = targetStartAddress + pos
+		if runLength >= COMPACT_RUN_MIN_LENGTH {
+			if line != "" {
+				fmt.Fprintf(&commandOutput, "%s%s\r", lineStartPad, line)
+				line = ""
+			}
+			var storeCommand string = fmt.Sprintf("%X:%X", address, sourceBytes[pos])
+			var moveCommand string = fmt.Sprintf("%X<%X.%XM", address+1, address, address+runLength-2)
+			if len(storeCommand)+3+len(moveCommand) <= maxLineLength {
+				fmt.Fprintf(&commandOutput, "%s%s N %s\r", lineStartPad, storeCommand, moveCommand)
+			} else {
+				fmt.Fprintf(&commandOutput, "%s%s\r", lineStartPad, storeCommand)
+				fmt.Fprintf(&commandOutput, "%s%s\r", lineStartPad, moveCommand)
+			}
+			// the characters received during the move are lost like the line start padding
+			var moveTime time.Duration = time.Duration(runLength) * emulator.MONITOR_MOVE_TIME_PER_BYTE
+			fmt.Fprint(&commandOutput, strings.Repeat(" ", int(math.Ceil(float64(len(lineStartPad))*moveTime.Seconds()/MONITOR_LINE_PROCESSING_TIME.Seconds()))))
+			needAddress = true
+			pos = pos + runLength
+			continue
+		}
+		var byteString string = fmt.Sprintf("%X", sourceBytes[pos])
+		if line != "" && len(line)+1+len(byteString) > maxLineLength {
+			fmt.Fprintf(&commandOutput, "%s%s\r", lineStartPad, line)
+			line = ""
+		}
+		if line == "" && needAddress {
+			line = fmt.Sprintf("%X:%s", address, byteString)
+			needAddress = false
+		} else if line == "" {
+			line = ":" + byteString
+		} else {
+			line = line + " " + byteString
+		}
+		pos = pos + 1
+	}
+	if line != "" {
+		fmt.Fprintf(&commandOutput, "%s%s\r", lineStartPad, line)
+	}
+}
+
+// writeCommandsToLoadDiskTrackToMemory outputs a sequence of commands to the apple ][ monitor which
+// fill the 2KB of memory between address 0x1000 and memory address 0x1FFF with 16 sectors worth of
+// data for transfer to the apple II disk. The 16 sectors correspond to 1 complete track from the
+// diskImage slice corresponding to the trackNum input. Each command line fills SEGMENT_SIZE count
+// of bytes. SEGMENT_SIZE was determened through trial and error to be best set at 8 ... when sending
+// commands over a serial connection at 2400 baud with data format including 7 data bytes and 1 stop
+// byte. The 16 space pad at the start of each line was adequate to avoid data loss during processing
+// of each line, however, in order to sychronize timing of the loss of line start padding, commands
+// of gradually increasing SEGMENT_SIZE was needed. So at the beginning of the transfer of a track,
+// the first segment transfer command is repeated with byte count starting at 0 and ending at 8. This
+// led to losing 12 or 13 characters from the 16 space pad regularly when executing each command.
+// Use of hardware flow control might avoid the need for this pad (see -flow-control). With a binary
+// transfer, the track is instead sent as raw bytes to the receiver program. The loaded track is then
+// checked by verifyTrackInMemory, whose error is returned.
+func writeCommandsToLoadDiskTrackToMemory(diskImage []byte, trackNum int, SEGMENT_SIZE int, LINE_START_PAD_LENGTH int) error {
+	if trackNum < 0x0 || trackNum > 0x22 {
+		return failure.TrackErrorf(failure.KIND_ILLEGAL_TRACK, trackNum, "illegal track number encountered: %d", trackNum)
+	}
+	if binaryTransfer != nil {
+		writeBinaryToLoadDiskTrackToMemory(diskImage, trackNum, SEGMENT_SIZE, LINE_START_PAD_LENGTH)
+	} else {
+		writeCommandsToLoadDiskBytesToMemory(diskImage, diskimage.DiskImageStartPosOfTrackSector(trackNum, 0x00), 0x1000, SEGMENT_SIZE, LINE_START_PAD_LENGTH)
+	}
+	return verifyTrackInMemory(diskImage, trackNum, SEGMENT_SIZE, LINE_START_PAD_LENGTH)
+}
+
+// writeCommandsToLoadDiskSectorToMemory outputs a sequence of commands to the apple ][ monitor which
+// fill the 256 bytes of memory starting at address 0x2000 with one sector of the diskImage slice,
+// sector sectorNum of track trackNum, with the same ramp up as writeCommandsToLoadDiskTrackToMemory.
+func writeCommandsToLoadDiskSectorToMemory(diskImage []byte, trackNum int, sectorNum int, SEGMENT_SIZE int, LINE_START_PAD_LENGTH int) error {
+	if trackNum < 0x0 || trackNum > 0x22 {
+		return failure.TrackErrorf(failure.KIND_ILLEGAL_TRACK, trackNum, "illegal track number encountered: %d", trackNum)
+	}
+	writeCommandsToLoadDiskBytesToMemory(diskImage, diskimage.DiskImageStartPosOfTrackSector(trackNum, sectorNum), 0x0100, SEGMENT_SIZE, LINE_START_PAD_LENGTH)
+	return nil
+}
+
+// writeCommandsToLoadDiskBytesToMemory outputs the commands for writeCommandsToLoadDiskTrackToMemory
+// and writeCommandsToLoadDiskSectorToMemory, filling diskImageWriteByteCount bytes of memory starting
+// at address 0x2000 with the data of diskImage starting at position sourceBytesStartPos. The first
+// segment is preceded by the rampUpLineCount lines of the ramp-up sequence. With compactEncoding, the
+// bytes after the ramp-up are filled by writeCompactCommandsToFillAppleMemory.
+func writeCommandsToLoadDiskBytesToMemory(diskImage []byte, sourceBytesStartPos int, diskImageWriteByteCount int, SEGMENT_SIZE int, LINE_START_PAD_LENGTH int) {
+	var lineStartPad string
+	generateLineStartPad(&lineStartPad, LINE_START_PAD_LENGTH)
+	var bytesWritten int = 0
+	var targetStartAddress = bufferAddress
+	var firstCommand bool = true
+	for bytesWritten < diskImageWriteByteCount {
+		if firstCommand {
+			commandOutput.rampingUp = true
+			// ramp up data stream by doing access and extra dumplicated short writes .. to get the "rhythm" going
+			for rampUpByteCount := SEGMENT_SIZE - rampUpLineCount + 1; rampUpByteCount <= SEGMENT_SIZE; rampUpByteCount = rampUpByteCount + 1 {
+				if rampUpByteCount >= 0 {
+					writeCommandsToFillAppleMemorySegment(diskImage, lineStartPad, targetStartAddress, sourceBytesStartPos, rampUpByteCount)
+				}
+			}
+			commandOutput.rampingUp = false
+			firstCommand = false
+			if compactEncoding {
+				writeCompactCommandsToFillAppleMemory(diskImage[sourceBytesStartPos:sourceBytesStartPos+diskImageWriteByteCount], lineStartPad, targetStartAddress, SEGMENT_SIZE)
+				return
+			}
+		}
+		writeCommandsToFillAppleMemorySegment(diskImage, lineStartPad, targetStartAddress, sourceBytesStartPos, SEGMENT_SIZE)
+		targetStartAddress = targetStartAddress + SEGMENT_SIZE
+		bytesWritten = bytesWritten + SEGMENT_SIZE
+		sourceBytesStartPos = sourceBytesStartPos + SEGMENT_SIZE
+	}
+}
+
+// writeCommandsToLoadRWTSClientProgramToMemory outputs a series of memory transfer commands to the
+// apple ][ monitor which loads into memory and then executes a machine language program which calls
+// the RWTS routine (already assumed to be loaded into memory and referenced indirectly by a vector
+// at location 0x0D39) 16 times to write the 16 sectors worth of data which has been loaded into the
+// memory range 0x1000 through 0x1FFF into sectors 0x00 through 0x0F of the apple II disk track which
+// is input in parameter trackNum. The machine langague routine is transferred in commands which load
+// segements of SEGMENT_SIZE, similar to the loading of the Disk Track buffer.
+// rwtsCommand is stored in the IOB command byte, so passing RWTS_COMMAND_READ instead of
+// RWTS_COMMAND_WRITE gives a client which reads the 16 sectors of the track into the same memory range.
+// clientStrategy "track" gives the client above. With "sector", the sector loop is removed so that the
+// client reads or writes only the single sector stored in the IOB (at 0x0C21) using the buffer at
+// 0x2000, and then returns. With "descending", the client loops over the same sectors and buffer pages
+// from sector 0x0F down to sector 0x00. RWTS maps these DOS3.3 logical sectors onto every other
+// physical sector in that order, so each next sector arrives under the head shortly after the previous
+// one is done, instead of most of a revolution later as in ascending order.
+func writeCommandsToLoadRWTSClientProgramToMemory(trackNum int, rwtsCommand byte, clientStrategy string, SEGMENT_SIZE int, LINE_START_PAD_LENGTH int) error {
+	var clientProgram []byte
+	var err error = generateRWTSClientProgram(&clientProgram, trackNum, rwtsCommand, clientStrategy)
+	if err != nil {
+		return err
+	}
+	var clientWriteByteCount int = len(clientProgram)
+	var sourceBytesStartPos int = 0
+	var lineStartPad string
+	generateLineStartPad(&lineStartPad, LINE_START_PAD_LENGTH)
+	var bytesWritten int = 0
+	var targetStartAddress = clientAddress
+	for bytesWritten < clientWriteByteCount {
+		writeCommandsToFillAppleMemorySegment(clientProgram, lineStartPad, targetStartAddress, sourceBytesStartPos, SEGMENT_SIZE)
+		targetStartAddress = targetStartAddress + SEGMENT_SIZE
+		bytesWritten = bytesWritten + SEGMENT_SIZE
+		sourceBytesStartPos = sourceBytesStartPos + SEGMENT_SIZE
+	}
+	return nil
+}
+
+// generateRWTSClientProgram generates the machine language program loaded by
+// writeCommandsToLoadRWTSClientProgramToMemory for track trackNum, rwtsCommand and clientStrategy.
+// The program is stored in the slice pointed to by clientProgram. It returns an error when trackNum is
+// not a track of the floppy disk.
+func generateRWTSClientProgram(clientProgram *[]byte, trackNum int, rwtsCommand byte, clientStrategy string) error {
+	if trackNum < 0x0 || trackNum > 0x22 {
+		return failure.TrackErrorf(failure.KIND_ILLEGAL_TRACK, trackNum, "illegal track number encountered: %d", trackNum)
+	}
+	var trackNumArray []byte = []byte{
+			'\x00', '\x01', '\x02', '\x03', '\x04', '\x05', '\x06', '\x07', '\x08', '\x09', '\x0A', '\x0B', '\x0C', '\x0D', '\x0E', '\x0F',
+			'\x10', '\x11', '\x12', '\x13', '\x14', '\x15', '\x16', '\x17', '\x18', '\x19', '\x1A', '\x1B', '\x1C', '\x1D', '\x1E', '\x1F',
+			'\x20', '\x21', '\x22' }
+	var trackNumByte = trackNumArray[trackNum]
+	var slotByte byte = byte(targetDiskSlot * 0x10)
+	var driveByte byte = byte(targetDiskDrive)
+	var clientPage byte = byte(clientAddress >> 8)
+	var bufferPage byte = byte(bufferAddress >> 8)
+	*clientProgram = []byte{
+			'\xA9', clientPage, // load address of IOB for RWTS into A/Y
+			'\xA0', '\x1C',
+			'\x20', '\xD9', '\x03', // call RWTS
+			'\xB0', '\x12', // break on error
+			'\xA9', '\x0F', // we are done after writing final sector
+			'\xCD', '\x21', clientPage,
+			'\xF0', '\x0A', //skip next iteration when done
+			'\xEE', '\x21', clientPage, // modify IOB : advance to write next sector (sector is in '\x0C21')
+			'\xEE', '\x25', clientPage, // modify IOB : advance to next memory page (buffer is in '\x0C25')
+			'\xF0', '\xE8', //iterate
+			'\xD0', '\xE6', //iterate
+			'\x60', // return from client
+			'\x00', // break
+			'\x01', slotByte, driveByte, targetDiskVolume, trackNumByte, '\x00', // slot / drive / vol / track / sector
+			'\x30', clientPage, // DCT address is '\x0C2F
+			'\x00', bufferPage, // data buffer address (starts at 0x2000)
+			'\x00', '\x00', rwtsCommand, // read or write
+			'\x00', '\x00', slotByte, driveByte, // actual volumne / previous slot / drive
+			'\x00', '\x00', '\x00', // not used
+			'\x00', '\x01', '\xEF', '\xD8' } // DCT table (constant)
+	if clientStrategy == "sector" {
+		// return right after the first RWTS call, leaving the IOB at the same address
+		(*clientProgram)[0x09] = '\x60'
+		for i := 0x0A; i < 0x1B; i = i + 1 {
+			(*clientProgram)[i] = '\x00'
+		}
+	} else if clientStrategy == "descending" {
+		// replace the sector loop, leaving the IOB at the same address
+		copy((*clientProgram)[0x09:0x1B], []byte{
+				'\xAD', '\x21', clientPage, // we are done after the sector 0x00
+				'\xF0', '\x0C', //skip next iteration when done
+				'\xCE', '\x21', clientPage, // modify IOB : step back to previous sector (sector is in '\x0C21')
+				'\xCE', '\x25', clientPage, // modify IOB : step back to previous memory page (buffer is in '\x0C25')
+				'\xF0', '\xEA', //iterate
+				'\xD0', '\xE8', //iterate
+				'\xEA', '\xEA', // unused
+				'\x60' }) // return from client
+		(*clientProgram)[0x21] = '\x0F'              // start with the final sector
+		(*clientProgram)[0x25] = bufferPage + '\x0F' // and the final memory page
+	}
+	return nil
+}
+
+// writeCommandsToInstallDiskTrackBySector outputs the commands which write track trackNum of the
+// diskImage slice one sector at a time, rather than loading the whole track before writing it. The
+// single sector client program is loaded once, and then for each sector the sector data is loaded,
+// the sector number is stored into the IOB, and the client is executed. The drive motor is started
+// and stopped by RWTS for each sector, and characters received meanwhile are lost, so each execute
+// command is followed by a settle line of spaces (harmless to the monitor) lasting long enough at
+// 2400 baud to cover a sector write including the motor start up delay.
+func writeCommandsToInstallDiskTrackBySector(diskImage []byte, trackNum int, SEGMENT_SIZE int, LINE_START_PAD_LENGTH int) error {
+	const SETTLE_PAD_LENGTH = 240
+	var err error = writeCommandsToLoadRWTSClientProgramToMemory(trackNum, RWTS_COMMAND_WRITE, "sector", SEGMENT_SIZE, LINE_START_PAD_LENGTH)
+	if err != nil {
+		return err
+	}
+	endProgressLine()
+	fmt.Fprintf(os.Stderr, "executing binary client program once per sector to write track %d\n", trackNum)
+	var lineStartPad string
+	generateLineStartPad(&lineStartPad, LINE_START_PAD_LENGTH)
+	var executeCommand string
+	generateExecuteCommand(&executeCommand, clientAddress)
+	for sectorNum := 0x00; sectorNum < 0x10; sectorNum = sectorNum + 1 {
+		var err error = writeCommandsToLoadDiskSectorToMemory(diskImage, trackNum, sectorNum, SEGMENT_SIZE, LINE_START_PAD_LENGTH)
+		if err != nil {
+			return err
+		}
+		writeCommandsToFillAppleMemorySegment([]byte{byte(sectorNum)}, lineStartPad, clientAddress+0x21, 0, 1)
+		fmt.Fprintf(&commandOutput, "%s%s\r", lineStartPad, executeCommand)
+		if sectorNum < 0x0F {
+			fmt.Fprintf(&commandOutput, "%s\r", strings.Repeat(" ", SETTLE_PAD_LENGTH))
+		}
+	}
+	return nil
+}
+
+// writeCommandsToSettle outputs settleCharCount spaces (harmless to the monitor) which keep the serial
+// line busy while the client accesses the disk, since characters received meanwhile are lost. They
+// are split into lines of at most MAX_SETTLE_LINE_LENGTH spaces, short of the monitor input line limit.
+func writeCommandsToSettle(settleCharCount int) {
+	const MAX_SETTLE_LINE_LENGTH = 240
+	for settleCharCount > 0 {
+		var lineLength int = settleCharCount
+		if lineLength > MAX_SETTLE_LINE_LENGTH {
+			lineLength = MAX_SETTLE_LINE_LENGTH
+		}
+		fmt.Fprintf(&commandOutput, "%s\r", strings.Repeat(" ", lineLength))
+		settleCharCount = settleCharCount - lineLength - 1
+	}
+}
+
+// parseTrackList fills trackNums with the tracks of trackList, a comma separated list of track numbers
+// and ranges of track numbers (such as 0-4,17,20-34), in the order given. It returns an error for a
+// track number which is not one or is out of range.
+func parseTrackList(trackNums *[]int, trackList string) error {
+	return parseNumberList(trackNums, trackList, 0x22, "track")
+}
+
+// parseNumberList fills numbers with the numbers of numberList, a comma separated list of numbers from
+// 0 through lastNumber and ranges of such numbers, in the order given. kind names what the numbers
+// select, for the error returned on a number out of range.
+func parseNumberList(numbers *[]int, numberList string, lastNumber int, kind string) error {
+	*numbers = nil
+	for _, item := range strings.Split(numberList, ",") {
+		var bounds []string = strings.SplitN(strings.TrimSpace(item), "-", 2)
+		var firstNumber, rangeLastNumber int
+		firstNumber, err := strconv.Atoi(bounds[0])
+		if err != nil {
+			return err
+		}
+		rangeLastNumber = firstNumber
+		if len(bounds) == 2 {
+			rangeLastNumber, err = strconv.Atoi(bounds[1])
+			if err != nil {
+				return err
+			}
+		}
+		if firstNumber < 0 || rangeLastNumber > lastNumber || firstNumber > rangeLastNumber {
+			if kind == "track" {
+				return failure.CodedErrorf(failure.KIND_ILLEGAL_TRACK_RANGE, "illegal %s range encountered: %s", kind, item)
+			}
+			return failure.CodedErrorf(failure.KIND_ILLEGAL_BLOCK_GROUP_RANGE, "illegal %s range encountered: %s", kind, item)
+		}
+		for number := firstNumber; number <= rangeLastNumber; number = number + 1 {
+			*numbers = append(*numbers, number)
+		}
+	}
+	return nil
+}
+
+// writeCommandsToResetClientIob outputs the command which sets the track, sector and data buffer
+// address bytes of the IOB of an already loaded RWTS client back to their starting values for track
+// trackNum, so that the client can be executed again for another track.
+func writeCommandsToResetClientIob(trackNum int, clientStrategy string, lineStartPad string) error {
+	var iobReset []byte
+	var err error = generateClientIobReset(&iobReset, trackNum, clientStrategy)
+	if err != nil {
+		return err
+	}
+	writeCommandsToFillAppleMemorySegment(iobReset, lineStartPad, clientAddress+0x20, 0, len(iobReset))
+	return nil
+}
+
+// generateClientIobReset generates the bytes stored by writeCommandsToResetClientIob at 0x0C20, for
+// track trackNum and clientStrategy. They are stored in the slice pointed to by iobReset. It returns an
+// error when trackNum is not a track of the floppy disk.
+func generateClientIobReset(iobReset *[]byte, trackNum int, clientStrategy string) error {
+	if trackNum < 0x0 || trackNum > 0x22 {
+		return failure.TrackErrorf(failure.KIND_ILLEGAL_TRACK, trackNum, "illegal track number encountered: %d", trackNum)
+	}
+	// track / sector / DCT address / data buffer address, as in the loaded IOB
+	*iobReset = []byte{byte(trackNum), '\x00', '\x30', byte(clientAddress >> 8), '\x00', byte(bufferAddress >> 8)}
+	if clientStrategy == "descending" {
+		(*iobReset)[1] = '\x0F'
+		(*iobReset)[5] = (*iobReset)[5] + '\x0F'
+	}
+	return nil
+}
+
+// writeCommandsToInstallDiskTracks outputs the commands which install each of the tracks trackNums of
+// the diskImage slice in one stream. With the "track" or "descending" clientStrategy the client
+// program is loaded only once: for each track the track data is loaded, the track number, first sector
+// and first buffer page are stored back into the IOB (the client leaves them advanced), and the client
+// is executed. With "sector", each track is installed as by writeCommandsToInstallDiskTrackBySector.
+// Every track but the last is followed by settleCharCount spaces covering the track write. With
+// readBack, the read back program is loaded with the client and executed after it for each track, and
+// the spaces also cover reading the track back and printing its checksums, unless the read back lines
+// are checked as they arrive (see retryTrackUntilVerified), which replaces the spaces. The install
+// stops at the end of the track in which writing the commands or checking the track failed.
+func writeCommandsToInstallDiskTracks(diskImage []byte, trackNums []int, clientStrategy string, readBack bool, settleCharCount int, SEGMENT_SIZE int, LINE_START_PAD_LENGTH int) error {
+	var lineStartPad string
+	generateLineStartPad(&lineStartPad, LINE_START_PAD_LENGTH)
+	var trailingCommands string
+	if readBack {
+		// the track is read back and its checksums printed before the next one is sent
+		generateExecuteCommand(&trailingCommands, clientAddress+0x0100)
+		settleCharCount = 2*settleCharCount + READ_BACK_LINE_LENGTH
+	}
+	var pipeline *trackPipeline = startTrackPipeline()
+	for i, trackNum := range trackNums {
+		progressEventTrack = trackNum
+		emitProgressEvent("track_started", commandOutput.lineCount, commandOutput.charCount)
+		var err error
+		if clientStrategy == "sector" {
+			err = writeCommandsToInstallDiskTrackBySector(diskImage, trackNum, SEGMENT_SIZE, LINE_START_PAD_LENGTH)
+		} else {
+			err = writeCommandsToLoadDiskTrackToMemory(diskImage, trackNum, SEGMENT_SIZE, LINE_START_PAD_LENGTH)
+		}
+		if err != nil {
+			failCommandStream(err)
+		} else if clientStrategy != "sector" {
+			if i == 0 {
+				err = writeCommandsToLoadRWTSClientProgramToMemory(trackNum, RWTS_COMMAND_WRITE, clientStrategy, SEGMENT_SIZE, LINE_START_PAD_LENGTH)
+				if err != nil {
+					return err
+				}
+				if readBack {
+					writeCommandsToLoadReadBackProgramToMemory(clientStrategy, SEGMENT_SIZE, LINE_START_PAD_LENGTH)
+				}
+			} else {
+				err = writeCommandsToResetClientIob(trackNum, clientStrategy, lineStartPad)
+				if err != nil {
+					return err
+				}
+			}
+			executeClient(trackNum, RWTS_COMMAND_WRITE, trailingCommands, LINE_START_PAD_LENGTH)
+			err = retryTrackUntilVerified(diskImage, trackNum, clientStrategy, trailingCommands, SEGMENT_SIZE, LINE_START_PAD_LENGTH)
+			if err != nil {
+				failCommandStream(err)
+			}
+		}
+		if commandOutput.err != nil {
+			break
+		}
+		// when the read back line has been waited for, the monitor is ready for the next track
+		if i < len(trackNums)-1 && readBackVerification == nil {
+			writeCommandsToSettle(settleCharCount)
+		}
+		emitProgressEvent("track_finished", commandOutput.lineCount, commandOutput.charCount)
+		pipeline.endTrack()
+		if commandOutput.err != nil {
+			break
+		}
+	}
+	return pipeline.finish()
+}
+
+// generateMemoryDumpCommand generates a command for the apple ][ monitor which displays the
+// byteCount bytes of memory starting at address startAddress. The command is stored in the
+// string pointed to by dumpCommand.
+func generateMemoryDumpCommand(dumpCommand *string, startAddress int, byteCount int) {
+	*dumpCommand = fmt.Sprintf("%04X.%04X", startAddress, startAddress+byteCount-1)
+}
+
+// generateExecuteCommand generates a command for the apple ][ monitor which executes the machine
+// language program at address startAddress. The command is stored in the string pointed to by
+// executeCommand.
+func generateExecuteCommand(executeCommand *string, startAddress int) {
+	*executeCommand = fmt.Sprintf("%XG", startAddress)
+}
+
+// executeClient outputs a command which executes the machine language program and
+// reports the read or written track to stderr. The trailingCommands (which may be empty) are
+// placed on the same line after the execute command. The monitor runs them when the client
+// returns, so they are not lost while the disk is being accessed.
+func executeClient(trackNum int, rwtsCommand byte, trailingCommands string, LINE_START_PAD_LENGTH int) {
+	if rwtsCommand == RWTS_COMMAND_READ {
+		endProgressLine()
+		fmt.Fprintf(os.Stderr, "executing binary client program to read track %d\n", trackNum)
+	} else {
+		endProgressLine()
+		fmt.Fprintf(os.Stderr, "executing binary client program to write track %d\n", trackNum)
+	}
+	var lineStartPad string
+	generateLineStartPad(&lineStartPad, LINE_START_PAD_LENGTH)
+	var executeCommand string
+	generateExecuteCommand(&executeCommand, clientAddress)
+	if trailingCommands == "" {
+		fmt.Fprintf(&commandOutput, "%s%s\r", lineStartPad, executeCommand)
+	} else {
+		fmt.Fprintf(&commandOutput, "%s%s %s\r", lineStartPad, executeCommand, trailingCommands)
+	}
+}
+
+// SmartPort section begin
+
+// writeCommandsToLoadSmartPortClientProgramToMemory outputs a series of memory transfer commands to
+// the apple ][ monitor which load a machine language program (at 0x0C00) that calls the SmartPort
+// firmware of the card (or built in port) in slot slot to write blockCount blocks of 512 bytes from the
+// memory starting at 0x2000 to the device with unit number unit, starting at block firstBlock. The
+// firmware dispatch address is found from the byte at 0xCnFF of the slot ROM, plus 3 for SmartPort.
+// Neither DOS nor ProDOS is needed in memory.
+func writeCommandsToLoadSmartPortClientProgramToMemory(slot int, unit int, firstBlock int, blockCount int, SEGMENT_SIZE int, LINE_START_PAD_LENGTH int) error {
+	if blockCount < 1 || blockCount > 8 {
+		return fmt.Errorf("illegal block count encountered: %d", blockCount)
+	}
+	var slotPage byte = byte(0xC0 + slot)
+	var clientPage byte = byte(clientAddress >> 8)
+	var clientProgram []byte = []byte{
+			'\xAD', '\xFF', slotPage, // find SmartPort entry point from slot ROM
+			'\x18',
+			'\x69', '\x03',
+			'\x8D', '\x0A', clientPage, // modify the call below
+			'\x20', '\x00', slotPage, // call SmartPort
+			'\x02', // write block command
+			'\x30', clientPage, // parameter list address is '\x0C30'
+			'\xB0', '\x14', // break on error
+			'\xEE', '\x34', clientPage, // modify parameter list : advance to next block (block is in '\x0C34')
+			'\xD0', '\x03',
+			'\xEE', '\x35', clientPage,
+			'\xEE', '\x33', clientPage, // modify parameter list : advance two memory pages (buffer is in '\x0C33')
+			'\xEE', '\x33', clientPage,
+			'\xCE', '\x3F', clientPage, // count down remaining blocks
+			'\xD0', '\xE5', //iterate
+			'\x60', // return from client
+			'\x00', // break
+			'\x00', '\x00', '\x00', '\x00', '\x00', '\x00', '\x00', '\x00', '\x00', '\x00', // not used
+			'\x03', byte(unit), // parameter count / unit number
+			'\x00', byte(bufferAddress >> 8), // data buffer address (starts at 0x2000)
+			byte(firstBlock), byte(firstBlock >> 8), byte(firstBlock >> 16), // block number
+			'\x00', '\x00', '\x00', '\x00', '\x00', '\x00', '\x00', '\x00', // not used
+			byte(blockCount) } // remaining blocks
+	var lineStartPad string
+	generateLineStartPad(&lineStartPad, LINE_START_PAD_LENGTH)
+	for sourceBytesStartPos := 0; sourceBytesStartPos < len(clientProgram); sourceBytesStartPos = sourceBytesStartPos + SEGMENT_SIZE {
+		writeCommandsToFillAppleMemorySegment(clientProgram, lineStartPad, clientAddress+sourceBytesStartPos, sourceBytesStartPos, SEGMENT_SIZE)
+	}
+	return nil
+}
+
+// writeCommandsToLoadMliClientProgramToMemory outputs a series of memory transfer commands to the
+// apple ][ monitor which load a machine language program (at 0x0C00) that calls the WRITE_BLOCK call
+// of the ProDOS MLI to write blockCount blocks of 512 bytes from the memory starting at 0x2000 to the
+// block device of slot slot and drive drive (such as a CFFA card, or the /RAM disk of slot 3 drive 2),
+// starting at block firstBlock. ProDOS must be in memory, with the monitor entered from BASIC.SYSTEM.
+// The parameter list is at the same place as that of the SmartPort client, so that both are reset by
+// writeCommandsToResetBlockClientParameters.
+func writeCommandsToLoadMliClientProgramToMemory(slot int, drive int, firstBlock int, blockCount int, SEGMENT_SIZE int, LINE_START_PAD_LENGTH int) error {
+	if blockCount < 1 || blockCount > 8 {
+		return fmt.Errorf("illegal block count encountered: %d", blockCount)
+	}
+	var unitNumber byte = byte((drive-1)<<7 | slot<<4)
+	var clientPage byte = byte(clientAddress >> 8)
+	var clientProgram []byte = []byte{
+		'\x20', '\x00', '\xBF', // call MLI
+		'\x81',             // write block command
+		'\x30', clientPage, // parameter list address is '\x0C30'
+		'\xB0', '\x14', // break on error
+		'\xEE', '\x33', clientPage, // modify parameter list : advance two memory pages (buffer is in '\x0C33')
+		'\xEE', '\x33', clientPage,
+		'\xEE', '\x34', clientPage, // modify parameter list : advance to next block (block is in '\x0C34')
+		'\xD0', '\x03',
+		'\xEE', '\x35', clientPage,
+		'\xCE', '\x3F', clientPage, // count down remaining blocks
+		'\xD0', '\xE5', //iterate
+		'\x60',                                                                                                                                                 // return from client
+		'\x00',                                                                                                                                                 // break
+		'\x00', '\x00', '\x00', '\x00', '\x00', '\x00', '\x00', '\x00', '\x00', '\x00', '\x00', '\x00', '\x00', '\x00', '\x00', '\x00', '\x00', '\x00', '\x00', // not used
+		'\x03', unitNumber, // parameter count / unit number
+		'\x00', byte(bufferAddress >> 8), // data buffer address (starts at 0x2000)
+		byte(firstBlock), byte(firstBlock >> 8), // block number
+		'\x00', '\x00', '\x00', '\x00', '\x00', '\x00', '\x00', '\x00', '\x00', // not used
+		byte(blockCount)} // remaining blocks
+	var lineStartPad string
+	generateLineStartPad(&lineStartPad, LINE_START_PAD_LENGTH)
+	for sourceBytesStartPos := 0; sourceBytesStartPos < len(clientProgram); sourceBytesStartPos = sourceBytesStartPos + SEGMENT_SIZE {
+		writeCommandsToFillAppleMemorySegment(clientProgram, lineStartPad, clientAddress+sourceBytesStartPos, sourceBytesStartPos, SEGMENT_SIZE)
+	}
+	return nil
+}
+
+// writeCommandsToResetBlockClientParameters outputs the command which sets the data buffer address,
+// the block number and the count of remaining blocks of the parameter list of an already loaded
+// SmartPort or MLI client back to their starting values for blockCount blocks from block firstBlock,
+// so that the client can be executed again for another block group.
+func writeCommandsToResetBlockClientParameters(firstBlock int, blockCount int, lineStartPad string) {
+	// data buffer address / block number / not used / remaining blocks, as in the loaded parameter list
+	var parameterReset []byte = []byte{
+		'\x00', byte(bufferAddress >> 8), byte(firstBlock), byte(firstBlock >> 8), byte(firstBlock >> 16),
+		'\x00', '\x00', '\x00', '\x00', '\x00', '\x00', '\x00', '\x00', byte(blockCount)}
+	writeCommandsToFillAppleMemorySegment(parameterReset, lineStartPad, clientAddress+0x32, 0, len(parameterReset))
+}
+
+// parseBlockGroupList fills groupNums with the block groups of groupList, a comma separated list of
+// block group numbers and ranges of them (such as 0-99,150), in the order given. The groups must be
+// among the groupCount groups of the image.
+func parseBlockGroupList(groupNums *[]int, groupList string, groupCount int) error {
+	return parseNumberList(groupNums, groupList, groupCount-1, "block group")
+}
+
+// writeCommandsToInstallBlockGroups outputs the commands which install each of the block groups
+// groupNums (the 8 blocks, 4KB, starting at block 8 * groupNum) of the ProDOS ordered diskImage. With
+// the "smartport" blockClient they are written with the SmartPort firmware in slot slot to unit unit,
+// such as the 1600 blocks of an 800K image onto a 3.5" drive. With "mli" they are written with the
+// ProDOS MLI to the block device of slot slot and drive unit, such as a hard disk image of any size up
+// to 65535 blocks onto a CFFA card. For each group the blocks are loaded into memory at 0x2000, and then the client is
+// executed. The client is loaded only once, with the first group: for each further group the buffer
+// address, the block number and the block count are stored back into its parameter list (the client
+// leaves them advanced). A final group at the end of the image may hold fewer than 8 blocks. Every group
+// but the last is followed by settleCharCount spaces covering the writing of the blocks. The install
+// stops at the end of the group in which writing the commands failed.
+func writeCommandsToInstallBlockGroups(diskImage []byte, groupNums []int, blockClient string, slot int, unit int, settleCharCount int, SEGMENT_SIZE int, LINE_START_PAD_LENGTH int) error {
+	var groupCount int = (len(diskImage) + 0x0FFF) / 0x1000
+	if blockClient == "mli" && len(diskImage) > 0xFFFF*diskimage.PRODOS_BLOCK_SIZE {
+		return failure.CodedErrorf(failure.KIND_VOLUME_TOO_LARGE, "ProDOS block devices hold at most 65535 blocks, the image holds %d", len(diskImage)/diskimage.PRODOS_BLOCK_SIZE)
+	}
+	for _, groupNum := range groupNums {
+		if groupNum < 0 || groupNum >= groupCount {
+			return failure.CodedErrorf(failure.KIND_ILLEGAL_BLOCK_GROUP, "illegal block group number encountered: %d, image holds %d groups of 8 blocks", groupNum, groupCount)
+		}
+	}
+	var unitName string = "unit"
+	if blockClient == "mli" {
+		unitName = "drive"
+	}
+	var lineStartPad string
+	generateLineStartPad(&lineStartPad, LINE_START_PAD_LENGTH)
+	var executeCommand string
+	generateExecuteCommand(&executeCommand, clientAddress)
+	var pipeline *trackPipeline = startTrackPipeline()
+	for i, groupNum := range groupNums {
+		progressEventTrack = groupNum
+		emitProgressEvent("track_started", commandOutput.lineCount, commandOutput.charCount)
+		var firstBlock int = groupNum * 8
+		var blockCount int = (len(diskImage) - groupNum*0x1000 + diskimage.PRODOS_BLOCK_SIZE - 1) / diskimage.PRODOS_BLOCK_SIZE
+		if blockCount > 8 {
+			blockCount = 8
+		}
+		writeCommandsToLoadDiskBytesToMemory(diskImage, groupNum*0x1000, blockCount*diskimage.PRODOS_BLOCK_SIZE, SEGMENT_SIZE, LINE_START_PAD_LENGTH)
+		var err error
+		if i == 0 && blockClient == "mli" {
+			err = writeCommandsToLoadMliClientProgramToMemory(slot, unit, firstBlock, blockCount, SEGMENT_SIZE, LINE_START_PAD_LENGTH)
+		} else if i == 0 {
+			err = writeCommandsToLoadSmartPortClientProgramToMemory(slot, unit, firstBlock, blockCount, SEGMENT_SIZE, LINE_START_PAD_LENGTH)
+		} else {
+			writeCommandsToResetBlockClientParameters(firstBlock, blockCount, lineStartPad)
+		}
+		if err != nil {
+			failCommandStream(err)
+			break
+		}
+		endProgressLine()
+		fmt.Fprintf(os.Stderr, "executing binary client program to write blocks %d through %d to slot %d %s %d\n", firstBlock, firstBlock+blockCount-1, slot, unitName, unit)
+		fmt.Fprintf(&commandOutput, "%s%s\r", lineStartPad, executeCommand)
+		if i < len(groupNums)-1 {
+			writeCommandsToSettle(settleCharCount)
+		}
+		emitProgressEvent("track_finished", commandOutput.lineCount, commandOutput.charCount)
+		pipeline.endTrack()
+		if commandOutput.err != nil {
+			break
+		}
+	}
+	return pipeline.finish()
+}
+
+// SmartPort section end
+
+// Bootstrap section begin
+
+// writeCommandsToLoadBootstrapWriterProgramToMemory outputs the memory transfer commands which load a
+// machine language program (at 0x0C00) that writes a whole track on the Disk II drive targetDiskDrive
+// of slot targetDiskSlot by driving the disk controller directly, so that neither DOS nor its RWTS
+// routine (at 0x03D9) is needed, only the monitor. The track number is kept at 0x0D7C. The writer turns the motor on, steps the head
+// to the track (first moving it back to track 0 when its position is unknown, on the first run, and
+// using the monitor WAIT routine for the timing) and waits for the disk to reach speed. It breaks into
+// the monitor when the disk is write protected. Otherwise it writes a gap of sync bytes, and then for
+// each of the 16 sectors the disk bytes of its address field and data field, separated and followed by
+// gaps of sync bytes, all in one revolution of the disk. The disk bytes are taken from two memory pages
+// per sector starting at 0x2000, as placed by writeCommandsToLoadBootstrapTrackFieldsToMemory. Every
+// disk byte is stored 32 cycles after the one before it (40 cycles for sync bytes, which leaves the two
+// extra zero bits the disk controller needs to synchronize on them), so the timing pads in the loops
+// must not be changed. The track is formatted as it is written, so the disk needs no formatting. For a
+// 13-sector image the writer writes 13 sectors, with the longer data fields of 5-and-3 encoding.
+func writeCommandsToLoadBootstrapWriterProgramToMemory(SEGMENT_SIZE int, LINE_START_PAD_LENGTH int) {
+	var writerProgram []byte
+	generateBootstrapWriterProgram(&writerProgram)
+	var lineStartPad string
+	generateLineStartPad(&lineStartPad, LINE_START_PAD_LENGTH)
+	for sourceBytesStartPos := 0; sourceBytesStartPos < len(writerProgram); sourceBytesStartPos = sourceBytesStartPos + SEGMENT_SIZE {
+		writeCommandsToFillAppleMemorySegment(writerProgram, lineStartPad, clientAddress+sourceBytesStartPos, sourceBytesStartPos, SEGMENT_SIZE)
+	}
+}
+
+// generateBootstrapWriterProgram generates the machine language program loaded by
+// writeCommandsToLoadBootstrapWriterProgramToMemory, with its track number at offset 0x017C set to 0.
+// The program is stored in the slice pointed to by writerProgram.
+func generateBootstrapWriterProgram(writerProgram *[]byte) {
+	*writerProgram = []byte{
+		'\xA2', '\x60', // slot 6 soft switches are indexed by X = 0x60
+		'\xBD', '\x89', '\xC0', '\xBD', '\x8A', '\xC0', // drive 1 motor on
+		'\xAD', '\x7B', '\x0D', '\x10', '\x0A', '\xA9', '\x50', '\x8D', '\x7B', '\x0D', '\xA9', '\x00', '\x20', '\x2A', '\x0D', // on the first run the head position is unknown (0xFF) : pretend half track 0x50 and seek to 0
+		'\xAD', '\x7C', '\x0D', '\x0A', '\x20', '\x2A', '\x0D', // seek to the half track of the track
+		'\xA0', '\x06', '\xA9', '\x00', '\x20', '\xA8', '\xFC', '\x88', '\xD0', '\xF8', // wait around a second for the motor to reach speed
+		'\xBD', '\x8D', '\xC0', '\xBD', '\x8E', '\xC0', '\x10', '\x03', '\x4C', '\x26', '\x0D', // sense write protect
+		'\xA9', '\x00', '\x85', '\x06', '\x85', '\x08', '\xA9', '\x20', '\x85', '\x07', '\xA9', '\x21', '\x85', '\x09', // data pointers (0x06 and 0x08) at the two pages of sector 0
+		'\xA9', '\x10', '\x8D', '\x7D', '\x0D', // 16 sectors to write
+		'\xA9', '\xFF', '\xA0', '\x1F', '\x9D', '\x8F', '\xC0', '\x1D', '\x8C', '\xC0', '\xEA', '\x4C', '\x54', '\x0C', '\xEA', '\xEA', // write mode : first gap of sync bytes
+		'\xEA', '\xEA', '\xEA', '\xEA', '\xEA', '\xEA', '\xEA', '\xEA', '\xEA', '\xEA', '\xEA', '\x9D', '\x8D', '\xC0', '\x1D', '\x8C',
+		'\xC0', '\x88', '\xD0', '\xEA', '\xEA', '\xEA', '\xEA', '\xEA', '\xEA', '\xEA', '\xEA', '\xEA', '\xEA', '\xEA',
+		'\xA0', '\x00', '\xB1', '\x06', '\x9D', '\x8D', '\xC0', '\x1D', '\x8C', '\xC0', '\x24', '\x00', '\xEA', '\xEA', '\xEA', '\xEA', // address field, 14 disk bytes at the start of the first page
+		'\xC8', '\xC0', '\x0E', '\xD0', '\xED',
+		'\xA9', '\xFF', '\xA0', '\x06', '\xEA', '\xEA', '\x4C', '\x9F', '\x0C', '\xEA', '\xEA', '\xEA', '\xEA', '\xEA', '\xEA', '\xEA', // second gap of sync bytes
+		'\xEA', '\xEA', '\xEA', '\xEA', '\xEA', '\xEA', '\x9D', '\x8D', '\xC0', '\x1D', '\x8C', '\xC0', '\x88', '\xD0', '\xEA',
+		'\xA0', '\x0E', '\xEA', '\xEA', '\xEA', '\xEA', '\xEA', '\xEA', '\xEA', '\xEA', '\xEA', '\xEA', '\xB1', '\x06', '\x9D', '\x8D', // data field, the rest of the first page
+		'\xC0', '\x1D', '\x8C', '\xC0', '\x24', '\x00', '\xEA', '\xEA', '\xEA', '\xEA', '\xC8', '\xF0', '\x03', '\x4C', '\xB4', '\x0C',
+		'\xEA',
+		'\xB1', '\x08', '\x9D', '\x8D', '\xC0', '\x1D', '\x8C', '\xC0', '\x24', '\x00', '\xEA', '\xEA', '\xEA', '\xEA', '\xC8', '\xC0', // and the start of the second page
+		'\x6B', '\xD0', '\xED',
+		'\xA9', '\xFF', '\x24', '\x00', '\xEA', '\xEA', '\xEA', '\x9D', '\x8D', '\xC0', '\x1D', '\x8C', '\xC0', '\xE6', '\x07', '\xE6', // third gap of sync bytes, moving the data pointers to the next sector
+		'\x07', '\xE6', '\x09', '\xE6', '\x09', '\xA0', '\x0D', '\xEA', '\xEA', '\xEA', '\x4C', '\x06', '\x0D', '\x24', '\x00', '\xEA',
+		'\xEA', '\xEA', '\xEA', '\xEA', '\xEA', '\xEA', '\xEA', '\xEA', '\xEA', '\xEA', '\x9D', '\x8D', '\xC0', '\x1D', '\x8C', '\xC0',
+		'\x88', '\xD0', '\xEA', '\x24', '\x00', '\xEA', '\xEA', '\xEA',
+		'\xCE', '\x7D', '\x0D', '\xF0', '\x03', '\x4C', '\x74', '\x0C', // iterate
+		'\xBD', '\x8E', '\xC0', '\xBD', '\x8C', '\xC0', '\xBD', '\x88', '\xC0', '\x60', // read mode, motor off and return from writer
+		'\xBD', '\x88', '\xC0', '\x00', // write protected : motor off and break
+		'\x8D', '\x7E', '\x0D', '\xAD', '\x7B', '\x0D', '\x29', '\x03', '\x0A', '\x09', '\x60', '\x8D', '\x7F', '\x0D', '\xAD', '\x7B', // seek : step the head one half track at a time towards the half track in A
+		'\x0D', '\xCD', '\x7E', '\x0D', '\xF0', '\x27', '\xB0', '\x04', '\x69', '\x01', '\x90', '\x02', '\xE9', '\x01', '\x8D', '\x7B',
+		'\x0D', '\x29', '\x03', '\x0A', '\x09', '\x60', '\xAA', '\xBD', '\x81', '\xC0', '\xA9', '\x40', '\x20', '\xA8', '\xFC', '\xAE',
+		'\x7F', '\x0D', '\xBD', '\x80', '\xC0', '\xA9', '\x40', '\x20', '\xA8', '\xFC', '\x4C', '\x2D', '\x0D',
+		'\xA9', '\x56', '\x20', '\xA8', '\xFC', '\xAD', '\x7B', '\x0D', '\x29', '\x03', '\x0A', '\x09', '\x60', '\xAA', '\xBD', '\x80', // let the head settle and turn the last phase off
+		'\xC0', '\xA2', '\x60', '\x60',
+		'\xFF', '\x00', '\x00', '\x00', '\x00', // current half track / track / sectors left / target half track / previous phase
+	}
+	// the soft switches of slot 6 drive 1 above are moved to those of targetDiskSlot and targetDiskDrive
+	for _, slotPos := range []int{0x0001, 0x0134, 0x014F, 0x0173, 0x0179} {
+		(*writerProgram)[slotPos] = byte(targetDiskSlot * 0x10)
+	}
+	(*writerProgram)[0x0006] = byte(0x8A + targetDiskDrive - 1)
+	// the addresses within the writer above are moved to clientAddress, and its data pointers to bufferAddress
+	for _, addressPos := range []int{0x000A, 0x0011, 0x0016, 0x0019, 0x001D, 0x0032, 0x0045, 0x0053, 0x0091, 0x00C7, 0x00F8,
+		0x0116, 0x011B, 0x012C, 0x012F, 0x0137, 0x013A, 0x013D, 0x014A, 0x015B, 0x0166, 0x016E} {
+		(*writerProgram)[addressPos] = (*writerProgram)[addressPos] - '\x0C' + byte(clientAddress>>8)
+	}
+	(*writerProgram)[0x003A] = byte(bufferAddress >> 8)
+	(*writerProgram)[0x003E] = byte(bufferAddress>>8) + 1
+	// the count of sectors and the length of the data field in the second page of each sector
+	var sectorCount, secondPageByteCount int
+	bootstrapTrackLayout(&sectorCount, &secondPageByteCount)
+	(*writerProgram)[0x0042] = byte(sectorCount)
+	(*writerProgram)[0x00D9] = byte(secondPageByteCount)
+}
+
+// bootstrapTrackLayout stores into sectorCount the count of sectors of a track the bootstrap writer
+// writes, and into secondPageByteCount the count of bytes of the data field of a sector held in its
+// second page: 16 sectors and 107 bytes (0x6B), or for a 13-sector image 13 sectors and 175 bytes
+// (0xAF) of the 417 byte 5-and-3 encoded data field.
+func bootstrapTrackLayout(sectorCount *int, secondPageByteCount *int) {
+	*sectorCount = 0x10
+	*secondPageByteCount = 0x6B
+	if diskImageIs13Sector {
+		*sectorCount = gcr.D13_SECTOR_COUNT
+		*secondPageByteCount = 0xAF
+	}
+}
+
+// bootstrapTrackByteCount returns the count of bytes of a track loaded for the bootstrap writer: the
+// used bytes of the two pages of each sector.
+func bootstrapTrackByteCount() int {
+	var sectorCount, secondPageByteCount int
+	bootstrapTrackLayout(&sectorCount, &secondPageByteCount)
+	return sectorCount * (0x0100 + secondPageByteCount)
+}
+
+// fillBootstrapTrackFields fills the 8KB trackFields with the disk bytes the bootstrap writer writes for
+// track trackNum of the diskImage (in DOS3.3 sector order), as a DOS 3.3 disk of volume targetDiskVolume
+// (or 254 when it is 0). Physical
+// sector n takes the two memory pages at offset 0x200 * n: the first page holds the 14 bytes of its
+// address field followed by the first 242 bytes of its data field, and the second page starts with the
+// remaining 107 bytes of the data field. A page is read whole in one loop, so that the loop timing
+// never changes. The 13 sectors of a 13-sector image are placed in the same way in the order of
+// DOS32_SECTOR_SKEW, as a DOS 3.2 disk, their second pages holding the remaining 175 bytes.
+func fillBootstrapTrackFields(trackFields []byte, diskImage []byte, trackNum int) error {
+	var addressField, dataField []byte
+	var volume byte = targetDiskVolume
+	if volume == '\x00' {
+		volume = '\xFE'
+	}
+	if diskImageIs13Sector {
+		for i, sector := range gcr.DOS32_SECTOR_SKEW {
+			gcr.Encode13SectorFields(&addressField, &dataField, diskImage, trackNum, sector, volume)
+			var fieldsPos int = i * 0x0200
+			copy(trackFields[fieldsPos:fieldsPos+0x0200], append(addressField, dataField...))
+		}
+		return nil
+	}
+	for sector := 0; sector < 0x10; sector = sector + 1 {
+		var err error = gcr.EncodeSectorFields(&addressField, &dataField, diskImage, trackNum, sector, volume)
+		if err != nil {
+			return err
+		}
+		var fieldsPos int = sector * 0x0200
+		copy(trackFields[fieldsPos:fieldsPos+0x0200], append(addressField, dataField...))
+	}
+	return nil
+}
+
+// writeCommandsToLoadBootstrapTrackFieldsToMemory outputs the commands which load the disk bytes of
+// track trackNum of the diskImage (in DOS3.3 sector order) into memory for the bootstrap writer, as
+// placed by fillBootstrapTrackFields. Only the bytes used of each pair of pages are sent (363, or 431
+// for a 13-sector image). The first
+// page is sent by writeCommandsToLoadDiskBytesToMemory, so that it is preceded by the ramp-up sequence.
+func writeCommandsToLoadBootstrapTrackFieldsToMemory(diskImage []byte, trackNum int, SEGMENT_SIZE int, LINE_START_PAD_LENGTH int) error {
+	if trackNum < 0x0 || trackNum > 0x22 {
+		return failure.TrackErrorf(failure.KIND_ILLEGAL_TRACK, trackNum, "illegal track number encountered: %d", trackNum)
+	}
+	var trackFields []byte = make([]byte, 0x2000)
+	var err error = fillBootstrapTrackFields(trackFields, diskImage, trackNum)
+	if err != nil {
+		return err
+	}
+	writeCommandsToLoadDiskBytesToMemory(trackFields, 0x0000, 0x0100, SEGMENT_SIZE, LINE_START_PAD_LENGTH)
+	var lineStartPad string
+	generateLineStartPad(&lineStartPad, LINE_START_PAD_LENGTH)
+	var sectorCount, secondPageByteCount int
+	bootstrapTrackLayout(&sectorCount, &secondPageByteCount)
+	for fieldsPos := 0x0100; fieldsPos < sectorCount*0x0200; fieldsPos = fieldsPos + 0x0100 {
+		var fieldsEndPos int = fieldsPos + 0x0100
+		if fieldsPos%0x0200 != 0 {
+			// the second page of a sector holds the last bytes of its data field
+			fieldsEndPos = fieldsPos + secondPageByteCount
+		}
+		for segmentPos := fieldsPos; segmentPos < fieldsEndPos; segmentPos = segmentPos + SEGMENT_SIZE {
+			var writeByteCount int = SEGMENT_SIZE
+			if segmentPos+writeByteCount > fieldsEndPos {
+				writeByteCount = fieldsEndPos - segmentPos
+			}
+			writeCommandsToFillAppleMemorySegment(trackFields, lineStartPad, bufferAddress+segmentPos, segmentPos, writeByteCount)
+		}
+	}
+	return nil
+}
+
+// writeCommandsToInstallDiskTracksWithBootstrapWriter outputs the commands which install each of the
+// tracks trackNums of the diskImage (in DOS3.3 sector order) with the bootstrap writer, on an apple ][
+// running only the monitor. The writer is loaded only once, with the first track: for each track the
+// disk bytes of the track are loaded, the track number is stored into the writer, and the writer is
+// executed. Every track but the last is followed by settleCharCount spaces covering the track write.
+// The install stops at the end of the track in which writing the commands failed.
+func writeCommandsToInstallDiskTracksWithBootstrapWriter(diskImage []byte, trackNums []int, settleCharCount int, SEGMENT_SIZE int, LINE_START_PAD_LENGTH int) error {
+	var lineStartPad string
+	generateLineStartPad(&lineStartPad, LINE_START_PAD_LENGTH)
+	var executeCommand string
+	generateExecuteCommand(&executeCommand, clientAddress)
+	var pipeline *trackPipeline = startTrackPipeline()
+	for i, trackNum := range trackNums {
+		progressEventTrack = trackNum
+		emitProgressEvent("track_started", commandOutput.lineCount, commandOutput.charCount)
+		var err error = writeCommandsToLoadBootstrapTrackFieldsToMemory(diskImage, trackNum, SEGMENT_SIZE, LINE_START_PAD_LENGTH)
+		if err != nil {
+			failCommandStream(err)
+			break
+		}
+		if i == 0 {
+			writeCommandsToLoadBootstrapWriterProgramToMemory(SEGMENT_SIZE, LINE_START_PAD_LENGTH)
+		}
+		writeCommandsToFillAppleMemorySegment([]byte{byte(trackNum)}, lineStartPad, clientAddress+0x017C, 0, 1)
+		endProgressLine()
+		fmt.Fprintf(os.Stderr, "executing bootstrap writer program to write track %d\n", trackNum)
+		fmt.Fprintf(&commandOutput, "%s%s\r", lineStartPad, executeCommand)
+		if i < len(trackNums)-1 {
+			writeCommandsToSettle(settleCharCount)
+		}
+		emitProgressEvent("track_finished", commandOutput.lineCount, commandOutput.charCount)
+		pipeline.endTrack()
+		if commandOutput.err != nil {
+			break
+		}
+	}
+	return pipeline.finish()
+}
+
+// Bootstrap section end
+
+// Applesoft section begin
+
+// APPLESOFT_TOKENS are the keywords of Applesoft BASIC, in the order of their tokens from 0x80, which is
+// the order the Applesoft tokenizer tries them in.
+var APPLESOFT_TOKENS []string = []string{
+	"END", "FOR", "NEXT", "DATA", "INPUT", "DEL", "DIM", "READ", "GR", "TEXT", "PR#", "IN#", "CALL", "PLOT", "HLIN", "VLIN",
+	"HGR2", "HGR", "HCOLOR=", "HPLOT", "DRAW", "XDRAW", "HTAB", "HOME", "ROT=", "SCALE=", "SHLOAD", "TRACE", "NOTRACE", "NORMAL", "INVERSE", "FLASH",
+	"COLOR=", "POP", "VTAB", "HIMEM:", "LOMEM:", "ONERR", "RESUME", "RECALL", "STORE", "SPEED=", "LET", "GOTO", "RUN", "IF", "RESTORE", "&",
+	"GOSUB", "RETURN", "REM", "STOP", "ON", "WAIT", "LOAD", "SAVE", "DEF", "POKE", "PRINT", "CONT", "LIST", "CLEAR", "GET", "NEW",
+	"TAB(", "TO", "FN", "SPC(", "THEN", "AT", "NOT", "STEP", "+", "-", "*", "/", "^", "AND", "OR", ">",
+	"=", "<", "SGN", "INT", "ABS", "USR", "FRE", "SCRN(", "PDL", "POS", "SQR", "RND", "LOG", "EXP", "COS", "SIN",
+	"TAN", "ATN", "PEEK", "LEN", "STR$", "VAL", "ASC", "CHR$", "LEFT$", "RIGHT$", "MID$"}
+
+// APPLESOFT_TYPED_LINE_LENGTH is the longest line of a loader program typed into Applesoft, which like a
+// monitor command must be echoed on one screen line, after the surviving padding and the prompt.
+const APPLESOFT_TYPED_LINE_LENGTH = emulator.SCREEN_COLUMNS - ECHO_MARGIN - PAD_MARGIN - 1
+
+// APPLESOFT_FILE_LINE_LENGTH is the longest line of a loader program written to a file, the longest
+// line Applesoft accepts when the program is edited.
+const APPLESOFT_FILE_LINE_LENGTH = 239
+
+// APPLESOFT_RELINK_TIME_PER_BYTE is the time Applesoft spends on each byte of the program when it
+// relinks the lines after a line is typed in, which it does over the whole program.
+const APPLESOFT_RELINK_TIME_PER_BYTE = 10 * time.Microsecond
+
+// APPLESOFT_POKE_TIME_PER_BYTE is the time the loader program takes to read and poke each byte.
+const APPLESOFT_POKE_TIME_PER_BYTE = 4 * time.Millisecond
+
+// APPLESOFT_HIMEM is the end of the memory free for an Applesoft program below DOS 3.3.
+const APPLESOFT_HIMEM = 0x9600
+
+// tokenizeApplesoftLine tokenizes the text of a line of an Applesoft program (without its line
+// number) as Applesoft does when the line is typed in: spaces are dropped except in strings and DATA
+// statements, keywords are replaced by their tokens (tried in the order of APPLESOFT_TOKENS, ignoring
+// spaces within them), and the rest of a REM statement is kept as typed. The tokens are stored in the
+// slice pointed to by tokenized.
+func tokenizeApplesoftLine(tokenized *[]byte, text string) {
+	var line []byte
+	var inData bool = false
+	var pos int = 0
+	for pos < len(text) {
+		var c byte = text[pos]
+		if c == '"' {
+			// a string left open runs to the end of the line
+			var quoteEnd int = strings.IndexByte(text[pos+1:], '"')
+			if quoteEnd < 0 {
+				line = append(line, text[pos:]...)
+				pos = len(text)
+			} else {
+				line = append(line, text[pos:pos+quoteEnd+2]...)
+				pos = pos + quoteEnd + 2
+			}
+			continue
+		}
+		if inData {
+			inData = c != ':'
+			line = append(line, c)
+			pos = pos + 1
+			continue
+		}
+		if c == ' ' {
+			pos = pos + 1
+			continue
+		}
+		var matched bool = false
+		for i, token := range APPLESOFT_TOKENS {
+			var end int = pos
+			var tokenPos int = 0
+			for tokenPos < len(token) && end < len(text) {
+				if text[end] == ' ' {
+					end = end + 1
+				} else if strings.ToUpper(text[end : end+1])[0] == token[tokenPos] {
+					end = end + 1
+					tokenPos = tokenPos + 1
+				} else {
+					break
+				}
+			}
+			if tokenPos < len(token) {
+				continue
+			}
+			line = append(line, byte(0x80+i))
+			pos = end
+			matched = true
+			if token == "DATA" {
+				inData = true
+			} else if token == "REM" {
+				line = append(line, text[pos:]...)
+				pos = len(text)
+			}
+			break
+		}
+		if !matched {
+			line = append(line, strings.ToUpper(text[pos:pos+1])...)
+			pos = pos + 1
+		}
+	}
+	*tokenized = line
+}
+
+// generateApplesoftLoaderProgram generates the lines of an Applesoft program which pokes program into
+// memory at clientAddress and data at bufferAddress, and then calls program. The bytes are held in DATA
+// statements as records of an address, a byte count and the bytes in decimal; a record with no bytes
+// ends the program by calling its address. Each DATA line is at most lineLength characters long. The
+// lines, with their line numbers, are stored in the slice pointed to by lines.
+func generateApplesoftLoaderProgram(lines *[]string, program []byte, data []byte, lineLength int) {
+	var programLines []string = []string{
+		"1 READ A,N",
+		"2 IF N=0 THEN CALL A:END",
+		"3 FOR I=1 TO N:READ B",
+		"4 POKE A,B:A=A+1:NEXT",
+		"5 GOTO 1"}
+	var items []string = []string{strconv.Itoa(clientAddress), strconv.Itoa(len(program))}
+	for _, b := range program {
+		items = append(items, strconv.Itoa(int(b)))
+	}
+	items = append(items, strconv.Itoa(bufferAddress), strconv.Itoa(len(data)))
+	for _, b := range data {
+		items = append(items, strconv.Itoa(int(b)))
+	}
+	items = append(items, strconv.Itoa(clientAddress), "0")
+	var lineNum int = 10
+	var line string = ""
+	for _, item := range items {
+		if line != "" && len(line)+1+len(item) > lineLength {
+			programLines = append(programLines, line)
+			lineNum = lineNum + 1
+			line = ""
+		}
+		if line == "" {
+			line = fmt.Sprintf("%dDATA%s", lineNum, item)
+		} else {
+			line = line + "," + item
+		}
+	}
+	*lines = append(programLines, line)
+}
+
+// splitApplesoftLineNumber splits a line of an Applesoft program into its line number and its text.
+func splitApplesoftLineNumber(lineNum *int, text *string, line string) {
+	var digitCount int = 0
+	for digitCount < len(line) && line[digitCount] >= '0' && line[digitCount] <= '9' {
+		digitCount = digitCount + 1
+	}
+	// the generated lines always start with their line number
+	*lineNum, _ = strconv.Atoi(line[:digitCount])
+	*text = line[digitCount:]
+}
+
+// applesoftLoaderStartAddress returns the address the loader programs are entered at: the page after
+// the 8KB data buffer and the two pages of the client, so that they do not poke over themselves.
+func applesoftLoaderStartAddress() int {
+	var pageAddress int = bufferAddress + 0x2000
+	if clientAddress+0x0200 > pageAddress {
+		pageAddress = clientAddress + 0x0200
+	}
+	return pageAddress + 1
+}
+
+// checkApplesoftLoaderFits returns an error when the loader program for track trackNum, programLength
+// bytes long from applesoftLoaderStartAddress, and its variables do not fit below APPLESOFT_HIMEM.
+func checkApplesoftLoaderFits(trackNum int, programLength int) error {
+	var startAddress int = applesoftLoaderStartAddress()
+	if startAddress+programLength+0x0100 > APPLESOFT_HIMEM {
+		return failure.TrackErrorf(failure.KIND_APPLESOFT_LOADER_SIZE, trackNum, "the Applesoft loader of track %d needs %d bytes from %04X, more than fit below DOS at %04X", trackNum, programLength+0x0100, startAddress, APPLESOFT_HIMEM)
+	}
+	return nil
+}
+
+// generateApplesoftProgramFile generates the tokenized form of the Applesoft program lines, as held
+// in memory from startAddress and in a BAS file: each line is the address of the next line, the line
+// number, the tokens and a zero, and the program ends with a zero link. The bytes are stored in the
+// slice pointed to by programFile.
+func generateApplesoftProgramFile(programFile *[]byte, lines []string, startAddress int) {
+	var file []byte
+	for _, line := range lines {
+		var lineNum int
+		var text string
+		splitApplesoftLineNumber(&lineNum, &text, line)
+		var tokenized []byte
+		tokenizeApplesoftLine(&tokenized, text)
+		var nextLineAddress int = startAddress + len(file) + 4 + len(tokenized) + 1
+		file = append(file, byte(nextLineAddress), byte(nextLineAddress>>8), byte(lineNum), byte(lineNum>>8))
+		file = append(file, tokenized...)
+		file = append(file, 0x00)
+	}
+	*programFile = append(file, 0x00, 0x00)
+}
+
+// generateApplesoftRelocationCommands generates the Applesoft commands which move the start of the
+// program to startAddress (with the zero byte Applesoft needs before it) and clear the program. They
+// are stored in the slice pointed to by commands.
+func generateApplesoftRelocationCommands(commands *[]string, startAddress int) {
+	*commands = []string{
+		fmt.Sprintf("POKE%d,0", startAddress-1),
+		fmt.Sprintf("POKE103,%d", startAddress&0xFF),
+		fmt.Sprintf("POKE104,%d", startAddress>>8),
+		"NEW"}
+}
+
+// writeCommandsToInstallDiskTracksWithApplesoftLoader outputs the commands which install each of the
+// tracks trackNums of the diskImage (in DOS3.3 sector order) by typing into Applesoft, instead of the
+// monitor, a loader program from generateApplesoftLoaderProgram holding the RWTS client for the track
+// with clientStrategy and the track data, and running it. The start of the program is first moved
+// above the data buffer and the client. Applesoft relinks the whole program after each line is typed,
+// so each line start pad is lengthened in proportion to the program typed so far. Every track but the
+// last is followed by settleCharCount spaces covering the poking of the bytes and the track write.
+// The install stops at the end of the track in which writing the commands failed.
+func writeCommandsToInstallDiskTracksWithApplesoftLoader(diskImage []byte, trackNums []int, clientStrategy string, settleCharCount int, LINE_START_PAD_LENGTH int) error {
+	var lineStartPad string
+	generateLineStartPad(&lineStartPad, LINE_START_PAD_LENGTH)
+	var startAddress int = applesoftLoaderStartAddress()
+	var relocationCommands []string
+	generateApplesoftRelocationCommands(&relocationCommands, startAddress)
+	var pipeline *trackPipeline = startTrackPipeline()
+	for i, trackNum := range trackNums {
+		progressEventTrack = trackNum
+		emitProgressEvent("track_started", commandOutput.lineCount, commandOutput.charCount)
+		var program, data []byte
+		var err error = generateTrackProgramAndData(&program, &data, diskImage, trackNum, clientStrategy, false)
+		if err != nil {
+			failCommandStream(err)
+			break
+		}
+		var lines []string
+		generateApplesoftLoaderProgram(&lines, program, data, APPLESOFT_TYPED_LINE_LENGTH)
+		var programFile []byte
+		generateApplesoftProgramFile(&programFile, lines, startAddress)
+		err = checkApplesoftLoaderFits(trackNum, len(programFile))
+		if err != nil {
+			failCommandStream(err)
+			break
+		}
+		for _, command := range relocationCommands {
+			fmt.Fprintf(&commandOutput, "%s%s\r", lineStartPad, command)
+		}
+		// the link of each line in the program tells the length of the program before the next line
+		var programLength int = 0
+		for _, line := range lines {
+			var relinkTime time.Duration = time.Duration(programLength) * APPLESOFT_RELINK_TIME_PER_BYTE
+			var relinkPad string = strings.Repeat(" ", int(math.Ceil(float64(len(lineStartPad))*relinkTime.Seconds()/MONITOR_LINE_PROCESSING_TIME.Seconds())))
+			fmt.Fprintf(&commandOutput, "%s%s%s\r", relinkPad, lineStartPad, line)
+			programLength = (int(programFile[programLength]) | int(programFile[programLength+1])<<8) - startAddress
+		}
+		endProgressLine()
+		fmt.Fprintf(os.Stderr, "running Applesoft loader program to write track %d\n", trackNum)
+		fmt.Fprintf(&commandOutput, "%sRUN\r", lineStartPad)
+		if i < len(trackNums)-1 {
+			writeCommandsToSettle(settleCharCount)
+		}
+		emitProgressEvent("track_finished", commandOutput.lineCount, commandOutput.charCount)
+		pipeline.endTrack()
+		if commandOutput.err != nil {
+			break
+		}
+	}
+	return pipeline.finish()
+}
+
+// writeApplesoftTrackToFile writes track trackNum of the diskImage (in DOS3.3 sector order) to the file
+// basFilepath as a tokenized Applesoft program from generateApplesoftLoaderProgram, holding the RWTS
+// client for the track with clientStrategy and the track data, to be added to a disk image (with add
+// and -file-type BAS or A) and run on the apple ][. The program is linked for the start address of
+// applesoftLoaderStartAddress, and the commands moving the start of the program there, to be typed
+// before running it, are reported to stderr.
+func writeApplesoftTrackToFile(basFilepath string, diskImage []byte, trackNum int, clientStrategy string) error {
+	var program, data []byte
+	var err error = generateTrackProgramAndData(&program, &data, diskImage, trackNum, clientStrategy, false)
+	if err != nil {
+		return err
+	}
+	var lines []string
+	generateApplesoftLoaderProgram(&lines, program, data, APPLESOFT_FILE_LINE_LENGTH)
+	var startAddress int = applesoftLoaderStartAddress()
+	var programFile []byte
+	generateApplesoftProgramFile(&programFile, lines, startAddress)
+	err = checkApplesoftLoaderFits(trackNum, len(programFile))
+	if err != nil {
+		return err
+	}
+	err = ioutil.WriteFile(basFilepath, programFile, 0644)
+	if err != nil {
+		return err
+	}
+	var relocationCommands []string
+	generateApplesoftRelocationCommands(&relocationCommands, startAddress)
+	fmt.Fprintf(os.Stderr, "wrote track %d to %s (%d bytes), type %s and run it\n", trackNum, basFilepath, len(programFile), strings.Join(relocationCommands, ":"))
+	return nil
+}
+
+// Applesoft section end
+
+// Cassette section begin
+
+// The cassette records are written as a square wave of 8 bit unsigned samples at
+// CASSETTE_SAMPLE_RATE, between the levels CASSETTE_LOW and CASSETTE_HIGH, with CASSETTE_SILENCE
+// before and after them.
+const CASSETTE_SAMPLE_RATE = 44100
+const CASSETTE_LOW = 0x20
+const CASSETTE_HIGH = 0xE0
+const CASSETTE_SILENCE = 0x80
+
+// The half cycles of the apple ][ cassette format, in microseconds: the 770 Hz header tone, the short
+// first and second half cycles of the sync bit, and those of 0 bits (2000 Hz) and 1 bits (1000 Hz).
+const CASSETTE_HEADER_HALF_CYCLE = 650
+const CASSETTE_SYNC_FIRST_HALF_CYCLE = 200
+const CASSETTE_SYNC_SECOND_HALF_CYCLE = 250
+const CASSETTE_ZERO_HALF_CYCLE = 250
+const CASSETTE_ONE_HALF_CYCLE = 500
+
+// CASSETTE_HEADER_TIME is the length of the header tone before each record. The monitor READ routine
+// ignores the first 3.5 seconds after it hears the tape, and then waits for the sync bit.
+const CASSETTE_HEADER_TIME = 5 * time.Second
+
+// cassetteWave is the sound of cassette records being built: the samples so far, the time (in
+// samples, with the fraction kept so that the half cycles do not drift) their last half cycle ends
+// at, and whether the next half cycle is high.
+type cassetteWave struct {
+	samples []byte
+	time    float64
+	high    bool
+}
+
+// halfCycle adds a half cycle of microseconds to the wave, alternating between high and low.
+func (w *cassetteWave) halfCycle(microseconds float64) {
+	var level byte = CASSETTE_LOW
+	if w.high {
+		level = CASSETTE_HIGH
+	}
+	w.time = w.time + microseconds*CASSETTE_SAMPLE_RATE/1000000
+	for float64(len(w.samples)) < w.time {
+		w.samples = append(w.samples, level)
+	}
+	w.high = !w.high
+}
+
+// byte adds the 8 bits of b to the wave, the highest bit first, each as a full cycle.
+func (w *cassetteWave) byte(b byte) {
+	for bit := 7; bit >= 0; bit = bit - 1 {
+		var halfCycle float64 = CASSETTE_ZERO_HALF_CYCLE
+		if b>>uint(bit)&1 != 0 {
+			halfCycle = CASSETTE_ONE_HALF_CYCLE
+		}
+		w.halfCycle(halfCycle)
+		w.halfCycle(halfCycle)
+	}
+}
+
+// silence adds duration of silence to the wave.
+func (w *cassetteWave) silence(duration time.Duration) {
+	var sampleCount int = int(duration.Seconds() * CASSETTE_SAMPLE_RATE)
+	for i := 0; i < sampleCount; i = i + 1 {
+		w.samples = append(w.samples, CASSETTE_SILENCE)
+	}
+	w.time = float64(len(w.samples))
+}
+
+// record adds a record of data to the wave as the monitor WRITE routine writes it: the header tone,
+// the sync bit, the bytes of data with the highest bit first, and a checksum byte (0xFF exclusive or
+// each byte of data), which the monitor READ routine checks, beeping and printing ERR when it differs.
+func (w *cassetteWave) record(data []byte) {
+	for i := 0; i < int(CASSETTE_HEADER_TIME.Seconds()*1000000/CASSETTE_HEADER_HALF_CYCLE); i = i + 1 {
+		w.halfCycle(CASSETTE_HEADER_HALF_CYCLE)
+	}
+	w.halfCycle(CASSETTE_SYNC_FIRST_HALF_CYCLE)
+	w.halfCycle(CASSETTE_SYNC_SECOND_HALF_CYCLE)
+	var checksum byte = 0xFF
+	for _, b := range data {
+		w.byte(b)
+		checksum = checksum ^ b
+	}
+	w.byte(checksum)
+	// a final header half cycle ends the last bit cleanly
+	w.halfCycle(CASSETTE_HEADER_HALF_CYCLE)
+}
+
+// writeWavFile writes the samples (8 bit unsigned, mono, at CASSETTE_SAMPLE_RATE) to the file
+// wavFilepath in the WAV format.
+func writeWavFile(wavFilepath string, samples []byte) error {
+	var header []byte = make([]byte, 44)
+	copy(header[0x00:], "RIFF")
+	binary.LittleEndian.PutUint32(header[0x04:], uint32(36+len(samples)))
+	copy(header[0x08:], "WAVEfmt ")
+	binary.LittleEndian.PutUint32(header[0x10:], 16)
+	binary.LittleEndian.PutUint16(header[0x14:], 1) // PCM
+	binary.LittleEndian.PutUint16(header[0x16:], 1) // mono
+	binary.LittleEndian.PutUint32(header[0x18:], CASSETTE_SAMPLE_RATE)
+	binary.LittleEndian.PutUint32(header[0x1C:], CASSETTE_SAMPLE_RATE)
+	binary.LittleEndian.PutUint16(header[0x20:], 1)
+	binary.LittleEndian.PutUint16(header[0x22:], 8)
+	copy(header[0x24:], "data")
+	binary.LittleEndian.PutUint32(header[0x28:], uint32(len(samples)))
+	return ioutil.WriteFile(wavFilepath, append(header, samples...), 0644)
+}
+
+// generateTrackProgramAndData generates the program installing track trackNum of the diskImage (in
+// DOS3.3 sector order), to be loaded at clientAddress, and the data it writes, to be loaded at
+// bufferAddress: the RWTS client with clientStrategy ("track" or "descending") and the track data, or
+// with bootstrap the bootstrap writer and the disk bytes of the track. They are stored in the slices
+// pointed to by program and data. It returns an error when the track is not in diskImage.
+func generateTrackProgramAndData(program *[]byte, data *[]byte, diskImage []byte, trackNum int, clientStrategy string, bootstrap bool) error {
+	if bootstrap {
+		generateBootstrapWriterProgram(program)
+		(*program)[0x017C] = byte(trackNum)
+		*data = make([]byte, 0x2000)
+		return fillBootstrapTrackFields(*data, diskImage, trackNum)
+	}
+	var err error = generateRWTSClientProgram(program, trackNum, RWTS_COMMAND_WRITE, clientStrategy)
+	if err != nil {
+		return err
+	}
+	var trackStartPos int = diskimage.DiskImageStartPosOfTrackSector(trackNum, 0)
+	if trackStartPos+0x1000 > len(diskImage) {
+		return failure.TrackErrorf(failure.KIND_ILLEGAL_TRACK, trackNum, "track %d is not in the image of %d bytes", trackNum, len(diskImage))
+	}
+	*data = diskImage[trackStartPos : trackStartPos+0x1000]
+	return nil
+}
+
+// generateCassetteReadCommand generates a command for the apple ][ monitor which reads a cassette
+// record into the byteCount bytes of memory starting at address startAddress. The command is stored
+// in the string pointed to by readCommand.
+func generateCassetteReadCommand(readCommand *string, startAddress int, byteCount int) {
+	*readCommand = fmt.Sprintf("%X.%XR", startAddress, startAddress+byteCount-1)
+}
+
+// writeCassetteTrackToWavFile writes track trackNum of the diskImage (in DOS3.3 sector order) to the
+// WAV file wavFilepath as two cassette records: the RWTS client for the track with clientStrategy
+// ("track" or "descending"), or with bootstrap the bootstrap writer, followed by the track data (or the
+// disk bytes of the track for the bootstrap writer). The monitor command which reads both records and
+// executes the client, to be typed before playing the file into the cassette input, is reported to
+// stderr. No serial card is needed.
+func writeCassetteTrackToWavFile(wavFilepath string, diskImage []byte, trackNum int, clientStrategy string, bootstrap bool) error {
+	var program, data []byte
+	var err error = generateTrackProgramAndData(&program, &data, diskImage, trackNum, clientStrategy, bootstrap)
+	if err != nil {
+		return err
+	}
+	var wave cassetteWave
+	wave.silence(time.Second / 2)
+	wave.record(program)
+	wave.silence(time.Second / 2)
+	wave.record(data)
+	wave.silence(time.Second / 2)
+	err = writeWavFile(wavFilepath, wave.samples)
+	if err != nil {
+		return err
+	}
+	var programReadCommand, dataReadCommand, executeCommand string
+	generateCassetteReadCommand(&programReadCommand, clientAddress, len(program))
+	generateCassetteReadCommand(&dataReadCommand, bufferAddress, len(data))
+	generateExecuteCommand(&executeCommand, clientAddress)
+	var duration time.Duration = time.Duration(float64(len(wave.samples)) / CASSETTE_SAMPLE_RATE * float64(time.Second))
+	fmt.Fprintf(os.Stderr, "wrote track %d to %s (%s), type %s %s %s and play it\n", trackNum, wavFilepath, duration.Round(time.Second), programReadCommand, dataReadCommand, executeCommand)
+	return nil
+}
+
+// Cassette section end
+
+// Hex record section begin
+
+// HEX_RECORD_LENGTH is the count of data bytes in each Intel HEX or S-record data record.
+const HEX_RECORD_LENGTH = 0x10
+
+// writeIntelHexRecords writes the data records holding the bytes of data, to be loaded at address
+// startAddress, to hexOutput in the Intel HEX format, each with its checksum (the two's complement of
+// the sum of the other bytes of the record).
+func writeIntelHexRecords(hexOutput *bytes.Buffer, startAddress int, data []byte) {
+	var pos int = 0
+	for pos < len(data) {
+		var recordEnd int = pos + HEX_RECORD_LENGTH
+		if recordEnd > len(data) {
+			recordEnd = len(data)
+		}
+		var address int = startAddress + pos
+		var checksum byte = byte(recordEnd-pos) + byte(address>>8) + byte(address)
+		fmt.Fprintf(hexOutput, ":%02X%04X00", recordEnd-pos, address)
+		for _, b := range data[pos:recordEnd] {
+			fmt.Fprintf(hexOutput, "%02X", b)
+			checksum = checksum + b
+		}
+		fmt.Fprintf(hexOutput, "%02X\r\n", byte(-int(checksum)))
+		pos = recordEnd
+	}
+}
+
+// writeSRecord writes an S-record of type recordType with the 16 bit address and the bytes of data
+// to srecOutput, with its checksum (the one's complement of the sum of the count, address and data
+// bytes).
+func writeSRecord(srecOutput *bytes.Buffer, recordType int, address int, data []byte) {
+	var checksum byte = byte(len(data)+3) + byte(address>>8) + byte(address)
+	fmt.Fprintf(srecOutput, "S%d%02X%04X", recordType, len(data)+3, address)
+	for _, b := range data {
+		fmt.Fprintf(srecOutput, "%02X", b)
+		checksum = checksum + b
+	}
+	fmt.Fprintf(srecOutput, "%02X\r\n", ^checksum)
+}
+
+// writeSRecordDataRecords writes the S1 records holding the bytes of data, to be loaded at address
+// startAddress, to srecOutput.
+func writeSRecordDataRecords(srecOutput *bytes.Buffer, startAddress int, data []byte) {
+	var pos int = 0
+	for pos < len(data) {
+		var recordEnd int = pos + HEX_RECORD_LENGTH
+		if recordEnd > len(data) {
+			recordEnd = len(data)
+		}
+		writeSRecord(srecOutput, 1, startAddress+pos, data[pos:recordEnd])
+		pos = recordEnd
+	}
+}
+
+// writeHexTrackToFile writes track trackNum of the diskImage (in DOS3.3 sector order) to the file
+// hexFilepath in hexFormat ("ihex" for Intel HEX, "srec" for Motorola S-records): the program from
+// generateTrackProgramAndData at clientAddress and the data it writes at bufferAddress. The Intel HEX
+// file ends with an end of file record, and the S-record file starts with an S0 header naming the
+// track and ends with an S9 record giving clientAddress as the start address. The monitor command
+// executing the program once the file is loaded is reported to stderr.
+func writeHexTrackToFile(hexFilepath string, hexFormat string, diskImage []byte, trackNum int, clientStrategy string, bootstrap bool) error {
+	var program, data []byte
+	var err error = generateTrackProgramAndData(&program, &data, diskImage, trackNum, clientStrategy, bootstrap)
+	if err != nil {
+		return err
+	}
+	var hexOutput bytes.Buffer
+	if hexFormat == "ihex" {
+		writeIntelHexRecords(&hexOutput, clientAddress, program)
+		writeIntelHexRecords(&hexOutput, bufferAddress, data)
+		fmt.Fprint(&hexOutput, ":00000001FF\r\n")
+	} else {
+		writeSRecord(&hexOutput, 0, 0x0000, []byte(fmt.Sprintf("TRACK %02d", trackNum)))
+		writeSRecordDataRecords(&hexOutput, clientAddress, program)
+		writeSRecordDataRecords(&hexOutput, bufferAddress, data)
+		writeSRecord(&hexOutput, 9, clientAddress, nil)
+	}
+	err = ioutil.WriteFile(hexFilepath, hexOutput.Bytes(), 0644)
+	if err != nil {
+		return err
+	}
+	var executeCommand string
+	generateExecuteCommand(&executeCommand, clientAddress)
+	fmt.Fprintf(os.Stderr, "wrote track %d to %s, load it and type %s\n", trackNum, hexFilepath, executeCommand)
+	return nil
+}
+
+// Hex record section end
+
+// writeCommandsToDumpDiskTrack outputs the commands to the apple ][ monitor which load a client
+// program that reads track trackNum from the floppy disk into the memory range 0x2000 through 0x2FFF
+// using the stock RWTS routine, execute it, and then display that memory range with the monitor.
+// No data is sent to the apple ][ other than the small client program itself.
+// clientStrategy is "track" or "descending", as for writeCommandsToLoadRWTSClientProgramToMemory.
+func writeCommandsToDumpDiskTrack(trackNum int, clientStrategy string, SEGMENT_SIZE int, LINE_START_PAD_LENGTH int) error {
+	var err error = writeCommandsToLoadRWTSClientProgramToMemory(trackNum, RWTS_COMMAND_READ, clientStrategy, SEGMENT_SIZE, LINE_START_PAD_LENGTH)
+	if err != nil {
+		return err
+	}
+	var dumpCommand string
+	generateMemoryDumpCommand(&dumpCommand, bufferAddress, 0x1000)
+	executeClient(trackNum, RWTS_COMMAND_READ, dumpCommand, LINE_START_PAD_LENGTH)
+	return nil
+}
+
+// READ_BACK_LINE_LENGTH is the count of characters in the line printed by the read back program: a
+// carriage return, T, the track, a colon, and 16 sector checksums each preceded by a space, and a
+// final carriage return.
+const READ_BACK_LINE_LENGTH = 1 + 1 + 2 + 1 + 16*5 + 1
+
+// writeCommandsToLoadReadBackProgramToMemory outputs the memory transfer commands which load a machine
+// language program (at 0x0D00) that reads back the track just written by the RWTS client at 0x0C00.
+// It changes the IOB to read the 16 sectors into the memory range 0x3000 through 0x3FFF and calls the
+// client, then sets the IOB back to write from 0x2000, so that the client can be used again. It then
+// prints T, the track number and a colon, followed by a checksum for each sector (the sum and the
+// exclusive or of its 256 bytes), to the current output. The client is also changed to return instead
+// of breaking into the monitor when the RWTS reports an error, so that the program runs after a failed
+// write as well: when the write or the read back failed, it prints a space, E and the RWTS error code,
+// and a space, V and the volume number the RWTS found, instead of the checksums. clientStrategy is
+// "track" or "descending", as for writeCommandsToLoadRWTSClientProgramToMemory, and gives the sector
+// and memory page to start from.
+func writeCommandsToLoadReadBackProgramToMemory(clientStrategy string, SEGMENT_SIZE int, LINE_START_PAD_LENGTH int) {
+	var readBackProgram []byte
+	generateReadBackProgram(&readBackProgram, clientStrategy)
+	var lineStartPad string
+	generateLineStartPad(&lineStartPad, LINE_START_PAD_LENGTH)
+	for sourceBytesStartPos := 0; sourceBytesStartPos < len(readBackProgram); sourceBytesStartPos = sourceBytesStartPos + SEGMENT_SIZE {
+		writeCommandsToFillAppleMemorySegment(readBackProgram, lineStartPad, clientAddress+0x0100+sourceBytesStartPos, sourceBytesStartPos, SEGMENT_SIZE)
+	}
+	// modify client : return on error (at '\x0C1A') rather than break
+	writeCommandsToFillAppleMemorySegment([]byte{READ_BACK_CLIENT_ERROR_BRANCH}, lineStartPad, clientAddress+0x08, 0, 1)
+}
+
+// READ_BACK_CLIENT_ERROR_BRANCH is the offset of the branch taken by the RWTS client on an error once
+// the read back program is loaded, which leads to its return rather than its break.
+const READ_BACK_CLIENT_ERROR_BRANCH = 0x11
+
+// generateReadBackProgram generates the machine language program loaded by
+// writeCommandsToLoadReadBackProgramToMemory for clientStrategy. The program is stored in the slice
+// pointed to by readBackProgram.
+func generateReadBackProgram(readBackProgram *[]byte, clientStrategy string) {
+	var clientPage byte = byte(clientAddress >> 8)
+	var programPage byte = clientPage + 1
+	var bufferPage byte = byte(bufferAddress >> 8)
+	var startSector byte = '\x00'
+	var readStartPage byte = bufferPage + '\x10'
+	var writeStartPage byte = bufferPage
+	if clientStrategy == "descending" {
+		startSector = '\x0F'
+		readStartPage = readStartPage + '\x0F'
+		writeStartPage = writeStartPage + '\x0F'
+	}
+	*readBackProgram = []byte{
+			'\xAD', '\x29', clientPage, // skip reading back when the write failed (RWTS error code is in '\x0C29')
+			'\xD0', '\x12',
+			'\xA9', startSector, // modify IOB : read from the first sector into the first page at 0x3000
+			'\x8D', '\x21', clientPage,
+			'\xA9', readStartPage,
+			'\x8D', '\x25', clientPage,
+			'\xA9', RWTS_COMMAND_READ,
+			'\x8D', '\x28', clientPage,
+			'\x20', '\x00', clientPage, // call client
+			'\xA9', startSector, // modify IOB : write from the first sector and page at 0x2000 again
+			'\x8D', '\x21', clientPage,
+			'\xA9', writeStartPage,
+			'\x8D', '\x25', clientPage,
+			'\xA9', RWTS_COMMAND_WRITE,
+			'\x8D', '\x28', clientPage,
+			'\xA9', '\x8D', // print carriage return, T, track number and colon
+			'\x20', '\xED', '\xFD',
+			'\xA9', '\xD4',
+			'\x20', '\xED', '\xFD',
+			'\xAD', '\x20', clientPage,
+			'\x20', '\xDA', '\xFD',
+			'\xA9', '\xBA',
+			'\x20', '\xED', '\xFD',
+			'\xAD', '\x29', clientPage, // print the RWTS error instead of the checksums when the write or the read failed
+			'\xD0', '\x44',
+			'\xA9', bufferPage + '\x10', // modify program : start summing at page 0x30 (page is in '\x0D50')
+			'\x8D', '\x50', programPage,
+			'\xA9', '\x00', // clear sum and exclusive or (in '\x0DAA' and '\x0DAB')
+			'\x8D', '\xAA', programPage,
+			'\x8D', '\xAB', programPage,
+			'\xA8',
+			'\xB9', '\x00', bufferPage + '\x10', // add and exclusive or each byte of the page
+			'\x48',
+			'\x18',
+			'\x6D', '\xAA', programPage,
+			'\x8D', '\xAA', programPage,
+			'\x68',
+			'\x4D', '\xAB', programPage,
+			'\x8D', '\xAB', programPage,
+			'\xC8',
+			'\xD0', '\xEB', //iterate over bytes
+			'\xA9', '\xA0', // print space, sum and exclusive or
+			'\x20', '\xED', '\xFD',
+			'\xAD', '\xAA', programPage,
+			'\x20', '\xDA', '\xFD',
+			'\xAD', '\xAB', programPage,
+			'\x20', '\xDA', '\xFD',
+			'\xEE', '\x50', programPage, // modify program : advance to next page
+			'\xAD', '\x50', programPage,
+			'\xC9', bufferPage + '\x20', // we are done after page 0x3F
+			'\xD0', '\xC7', //iterate over pages
+			'\xA9', '\x8D', // print carriage return
+			'\x20', '\xED', '\xFD',
+			'\x60', // return
+			'\xA9', '\xA0', // print space, E and the error code, space, V and the volume found (in '\x0C2A')
+			'\x20', '\xED', '\xFD',
+			'\xA9', '\xC5',
+			'\x20', '\xED', '\xFD',
+			'\xAD', '\x29', clientPage,
+			'\x20', '\xDA', '\xFD',
+			'\xA9', '\xA0',
+			'\x20', '\xED', '\xFD',
+			'\xA9', '\xD6',
+			'\x20', '\xED', '\xFD',
+			'\xAD', '\x2A', clientPage,
+			'\x20', '\xDA', '\xFD',
+			'\xA9', '\x8D',
+			'\x20', '\xED', '\xFD',
+			'\x60',
+			'\x00', '\x00' } // sum / exclusive or
+}
+
+// READ_BACK_LINE_PATTERN matches a line printed by the read back program, giving the track number and
+// either the sector checksums or the RWTS error code and the volume number found.
+var READ_BACK_LINE_PATTERN *regexp.Regexp = regexp.MustCompile(`T([0-9A-F]{2}):(?:((?: [0-9A-F]{4}){16})| E([0-9A-F]{2}) V([0-9A-F]{2}))`)
+
+// RWTS_ERROR_NAMES names the error codes the RWTS returns in the IOB.
+var RWTS_ERROR_NAMES map[string]string = map[string]string{
+	"08": "init error", "10": "write protected", "20": "volume mismatch", "40": "drive error", "80": "read error"}
+
+// checkReadBackCapture compares the sector checksums printed by the read back program, found in the
+// serial output captured while installing, against those of the tracks of diskImage (in DOS3.3 sector
+// order), reporting each differing sector to stderr. Tracks whose write or read back failed with an
+// RWTS error are reported with the error (and for a volume mismatch, the volume found), and tracks of
+// trackNums which have no line in the capture (because the line was garbled) are reported too. When a
+// track was read back more than once, the last line counts. It stores the count of differing, failed
+// and missing tracks into failedTrackCount, and returns an error when trackNums is nil and the capture
+// names no track.
+func checkReadBackCapture(failedTrackCount *int, diskImage []byte, trackNums []int, capture []byte) error {
+	var checksumsByTrack map[int]string = make(map[int]string)
+	var errorsByTrack map[int][]string = make(map[int][]string)
+	for _, match := range READ_BACK_LINE_PATTERN.FindAllStringSubmatch(string(filesystem.StripHighBits(capture)), -1) {
+		// the pattern matched two hexadecimal digits, which always parse
+		var trackNum int64
+		trackNum, _ = strconv.ParseInt(match[1], 16, 32)
+		if match[2] != "" {
+			checksumsByTrack[int(trackNum)] = match[2]
+			delete(errorsByTrack, int(trackNum))
+		} else {
+			errorsByTrack[int(trackNum)] = match[3:5]
+			delete(checksumsByTrack, int(trackNum))
+		}
+	}
+	if trackNums == nil {
+		for trackNum := 0; trackNum < 0x23; trackNum = trackNum + 1 {
+			if checksumsByTrack[trackNum] != "" || errorsByTrack[trackNum] != nil {
+				trackNums = append(trackNums, trackNum)
+			}
+		}
+		if len(trackNums) == 0 {
+			return failure.CodedErrorf(failure.KIND_NO_READ_BACK, "capture holds no read back checksums")
+		}
+	}
+	*failedTrackCount = 0
+	for _, trackNum := range trackNums {
+		if errorsByTrack[trackNum] != nil {
+			var errorCode string = errorsByTrack[trackNum][0]
+			var errorName string = RWTS_ERROR_NAMES[errorCode]
+			if errorName == "" {
+				errorName = "unknown error"
+			}
+			if errorCode == "20" {
+				var foundVolume int64
+				// the pattern matched two hexadecimal digits, which always parse
+				foundVolume, _ = strconv.ParseInt(errorsByTrack[trackNum][1], 16, 32)
+				fmt.Fprintf(os.Stderr, "track %d failed with RWTS error 0x%s (%s): the disk has volume %d\n", trackNum, errorCode, errorName, foundVolume)
+			} else {
+				fmt.Fprintf(os.Stderr, "track %d failed with RWTS error 0x%s (%s)\n", trackNum, errorCode, errorName)
+			}
+			*failedTrackCount = *failedTrackCount + 1
+			continue
+		}
+		if checksumsByTrack[trackNum] == "" {
+			fmt.Fprintf(os.Stderr, "track %d was not read back\n", trackNum)
+			*failedTrackCount = *failedTrackCount + 1
+			continue
+		}
+		var readChecksums []string = strings.Fields(checksumsByTrack[trackNum])
+		var differingSectorCount int = 0
+		for sectorNum := 0; sectorNum < 0x10; sectorNum = sectorNum + 1 {
+			var sum byte = 0
+			var xor byte = 0
+			for _, b := range diskimage.Dos33SectorOfImage(diskImage, trackNum, sectorNum) {
+				sum = sum + b
+				xor = xor ^ b
+			}
+			var expectedChecksum string = fmt.Sprintf("%02X%02X", sum, xor)
+			if readChecksums[sectorNum] != expectedChecksum {
+				fmt.Fprintf(os.Stderr, "track %d sector %d differs: read back %s, expected %s\n", trackNum, sectorNum, readChecksums[sectorNum], expectedChecksum)
+				differingSectorCount = differingSectorCount + 1
+			}
+		}
+		if differingSectorCount > 0 {
+			*failedTrackCount = *failedTrackCount + 1
+		} else {
+			fmt.Fprintf(os.Stderr, "track %d read back correctly\n", trackNum)
+		}
+	}
+	return nil
+}
+
+// readBackVerifier checks the line printed by the read back program for each track as it arrives on
+// the serial line, read from input, so that a track which was not written correctly is sent again right
+// away, up to maxRetries times. It waits for the line until timeout after the serial line has had time
+// to send the commands so far. retryCount tracks have been sent again, and failedTrackNums failed
+// every try.
+type readBackVerifier struct {
+	input           chan byte
+	maxRetries      int
+	timeout         time.Duration
+	baud            int
+	bitsPerChar     int
+	retryCount      int
+	failedTrackNums []int
+}
+
+// readBackVerification is set when the read back lines are checked as they arrive, and is nil
+// otherwise.
+var readBackVerification *readBackVerifier
+
+// awaitReadBackLine waits for the line printed by the read back program for track trackNum, skipping
+// the echo of the commands and the lines of other tracks, and compares its checksums against those of
+// the track of diskImage (in DOS3.3 sector order) as checkReadBackCapture does. It returns false when
+// the track differs, failed, or was not read back in time, and an error when the serial port closes.
+func (v *readBackVerifier) awaitReadBackLine(diskImage []byte, trackNum int) (bool, error) {
+	emitProgressEvent("track_verifying", commandOutput.lineCount, commandOutput.charCount)
+	var deadline time.Time = commandOutput.startTime.Add(time.Duration(transmissionSeconds(commandOutput.charCount, v.baud, v.bitsPerChar)*float64(time.Second)) + v.timeout)
+	var line []byte
+	for {
+		select {
+		case b, ok := <-v.input:
+			if !ok {
+				return false, protocol.ClosedInputError(failure.KIND_SERIAL_PORT_CLOSED, fmt.Sprintf("serial port closed while waiting for the read back line of track %d", trackNum))
+			}
+			if b&0x7F != '\r' {
+				line = append(line, b&0x7F)
+				continue
+			}
+			var match []string = READ_BACK_LINE_PATTERN.FindStringSubmatch(string(line))
+			line = nil
+			if match == nil || match[1] != fmt.Sprintf("%02X", trackNum) {
+				continue
+			}
+			endProgressLine()
+			var failedTrackCount int
+			// the track is given, so the capture is not searched for the tracks it names
+			checkReadBackCapture(&failedTrackCount, diskImage, []int{trackNum}, []byte(match[0]))
+			return failedTrackCount == 0, nil
+		case <-time.After(time.Until(deadline)):
+			endProgressLine()
+			fmt.Fprintf(os.Stderr, "track %d was not read back in time\n", trackNum)
+			return false, nil
+		}
+	}
+}
+
+// retryTrackUntilVerified waits, when the read back lines are checked as they arrive, for the read back
+// line of track trackNum, just installed by the RWTS client with the read back program as its
+// trailingCommands, and sends the track data again and executes the client again until the track reads
+// back correctly, up to the maxRetries of readBackVerification. A track which never reads back
+// correctly is added to its failedTrackNums.
+func retryTrackUntilVerified(diskImage []byte, trackNum int, clientStrategy string, trailingCommands string, SEGMENT_SIZE int, LINE_START_PAD_LENGTH int) error {
+	if readBackVerification == nil {
+		return nil
+	}
+	var lineStartPad string
+	generateLineStartPad(&lineStartPad, LINE_START_PAD_LENGTH)
+	for retryCount := 0; ; retryCount = retryCount + 1 {
+		verified, err := readBackVerification.awaitReadBackLine(diskImage, trackNum)
+		if err != nil {
+			return err
+		}
+		if verified {
+			return nil
+		}
+		if retryCount == readBackVerification.maxRetries {
+			readBackVerification.failedTrackNums = append(readBackVerification.failedTrackNums, trackNum)
+			emitProgressEvent("track_failed", commandOutput.lineCount, commandOutput.charCount)
+			return nil
+		}
+		fmt.Fprintf(os.Stderr, "sending track %d again (retry %d of %d)\n", trackNum, retryCount+1, readBackVerification.maxRetries)
+		readBackVerification.retryCount = readBackVerification.retryCount + 1
+		err = writeCommandsToLoadDiskTrackToMemory(diskImage, trackNum, SEGMENT_SIZE, LINE_START_PAD_LENGTH)
+		if err != nil {
+			return err
+		}
+		err = writeCommandsToResetClientIob(trackNum, clientStrategy, lineStartPad)
+		if err != nil {
+			return err
+		}
+		executeClient(trackNum, RWTS_COMMAND_WRITE, trailingCommands, LINE_START_PAD_LENGTH)
+		if commandOutput.err != nil {
+			return commandOutput.err
+		}
+	}
+}
+
+// hasTrackFailedVerification tells whether track trackNum never read back correctly.
+func hasTrackFailedVerification(trackNum int) bool {
+	if readBackVerification == nil {
+		return false
+	}
+	for _, failedTrackNum := range readBackVerification.failedTrackNums {
+		if failedTrackNum == trackNum {
+			return true
+		}
+	}
+	return false
+}
+
+// reportReadBackVerification reports the tracks sent again to stderr, and returns an error when any
+// track never read back correctly, naming them, so that they can be sent again with -tracks.
+func reportReadBackVerification(trackCount int) error {
+	if readBackVerification == nil {
+		return nil
+	}
+	endProgressLine()
+	fmt.Fprintf(os.Stderr, "%d tracks verified by reading back, %d sent again\n", trackCount-len(readBackVerification.failedTrackNums), readBackVerification.retryCount)
+	if len(readBackVerification.failedTrackNums) > 0 {
+		var failedTracks []string
+		for _, trackNum := range readBackVerification.failedTrackNums {
+			failedTracks = append(failedTracks, strconv.Itoa(trackNum))
+		}
+		return failure.CodedErrorf(failure.KIND_READ_BACK_FAILED, "tracks %s failed read back verification after %d retries each", strings.Join(failedTracks, ","), readBackVerification.maxRetries)
+	}
+	return nil
+}
+
+// Memory verification section begin
+
+// MEMORY_VERIFY_MAX_RESENDS is the count of times the differing rows of the track buffer are sent again
+// before the install stops, without writing the track.
+const MEMORY_VERIFY_MAX_RESENDS = 3
+
+// MEMORY_VERIFY_ROW_SIZE is the count of bytes in each row of the track buffer compared and sent again,
+// those of a line of a monitor memory dump.
+const MEMORY_VERIFY_ROW_SIZE = 8
+
+// memoryVerifier checks the track buffer after each track is loaded, before the client writes it, by
+// displaying it with the monitor and comparing the dump lines as they arrive on the serial line, read
+// from input, against the track. resendCount rows have been sent again.
+type memoryVerifier struct {
+	input       chan byte
+	baud        int
+	bitsPerChar int
+	resendCount int
+}
+
+// memoryVerification is set when the track buffer is checked after each track is loaded, and is nil
+// otherwise.
+var memoryVerification *memoryVerifier
+
+// awaitMemoryDump waits for the lines of the monitor memory dump of the len(dump) bytes starting at
+// address startAddress, skipping the echo of the commands and any other lines, and stores the bytes
+// into dump, setting the same positions of seen. It returns once every byte has been seen, or when
+// the dump has not arrived in time after the serial line has had time to send the commands so far. It
+// returns an error when the serial port closes.
+func (v *memoryVerifier) awaitMemoryDump(dump []byte, seen []bool, startAddress int) error {
+	var dumpSeconds float64 = transmissionSeconds(len(dump)/MEMORY_VERIFY_ROW_SIZE*MONITOR_DUMP_LINE_LENGTH, v.baud, v.bitsPerChar)
+	var deadline time.Time = commandOutput.startTime.Add(time.Duration((transmissionSeconds(commandOutput.charCount, v.baud, v.bitsPerChar) + dumpSeconds + 1) * float64(time.Second)))
+	var unseenCount int = len(dump)
+	var line []byte
+	for unseenCount > 0 {
+		select {
+		case b, ok := <-v.input:
+			if !ok {
+				return protocol.ClosedInputError(failure.KIND_SERIAL_PORT_CLOSED, "serial port closed while waiting for the memory dump")
+			}
+			if b&0x7F != '\r' {
+				line = append(line, b&0x7F)
+				continue
+			}
+			var match []string = MONITOR_DUMP_LINE_PATTERN.FindStringSubmatch(strings.ToUpper(string(line)))
+			line = nil
+			if match == nil {
+				continue
+			}
+			// the pattern matched hexadecimal digits, which always parse
+			var address int64
+			address, _ = strconv.ParseInt(match[1], 16, 32)
+			for i, byteText := range strings.Fields(match[2]) {
+				var pos int = int(address) - startAddress + i
+				if pos < 0 || pos >= len(dump) {
+					continue
+				}
+				var value uint64
+				value, _ = strconv.ParseUint(byteText, 16, 8)
+				dump[pos] = byte(value)
+				if !seen[pos] {
+					seen[pos] = true
+					unseenCount = unseenCount - 1
+				}
+			}
+		case <-time.After(time.Until(deadline)):
+			return nil
+		}
+	}
+	return nil
+}
+
+// verifyTrackInMemory displays, when the track buffer is checked after each track is loaded, the track
+// buffer just loaded with track trackNum of the diskImage (in DOS3.3 sector order) with the monitor,
+// and compares the dump against the track. The rows which differ, or whose dump lines were lost, are
+// sent again and the track buffer displayed again, up to MEMORY_VERIFY_MAX_RESENDS times, after which
+// an error is returned, so that the install stops before the client writes the track.
+func verifyTrackInMemory(diskImage []byte, trackNum int, SEGMENT_SIZE int, LINE_START_PAD_LENGTH int) error {
+	if memoryVerification == nil {
+		return nil
+	}
+	var lineStartPad string
+	generateLineStartPad(&lineStartPad, LINE_START_PAD_LENGTH)
+	var trackStartPos int = diskimage.DiskImageStartPosOfTrackSector(trackNum, 0)
+	var track []byte = diskImage[trackStartPos : trackStartPos+0x1000]
+	var dumpCommand string
+	generateMemoryDumpCommand(&dumpCommand, bufferAddress, 0x1000)
+	for resendRound := 0; ; resendRound = resendRound + 1 {
+		fmt.Fprintf(&commandOutput, "%s%s\r", lineStartPad, dumpCommand)
+		var dump []byte = make([]byte, 0x1000)
+		var seen []bool = make([]bool, 0x1000)
+		emitProgressEvent("track_verifying", commandOutput.lineCount, commandOutput.charCount)
+		var err error = memoryVerification.awaitMemoryDump(dump, seen, bufferAddress)
+		endProgressLine()
+		if err != nil {
+			return err
+		}
+		var differingRowPositions []int
+		var seenCount int = 0
+		for rowPos := 0; rowPos < 0x1000; rowPos = rowPos + MEMORY_VERIFY_ROW_SIZE {
+			var rowDiffers bool = false
+			for pos := rowPos; pos < rowPos+MEMORY_VERIFY_ROW_SIZE; pos = pos + 1 {
+				if seen[pos] {
+					seenCount = seenCount + 1
+				}
+				if !seen[pos] || dump[pos] != track[pos] {
+					rowDiffers = true
+				}
+			}
+			if rowDiffers {
+				differingRowPositions = append(differingRowPositions, rowPos)
+			}
+		}
+		if seenCount == 0 {
+			emitProgressEvent("track_failed", commandOutput.lineCount, commandOutput.charCount)
+			return failure.TrackErrorf(failure.KIND_NO_MEMORY_DUMP, trackNum, "no memory dump of track %d arrived in time, stopping before writing it", trackNum)
+		}
+		if len(differingRowPositions) == 0 {
+			fmt.Fprintf(os.Stderr, "track %d verified in memory\n", trackNum)
+			return nil
+		}
+		if resendRound == MEMORY_VERIFY_MAX_RESENDS {
+			emitProgressEvent("track_failed", commandOutput.lineCount, commandOutput.charCount)
+			return failure.TrackErrorf(failure.KIND_MEMORY_MISMATCH, trackNum, "track %d still differs in memory at %d rows after sending them again %d times, stopping before writing it", trackNum, len(differingRowPositions), MEMORY_VERIFY_MAX_RESENDS)
+		}
+		fmt.Fprintf(os.Stderr, "track %d differs in memory at %d rows from %04X, sending them again\n", trackNum, len(differingRowPositions), bufferAddress+differingRowPositions[0])
+		memoryVerification.resendCount = memoryVerification.resendCount + len(differingRowPositions)
+		for _, rowPos := range differingRowPositions {
+			for pos := rowPos; pos < rowPos+MEMORY_VERIFY_ROW_SIZE; pos = pos + SEGMENT_SIZE {
+				var writeByteCount int = SEGMENT_SIZE
+				if pos+writeByteCount > rowPos+MEMORY_VERIFY_ROW_SIZE {
+					writeByteCount = rowPos + MEMORY_VERIFY_ROW_SIZE - pos
+				}
+				writeCommandsToFillAppleMemorySegment(track, lineStartPad, bufferAddress+pos, pos, writeByteCount)
+			}
+		}
+	}
+}
+
+// Memory verification section end
+
+// MONITOR_DUMP_LINE_LENGTH is the count of characters in each line of a monitor memory dump: a
+// carriage return, the address, a dash, and 8 bytes in hexadecimal each preceded by a space.
+const MONITOR_DUMP_LINE_LENGTH = 1 + 4 + 1 + 8*3
+
+// writeCommandsToDumpDiskTracks outputs the commands which read and display each of the tracks
+// trackNums of the floppy disk, as writeCommandsToDumpDiskTrack does for one track. The client is
+// loaded once with the first track; for each further track only the IOB is reset before executing it
+// again. Between tracks, settleCharCount spaces plus the length of the memory dump keep the serial
+// line busy while the track is read and displayed, since characters received meanwhile are lost.
+// Dumping stops at the end of the track in which writing the commands failed.
+func writeCommandsToDumpDiskTracks(trackNums []int, clientStrategy string, settleCharCount int, SEGMENT_SIZE int, LINE_START_PAD_LENGTH int) error {
+	var lineStartPad string
+	generateLineStartPad(&lineStartPad, LINE_START_PAD_LENGTH)
+	var dumpCommand string
+	generateMemoryDumpCommand(&dumpCommand, bufferAddress, 0x1000)
+	for i, trackNum := range trackNums {
+		progressEventTrack = trackNum
+		emitProgressEvent("track_started", commandOutput.lineCount, commandOutput.charCount)
+		var err error
+		if i == 0 {
+			err = writeCommandsToLoadRWTSClientProgramToMemory(trackNum, RWTS_COMMAND_READ, clientStrategy, SEGMENT_SIZE, LINE_START_PAD_LENGTH)
+			if err != nil {
+				return err
+			}
+		} else {
+			err = writeCommandsToResetClientIob(trackNum, clientStrategy, lineStartPad)
+			if err != nil {
+				return err
+			}
+		}
+		executeClient(trackNum, RWTS_COMMAND_READ, dumpCommand, LINE_START_PAD_LENGTH)
+		if i < len(trackNums)-1 {
+			writeCommandsToSettle(settleCharCount + 0x1000/8*MONITOR_DUMP_LINE_LENGTH)
+		}
+		emitProgressEvent("track_finished", commandOutput.lineCount, commandOutput.charCount)
+		if commandOutput.err != nil {
+			break
+		}
+	}
+	return commandOutput.err
+}
+
+// MONITOR_DUMP_LINE_PATTERN matches a line of a monitor memory dump, giving its address and bytes.
+var MONITOR_DUMP_LINE_PATTERN *regexp.Regexp = regexp.MustCompile(`^\s*([0-9A-F]{4})-((?:\s+[0-9A-F]{2})+)\s*$`)
+
+// readTracksFromMonitorDump copies the tracks trackNums, dumped in that order by the commands of
+// writeCommandsToDumpDiskTracks, from the captured serial output capture into diskImage (in DOS3.3
+// sector order). A dump starts at each line for address bufferAddress; anything which is not a dump line
+// (such as echoed commands) is skipped. A dump which does not cover the whole track, or a count of
+// dumps which differs from the count of tracks, is returned as an error before any track is copied.
+func readTracksFromMonitorDump(diskImage []byte, trackNums []int, capture []byte) error {
+	var lines []string = strings.Split(strings.Replace(string(filesystem.StripHighBits(capture)), "\r", "\n", -1), "\n")
+	var dumps [][]byte
+	var dumpSeen [][]bool
+	for _, line := range lines {
+		var match []string = MONITOR_DUMP_LINE_PATTERN.FindStringSubmatch(strings.ToUpper(line))
+		if match == nil {
+			continue
+		}
+		// the pattern matched hexadecimal digits, which always parse
+		var address int64
+		address, _ = strconv.ParseInt(match[1], 16, 32)
+		if int(address) == bufferAddress {
+			dumps = append(dumps, make([]byte, 0x1000))
+			dumpSeen = append(dumpSeen, make([]bool, 0x1000))
+		}
+		if len(dumps) == 0 || int(address) < bufferAddress {
+			continue
+		}
+		for i, byteText := range strings.Fields(match[2]) {
+			var pos int = int(address) - bufferAddress + i
+			if pos >= 0x1000 {
+				break
+			}
+			var value uint64
+			value, _ = strconv.ParseUint(byteText, 16, 8)
+			dumps[len(dumps)-1][pos] = byte(value)
+			dumpSeen[len(dumps)-1][pos] = true
+		}
+	}
+	if len(dumps) != len(trackNums) {
+		return failure.CodedErrorf(failure.KIND_DUMP_COUNT, "capture holds %d track dumps, expected %d", len(dumps), len(trackNums))
+	}
+	for i, trackNum := range trackNums {
+		for pos := 0; pos < 0x1000; pos = pos + 1 {
+			if !dumpSeen[i][pos] {
+				return failure.TrackErrorf(failure.KIND_DUMP_INCOMPLETE, trackNum, "dump of track %d is missing address %04X, dump the track again", trackNum, bufferAddress+pos)
+			}
+		}
+	}
+	for i, trackNum := range trackNums {
+		copy(diskImage[diskimage.DiskImageStartPosOfTrackSector(trackNum, 0):], dumps[i])
+		fmt.Fprintf(os.Stderr, "read track %d from the capture\n", trackNum)
+	}
+	return nil
+}
+
+// Subcommand section begin
+
+// subcommand is a mode of the program, named by the first command line argument. Its run function
+// parses the arguments after the name with a flag set of its own, which holds only the flags the mode
+// takes, and carries out the mode.
+type subcommand struct {
+	name    string
+	summary string
+	run     func(args []string) error
+}
+
+// SUBCOMMANDS lists the subcommands of the program, in the order -help lists them. install is also
+// carried out when the first argument names no subcommand, as installing is what the program does by
+// default.
+var SUBCOMMANDS []subcommand = []subcommand{
+	{"install", "write tracks (or block groups) of a disk image to a disk through the apple ][ monitor", runInstall},
+	{"daemon", "serve a persistent queue of install transfers, run one at a time and controlled through an HTTP API", runDaemon},
+	{"duplicate", "install the same disk image through several serial ports at once, one apple ][ on each", runDuplicate},
+	{"session", "carry out the steps of a session manifest, such as installing several disks and verifying them, with resume", runSession},
+	{"dump", "read tracks from a floppy disk with the stock RWTS routine and display them with the monitor", runDump},
+	{"undump", "write the tracks displayed by dump, as captured from the serial line, into a disk image", runUndump},
+	{"check", "compare the sector checksums printed by install -read-back, as captured, against a disk image", runCheckReadBack},
+	{"convert", "write a 140K disk image to a new image file in another sector order or format", runConvert},
+	{"encrypt", "encrypt an image file with the key of a key file, for keeping it where others may read it", runEncrypt},
+	{"decrypt", "write the content of an encrypted image file to a file of its own", runDecrypt},
+	{"split", "split a large ProDOS block image into 140K floppy image chunks with a manifest", runSplit},
+	{"join", "reassemble the floppy image chunks listed in a manifest into a large image", runJoin},
+	{"dos-master", "copy the DOS image on tracks 0-2 of a DOS 3.3 disk image onto a DOS 3.3 data disk image", runDosMaster},
+	{"bootify", "copy the boot blocks, PRODOS and BASIC.SYSTEM of a ProDOS disk image onto a data disk image", runBootify},
+	{"add", "write a host file into a DOS 3.3 or ProDOS disk image", runAdd},
+	{"extract", "write a file held in a disk image, or all of its files, to the host", runExtract},
+	{"cmp", "compare a file held in a disk image against a host file", runCompare},
+	{"ymodem", "send host files, or files held in disk images, to a YMODEM receiver", runYmodem},
+	{"zmodem", "send host files, or files held in disk images, to a ZMODEM receiver", runZmodem},
+	{"xmodem", "send a host file, a file held in a disk image, or tracks of a disk image, to an XMODEM receiver", runXmodem},
+	{"adtpro", "act as the host of the ADTPro client, sending the disk images of a directory to it and receiving its disks", runAdtpro},
+	{"apple3-client", "make the boot disk of the Apple /// client from a bootable Apple /// disk image", runApple3Client},
+	{"apple3", "write a disk image to the built-in drive of an Apple /// running the client, over the serial line", runApple3},
+	{"catalog", "list the files of a DOS 3.3 or ProDOS disk image", runCatalog},
+	{"fsck", "check the allocation of the blocks or sectors of a ProDOS or DOS 3.3 disk image", runFsck},
+	{"diff", "compare two floppy disk images sector by sector", runDiff},
+	{"hexdump", "print one sector of a floppy disk image in hex and ASCII", runHexdump},
+	{"poke", "store bytes into one sector of a floppy disk image", runPoke},
+	{"browse", "step through the sectors of a floppy disk image, showing the file owning each", runBrowse},
+	{"hgr", "list the hi-res pictures held in a disk image, or render one of them to a PNG file", runHgr},
+	{"label", "write a printable PDF sleeve insert and disk label listing the files of a disk image", runLabel},
+	{"preview", "boot a temporary copy of a disk image in a locally installed emulator", runPreview},
+	{"hash", "print the MD5, SHA-1 and CRC32 of disk images and of each of their tracks", runHash},
+	{"verify", "check the SHA-1 of a disk image against a list of known-good image hashes", runVerify},
+	{"undo", "roll back the last journaled operations which wrote a disk image file", runUndo},
+	{"calibrate", "measure the fastest segment size and pad length the monitor loads intact", runCalibrate},
+	{"explain-pacing", "show how the segment size and pad length are derived from the serial line", runExplainPacing},
+	{"check-client", "run the client programs on a 6502 emulator with a mock RWTS, checking what they do", runCheckClient}}
+
+// printUsage writes the subcommands of the program to stderr.
+func printUsage() {
+	var programName string = filepath.Base(os.Args[0])
+	fmt.Fprintf(os.Stderr, "usage: %s [subcommand] [flags] arguments\n\nsubcommands:\n", programName)
+	for _, command := range SUBCOMMANDS {
+		fmt.Fprintf(os.Stderr, "  %-15s %s\n", command.name, command.summary)
+	}
+	fmt.Fprintf(os.Stderr, "\nWithout a subcommand, the arguments are those of install. %s subcommand -help, or %s help\nsubcommand, lists the flags of a subcommand.\n", programName, programName)
+}
+
+// checkArgumentCount returns a KIND_ARGUMENT_COUNT error when fewer than minCount or more than maxCount
+// arguments follow the flags parsed by the flag set of a subcommand.
+func checkArgumentCount(flags *flag.FlagSet, minCount int, maxCount int) error {
+	if flags.NArg() < minCount {
+		return failure.CodedErrorf(failure.KIND_ARGUMENT_COUNT, "too few arguments for %s: %d given, %d needed", flags.Name(), flags.NArg(), minCount)
+	}
+	if flags.NArg() > maxCount {
+		return failure.CodedErrorf(failure.KIND_ARGUMENT_COUNT, "too many arguments for %s: %s", flags.Name(), strings.Join(flags.Args()[maxCount:], " "))
+	}
+	return nil
+}
+
+// newSubcommandFlagSet returns the flag set of the subcommand name, whose usage message gives the
+// arguments following the flags. It holds the flags every subcommand takes, -errors-json and -debug.
+func newSubcommandFlagSet(name string, arguments string) *flag.FlagSet {
+	var flags *flag.FlagSet = flag.NewFlagSet(name, flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: %s %s [flags] %s\n\nflags:\n", filepath.Base(os.Args[0]), name, arguments)
+		flags.PrintDefaults()
+	}
+	flags.BoolVar(&reportFailuresAsJson, "errors-json", false, "additionally report a failure on stderr as a line of JSON with a code, message, track, sector and suggestion")
+	flags.BoolVar(&debugFailures, "debug", false, "let a failure stop the program with a Go panic and stack trace, instead of an error message")
+	return flags
+}
+
+// addImageFlags adds to flags those of the subcommands reading disk images, which set how the image
+// files are fetched and in which sector order they are taken.
+func addImageFlags(flags *flag.FlagSet) {
+	flags.IntVar(&diskImageDownloadMaxBytes, "max-download-bytes", diskImageDownloadMaxBytes, "largest disk image accepted from a URL, or from decompressing a .gz image")
+	flags.StringVar(&diskImageChecksum, "sha256", "", "SHA-256 (in hexadecimal) the disk image file must have, checked before any decompression")
+	flags.BoolVar(&diskImageIsDos33Order, "dos-order", false, "140K disk image files given are in DOS 3.3 sector order, whatever their content or name suggests")
+	flags.StringVar((*string)(&diskImageInterleave), "interleave", "", "140K disk image files given are in this sector order: prodos, dos, pascal, cpm or physical, whatever their content or name suggests")
+	flags.BoolVar(&skipBadSectors, "skip-bad-sectors", false, "read nibble and WOZ images with unrecoverable sectors, leaving those sectors filled with zeros")
+	flags.StringVar(&diskImageKeyFilepath, "key-file", "", "decrypt the encrypted disk image files given, and encrypt those written over, with the key in this file (made by encrypt -new-key)")
+}
+
+// addPartitionFlag adds -partition to flags, for the subcommands which may operate on one volume of a
+// multi-volume image, returning the partition number given, or 0 for the whole image.
+func addPartitionFlag(flags *flag.FlagSet) *int {
+	return flags.Int("partition", 0, "operate on this ProDOS partition (counting from 1) of a CFFA style multi-volume image")
+}
+
+// readDiskImagePartition reads the disk image file diskImageFilepath into diskImage as
+// readDiskImageFromFile does, and reduces it to its ProDOS partition partitionNum unless that is 0.
+func readDiskImagePartition(diskImage *[]byte, diskImageFilepath string, partitionNum int) error {
+	var err error = readDiskImageFromFile(diskImage, diskImageFilepath)
+	if err != nil {
+		return err
+	}
+	if partitionNum > 0 {
+		return filesystem.SelectPartitionOfDiskImage(diskImage, partitionNum)
+	}
+	return nil
+}
+
+// splitImageFileArgument splits an argument of the form diskImageFilepath:fileName into the path of
+// the disk image file and the name of the file held in it, which is empty when the argument holds no
+// colon.
+func splitImageFileArgument(diskImageFilepath *string, fileName *string, argument string) {
+	var separatorPos int = strings.LastIndex(argument, ":")
+	*diskImageFilepath = argument
+	*fileName = ""
+	if separatorPos >= 0 {
+		*diskImageFilepath = argument[:separatorPos]
+		*fileName = argument[separatorPos+1:]
+	}
+}
+
+// serialLineFlags holds the flags describing the serial line to the apple ][: its speed and framing,
+// and the serial port or TCP bridge the commands are sent to instead of stdout.
+type serialLineFlags struct {
+	baud         *int
+	framing      *string
+	portFilepath *string
+	tcpAddress   *string
+	telnet       *bool
+	flowControl  *string
+}
+
+// addLineFormatFlags adds -baud and -framing to flags, storing them into line.
+func addLineFormatFlags(flags *flag.FlagSet, line *serialLineFlags) {
+	line.baud = flags.Int("baud", 2400, "serial line speed in bits per second")
+	line.framing = flags.String("framing", "7N2", "serial line data bits, parity and stop bits")
+}
+
+// addSerialLineFlags adds to flags those setting up the serial line and the connection to it.
+func addSerialLineFlags(flags *flag.FlagSet) *serialLineFlags {
+	var line *serialLineFlags = &serialLineFlags{}
+	addLineFormatFlags(flags, line)
+	line.portFilepath = flags.String("port", "", "send directly to this serial device (such as /dev/ttyUSB0), set up for -baud and -framing, instead of stdout")
+	line.tcpAddress = flags.String("tcp", "", "send over a TCP connection to this host:port of a bridge to the serial line (such as a WiFi modem, ser2net or the virtual serial port of an emulator), paced for -baud and -framing, instead of stdout")
+	line.telnet = flags.Bool("telnet", false, "with -tcp, speak the telnet protocol to the bridge, as the telnet ports of ser2net and some WiFi modems need")
+	line.flowControl = flags.String("flow-control", "none", "with -port, the flow control of the serial line: none, rtscts or xonxoff (with -tcp, as set up on the bridge), which leave out the line start padding and ramp-up")
+	return line
+}
+
+// isConnected tells whether line sends to a serial port or a TCP bridge rather than to stdout.
+func (line *serialLineFlags) isConnected() bool {
+	return *line.portFilepath != "" || *line.tcpAddress != ""
+}
+
+// openSerialLine checks the flags of line, and opens the serial port or the TCP bridge it gives into
+// port, leaving port nil when there is none. It stores the count of bits sent for each character
+// into bitsPerChar.
+func openSerialLine(port *io.ReadWriteCloser, bitsPerChar *int, line *serialLineFlags) error {
+	var err error = parseLineFormat(bitsPerChar, *line.baud, *line.framing)
+	if err != nil {
+		return err
+	}
+	if *line.flowControl != "none" && *line.flowControl != "rtscts" && *line.flowControl != "xonxoff" {
+		return failure.CodedErrorf(failure.KIND_UNKNOWN_VALUE, "unknown flow control: %s", *line.flowControl)
+	}
+	if *line.portFilepath != "" && *line.tcpAddress != "" {
+		return failure.CodedErrorf(failure.KIND_OPTION_CONFLICT, "-tcp sends over a connection, and cannot be used with -port")
+	}
+	if *line.telnet && *line.tcpAddress == "" {
+		return failure.CodedErrorf(failure.KIND_OPTION_CONFLICT, "-telnet needs -tcp, to connect to the bridge")
+	}
+	if *line.portFilepath != "" {
+		var serialPort *os.File
+		err = openSerialPort(&serialPort, *line.portFilepath, *line.baud, *line.framing, *line.flowControl)
+		if err != nil {
+			return err
+		}
+		*port = serialPort
+	} else if *line.tcpAddress != "" {
+		var bridge *tcpPort
+		err = openTcpPort(&bridge, *line.tcpAddress, *line.telnet, *line.baud, *line.framing, *line.flowControl)
+		if err != nil {
+			return err
+		}
+		*port = bridge
+	}
+	return nil
+}
+
+// addMonitorLineTimeFlag adds -monitor-line-time to flags.
+func addMonitorLineTimeFlag(flags *flag.FlagSet) *time.Duration {
+	return flags.Duration("monitor-line-time", MONITOR_LINE_PROCESSING_TIME, "assumed time the monitor spends processing each command line, for deriving -segment-size and -pad-length")
+}
+
+// addHighBitFlag adds -high-bit to flags.
+func addHighBitFlag(flags *flag.FlagSet) *bool {
+	return flags.Bool("high-bit", false, "send characters with the high bit set, as the apple ][ keyboard produces them (needs 8 data bits)")
+}
+
+// commandStreamFlags holds the flags of install and dump, which both write a stream of monitor commands
+// loading and executing a client: where the stream goes, how it is paced, and the disk, memory and
+// tracks the client works with.
+type commandStreamFlags struct {
+	line               *serialLineFlags
+	lineProcessingTime *time.Duration
+	highBit            *bool
+	lineEnding         *string
+	outputFilepath     *string
+	chunkBytes         *int
+	chunkPrefix        *string
+	eventsFilepath     *string
+	eventsFd           *int
+	controlFd          *int
+	dryRun             *bool
+	quiet              *bool
+	timingReport       *bool
+	segmentSize        *int
+	padLength          *int
+	rampUpLines        *int
+	tuningFilepath     *string
+	checkedLines       *bool
+	compact            *bool
+	profile            *string
+	slot               *int
+	drive              *int
+	targetFormat       *string
+	targetVolume       *int
+	clientAddress      *int
+	bufferAddress      *int
+	clientStrategy     *string
+	allTracks          *bool
+	trackList          *string
+	trackWriteTime     *time.Duration
+	keepalive          *time.Duration
+	terminal           *bool
+}
+
+// useDiskImageVolume makes the RWTS client, and the bootstrap writer, expect the volume number given by
+// the header of the 2MG image last read, unless -target-volume gives one. The ProDOS formatter always
+// gives volume 254, so the volume is only taken with -target-format dos33, or with -profile bootstrap
+// which formats the tracks it writes.
+func (stream *commandStreamFlags) useDiskImageVolume() {
+	if diskImageVolume < 0 || *stream.targetVolume >= 0 {
+		return
+	}
+	if diskImageVolume > 0xFE {
+		fmt.Fprintf(os.Stderr, "2MG volume %d is not a DOS 3.3 volume number, and is ignored\n", diskImageVolume)
+		return
+	}
+	if *stream.targetFormat != "dos33" && *stream.profile != "bootstrap" {
+		if diskImageVolume != 0 && diskImageVolume != 0xFE {
+			fmt.Fprintf(os.Stderr, "2MG volume %d is ignored, as the ProDOS formatter gives volume 254; give -target-format dos33 to expect it\n", diskImageVolume)
+		}
+		return
+	}
+	targetDiskVolume = byte(diskImageVolume)
+	fmt.Fprintf(os.Stderr, "expecting volume %d of the 2MG header on the destination disk\n", diskImageVolume)
+}
+
+// addCommandStreamFlags adds to flags those of a subcommand writing a stream of monitor commands.
+func addCommandStreamFlags(flags *flag.FlagSet) *commandStreamFlags {
+	var stream *commandStreamFlags = &commandStreamFlags{line: addSerialLineFlags(flags)}
+	stream.lineProcessingTime = addMonitorLineTimeFlag(flags)
+	stream.highBit = addHighBitFlag(flags)
+	stream.lineEnding = flags.String("line-ending", "cr", "end each command line with a carriage return (cr), as the monitor needs, or a line feed (lf) for transfer programs converting line endings")
+	stream.outputFilepath = flags.String("output", "", "write the commands to this file instead of stdout, or to a file for each track when the name holds a format like %02d for the track number")
+	stream.chunkBytes = flags.Int("chunk-bytes", 0, "write the commands into numbered files of at most this many bytes instead of stdout, with a manifest of the send order")
+	stream.chunkPrefix = flags.String("chunk-prefix", "serial_install", "path and name prefix of the -chunk-bytes files and manifest")
+	stream.eventsFilepath = flags.String("events", "", "write JSON progress events, one per line, to this file")
+	stream.eventsFd = flags.Int("events-fd", -1, "write JSON progress events, one per line, to this open file descriptor")
+	stream.controlFd = flags.Int("control-fd", -1, "read control keys from this open file descriptor, as a program driving the transfer sends them: p pauses the transfer before the next track, and then lets it go on")
+	stream.dryRun = flags.Bool("dry-run", false, "build the commands without writing them, reporting the tracks, characters and time the transfer would take")
+	stream.quiet = flags.Bool("quiet", false, "do not report the progress of the transfer, with an estimate of the time remaining, on stderr")
+	stream.timingReport = flags.Bool("timing-report", false, "report the theoretical and measured time to transfer the command stream to stderr")
+	stream.segmentSize = flags.Int("segment-size", -1, "bytes per memory fill command, derived from -baud and -framing when negative")
+	stream.padLength = flags.Int("pad-length", -1, "spaces at the start of each command line, derived from -baud and -framing when negative")
+	stream.rampUpLines = flags.Int("ramp-up-lines", -1, "lines of growing memory fill commands sent before the first segment of each transfer, or -1 to derive them from the segment size")
+	stream.tuningFilepath = flags.String("tuning", "", "take -segment-size and -pad-length from this tuning profile file recorded by calibrate at the same -baud and -framing")
+	stream.checkedLines = flags.Bool("checked-lines", false, "with -port or -tcp, send each memory fill command with a checksum to a stub which answers ACK or NAK, and send it again until it is acknowledged")
+	stream.compact = flags.Bool("compact", false, "fill memory with fewer characters: continuation lines without addresses, bytes below 0x10 with one digit, and runs of equal bytes copied with the monitor move command")
+	stream.profile = flags.String("profile", "", "target machine profile: empty for a Disk II written through the DOS RWTS, bootstrap for a Disk II written by a writer program needing only the monitor, applesoft for a Disk II written through the DOS RWTS by programs typed into Applesoft instead of the monitor, iic-plus for the internal 3.5\" drive of an apple //c Plus, smartport for a drive on a SmartPort chain, prodos for a block device written through the ProDOS MLI, or laser128 for the serial port defaults of a Laser 128")
+	stream.slot = flags.Int("slot", 6, "the slot of the Disk II controller written to (or by dump, read from), or with -profile prodos of the block device")
+	stream.drive = flags.Int("drive", 1, "the drive (1 or 2) of the Disk II controller written to (or by dump, read from), or with -profile prodos of the block device")
+	stream.targetFormat = flags.String("target-format", "prodos", "the formatter of the destination disk: prodos (the ProDOS formatter) or dos33 (the DOS 3.3 INIT command), whose volume number the RWTS client then checks")
+	stream.targetVolume = flags.Int("target-volume", -1, "the volume number the RWTS requires of the destination disk, or 0 to accept any volume; by default 254 (as INIT gives) with -target-format dos33 and any volume otherwise")
+	stream.clientAddress = flags.Int("client-address", 0x0C00, "the memory address the client program is loaded at, at the start of a page (such as 0x6000); the read back program takes the page after it")
+	stream.bufferAddress = flags.Int("buffer-address", 0x2000, "the memory address of the 8KB data buffer, at the start of a page (such as 0x4000); give undump the same address as dump")
+	stream.clientStrategy = flags.String("client-strategy", "track", "a client writing (or reading) the whole track in ascending (track) or rotationally quicker descending (descending) sector order, or when installing, loading and writing one sector at a time (sector)")
+	stream.allTracks = flags.Bool("all-tracks", false, "all 35 tracks of the disk (when installing with a SmartPort profile, all block groups of the image) in one command stream, loading the client only once")
+	stream.trackList = flags.String("tracks", "", "the listed tracks and track ranges (such as 0-4,17,20-34, or when installing with a SmartPort profile block groups) in one command stream")
+	stream.trackWriteTime = flags.Duration("track-write-time", 5*time.Second, "with -all-tracks or -tracks, the time the client is given to write (or read) each track before the next one is sent")
+	stream.terminal = flags.Bool("terminal", false, "with -port or -tcp, first connect the terminal to the apple ][ through the serial line, to get to the monitor, and send the commands once ctrl-] is pressed")
+	stream.keepalive = flags.Duration("keepalive", 0, "with -port or -tcp, send a space (harmless to the monitor) this often while the transfer waits on the host side, such as when paused, for bridges dropping idle connections")
+	return stream
+}
+
+// commandDestination is where the monitor commands of install and dump go, as set up by
+// openCommandDestination: the serial port or TCP bridge, if any, with the characters read from it
+// arriving on input once they are read, and the pacing of the commands.
+type commandDestination struct {
+	port               io.ReadWriteCloser
+	input              chan byte
+	chunkWriter        *chunkedFileWriter
+	baud               int
+	bitsPerChar        int
+	segmentSize        int
+	lineStartPadLength int
+}
+
+// openCommandDestination checks the flags of stream given in flags, and sets up destination and the
+// command output for them: the output files or the serial line the commands go to, the disk and memory
+// the client works with, and the pacing of the commands, loading the checked line stub when there is
+// one. Whatever was opened is closed by destination.close, even when an error is returned.
+func openCommandDestination(destination *commandDestination, flags *flag.FlagSet, stream *commandStreamFlags) error {
+	var line *serialLineFlags = stream.line
+	if *stream.profile == "laser128" {
+		var setFlags map[string]bool = map[string]bool{}
+		flags.Visit(func(f *flag.Flag) {
+			setFlags[f.Name] = true
+		})
+		if !setFlags["baud"] {
+			*line.baud = 1200
+		}
+		if !setFlags["framing"] {
+			*line.framing = "8N1"
+		}
+	}
+	if *stream.profile != "" && *stream.profile != "bootstrap" && *stream.profile != "applesoft" && *stream.profile != "iic-plus" && *stream.profile != "smartport" && *stream.profile != "prodos" && *stream.profile != "laser128" {
+		return failure.CodedErrorf(failure.KIND_UNKNOWN_VALUE, "unknown profile: %s", *stream.profile)
+	}
+	var found bool
+	commandOutput.lineEnding, found = LINE_ENDINGS[*stream.lineEnding]
+	if !found {
+		return failure.CodedErrorf(failure.KIND_UNKNOWN_VALUE, "unknown line ending: %s", *stream.lineEnding)
+	}
+	if *stream.clientStrategy != "track" && *stream.clientStrategy != "sector" && *stream.clientStrategy != "descending" {
+		return failure.CodedErrorf(failure.KIND_UNKNOWN_VALUE, "unknown client strategy: %s", *stream.clientStrategy)
+	}
+	if *stream.dryRun && (*stream.outputFilepath != "" || *stream.chunkBytes > 0 || line.isConnected() || *stream.checkedLines) {
+		return failure.CodedErrorf(failure.KIND_OPTION_CONFLICT, "-dry-run writes no commands, and cannot be used with -output, -chunk-bytes, -port, -tcp or -checked-lines")
+	}
+	if *stream.outputFilepath != "" && (*stream.chunkBytes > 0 || line.isConnected()) {
+		return failure.CodedErrorf(failure.KIND_OPTION_CONFLICT, "-output cannot be used with -chunk-bytes, -port or -tcp")
+	}
+	if *stream.chunkBytes > 0 && line.isConnected() {
+		return failure.CodedErrorf(failure.KIND_OPTION_CONFLICT, "-port and -tcp send the commands directly, and cannot be used with -chunk-bytes")
+	}
+	if *stream.checkedLines && !line.isConnected() {
+		return failure.CodedErrorf(failure.KIND_OPTION_CONFLICT, "-checked-lines needs -port or -tcp, to receive the answers of the stub")
+	}
+	if *stream.keepalive > 0 && !line.isConnected() {
+		return failure.CodedErrorf(failure.KIND_OPTION_CONFLICT, "-keepalive needs -port or -tcp, whose bridge could drop an idle connection")
+	}
+	keepaliveInterval = *stream.keepalive
+	if *stream.terminal && !line.isConnected() {
+		return failure.CodedErrorf(failure.KIND_OPTION_CONFLICT, "-terminal needs -port or -tcp, to reach the apple ][")
+	}
+	if *stream.compact && *stream.checkedLines {
+		return failure.CodedErrorf(failure.KIND_OPTION_CONFLICT, "-compact cannot be used with -checked-lines, whose lines each carry their address and checksum")
+	}
+	// the client programs take 2 pages, and the data buffer 8KB (a track and the track read back, or the
+	// disk bytes of a track for the bootstrap writer), between the text screen and DOS
+	if *stream.clientAddress%0x0100 != 0 || *stream.clientAddress < 0x0800 || *stream.clientAddress+0x0200 > 0x9600 {
+		return failure.CodedErrorf(failure.KIND_ILLEGAL_CLIENT_ADDRESS, "illegal client address encountered: %04X", *stream.clientAddress)
+	}
+	if *stream.bufferAddress%0x0100 != 0 || *stream.bufferAddress < 0x0800 || *stream.bufferAddress+0x2000 > 0x9600 {
+		return failure.CodedErrorf(failure.KIND_ILLEGAL_BUFFER_ADDRESS, "illegal buffer address encountered: %04X", *stream.bufferAddress)
+	}
+	if *stream.clientAddress < *stream.bufferAddress+0x2000 && *stream.bufferAddress < *stream.clientAddress+0x0200 {
+		return failure.CodedErrorf(failure.KIND_OVERLAP, "the client programs at %04X overlap the data buffer at %04X", *stream.clientAddress, *stream.bufferAddress)
+	}
+	clientAddress = *stream.clientAddress
+	bufferAddress = *stream.bufferAddress
+	if *stream.checkedLines && overlapsCheckedLineStub() {
+		return failure.CodedErrorf(failure.KIND_OVERLAP, "the client programs at %04X or the data buffer at %04X overlap the checked line stub at %04X", clientAddress, bufferAddress, CHECKED_LINE_STUB_ADDRESS)
+	}
+	if *stream.slot < 1 || *stream.slot > 7 {
+		return failure.CodedErrorf(failure.KIND_ILLEGAL_SLOT, "illegal slot encountered: %d", *stream.slot)
+	}
+	if *stream.drive < 1 || *stream.drive > 2 {
+		return failure.CodedErrorf(failure.KIND_ILLEGAL_DRIVE, "illegal drive encountered: %d", *stream.drive)
+	}
+	targetDiskSlot = *stream.slot
+	targetDiskDrive = *stream.drive
+	if *stream.targetFormat != "prodos" && *stream.targetFormat != "dos33" {
+		return failure.CodedErrorf(failure.KIND_UNKNOWN_VALUE, "unknown target format: %s", *stream.targetFormat)
+	}
+	if *stream.targetVolume > 0xFE {
+		return failure.CodedErrorf(failure.KIND_ILLEGAL_VOLUME, "illegal volume number encountered: %d", *stream.targetVolume)
+	}
+	if *stream.targetVolume >= 0 {
+		targetDiskVolume = byte(*stream.targetVolume)
+	} else if *stream.targetFormat == "dos33" {
+		targetDiskVolume = '\xFE'
+	}
+	if *stream.targetFormat == "prodos" && targetDiskVolume != '\x00' && targetDiskVolume != '\xFE' {
+		return failure.CodedErrorf(failure.KIND_OPTION_CONFLICT, "the ProDOS formatter always gives volume 254, so volume %d needs -target-format dos33", targetDiskVolume)
+	}
+	var err error = openProgressEventOutput(*stream.eventsFilepath, *stream.eventsFd)
+	if err != nil {
+		return err
+	}
+	openTransferControl(*stream.controlFd)
+	if *stream.dryRun {
+		commandOutput.output = ioutil.Discard
+		*stream.quiet = true
+		*stream.timingReport = true
+	}
+	if *stream.outputFilepath != "" && !isPerTrackOutputFilepath(*stream.outputFilepath) {
+		err = openCommandOutputFile(*stream.outputFilepath)
+		if err != nil {
+			return err
+		}
+	}
+	if *stream.chunkBytes > 0 {
+		destination.chunkWriter = &chunkedFileWriter{filepathPrefix: *stream.chunkPrefix, maxBytes: *stream.chunkBytes}
+		commandOutput.output = destination.chunkWriter
+	}
+	destination.baud = *line.baud
+	err = openSerialLine(&destination.port, &destination.bitsPerChar, line)
+	if err != nil {
+		return err
+	}
+	if destination.port != nil {
+		commandOutput.output = destination.port
+	}
+	commandOutput.dataBits = int((*line.framing)[0] - '0')
+	commandOutput.highBit = *stream.highBit
+	if *stream.highBit && commandOutput.dataBits == 7 {
+		return failure.CodedErrorf(failure.KIND_OPTION_CONFLICT, "-high-bit needs a framing with 8 data bits")
+	}
+	derivePacing(&destination.segmentSize, &destination.lineStartPadLength, *line.baud, destination.bitsPerChar, *stream.lineProcessingTime)
+	if *stream.tuningFilepath != "" {
+		err = readTuningProfile(&destination.segmentSize, &destination.lineStartPadLength, *stream.tuningFilepath, *line.baud, *line.framing)
+		if err != nil {
+			return err
+		}
+	}
+	if *stream.segmentSize >= 0 {
+		destination.segmentSize = *stream.segmentSize
+	}
+	if *stream.padLength >= 0 {
+		destination.lineStartPadLength = *stream.padLength
+	}
+	if destination.segmentSize < 1 {
+		return failure.CodedErrorf(failure.KIND_ILLEGAL_SEGMENT_SIZE, "segment size must be at least 1, not %d", destination.segmentSize)
+	}
+	rampUpLineCount = deriveRampUpLineCount(destination.segmentSize)
+	if *line.flowControl != "none" {
+		// the apple ][ holds off the characters it would lose, so no padding or ramp-up is needed
+		if *stream.padLength < 0 {
+			destination.lineStartPadLength = 0
+		}
+		rampUpLineCount = 0
+	}
+	// the monitor cancels a line filling its input buffer, so the whole line must fit, padding included
+	var lineLength int = destination.lineStartPadLength + 5 + 3*destination.segmentSize
+	if *stream.checkedLines {
+		// the staged address, count and checksum bytes, then N and the call of the stub
+		lineLength = lineLength + 3*4 + 3 + 5
+	}
+	if lineLength > simulator.MONITOR_INPUT_LINE_LIMIT-1 {
+		return failure.CodedErrorf(failure.KIND_ILLEGAL_SEGMENT_SIZE, "memory fill lines of %d bytes after %d spaces of padding take %d characters, more than the %d the monitor input buffer holds", destination.segmentSize, destination.lineStartPadLength, lineLength, simulator.MONITOR_INPUT_LINE_LIMIT-1)
+	}
+	if *stream.rampUpLines < -1 {
+		return failure.CodedErrorf(failure.KIND_ILLEGAL_RAMP_UP_LINES, "illegal ramp-up line count encountered: %d", *stream.rampUpLines)
+	}
+	if *stream.rampUpLines >= 0 {
+		rampUpLineCount = *stream.rampUpLines
+	}
+	if *stream.terminal {
+		err = runTerminal(destination.port, destination.serialInput())
+		if err != nil {
+			return err
+		}
+		// the pacing of a bridge starts over with the commands, leaving out the keys typed
+		bridge, ok := destination.port.(*tcpPort)
+		if ok {
+			bridge.charCount = 0
+		}
+	}
+	if *stream.checkedLines {
+		writeCommandsToLoadCheckedLineStubToMemory(destination.segmentSize, destination.lineStartPadLength)
+		// checked lines are paced by the answers of the stub, so no ramp-up is needed
+		rampUpLineCount = 0
+		checkedLines = &checkedLineLink{input: destination.serialInput(), baud: *line.baud, bitsPerChar: destination.bitsPerChar, timeout: time.Second}
+	}
+	compactEncoding = *stream.compact
+	return nil
+}
+
+// overlapsCheckedLineStub tells whether the client programs at clientAddress or the data buffer at
+// bufferAddress overlap the checked line stub, where the binary receiver is loaded too.
+func overlapsCheckedLineStub() bool {
+	return clientAddress < CHECKED_LINE_STUB_ADDRESS+0x0200 && CHECKED_LINE_STUB_ADDRESS < clientAddress+0x0200 ||
+		bufferAddress < CHECKED_LINE_STUB_ADDRESS+0x0200 && CHECKED_LINE_STUB_ADDRESS < bufferAddress+0x2000
+}
+
+// serialInput returns the channel the characters read from the serial line of destination arrive on,
+// starting to read them the first time. The answers of the checked line stub, the read back lines and
+// the memory dumps all arrive there.
+func (destination *commandDestination) serialInput() chan byte {
+	if destination.input == nil {
+		destination.input = make(chan byte, 0x0400)
+		go protocol.ReadYmodemLinkInput(destination.input, destination.port)
+	}
+	return destination.input
+}
+
+// close reports the checked lines sent, if any, and closes the serial line and the output files of
+// destination, returning the first error met.
+func (destination *commandDestination) close() error {
+	var err error
+	if checkedLines != nil {
+		reportCheckedLines()
+	}
+	if destination.port != nil {
+		err = closeSerialPort(destination.port, destination.baud, destination.bitsPerChar)
+	}
+	if destination.chunkWriter != nil {
+		var closeErr error = destination.chunkWriter.Close()
+		if err == nil {
+			err = closeErr
+		}
+	}
+	var closeErr error = closeCommandOutputFile()
+	if err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// parseStreamTrackList stores into trackNums the tracks given by -all-tracks (all 35 tracks) or
+// -tracks of stream, leaving it empty when neither is given.
+func parseStreamTrackList(trackNums *[]int, stream *commandStreamFlags) error {
+	if *stream.allTracks {
+		return parseTrackList(trackNums, "0-34")
+	}
+	if *stream.trackList != "" {
+		return parseTrackList(trackNums, *stream.trackList)
+	}
+	return nil
+}
+
+// settleCharCount returns the count of characters sent in the time given to the client to write (or
+// read) each track with -all-tracks or -tracks, at the speed of destination.
+func (destination *commandDestination) settleCharCount(trackWriteTime time.Duration) int {
+	return int(math.Ceil(trackWriteTime.Seconds() * float64(destination.baud) / float64(destination.bitsPerChar)))
+}
+
+// runInstall carries out the install subcommand, writing the commands which install the tracks (or
+// block groups) of a disk image given by args.
+func runInstall(args []string) (err error) {
+	var flags *flag.FlagSet = newSubcommandFlagSet("install", "diskImageFilepath [trackNum]")
+	addImageFlags(flags)
+	var partitionNum *int = addPartitionFlag(flags)
+	var stream *commandStreamFlags = addCommandStreamFlags(flags)
+	var dataOnly *bool = flags.Bool("data-only", false, "only load the track data into memory at 0x2000 (or -buffer-address), without loading or executing the client program")
+	var clientOnly *bool = flags.Bool("client-only", false, "only load the client program which writes track trackNum from memory at 0x2000 (or -buffer-address), without loading the track data or reading a disk image")
+	var execute *bool = flags.Bool("execute", false, "with -client-only, also execute the client program")
+	var readBack *bool = flags.Bool("read-back", false, "after writing each track, read it back into memory at 0x3000 (0x1000 past -buffer-address) and print a checksum for each sector, for check")
+	var retries *int = flags.Int("retries", 0, "with -read-back and -port or -tcp, check the read back checksums of each track as they arrive, and send a track which differs again up to this many times")
+	var verifyMemory *bool = flags.Bool("verify-memory", false, "after loading each track, display the track buffer with the monitor and compare the dump arriving on -port or -tcp against the track, sending differing rows again before the client writes it")
+	var binary *bool = flags.Bool("binary", false, "load a receiver program first, and then send the track data as raw bytes which it reads from the serial card directly, instead of as memory fill commands (needs 8 data bits)")
+	var serialSlot *int = flags.Int("serial-slot", 2, "with -binary, the slot of the Super Serial Card (or compatible) the commands arrive through")
+	var simulate *bool = flags.Bool("simulate", false, "feed the commands to a simulated apple ][ monitor, losing characters as it would at -baud and -monitor-line-time, instead of writing them, and check the memory it ends up with against the tracks")
+	var simulateMemoryFilepath *string = flags.String("simulate-memory", "", "with -simulate, write the 64K memory of the simulated apple ][ to this file")
+	var faultDrop *float64 = flags.Float64("fault-drop", 0, "with -simulate, the chance (from 0 to 1) that a fault dropping characters on the line starts at each character, such as 0.0001")
+	var faultCorrupt *float64 = flags.Float64("fault-corrupt", 0, "with -simulate, the chance (from 0 to 1) that a fault corrupting characters on the line, by flipping one of their bits, starts at each character")
+	var faultBurst *int = flags.Int("fault-burst", 1, "with -simulate, the count of characters each fault of -fault-drop or -fault-corrupt goes on for")
+	var faultSeed *int64 = flags.Int64("fault-seed", 1, "with -simulate, the seed of the random faults, the same seed giving the same faults")
+	var tui *bool = flags.Bool("tui", false, "with -port or -tcp and -all-tracks or -tracks, show the state of each track in a grid on the terminal, with the throughput, and keys to pause the transfer before the next track and to retry the tracks failing -read-back -retries")
+	var sessionFilepath *string = flags.String("session", "serial_install.session", "with -all-tracks or -tracks, the file recording the tracks (or block groups) sent so far, removed once all are sent")
+	var resume *bool = flags.Bool("resume", false, "with -all-tracks or -tracks, continue the interrupted transfer recorded in -session, leaving out the tracks it completed")
+	var smartPortSlot *int = flags.Int("smartport-slot", 5, "with -profile smartport, the slot of the SmartPort firmware")
+	var smartPortUnit *int = flags.Int("smartport-unit", 1, "with -profile smartport, the unit number (counting from 1) of the drive on the SmartPort chain")
+	var applesoftFilepath *string = flags.String("applesoft-file", "", "write the tracks and the client program to this file as tokenized Applesoft programs poking them into memory, to be added to a disk image and run, with a format like %02d for the track number when installing more than one track")
+	var hexFilepath *string = flags.String("hex-file", "", "write the tracks and the client program to this file in -hex-format, for EPROM emulators and other loaders, with a format like %02d for the track number when installing more than one track")
+	var hexFormat *string = flags.String("hex-format", "", "the format of -hex-file: ihex (Intel HEX) or srec (Motorola S-records)")
+	var cassetteFilepath *string = flags.String("cassette", "", "write the tracks and the client program as apple ][ cassette records to this WAV file, to be played into the cassette input instead of sent over a serial line, with a format like %02d for the track number when installing more than one track")
+	flags.Parse(args)
+	var line *serialLineFlags = stream.line
+	var profile string = *stream.profile
+	var clientStrategy string = *stream.clientStrategy
+	if *stream.dryRun && *resume {
+		return failure.CodedErrorf(failure.KIND_OPTION_CONFLICT, "-dry-run writes no commands, and cannot be used with -resume")
+	}
+	if *simulate && (*stream.dryRun || *stream.outputFilepath != "" || *stream.chunkBytes > 0 || line.isConnected() || *resume) {
+		return failure.CodedErrorf(failure.KIND_OPTION_CONFLICT, "-simulate feeds the commands to a simulated monitor, and cannot be used with -dry-run, -output, -chunk-bytes, -port, -tcp or -resume")
+	}
+	if *simulate && (*binary || *clientOnly || *hexFilepath != "" || *cassetteFilepath != "" || *applesoftFilepath != "" || (profile != "" && profile != "laser128")) {
+		return failure.CodedErrorf(failure.KIND_OPTION_CONFLICT, "-simulate checks the tracks loaded by memory fill commands for the RWTS client, and cannot be used with -binary, -client-only, -hex-file, -cassette, -applesoft-file or a profile other than laser128")
+	}
+	if !*simulate && *simulateMemoryFilepath != "" {
+		return failure.CodedErrorf(failure.KIND_OPTION_CONFLICT, "-simulate-memory needs -simulate, to simulate the memory")
+	}
+	var faults *simulator.CharacterFaults
+	if *faultDrop != 0 || *faultCorrupt != 0 {
+		if !*simulate {
+			return failure.CodedErrorf(failure.KIND_OPTION_CONFLICT, "-fault-drop and -fault-corrupt need -simulate, to receive the faulty characters")
+		}
+		if *faultDrop < 0 || *faultCorrupt < 0 || *faultDrop+*faultCorrupt > 1 || *faultBurst < 1 {
+			return failure.CodedErrorf(failure.KIND_ILLEGAL_FAULT_MODEL, "illegal fault model encountered: -fault-drop %g -fault-corrupt %g -fault-burst %d", *faultDrop, *faultCorrupt, *faultBurst)
+		}
+		faults = &simulator.CharacterFaults{DropRate: *faultDrop, CorruptRate: *faultCorrupt, BurstLength: *faultBurst, Random: rand.New(rand.NewSource(*faultSeed))}
+	}
+	if *line.flowControl != "none" && !line.isConnected() && !*simulate {
+		return failure.CodedErrorf(failure.KIND_OPTION_CONFLICT, "-flow-control needs -port or -tcp, to set up the serial line")
+	}
+	if *binary && (*stream.checkedLines || clientStrategy == "sector" || (profile != "" && profile != "laser128")) {
+		return failure.CodedErrorf(failure.KIND_OPTION_CONFLICT, "-binary sends whole tracks for the RWTS client, and cannot be used with -checked-lines, the sector client strategy or a profile other than laser128")
+	}
+	if *binary && (*serialSlot < 1 || *serialSlot > 7) {
+		return failure.CodedErrorf(failure.KIND_ILLEGAL_SERIAL_SLOT, "illegal serial slot encountered: %d", *serialSlot)
+	}
+	if profile == "bootstrap" && (clientStrategy != "track" || *dataOnly || *clientOnly) {
+		return failure.CodedErrorf(failure.KIND_OPTION_CONFLICT, "-profile bootstrap writes whole tracks with its own writer program, and cannot be used with another client strategy, -data-only or -client-only")
+	}
+	if profile == "applesoft" && (clientStrategy == "sector" || *dataOnly || *clientOnly || *readBack || *stream.checkedLines) {
+		return failure.CodedErrorf(failure.KIND_OPTION_CONFLICT, "-profile applesoft types whole tracks into Applesoft with the RWTS client, and cannot be used with the sector client strategy, -data-only, -client-only, -read-back or -checked-lines")
+	}
+	if *applesoftFilepath != "" && (*hexFilepath != "" || *cassetteFilepath != "") {
+		return failure.CodedErrorf(failure.KIND_OPTION_CONFLICT, "-applesoft-file, -hex-file and -cassette each write the tracks to their own files, and cannot be used together")
+	}
+	if *applesoftFilepath != "" && (clientStrategy == "sector" || *dataOnly || *clientOnly || *readBack || line.isConnected() || (profile != "" && profile != "applesoft")) {
+		return failure.CodedErrorf(failure.KIND_OPTION_CONFLICT, "-applesoft-file writes whole tracks with the RWTS client to Applesoft programs, and cannot be used with the sector client strategy, -data-only, -client-only, -read-back, -port, -tcp or another profile")
+	}
+	if *hexFormat != "" && *hexFormat != "ihex" && *hexFormat != "srec" {
+		return failure.CodedErrorf(failure.KIND_UNKNOWN_VALUE, "illegal hex format encountered: %s", *hexFormat)
+	}
+	if (*hexFormat != "") != (*hexFilepath != "") {
+		return failure.CodedErrorf(failure.KIND_OPTION_CONFLICT, "-hex-file needs -hex-format, and -hex-format needs -hex-file")
+	}
+	if *hexFilepath != "" && *cassetteFilepath != "" {
+		return failure.CodedErrorf(failure.KIND_OPTION_CONFLICT, "-hex-file and -cassette each write the tracks to their own files, and cannot be used together")
+	}
+	if *hexFilepath != "" && (clientStrategy == "sector" || *dataOnly || *clientOnly || *readBack || line.isConnected() || (profile != "" && profile != "bootstrap")) {
+		return failure.CodedErrorf(failure.KIND_OPTION_CONFLICT, "-hex-file writes whole tracks with the RWTS client or the bootstrap writer to a file, and cannot be used with the sector client strategy, -data-only, -client-only, -read-back, -port, -tcp or a SmartPort profile")
+	}
+	if *cassetteFilepath != "" && (clientStrategy == "sector" || *dataOnly || *clientOnly || *readBack || line.isConnected() || (profile != "" && profile != "bootstrap")) {
+		return failure.CodedErrorf(failure.KIND_OPTION_CONFLICT, "-cassette writes whole tracks with the RWTS client or the bootstrap writer to a WAV file, and cannot be used with the sector client strategy, -data-only, -client-only, -read-back, -port, -tcp or a SmartPort profile")
+	}
+	if *smartPortSlot < 1 || *smartPortSlot > 7 {
+		return failure.CodedErrorf(failure.KIND_ILLEGAL_SMARTPORT, "illegal SmartPort slot encountered: %d", *smartPortSlot)
+	}
+	if *smartPortUnit < 1 || *smartPortUnit > 0x7E {
+		return failure.CodedErrorf(failure.KIND_ILLEGAL_SMARTPORT, "illegal SmartPort unit encountered: %d", *smartPortUnit)
+	}
+	if *dataOnly && clientStrategy == "sector" {
+		return failure.CodedErrorf(failure.KIND_OPTION_CONFLICT, "-data-only loads the whole track, and cannot be used with the sector client strategy")
+	}
+	if *clientOnly && clientStrategy == "sector" {
+		return failure.CodedErrorf(failure.KIND_OPTION_CONFLICT, "-client-only loads a client for the whole track, and cannot be used with the sector client strategy")
+	}
+	if *readBack && (clientStrategy == "sector" || *dataOnly || *clientOnly || profile != "") {
+		return failure.CodedErrorf(failure.KIND_OPTION_CONFLICT, "-read-back reads back a whole track written by the RWTS client, and cannot be used with the sector client strategy, -data-only, -client-only or a SmartPort profile")
+	}
+	if *retries > 0 && (!*readBack || !line.isConnected()) {
+		return failure.CodedErrorf(failure.KIND_OPTION_CONFLICT, "-retries needs -read-back and -port or -tcp, to receive the read back checksums")
+	}
+	if *verifyMemory && !line.isConnected() {
+		return failure.CodedErrorf(failure.KIND_OPTION_CONFLICT, "-verify-memory needs -port or -tcp, to receive the memory dumps")
+	}
+	if *verifyMemory && (clientStrategy == "sector" || *clientOnly || profile != "") {
+		return failure.CodedErrorf(failure.KIND_OPTION_CONFLICT, "-verify-memory checks the whole track loaded for the RWTS client, and cannot be used with the sector client strategy, -client-only or a SmartPort profile")
+	}
+	if *tui && (!line.isConnected() || !(*stream.allTracks || *stream.trackList != "")) {
+		return failure.CodedErrorf(failure.KIND_OPTION_CONFLICT, "-tui needs -port or -tcp, and -all-tracks or -tracks, to show the tracks sent in a grid")
+	}
+	if *tui && (profile == "iic-plus" || profile == "smartport" || profile == "prodos") {
+		return failure.CodedErrorf(failure.KIND_OPTION_CONFLICT, "-tui shows the tracks of a 140K floppy installed over the serial line, and cannot be used with a SmartPort profile")
+	}
+	var destination commandDestination
+	defer func() {
+		var closeErr error = destination.close()
+		if err == nil {
+			err = closeErr
+		}
+	}()
+	err = openCommandDestination(&destination, flags, stream)
+	if err != nil {
+		return err
+	}
+	var SEGMENT_SIZE int = destination.segmentSize
+	var LINE_START_PAD_LENGTH int = destination.lineStartPadLength
+	var baud int = *line.baud
+	var bitsPerChar int = destination.bitsPerChar
+	if *simulate {
+		var clientTime time.Duration = *stream.trackWriteTime
+		if clientStrategy == "sector" {
+			// the client writes a single sector each time it is executed
+			clientTime = clientTime / 0x10
+		}
+		startMonitorSimulation(baud, bitsPerChar, *stream.lineProcessingTime, clientTime, *line.flowControl != "none", faults, *simulateMemoryFilepath)
+	}
+	if *binary {
+		if commandOutput.dataBits == 7 {
+			return failure.CodedErrorf(failure.KIND_OPTION_CONFLICT, "-binary needs a framing with 8 data bits, to send raw bytes")
+		}
+		if overlapsCheckedLineStub() {
+			return failure.CodedErrorf(failure.KIND_OVERLAP, "the client programs at %04X or the data buffer at %04X overlap the binary receiver at %04X", clientAddress, bufferAddress, CHECKED_LINE_STUB_ADDRESS)
+		}
+		binaryTransfer = &binaryReceiver{slot: *serialSlot}
+	}
+	if *retries > 0 {
+		readBackVerification = &readBackVerifier{maxRetries: *retries, timeout: 2**stream.trackWriteTime + time.Duration((transmissionSeconds(READ_BACK_LINE_LENGTH, baud, bitsPerChar)+1)*float64(time.Second)), baud: baud, bitsPerChar: bitsPerChar}
+		readBackVerification.input = destination.serialInput()
+	}
+	if *verifyMemory {
+		memoryVerification = &memoryVerifier{baud: baud, bitsPerChar: bitsPerChar, input: destination.serialInput()}
+	}
+	if *tui {
+		// the grid takes the place of the progress report
+		*stream.quiet = true
+	}
+	// with nothing waited for from the apple ][, each track can be prepared while the one before it is
+	// sent; the track grid shows each track as it is sent instead
+	trackPipelining = destination.port != nil && checkedLines == nil && readBackVerification == nil && memoryVerification == nil && !*tui
+	if *clientOnly {
+		var trackNumInt int
+		trackNumInt, err = strconv.Atoi(flags.Arg(0))
+		if err != nil {
+			return err
+		}
+		err = startTrackOutputFile(*stream.outputFilepath, trackNumInt)
+		if err != nil {
+			return err
+		}
+		progressEventTrack = trackNumInt
+		emitProgressEvent("track_started", 0, 0)
+		err = writeCommandsToLoadRWTSClientProgramToMemory(trackNumInt, RWTS_COMMAND_WRITE, clientStrategy, SEGMENT_SIZE, LINE_START_PAD_LENGTH)
+		if err != nil {
+			return err
+		}
+		if *execute {
+			executeClient(trackNumInt, RWTS_COMMAND_WRITE, "", LINE_START_PAD_LENGTH)
+		}
+		emitProgressEvent("track_finished", commandOutput.lineCount, commandOutput.charCount)
+		if commandOutput.err != nil {
+			return commandOutput.err
+		}
+		if *stream.timingReport {
+			reportTransferTiming(fmt.Sprintf("track %d", trackNumInt), 0x34, baud, *line.framing)
+		}
+		return nil
+	}
+	var diskImageFilepath string = flags.Arg(0)
+	// the block profiles write a block group at a time, through the SmartPort firmware (the internal
+	// 3.5" drive of the apple //c Plus is the first unit of slot 5) or through the ProDOS MLI to the
+	// block device of -slot and -drive
+	var blockProfile bool = profile == "iic-plus" || profile == "smartport" || profile == "prodos"
+	var blockClient string = "smartport"
+	var blockSlot int = *smartPortSlot
+	var blockUnit int = *smartPortUnit
+	if profile == "iic-plus" {
+		blockSlot = 5
+		blockUnit = 1
+	} else if profile == "prodos" {
+		blockClient = "mli"
+		blockSlot = targetDiskSlot
+		blockUnit = targetDiskDrive
+	}
+	if *cassetteFilepath != "" || *hexFilepath != "" || *applesoftFilepath != "" {
+		var recordFilepath string = *cassetteFilepath
+		if *hexFilepath != "" {
+			recordFilepath = *hexFilepath
+		} else if *applesoftFilepath != "" {
+			recordFilepath = *applesoftFilepath
+		}
+		var diskImage []byte
+		err = readDiskImagePartition(&diskImage, diskImageFilepath, *partitionNum)
+		if err != nil {
+			return err
+		}
+		stream.useDiskImageVolume()
+		if diskImageIs13Sector {
+			err = gcr.Check13SectorInstall(diskImageFilepath, profile)
+			if err != nil {
+				return err
+			}
+		} else {
+			err = checkFloppyImageSize(diskImage, diskImageFilepath)
+			if err != nil {
+				return err
+			}
+			err = diskimage.ConvertDiskImageFromProdosOrderToDos33Order(diskImage)
+			if err != nil {
+				return err
+			}
+		}
+		var trackNums []int
+		err = parseStreamTrackList(&trackNums, stream)
+		if err != nil {
+			return err
+		}
+		if len(trackNums) == 0 {
+			err = parseTrackList(&trackNums, flags.Arg(1))
+			if err != nil {
+				return err
+			}
+		}
+		if len(trackNums) > 1 && !isPerTrackOutputFilepath(recordFilepath) {
+			if *hexFilepath != "" {
+				return failure.CodedErrorf(failure.KIND_HEX_FILE_NAME, "-hex-file needs a name with a format like %%02d for the track number to write more than one track")
+			}
+			if *applesoftFilepath != "" {
+				return failure.CodedErrorf(failure.KIND_APPLESOFT_FILE_NAME, "-applesoft-file needs a name with a format like %%02d for the track number to write more than one track")
+			}
+			return failure.CodedErrorf(failure.KIND_CASSETTE_NAME, "-cassette needs a name with a format like %%02d for the track number to write more than one track")
+		}
+		for _, trackNum := range trackNums {
+			var trackFilepath string = recordFilepath
+			if isPerTrackOutputFilepath(trackFilepath) {
+				trackFilepath = fmt.Sprintf(trackFilepath, trackNum)
+			}
+			if *hexFilepath != "" {
+				err = writeHexTrackToFile(trackFilepath, *hexFormat, diskImage, trackNum, clientStrategy, profile == "bootstrap")
+			} else if *applesoftFilepath != "" {
+				err = writeApplesoftTrackToFile(trackFilepath, diskImage, trackNum, clientStrategy)
+			} else {
+				err = writeCassetteTrackToWavFile(trackFilepath, diskImage, trackNum, clientStrategy, profile == "bootstrap")
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if *stream.allTracks || *stream.trackList != "" {
+		if *dataOnly {
+			return failure.CodedErrorf(failure.KIND_OPTION_CONFLICT, "-all-tracks and -tracks install whole tracks or block groups with a client, and cannot be used with -data-only")
+		}
+		var diskImage []byte
+		err = readDiskImagePartition(&diskImage, diskImageFilepath, *partitionNum)
+		if err != nil {
+			return err
+		}
+		stream.useDiskImageVolume()
+		if blockProfile {
+			// -all-tracks and -tracks select block groups, all of them being every block of the image
+			var groupCount int = (len(diskImage) + 0x0FFF) / 0x1000
+			var groupNums []int
+			if *stream.allTracks {
+				err = parseBlockGroupList(&groupNums, fmt.Sprintf("0-%d", groupCount-1), groupCount)
+			} else {
+				err = parseBlockGroupList(&groupNums, *stream.trackList, groupCount)
+			}
+			if err != nil {
+				return err
+			}
+			if !*stream.dryRun {
+				err = startTransferSession(&groupNums, *sessionFilepath, diskImageFilepath, diskImage, profile, *resume, baud, bitsPerChar)
+				if err != nil {
+					return err
+				}
+				if len(groupNums) == 0 {
+					return finishTransferSession()
+				}
+			}
+			var settleCharCount int = destination.settleCharCount(*stream.trackWriteTime)
+			if isPerTrackOutputFilepath(*stream.outputFilepath) {
+				settleCharCount = 0
+			}
+			if !*stream.quiet {
+				startProgressReport(len(groupNums), estimateTrackCharCount(SEGMENT_SIZE, LINE_START_PAD_LENGTH)+settleCharCount, baud, bitsPerChar)
+			}
+			if isPerTrackOutputFilepath(*stream.outputFilepath) {
+				// each file loads the client and installs its block group on its own
+				for _, groupNum := range groupNums {
+					err = startTrackOutputFile(*stream.outputFilepath, groupNum)
+					if err != nil {
+						return err
+					}
+					err = writeCommandsToInstallBlockGroups(diskImage, []int{groupNum}, blockClient, blockSlot, blockUnit, settleCharCount, SEGMENT_SIZE, LINE_START_PAD_LENGTH)
+					if err != nil {
+						return err
+					}
+				}
+			} else {
+				err = writeCommandsToInstallBlockGroups(diskImage, groupNums, blockClient, blockSlot, blockUnit, settleCharCount, SEGMENT_SIZE, LINE_START_PAD_LENGTH)
+				if err != nil {
+					return err
+				}
+			}
+			err = finishTransferSession()
+			if err != nil {
+				return err
+			}
+			if *stream.timingReport {
+				reportTransferTiming(fmt.Sprintf("%d block groups", len(groupNums)), len(groupNums)*0x1000+0x40, baud, *line.framing)
+			}
+			return nil
+		}
+		if diskImageIs13Sector {
+			err = gcr.Check13SectorInstall(diskImageFilepath, profile)
+			if err != nil {
+				return err
+			}
+		} else {
+			err = checkFloppyImageSize(diskImage, diskImageFilepath)
+			if err != nil {
+				return err
+			}
+			err = diskimage.ConvertDiskImageFromProdosOrderToDos33Order(diskImage)
+			if err != nil {
+				return err
+			}
+		}
+		var trackNums []int
+		err = parseStreamTrackList(&trackNums, stream)
+		if err != nil {
+			return err
+		}
+		if !*stream.dryRun && !*simulate {
+			err = startTransferSession(&trackNums, *sessionFilepath, diskImageFilepath, diskImage, profile, *resume, baud, bitsPerChar)
+			if err != nil {
+				return err
+			}
+			if len(trackNums) == 0 {
+				return finishTransferSession()
+			}
+		}
+		var settleCharCount int = destination.settleCharCount(*stream.trackWriteTime)
+		if profile == "applesoft" {
+			// the loader program pokes the client and the track data before the track is written
+			settleCharCount = settleCharCount + int(math.Ceil(APPLESOFT_POKE_TIME_PER_BYTE.Seconds()*(0x1000+0x34)*float64(baud)/float64(bitsPerChar)))
+		}
+		if isPerTrackOutputFilepath(*stream.outputFilepath) {
+			settleCharCount = 0
+		}
+		if !*stream.quiet {
+			startProgressReport(len(trackNums), estimateTrackCharCount(SEGMENT_SIZE, LINE_START_PAD_LENGTH)+settleCharCount, baud, bitsPerChar)
+		}
+		if *tui {
+			err = startTrackGrid(trackNums)
+			if err != nil {
+				return err
+			}
+			defer func() {
+				var finishErr error = finishTrackGrid()
+				if err == nil {
+					err = finishErr
+				}
+			}()
+		}
+		if isPerTrackOutputFilepath(*stream.outputFilepath) {
+			// each file loads the client and installs its track on its own, to be sent one at a time,
+			// so no time is left for the tracks after it
+			for _, trackNum := range trackNums {
+				err = startTrackOutputFile(*stream.outputFilepath, trackNum)
+				if err != nil {
+					return err
+				}
+				if profile == "bootstrap" {
+					err = writeCommandsToInstallDiskTracksWithBootstrapWriter(diskImage, []int{trackNum}, settleCharCount, SEGMENT_SIZE, LINE_START_PAD_LENGTH)
+				} else if profile == "applesoft" {
+					err = writeCommandsToInstallDiskTracksWithApplesoftLoader(diskImage, []int{trackNum}, clientStrategy, settleCharCount, LINE_START_PAD_LENGTH)
+				} else {
+					err = writeCommandsToInstallDiskTracks(diskImage, []int{trackNum}, clientStrategy, *readBack, settleCharCount, SEGMENT_SIZE, LINE_START_PAD_LENGTH)
+				}
+				if err != nil {
+					return err
+				}
+			}
+		} else if profile == "bootstrap" {
+			err = writeCommandsToInstallDiskTracksWithBootstrapWriter(diskImage, trackNums, settleCharCount, SEGMENT_SIZE, LINE_START_PAD_LENGTH)
+			if err != nil {
+				return err
+			}
+		} else if profile == "applesoft" {
+			err = writeCommandsToInstallDiskTracksWithApplesoftLoader(diskImage, trackNums, clientStrategy, settleCharCount, LINE_START_PAD_LENGTH)
+			if err != nil {
+				return err
+			}
+		} else {
+			err = writeCommandsToInstallDiskTracks(diskImage, trackNums, clientStrategy, *readBack, settleCharCount, SEGMENT_SIZE, LINE_START_PAD_LENGTH)
+			if err != nil {
+				return err
+			}
+			for {
+				var retry bool
+				retry, err = awaitTrackRetry()
+				if err != nil {
+					return err
+				}
+				if !retry {
+					break
+				}
+				var retryTrackNums []int = readBackVerification.failedTrackNums
+				readBackVerification.failedTrackNums = nil
+				err = writeCommandsToInstallDiskTracks(diskImage, retryTrackNums, clientStrategy, *readBack, settleCharCount, SEGMENT_SIZE, LINE_START_PAD_LENGTH)
+				if err != nil {
+					return err
+				}
+			}
+		}
+		if *stream.timingReport {
+			if profile == "bootstrap" {
+				// the used bytes of the two pages of each sector, and the writer program
+				reportTransferTiming(fmt.Sprintf("%d tracks", len(trackNums)), len(trackNums)*bootstrapTrackByteCount()+0x0180, baud, *line.framing)
+			} else {
+				reportTransferTiming(fmt.Sprintf("%d tracks", len(trackNums)), len(trackNums)*0x1000+0x34, baud, *line.framing)
+			}
+		}
+		err = reportReadBackVerification(len(trackNums))
+		if err != nil {
+			return err
+		}
+		err = reportMonitorSimulation(diskImage, trackNums, clientStrategy, false)
+		if err != nil {
+			return err
+		}
+		return finishTransferSession()
+	}
+	var trackNumInt int
+	trackNumInt, err = strconv.Atoi(flags.Arg(1))
+	if err != nil {
+		return err
+	}
+	var diskImage []byte
+	err = readDiskImagePartition(&diskImage, diskImageFilepath, *partitionNum)
+	if err != nil {
+		return err
+	}
+	stream.useDiskImageVolume()
+	if blockProfile {
+		if !*stream.quiet {
+			startProgressReport(1, estimateTrackCharCount(SEGMENT_SIZE, LINE_START_PAD_LENGTH), baud, bitsPerChar)
+		}
+		err = startTrackOutputFile(*stream.outputFilepath, trackNumInt)
+		if err != nil {
+			return err
+		}
+		err = writeCommandsToInstallBlockGroups(diskImage, []int{trackNumInt}, blockClient, blockSlot, blockUnit, 0, SEGMENT_SIZE, LINE_START_PAD_LENGTH)
+		if err != nil {
+			return err
+		}
+		if *stream.timingReport {
+			reportTransferTiming(fmt.Sprintf("block group %d", trackNumInt), 0x1000+0x40, baud, *line.framing)
+		}
+		return nil
+	}
+	if diskImageIs13Sector {
+		err = gcr.Check13SectorInstall(diskImageFilepath, profile)
+		if err != nil {
+			return err
+		}
+	} else {
+		err = checkFloppyImageSize(diskImage, diskImageFilepath)
+		if err != nil {
+			return err
+		}
+		err = diskimage.ConvertDiskImageFromProdosOrderToDos33Order(diskImage)
+		if err != nil {
+			return err
+		}
+	}
+	if !*stream.quiet {
+		startProgressReport(1, estimateTrackCharCount(SEGMENT_SIZE, LINE_START_PAD_LENGTH), baud, bitsPerChar)
+	}
+	err = startTrackOutputFile(*stream.outputFilepath, trackNumInt)
+	if err != nil {
+		return err
+	}
+	if profile == "bootstrap" {
+		err = writeCommandsToInstallDiskTracksWithBootstrapWriter(diskImage, []int{trackNumInt}, 0, SEGMENT_SIZE, LINE_START_PAD_LENGTH)
+		if err != nil {
+			return err
+		}
+		if *stream.timingReport {
+			// the used bytes of the two pages of each sector, and the writer program
+			reportTransferTiming(fmt.Sprintf("track %d", trackNumInt), bootstrapTrackByteCount()+0x0180, baud, *line.framing)
+		}
+		return nil
+	}
+	if profile == "applesoft" {
+		err = writeCommandsToInstallDiskTracksWithApplesoftLoader(diskImage, []int{trackNumInt}, clientStrategy, 0, LINE_START_PAD_LENGTH)
+		if err != nil {
+			return err
+		}
+		if *stream.timingReport {
+			reportTransferTiming(fmt.Sprintf("track %d", trackNumInt), 0x1000+0x34, baud, *line.framing)
+		}
+		return nil
+	}
+	progressEventTrack = trackNumInt
+	emitProgressEvent("track_started", 0, 0)
+	var payloadByteCount int = 0x1000 + 0x34 // the track data and the client program
+	if *dataOnly {
+		err = writeCommandsToLoadDiskTrackToMemory(diskImage, trackNumInt, SEGMENT_SIZE, LINE_START_PAD_LENGTH)
+		if err != nil {
+			return err
+		}
+		payloadByteCount = 0x1000
+	} else if clientStrategy == "sector" {
+		err = writeCommandsToInstallDiskTrackBySector(diskImage, trackNumInt, SEGMENT_SIZE, LINE_START_PAD_LENGTH)
+		if err != nil {
+			return err
+		}
+	} else {
+		err = writeCommandsToLoadDiskTrackToMemory(diskImage, trackNumInt, SEGMENT_SIZE, LINE_START_PAD_LENGTH)
+		if err != nil {
+			return err
+		}
+		err = writeCommandsToLoadRWTSClientProgramToMemory(trackNumInt, RWTS_COMMAND_WRITE, clientStrategy, SEGMENT_SIZE, LINE_START_PAD_LENGTH)
+		if err != nil {
+			return err
+		}
+		if *readBack {
+			writeCommandsToLoadReadBackProgramToMemory(clientStrategy, SEGMENT_SIZE, LINE_START_PAD_LENGTH)
+			var readBackCommand string
+			generateExecuteCommand(&readBackCommand, clientAddress+0x0100)
+			executeClient(trackNumInt, RWTS_COMMAND_WRITE, readBackCommand, LINE_START_PAD_LENGTH)
+			err = retryTrackUntilVerified(diskImage, trackNumInt, clientStrategy, readBackCommand, SEGMENT_SIZE, LINE_START_PAD_LENGTH)
+			if err != nil {
+				return err
+			}
+		} else {
+			executeClient(trackNumInt, RWTS_COMMAND_WRITE, "", LINE_START_PAD_LENGTH)
+		}
+	}
+	emitProgressEvent("track_finished", commandOutput.lineCount, commandOutput.charCount)
+	if commandOutput.err != nil {
+		return commandOutput.err
+	}
+	if *stream.timingReport {
+		reportTransferTiming(fmt.Sprintf("track %d", trackNumInt), payloadByteCount, baud, *line.framing)
+	}
+	err = reportReadBackVerification(1)
+	if err != nil {
+		return err
+	}
+	return reportMonitorSimulation(diskImage, []int{trackNumInt}, clientStrategy, *dataOnly)
+}
+
+// runDaemon carries out the daemon subcommand, serving the transfer queue recorded in a queue file
+// through an HTTP API, and running its transfers one at a time until it is interrupted.
+func runDaemon(args []string) error {
+	var flags *flag.FlagSet = newSubcommandFlagSet("daemon", "[queueFilepath]")
+	var address *string = flags.String("listen", "localhost:6502", "serve the HTTP API at this host:port")
+	var tui *bool = flags.Bool("tui", false, "show the state of the transfers of the queue at the top of the terminal, while the messages scroll below it")
+	var keyFilepath *string = flags.String("key-file", "", "encrypt the queue file with the key in this file (made by encrypt -new-key), and decrypt the encrypted images of the transfers with it")
+	flags.Parse(args)
+	var queueFilepath string = "serial_install.queue"
+	if flags.NArg() >= 1 {
+		queueFilepath = flags.Arg(0)
+	}
+	var queue *transferQueue = &transferQueue{}
+	var err error = readTransferQueue(queue, queueFilepath, *keyFilepath)
+	if err != nil {
+		return err
+	}
+	listener, err := net.Listen("tcp", *address)
+	if err != nil {
+		return err
+	}
+	if *tui {
+		tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+		if err != nil {
+			return failure.CodedErrorf(failure.KIND_OPTION_CONFLICT, "the queue status needs a terminal: %w", err)
+		}
+		var rowCount, columnCount int
+		var size string
+		err = runStty(&size, tty, "size")
+		if err == nil {
+			_, err = fmt.Sscanf(size, "%d %d", &rowCount, &columnCount)
+		}
+		if err != nil || rowCount == 0 {
+			rowCount = 24
+		}
+		// clear the screen, and scroll the messages below the status: a title, a heading and the rows
+		fmt.Fprintf(tty, "\x1b[2J\x1b[%d;%dr\x1b[%d;1H", QUEUE_TUI_ROWS+4, rowCount, rowCount)
+		queue.mutex.Lock()
+		queue.changed = func() {
+			queue.showQueueStatus(tty, listener.Addr().String())
+		}
+		queue.changed()
+		queue.mutex.Unlock()
+	}
+	fmt.Fprintf(os.Stderr, "serving transfer queue %s at http://%s/transfers\n", queueFilepath, listener.Addr())
+	go queue.runTransfers()
+	return http.Serve(listener, queue)
+}
+
+// duplication is what is recorded of a run of duplicate in which some copies failed: the copy they
+// were of (counting from 1), and the serial devices and bridges whose copies were completed.
+type duplication struct {
+	Copy      int      `json:"copy"`
+	Completed []string `json:"completed"`
+}
+
+// runDuplicate carries out the duplicate subcommand, installing a disk image through several serial
+// ports (or TCP bridges) at once, with an install of its own run as another process for each, given
+// the same arguments, and doing so again for each copy asked for once the operator has put in new
+// disks.
+func runDuplicate(args []string) error {
+	var flags *flag.FlagSet = newSubcommandFlagSet("duplicate", "-ports ports|-tcp addresses [--] installArguments...")
+	var ports *string = flags.String("ports", "", "the serial devices to send to at once, separated by commas, with an apple ][ on each")
+	var tcpAddresses *string = flags.String("tcp", "", "the host:port of the TCP bridges to send to at once, separated by commas")
+	var copyCount *int = flags.Int("copies", 1, "the copies to make through each serial device or bridge, asking on stdin for new disks to be put in between copies")
+	var verify *bool = flags.Bool("verify", false, "verify each copy by reading its tracks back, sending the tracks which differ again (gives install -read-back -retries 2)")
+	var sessionPrefix *string = flags.String("session", "serial_install", "path and name prefix of the session files of the copies, followed by the name of the serial device or bridge, and of the file listing the copies completed when some failed")
+	var resume *bool = flags.Bool("resume", false, "continue the copies which failed, from their session files, leaving out those which were completed")
+	flags.Parse(args)
+	var lineFlags, lines []string
+	for _, port := range strings.Split(*ports, ",") {
+		if port != "" {
+			lineFlags = append(lineFlags, "-port")
+			lines = append(lines, port)
+		}
+	}
+	for _, address := range strings.Split(*tcpAddresses, ",") {
+		if address != "" {
+			lineFlags = append(lineFlags, "-tcp")
+			lines = append(lines, address)
+		}
+	}
+	if len(lines) == 0 {
+		return failure.CodedErrorf(failure.KIND_OPTION_CONFLICT, "duplicate needs -ports or -tcp, to send the copies to")
+	}
+	if *copyCount < 1 {
+		return failure.CodedErrorf(failure.KIND_OPTION_CONFLICT, "-copies must be at least 1, not %d", *copyCount)
+	}
+	var err error = checkInstallArgs(flags.Args(), DUPLICATE_RESERVED_FLAGS)
+	if err != nil {
+		return err
+	}
+	var installArgs []string = flags.Args()
+	if *verify {
+		installArgs = append([]string{"-read-back", "-retries", "2"}, installArgs...)
+	}
+	// the copies completed by the run which failed, to leave out when resuming
+	var duplicationFilepath string = *sessionPrefix + ".duplicate"
+	var recorded duplication = duplication{Copy: 1}
+	if *resume {
+		data, err := ioutil.ReadFile(duplicationFilepath)
+		if err == nil {
+			err = json.Unmarshal(data, &recorded)
+		}
+		if err != nil && !os.IsNotExist(err) {
+			return failure.CodedErrorf(failure.KIND_BAD_SESSION, "duplication file %s could not be read: %w", duplicationFilepath, err)
+		}
+	}
+	var operatorInput *bufio.Reader = bufio.NewReader(os.Stdin)
+	for copyNum := recorded.Copy; copyNum <= *copyCount; copyNum = copyNum + 1 {
+		if copyNum > recorded.Copy {
+			fmt.Fprintf(os.Stderr, "copy %d of %d made: put new disks in the drives and press return to make copy %d, or q and return to stop\n", copyNum-1, *copyCount, copyNum)
+			answer, err := operatorInput.ReadString('\n')
+			if strings.TrimSpace(answer) == "q" || (err != nil && answer == "") {
+				fmt.Fprintf(os.Stderr, "stopped after %d of %d copies\n", copyNum-1, *copyCount)
+				break
+			}
+			recorded.Completed = nil
+		}
+		if *copyCount > 1 {
+			fmt.Fprintf(os.Stderr, "making copy %d of %d\n", copyNum, *copyCount)
+		}
+		var failures []error = make([]error, len(lines))
+		makeDuplicateCopy(failures, lines, lineFlags, installArgs, *sessionPrefix, *resume && copyNum == recorded.Copy, recorded.Completed)
+		var failedCount int = 0
+		recorded.Copy = copyNum
+		recorded.Completed = []string{}
+		for i, line := range lines {
+			if failures[i] != nil {
+				fmt.Fprintf(os.Stderr, "%s: failed: %s\n", line, failures[i])
+				failedCount = failedCount + 1
+			} else {
+				fmt.Fprintf(os.Stderr, "%s: done\n", line)
+				recorded.Completed = append(recorded.Completed, line)
+			}
+		}
+		if failedCount > 0 {
+			// a struct of strings and numbers always marshals
+			var data []byte
+			data, _ = json.MarshalIndent(recorded, "", "  ")
+			err = ioutil.WriteFile(duplicationFilepath, append(data, '\n'), 0644)
+			if err != nil {
+				return err
+			}
+			return failure.CodedErrorf(failure.KIND_DUPLICATION_FAILED, "%d of %d copies failed", failedCount, len(lines))
+		}
+	}
+	err = os.Remove(duplicationFilepath)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// makeDuplicateCopy makes a copy through each of lines at once, a serial device given to install by
+// its flag in lineFlags along with installArgs, storing the failure of each into failures. The
+// session of each copy is resumed when resume is set, and the copies through the lines listed in
+// completed are left out.
+func makeDuplicateCopy(failures []error, lines []string, lineFlags []string, installArgs []string, sessionPrefix string, resume bool, completed []string) {
+	var copies sync.WaitGroup
+	for i, line := range lines {
+		var sessionFilepath string = fmt.Sprintf("%s.%s.session", sessionPrefix, strings.Replace(filepath.Base(line), ":", "_", -1))
+		var isCompleted bool = false
+		for _, completedLine := range completed {
+			isCompleted = isCompleted || completedLine == line
+		}
+		if resume && isCompleted {
+			fmt.Fprintf(os.Stderr, "%s: the copy was completed before\n", line)
+			continue
+		}
+		var process *installProcess = &installProcess{}
+		failures[i] = startInstallProcess(process, append([]string{lineFlags[i], line}, installArgs...), sessionFilepath, resume, line)
+		if failures[i] != nil {
+			continue
+		}
+		copies.Add(1)
+		go func(i int, line string) {
+			defer copies.Done()
+			failures[i] = process.wait(func(event progressEvent) {
+				if event.Event == "track_finished" {
+					fmt.Fprintf(os.Stderr, "%s: track %d sent\n", line, event.Track)
+				} else if event.Event == "track_failed" {
+					fmt.Fprintf(os.Stderr, "%s: track %d failed\n", line, event.Track)
+				}
+			})
+		}(i, line)
+	}
+	copies.Wait()
+}
+
+// runSession carries out the session subcommand, running the steps of a session manifest one after
+// another and recording those completed, so that a session which failed or was stopped continues with
+// -resume from the step it stopped at, that step resuming its install from its last completed track.
+func runSession(args []string) error {
+	var flags *flag.FlagSet = newSubcommandFlagSet("session", "manifestFilepath")
+	var resume *bool = flags.Bool("resume", false, "continue the session recorded in the state file beside the manifest, leaving out the steps it completed")
+	flags.Parse(args)
+	var err error = checkArgumentCount(flags, 1, 1)
+	if err != nil {
+		return err
+	}
+	var manifestFilepath string = flags.Arg(0)
+	var manifest sessionManifest
+	var digest string
+	err = readSessionManifest(&manifest, &digest, manifestFilepath)
+	if err != nil {
+		return err
+	}
+	var stateFilepath string = manifestFilepath + ".state"
+	var progress sessionProgress = sessionProgress{Manifest: digest}
+	if *resume {
+		var recorded sessionProgress
+		data, err := ioutil.ReadFile(stateFilepath)
+		if os.IsNotExist(err) {
+			return failure.CodedErrorf(failure.KIND_BAD_SESSION, "no session to resume in %s", stateFilepath)
+		}
+		if err == nil {
+			err = json.Unmarshal(data, &recorded)
+		}
+		if err != nil {
+			return failure.CodedErrorf(failure.KIND_BAD_SESSION, "state file %s could not be read: %w", stateFilepath, err)
+		}
+		if recorded.Manifest != digest {
+			return failure.CodedErrorf(failure.KIND_BAD_SESSION, "state file %s is of another manifest, or the manifest has changed since", stateFilepath)
+		}
+		progress.Completed = recorded.Completed
+		fmt.Fprintf(os.Stderr, "resuming session %s: %d of %d steps completed\n", manifestFilepath, progress.Completed, len(manifest.Steps))
+	}
+	var operatorInput *bufio.Reader = bufio.NewReader(os.Stdin)
+	for stepNum := progress.Completed + 1; stepNum <= len(manifest.Steps); stepNum = stepNum + 1 {
+		var step sessionStep = manifest.Steps[stepNum-1]
+		err = writeSessionProgress(progress, stateFilepath)
+		if err != nil {
+			return err
+		}
+		if step.Prompt != "" {
+			fmt.Fprintf(os.Stderr, "%s: press return to go on, or q and return to stop\n", step.Prompt)
+			answer, err := operatorInput.ReadString('\n')
+			if strings.TrimSpace(answer) == "q" || (err != nil && answer == "") {
+				fmt.Fprintf(os.Stderr, "stopped before step %d of %d, run the session again with -resume to continue\n", stepNum, len(manifest.Steps))
+				return nil
+			}
+		}
+		fmt.Fprintf(os.Stderr, "step %d of %d: %s\n", stepNum, len(manifest.Steps), step.Name)
+		var sessionFilepath string = fmt.Sprintf("%s.%d.session", manifestFilepath, stepNum)
+		err = runSessionStep(&manifest, stepNum, sessionFilepath, *resume && stepNum == progress.Completed+1)
+		if err != nil {
+			return failure.CodedErrorf(failure.KIND_SESSION_STEP_FAILED, "step %d of %d (%s) failed: %w", stepNum, len(manifest.Steps), step.Name, err)
+		}
+		progress.Completed = stepNum
+	}
+	fmt.Fprintf(os.Stderr, "session %s completed: %d steps\n", manifestFilepath, len(manifest.Steps))
+	err = os.Remove(stateFilepath)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// runDump carries out the dump subcommand, writing the commands which read the tracks given by args
+// from the disk and display them with the monitor.
+func runDump(args []string) (err error) {
+	var flags *flag.FlagSet = newSubcommandFlagSet("dump", "[trackNum]")
+	var stream *commandStreamFlags = addCommandStreamFlags(flags)
+	flags.Parse(args)
+	var line *serialLineFlags = stream.line
+	if *stream.clientStrategy == "sector" {
+		return failure.CodedErrorf(failure.KIND_OPTION_CONFLICT, "the sector client strategy is only available for installing")
+	}
+	if *stream.profile != "" && *stream.profile != "laser128" {
+		return failure.CodedErrorf(failure.KIND_OPTION_CONFLICT, "dump reads tracks with the RWTS client, and cannot be used with a profile other than laser128")
+	}
+	if *line.flowControl != "none" && !line.isConnected() {
+		return failure.CodedErrorf(failure.KIND_OPTION_CONFLICT, "-flow-control needs -port or -tcp, to set up the serial line")
+	}
+	var destination commandDestination
+	defer func() {
+		var closeErr error = destination.close()
+		if err == nil {
+			err = closeErr
+		}
+	}()
+	err = openCommandDestination(&destination, flags, stream)
+	if err != nil {
+		return err
+	}
+	var SEGMENT_SIZE int = destination.segmentSize
+	var LINE_START_PAD_LENGTH int = destination.lineStartPadLength
+	var trackNums []int
+	err = parseStreamTrackList(&trackNums, stream)
+	if err != nil {
+		return err
+	}
+	if len(trackNums) > 0 {
+		var settleCharCount int = destination.settleCharCount(*stream.trackWriteTime)
+		if !*stream.quiet {
+			startProgressReport(len(trackNums), settleCharCount, destination.baud, destination.bitsPerChar)
+		}
+		if isPerTrackOutputFilepath(*stream.outputFilepath) {
+			// each file dumps its track on its own, so no time is left for the tracks after it
+			for _, trackNum := range trackNums {
+				err = startTrackOutputFile(*stream.outputFilepath, trackNum)
+				if err != nil {
+					return err
+				}
+				err = writeCommandsToDumpDiskTracks([]int{trackNum}, *stream.clientStrategy, 0, SEGMENT_SIZE, LINE_START_PAD_LENGTH)
+				if err != nil {
+					return err
+				}
+			}
+		} else {
+			err = writeCommandsToDumpDiskTracks(trackNums, *stream.clientStrategy, settleCharCount, SEGMENT_SIZE, LINE_START_PAD_LENGTH)
+			if err != nil {
+				return err
+			}
+		}
+		if *stream.timingReport {
+			reportTransferTiming(fmt.Sprintf("%d track dumps", len(trackNums)), len(trackNums)*0x34, destination.baud, *line.framing)
+		}
+		return nil
+	}
+	var trackNumInt int
+	trackNumInt, err = strconv.Atoi(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	err = startTrackOutputFile(*stream.outputFilepath, trackNumInt)
+	if err != nil {
+		return err
+	}
+	progressEventTrack = trackNumInt
+	emitProgressEvent("track_started", 0, 0)
+	err = writeCommandsToDumpDiskTrack(trackNumInt, *stream.clientStrategy, SEGMENT_SIZE, LINE_START_PAD_LENGTH)
+	if err != nil {
+		return err
+	}
+	emitProgressEvent("track_finished", commandOutput.lineCount, commandOutput.charCount)
+	if commandOutput.err != nil {
+		return commandOutput.err
+	}
+	if *stream.timingReport {
+		reportTransferTiming(fmt.Sprintf("track %d", trackNumInt), 0x34, destination.baud, *line.framing)
+	}
+	return nil
+}
+
+// runCalibrate carries out the calibrate subcommand, measuring over the serial line the fastest
+// segment size and pad length with which the monitor loads memory intact, and recording them in the
+// tuning profile file given by args.
+func runCalibrate(args []string) (err error) {
+	var flags *flag.FlagSet = newSubcommandFlagSet("calibrate", "tuningFilepath")
+	var line *serialLineFlags = addSerialLineFlags(flags)
+	var lineProcessingTime *time.Duration = addMonitorLineTimeFlag(flags)
+	var highBit *bool = addHighBitFlag(flags)
+	var bufferAddressFlag *int = flags.Int("buffer-address", 0x2000, "the memory address the probes are loaded at, at the start of a page (such as 0x4000)")
+	flags.Parse(args)
+	if !line.isConnected() {
+		return failure.CodedErrorf(failure.KIND_OPTION_CONFLICT, "calibrate needs -port or -tcp, to receive the memory dumps")
+	}
+	if *line.flowControl != "none" {
+		return failure.CodedErrorf(failure.KIND_OPTION_CONFLICT, "calibrate probes the padding the monitor loses between lines, and cannot be used with -flow-control")
+	}
+	if *bufferAddressFlag%0x0100 != 0 || *bufferAddressFlag < 0x0800 || *bufferAddressFlag+0x2000 > 0x9600 {
+		return failure.CodedErrorf(failure.KIND_ILLEGAL_BUFFER_ADDRESS, "illegal buffer address encountered: %04X", *bufferAddressFlag)
+	}
+	bufferAddress = *bufferAddressFlag
+	var port io.ReadWriteCloser
+	var bitsPerChar int
+	err = openSerialLine(&port, &bitsPerChar, line)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		var closeErr error = closeSerialPort(port, *line.baud, bitsPerChar)
+		if err == nil {
+			err = closeErr
+		}
+	}()
+	commandOutput.output = port
+	commandOutput.dataBits = int((*line.framing)[0] - '0')
+	commandOutput.highBit = *highBit
+	if *highBit && commandOutput.dataBits == 7 {
+		return failure.CodedErrorf(failure.KIND_OPTION_CONFLICT, "-high-bit needs a framing with 8 data bits")
+	}
+	var SEGMENT_SIZE, LINE_START_PAD_LENGTH int
+	derivePacing(&SEGMENT_SIZE, &LINE_START_PAD_LENGTH, *line.baud, bitsPerChar, *lineProcessingTime)
+	var input chan byte = make(chan byte, 0x0400)
+	go protocol.ReadYmodemLinkInput(input, port)
+	// twice the derived padding covers a monitor twice as slow as assumed
+	return calibratePacing(input, flags.Arg(0), *line.baud, *line.framing, 2*LINE_START_PAD_LENGTH)
+}
+
+// runExplainPacing carries out the explain-pacing subcommand, showing how the segment size and pad
+// length are derived from the serial line.
+func runExplainPacing(args []string) error {
+	var flags *flag.FlagSet = newSubcommandFlagSet("explain-pacing", "")
+	var line serialLineFlags
+	addLineFormatFlags(flags, &line)
+	var lineProcessingTime *time.Duration = addMonitorLineTimeFlag(flags)
+	flags.Parse(args)
+	return explainPacing(*line.baud, *line.framing, *lineProcessingTime)
+}
+
+// runCheckClient carries out the check-client subcommand, running the client programs on a 6502
+// emulator with a mock RWTS and exiting with status 1 on any problem.
+func runCheckClient(args []string) error {
+	var flags *flag.FlagSet = newSubcommandFlagSet("check-client", "")
+	flags.Parse(args)
+	if !checkClientPrograms(os.Stdout) {
+		os.Exit(1)
+	}
+	return nil
+}
+
+// runUndump carries out the undump subcommand, writing the tracks dumped by dump, as captured from
+// the serial line, into a disk image file given by args.
+func runUndump(args []string) error {
+	var flags *flag.FlagSet = newSubcommandFlagSet("undump", "captureFilepath diskImageFilepath")
+	addImageFlags(flags)
+	var trackList *string = flags.String("tracks", "0-34", "the tracks and track ranges (such as 0-4,17,20-34) dumped, in the order they were dumped")
+	var bufferAddressFlag *int = flags.Int("buffer-address", 0x2000, "the memory address of the data buffer given to dump")
+	flags.Parse(args)
+	if *bufferAddressFlag%0x0100 != 0 || *bufferAddressFlag < 0x0800 || *bufferAddressFlag+0x2000 > 0x9600 {
+		return failure.CodedErrorf(failure.KIND_ILLEGAL_BUFFER_ADDRESS, "illegal buffer address encountered: %04X", *bufferAddressFlag)
+	}
+	bufferAddress = *bufferAddressFlag
+	var trackNums []int
+	var err error = parseTrackList(&trackNums, *trackList)
+	if err != nil {
+		return err
+	}
+	var capture []byte
+	capture, err = ioutil.ReadFile(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	var diskImageFilepath string = flags.Arg(1)
+	var diskImage []byte
+	var outputOrder diskimage.SectorOrder = diskimage.SECTOR_ORDER_PRODOS
+	_, err = os.Stat(diskImageFilepath)
+	if err == nil {
+		// tracks dumped again replace those of the existing image, which keeps its sector order
+		err = readDiskImageFromFile(&diskImage, diskImageFilepath)
+		if err != nil {
+			return err
+		}
+		if len(diskImage) != diskimage.FLOPPY_IMAGE_SIZE {
+			return failure.CodedErrorf(failure.KIND_NOT_FLOPPY, "%s is not a 140K floppy image", diskImageFilepath)
+		}
+		if diskImageReadOrder == "" {
+			return fmt.Errorf("%s cannot be changed in place, convert it to a .po image first", diskImageFilepath)
+		}
+		outputOrder = diskImageReadOrder
+	} else {
+		diskImage = make([]byte, diskimage.FLOPPY_IMAGE_SIZE)
+		if ORDER_OF_EXTENSION[strings.ToLower(filepath.Ext(diskImageFilepath))] == "dos" {
+			outputOrder = diskimage.SECTOR_ORDER_DOS
+		}
+	}
+	err = diskimage.ConvertDiskImageFromProdosOrderToDos33Order(diskImage)
+	if err != nil {
+		return err
+	}
+	err = readTracksFromMonitorDump(diskImage, trackNums, capture)
+	if err != nil {
+		return err
+	}
+	err = diskimage.ConvertDiskImageFromDos33OrderToProdosOrder(diskImage)
+	if err != nil {
+		return err
+	}
+	err = diskimage.ReorderDiskImageSectors(diskImage, diskimage.SECTOR_ORDER_PRODOS, outputOrder)
+	if err != nil {
+		return err
+	}
+	err = writeDiskImageWithJournal(diskImage, diskImageFilepath, "undump")
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "wrote %d tracks into file %s, in %s sector order\n", len(trackNums), diskImageFilepath, outputOrder)
+	return nil
+}
+
+// runCheckReadBack carries out the check subcommand, comparing the sector checksums printed by install
+// -read-back, as captured from the serial line, against the tracks of a disk image, and exiting with
+// status 1 when any differ.
+func runCheckReadBack(args []string) error {
+	var flags *flag.FlagSet = newSubcommandFlagSet("check", "captureFilepath diskImageFilepath")
+	addImageFlags(flags)
+	var allTracks *bool = flags.Bool("all-tracks", false, "report each of the 35 tracks which was not read back")
+	var trackList *string = flags.String("tracks", "", "report each of the listed tracks and track ranges (such as 0-4,17,20-34) which was not read back")
+	flags.Parse(args)
+	var trackNums []int
+	var err error
+	if *allTracks {
+		err = parseTrackList(&trackNums, "0-34")
+	} else if *trackList != "" {
+		err = parseTrackList(&trackNums, *trackList)
+	}
+	if err != nil {
+		return err
+	}
+	var capture []byte
+	capture, err = ioutil.ReadFile(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	var diskImage []byte
+	err = readDiskImageFromFile(&diskImage, flags.Arg(1))
+	if err != nil {
+		return err
+	}
+	if len(diskImage) != diskimage.FLOPPY_IMAGE_SIZE {
+		return failure.CodedErrorf(failure.KIND_NOT_FLOPPY, "%s is not a 140K floppy image", flags.Arg(1))
+	}
+	err = diskimage.ConvertDiskImageFromProdosOrderToDos33Order(diskImage)
+	if err != nil {
+		return err
+	}
+	var failedTrackCount int
+	err = checkReadBackCapture(&failedTrackCount, diskImage, trackNums, capture)
+	if err != nil {
+		return err
+	}
+	if failedTrackCount > 0 {
+		os.Exit(1)
+	}
+	return nil
+}
+
+// runConvert carries out the convert subcommand, writing a 140K disk image to a new image file in
+// another sector order or format.
+func runConvert(args []string) error {
+	var flags *flag.FlagSet = newSubcommandFlagSet("convert", "diskImageFilepath outputImageFilepath")
+	addImageFlags(flags)
+	var convertOrder *string = flags.String("order", "", "the sector order written: prodos, dos, pascal, cpm or physical, nibble for a nibble image, 13-sector for a 13-sector image, or empty for the order named by the extension (.po, .do, .dsk, .nib or .d13)")
+	flags.Parse(args)
+	return convertDiskImageFile(flags.Arg(0), flags.Arg(1), *convertOrder)
+}
+
+// runEncrypt carries out the encrypt subcommand, writing an image file (or any file) encrypted with
+// the key of a key file, which -new-key makes first, to be kept where others may read it.
+func runEncrypt(args []string) error {
+	var flags *flag.FlagSet = newSubcommandFlagSet("encrypt", "imageFilepath [encryptedFilepath]")
+	flags.StringVar(&diskImageKeyFilepath, "key-file", "", "the key file holding the key to encrypt with")
+	var newKey *bool = flags.Bool("new-key", false, "first make the key file, with a random key")
+	flags.Parse(args)
+	var err error = checkArgumentCount(flags, 1, 2)
+	if err != nil {
+		return err
+	}
+	var encryptedFilepath string = flags.Arg(0) + ENCRYPTED_FILE_EXTENSION
+	if flags.NArg() == 2 {
+		encryptedFilepath = flags.Arg(1)
+	}
+	if *newKey {
+		if diskImageKeyFilepath == "" {
+			return failure.CodedErrorf(failure.KIND_BAD_KEY, "-new-key needs the key file to make (-key-file)")
+		}
+		err = writeNewEncryptionKey(diskImageKeyFilepath)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "made key file %s: keep a copy of it apart from the images, which cannot be decrypted without it\n", diskImageKeyFilepath)
+	}
+	var key []byte
+	err = readEncryptionKey(&key, diskImageKeyFilepath)
+	if err != nil {
+		return err
+	}
+	data, err := ioutil.ReadFile(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	if isEncryptedData(data) {
+		return failure.CodedErrorf(failure.KIND_ENCRYPTION, "%s is already encrypted", flags.Arg(0))
+	}
+	var encrypted []byte
+	err = encryptData(&encrypted, data, key)
+	if err != nil {
+		return err
+	}
+	err = ioutil.WriteFile(encryptedFilepath, encrypted, 0644)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "wrote %d bytes of file %s encrypted to file %s\n", len(data), flags.Arg(0), encryptedFilepath)
+	return nil
+}
+
+// runDecrypt carries out the decrypt subcommand, writing the content of an encrypted file to a file
+// of its own.
+func runDecrypt(args []string) error {
+	var flags *flag.FlagSet = newSubcommandFlagSet("decrypt", "encryptedFilepath outputFilepath")
+	flags.StringVar(&diskImageKeyFilepath, "key-file", "", "the key file holding the key the file was encrypted with")
+	flags.Parse(args)
+	var err error = checkArgumentCount(flags, 2, 2)
+	if err != nil {
+		return err
+	}
+	data, err := ioutil.ReadFile(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	if !isEncryptedData(data) {
+		return failure.CodedErrorf(failure.KIND_ENCRYPTION, "%s is not encrypted", flags.Arg(0))
+	}
+	err = decryptDataWithKeyFile(&data, flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	err = ioutil.WriteFile(flags.Arg(1), data, 0644)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "wrote %d decrypted bytes to file %s\n", len(data), flags.Arg(1))
+	return nil
+}
+
+// runSplit carries out the split subcommand, cutting a large ProDOS block image into 140K floppy image
+// chunks with a manifest.
+func runSplit(args []string) error {
+	var flags *flag.FlagSet = newSubcommandFlagSet("split", "largeImageFilepath chunkFilepathPrefix")
+	addImageFlags(flags)
+	var partitionNum *int = addPartitionFlag(flags)
+	flags.Parse(args)
+	if *partitionNum == 0 {
+		var data io.Reader
+		var byteCount int
+		var streamFile *os.File
+		var err error
+		streamFile, err = openDiskImageStream(&data, &byteCount, flags.Arg(0))
+		if err != nil {
+			return err
+		}
+		if streamFile != nil {
+			defer streamFile.Close()
+			return splitDiskImageStreamIntoFloppyImages(data, flags.Arg(1))
+		}
+	}
+	var diskImage []byte
+	var err error = readDiskImageFromFile(&diskImage, flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	if diskImageIs13Sector {
+		return failure.CodedErrorf(failure.KIND_13_SECTOR, "%s is a 13-sector image, which holds no ProDOS blocks to split", flags.Arg(0))
+	}
+	if *partitionNum > 0 {
+		err = filesystem.SelectPartitionOfDiskImage(&diskImage, *partitionNum)
+		if err != nil {
+			return err
+		}
+	}
+	return splitDiskImageIntoFloppyImages(diskImage, flags.Arg(1))
+}
+
+// runJoin carries out the join subcommand, reassembling the floppy image chunks listed in a manifest
+// into a large image.
+func runJoin(args []string) error {
+	var flags *flag.FlagSet = newSubcommandFlagSet("join", "manifestFilepath largeImageFilepath")
+	addImageFlags(flags)
+	flags.Parse(args)
+	var diskImage []byte
+	var err error = joinFloppyImagesIntoDiskImage(&diskImage, flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	err = writeDiskImageWithJournal(diskImage, flags.Arg(1), "join")
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "wrote %d bytes to file %s\n", len(diskImage), flags.Arg(1))
+	return nil
+}
+
+// runDosMaster carries out the dos-master subcommand, copying the DOS image on tracks 0 through 2 of a
+// DOS 3.3 disk image onto a DOS 3.3 data disk image.
+func runDosMaster(args []string) error {
+	var flags *flag.FlagSet = newSubcommandFlagSet("dos-master", "dosImageFilepath dataImageFilepath outputImageFilepath")
+	addImageFlags(flags)
+	flags.Parse(args)
+	var dosImage []byte
+	var err error = readDiskImageFromFile(&dosImage, flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	var dataImage []byte
+	err = readDiskImageFromFile(&dataImage, flags.Arg(1))
+	if err != nil {
+		return err
+	}
+	if len(dosImage) != diskimage.FLOPPY_IMAGE_SIZE || len(dataImage) != diskimage.FLOPPY_IMAGE_SIZE {
+		return failure.CodedErrorf(failure.KIND_DOS33_IMAGE_SIZE, "DOS 3.3 disk images must hold %d bytes", diskimage.FLOPPY_IMAGE_SIZE)
+	}
+	err = diskimage.ConvertDiskImageFromProdosOrderToDos33Order(dosImage)
+	if err != nil {
+		return err
+	}
+	err = diskimage.ConvertDiskImageFromProdosOrderToDos33Order(dataImage)
+	if err != nil {
+		return err
+	}
+	err = filesystem.InstallDos33ImageOnDataDisk(dataImage, dosImage)
+	if err != nil {
+		return err
+	}
+	var bootPage byte = diskimage.Dos33SectorOfImage(dosImage, 0x00, 0x00)[0xFE]
+	if bootPage == filesystem.DOS33_MASTER_BOOT_PAGE {
+		fmt.Fprintf(os.Stderr, "copied the DOS image of a master disk, relocating DOS to the memory size when booted\n")
+	} else {
+		fmt.Fprintf(os.Stderr, "copied the DOS image of a slave disk, loading DOS at page 0x%02X as on the machine which initialized it\n", bootPage)
+	}
+	err = diskimage.ConvertDiskImageFromDos33OrderToProdosOrder(dataImage)
+	if err != nil {
+		return err
+	}
+	err = writeDiskImageWithJournal(dataImage, flags.Arg(2), "dos-master")
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "wrote DOS tracks 0 through 2 and %d bytes to file %s\n", len(dataImage), flags.Arg(2))
+	return nil
+}
+
+// runBootify carries out the bootify subcommand, copying the boot blocks, PRODOS and BASIC.SYSTEM of a
+// bootable ProDOS disk image onto a ProDOS data disk image.
+func runBootify(args []string) error {
+	var flags *flag.FlagSet = newSubcommandFlagSet("bootify", "systemImageFilepath dataImageFilepath outputImageFilepath")
+	addImageFlags(flags)
+	var force *bool = flags.Bool("force", false, "bootify the data image even when its 2MG header marks it locked")
+	flags.Parse(args)
+	var systemImage []byte
+	var err error = readDiskImageFromFile(&systemImage, flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	var dataImage []byte
+	err = readDiskImageFromFile(&dataImage, flags.Arg(1))
+	if err != nil {
+		return err
+	}
+	err = refuseLockedDiskImage(flags.Arg(1), *force)
+	if err != nil {
+		return err
+	}
+	err = filesystem.BootifyProdosImage(dataImage, systemImage)
+	if err != nil {
+		return err
+	}
+	err = writeDiskImageWithJournal(dataImage, flags.Arg(2), "bootify")
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "wrote %d bytes to file %s\n", len(dataImage), flags.Arg(2))
+	return nil
+}
+
+// runAdd carries out the add subcommand, writing a host file into a DOS 3.3 or ProDOS disk image in
+// place.
+func runAdd(args []string) error {
+	var flags *flag.FlagSet = newSubcommandFlagSet("add", "hostFilepath diskImageFilepath[:fileName]")
+	addImageFlags(flags)
+	var fileType *string = flags.String("file-type", "BIN", "the file type: a ProDOS name such as BIN, TXT, BAS or SYS, a DOS 3.3 letter such as B, T or A, or $ and a ProDOS type in hexadecimal")
+	var loadAddress *int = flags.Int("load-address", -1, "the load address of a binary file (the aux type of a ProDOS file), by default 0x2000, or 0x0801 for a ProDOS BAS file")
+	var date *string = flags.String("date", "", "the creation and modification date of a ProDOS file, as 2006-01-02 or 2006-01-02 15:04, by default the modification time of the host file")
+	var names *filesystem.FileNameFlags = filesystem.AddFileNameFlags(flags, false)
+	flags.Parse(args)
+	var err error = names.Check()
+	if err != nil {
+		return err
+	}
+	var hostFileData []byte
+	hostFileData, err = ioutil.ReadFile(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	var dateTime time.Time
+	err = parseFileDate(&dateTime, *date, flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	var diskImageFilepath, filePath string
+	splitImageFileArgument(&diskImageFilepath, &filePath, flags.Arg(1))
+	var diskImage []byte
+	err = readDiskImageFromFile(&diskImage, diskImageFilepath)
+	if err != nil {
+		return err
+	}
+	if filePath == "" {
+		var volumeName string
+		var totalBlocks int
+		var isProdos bool = filesystem.ReadProdosVolumeHeader(&volumeName, &totalBlocks, diskImage, 0)
+		err = filesystem.TranslateHostFileName(&filePath, filepath.Base(flags.Arg(0)), isProdos, names)
+		if err != nil {
+			return err
+		}
+	}
+	err = refuseLockedDiskImage(diskImageFilepath, false)
+	if err != nil {
+		return err
+	}
+	if diskImageReadOrder == "" {
+		return fmt.Errorf("%s cannot be changed in place, convert it to a .po image first", diskImageFilepath)
+	}
+	err = filesystem.AddHostFileToDiskImage(diskImage, filePath, hostFileData, *fileType, *loadAddress, dateTime)
+	if err != nil {
+		return err
+	}
+	if len(diskImage) == diskimage.FLOPPY_IMAGE_SIZE {
+		// written back in the sector order it was read in
+		err = diskimage.ReorderDiskImageSectors(diskImage, diskimage.SECTOR_ORDER_PRODOS, diskImageReadOrder)
+		if err != nil {
+			return err
+		}
+	}
+	err = writeDiskImageWithJournal(diskImage, diskImageFilepath, "add")
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "wrote %d bytes to file %s\n", len(diskImage), diskImageFilepath)
+	return nil
+}
+
+// parseFileDate stores into dateTime the local time given by date, as 2006-01-02 or 2006-01-02 15:04,
+// or when date is empty, the modification time of the host file hostFilepath. It returns an error
+// for a date in another form, or one ProDOS cannot store.
+func parseFileDate(dateTime *time.Time, date string, hostFilepath string) error {
+	if date == "" {
+		info, err := os.Stat(hostFilepath)
+		if err != nil {
+			return err
+		}
+		*dateTime = info.ModTime()
+		return nil
+	}
+	var err error
+	for _, layout := range []string{"2006-01-02 15:04", "2006-01-02"} {
+		*dateTime, err = time.ParseInLocation(layout, date, time.Local)
+		if err == nil {
+			break
+		}
+	}
+	if err != nil {
+		return failure.CodedErrorf(failure.KIND_BAD_DATE, "illegal date encountered: %s", date)
+	}
+	if dateTime.Year() < filesystem.PRODOS_FIRST_YEAR || dateTime.Year() > filesystem.PRODOS_LAST_YEAR {
+		return failure.CodedErrorf(failure.KIND_BAD_DATE, "ProDOS stores dates from %d to %d, not %s", filesystem.PRODOS_FIRST_YEAR, filesystem.PRODOS_LAST_YEAR, date)
+	}
+	return nil
+}
+
+// runExtract carries out the extract subcommand, writing a file held in a disk image, the files
+// matching a pattern, or all of its files, to a host directory.
+func runExtract(args []string) error {
+	var flags *flag.FlagSet = newSubcommandFlagSet("extract", "diskImageFilepath[:fileName|:pattern] [hostDirectory]")
+	addImageFlags(flags)
+	var partitionNum *int = addPartitionFlag(flags)
+	var all *bool = flags.Bool("all", false, "extract every file of the image, as when no file name is given")
+	var names *filesystem.FileNameFlags = filesystem.AddFileNameFlags(flags, true)
+	flags.Parse(args)
+	var err error = names.Check()
+	if err != nil {
+		return err
+	}
+	var diskImageFilepath, filePath string
+	splitImageFileArgument(&diskImageFilepath, &filePath, flags.Arg(0))
+	if *all && filePath != "" {
+		return failure.CodedErrorf(failure.KIND_OPTION_CONFLICT, "-all extracts every file, and cannot be given with a file name or pattern")
+	}
+	var hostDirectory string = "."
+	if flags.NArg() >= 2 {
+		hostDirectory = flags.Arg(1)
+	}
+	var diskImage []byte
+	err = readDiskImagePartition(&diskImage, diskImageFilepath, *partitionNum)
+	if err != nil {
+		return err
+	}
+	return filesystem.ExtractDiskImageFiles(diskImage, filePath, hostDirectory, names)
+}
+
+// runCompare carries out the cmp subcommand, comparing a file held in a disk image against a host file
+// and exiting with status 1 when they differ.
+func runCompare(args []string) error {
+	var flags *flag.FlagSet = newSubcommandFlagSet("cmp", "diskImageFilepath:fileName hostFilepath")
+	addImageFlags(flags)
+	var partitionNum *int = addPartitionFlag(flags)
+	flags.Parse(args)
+	var diskImageFilepath, fileName string
+	splitImageFileArgument(&diskImageFilepath, &fileName, flags.Arg(0))
+	if !strings.Contains(flags.Arg(0), ":") {
+		return failure.CodedErrorf(failure.KIND_MISSING_FILE_NAME, "expected diskImageFilepath:fileName, got %s", flags.Arg(0))
+	}
+	var diskImage []byte
+	var err error = readDiskImagePartition(&diskImage, diskImageFilepath, *partitionNum)
+	if err != nil {
+		return err
+	}
+	var imageFileData []byte
+	err = filesystem.ReadFileFromDiskImage(&imageFileData, diskImage, fileName)
+	if err != nil {
+		return err
+	}
+	var hostFileData []byte
+	hostFileData, err = ioutil.ReadFile(flags.Arg(1))
+	if err != nil {
+		return err
+	}
+	if !filesystem.CompareFileData(imageFileData, hostFileData, flags.Arg(0), flags.Arg(1)) {
+		os.Exit(1)
+	}
+	return nil
+}
+
+// openTransferLink opens the serial line given by line, if any, and sets up link to send its output
+// there, or otherwise to stdout, and to read its input from the same place. It returns a function
+// closing the serial line.
+func openTransferLink(link *protocol.YmodemLink, line *serialLineFlags) (func() error, error) {
+	var port io.ReadWriteCloser
+	var bitsPerChar int
+	var err error = openSerialLine(&port, &bitsPerChar, line)
+	if err != nil {
+		return nil, err
+	}
+	link.Input = make(chan byte, 0x0400)
+	if port == nil {
+		link.Output = os.Stdout
+		go protocol.ReadYmodemLinkInput(link.Input, os.Stdin)
+		return func() error { return nil }, nil
+	}
+	link.Output = port
+	go protocol.ReadYmodemLinkInput(link.Input, port)
+	return func() error { return closeSerialPort(port, *line.baud, bitsPerChar) }, nil
+}
+
+// runYmodem carries out the ymodem subcommand, sending files with the YMODEM batch protocol to a
+// receiver on stdin and stdout, or on the serial line.
+func runYmodem(args []string) error {
+	return runBatchTransfer("ymodem", "YMODEM", protocol.SendYmodemBatch, args)
+}
+
+// runZmodem carries out the zmodem subcommand, sending files with the ZMODEM protocol to a receiver
+// on stdin and stdout, or on the serial line.
+func runZmodem(args []string) error {
+	return runBatchTransfer("zmodem", "ZMODEM", protocol.SendZmodemBatch, args)
+}
+
+// runAdtpro carries out the adtpro subcommand, acting as the host of the ADTPro client running on the
+// apple ][, on the serial line (or stdin and stdout), serving the image files of a host directory.
+func runAdtpro(args []string) (err error) {
+	var flags *flag.FlagSet = newSubcommandFlagSet("adtpro", "[directory]")
+	addImageFlags(flags)
+	var line *serialLineFlags = addSerialLineFlags(flags)
+	flags.Parse(args)
+	err = checkArgumentCount(flags, 0, 1)
+	if err != nil {
+		return err
+	}
+	var host *protocol.AdtproHost = &protocol.AdtproHost{Directory: ".", ReadImage: readAdtproHostImage, WriteImage: writeAdtproHostImage}
+	if flags.NArg() == 1 {
+		host.Directory = flags.Arg(0)
+	}
+	var link *protocol.YmodemLink = &protocol.YmodemLink{Protocol: "ADTPro"}
+	var closeLink func() error
+	closeLink, err = openTransferLink(link, line)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		var closeErr error = closeLink()
+		if err == nil {
+			err = closeErr
+		}
+	}()
+	host.Input = link.Input
+	host.Output = link.Output
+	fmt.Fprintf(os.Stderr, "ADTPro: serving directory %s, waiting for the client\n", host.Directory)
+	return protocol.ServeAdtproClient(host)
+}
+
+// runApple3Client carries out the apple3-client subcommand, making the boot disk of the Apple /// client
+// from a copy of a bootable Apple /// disk image.
+func runApple3Client(args []string) error {
+	var flags *flag.FlagSet = newSubcommandFlagSet("apple3-client", "sosImageFilepath outputImageFilepath")
+	addImageFlags(flags)
+	flags.Parse(args)
+	var err error = checkArgumentCount(flags, 2, 2)
+	if err != nil {
+		return err
+	}
+	var sosImage []byte
+	err = readDiskImageFromFile(&sosImage, flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	if len(sosImage) != diskimage.FLOPPY_IMAGE_SIZE || diskImageIs13Sector {
+		return failure.CodedErrorf(failure.KIND_NOT_FLOPPY, "%s is not a 140K floppy image, which the built-in drive of the Apple /// boots", flags.Arg(0))
+	}
+	err = protocol.MakeSosClientImage(sosImage)
+	if err != nil {
+		return err
+	}
+	err = writeDiskImageWithJournal(sosImage, flags.Arg(1), "apple3-client")
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "wrote the client as SOS.INTERP and %d bytes to file %s\n", len(sosImage), flags.Arg(1))
+	return nil
+}
+
+// runApple3 carries out the apple3 subcommand, writing a 140K disk image to the built-in drive of an
+// Apple /// booted from the client disk, on the serial line (or stdin and stdout).
+func runApple3(args []string) (err error) {
+	var flags *flag.FlagSet = newSubcommandFlagSet("apple3", "diskImageFilepath")
+	addImageFlags(flags)
+	var line *serialLineFlags = addSerialLineFlags(flags)
+	var firstBlock *int = flags.Int("first-block", 0, "the block to start writing at, for going on after a failed block")
+	flags.Parse(args)
+	err = checkArgumentCount(flags, 1, 1)
+	if err != nil {
+		return err
+	}
+	var diskImage []byte
+	err = readDiskImageFromFile(&diskImage, flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	if len(diskImage) != diskimage.FLOPPY_IMAGE_SIZE || diskImageIs13Sector {
+		return failure.CodedErrorf(failure.KIND_NOT_FLOPPY, "%s is not a 140K floppy image, which the built-in drive of the Apple /// holds", flags.Arg(0))
+	}
+	var blockCount int = len(diskImage) / diskimage.PRODOS_BLOCK_SIZE
+	if *firstBlock < 0 || *firstBlock >= blockCount {
+		return failure.CodedErrorf(failure.KIND_ILLEGAL_FIRST_BLOCK, "illegal first block encountered: %d", *firstBlock)
+	}
+	var link *protocol.YmodemLink = &protocol.YmodemLink{Protocol: "Apple ///"}
+	var closeLink func() error
+	closeLink, err = openTransferLink(link, line)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		var closeErr error = closeLink()
+		if err == nil {
+			err = closeErr
+		}
+	}()
+	fmt.Fprintf(os.Stderr, "Apple ///: waiting for the client, boot the client disk and give it the disk to write\n")
+	return protocol.WriteApple3Disk(link, diskImage, *firstBlock)
+}
+
+// readAdtproHostImage reads the image file imageFilepath for the ADTPro client into diskImage, in
+// ProDOS block order as readDiskImageFromFile gives it, refusing 13-sector images.
+func readAdtproHostImage(diskImage *[]byte, imageFilepath string) error {
+	var err error = readDiskImageFromFile(diskImage, imageFilepath)
+	if err == nil && diskImageIs13Sector {
+		err = failure.CodedErrorf(failure.KIND_13_SECTOR, "%s is a 13-sector image, which holds no ProDOS blocks", imageFilepath)
+	}
+	return err
+}
+
+// writeAdtproHostImage writes the ProDOS ordered diskImage received from the ADTPro client to the image
+// file imageFilepath, in the sector order named by the extension of the file.
+func writeAdtproHostImage(diskImage []byte, imageFilepath string) error {
+	if len(diskImage) == diskimage.FLOPPY_IMAGE_SIZE && ORDER_OF_EXTENSION[strings.ToLower(filepath.Ext(imageFilepath))] == "dos" {
+		var err error = diskimage.ConvertDiskImageFromProdosOrderToDos33Order(diskImage)
+		if err != nil {
+			return err
+		}
+	}
+	return writeDiskImageWithJournal(diskImage, imageFilepath, "adtpro")
+}
+
+// runBatchTransfer carries out the subcommand name, sending the files given in args with send, which
+// speaks protocolName, to a receiver on stdin and stdout, or on the serial line.
+func runBatchTransfer(name string, protocolName string, send func(link *protocol.YmodemLink, files []protocol.YmodemFile) error, args []string) (err error) {
+	var flags *flag.FlagSet = newSubcommandFlagSet(name, "filePath...")
+	addImageFlags(flags)
+	var line *serialLineFlags = addSerialLineFlags(flags)
+	flags.Parse(args)
+	var files []protocol.YmodemFile = make([]protocol.YmodemFile, flags.NArg())
+	for i, filePath := range flags.Args() {
+		err = readYmodemFile(&files[i], filePath)
+		if err != nil {
+			return err
+		}
+	}
+	var link *protocol.YmodemLink = &protocol.YmodemLink{Protocol: protocolName}
+	var closeLink func() error
+	closeLink, err = openTransferLink(link, line)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		var closeErr error = closeLink()
+		if err == nil {
+			err = closeErr
+		}
+	}()
+	return send(link, files)
+}
+
+// runXmodem carries out the xmodem subcommand, sending a file, or tracks of a disk image, with the
+// XMODEM protocol to a receiver on stdin and stdout, or on the serial line.
+func runXmodem(args []string) (err error) {
+	var flags *flag.FlagSet = newSubcommandFlagSet("xmodem", "filePath")
+	addImageFlags(flags)
+	var line *serialLineFlags = addSerialLineFlags(flags)
+	var trackList *string = flags.String("tracks", "", "send only the listed tracks and track ranges (such as 0-4,17,20-34) of the disk image filePath, in DOS 3.3 sector order as dump gives them")
+	flags.Parse(args)
+	var file protocol.YmodemFile
+	if *trackList != "" {
+		// only the listed tracks of the image, in DOS3.3 sector order as dump gives them
+		var diskImage []byte
+		err = readDiskImageFromFile(&diskImage, flags.Arg(0))
+		if err != nil {
+			return err
+		}
+		err = diskimage.ConvertDiskImageFromProdosOrderToDos33Order(diskImage)
+		if err != nil {
+			return err
+		}
+		var trackNums []int
+		err = parseTrackList(&trackNums, *trackList)
+		if err != nil {
+			return err
+		}
+		for _, trackNum := range trackNums {
+			var trackStartPos int = diskimage.DiskImageStartPosOfTrackSector(trackNum, 0)
+			file.Data = append(file.Data, diskImage[trackStartPos:trackStartPos+0x1000]...)
+		}
+		file.Name = filepath.Base(flags.Arg(0))
+	} else {
+		err = readYmodemFile(&file, flags.Arg(0))
+		if err != nil {
+			return err
+		}
+	}
+	var link *protocol.YmodemLink = &protocol.YmodemLink{Protocol: "XMODEM"}
+	var closeLink func() error
+	closeLink, err = openTransferLink(link, line)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		var closeErr error = closeLink()
+		if err == nil {
+			err = closeErr
+		}
+	}()
+	return protocol.SendXmodemFile(link, file)
+}
+
+// runCatalog carries out the catalog subcommand, listing the files of a disk image.
+func runCatalog(args []string) error {
+	var flags *flag.FlagSet = newSubcommandFlagSet("catalog", "diskImageFilepath")
+	addImageFlags(flags)
+	var partitionNum *int = addPartitionFlag(flags)
+	flags.Parse(args)
+	var err error = checkArgumentCount(flags, 1, 1)
+	if err != nil {
+		return err
+	}
+	var diskImage []byte
+	err = readDiskImagePartition(&diskImage, flags.Arg(0), *partitionNum)
+	if err != nil {
+		return err
+	}
+	return filesystem.PrintDiskImageCatalog(os.Stdout, diskImage)
+}
+
+// runFsck carries out the fsck subcommand, checking the allocation of the blocks or sectors of a disk
+// image and exiting with status 1 on any problem.
+func runFsck(args []string) error {
+	var flags *flag.FlagSet = newSubcommandFlagSet("fsck", "diskImageFilepath")
+	addImageFlags(flags)
+	var partitionNum *int = addPartitionFlag(flags)
+	flags.Parse(args)
+	var err error = checkArgumentCount(flags, 1, 1)
+	if err != nil {
+		return err
+	}
+	var diskImage []byte
+	err = readDiskImagePartition(&diskImage, flags.Arg(0), *partitionNum)
+	if err != nil {
+		return err
+	}
+	if !filesystem.CheckDiskImage(os.Stdout, diskImage) {
+		os.Exit(1)
+	}
+	return nil
+}
+
+// runDiff carries out the diff subcommand, comparing two floppy disk images sector by sector and
+// exiting with status 1 when any sectors differ.
+func runDiff(args []string) error {
+	var flags *flag.FlagSet = newSubcommandFlagSet("diff", "diskImageFilepath otherDiskImageFilepath")
+	addImageFlags(flags)
+	var diffHex *bool = flags.Bool("hex", false, "also print the differing lines of each differing sector in hex and ASCII")
+	flags.Parse(args)
+	var err error = checkArgumentCount(flags, 2, 2)
+	if err != nil {
+		return err
+	}
+	var diskImage, otherDiskImage []byte
+	err = readDiskImageFromFile(&diskImage, flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	err = readDiskImageFromFile(&otherDiskImage, flags.Arg(1))
+	if err != nil {
+		return err
+	}
+	if len(diskImage) != diskimage.FLOPPY_IMAGE_SIZE || len(otherDiskImage) != diskimage.FLOPPY_IMAGE_SIZE {
+		return failure.CodedErrorf(failure.KIND_OPTION_CONFLICT, "diff needs two 140K floppy images, not %d and %d bytes", len(diskImage), len(otherDiskImage))
+	}
+	var identical bool
+	err = diffDiskImages(&identical, os.Stdout, diskImage, otherDiskImage, *diffHex)
+	if err != nil {
+		return err
+	}
+	if !identical {
+		os.Exit(1)
+	}
+	return nil
+}
+
+// addSectorFlags adds -track and -sector to flags, for the subcommands working on one sector of a
+// floppy disk image.
+func addSectorFlags(flags *flag.FlagSet) (*int, *int) {
+	var trackNum *int = flags.Int("track", 0, "the track of the sector")
+	var sectorNum *int = flags.Int("sector", 0, "the sector, in DOS 3.3 sector order")
+	return trackNum, sectorNum
+}
+
+// runHexdump carries out the hexdump subcommand, printing one sector of a floppy disk image in hex and
+// ASCII.
+func runHexdump(args []string) error {
+	var flags *flag.FlagSet = newSubcommandFlagSet("hexdump", "diskImageFilepath")
+	addImageFlags(flags)
+	trackNum, sectorNum := addSectorFlags(flags)
+	var bothOrders *bool = flags.Bool("both-orders", false, "also print the sector at the same position of the image in ProDOS sector order")
+	flags.Parse(args)
+	var err error = checkArgumentCount(flags, 1, 1)
+	if err != nil {
+		return err
+	}
+	if *trackNum < 0 || *trackNum >= 0x23 || *sectorNum < 0 || *sectorNum > 0x0F {
+		return failure.SectorErrorf(failure.KIND_ILLEGAL_TRACK_OR_SECTOR, *trackNum, *sectorNum, "illegal track or sector number: track %d sector %d", *trackNum, *sectorNum)
+	}
+	var diskImage []byte
+	err = readDiskImageFromFile(&diskImage, flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	if len(diskImage) != diskimage.FLOPPY_IMAGE_SIZE {
+		return failure.CodedErrorf(failure.KIND_OPTION_CONFLICT, "hexdump needs a 140K floppy image, not %d bytes", len(diskImage))
+	}
+	err = dumpDiskImageSector(diskImage, *trackNum, *sectorNum, *bothOrders)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// runPoke carries out the poke subcommand, storing bytes into one sector of a floppy disk image in
+// place.
+func runPoke(args []string) error {
+	var flags *flag.FlagSet = newSubcommandFlagSet("poke", "diskImageFilepath hexBytes...")
+	addImageFlags(flags)
+	trackNum, sectorNum := addSectorFlags(flags)
+	var pokeOffset *int = flags.Int("offset", 0, "the offset in the sector of the first byte to store")
+	flags.Parse(args)
+	if *trackNum < 0 || *trackNum >= 0x23 || *sectorNum < 0 || *sectorNum > 0x0F {
+		return failure.SectorErrorf(failure.KIND_ILLEGAL_TRACK_OR_SECTOR, *trackNum, *sectorNum, "illegal track or sector number: track %d sector %d", *trackNum, *sectorNum)
+	}
+	if *pokeOffset < 0 || *pokeOffset > 0xFF {
+		return failure.CodedErrorf(failure.KIND_ILLEGAL_SECTOR_OFFSET, "illegal sector offset: %d", *pokeOffset)
+	}
+	if flags.NArg() < 2 {
+		return failure.CodedErrorf(failure.KIND_OPTION_CONFLICT, "poke needs the bytes to store after the diskImageFilepath")
+	}
+	var pokeBytes []byte
+	var err error = parsePokeBytes(&pokeBytes, flags.Args()[1:])
+	if err != nil {
+		return err
+	}
+	var diskImage []byte
+	err = readDiskImageFromFile(&diskImage, flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	if len(diskImage) != diskimage.FLOPPY_IMAGE_SIZE {
+		return failure.CodedErrorf(failure.KIND_OPTION_CONFLICT, "poke needs a 140K floppy image, not %d bytes", len(diskImage))
+	}
+	err = refuseLockedDiskImage(flags.Arg(0), false)
+	if err != nil {
+		return err
+	}
+	if diskImageReadOrder == "" {
+		return fmt.Errorf("%s cannot be changed in place, convert it to a .po image first", flags.Arg(0))
+	}
+	err = pokeDiskImageSector(diskImage, *trackNum, *sectorNum, *pokeOffset, pokeBytes)
+	if err != nil {
+		return err
+	}
+	// written back in the sector order it was read in
+	err = diskimage.ReorderDiskImageSectors(diskImage, diskimage.SECTOR_ORDER_PRODOS, diskImageReadOrder)
+	if err != nil {
+		return err
+	}
+	err = writeDiskImageWithJournal(diskImage, flags.Arg(0), "poke")
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "wrote %d bytes to file %s\n", len(diskImage), flags.Arg(0))
+	return nil
+}
+
+// runBrowse carries out the browse subcommand, stepping through the sectors of a floppy disk image.
+func runBrowse(args []string) error {
+	var flags *flag.FlagSet = newSubcommandFlagSet("browse", "diskImageFilepath")
+	addImageFlags(flags)
+	var partitionNum *int = addPartitionFlag(flags)
+	flags.Parse(args)
+	var err error = checkArgumentCount(flags, 1, 1)
+	if err != nil {
+		return err
+	}
+	var diskImage []byte
+	err = readDiskImagePartition(&diskImage, flags.Arg(0), *partitionNum)
+	if err != nil {
+		return err
+	}
+	if len(diskImage) != diskimage.FLOPPY_IMAGE_SIZE {
+		return failure.CodedErrorf(failure.KIND_OPTION_CONFLICT, "browse needs a 140K floppy image, not %d bytes", len(diskImage))
+	}
+	err = browseDiskImage(diskImage, os.Stdin)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// runHgr carries out the hgr subcommand, listing the hi-res pictures held in a disk image, or
+// rendering the one named to a PNG file.
+func runHgr(args []string) error {
+	var flags *flag.FlagSet = newSubcommandFlagSet("hgr", "diskImageFilepath[:fileName] [pngFilepath]")
+	addImageFlags(flags)
+	var partitionNum *int = addPartitionFlag(flags)
+	flags.Parse(args)
+	var diskImageFilepath, fileName string
+	splitImageFileArgument(&diskImageFilepath, &fileName, flags.Arg(0))
+	var diskImage []byte
+	var err error = readDiskImagePartition(&diskImage, diskImageFilepath, *partitionNum)
+	if err != nil {
+		return err
+	}
+	if !strings.Contains(flags.Arg(0), ":") {
+		var picturePaths []string
+		err = findHgrFiles(&picturePaths, diskImage)
+		if err != nil {
+			return err
+		}
+		for _, picturePath := range picturePaths {
+			fmt.Println(picturePath)
+		}
+		return nil
+	}
+	if flags.NArg() < 2 {
+		return failure.CodedErrorf(failure.KIND_OPTION_CONFLICT, "hgr needs a pngFilepath to render a picture to")
+	}
+	var screen []byte
+	err = filesystem.ReadFileFromDiskImage(&screen, diskImage, fileName)
+	if err != nil {
+		return err
+	}
+	return writeHgrScreenAsPng(screen, flags.Arg(1))
+}
+
+// runLabel carries out the label subcommand, writing a printable PDF sleeve insert and disk label
+// listing the files of a disk image.
+func runLabel(args []string) error {
+	var flags *flag.FlagSet = newSubcommandFlagSet("label", "diskImageFilepath pdfFilepath")
+	addImageFlags(flags)
+	var partitionNum *int = addPartitionFlag(flags)
+	var labelTitle *string = flags.String("title", "", "the title printed instead of the volume name")
+	var labelSubtitle *string = flags.String("subtitle", "", "the subtitle printed instead of the image file name")
+	flags.Parse(args)
+	var diskImage []byte
+	var err error = readDiskImagePartition(&diskImage, flags.Arg(0), *partitionNum)
+	if err != nil {
+		return err
+	}
+	var title string
+	var fileNames []string
+	err = filesystem.ListDiskImageFiles(&title, &fileNames, diskImage)
+	if err != nil {
+		return err
+	}
+	if *labelTitle != "" {
+		title = *labelTitle
+	}
+	var subtitle string = filepath.Base(flags.Arg(0))
+	if *labelSubtitle != "" {
+		subtitle = *labelSubtitle
+	}
+	return writeDiskLabelPdf(flags.Arg(1), title, subtitle, fileNames)
+}
+
+// runPreview carries out the preview subcommand, booting a temporary copy of a disk image in a locally
+// installed emulator.
+func runPreview(args []string) error {
+	var flags *flag.FlagSet = newSubcommandFlagSet("preview", "diskImageFilepath")
+	addImageFlags(flags)
+	var partitionNum *int = addPartitionFlag(flags)
+	var emulator *string = flags.String("emulator", "linapple", "the emulator to start: linapple, applewin (through wine) or microm8")
+	var emulatorCommand *string = flags.String("emulator-command", "", "the command line starting the emulator, with %s standing for the image file path")
+	flags.Parse(args)
+	var diskImage []byte
+	var err error = readDiskImagePartition(&diskImage, flags.Arg(0), *partitionNum)
+	if err != nil {
+		return err
+	}
+	var command string = *emulatorCommand
+	if command == "" {
+		var found bool
+		command, found = EMULATOR_COMMANDS[*emulator]
+		if !found {
+			return failure.CodedErrorf(failure.KIND_UNKNOWN_VALUE, "unknown emulator: %s", *emulator)
+		}
+	}
+	return previewDiskImage(diskImage, command)
+}
+
+// runHash carries out the hash subcommand, printing the hashes of each disk image given and of each
+// of its tracks.
+func runHash(args []string) error {
+	var flags *flag.FlagSet = newSubcommandFlagSet("hash", "diskImageFilepath...")
+	addImageFlags(flags)
+	var partitionNum *int = addPartitionFlag(flags)
+	flags.Parse(args)
+	if flags.NArg() < 1 {
+		return failure.CodedErrorf(failure.KIND_OPTION_CONFLICT, "hash needs at least one diskImageFilepath")
+	}
+	var err error
+	for _, diskImageFilepath := range flags.Args() {
+		if *partitionNum == 0 {
+			var data io.Reader
+			var byteCount int
+			var streamFile *os.File
+			streamFile, err = openDiskImageStream(&data, &byteCount, diskImageFilepath)
+			if err != nil {
+				return err
+			}
+			if streamFile != nil {
+				err = reportDiskImageStreamHashes(os.Stdout, data, diskImageFilepath)
+				streamFile.Close()
+				if err != nil {
+					return err
+				}
+				continue
+			}
+		}
+		var diskImage []byte
+		err = readDiskImagePartition(&diskImage, diskImageFilepath, *partitionNum)
+		if err != nil {
+			return err
+		}
+		err = reportDiskImageHashes(os.Stdout, diskImage, diskImageFilepath)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// runVerify carries out the verify subcommand, checking the SHA-1 of a disk image against a list of
+// known-good image hashes and exiting with status 1 when it is not listed as good.
+func runVerify(args []string) error {
+	var flags *flag.FlagSet = newSubcommandFlagSet("verify", "hashListFilepath diskImageFilepath")
+	addImageFlags(flags)
+	flags.Parse(args)
+	var diskImage []byte
+	var err error = readDiskImageFromFile(&diskImage, flags.Arg(1))
+	if err != nil {
+		return err
+	}
+	var verified bool
+	verified, err = verifyDiskImageAgainstHashList(diskImage, flags.Arg(1), flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	if !verified {
+		os.Exit(1)
+	}
+	return nil
+}
+
+// runUndo carries out the undo subcommand, rolling back the last journaled operations which wrote a
+// disk image file.
+func runUndo(args []string) error {
+	var flags *flag.FlagSet = newSubcommandFlagSet("undo", "operationCount diskImageFilepath")
+	flags.Parse(args)
+	var operationCount int
+	operationCount, err := strconv.Atoi(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	if operationCount < 1 {
+		return failure.CodedErrorf(failure.KIND_INVALID_NUMBER, "illegal operation count encountered: %d", operationCount)
+	}
+	return undoDiskImageOperations(flags.Arg(1), operationCount)
+}
+
+// Subcommand section end
+
+// floppy_disk_image_file_to_serial_install main routine carries out the subcommand named by the
+// command line arguments, by default installing a track of a disk image: it outputs the full series
+// of apple ][ monitor commands to load the apple ][ memory buffer with data for the requested track,
+// and to load and execute the machine language routine which will write the data to the apple II Disk
+// track via the Dos3.3 RWTS subroutine. Note that before transfer, the sector order is reordered for
+// proper ProDOS block access during disk use.
+// A failure returned by run, or a runtime error recovered from a bug, is reported on stderr with exit
+// status 2, unless -debug lets it stop the program with a Go panic and stack trace.
+func main() {
+	defer func() {
+		if debugFailures {
+			return
+		}
+		var recovered interface{} = recover()
+		if recovered != nil {
+			exitWithFailure(failure.LocateRuntimeFailure(recovered))
+		}
+	}()
+	var err error = run()
+	if err != nil {
+		if debugFailures {
+			panic(err)
+		}
+		exitWithFailure(err)
+	}
+}
+
+// debugFailures is set with -debug, to let a failure stop the program with a Go panic and stack trace.
+var debugFailures bool
+
+// reportFailuresAsJson is set with -errors-json, to additionally report a failure as a line of JSON.
+var reportFailuresAsJson bool
+
+// exitWithFailure reports a failure on stderr, as a line of JSON as well with -errors-json, and exits
+// with status 2.
+func exitWithFailure(reason interface{}) {
+	failure.ReportFailure(os.Stderr, reason)
+	if reportFailuresAsJson {
+		failure.ReportErrorAsJson(os.Stderr, reason)
+	}
+	os.Exit(2)
+}
+
+// run carries out the subcommand named by the first command line argument, or install when it names
+// none, returning the first failure met. -help given first lists the subcommands.
+func run() error {
+	var args []string = os.Args[1:]
+	if len(args) == 0 {
+		printUsage()
+		os.Exit(2)
+	}
+	if args[0] == "-help" || args[0] == "-h" || args[0] == "--help" || args[0] == "help" {
+		// help NAME lists the flags of the subcommand NAME, as NAME -help does
+		for _, command := range SUBCOMMANDS {
+			if args[0] == "help" && len(args) > 1 && args[1] == command.name {
+				return command.run([]string{"-help"})
+			}
+		}
+		printUsage()
+		return nil
+	}
+	for _, command := range SUBCOMMANDS {
+		if args[0] == command.name {
+			return command.run(args[1:])
+		}
+	}
+	return runInstall(args)
 }
@@ -0,0 +1,1561 @@
+package main
+
+import "bytes"
+import "encoding/binary"
+import "encoding/json"
+import "errors"
+import "flag"
+import "fmt"
+import "github.com/bassjack1/apple2disk/go/diskimage"
+import "github.com/bassjack1/apple2disk/go/emulator"
+import "github.com/bassjack1/apple2disk/go/failure"
+import "github.com/bassjack1/apple2disk/go/filesystem"
+import "github.com/bassjack1/apple2disk/go/gcr"
+import "github.com/bassjack1/apple2disk/go/protocol"
+import "io"
+import "io/ioutil"
+import "net/http"
+import "net/http/httptest"
+import "os"
+import "path/filepath"
+import "strings"
+import "testing"
+import "time"
+
+// ALL_SECTOR_ORDERS lists every sector order of SECTOR_INTERLEAVES.
+var ALL_SECTOR_ORDERS []diskimage.SectorOrder = []diskimage.SectorOrder{diskimage.SECTOR_ORDER_DOS, diskimage.SECTOR_ORDER_PRODOS, diskimage.SECTOR_ORDER_PASCAL, diskimage.SECTOR_ORDER_CPM, diskimage.SECTOR_ORDER_PHYSICAL}
+
+// generateTestDiskImage returns a 140K image whose sectors all differ, each filled with a pattern
+// after its number in the image, which its first two bytes hold.
+func generateTestDiskImage() []byte {
+	var image []byte = make([]byte, diskimage.FLOPPY_IMAGE_SIZE)
+	for i := 0; i < len(image); i = i + 1 {
+		image[i] = byte(i/0x0100 + i*7)
+	}
+	for sectorNum := 0; sectorNum < diskimage.FLOPPY_IMAGE_SIZE/0x0100; sectorNum = sectorNum + 1 {
+		image[sectorNum*0x0100] = byte(sectorNum & 0xFF)
+		image[sectorNum*0x0100+1] = byte(sectorNum >> 8)
+	}
+	return image
+}
+
+// TestReorderSectorsRoundTrip checks that reordering an image from one sector order into another and
+// back gives the original image, for every pair of orders, and that the original is left as it is.
+func TestReorderSectorsRoundTrip(t *testing.T) {
+	var image []byte = generateTestDiskImage()
+	var original []byte = append([]byte{}, image...)
+	for _, from := range ALL_SECTOR_ORDERS {
+		for _, to := range ALL_SECTOR_ORDERS {
+			reordered, err := diskimage.ReorderSectors(image, from, to)
+			if err != nil {
+				t.Fatalf("%s to %s: %v", from, to, err)
+			}
+			roundTrip, err := diskimage.ReorderSectors(reordered, to, from)
+			if err != nil {
+				t.Fatalf("%s to %s: %v", to, from, err)
+			}
+			if !bytes.Equal(roundTrip, image) {
+				t.Errorf("%s to %s and back does not give the original image", from, to)
+			}
+			if !bytes.Equal(image, original) {
+				t.Fatalf("%s to %s changed the image passed in", from, to)
+			}
+		}
+	}
+}
+
+// TestReorderSectorsProdosToDos checks the ProDOS to DOS 3.3 order conversion against the usual
+// sector mapping, which puts ProDOS block 0 into DOS 3.3 sectors 0x00 and 0x0E of track 0: DOS 3.3
+// sector i of each track holds ProDOS sector PRODOS_SECTOR_OF_DOS33_SECTOR[i].
+func TestReorderSectorsProdosToDos(t *testing.T) {
+	var PRODOS_SECTOR_OF_DOS33_SECTOR [0x10]int = [0x10]int{
+		0x00, 0x0E, 0x0D, 0x0C, 0x0B, 0x0A, 0x09, 0x08, 0x07, 0x06, 0x05, 0x04, 0x03, 0x02, 0x01, 0x0F,
+	}
+	var image []byte = generateTestDiskImage()
+	reordered, err := diskimage.ReorderSectors(image, diskimage.SECTOR_ORDER_PRODOS, diskimage.SECTOR_ORDER_DOS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for track := 0x00; track < 0x23; track = track + 1 {
+		for dos33Sector := 0x00; dos33Sector < 0x10; dos33Sector = dos33Sector + 1 {
+			var dos33Pos int = diskimage.DiskImageStartPosOfTrackSector(track, dos33Sector)
+			var prodosPos int = diskimage.DiskImageStartPosOfTrackSector(track, PRODOS_SECTOR_OF_DOS33_SECTOR[dos33Sector])
+			if !bytes.Equal(reordered[dos33Pos:dos33Pos+0x0100], image[prodosPos:prodosPos+0x0100]) {
+				t.Errorf("track %d DOS 3.3 sector %d does not hold ProDOS sector %d", track, dos33Sector, PRODOS_SECTOR_OF_DOS33_SECTOR[dos33Sector])
+			}
+		}
+	}
+}
+
+// TestInverseSectorPermutation checks that the inverse permutation of each pair of orders is the
+// permutation going the other way, and undoes it.
+func TestInverseSectorPermutation(t *testing.T) {
+	for _, from := range ALL_SECTOR_ORDERS {
+		for _, to := range ALL_SECTOR_ORDERS {
+			permutation, err := diskimage.SectorPermutation(from, to)
+			if err != nil {
+				t.Fatal(err)
+			}
+			inverse, err := diskimage.InverseSectorPermutation(from, to)
+			if err != nil {
+				t.Fatal(err)
+			}
+			backwards, err := diskimage.SectorPermutation(to, from)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if inverse != backwards {
+				t.Errorf("inverse of %s to %s is %v, not %v", from, to, inverse, backwards)
+			}
+			for sector := 0x00; sector < 0x10; sector = sector + 1 {
+				if inverse[permutation[sector]] != sector {
+					t.Errorf("inverse of %s to %s does not bring sector %d back", from, to, sector)
+				}
+			}
+		}
+	}
+}
+
+// TestReorderSectorsErrors checks that unknown sector orders and images shorter than 35 tracks are
+// reported as errors.
+func TestReorderSectorsErrors(t *testing.T) {
+	var image []byte = generateTestDiskImage()
+	_, err := diskimage.ReorderSectors(image, diskimage.SectorOrder("nibble"), diskimage.SECTOR_ORDER_DOS)
+	if err == nil {
+		t.Error("no error for an unknown order to reorder from")
+	}
+	_, err = diskimage.ReorderSectors(image, diskimage.SECTOR_ORDER_DOS, diskimage.SectorOrder("13-sector"))
+	if err == nil {
+		t.Error("no error for an unknown order to reorder into")
+	}
+	_, err = diskimage.ReorderSectors(image[:diskimage.FLOPPY_IMAGE_SIZE-0x0100], diskimage.SECTOR_ORDER_PRODOS, diskimage.SECTOR_ORDER_DOS)
+	if err == nil {
+		t.Error("no error for an image shorter than 35 tracks")
+	}
+	_, err = diskimage.InverseSectorPermutation(diskimage.SECTOR_ORDER_PRODOS, diskimage.SectorOrder(""))
+	if err == nil {
+		t.Error("no error for the inverse of an unknown order")
+	}
+}
+
+// TestClientProgramIllegalTrack checks that the RWTS client program, its IOB reset and the sector
+// reordering return errors instead of panicking for a track past the disk and a short image.
+func TestClientProgramIllegalTrack(t *testing.T) {
+	var clientProgram []byte
+	var err error = generateRWTSClientProgram(&clientProgram, 0x23, RWTS_COMMAND_WRITE, "track")
+	if err == nil || !strings.Contains(err.Error(), "illegal track number") {
+		t.Errorf("client program for track 35: error %v", err)
+	}
+	var iobReset []byte
+	err = generateClientIobReset(&iobReset, -1, "descending")
+	if err == nil || !strings.Contains(err.Error(), "illegal track number") {
+		t.Errorf("IOB reset for track -1: error %v", err)
+	}
+	var reordered []byte
+	err = diskimage.ReorderedDiskImageSectors(&reordered, make([]byte, diskimage.FLOPPY_IMAGE_SIZE-0x0100), diskimage.SECTOR_ORDER_PRODOS, diskimage.SECTOR_ORDER_DOS)
+	if err == nil {
+		t.Errorf("no error reordering an image shorter than 35 tracks")
+	}
+}
+
+// TestClientStrategiesWriteTrack runs the RWTS client program of each client strategy on the 6502
+// emulator with the mock RWTS, checking that it returns, that the 16 sectors of the track are written
+// from the pages of the track buffer, and that the buffer page moves on with each sector. The sector
+// strategy client writes one sector per run from the same page, so it is run once for each sector
+// with that sector loaded.
+func TestClientStrategiesWriteTrack(t *testing.T) {
+	const TRACK_NUM = 0x11
+	for _, clientStrategy := range []string{"track", "descending", "sector"} {
+		var rwts *mockRwts = &mockRwts{sectors: map[int][]byte{}, failingCall: -1}
+		c, err := startClientCheck(rwts, TRACK_NUM, clientStrategy)
+		if err != nil {
+			t.Fatalf("%s client: %s", clientStrategy, err)
+		}
+		var trackBuffer []byte = append([]byte{}, c.Memory[bufferAddress:bufferAddress+0x1000]...)
+		var runCount int = 1
+		if clientStrategy == "sector" {
+			runCount = 0x10
+		}
+		var calls []rwtsCall
+		for run := 0; run < runCount; run = run + 1 {
+			if clientStrategy == "sector" {
+				// the host loads the sector into the buffer and stores its number into the IOB
+				copy(c.Memory[bufferAddress:bufferAddress+0x0100], trackBuffer[run*0x0100:(run+1)*0x0100])
+				c.Memory[clientAddress+0x21] = byte(run)
+			}
+			rwts.calls = nil
+			var stop string = emulator.RunMachineCode(c, clientAddress, CLIENT_CHECK_MAX_INSTRUCTIONS)
+			if stop != "returned" {
+				t.Fatalf("%s client: %s instead of returning", clientStrategy, stop)
+			}
+			calls = append(calls, rwts.calls...)
+		}
+		if len(calls) != 0x10 {
+			t.Fatalf("%s client: %d RWTS calls instead of 16", clientStrategy, len(calls))
+		}
+		for i, call := range calls {
+			var expectedSector int = i
+			if clientStrategy == "descending" {
+				expectedSector = 0x0F - i
+			}
+			var expectedBufferAddress int = bufferAddress + expectedSector*0x0100
+			if clientStrategy == "sector" {
+				expectedBufferAddress = bufferAddress
+			}
+			if call.command != RWTS_COMMAND_WRITE || call.track != TRACK_NUM || call.sector != expectedSector {
+				t.Errorf("%s client: call %d was command %d for track %d sector %d, not a write of track %d sector %d", clientStrategy, i+1, call.command, call.track, call.sector, TRACK_NUM, expectedSector)
+			}
+			if call.bufferAddress != expectedBufferAddress {
+				t.Errorf("%s client: call %d wrote from buffer %04X instead of %04X", clientStrategy, i+1, call.bufferAddress, expectedBufferAddress)
+			}
+		}
+		if len(rwts.sectors) != 0x10 {
+			t.Errorf("%s client: %d sectors written instead of 16", clientStrategy, len(rwts.sectors))
+		}
+		for sector := 0x00; sector < 0x10; sector = sector + 1 {
+			if !bytes.Equal(rwts.sectors[TRACK_NUM<<4|sector], trackBuffer[sector*0x0100:(sector+1)*0x0100]) {
+				t.Errorf("%s client: sector %d does not hold page %d of the track buffer", clientStrategy, sector, sector)
+			}
+		}
+	}
+}
+
+// TestSectorAccessorErrors checks that the sector accessors return an error wrapping
+// diskimage.ErrIllegalTrackOrSector, naming the track and sector, for a sector outside the image.
+func TestSectorAccessorErrors(t *testing.T) {
+	var image []byte = generateTestDiskImage()
+	var sectorBuffer [0x0100]byte
+	var err error = diskimage.ReadSectorDataToBuffer(&sectorBuffer, image, 0x22, 0x0F)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(sectorBuffer[:], image[diskimage.FLOPPY_IMAGE_SIZE-0x0100:]) {
+		t.Error("the last sector read does not hold the end of the image")
+	}
+	err = diskimage.ReadSectorDataToBuffer(&sectorBuffer, image, 0x23, 0x00)
+	if !errors.Is(err, diskimage.ErrIllegalTrackOrSector) || !strings.Contains(fmt.Sprint(err), "reading track 35 sector 0") {
+		t.Errorf("reading track 35 sector 0 gave %v", err)
+	}
+	err = diskimage.WriteSectorDataFromBuffer(&sectorBuffer, image, 0x05, 0x10)
+	if !errors.Is(err, diskimage.ErrIllegalTrackOrSector) || !strings.Contains(fmt.Sprint(err), "writing track 5 sector 16") {
+		t.Errorf("writing track 5 sector 16 gave %v", err)
+	}
+}
+
+// TestParseTrackList checks the track lists accepted, and that those out of range are errors.
+func TestParseTrackList(t *testing.T) {
+	var trackNums []int
+	var err error = parseTrackList(&trackNums, "0-2,17,34")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(trackNums) != "[0 1 2 17 34]" {
+		t.Errorf("0-2,17,34 gave the tracks %v", trackNums)
+	}
+	for _, trackList := range []string{"0-35", "5-3", "x", "-1"} {
+		if parseTrackList(&trackNums, trackList) == nil {
+			t.Errorf("no error for the track list %s", trackList)
+		}
+	}
+}
+
+
+// TestClassifyFailure checks that a failure is reported with the code, track and sector of the
+// outermost error giving them, that errors of the system are classified, and that anything else is an
+// internal failure.
+func TestClassifyFailure(t *testing.T) {
+	var image []byte = generateTestDiskImage()
+	var sectorBuffer [0x0100]byte
+	var err error = diskimage.ReadSectorDataToBuffer(&sectorBuffer, image, 0x23, 0x02)
+	var report failure.ErrorReport = failure.ClassifyFailure(fmt.Errorf("catalog: %w", err))
+	if report.Code != "track_out_of_range" || report.Track == nil || *report.Track != 0x23 || report.Sector == nil || *report.Sector != 0x02 {
+		t.Errorf("reading track 35 sector 2 was reported as %+v", report)
+	}
+	report = failure.ClassifyFailure(failure.CodedErrorf(failure.KIND_WRITE_FAILED, "writing the commands failed after 3 lines: %w", failure.TrackErrorf(failure.KIND_ILLEGAL_TRACK, 40, "illegal track number encountered: 40")))
+	if report.Code != "transfer_failed" || report.Track == nil || *report.Track != 40 || report.Sector != nil {
+		t.Errorf("a failure wrapping another was reported as %+v", report)
+	}
+	_, err = os.Open("/nonexistent/image.po")
+	report = failure.ClassifyFailure(err)
+	if report.Code != "file_not_found" || report.Suggestion == "" {
+		t.Errorf("a missing file was reported as %+v", report)
+	}
+	report = failure.ClassifyFailure("illegal track\n")
+	if report.Code != "internal" || report.Message != "illegal track" || report.Track != nil {
+		t.Errorf("a recovered string was reported as %+v", report)
+	}
+}
+
+// TestStreamDiskImageTracks checks that an image is handed over whole a track at a time, with a short
+// final track, and that reading stops at the size limit.
+func TestStreamDiskImageTracks(t *testing.T) {
+	var image []byte = generateTestDiskImage()[:0x2A00]
+	var streamed []byte
+	var trackLengths []int
+	var byteCount int
+	var err error = streamDiskImageTracks(&byteCount, bytes.NewReader(image), 0, func(track []byte) error {
+		streamed = append(streamed, track...)
+		trackLengths = append(trackLengths, len(track))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if byteCount != len(image) || !bytes.Equal(streamed, image) {
+		t.Errorf("%d bytes streamed do not hold the %d bytes of the image", byteCount, len(image))
+	}
+	if fmt.Sprint(trackLengths) != "[4096 4096 2560]" {
+		t.Errorf("tracks of %v bytes handed over", trackLengths)
+	}
+	err = streamDiskImageTracks(&byteCount, bytes.NewReader(image), 0x2000, func(track []byte) error {
+		return nil
+	})
+	if !errors.Is(err, errDiskImageTooLarge) {
+		t.Errorf("reading past the limit gave %v", err)
+	}
+}
+
+// generateTestProdosImage returns a 140K ProDOS ordered image holding an empty volume named TEST, with
+// its volume directory in block 2 and its volume bitmap in block 3.
+func generateTestProdosImage() []byte {
+	var image []byte = make([]byte, diskimage.FLOPPY_IMAGE_SIZE)
+	var header []byte = diskimage.ProdosBlockOfImage(image, 0x02)[0x04:]
+	header[0x00] = 0xF0 | byte(len("TEST"))
+	copy(header[0x01:], "TEST")
+	header[0x1E] = 0xC3
+	header[0x1F] = filesystem.PRODOS_DIRECTORY_ENTRY_LENGTH
+	header[0x20] = 0x0D
+	header[0x23] = 0x03
+	header[0x25] = byte((diskimage.FLOPPY_IMAGE_SIZE / diskimage.PRODOS_BLOCK_SIZE) & 0xFF)
+	header[0x26] = byte((diskimage.FLOPPY_IMAGE_SIZE / diskimage.PRODOS_BLOCK_SIZE) >> 8)
+	// blocks 0 through 3 are used, the others free
+	var bitmap []byte = diskimage.ProdosBlockOfImage(image, 0x03)
+	for blockNum := 0x04; blockNum < diskimage.FLOPPY_IMAGE_SIZE/diskimage.PRODOS_BLOCK_SIZE; blockNum = blockNum + 1 {
+		bitmap[blockNum/8] = bitmap[blockNum/8] | 0x80>>uint(blockNum%8)
+	}
+	return image
+}
+
+// addTestProdosSubdirectory adds to the directory with key block parentBlockNum of the ProDOS ordered
+// image the entry of the subdirectory named name with key block keyBlockNum, and when the key block is
+// free, the empty subdirectory itself, marking the block used.
+func addTestProdosSubdirectory(image []byte, parentBlockNum int, name string, keyBlockNum int) {
+	var parentKeyBlock []byte = diskimage.ProdosBlockOfImage(image, parentBlockNum)
+	var bitmap []byte = diskimage.ProdosBlockOfImage(image, 0x03)
+	filesystem.ForEachProdosDirectoryEntry(image, parentBlockNum, func(entry []byte, blockNum int) bool {
+		if entry[0x00] != 0x00 {
+			return true
+		}
+		entry[0x00] = 0xD0 | byte(len(name))
+		copy(entry[0x01:], name)
+		entry[0x10] = 0x0F
+		entry[0x11] = byte(keyBlockNum & 0xFF)
+		entry[0x12] = byte(keyBlockNum >> 8)
+		entry[0x13] = 0x01
+		entry[0x16] = 0x02
+		entry[0x1E] = 0xE3
+		entry[0x25] = byte(parentBlockNum & 0xFF)
+		entry[0x26] = byte(parentBlockNum >> 8)
+		parentKeyBlock[0x04+0x21] = parentKeyBlock[0x04+0x21] + 1
+		return false
+	})
+	if bitmap[keyBlockNum/8]&(0x80>>uint(keyBlockNum%8)) == 0 {
+		return
+	}
+	bitmap[keyBlockNum/8] = bitmap[keyBlockNum/8] &^ (0x80 >> uint(keyBlockNum%8))
+	var header []byte = diskimage.ProdosBlockOfImage(image, keyBlockNum)[0x04:]
+	header[0x00] = 0xE0 | byte(len(name))
+	copy(header[0x01:], name)
+	header[0x1E] = 0xE3
+	header[0x1F] = filesystem.PRODOS_DIRECTORY_ENTRY_LENGTH
+	header[0x20] = 0x0D
+	header[0x23] = byte(parentBlockNum & 0xFF)
+	header[0x24] = byte(parentBlockNum >> 8)
+}
+
+// generateTestDos33Image returns a 140K ProDOS ordered image holding an empty DOS 3.3 disk of volume
+// 254, as INIT leaves it without the DOS image: the VTOC in track 17 sector 0, the catalog in the
+// sectors after it linked from sector 15 down to sector 1, and tracks 3 through 34 free but for track 17.
+func generateTestDos33Image() []byte {
+	var image []byte = make([]byte, diskimage.FLOPPY_IMAGE_SIZE)
+	var vtoc []byte = diskimage.Dos33SectorOfImage(image, 0x11, 0x00)
+	vtoc[0x01] = 0x11
+	vtoc[0x02] = 0x0F
+	vtoc[0x03] = 0x03
+	vtoc[0x06] = 0xFE
+	vtoc[0x27] = 0x7A
+	vtoc[0x34] = 0x23
+	vtoc[0x35] = 0x10
+	vtoc[0x37] = 0x01
+	for track := 0x03; track < 0x23; track = track + 1 {
+		if track != 0x11 {
+			vtoc[0x38+4*track] = 0xFF
+			vtoc[0x39+4*track] = 0xFF
+		}
+	}
+	for sector := 0x0F; sector > 0x01; sector = sector - 1 {
+		var catalogSector []byte = diskimage.Dos33SectorOfImage(image, 0x11, sector)
+		catalogSector[0x01] = 0x11
+		catalogSector[0x02] = byte(sector - 1)
+	}
+	diskimage.ReorderDiskImageSectors(image, diskimage.SECTOR_ORDER_DOS, diskimage.SECTOR_ORDER_PRODOS)
+	return image
+}
+
+// TEST_FILE_DATE is the date the tests add the files to the disk images at, one of the years ProDOS
+// stores as below 40.
+var TEST_FILE_DATE time.Time = time.Date(2024, time.March, 9, 14, 30, 0, 0, time.Local)
+
+// testHostFiles returns the host files added by the tests to the disk images, by name: a text file,
+// and a binary file long enough to need a second DOS 3.3 track/sector list and a ProDOS index block.
+func testHostFiles() map[string][]byte {
+	var data []byte = make([]byte, 40000)
+	for i := 0; i < len(data); i = i + 1 {
+		data[i] = byte(i*13 + i/0x0100)
+	}
+	return map[string][]byte{"NOTES": []byte("HELLO\nAPPLE ][\n"), "DATA": data}
+}
+
+// addTestHostFiles adds the files of testHostFiles to the image, NOTES as a text file and DATA as a
+// binary file, failing the test when either cannot be added.
+func addTestHostFiles(t *testing.T, image []byte) {
+	var files map[string][]byte = testHostFiles()
+	var err error = filesystem.AddHostFileToDiskImage(image, "NOTES", files["NOTES"], "TXT", -1, TEST_FILE_DATE)
+	if err == nil {
+		err = filesystem.AddHostFileToDiskImage(image, "DATA", files["DATA"], "BIN", 0x4000, TEST_FILE_DATE)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestAddFileRoundTrip checks that files added to a DOS 3.3 disk and to a ProDOS volume read back as
+// they were on the host, and that the check of the image finds no problems afterwards.
+func TestAddFileRoundTrip(t *testing.T) {
+	var images = []struct {
+		name  string
+		image []byte
+	}{
+		{"DOS 3.3", generateTestDos33Image()},
+		{"ProDOS", generateTestProdosImage()},
+	}
+	for _, test := range images {
+		addTestHostFiles(t, test.image)
+		for fileName, hostFileData := range testHostFiles() {
+			var fileData []byte
+			var err error = filesystem.ReadFileFromDiskImage(&fileData, test.image, fileName)
+			if err != nil || !bytes.Equal(fileData, hostFileData) {
+				t.Errorf("%s: reading %s back gave %d bytes and %v", test.name, fileName, len(fileData), err)
+			}
+		}
+		// a binary file whose last sectors hold only zeros keeps them
+		var zeroEnded []byte = append([]byte("PATCHED"), make([]byte, 0x0300)...)
+		var err error = filesystem.AddHostFileToDiskImage(test.image, "ZEROS", zeroEnded, "BIN", 0x2000, TEST_FILE_DATE)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var fileData []byte
+		err = filesystem.ReadFileFromDiskImage(&fileData, test.image, "ZEROS")
+		if err != nil || !bytes.Equal(fileData, zeroEnded) {
+			t.Errorf("%s: reading a file ending with zeros back gave %d bytes and %v", test.name, len(fileData), err)
+		}
+		var report bytes.Buffer
+		if !filesystem.CheckDiskImage(&report, test.image) {
+			t.Errorf("%s: check after adding the files reported:\n%s", test.name, report.String())
+		}
+	}
+}
+
+// TestExtractPattern checks that extracting a pattern writes the files matching it, whatever their
+// case, with the modification date of their ProDOS entries, and that extracting them again writes
+// them under new names instead of overwriting them.
+func TestExtractPattern(t *testing.T) {
+	var image []byte = generateTestProdosImage()
+	addTestHostFiles(t, image)
+	for _, fileName := range []string{"GAME.BIN", "TOOL.BIN"} {
+		var err error = filesystem.AddHostFileToDiskImage(image, fileName, []byte(fileName), "BIN", -1, TEST_FILE_DATE)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	hostDirectory, err := ioutil.TempDir("", "extract")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(hostDirectory)
+	var names *filesystem.FileNameFlags = testFileNameFlags(t, true)
+	for i := 0; i < 2; i = i + 1 {
+		err = filesystem.ExtractDiskImageFiles(image, "*.bin", hostDirectory, names)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	var hostFileNames []string
+	hostFiles, err := ioutil.ReadDir(hostDirectory)
+	for _, hostFile := range hostFiles {
+		hostFileNames = append(hostFileNames, hostFile.Name())
+		if !hostFile.ModTime().Equal(TEST_FILE_DATE) {
+			t.Errorf("%s was extracted modified at %v", hostFile.Name(), hostFile.ModTime())
+		}
+	}
+	if err != nil || strings.Join(hostFileNames, " ") != "GAME.BIN GAME_2.BIN TOOL.BIN TOOL_2.BIN" {
+		t.Errorf("extracting *.bin twice wrote %v, %v", hostFileNames, err)
+	}
+	err = filesystem.ExtractDiskImageFiles(image, "*.SYSTEM", hostDirectory, names)
+	if err == nil || !strings.Contains(err.Error(), "no file of the image matches *.SYSTEM") {
+		t.Errorf("extracting a pattern matching no file gave %v", err)
+	}
+}
+
+// testFileNameFlags returns the file name flags of extract (when forHost is set) or of add, set by
+// args, failing the test when they are not accepted.
+func testFileNameFlags(t *testing.T, forHost bool, args ...string) *filesystem.FileNameFlags {
+	var flags *flag.FlagSet = flag.NewFlagSet("names", flag.ContinueOnError)
+	var names *filesystem.FileNameFlags = filesystem.AddFileNameFlags(flags, forHost)
+	var err error = flags.Parse(args)
+	if err == nil {
+		err = names.Check()
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return names
+}
+
+// TestFileNameTranslation checks that host file names are translated to the names of ProDOS and
+// DOS 3.3 disks and back, within the limits of length, characters and case given.
+func TestFileNameTranslation(t *testing.T) {
+	var toDisk = []struct {
+		hostFileName string
+		isProdos     bool
+		args         []string
+		fileName     string
+	}{
+		{"player.bin", true, nil, "PLAYER.BIN"},
+		{"my game_v2.system", true, nil, "MY.GAME..SYSTEM"},
+		{"2048-clone.s", true, nil, "CLONE.S"},
+		{"notes, draft.txt", false, nil, "NOTES. DRAFT.TXT"},
+		{"a very long name for a dos 3.3 file.txt", false, nil, "A VERY LONG NAME FOR A DOS.TXT"},
+		{"player.bin", true, []string{"-name-length", "5"}, "P.BIN"},
+		{"my_game.bin", false, nil, "MY.GAME.BIN"},
+		{"my~game.bin", false, []string{"-name-replacement", "-"}, "MY-GAME.BIN"},
+	}
+	for _, test := range toDisk {
+		var fileName string
+		var err error = filesystem.TranslateHostFileName(&fileName, test.hostFileName, test.isProdos, testFileNameFlags(t, false, test.args...))
+		if err != nil || fileName != test.fileName {
+			t.Errorf("%s %v gave %q and %v, expected %q", test.hostFileName, test.args, fileName, err, test.fileName)
+		}
+	}
+	var fileName string
+	var err error = filesystem.TranslateHostFileName(&fileName, "1234", true, testFileNameFlags(t, false))
+	if err == nil {
+		t.Errorf("a host file name without a letter gave %q", fileName)
+	}
+	err = filesystem.TranslateHostFileName(&fileName, "player.bin", true, testFileNameFlags(t, false, "-name-replacement", "-"))
+	if err == nil {
+		t.Errorf("a replacement ProDOS names cannot hold gave %q", fileName)
+	}
+	var toHost = []struct {
+		fileName     string
+		args         []string
+		hostFileName string
+	}{
+		{"PLAYER.BIN", nil, "PLAYER.BIN"},
+		{"PLAYER.BIN", []string{"-name-case", "lower"}, "player.bin"},
+		{"HELLO/WORLD", nil, "HELLO_WORLD"},
+		{"..", nil, "__"},
+		{"MY GAME (1983)", []string{"-name-charset", "portable"}, "MY_GAME__1983_"},
+		{"ADVENTURE.SYSTEM", []string{"-name-length", "12", "-name-case", "lower"}, "adven.system"},
+	}
+	for _, test := range toHost {
+		var hostFileName string
+		filesystem.TranslateDiskFileName(&hostFileName, test.fileName, testFileNameFlags(t, true, test.args...))
+		if hostFileName != test.hostFileName {
+			t.Errorf("%s %v gave %q, expected %q", test.fileName, test.args, hostFileName, test.hostFileName)
+		}
+	}
+}
+
+// TestProdosVolumeCheck checks that the check of a ProDOS volume reports each kind of damage seeded
+// into it: a used block marked free, a free block marked used, blocks used by two files, a block off
+// the volume, and a wrong file count.
+func TestProdosVolumeCheck(t *testing.T) {
+	var tests = []struct {
+		name    string
+		damage  func(image []byte, notesEntry []byte, dataEntry []byte)
+		problem string
+	}{
+		{"used block marked free", func(image []byte, notesEntry []byte, dataEntry []byte) {
+			var bitmap []byte = diskimage.ProdosBlockOfImage(image, 0x03)
+			bitmap[notesEntry[0x11]/8] = bitmap[notesEntry[0x11]/8] | 0x80>>(notesEntry[0x11]%8)
+		}, "used by /TEST/NOTES marked free in the volume bitmap"},
+		{"free block marked used", func(image []byte, notesEntry []byte, dataEntry []byte) {
+			var bitmap []byte = diskimage.ProdosBlockOfImage(image, 0x03)
+			bitmap[0x117/8] = bitmap[0x117/8] &^ (0x80 >> (0x117 % 8))
+		}, "block 279 marked used in the volume bitmap but used by nothing"},
+		{"block used twice", func(image []byte, notesEntry []byte, dataEntry []byte) {
+			// the first data block of DATA is also the only block of NOTES
+			var indexBlock []byte = diskimage.ProdosBlockOfImage(image, int(dataEntry[0x11])|int(dataEntry[0x12])<<8)
+			indexBlock[0x00] = notesEntry[0x11]
+			indexBlock[0x0100] = notesEntry[0x12]
+		}, "is used by both /TEST/NOTES and /TEST/DATA"},
+		{"block off the volume", func(image []byte, notesEntry []byte, dataEntry []byte) {
+			var indexBlock []byte = diskimage.ProdosBlockOfImage(image, int(dataEntry[0x11])|int(dataEntry[0x12])<<8)
+			indexBlock[0x00] = 0x00
+			indexBlock[0x0100] = 0x20
+		}, "/TEST/DATA refers to block 8192 beyond the end of the volume"},
+		{"wrong file count", func(image []byte, notesEntry []byte, dataEntry []byte) {
+			diskimage.ProdosBlockOfImage(image, 0x02)[0x04+0x21] = 0x03
+		}, "directory /TEST lists 2 files but its header counts 3"},
+		{"bitmap beyond the image", func(image []byte, notesEntry []byte, dataEntry []byte) {
+			diskimage.ProdosBlockOfImage(image, 0x02)[0x04+0x23] = 0xF0
+			diskimage.ProdosBlockOfImage(image, 0x02)[0x04+0x24] = 0xFF
+		}, "the volume bitmap at block 65520 lies beyond the end of the 280 block image"},
+		{"volume larger than the image", func(image []byte, notesEntry []byte, dataEntry []byte) {
+			diskimage.ProdosBlockOfImage(image, 0x02)[0x04+0x26] = 0x10
+		}, "more than the 280 blocks of the image"},
+	}
+	for _, test := range tests {
+		var image []byte = generateTestProdosImage()
+		addTestHostFiles(t, image)
+		var notesEntry, dataEntry []byte
+		var err error = filesystem.FindProdosVolumeDirectoryEntry(&notesEntry, image, "NOTES")
+		if err == nil {
+			err = filesystem.FindProdosVolumeDirectoryEntry(&dataEntry, image, "DATA")
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		test.damage(image, notesEntry, dataEntry)
+		var report bytes.Buffer
+		if filesystem.CheckProdosVolume(&report, image) || !strings.Contains(report.String(), test.problem) {
+			t.Errorf("%s: check reported:\n%s", test.name, report.String())
+		}
+	}
+}
+
+// TestDosMasterSource checks that the DOS image is copied only from an image holding the DOS 3.3
+// boot sector and marking the DOS tracks in use, and that it then reaches the data disk.
+func TestDosMasterSource(t *testing.T) {
+	var dosImage []byte = generateTestDos33Image()
+	var dataImage []byte = generateTestDos33Image()
+	for _, image := range [][]byte{dosImage, dataImage} {
+		var err error = diskimage.ConvertDiskImageFromProdosOrderToDos33Order(image)
+		if err != nil {
+			t.Fatalf("%s", err)
+		}
+	}
+	var err error = filesystem.InstallDos33ImageOnDataDisk(dataImage, dosImage)
+	if err == nil || !strings.Contains(err.Error(), "boot sector") {
+		t.Errorf("an image without the boot sector gave error %v", err)
+	}
+	copy(diskimage.Dos33SectorOfImage(dosImage, 0x00, 0x00), filesystem.DOS33_BOOT_SECTOR_START)
+	diskimage.Dos33SectorOfImage(dosImage, 0x11, 0x00)[0x38+4*0x02] = 0xFF
+	err = filesystem.InstallDos33ImageOnDataDisk(dataImage, dosImage)
+	if err == nil || !strings.Contains(err.Error(), "track 2 is free") {
+		t.Errorf("an image with track 2 free gave error %v", err)
+	}
+	diskimage.Dos33SectorOfImage(dosImage, 0x11, 0x00)[0x38+4*0x02] = 0x00
+	err = filesystem.InstallDos33ImageOnDataDisk(dataImage, dosImage)
+	if err != nil || !bytes.Equal(dataImage[:0x3000], dosImage[:0x3000]) {
+		t.Errorf("the DOS image was not copied: %v", err)
+	}
+}
+
+// TestDos33DiskCheck checks that the check of a DOS 3.3 disk reports each kind of damage seeded into
+// it: a used sector marked free, a free sector marked used, sectors used by two files, a sector off
+// the disk, a wrong sector count, a catalog loop and a VTOC of another geometry.
+func TestDos33DiskCheck(t *testing.T) {
+	var tests = []struct {
+		name    string
+		damage  func(image []byte, notesEntry []byte, dataEntry []byte)
+		problem string
+	}{
+		{"used sector marked free", func(image []byte, notesEntry []byte, dataEntry []byte) {
+			var vtoc []byte = diskimage.Dos33SectorOfImage(image, 0x11, 0x00)
+			var bytePos int = 0x38 + 4*int(notesEntry[0x00]) + 1 - int(notesEntry[0x01])/8
+			vtoc[bytePos] = vtoc[bytePos] | 1<<(notesEntry[0x01]%8)
+		}, "used by NOTES marked free in the VTOC"},
+		{"free sector marked used", func(image []byte, notesEntry []byte, dataEntry []byte) {
+			var vtoc []byte = diskimage.Dos33SectorOfImage(image, 0x11, 0x00)
+			vtoc[0x38+4*0x22+1] = vtoc[0x38+4*0x22+1] &^ 0x01
+		}, "track 34 sector 0 marked used in the VTOC but used by nothing"},
+		{"sector used twice", func(image []byte, notesEntry []byte, dataEntry []byte) {
+			// the first data sector of DATA is also that of NOTES
+			var notesTsList []byte = diskimage.Dos33SectorOfImage(image, int(notesEntry[0x00]), int(notesEntry[0x01]))
+			var dataTsList []byte = diskimage.Dos33SectorOfImage(image, int(dataEntry[0x00]), int(dataEntry[0x01]))
+			copy(dataTsList[0x0C:0x0E], notesTsList[0x0C:0x0E])
+		}, "is used by both NOTES and DATA"},
+		{"sector off the disk", func(image []byte, notesEntry []byte, dataEntry []byte) {
+			var dataTsList []byte = diskimage.Dos33SectorOfImage(image, int(dataEntry[0x00]), int(dataEntry[0x01]))
+			dataTsList[0x0C] = 0x40
+			dataTsList[0x0D] = 0x03
+		}, "DATA refers to track 64 sector 3 off the disk"},
+		{"wrong sector count", func(image []byte, notesEntry []byte, dataEntry []byte) {
+			notesEntry[0x21] = 0x05
+		}, "NOTES uses 2 sectors but its catalog entry counts 5"},
+		{"catalog loop", func(image []byte, notesEntry []byte, dataEntry []byte) {
+			// the last catalog sector links back to the first
+			var catalogSector []byte = diskimage.Dos33SectorOfImage(image, 0x11, 0x01)
+			catalogSector[0x01] = 0x11
+			catalogSector[0x02] = 0x0F
+		}, "the catalog loops back to track 17 sector 15"},
+		{"VTOC of 13 sectors", func(image []byte, notesEntry []byte, dataEntry []byte) {
+			diskimage.Dos33SectorOfImage(image, 0x11, 0x00)[0x35] = 0x0D
+		}, "VTOC describes 35 tracks of 13 sectors of 256 bytes"},
+	}
+	for _, test := range tests {
+		var image []byte = generateTestDos33Image()
+		addTestHostFiles(t, image)
+		var err error = diskimage.ReorderDiskImageSectors(image, diskimage.SECTOR_ORDER_PRODOS, diskimage.SECTOR_ORDER_DOS)
+		if err != nil {
+			t.Fatalf("%s", err)
+		}
+		var notesEntry, dataEntry []byte
+		if !filesystem.FindDos33CatalogEntry(&notesEntry, image, "NOTES") || !filesystem.FindDos33CatalogEntry(&dataEntry, image, "DATA") {
+			t.Fatalf("the files added are not in the catalog")
+		}
+		test.damage(image, notesEntry, dataEntry)
+		var report bytes.Buffer
+		if filesystem.CheckDos33Disk(&report, image) || !strings.Contains(report.String(), test.problem) {
+			t.Errorf("%s: check reported:\n%s", test.name, report.String())
+		}
+	}
+}
+
+// TestProdosDirectoryLoops checks that the directory walks stop at subdirectories linked into a loop
+// and at blocks linked twice into a directory, and refuse a directory header with entries too short.
+func TestProdosDirectoryLoops(t *testing.T) {
+	var image []byte = generateTestProdosImage()
+	addTestProdosSubdirectory(image, 0x02, "A", 0x04)
+	addTestProdosSubdirectory(image, 0x04, "B", 0x05)
+	// B links back to A
+	addTestProdosSubdirectory(image, 0x05, "A", 0x04)
+	var fileNames []string
+	var err error = filesystem.ListProdosDirectoryFiles(&fileNames, image, 0x02, "", make(map[int]bool))
+	if err != nil || strings.Join(fileNames, " ") != "A/ A/B/ A/B/A/" {
+		t.Errorf("listing gave %v, %v", fileNames, err)
+	}
+	var listing bytes.Buffer
+	err = filesystem.PrintProdosDirectory(&listing, image, 0x02, "/TEST", make(map[int]bool))
+	if err != nil || strings.Count(listing.String(), "NAME") != 3 {
+		t.Errorf("catalog gave %v:\n%s", err, listing.String())
+	}
+	var report bytes.Buffer
+	if filesystem.CheckProdosVolume(&report, image) || !strings.Contains(report.String(), "block 4 is used by both /TEST/A and /TEST/A/B/A") {
+		t.Errorf("check of the loop reported:\n%s", report.String())
+	}
+	// the volume directory links to itself as its next block
+	var loopedImage []byte = generateTestProdosImage()
+	diskimage.ProdosBlockOfImage(loopedImage, 0x02)[0x02] = 0x02
+	var entryCount int = 0
+	err = filesystem.ForEachProdosDirectoryEntry(loopedImage, 0x02, func(entry []byte, blockNum int) bool {
+		entryCount = entryCount + 1
+		return true
+	})
+	if err == nil || entryCount != 0x0D {
+		t.Errorf("walking a looped directory visited %d entries and gave %v", entryCount, err)
+	}
+	var damagedImage []byte = generateTestProdosImage()
+	diskimage.ProdosBlockOfImage(damagedImage, 0x02)[0x04+0x1F] = 0x10
+	var entry []byte
+	err = filesystem.FindProdosVolumeDirectoryEntry(&entry, damagedImage, "A")
+	if err == nil {
+		t.Errorf("searching a directory with entries of 16 bytes succeeded")
+	}
+}
+
+// TestProdosCatalogCounts checks that the catalog counts no more blocks than the image holds, and
+// refuses to count the free blocks of a volume bitmap lying beyond the end of the image.
+func TestProdosCatalogCounts(t *testing.T) {
+	var image []byte = generateTestProdosImage()
+	var listing bytes.Buffer
+	var err error = filesystem.PrintDiskImageCatalog(&listing, image)
+	if err != nil || !strings.Contains(listing.String(), "TOTAL BLOCKS:  280") {
+		t.Errorf("catalog gave %v:\n%s", err, listing.String())
+	}
+	var keyBlock []byte = diskimage.ProdosBlockOfImage(image, 0x02)
+	keyBlock[0x04+0x25] = 0xFF
+	keyBlock[0x04+0x26] = 0xFF
+	listing.Reset()
+	err = filesystem.PrintDiskImageCatalog(&listing, image)
+	if err != nil || !strings.Contains(listing.String(), "TOTAL BLOCKS:  280") {
+		t.Errorf("catalog of a volume counting 65535 blocks gave %v:\n%s", err, listing.String())
+	}
+	keyBlock[0x04+0x23] = 0xF0
+	keyBlock[0x04+0x24] = 0xFF
+	listing.Reset()
+	err = filesystem.PrintDiskImageCatalog(&listing, image)
+	if err == nil || strings.Contains(listing.String(), "BLOCKS FREE") {
+		t.Errorf("catalog of a bitmap beyond the image gave %v:\n%s", err, listing.String())
+	}
+}
+
+// generateTestWozImage returns a WOZ image of the given version (1 or 2) holding the tracks of the
+// diskImage (in DOS3.3 sector order) as DOS 3.3 formats them, each disk byte stored as 8 bits. Its TMAP
+// chunk maps the first mappedTracks tracks, and its TRKS chunk holds the first storedTracks of them.
+func generateTestWozImage(t *testing.T, diskImage []byte, version int, mappedTracks int, storedTracks int) []byte {
+	var trackMap []byte = bytes.Repeat([]byte{'\xFF'}, mappedTracks*4)
+	for trackNum := 0; trackNum < mappedTracks; trackNum = trackNum + 1 {
+		trackMap[trackNum*4] = byte(trackNum)
+	}
+	var wozImage []byte = []byte(fmt.Sprintf("WOZ%d\xFF\x0A\x0D\x0A\x00\x00\x00\x00", version))
+	wozImage = append(wozImage, []byte("TMAP")...)
+	wozImage = append(wozImage, byte(len(trackMap)), byte(len(trackMap)>>8), 0x00, 0x00)
+	wozImage = append(wozImage, trackMap...)
+	var trackNibbles []byte = make([]byte, gcr.NIB_TRACK_SIZE)
+	var tracks []byte
+	// the WOZ2 track data starts at the first block after the track entries
+	var dataStartPos int = (len(wozImage)+gcr.WOZ_CHUNK_HEADER_SIZE+storedTracks*gcr.WOZ2_TRACK_ENTRY_SIZE)/gcr.WOZ2_BLOCK_SIZE*gcr.WOZ2_BLOCK_SIZE + gcr.WOZ2_BLOCK_SIZE
+	var trackBlocks int = (gcr.NIB_TRACK_SIZE + gcr.WOZ2_BLOCK_SIZE - 1) / gcr.WOZ2_BLOCK_SIZE
+	if version == 2 {
+		for trackNum := 0; trackNum < storedTracks; trackNum = trackNum + 1 {
+			var startBlock int = dataStartPos/gcr.WOZ2_BLOCK_SIZE + trackNum*trackBlocks
+			var bitCount int = gcr.WOZ1_TRACK_BITS_SIZE * 8
+			tracks = append(tracks, byte(startBlock), byte(startBlock>>8), byte(trackBlocks), 0x00,
+				byte(bitCount), byte(bitCount>>8), byte(bitCount>>16), 0x00)
+		}
+		tracks = append(tracks, make([]byte, dataStartPos-len(wozImage)-gcr.WOZ_CHUNK_HEADER_SIZE-len(tracks))...)
+	}
+	for trackNum := 0; trackNum < storedTracks; trackNum = trackNum + 1 {
+		var err error = gcr.EncodeTrackNibbles(trackNibbles, diskImage, trackNum, '\xFE')
+		if err != nil {
+			t.Fatal(err)
+		}
+		var track []byte = make([]byte, gcr.WOZ1_TRACK_SIZE)
+		if version == 2 {
+			track = make([]byte, trackBlocks*gcr.WOZ2_BLOCK_SIZE)
+		}
+		copy(track, trackNibbles[:gcr.WOZ1_TRACK_BITS_SIZE])
+		if version == 1 {
+			track[gcr.WOZ1_TRACK_BITS_SIZE+2] = byte(gcr.WOZ1_TRACK_BITS_SIZE * 8 & 0xFF)
+			track[gcr.WOZ1_TRACK_BITS_SIZE+3] = byte(gcr.WOZ1_TRACK_BITS_SIZE * 8 >> 8)
+		}
+		tracks = append(tracks, track...)
+	}
+	wozImage = append(wozImage, []byte("TRKS")...)
+	wozImage = append(wozImage, byte(len(tracks)), byte(len(tracks)>>8), byte(len(tracks)>>16), 0x00)
+	return append(wozImage, tracks...)
+}
+
+// TestWozDecoding checks that the sectors decoded from WOZ1 and WOZ2 images are those of the image they
+// were made from, and that images whose chunks do not hold every track, or hold a track of no bits,
+// are refused.
+func TestWozDecoding(t *testing.T) {
+	var diskImage []byte = generateTestDiskImage()
+	var zeroBitImage []byte = generateTestWozImage(t, diskImage, 1, 0x23, 0x23)
+	// the bit count of the last track of a WOZ1 image ends the image
+	zeroBitImage[len(zeroBitImage)-gcr.WOZ1_TRACK_SIZE+gcr.WOZ1_TRACK_BITS_SIZE+2] = 0x00
+	zeroBitImage[len(zeroBitImage)-gcr.WOZ1_TRACK_SIZE+gcr.WOZ1_TRACK_BITS_SIZE+3] = 0x00
+	var tests = []struct {
+		name     string
+		wozImage []byte
+		failure  string
+	}{
+		{"WOZ1", generateTestWozImage(t, diskImage, 1, 0x23, 0x23), ""},
+		{"WOZ2", generateTestWozImage(t, diskImage, 2, 0x23, 0x23), ""},
+		{"WOZ1 short TMAP", generateTestWozImage(t, diskImage, 1, 0x10, 0x23), "TMAP chunk of 64 bytes does not map track 16"},
+		{"WOZ1 short TRKS", generateTestWozImage(t, diskImage, 1, 0x23, 0x20), "TRKS chunk of 212992 bytes does not hold track 32"},
+		// the unused WOZ2 track entries are zeros, giving tracks of no bits
+		{"WOZ2 unstored track", generateTestWozImage(t, diskImage, 2, 0x23, 0x20), "track 32: WOZ track holds no bits"},
+		{"WOZ1 track of no bits", zeroBitImage, "track 34: WOZ track holds no bits"},
+	}
+	for _, test := range tests {
+		var image []byte = test.wozImage
+		var err error = gcr.ConvertWozImageToDos33Order(&image, false)
+		if test.failure == "" && (err != nil || !bytes.Equal(image, diskImage)) {
+			t.Errorf("%s: decoding gave %v", test.name, err)
+		}
+		if test.failure != "" && (err == nil || !strings.Contains(err.Error(), test.failure)) {
+			t.Errorf("%s: decoding gave %v, not an error holding %q", test.name, err, test.failure)
+		}
+	}
+}
+
+// testGcrSectors returns sectors to round trip through a GCR encoding: all zeros, all ones, counting
+// up, and pseudo random bytes, which set every bit pattern of each packed group.
+func testGcrSectors() [][]byte {
+	var zeros []byte = make([]byte, 0x0100)
+	var ones []byte = bytes.Repeat([]byte{'\xFF'}, 0x0100)
+	var counting []byte = make([]byte, 0x0100)
+	var random []byte = make([]byte, 0x0100)
+	var value uint32 = 0x12345678
+	for i := 0; i < 0x0100; i = i + 1 {
+		counting[i] = byte(i)
+		value = value*1103515245 + 12345
+		random[i] = byte(value >> 16)
+	}
+	return [][]byte{zeros, ones, counting, random}
+}
+
+// TestGcr62RoundTrip checks that 6-and-2 encoded sectors decode to the same bytes, that a changed
+// disk byte fails the checksum, and that a whole image comes back from its nibble image.
+func TestGcr62RoundTrip(t *testing.T) {
+	var sectorBuffer [0x0100]byte
+	var failure string
+	for n, sector := range testGcrSectors() {
+		var nibbles []byte = gcr.EncodeGcr62Sector(sector)
+		if len(nibbles) != 0x0157 {
+			t.Fatalf("sector %d: %d disk bytes encoded", n, len(nibbles))
+		}
+		if !gcr.DecodeGcr62Sector(&sectorBuffer, &failure, nibbles) || !bytes.Equal(sectorBuffer[:], sector) {
+			t.Errorf("sector %d: decoding gave %q", n, failure)
+		}
+		nibbles[0x80] = gcr.GCR_62_WRITE_TABLE[(bytes.IndexByte(gcr.GCR_62_WRITE_TABLE[:], nibbles[0x80])+1)%0x40]
+		if gcr.DecodeGcr62Sector(&sectorBuffer, &failure, nibbles) || failure != "its data field failed its checksum" {
+			t.Errorf("sector %d: a changed disk byte gave %q", n, failure)
+		}
+	}
+	var diskImage []byte = generateTestDiskImage()
+	var image []byte = append([]byte{}, diskImage...)
+	var err error = gcr.ConvertDos33OrderImageToNibbleImage(&image)
+	if err != nil || len(image) != gcr.NIB_IMAGE_SIZE {
+		t.Fatalf("encoding gave %d bytes and %v", len(image), err)
+	}
+	err = gcr.ConvertNibbleImageToDos33Order(&image, false)
+	if err != nil || !bytes.Equal(image, diskImage) {
+		t.Errorf("decoding the nibble image gave %v", err)
+	}
+}
+
+// TestGcr53RoundTrip checks that 5-and-3 encoded sectors decode to the same bytes, that a changed
+// disk byte fails the checksum, and that a whole 13-sector image comes back from its nibble image.
+func TestGcr53RoundTrip(t *testing.T) {
+	var sectorBuffer [0x0100]byte
+	var failure string
+	for n, sector := range testGcrSectors() {
+		var nibbles []byte = gcr.EncodeGcr53Sector(sector)
+		if len(nibbles) != 0x019B {
+			t.Fatalf("sector %d: %d disk bytes encoded", n, len(nibbles))
+		}
+		if !gcr.DecodeGcr53Sector(&sectorBuffer, &failure, nibbles) || !bytes.Equal(sectorBuffer[:], sector) {
+			t.Errorf("sector %d: decoding gave %q", n, failure)
+		}
+		nibbles[0x80] = gcr.GCR_53_WRITE_TABLE[(bytes.IndexByte(gcr.GCR_53_WRITE_TABLE[:], nibbles[0x80])+1)%0x20]
+		if gcr.DecodeGcr53Sector(&sectorBuffer, &failure, nibbles) || failure != "its data field failed its checksum" {
+			t.Errorf("sector %d: a changed disk byte gave %q", n, failure)
+		}
+	}
+	var diskImage []byte = generateTestDiskImage()[:gcr.D13_IMAGE_SIZE]
+	var image []byte = append([]byte{}, diskImage...)
+	gcr.Convert13SectorImageToNibbleImage(&image)
+	if !gcr.NibbleImageIs13Sector(image) {
+		t.Fatalf("the nibble image is not recognized as 13-sector")
+	}
+	var err error = gcr.ConvertNibbleImageTo13SectorImage(&image, false)
+	if err != nil || !bytes.Equal(image, diskImage) {
+		t.Errorf("decoding the nibble image gave %v", err)
+	}
+}
+
+// generateTestTwoImgImage returns a 2MG image holding diskImage in ProDOS sector order, with the
+// flags given in its header.
+func generateTestTwoImgImage(diskImage []byte, flags uint32) []byte {
+	var header []byte = make([]byte, TWO_IMG_HEADER_SIZE)
+	copy(header, "2IMG")
+	binary.LittleEndian.PutUint32(header[0x0C:0x10], TWO_IMG_FORMAT_PRODOS_ORDER)
+	binary.LittleEndian.PutUint32(header[0x10:0x14], flags)
+	binary.LittleEndian.PutUint32(header[0x18:0x1C], TWO_IMG_HEADER_SIZE)
+	binary.LittleEndian.PutUint32(header[0x1C:0x20], uint32(len(diskImage)))
+	return append(header, diskImage...)
+}
+
+// TestTwoImgFlags checks that the volume number of a 2MG image is only taken when its flag is set,
+// and that a locked image is refused by the operations which change an image.
+func TestTwoImgFlags(t *testing.T) {
+	var diskImage []byte = generateTestDiskImage()
+	var tests = []struct {
+		name   string
+		flags  uint32
+		volume int
+		locked bool
+	}{
+		{"no flags", 0x00000000, -1, false},
+		{"volume not set", 0x000000FE, -1, false},
+		{"volume 10", 0x0000010A, 10, false},
+		{"locked volume 0", 0x80000100, 0, true},
+	}
+	for _, test := range tests {
+		var image []byte = generateTestTwoImgImage(diskImage, test.flags)
+		var isDos33Order bool
+		var err error = extractTwoImgData(&isDos33Order, &image)
+		if err != nil || isDos33Order || !bytes.Equal(image, diskImage) {
+			t.Fatalf("%s: extracting gave %v", test.name, err)
+		}
+		if diskImageVolume != test.volume || diskImageIsLocked != test.locked {
+			t.Errorf("%s: read volume %d and locked %v", test.name, diskImageVolume, diskImageIsLocked)
+		}
+		err = refuseLockedDiskImage("game.2mg", false)
+		var coded *failure.CodedError
+		if test.locked && !(errors.As(err, &coded) && coded.Kind == failure.KIND_LOCKED_IMAGE) {
+			t.Errorf("%s: a locked image gave %v", test.name, err)
+		}
+		if !test.locked && err != nil {
+			t.Errorf("%s: an unlocked image gave %v", test.name, err)
+		}
+	}
+}
+
+// TestImageEncryption checks that an encrypted image is read decrypted with its key file, whose
+// extension is left out of format detection, that another key or a changed file is refused, and that
+// an image written over an encrypted one is encrypted again.
+func TestImageEncryption(t *testing.T) {
+	imageDirectory, err := ioutil.TempDir("", "encryption")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(imageDirectory)
+	var keyFilepath string = filepath.Join(imageDirectory, "image.key")
+	var otherKeyFilepath string = filepath.Join(imageDirectory, "other.key")
+	if writeNewEncryptionKey(keyFilepath) != nil || writeNewEncryptionKey(otherKeyFilepath) != nil {
+		t.Fatal("the key files could not be made")
+	}
+	if writeNewEncryptionKey(keyFilepath) == nil {
+		t.Errorf("an existing key file was replaced")
+	}
+	var key, otherKey []byte
+	if readEncryptionKey(&key, keyFilepath) != nil || readEncryptionKey(&otherKey, otherKeyFilepath) != nil {
+		t.Fatal("the key files could not be read")
+	}
+	var diskImage []byte = generateTestProdosImage()
+	var encrypted []byte
+	err = encryptData(&encrypted, diskImage, key)
+	if err != nil || !isEncryptedData(encrypted) || bytes.Contains(encrypted, diskImage[0x0400:0x0500]) {
+		t.Fatalf("encrypting gave %v", err)
+	}
+	var decrypted []byte
+	if decryptData(&decrypted, encrypted, otherKey, "image") == nil {
+		t.Errorf("an image was decrypted with another key")
+	}
+	var changed []byte = append([]byte{}, encrypted...)
+	changed[len(changed)/2] ^= 0x01
+	if decryptData(&decrypted, changed, key, "image") == nil {
+		t.Errorf("a changed image was decrypted")
+	}
+	var imageFilepath string = filepath.Join(imageDirectory, "volume.po.enc")
+	err = ioutil.WriteFile(imageFilepath, encrypted, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	diskImageKeyFilepath = keyFilepath
+	defer func() { diskImageKeyFilepath = "" }()
+	var readImage []byte
+	err = readDiskImageFromFile(&readImage, imageFilepath)
+	if err != nil || !bytes.Equal(readImage, diskImage) {
+		t.Fatalf("reading the encrypted image gave %v", err)
+	}
+	readImage[0x0600] = readImage[0x0600] ^ 0xFF
+	err = writeDiskImageWithJournal(readImage, imageFilepath, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	written, err := ioutil.ReadFile(imageFilepath)
+	if err != nil || !isEncryptedData(written) || decryptData(&decrypted, written, key, "image") != nil || !bytes.Equal(decrypted, readImage) {
+		t.Errorf("the image written over the encrypted one was not encrypted again: %v", err)
+	}
+}
+
+
+
+
+
+
+
+
+
+// sendAdtproCommand sends the client command with the apple ][ text of name ended by a zero, when
+// name is not empty, and then the bytes of extra.
+func sendAdtproCommand(t *testing.T, client *protocol.AdtproHost, command byte, name string, extra ...byte) {
+	var message []byte = []byte{command}
+	if name != "" {
+		for _, c := range []byte(name) {
+			message = append(message, c|0x80)
+		}
+		message = append(message, 0x00)
+	}
+	_, err := client.Output.Write(append(message, extra...))
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// receiveAdtproBytes returns the next count bytes the host sends to client.
+func receiveAdtproBytes(t *testing.T, client *protocol.AdtproHost, count int) []byte {
+	var received []byte = make([]byte, count)
+	for i := range received {
+		var err error = client.Receive(&received[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return received
+}
+
+// TestAdtproHost checks the host side of the ADTPro client against a simulated client: the directory,
+// the size of an image, an image sent in ProDOS block order with a half block refused once, and a disk
+// received into an image in the sector order of its name, with a damaged half block sent again.
+func TestAdtproHost(t *testing.T) {
+	hostDirectory, err := ioutil.TempDir("", "adtpro")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(hostDirectory)
+	var diskImage []byte = generateTestProdosImage()
+	for i := 0x2000; i < len(diskImage); i = i + 1 {
+		diskImage[i] = byte(i * 7 / 5)
+	}
+	err = ioutil.WriteFile(filepath.Join(hostDirectory, "volume.po"), diskImage, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hostInput, clientOutput := io.Pipe()
+	clientInput, hostOutput := io.Pipe()
+	var host *protocol.AdtproHost = &protocol.AdtproHost{Input: make(chan byte, 0x0400), Output: hostOutput, Directory: hostDirectory, ReadImage: readAdtproHostImage, WriteImage: writeAdtproHostImage}
+	var client *protocol.AdtproHost = &protocol.AdtproHost{Input: make(chan byte, 0x0400), Output: clientOutput}
+	go protocol.ReadYmodemLinkInput(host.Input, hostInput)
+	go protocol.ReadYmodemLinkInput(client.Input, clientInput)
+	var served chan error = make(chan error, 1)
+	go func() { served <- protocol.ServeAdtproClient(host) }()
+
+	sendAdtproCommand(t, client, protocol.ADTPRO_COMMAND_DIRECTORY, "")
+	var page strings.Builder
+	for {
+		var b byte = receiveAdtproBytes(t, client, 1)[0]
+		if b == 0x00 {
+			break
+		}
+		page.WriteByte(b & 0x7F)
+	}
+	if !strings.Contains(page.String(), "volume.po") || receiveAdtproBytes(t, client, 1)[0] != 0x00 {
+		t.Errorf("the directory was sent as %q", page.String())
+	}
+
+	sendAdtproCommand(t, client, protocol.ADTPRO_COMMAND_SIZE, "volume.po")
+	if reply := receiveAdtproBytes(t, client, 3); !bytes.Equal(reply, []byte{0x18, 0x01, protocol.ADTPRO_REPLY_OK}) {
+		t.Errorf("the size was answered with % X", reply)
+	}
+	sendAdtproCommand(t, client, protocol.ADTPRO_COMMAND_SIZE, "missing.po")
+	if reply := receiveAdtproBytes(t, client, 3); reply[2] != protocol.ADTPRO_REPLY_NOT_FOUND {
+		t.Errorf("the size of a missing file was answered with % X", reply)
+	}
+
+	sendAdtproCommand(t, client, protocol.ADTPRO_COMMAND_GET, "volume.po")
+	if reply := receiveAdtproBytes(t, client, 1); reply[0] != protocol.ADTPRO_REPLY_OK {
+		t.Fatalf("getting the image was answered with % X", reply)
+	}
+	var received []byte
+	var refused bool = false
+	for len(received) < len(diskImage) {
+		var halfBlock [protocol.ADTPRO_HALF_BLOCK_SIZE]byte
+		var ok bool
+		err = client.ReceiveHalfBlock(&halfBlock, &ok)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var answer byte = protocol.YMODEM_ACK
+		if !ok || (len(received) == 0x1000 && !refused) {
+			answer = protocol.YMODEM_NAK
+			refused = true
+		}
+		client.Output.Write([]byte{answer})
+		if answer == protocol.YMODEM_ACK {
+			received = append(received, halfBlock[:]...)
+		}
+	}
+	if !bytes.Equal(received, diskImage) {
+		t.Errorf("the image was received changed")
+	}
+
+	sendAdtproCommand(t, client, protocol.ADTPRO_COMMAND_PUT, "volume.po", 0x18, 0x01)
+	if reply := receiveAdtproBytes(t, client, 1); reply[0] != protocol.ADTPRO_REPLY_EXISTS {
+		t.Errorf("putting over an existing file was answered with % X", reply)
+	}
+	sendAdtproCommand(t, client, protocol.ADTPRO_COMMAND_PUT, "copy.do", 0x18, 0x01)
+	if reply := receiveAdtproBytes(t, client, 1); reply[0] != protocol.ADTPRO_REPLY_OK {
+		t.Fatalf("putting a disk was answered with % X", reply)
+	}
+	for start := 0; start < len(diskImage); start = start + protocol.ADTPRO_HALF_BLOCK_SIZE {
+		if start == 0x4000 {
+			var damaged []byte = protocol.EncodeAdtproHalfBlock(diskImage[start : start+protocol.ADTPRO_HALF_BLOCK_SIZE])
+			client.Output.Write(append(damaged, 0x00, 0x00))
+			if reply := receiveAdtproBytes(t, client, 1); reply[0] != protocol.YMODEM_NAK {
+				t.Errorf("a damaged half block was answered with % X", reply)
+			}
+		}
+		err = client.SendHalfBlock(diskImage[start : start+protocol.ADTPRO_HALF_BLOCK_SIZE])
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	clientOutput.Close()
+	err = <-served
+	if err != nil {
+		t.Fatal(err)
+	}
+	written, err := ioutil.ReadFile(filepath.Join(hostDirectory, "copy.do"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = diskimage.ReorderDiskImageSectors(written, diskimage.SECTOR_ORDER_DOS, diskimage.SECTOR_ORDER_PRODOS)
+	if err != nil || !bytes.Equal(written, diskImage) {
+		t.Errorf("the disk was written changed: %v", err)
+	}
+}
+
+// testSos stands for SOS under the Apple /// client run on a 6502 emulator: bytes from the host are
+// read from input and bytes to it written to output as the .RS232 device, the console echoes into
+// console and returns a key at once, and the blocks written to .D1 go into disk. Writing the block
+// numbered protectedBlock fails once, as from a write protected disk.
+type testSos struct {
+	input          chan byte
+	output         chan byte
+	console        []byte
+	disk           []byte
+	protectedBlock int
+	terminated     bool
+}
+
+// call carries out the SOS call made by the break at the program counter of c, which is followed by
+// the call number and the address of its parameter list, and returns the SOS error code.
+func (s *testSos) call(c *emulator.Cpu6502) byte {
+	var params int = c.ReadWord(c.PC+2, c.PC+3)
+	var name = func(address int) string {
+		return string(c.Memory[address+1 : address+1+int(c.Memory[address])])
+	}
+	var buffer int = c.ReadWord(params+2, params+3)
+	var count int = c.ReadWord(params+4, params+5)
+	switch c.Memory[c.PC+1] {
+	case 0xC8: // OPEN
+		var refNum byte = map[string]byte{".CONSOLE": 1, ".RS232": 2}[name(c.ReadWord(params+1, params+2))]
+		if refNum == 0 {
+			return 0x46
+		}
+		c.Memory[params+3] = refNum
+	case 0xCA: // READ
+		if c.Memory[params+1] == 1 {
+			c.Memory[buffer] = 0x0D
+		} else {
+			b, ok := <-s.input
+			if !ok {
+				s.terminated = true
+				return 0x00
+			}
+			c.Memory[buffer] = b
+		}
+		c.Memory[params+6] = 0x01
+		c.Memory[params+7] = 0x00
+	case 0xCB: // WRITE
+		for i := 0; i < count; i = i + 1 {
+			if c.Memory[params+1] == 1 {
+				s.console = append(s.console, c.Memory[buffer+i])
+			} else {
+				s.output <- c.Memory[buffer+i]
+			}
+		}
+	case 0x84: // GET_DEV_NUM
+		if name(c.ReadWord(params+1, params+2)) != ".D1" {
+			return 0x10
+		}
+		c.Memory[params+3] = 0x01
+	case 0x83: // D_WRITE
+		var blockNum int = c.ReadWord(params+6, params+7)
+		if blockNum == s.protectedBlock {
+			s.protectedBlock = -1
+			return protocol.SOS_ERROR_WRITE_PROTECTED
+		}
+		copy(s.disk[blockNum*diskimage.PRODOS_BLOCK_SIZE:], c.Memory[buffer:buffer+count])
+	case 0x65: // TERMINATE
+		s.terminated = true
+	default:
+		return 0x01
+	}
+	return 0x00
+}
+
+// damagingWriter writes to w, changing a byte of the write numbered damagedWrite (counted from 1).
+type damagingWriter struct {
+	w            io.Writer
+	writeCount   int
+	damagedWrite int
+}
+
+func (d *damagingWriter) Write(p []byte) (int, error) {
+	d.writeCount = d.writeCount + 1
+	if d.writeCount == d.damagedWrite {
+		var damaged []byte = append([]byte{}, p...)
+		damaged[len(damaged)/2] = damaged[len(damaged)/2] ^ 0x01
+		return d.w.Write(damaged)
+	}
+	return d.w.Write(p)
+}
+
+// TestApple3Client checks that the client boot disk keeps the SOS files and the former interpreter,
+// and runs the client on the 6502 emulator under a simulated SOS against the host: a block damaged on
+// the line is sent again, a write protected disk stops the host at its block, and the disk is then
+// written from that block on.
+func TestApple3Client(t *testing.T) {
+	var sosImage []byte = generateTestProdosImage()
+	var err error = protocol.MakeSosClientImage(sosImage)
+	var coded *failure.CodedError
+	if !(errors.As(err, &coded) && coded.Kind == failure.KIND_NO_SOS_IMAGE) {
+		t.Errorf("an image without SOS was not refused: %v", err)
+	}
+	for _, fileName := range []string{"SOS.KERNEL", "SOS.DRIVER", "SOS.INTERP"} {
+		err = filesystem.AddProdosFile(sosImage, fileName, 0x0C, 0x0000, []byte(fileName), time.Now())
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = protocol.MakeSosClientImage(sosImage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var interpreter []byte
+	protocol.GenerateSosClientInterpreter(&interpreter)
+	var fileData []byte
+	var fileType byte
+	err = filesystem.ReadProdosFile(&fileData, &fileType, sosImage, "SOS.INTERP")
+	if err != nil || fileType != protocol.SOS_INTERPRETER_FILE_TYPE || !bytes.Equal(fileData, interpreter) {
+		t.Errorf("SOS.INTERP is not the client: %v", err)
+	}
+	err = filesystem.ReadProdosFile(&fileData, &fileType, sosImage, "SOS.INTERP.OLD")
+	if err != nil || string(fileData) != "SOS.INTERP" {
+		t.Errorf("the former interpreter was not kept: %v", err)
+	}
+	if string(interpreter[:0x08]) != "SOS NTRP" || len(interpreter) != 0x0E+protocol.SOS_CLIENT_SIZE {
+		t.Errorf("the interpreter header is % X", interpreter[:0x0E])
+	}
+
+	var diskImage []byte = make([]byte, diskimage.FLOPPY_IMAGE_SIZE)
+	for i := range diskImage {
+		diskImage[i] = byte(i*13/7 + i>>9)
+	}
+	var sos *testSos = &testSos{input: make(chan byte, 0x0400), output: make(chan byte, 0x0400), disk: make([]byte, diskimage.FLOPPY_IMAGE_SIZE), protectedBlock: 5}
+	clientInput, hostOutput := io.Pipe()
+	go protocol.ReadYmodemLinkInput(sos.input, clientInput)
+	var link *protocol.YmodemLink = &protocol.YmodemLink{Input: sos.output, Output: &damagingWriter{w: hostOutput, damagedWrite: 3}}
+	var c *emulator.Cpu6502 = &emulator.Cpu6502{}
+	copy(c.Memory[protocol.SOS_CLIENT_ADDRESS:], interpreter[0x0E:])
+	c.SP = 0xFF
+	c.P = emulator.FLAG_UNUSED | emulator.FLAG_INTERRUPT
+	c.PC = protocol.SOS_CLIENT_ADDRESS
+	var ran chan string = make(chan string, 1)
+	go func() {
+		for !sos.terminated {
+			if c.Memory[c.PC] == 0x00 {
+				c.A = sos.call(c)
+				c.SetNZ(c.A)
+				c.PC = c.PC + 4
+				continue
+			}
+			if !c.Step() {
+				ran <- fmt.Sprintf("stopped at the illegal opcode %02X at %04X", c.Memory[c.PC], c.PC)
+				return
+			}
+		}
+		ran <- "terminated"
+	}()
+
+	err = protocol.WriteApple3Disk(link, diskImage, 0)
+	if !(errors.As(err, &coded) && coded.Kind == failure.KIND_APPLE3_WRITE && strings.Contains(err.Error(), "block 5 ")) {
+		t.Errorf("a write protected disk gave %v", err)
+	}
+	err = protocol.WriteApple3Disk(link, diskImage, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stop := <-ran; stop != "terminated" {
+		t.Fatalf("the client %s", stop)
+	}
+	if !bytes.Equal(sos.disk, diskImage) {
+		t.Errorf("the disk was written changed")
+	}
+	if !strings.HasPrefix(string(sos.console), protocol.SOS_CLIENT_PROMPT) {
+		t.Errorf("the client showed %q", sos.console)
+	}
+}
+
+// queueRequest sends the request method path with body (when not empty) to the HTTP API of queue,
+// and returns the status of the reply, decoding its JSON body into reply.
+func queueRequest(t *testing.T, queue *transferQueue, method string, path string, body string, reply interface{}) int {
+	var request *http.Request = httptest.NewRequest(method, path, strings.NewReader(body))
+	var recorder *httptest.ResponseRecorder = httptest.NewRecorder()
+	queue.ServeHTTP(recorder, request)
+	if reply != nil {
+		var err error = json.Unmarshal(recorder.Body.Bytes(), reply)
+		if err != nil {
+			t.Fatalf("%s %s replied %q: %v", method, path, recorder.Body.String(), err)
+		}
+	}
+	return recorder.Code
+}
+
+// TestTransferQueueApi checks that transfers are enqueued, held back, reordered and removed through
+// the HTTP API of the queue, that the flags the daemon gives itself and unknown transfers are
+// refused, and that the queue is read back from its file with its running transfers queued again.
+func TestTransferQueueApi(t *testing.T) {
+	queueDirectory, err := ioutil.TempDir("", "queue")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(queueDirectory)
+	var queueFilepath string = filepath.Join(queueDirectory, "test.queue")
+	var queue *transferQueue = &transferQueue{}
+	err = readTransferQueue(queue, queueFilepath, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var transfer queuedTransfer
+	for _, args := range []string{`["-all-tracks", "a.po"]`, `["-tracks", "0-2", "b.po"]`, `["c.po", "17"]`} {
+		var status int = queueRequest(t, queue, "POST", "/transfers", `{"args": `+args+`}`, &transfer)
+		if status != http.StatusCreated || transfer.State != "queued" {
+			t.Errorf("enqueuing %s gave %d, %+v", args, status, transfer)
+		}
+	}
+	var report failure.ErrorReport
+	var status int = queueRequest(t, queue, "POST", "/transfers", `{"args": ["-resume", "a.po"]}`, &report)
+	if status != http.StatusBadRequest || report.Code != "bad_request" {
+		t.Errorf("enqueuing with -resume gave %d, %+v", status, report)
+	}
+	status = queueRequest(t, queue, "POST", "/transfers/9/pause", "", &report)
+	if status != http.StatusNotFound || report.Code != "transfer_not_found" {
+		t.Errorf("pausing an unknown transfer gave %d, %+v", status, report)
+	}
+	status = queueRequest(t, queue, "POST", "/transfers/2/pause", "", &transfer)
+	if status != http.StatusOK || transfer.State != "paused" {
+		t.Errorf("pausing a queued transfer gave %d, %+v", status, transfer)
+	}
+	queueRequest(t, queue, "POST", "/transfers/3/move", `{"position": 1}`, &transfer)
+	status = queueRequest(t, queue, "DELETE", "/transfers/1", "", nil)
+	if status != http.StatusNoContent {
+		t.Errorf("removing a queued transfer gave %d", status)
+	}
+	queue.Transfers[0].State = "running"
+	var transfers []queuedTransfer
+	queueRequest(t, queue, "GET", "/transfers", "", &transfers)
+	var states []string
+	for _, listed := range transfers {
+		states = append(states, fmt.Sprintf("%d %s", listed.Id, listed.State))
+	}
+	if strings.Join(states, ", ") != "3 running, 2 paused" {
+		t.Errorf("the queue listed %v", states)
+	}
+	queue.write()
+	var readQueue *transferQueue = &transferQueue{}
+	err = readTransferQueue(readQueue, queueFilepath, "")
+	if err != nil || len(readQueue.Transfers) != 2 || readQueue.Transfers[0].State != "queued" || readQueue.NextId != 4 {
+		t.Errorf("reading the queue back gave %+v, %v", readQueue, err)
+	}
+}
+
+// TestInstallArgs checks that the serial line of install arguments is found in each form of the flag,
+// and that the flags reserved for the program running the install are refused in each form.
+func TestInstallArgs(t *testing.T) {
+	var lines = []struct {
+		args []string
+		line string
+	}{
+		{[]string{"-port", "/dev/ttyUSB0", "-all-tracks", "a.po"}, "/dev/ttyUSB0"},
+		{[]string{"-all-tracks", "--tcp=wifi:23", "a.po"}, "wifi:23"},
+		{[]string{"-all-tracks", "a.po"}, ""},
+	}
+	for _, test := range lines {
+		if transferLine(test.args) != test.line {
+			t.Errorf("%v sends to %q, expected %q", test.args, transferLine(test.args), test.line)
+		}
+	}
+	for _, args := range [][]string{{"-resume", "a.po"}, {"--session=s", "a.po"}, {"-port", "/dev/ttyUSB0", "a.po"}, {}} {
+		if checkInstallArgs(args, DUPLICATE_RESERVED_FLAGS) == nil {
+			t.Errorf("%v was accepted", args)
+		}
+	}
+	var err error = checkInstallArgs([]string{"-baud", "9600", "-all-tracks", "a.po"}, DUPLICATE_RESERVED_FLAGS)
+	if err != nil {
+		t.Errorf("install arguments were refused: %v", err)
+	}
+}
+
+// TestSessionManifest checks that a session manifest is read with its steps, and that manifests with
+// unknown fields, steps both installing and running, reserved install flags or subcommands which are
+// not steps of a session are refused.
+func TestSessionManifest(t *testing.T) {
+	manifestDirectory, err := ioutil.TempDir("", "session")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(manifestDirectory)
+	var manifestFilepath string = filepath.Join(manifestDirectory, "session.json")
+	var read = func(text string) (sessionManifest, string, error) {
+		var manifest sessionManifest
+		var digest string
+		err := ioutil.WriteFile(manifestFilepath, []byte(text), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = readSessionManifest(&manifest, &digest, manifestFilepath)
+		return manifest, digest, err
+	}
+	manifest, digest, err := read(`{"install_args": ["-port", "/dev/ttyUSB0"], "steps": [
+		{"name": "blank", "prompt": "put in a blank disk", "install": ["-profile", "bootstrap", "blank.po"]},
+		{"name": "verify", "run": ["verify", "blank.po", "blank.po.sha256"]}]}`)
+	if err != nil || len(manifest.Steps) != 2 || manifest.Steps[0].Prompt != "put in a blank disk" || len(digest) != 64 {
+		t.Errorf("the manifest was read as %+v with digest %q: %v", manifest, digest, err)
+	}
+	for _, text := range []string{
+		`{"steps": []}`,
+		`{"steps": [{"install": ["a.po"]}], "retries": 3}`,
+		`{"steps": [{"install": ["a.po"], "run": ["check", "a.po"]}]}`,
+		`{"steps": [{"name": "empty"}]}`,
+		`{"steps": [{"install": ["-resume", "a.po"]}]}`,
+		`{"steps": [{"run": ["daemon", "-port", "/dev/ttyUSB0"]}]}`,
+	} {
+		_, _, err = read(text)
+		var coded *failure.CodedError
+		if !(errors.As(err, &coded) && coded.Kind == failure.KIND_BAD_SESSION_MANIFEST) {
+			t.Errorf("%s was not refused as a bad manifest: %v", text, err)
+		}
+	}
+}
+
+// TestArgumentCount checks that a subcommand taking one disk image refuses no argument and extra
+// arguments after it.
+func TestArgumentCount(t *testing.T) {
+	for _, args := range [][]string{{}, {"-track", "1", "a.po", "b.po"}} {
+		var flags *flag.FlagSet = newSubcommandFlagSet("hexdump", "diskImageFilepath")
+		flags.Int("track", 0, "")
+		flags.Parse(args)
+		if checkArgumentCount(flags, 1, 1) == nil {
+			t.Errorf("%v was accepted", args)
+		}
+	}
+	var flags *flag.FlagSet = newSubcommandFlagSet("hexdump", "diskImageFilepath")
+	flags.Parse([]string{"a.po"})
+	var err error = checkArgumentCount(flags, 1, 1)
+	if err != nil {
+		t.Errorf("a.po was refused: %v", err)
+	}
+}
+
+// TestKeepalive checks that keepalive spaces are written only at the start of a line, in lines short
+// of the monitor input line limit, without counting them as characters of the command stream.
+func TestKeepalive(t *testing.T) {
+	var output bytes.Buffer
+	var w commandStreamWriter = commandStreamWriter{output: &output, lineEnding: '\r', atLineStart: true}
+	for i := 0; i < 241; i = i + 1 {
+		w.writeKeepalive()
+	}
+	w.endKeepalive()
+	var expected string = strings.Repeat(" ", 240) + "\r \r"
+	if output.String() != expected || w.charCount != 0 || w.lineCount != 0 {
+		t.Errorf("keepalive wrote %q (%d characters, %d lines counted)", output.String(), w.charCount, w.lineCount)
+	}
+	output.Reset()
+	fmt.Fprintf(&w, "300:00")
+	w.writeKeepalive()
+	w.endKeepalive()
+	if output.String() != "300:00" {
+		t.Errorf("keepalive within a line wrote %q", output.String())
+	}
+}
+
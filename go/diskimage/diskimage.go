@@ -0,0 +1,268 @@
+/*
+apple2disk diskimage package
+Copyright (C) 2024 github user bassjack1 <147515670+bassjack1@users.noreply.github.com>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+Communication with the author can be done via tagging @bassjack1 in github.com issues or by composing
+private messages to user bassjack1 on reddit.com : https://www.reddit.com/message/compose/
+*/
+
+// Package diskimage gives access to the tracks, sectors and blocks of 140K Apple ][ disk images and
+// reorders their sectors between the DOS 3.3, ProDOS, Pascal, CP/M and physical orders.
+package diskimage
+
+import "fmt"
+import "github.com/bassjack1/apple2disk/go/failure"
+
+// Sector suffling section begin
+
+// ErrIllegalTrackOrSector is wrapped by the errors of the sector accessors for a track or sector
+// outside the disk image.
+var ErrIllegalTrackOrSector error = failure.CodedErrorf(failure.KIND_ILLEGAL_TRACK_OR_SECTOR, "illegal track or sector")
+
+// sectorError returns err with the operation (such as "reading") done on the sector at track,sector,
+// which it concerns.
+func sectorError(operation string, track int, sector int, err error) error {
+	return failure.SectorErrorf(failure.ErrorKind{}, track, sector, "%s track %d sector %d: %w", operation, track, sector, err)
+}
+
+// checkSectorInImage returns an error wrapping ErrIllegalTrackOrSector when the sector at track,sector
+// is not entirely inside diskImage, for operation.
+func checkSectorInImage(diskImage []byte, track int, sector int, operation string) error {
+	if track < 0 || sector < 0 || sector > 0x0F || DiskImageStartPosOfTrackSector(track, sector)+0x0100 > len(diskImage) {
+		return sectorError(operation, track, sector, ErrIllegalTrackOrSector)
+	}
+	return nil
+}
+
+// ReadSectorDataToBuffer fills the sectorBuffer slice with one sector of data
+// from diskImage starting at the offset for track,sector. It returns an error when the sector is not
+// in diskImage.
+func ReadSectorDataToBuffer(sectorBuffer *[0x0100]byte, diskImage []byte, track int, sector int) error {
+	var err error = checkSectorInImage(diskImage, track, sector, "reading")
+	if err != nil {
+		return err
+	}
+	var sourceBytesPos int = DiskImageStartPosOfTrackSector(track, sector)
+	var destinationPos int = 0
+	for destinationPos < 0x0100 {
+		sectorBuffer[destinationPos] = diskImage[sourceBytesPos]
+		destinationPos = destinationPos + 1
+		sourceBytesPos = sourceBytesPos + 1
+	}
+	return nil
+}
+
+// WriteSectorDataFromBuffer overwrites one sector of data in diskImage starting at the
+// offset for track,sector with the data stored in the sectorBuffer. It returns an error when the
+// sector is not in diskImage.
+func WriteSectorDataFromBuffer(sectorBuffer *[0x0100]byte, diskImage []byte, track int, sector int) error {
+	var err error = checkSectorInImage(diskImage, track, sector, "writing")
+	if err != nil {
+		return err
+	}
+	var destinationBytesPos int = DiskImageStartPosOfTrackSector(track, sector)
+	var sourcePos int = 0
+	for sourcePos < 0x0100 {
+		diskImage[destinationBytesPos] = sectorBuffer[sourcePos]
+		sourcePos = sourcePos + 1
+		destinationBytesPos = destinationBytesPos + 1
+	}
+	return nil
+}
+
+// ConvertDiskImageFromProdosOrderToDos33Order reorders the content of the passed in DiskImage by
+// rearranging the sectors of each track from ProDOS sector order into DOS3.3 sector order, with the
+// permutation of generateSectorPermutation. Several attempts at reordering were made before the
+// tables were understood. Some of the online references which were helpful towards understanding the
+// issue were:
+// https://stason.org/TULARC/pc/apple2/faq/10-006-What-are-DSK-PO-DO-HDV-NIB-and-2MG-disk-image.html
+// https://retrocomputing.stackexchange.com/questions/85/whats-the-difference-between-dos-ordered-and-prodos-ordered-disk-images
+// https://nerdlypleasures.blogspot.com/2021/02/the-woz-format-accurate-preservation-of.html
+// https://comp.sys.apple2.narkive.com/JY05JygH/reference-for-layout-of-prodos-and-dos-3-3-sector-ordering
+// https://retrocomputing.stackexchange.com/questions/15056/converting-apple-ii-prodos-blocks-to-dos-tracks-and-sectors
+// The last article has a comment by user "fadden" pointing to ciderpress code here:
+// https://github.com/fadden/ciderpress/blob/master/diskimg/DiskImg.cpp
+// This code was the most informative, although the issue is still confusing.
+// Some of the documentation claims that prodos physical sector arrangment on the
+// disk is non-sequential. However a nibble stream editor for tracks showed that
+// a ProDOS formatted or a DOS3.3 formatted disk had the same physical sector ordering:
+// 0x00,0x01,0x02,0x03,0x04,0x05,0x06,0x07,0x08,0x09,0x0A,0x0B,0x0C,0x0D,0x0E,0x0F
+// But the translation of logical blocks (512 bytes per block) into sector pairs is
+// somewhat opaque. It seems as though there is a re-ordering of sectors under prodos
+// which differs from the web references above, or the .PO file format is not actually in 
+// logical bock sequential order. The order which worked here is to write each track (16
+// 256 byte sectors) in this physical sector ordering:
+// 0x00,0x0E,0x0D,0x0C,0x0B,0x0A,0x09,0x08,0x07,0x06,0x05,0x04,0x03,0x02,0x01,0x0F
+// which is what the ProDOS and DOS3.3 tables of SECTOR_INTERLEAVES give (the permutation swaps pairs
+// of sectors, so it is its own inverse; ConvertDiskImageFromDos33OrderToProdosOrder names the reverse).
+func ConvertDiskImageFromProdosOrderToDos33Order(diskImage []byte) error {
+	return ReorderDiskImageSectors(diskImage, SECTOR_ORDER_PRODOS, SECTOR_ORDER_DOS)
+}
+
+// ConvertDiskImageFromDos33OrderToProdosOrder reorders the content of the passed in DiskImage from
+// DOS3.3 sector order, such as the tracks undump reads from a disk, back into ProDOS sector order. It
+// undoes ConvertDiskImageFromProdosOrderToDos33Order.
+func ConvertDiskImageFromDos33OrderToProdosOrder(diskImage []byte) error {
+	return ReorderDiskImageSectors(diskImage, SECTOR_ORDER_DOS, SECTOR_ORDER_PRODOS)
+}
+
+// SectorOrder names an order the 16 sectors of each track of a 140K disk image may be in.
+type SectorOrder string
+
+// The sector orders of SECTOR_INTERLEAVES. The ProDOS order is also used by Apple Pascal, and the
+// physical order keeps the sectors as they are on the disk.
+const (
+	SECTOR_ORDER_DOS      SectorOrder = "dos"
+	SECTOR_ORDER_PRODOS   SectorOrder = "prodos"
+	SECTOR_ORDER_PASCAL   SectorOrder = "pascal"
+	SECTOR_ORDER_CPM      SectorOrder = "cpm"
+	SECTOR_ORDER_PHYSICAL SectorOrder = "physical"
+)
+
+// SECTOR_INTERLEAVES maps each sector order a disk image may be in to the physical sector (as
+// numbered in the address field on the disk) holding each of the 16 sectors of a track in that
+// order. These are the tables of the ciderpress code mentioned above.
+var SECTOR_INTERLEAVES map[SectorOrder][0x10]int = map[SectorOrder][0x10]int{
+	SECTOR_ORDER_DOS:      {0x00, 0x0D, 0x0B, 0x09, 0x07, 0x05, 0x03, 0x01, 0x0E, 0x0C, 0x0A, 0x08, 0x06, 0x04, 0x02, 0x0F},
+	SECTOR_ORDER_PRODOS:   {0x00, 0x02, 0x04, 0x06, 0x08, 0x0A, 0x0C, 0x0E, 0x01, 0x03, 0x05, 0x07, 0x09, 0x0B, 0x0D, 0x0F},
+	SECTOR_ORDER_PASCAL:   {0x00, 0x02, 0x04, 0x06, 0x08, 0x0A, 0x0C, 0x0E, 0x01, 0x03, 0x05, 0x07, 0x09, 0x0B, 0x0D, 0x0F},
+	SECTOR_ORDER_CPM:      {0x00, 0x03, 0x06, 0x09, 0x0C, 0x0F, 0x02, 0x05, 0x08, 0x0B, 0x0E, 0x01, 0x04, 0x07, 0x0A, 0x0D},
+	SECTOR_ORDER_PHYSICAL: {0x00, 0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08, 0x09, 0x0A, 0x0B, 0x0C, 0x0D, 0x0E, 0x0F}}
+
+// invertSectorPermutation stores into inverse the permutation of the 16 sectors of a track which
+// undoes permutation, which gives the place each sector is moved to.
+func invertSectorPermutation(inverse *[0x10]int, permutation [0x10]int) {
+	for sector, movedSector := range permutation {
+		inverse[movedSector] = sector
+	}
+}
+
+// generateSectorPermutation stores into permutation the place in the sector order to of each of the
+// 16 sectors of a track in the sector order from: the sector holding the same physical sector. Going
+// from the physical sectors of one order to those of the other, it is the table of from followed by
+// the inverse of the table of to, so the permutation from to back to from is its inverse. It returns
+// an error when either order is not one of SECTOR_INTERLEAVES.
+func generateSectorPermutation(permutation *[0x10]int, from SectorOrder, to SectorOrder) error {
+	fromPhysicalSectors, found := SECTOR_INTERLEAVES[from]
+	if !found {
+		return failure.CodedErrorf(failure.KIND_UNKNOWN_VALUE, "unknown sector interleave: %s", from)
+	}
+	toPhysicalSectors, found := SECTOR_INTERLEAVES[to]
+	if !found {
+		return failure.CodedErrorf(failure.KIND_UNKNOWN_VALUE, "unknown sector interleave: %s", to)
+	}
+	var toSectorOfPhysicalSector [0x10]int
+	invertSectorPermutation(&toSectorOfPhysicalSector, toPhysicalSectors)
+	for fromSector, physicalSector := range fromPhysicalSectors {
+		permutation[fromSector] = toSectorOfPhysicalSector[physicalSector]
+	}
+	return nil
+}
+
+// SectorPermutation returns the place in the sector order to of each of the 16 sectors of a track in
+// the sector order from, as ReorderSectors moves them.
+func SectorPermutation(from SectorOrder, to SectorOrder) ([0x10]int, error) {
+	var permutation [0x10]int
+	var err error = generateSectorPermutation(&permutation, from, to)
+	return permutation, err
+}
+
+// InverseSectorPermutation returns the permutation undoing SectorPermutation(from, to): the place in
+// the sector order from of each of the 16 sectors of a track in the sector order to. It is the same
+// as SectorPermutation(to, from).
+func InverseSectorPermutation(from SectorOrder, to SectorOrder) ([0x10]int, error) {
+	var inverse [0x10]int
+	permutation, err := SectorPermutation(from, to)
+	if err != nil {
+		return inverse, err
+	}
+	invertSectorPermutation(&inverse, permutation)
+	return inverse, nil
+}
+
+// ReorderSectors returns a copy of image with the sectors of each of its first 35 tracks moved from
+// the sector order from into the sector order to, as SectorPermutation gives them, leaving image as it
+// is. Anything after the 35 tracks is copied unchanged. It returns an error when either order is
+// unknown or image is shorter than 35 tracks.
+func ReorderSectors(image []byte, from SectorOrder, to SectorOrder) ([]byte, error) {
+	permutation, err := SectorPermutation(from, to)
+	if err != nil {
+		return nil, err
+	}
+	if len(image) < FLOPPY_IMAGE_SIZE {
+		return nil, fmt.Errorf("image of %d bytes is shorter than the %d bytes of 35 tracks", len(image), FLOPPY_IMAGE_SIZE)
+	}
+	var reordered []byte = make([]byte, len(image))
+	copy(reordered, image)
+	for track := 0x00; track < 0x23; track = track + 1 {
+		for fromSector, toSector := range permutation {
+			var fromPos int = DiskImageStartPosOfTrackSector(track, fromSector)
+			var toPos int = DiskImageStartPosOfTrackSector(track, toSector)
+			copy(reordered[toPos:toPos+0x0100], image[fromPos:fromPos+0x0100])
+		}
+	}
+	return reordered, nil
+}
+
+// ReorderedDiskImageSectors stores into reordered a copy of diskImage with its sectors moved from the
+// sector order fromInterleave into the sector order toInterleave, as ReorderSectors does, and returns
+// the error of ReorderSectors when either order is unknown or diskImage is shorter than 35 tracks.
+func ReorderedDiskImageSectors(reordered *[]byte, diskImage []byte, fromInterleave SectorOrder, toInterleave SectorOrder) error {
+	var err error
+	*reordered, err = ReorderSectors(diskImage, fromInterleave, toInterleave)
+	return err
+}
+
+// ReorderDiskImageSectors rearranges the sectors of each of the 35 tracks of diskImage in place, from
+// the sector order fromInterleave into the sector order toInterleave, as ReorderedDiskImageSectors
+// does for a copy. It returns the error of ReorderedDiskImageSectors, leaving diskImage as it is.
+func ReorderDiskImageSectors(diskImage []byte, fromInterleave SectorOrder, toInterleave SectorOrder) error {
+	var reordered []byte
+	var err error = ReorderedDiskImageSectors(&reordered, diskImage, fromInterleave, toInterleave)
+	if err != nil {
+		return err
+	}
+	copy(diskImage, reordered)
+	return nil
+}
+
+// DiskImageStartPosOfTrackSector returns an integer offset corresponding to the start of a
+// specified track/sector in a raw disk image.  trackNum must be in [0,34], sectorNum must be in [0,15].
+func DiskImageStartPosOfTrackSector(trackNum int, sectorNum int) int {
+	//     0x000TTSBB              0x000TTSBB
+	return 0x00001000 * trackNum + 0x00000100 * sectorNum
+}
+
+// FLOPPY_IMAGE_SIZE is the size of a 35 track, 16 sector floppy disk image (280 ProDOS blocks).
+const FLOPPY_IMAGE_SIZE = 0x23000
+
+// PRODOS_BLOCK_SIZE is the size of one ProDOS logical block.
+const PRODOS_BLOCK_SIZE = 0x0200
+
+// Dos33SectorOfImage returns the 256 bytes of sector sector of track track in a diskImage which is
+// in DOS3.3 sector order.
+func Dos33SectorOfImage(diskImage []byte, track int, sector int) []byte {
+	var startPos int = DiskImageStartPosOfTrackSector(track, sector)
+	return diskImage[startPos : startPos+0x0100]
+}
+
+// ProdosBlockOfImage returns the 512 bytes of block blockNum of the ProDOS ordered diskImage.
+func ProdosBlockOfImage(diskImage []byte, blockNum int) []byte {
+	var startPos int = blockNum * PRODOS_BLOCK_SIZE
+	return diskImage[startPos : startPos+PRODOS_BLOCK_SIZE]
+}
+
+// Sector suffling section end
@@ -0,0 +1,483 @@
+/*
+apple2disk emulator package
+Copyright (C) 2024 github user bassjack1 <147515670+bassjack1@users.noreply.github.com>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+Communication with the author can be done via tagging @bassjack1 in github.com issues or by composing
+private messages to user bassjack1 on reddit.com : https://www.reddit.com/message/compose/
+*/
+
+// Package emulator is a 6502 processor emulator, running client machine code the way the Apple ][
+// would, with traps standing in for monitor and operating system routines.
+package emulator
+
+import "fmt"
+import "time"
+
+// 6502 section begin
+
+// The addressing modes of the 6502 instructions.
+const (
+	MODE_IMPLIED = iota
+	MODE_ACCUMULATOR
+	MODE_IMMEDIATE
+	MODE_ZERO_PAGE
+	MODE_ZERO_PAGE_X
+	MODE_ZERO_PAGE_Y
+	MODE_ABSOLUTE
+	MODE_ABSOLUTE_X
+	MODE_ABSOLUTE_Y
+	MODE_INDIRECT
+	MODE_INDEXED_INDIRECT
+	MODE_INDIRECT_INDEXED
+	MODE_RELATIVE
+)
+
+// The bits of the 6502 processor status register.
+const (
+	FLAG_CARRY     = 0x01
+	FLAG_ZERO      = 0x02
+	FLAG_INTERRUPT = 0x04
+	FLAG_DECIMAL   = 0x08
+	FLAG_BREAK     = 0x10
+	FLAG_UNUSED    = 0x20
+	FLAG_OVERFLOW  = 0x40
+	FLAG_NEGATIVE  = 0x80
+)
+
+// opcode6502 is the instruction and addressing mode of an opcode.
+type opcode6502 struct {
+	name string
+	mode int
+}
+
+// OPCODES_6502 maps each documented opcode of the NMOS 6502 to its instruction and addressing mode.
+var OPCODES_6502 map[byte]opcode6502 = map[byte]opcode6502{
+	0x69: {"ADC", MODE_IMMEDIATE}, 0x65: {"ADC", MODE_ZERO_PAGE}, 0x75: {"ADC", MODE_ZERO_PAGE_X}, 0x6D: {"ADC", MODE_ABSOLUTE}, 0x7D: {"ADC", MODE_ABSOLUTE_X}, 0x79: {"ADC", MODE_ABSOLUTE_Y}, 0x61: {"ADC", MODE_INDEXED_INDIRECT}, 0x71: {"ADC", MODE_INDIRECT_INDEXED},
+	0x29: {"AND", MODE_IMMEDIATE}, 0x25: {"AND", MODE_ZERO_PAGE}, 0x35: {"AND", MODE_ZERO_PAGE_X}, 0x2D: {"AND", MODE_ABSOLUTE}, 0x3D: {"AND", MODE_ABSOLUTE_X}, 0x39: {"AND", MODE_ABSOLUTE_Y}, 0x21: {"AND", MODE_INDEXED_INDIRECT}, 0x31: {"AND", MODE_INDIRECT_INDEXED},
+	0x0A: {"ASL", MODE_ACCUMULATOR}, 0x06: {"ASL", MODE_ZERO_PAGE}, 0x16: {"ASL", MODE_ZERO_PAGE_X}, 0x0E: {"ASL", MODE_ABSOLUTE}, 0x1E: {"ASL", MODE_ABSOLUTE_X},
+	0x90: {"BCC", MODE_RELATIVE}, 0xB0: {"BCS", MODE_RELATIVE}, 0xF0: {"BEQ", MODE_RELATIVE}, 0x30: {"BMI", MODE_RELATIVE},
+	0xD0: {"BNE", MODE_RELATIVE}, 0x10: {"BPL", MODE_RELATIVE}, 0x50: {"BVC", MODE_RELATIVE}, 0x70: {"BVS", MODE_RELATIVE},
+	0x24: {"BIT", MODE_ZERO_PAGE}, 0x2C: {"BIT", MODE_ABSOLUTE},
+	0x00: {"BRK", MODE_IMPLIED},
+	0x18: {"CLC", MODE_IMPLIED}, 0xD8: {"CLD", MODE_IMPLIED}, 0x58: {"CLI", MODE_IMPLIED}, 0xB8: {"CLV", MODE_IMPLIED},
+	0xC9: {"CMP", MODE_IMMEDIATE}, 0xC5: {"CMP", MODE_ZERO_PAGE}, 0xD5: {"CMP", MODE_ZERO_PAGE_X}, 0xCD: {"CMP", MODE_ABSOLUTE}, 0xDD: {"CMP", MODE_ABSOLUTE_X}, 0xD9: {"CMP", MODE_ABSOLUTE_Y}, 0xC1: {"CMP", MODE_INDEXED_INDIRECT}, 0xD1: {"CMP", MODE_INDIRECT_INDEXED},
+	0xE0: {"CPX", MODE_IMMEDIATE}, 0xE4: {"CPX", MODE_ZERO_PAGE}, 0xEC: {"CPX", MODE_ABSOLUTE},
+	0xC0: {"CPY", MODE_IMMEDIATE}, 0xC4: {"CPY", MODE_ZERO_PAGE}, 0xCC: {"CPY", MODE_ABSOLUTE},
+	0xC6: {"DEC", MODE_ZERO_PAGE}, 0xD6: {"DEC", MODE_ZERO_PAGE_X}, 0xCE: {"DEC", MODE_ABSOLUTE}, 0xDE: {"DEC", MODE_ABSOLUTE_X},
+	0xCA: {"DEX", MODE_IMPLIED}, 0x88: {"DEY", MODE_IMPLIED},
+	0x49: {"EOR", MODE_IMMEDIATE}, 0x45: {"EOR", MODE_ZERO_PAGE}, 0x55: {"EOR", MODE_ZERO_PAGE_X}, 0x4D: {"EOR", MODE_ABSOLUTE}, 0x5D: {"EOR", MODE_ABSOLUTE_X}, 0x59: {"EOR", MODE_ABSOLUTE_Y}, 0x41: {"EOR", MODE_INDEXED_INDIRECT}, 0x51: {"EOR", MODE_INDIRECT_INDEXED},
+	0xE6: {"INC", MODE_ZERO_PAGE}, 0xF6: {"INC", MODE_ZERO_PAGE_X}, 0xEE: {"INC", MODE_ABSOLUTE}, 0xFE: {"INC", MODE_ABSOLUTE_X},
+	0xE8: {"INX", MODE_IMPLIED}, 0xC8: {"INY", MODE_IMPLIED},
+	0x4C: {"JMP", MODE_ABSOLUTE}, 0x6C: {"JMP", MODE_INDIRECT},
+	0x20: {"JSR", MODE_ABSOLUTE},
+	0xA9: {"LDA", MODE_IMMEDIATE}, 0xA5: {"LDA", MODE_ZERO_PAGE}, 0xB5: {"LDA", MODE_ZERO_PAGE_X}, 0xAD: {"LDA", MODE_ABSOLUTE}, 0xBD: {"LDA", MODE_ABSOLUTE_X}, 0xB9: {"LDA", MODE_ABSOLUTE_Y}, 0xA1: {"LDA", MODE_INDEXED_INDIRECT}, 0xB1: {"LDA", MODE_INDIRECT_INDEXED},
+	0xA2: {"LDX", MODE_IMMEDIATE}, 0xA6: {"LDX", MODE_ZERO_PAGE}, 0xB6: {"LDX", MODE_ZERO_PAGE_Y}, 0xAE: {"LDX", MODE_ABSOLUTE}, 0xBE: {"LDX", MODE_ABSOLUTE_Y},
+	0xA0: {"LDY", MODE_IMMEDIATE}, 0xA4: {"LDY", MODE_ZERO_PAGE}, 0xB4: {"LDY", MODE_ZERO_PAGE_X}, 0xAC: {"LDY", MODE_ABSOLUTE}, 0xBC: {"LDY", MODE_ABSOLUTE_X},
+	0x4A: {"LSR", MODE_ACCUMULATOR}, 0x46: {"LSR", MODE_ZERO_PAGE}, 0x56: {"LSR", MODE_ZERO_PAGE_X}, 0x4E: {"LSR", MODE_ABSOLUTE}, 0x5E: {"LSR", MODE_ABSOLUTE_X},
+	0xEA: {"NOP", MODE_IMPLIED},
+	0x09: {"ORA", MODE_IMMEDIATE}, 0x05: {"ORA", MODE_ZERO_PAGE}, 0x15: {"ORA", MODE_ZERO_PAGE_X}, 0x0D: {"ORA", MODE_ABSOLUTE}, 0x1D: {"ORA", MODE_ABSOLUTE_X}, 0x19: {"ORA", MODE_ABSOLUTE_Y}, 0x01: {"ORA", MODE_INDEXED_INDIRECT}, 0x11: {"ORA", MODE_INDIRECT_INDEXED},
+	0x48: {"PHA", MODE_IMPLIED}, 0x08: {"PHP", MODE_IMPLIED}, 0x68: {"PLA", MODE_IMPLIED}, 0x28: {"PLP", MODE_IMPLIED},
+	0x2A: {"ROL", MODE_ACCUMULATOR}, 0x26: {"ROL", MODE_ZERO_PAGE}, 0x36: {"ROL", MODE_ZERO_PAGE_X}, 0x2E: {"ROL", MODE_ABSOLUTE}, 0x3E: {"ROL", MODE_ABSOLUTE_X},
+	0x6A: {"ROR", MODE_ACCUMULATOR}, 0x66: {"ROR", MODE_ZERO_PAGE}, 0x76: {"ROR", MODE_ZERO_PAGE_X}, 0x6E: {"ROR", MODE_ABSOLUTE}, 0x7E: {"ROR", MODE_ABSOLUTE_X},
+	0x40: {"RTI", MODE_IMPLIED}, 0x60: {"RTS", MODE_IMPLIED},
+	0xE9: {"SBC", MODE_IMMEDIATE}, 0xE5: {"SBC", MODE_ZERO_PAGE}, 0xF5: {"SBC", MODE_ZERO_PAGE_X}, 0xED: {"SBC", MODE_ABSOLUTE}, 0xFD: {"SBC", MODE_ABSOLUTE_X}, 0xF9: {"SBC", MODE_ABSOLUTE_Y}, 0xE1: {"SBC", MODE_INDEXED_INDIRECT}, 0xF1: {"SBC", MODE_INDIRECT_INDEXED},
+	0x38: {"SEC", MODE_IMPLIED}, 0xF8: {"SED", MODE_IMPLIED}, 0x78: {"SEI", MODE_IMPLIED},
+	0x85: {"STA", MODE_ZERO_PAGE}, 0x95: {"STA", MODE_ZERO_PAGE_X}, 0x8D: {"STA", MODE_ABSOLUTE}, 0x9D: {"STA", MODE_ABSOLUTE_X}, 0x99: {"STA", MODE_ABSOLUTE_Y}, 0x81: {"STA", MODE_INDEXED_INDIRECT}, 0x91: {"STA", MODE_INDIRECT_INDEXED},
+	0x86: {"STX", MODE_ZERO_PAGE}, 0x96: {"STX", MODE_ZERO_PAGE_Y}, 0x8E: {"STX", MODE_ABSOLUTE},
+	0x84: {"STY", MODE_ZERO_PAGE}, 0x94: {"STY", MODE_ZERO_PAGE_X}, 0x8C: {"STY", MODE_ABSOLUTE},
+	0xAA: {"TAX", MODE_IMPLIED}, 0xA8: {"TAY", MODE_IMPLIED}, 0xBA: {"TSX", MODE_IMPLIED}, 0x8A: {"TXA", MODE_IMPLIED}, 0x9A: {"TXS", MODE_IMPLIED}, 0x98: {"TYA", MODE_IMPLIED}}
+
+// MONITOR_RETURN_ADDRESS is the address in the monitor a program returns to when run by RunMachineCode,
+// as it would return into the monitor after the G command.
+const MONITOR_RETURN_ADDRESS = 0xFF69
+
+// cpu6502 is a 6502 processor running the machine language programs of this program on a 64K memory,
+// for checking them without an apple ][. A trap stands for the subroutine at its address (such as the
+// RWTS or a monitor routine): it is called instead of running the code there, and then returns.
+type Cpu6502 struct {
+	Memory           [0x10000]byte
+	A                byte
+	x                byte
+	Y                byte
+	SP               byte
+	P                byte
+	PC               int
+	InstructionCount int
+	Traps            map[int]func(c *Cpu6502)
+}
+
+// push pushes value onto the stack in page 1.
+func (c *Cpu6502) push(value byte) {
+	c.Memory[0x0100+int(c.SP)] = value
+	c.SP = c.SP - 1
+}
+
+// pull pulls a value off the stack in page 1.
+func (c *Cpu6502) pull() byte {
+	c.SP = c.SP + 1
+	return c.Memory[0x0100+int(c.SP)]
+}
+
+// ReadWord returns the little endian word at address, whose high byte is read from highAddress.
+func (c *Cpu6502) ReadWord(address int, highAddress int) int {
+	return int(c.Memory[address&0xFFFF]) | int(c.Memory[highAddress&0xFFFF])<<8
+}
+
+// SetFlag sets the status register bit flag when on is set, and clears it otherwise.
+func (c *Cpu6502) SetFlag(flag byte, on bool) {
+	if on {
+		c.P = c.P | flag
+	} else {
+		c.P = c.P &^ flag
+	}
+}
+
+// SetNZ sets the negative and zero flags for value.
+func (c *Cpu6502) SetNZ(value byte) {
+	c.SetFlag(FLAG_ZERO, value == 0)
+	c.SetFlag(FLAG_NEGATIVE, value&0x80 != 0)
+}
+
+// operandAddress returns the address of the operand of an instruction in addressing mode mode, whose
+// operand bytes follow the opcode at the program counter.
+func (c *Cpu6502) operandAddress(mode int) int {
+	var operand int = c.PC + 1
+	switch mode {
+	case MODE_IMMEDIATE:
+		return operand
+	case MODE_ZERO_PAGE:
+		return int(c.Memory[operand])
+	case MODE_ZERO_PAGE_X:
+		return int(c.Memory[operand]+c.x) & 0xFF
+	case MODE_ZERO_PAGE_Y:
+		return int(c.Memory[operand]+c.Y) & 0xFF
+	case MODE_ABSOLUTE:
+		return c.ReadWord(operand, operand+1)
+	case MODE_ABSOLUTE_X:
+		return (c.ReadWord(operand, operand+1) + int(c.x)) & 0xFFFF
+	case MODE_ABSOLUTE_Y:
+		return (c.ReadWord(operand, operand+1) + int(c.Y)) & 0xFFFF
+	case MODE_INDIRECT:
+		// the high byte of the pointer is read from the same page, as the NMOS 6502 does
+		var pointer int = c.ReadWord(operand, operand+1)
+		return c.ReadWord(pointer, pointer&0xFF00|(pointer+1)&0x00FF)
+	case MODE_INDEXED_INDIRECT:
+		var pointer int = int(c.Memory[operand]+c.x) & 0xFF
+		return c.ReadWord(pointer, (pointer+1)&0xFF)
+	case MODE_INDIRECT_INDEXED:
+		var pointer int = int(c.Memory[operand])
+		return (c.ReadWord(pointer, (pointer+1)&0xFF) + int(c.Y)) & 0xFFFF
+	case MODE_RELATIVE:
+		return (c.PC + 2 + int(int8(c.Memory[operand]))) & 0xFFFF
+	}
+	return 0
+}
+
+// INSTRUCTION_LENGTHS gives the length of an instruction in each addressing mode.
+var INSTRUCTION_LENGTHS map[int]int = map[int]int{
+	MODE_IMPLIED: 1, MODE_ACCUMULATOR: 1, MODE_IMMEDIATE: 2, MODE_ZERO_PAGE: 2, MODE_ZERO_PAGE_X: 2, MODE_ZERO_PAGE_Y: 2,
+	MODE_ABSOLUTE: 3, MODE_ABSOLUTE_X: 3, MODE_ABSOLUTE_Y: 3, MODE_INDIRECT: 3, MODE_INDEXED_INDIRECT: 2, MODE_INDIRECT_INDEXED: 2, MODE_RELATIVE: 2}
+
+// addWithCarry adds value and the carry to the accumulator, in decimal when the decimal flag is set.
+// The zero, negative and overflow flags follow the binary sum, as on the NMOS 6502.
+func (c *Cpu6502) addWithCarry(value byte) {
+	var carry int = int(c.P & FLAG_CARRY)
+	var sum int = int(c.A) + int(value) + carry
+	c.SetFlag(FLAG_OVERFLOW, (c.A^value)&0x80 == 0 && (c.A^byte(sum))&0x80 != 0)
+	c.SetNZ(byte(sum))
+	if c.P&FLAG_DECIMAL != 0 {
+		var low int = int(c.A&0x0F) + int(value&0x0F) + carry
+		var high int = int(c.A>>4) + int(value>>4)
+		if low > 0x09 {
+			low = low + 0x06
+		}
+		if low > 0x0F {
+			high = high + 1
+		}
+		if high > 0x09 {
+			high = high + 0x06
+		}
+		sum = high<<4 | low&0x0F
+	}
+	c.SetFlag(FLAG_CARRY, sum > 0xFF)
+	c.A = byte(sum)
+}
+
+// subtractWithBorrow subtracts value and the borrow (the carry clear) from the accumulator, in decimal
+// when the decimal flag is set. The flags follow the binary difference, as on the NMOS 6502.
+func (c *Cpu6502) subtractWithBorrow(value byte) {
+	var borrow int = 1 - int(c.P&FLAG_CARRY)
+	var difference int = int(c.A) - int(value) - borrow
+	c.SetFlag(FLAG_OVERFLOW, (c.A^value)&0x80 != 0 && (c.A^byte(difference))&0x80 != 0)
+	c.SetNZ(byte(difference))
+	c.SetFlag(FLAG_CARRY, difference >= 0)
+	if c.P&FLAG_DECIMAL != 0 {
+		var low int = int(c.A&0x0F) - int(value&0x0F) - borrow
+		var high int = int(c.A>>4) - int(value>>4)
+		if low < 0 {
+			low = low - 0x06
+			high = high - 1
+		}
+		if high < 0 {
+			high = high - 0x06
+		}
+		difference = high<<4 | low&0x0F
+	}
+	c.A = byte(difference)
+}
+
+// compare sets the flags for comparing register against value.
+func (c *Cpu6502) compare(register byte, value byte) {
+	c.SetFlag(FLAG_CARRY, register >= value)
+	c.SetNZ(register - value)
+}
+
+// shift applies the shift or rotate instruction name to value, setting the carry to the bit shifted
+// out, and returns the result.
+func (c *Cpu6502) shift(name string, value byte) byte {
+	var carryIn byte = c.P & FLAG_CARRY
+	var result byte
+	switch name {
+	case "ASL":
+		c.SetFlag(FLAG_CARRY, value&0x80 != 0)
+		result = value << 1
+	case "ROL":
+		c.SetFlag(FLAG_CARRY, value&0x80 != 0)
+		result = value<<1 | carryIn
+	case "LSR":
+		c.SetFlag(FLAG_CARRY, value&0x01 != 0)
+		result = value >> 1
+	case "ROR":
+		c.SetFlag(FLAG_CARRY, value&0x01 != 0)
+		result = value>>1 | carryIn<<7
+	}
+	c.SetNZ(result)
+	return result
+}
+
+// returnFromSubroutine pulls the return address off the stack, as RTS does.
+func (c *Cpu6502) returnFromSubroutine() {
+	var low int = int(c.pull())
+	var high int = int(c.pull())
+	c.PC = (high<<8 | low) + 1
+}
+
+// branchTaken returns whether the branch instruction name is taken with the current flags.
+func (c *Cpu6502) branchTaken(name string) bool {
+	switch name {
+	case "BCC":
+		return c.P&FLAG_CARRY == 0
+	case "BCS":
+		return c.P&FLAG_CARRY != 0
+	case "BNE":
+		return c.P&FLAG_ZERO == 0
+	case "BEQ":
+		return c.P&FLAG_ZERO != 0
+	case "BPL":
+		return c.P&FLAG_NEGATIVE == 0
+	case "BMI":
+		return c.P&FLAG_NEGATIVE != 0
+	case "BVC":
+		return c.P&FLAG_OVERFLOW == 0
+	}
+	return c.P&FLAG_OVERFLOW != 0
+}
+
+// step runs the instruction at the program counter. It returns false, without running it, when the
+// opcode is not a documented one.
+func (c *Cpu6502) Step() bool {
+	var opcode opcode6502
+	opcode, found := OPCODES_6502[c.Memory[c.PC]]
+	if !found {
+		return false
+	}
+	var address int = c.operandAddress(opcode.mode)
+	var nextPc int = (c.PC + INSTRUCTION_LENGTHS[opcode.mode]) & 0xFFFF
+	c.InstructionCount = c.InstructionCount + 1
+	switch opcode.name {
+	case "ADC":
+		c.addWithCarry(c.Memory[address])
+	case "SBC":
+		c.subtractWithBorrow(c.Memory[address])
+	case "AND":
+		c.A = c.A & c.Memory[address]
+		c.SetNZ(c.A)
+	case "ORA":
+		c.A = c.A | c.Memory[address]
+		c.SetNZ(c.A)
+	case "EOR":
+		c.A = c.A ^ c.Memory[address]
+		c.SetNZ(c.A)
+	case "ASL", "ROL", "LSR", "ROR":
+		if opcode.mode == MODE_ACCUMULATOR {
+			c.A = c.shift(opcode.name, c.A)
+		} else {
+			c.Memory[address] = c.shift(opcode.name, c.Memory[address])
+		}
+	case "BCC", "BCS", "BNE", "BEQ", "BPL", "BMI", "BVC", "BVS":
+		if c.branchTaken(opcode.name) {
+			nextPc = address
+		}
+	case "BIT":
+		c.SetFlag(FLAG_ZERO, c.A&c.Memory[address] == 0)
+		c.SetFlag(FLAG_NEGATIVE, c.Memory[address]&0x80 != 0)
+		c.SetFlag(FLAG_OVERFLOW, c.Memory[address]&0x40 != 0)
+	case "BRK":
+		var returnAddress int = c.PC + 2
+		c.push(byte(returnAddress >> 8))
+		c.push(byte(returnAddress))
+		c.push(c.P | FLAG_BREAK | FLAG_UNUSED)
+		c.SetFlag(FLAG_INTERRUPT, true)
+		nextPc = c.ReadWord(0xFFFE, 0xFFFF)
+	case "CLC":
+		c.SetFlag(FLAG_CARRY, false)
+	case "CLD":
+		c.SetFlag(FLAG_DECIMAL, false)
+	case "CLI":
+		c.SetFlag(FLAG_INTERRUPT, false)
+	case "CLV":
+		c.SetFlag(FLAG_OVERFLOW, false)
+	case "SEC":
+		c.SetFlag(FLAG_CARRY, true)
+	case "SED":
+		c.SetFlag(FLAG_DECIMAL, true)
+	case "SEI":
+		c.SetFlag(FLAG_INTERRUPT, true)
+	case "CMP":
+		c.compare(c.A, c.Memory[address])
+	case "CPX":
+		c.compare(c.x, c.Memory[address])
+	case "CPY":
+		c.compare(c.Y, c.Memory[address])
+	case "DEC":
+		c.Memory[address] = c.Memory[address] - 1
+		c.SetNZ(c.Memory[address])
+	case "INC":
+		c.Memory[address] = c.Memory[address] + 1
+		c.SetNZ(c.Memory[address])
+	case "DEX":
+		c.x = c.x - 1
+		c.SetNZ(c.x)
+	case "DEY":
+		c.Y = c.Y - 1
+		c.SetNZ(c.Y)
+	case "INX":
+		c.x = c.x + 1
+		c.SetNZ(c.x)
+	case "INY":
+		c.Y = c.Y + 1
+		c.SetNZ(c.Y)
+	case "JMP":
+		nextPc = address
+	case "JSR":
+		var returnAddress int = c.PC + 2
+		c.push(byte(returnAddress >> 8))
+		c.push(byte(returnAddress))
+		nextPc = address
+	case "RTS":
+		c.returnFromSubroutine()
+		nextPc = c.PC
+	case "RTI":
+		c.P = c.pull() | FLAG_UNUSED
+		var low int = int(c.pull())
+		var high int = int(c.pull())
+		nextPc = high<<8 | low
+	case "LDA":
+		c.A = c.Memory[address]
+		c.SetNZ(c.A)
+	case "LDX":
+		c.x = c.Memory[address]
+		c.SetNZ(c.x)
+	case "LDY":
+		c.Y = c.Memory[address]
+		c.SetNZ(c.Y)
+	case "STA":
+		c.Memory[address] = c.A
+	case "STX":
+		c.Memory[address] = c.x
+	case "STY":
+		c.Memory[address] = c.Y
+	case "PHA":
+		c.push(c.A)
+	case "PHP":
+		c.push(c.P | FLAG_BREAK | FLAG_UNUSED)
+	case "PLA":
+		c.A = c.pull()
+		c.SetNZ(c.A)
+	case "PLP":
+		c.P = c.pull() | FLAG_UNUSED
+	case "TAX":
+		c.x = c.A
+		c.SetNZ(c.x)
+	case "TAY":
+		c.Y = c.A
+		c.SetNZ(c.Y)
+	case "TSX":
+		c.x = c.SP
+		c.SetNZ(c.x)
+	case "TXA":
+		c.A = c.x
+		c.SetNZ(c.A)
+	case "TXS":
+		c.SP = c.x
+	case "TYA":
+		c.A = c.Y
+		c.SetNZ(c.A)
+	}
+	c.PC = nextPc
+	return true
+}
+
+// RunMachineCode runs the program at startAddress as the monitor G command does, with a return address
+// of MONITOR_RETURN_ADDRESS on the stack, for at most maxInstructionCount instructions. It returns how
+// the program stopped: by returning, at a break (which enters the monitor on an apple ][), at an opcode
+// which is not a documented one, or by running on.
+func RunMachineCode(c *Cpu6502, startAddress int, maxInstructionCount int) string {
+	c.SP = 0xFF
+	c.P = FLAG_UNUSED | FLAG_INTERRUPT
+	var returnAddress int = MONITOR_RETURN_ADDRESS - 1
+	c.push(byte(returnAddress >> 8))
+	c.push(byte(returnAddress))
+	c.PC = startAddress
+	c.InstructionCount = 0
+	for c.InstructionCount < maxInstructionCount {
+		if c.PC == MONITOR_RETURN_ADDRESS {
+			return "returned"
+		}
+		var trap func(c *Cpu6502) = c.Traps[c.PC]
+		if trap != nil {
+			trap(c)
+			c.InstructionCount = c.InstructionCount + 1
+			c.returnFromSubroutine()
+			continue
+		}
+		if c.Memory[c.PC] == 0x00 {
+			return fmt.Sprintf("stopped at the break at %04X", c.PC)
+		}
+		if !c.Step() {
+			return fmt.Sprintf("stopped at the illegal opcode %02X at %04X", c.Memory[c.PC], c.PC)
+		}
+	}
+	return fmt.Sprintf("ran on past %d instructions", maxInstructionCount)
+}
+
+// SCREEN_COLUMNS is the width of the apple ][ screen on which the monitor echoes each command line.
+const SCREEN_COLUMNS = 40
+
+// MONITOR_MOVE_TIME_PER_BYTE is the time the monitor move command takes for each byte it copies.
+const MONITOR_MOVE_TIME_PER_BYTE = 53 * time.Microsecond
+
+// 6502 section end
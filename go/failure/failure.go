@@ -0,0 +1,287 @@
+/*
+apple2disk failure package
+Copyright (C) 2024 github user bassjack1 <147515670+bassjack1@users.noreply.github.com>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+Communication with the author can be done via tagging @bassjack1 in github.com issues or by composing
+private messages to user bassjack1 on reddit.com : https://www.reddit.com/message/compose/
+*/
+
+// Package failure holds the coded errors of the apple2disk tools, each carrying an error kind with a
+// stable code and a suggestion, and reports them as text or JSON.
+package failure
+
+import "encoding/json"
+import "errors"
+import "fmt"
+import "io"
+import "os"
+import "runtime"
+import "strconv"
+import "strings"
+
+// Error report section begin
+
+// ErrorReport is a failure reported as JSON with -errors-json. Track and Sector are only present when
+// the failure concerns them.
+type ErrorReport struct {
+	Code       string `json:"code"`
+	Message    string `json:"message"`
+	Track      *int   `json:"track,omitempty"`
+	Sector     *int   `json:"sector,omitempty"`
+	Suggestion string `json:"suggestion,omitempty"`
+}
+
+// ErrorKind is the code of a kind of failure, reported with -errors-json, and the suggestion reported
+// with it.
+type ErrorKind struct {
+	Code       string
+	suggestion string
+}
+
+// The kinds of failures of the options and arguments given.
+var (
+	KIND_OPTION_CONFLICT ErrorKind = ErrorKind{"bad_option", "see the -help of the subcommand for the flags it accepts"}
+	KIND_UNKNOWN_VALUE ErrorKind = ErrorKind{"bad_option", "see the -help of the subcommand for the accepted values"}
+	KIND_ARGUMENT_COUNT ErrorKind = ErrorKind{"bad_argument", "see the -help of the subcommand for the arguments it takes"}
+	KIND_ILLEGAL_TRACK ErrorKind = ErrorKind{"track_out_of_range", "tracks of a floppy disk are numbered 0 through 34"}
+	KIND_ILLEGAL_TRACK_OR_SECTOR ErrorKind = ErrorKind{"track_out_of_range", "tracks of a floppy disk are numbered 0 through 34 and sectors 0 through 15"}
+	KIND_ILLEGAL_TRACK_RANGE ErrorKind = ErrorKind{"track_out_of_range", "list tracks 0 through 34 like 0-4,17,20-34"}
+	KIND_ILLEGAL_BLOCK_GROUP ErrorKind = ErrorKind{"block_group_out_of_range", "block groups are numbered from 0, 8 blocks per group"}
+	KIND_ILLEGAL_BLOCK_GROUP_RANGE ErrorKind = ErrorKind{"block_group_out_of_range", "list block groups from 0 like 0-99,150, 8 blocks per group"}
+	KIND_ILLEGAL_FIRST_BLOCK ErrorKind = ErrorKind{"bad_option", "blocks of a 140K disk are numbered 0 through 279"}
+	KIND_ILLEGAL_SECTOR_OFFSET ErrorKind = ErrorKind{"bad_option", "give -offset of 0 through 255, the bytes of a sector"}
+	KIND_POKE_PAST_SECTOR ErrorKind = ErrorKind{"bad_argument", "store the bytes going past the end of the sector with another poke of the next sector"}
+	KIND_NOT_HEXADECIMAL ErrorKind = ErrorKind{"bad_argument", "give the bytes as pairs of hexadecimal digits"}
+	KIND_ILLEGAL_SMARTPORT ErrorKind = ErrorKind{"bad_option", "SmartPort slots are 1 through 7 and units count from 1"}
+	KIND_ILLEGAL_SERIAL_SLOT ErrorKind = ErrorKind{"bad_option", "serial cards may be in slots 1 through 7, usually 2"}
+	KIND_ILLEGAL_SLOT ErrorKind = ErrorKind{"bad_option", "slots are 1 through 7"}
+	KIND_ILLEGAL_DRIVE ErrorKind = ErrorKind{"bad_option", "a Disk II controller has drives 1 and 2"}
+	KIND_ILLEGAL_VOLUME ErrorKind = ErrorKind{"bad_option", "DOS 3.3 volumes are numbered 1 through 254, or give 0 to accept any volume"}
+	KIND_ILLEGAL_CLIENT_ADDRESS ErrorKind = ErrorKind{"bad_option", "give the start of a memory page from 0x0800 through 0x9400, such as 0x6000"}
+	KIND_ILLEGAL_BUFFER_ADDRESS ErrorKind = ErrorKind{"bad_option", "give the start of a memory page from 0x0800 through 0x7600, such as 0x4000"}
+	KIND_OVERLAP ErrorKind = ErrorKind{"bad_option", "move -client-address or -buffer-address clear of the other programs"}
+	KIND_ILLEGAL_FRAMING ErrorKind = ErrorKind{"bad_option", "give -framing like 7N2 or 8N1"}
+	KIND_ILLEGAL_BAUD ErrorKind = ErrorKind{"bad_option", "give -baud as a positive number of bits per second, such as 2400 or 9600"}
+	KIND_7_DATA_BITS ErrorKind = ErrorKind{"bad_option", "use a framing with 8 data bits, or remove -high-bit"}
+	KIND_ILLEGAL_SEGMENT_SIZE ErrorKind = ErrorKind{"bad_option", "give -segment-size of at least 1, short enough for the lines to fit the monitor input buffer with their padding, or leave it to be derived"}
+	KIND_ILLEGAL_RAMP_UP_LINES ErrorKind = ErrorKind{"bad_option", "give -ramp-up-lines of 0 or more, or -1 to derive them from the segment size"}
+	KIND_ILLEGAL_FAULT_MODEL ErrorKind = ErrorKind{"bad_option", "give -fault-drop and -fault-corrupt as chances adding up to at most 1, such as 0.0001, and -fault-burst of at least 1"}
+	KIND_TUNING_MISMATCH ErrorKind = ErrorKind{"bad_option", "run calibrate again at these -baud and -framing, or give those of the tuning profile"}
+	KIND_13_SECTOR_INSTALL ErrorKind = ErrorKind{"bad_option", "13-sector tracks are written only by the bootstrap writer, add -profile bootstrap"}
+	KIND_CASSETTE_NAME ErrorKind = ErrorKind{"bad_option", "give -cassette a name like track%02d.wav, writing a file for each track"}
+	KIND_HEX_FILE_NAME ErrorKind = ErrorKind{"bad_option", "give -hex-file a name like track%02d.hex, writing a file for each track"}
+	KIND_APPLESOFT_FILE_NAME ErrorKind = ErrorKind{"bad_option", "give -applesoft-file a name like track%02d.bas, writing a file for each track"}
+	KIND_APPLESOFT_LOADER_SIZE ErrorKind = ErrorKind{"bad_option", "move -buffer-address and -client-address lower, to leave room for the Applesoft loader above them"}
+	KIND_CHUNK_TOO_SMALL ErrorKind = ErrorKind{"bad_option", "raise -chunk-bytes"}
+	KIND_CANNOT_UNDO ErrorKind = ErrorKind{"bad_option", "undo can only roll back the operations recorded in the journal"}
+	KIND_MISSING_FILE_NAME ErrorKind = ErrorKind{"bad_argument", "separate the image and the file in it with a colon"}
+	KIND_BAD_DATE ErrorKind = ErrorKind{"bad_option", "give -date as 2006-01-02 or 2006-01-02 15:04, between 1940 and 2039"}
+	KIND_BAD_NAME_REPLACEMENT ErrorKind = ErrorKind{"bad_option", "give -name-replacement as one character the names written may hold, such as a period in ProDOS names"}
+	KIND_BAD_NAME_LENGTH ErrorKind = ErrorKind{"bad_option", "give -name-length of at least 1, or 0 for the longest names allowed"}
+	KIND_BAD_PATTERN ErrorKind = ErrorKind{"bad_argument", "use * ? and [] as in host file names, and quote the pattern to keep the shell from expanding it"}
+	KIND_INVALID_NUMBER ErrorKind = ErrorKind{"bad_argument", "give numbers in decimal"}
+	KIND_FILE_NOT_FOUND ErrorKind = ErrorKind{"file_not_found", "check the path of the host file"}
+)
+
+// The kinds of failures of the disk images read, and of the files in them.
+var (
+	KIND_EMPTY_IMAGE ErrorKind = ErrorKind{"truncated_image", "the image file is empty, fetch or copy it again"}
+	KIND_TRUNCATED_IMAGE ErrorKind = ErrorKind{"truncated_image", "the image file is incomplete, fetch or copy it again"}
+	KIND_OVERSIZED_IMAGE ErrorKind = ErrorKind{"unrecognized_image", "the image holds extra bytes, such as the header of a format not recognized, or has the wrong extension"}
+	KIND_NOT_FLOPPY ErrorKind = ErrorKind{"unrecognized_image", "this subcommand works on 140K floppy images only"}
+	KIND_NOT_FLOPPY_INSTALL ErrorKind = ErrorKind{"unrecognized_image", "install the blocks of other images with -profile prodos or smartport"}
+	KIND_DOS33_IMAGE_SIZE ErrorKind = ErrorKind{"unrecognized_image", "DOS 3.3 images must be 140K floppy images"}
+	KIND_DOS33_CATALOG ErrorKind = ErrorKind{"unrecognized_image", "catalog lists the files of DOS 3.3 floppy images only"}
+	KIND_13_SECTOR ErrorKind = ErrorKind{"unrecognized_image", "13-sector images hold DOS 3.1 or 3.2 disks: they convert only to .d13 or .nib images, and install only with -profile bootstrap"}
+	KIND_NIBBLE_IMAGE_SIZE ErrorKind = ErrorKind{"unrecognized_image", "nibble images hold 35 tracks of 6656 disk bytes"}
+	KIND_UNDECODABLE_SECTORS ErrorKind = ErrorKind{"damaged_image", "the disk was not read cleanly or is copy protected, dump it again or keep the readable sectors with -skip-bad-sectors"}
+	KIND_2MG ErrorKind = ErrorKind{"unrecognized_image", "the image is not a 2MG image of DOS3.3 or ProDOS sectors or nibbles"}
+	KIND_WOZ ErrorKind = ErrorKind{"unrecognized_image", "the image is not a WOZ 1.0 or 2.0 image"}
+	KIND_WOZ_TRACK_MISSING ErrorKind = ErrorKind{"damaged_image", "the WOZ image is incomplete, image the disk again"}
+	KIND_WOZ_CRC32 ErrorKind = ErrorKind{"checksum_mismatch", "the WOZ image was damaged after it was made, fetch it again"}
+	KIND_SHA256_MISMATCH ErrorKind = ErrorKind{"checksum_mismatch", "the image differs from the published one, fetch it again"}
+	KIND_DOWNLOAD_TOO_LARGE ErrorKind = ErrorKind{"too_large", "raise -max-download-bytes if the image is expected to be this large"}
+	KIND_FETCH_FAILED ErrorKind = ErrorKind{"fetch_failed", "check the URL"}
+	KIND_VOLUME_TOO_LARGE ErrorKind = ErrorKind{"too_large", "ProDOS volumes are at most 32MB, select one volume of a multi-volume image with -partition"}
+	KIND_PARTITION_NOT_FOUND ErrorKind = ErrorKind{"partition_not_found", "partitions are counted from 1"}
+	KIND_BAD_MANIFEST ErrorKind = ErrorKind{"bad_manifest", "rewrite the manifest with split"}
+	KIND_BAD_CHUNK_FILE ErrorKind = ErrorKind{"bad_manifest", "check the chunk files listed in the manifest"}
+	KIND_NO_VTOC ErrorKind = ErrorKind{"unrecognized_image", "the image may not be a DOS 3.3 disk, or may not be in ProDOS sector order"}
+	KIND_NO_VOLUME_DIRECTORY ErrorKind = ErrorKind{"unrecognized_image", "the image may not be a ProDOS volume, or may not be in ProDOS sector order"}
+	KIND_UNRECOGNIZED_FILESYSTEM ErrorKind = ErrorKind{"unrecognized_image", "the image may be damaged, or not in ProDOS sector order"}
+	KIND_FILE_NOT_IN_IMAGE ErrorKind = ErrorKind{"file_not_in_image", "list the files of the image with catalog"}
+	KIND_SYSTEM_FILE_NOT_FOUND ErrorKind = ErrorKind{"file_not_in_image", "the system image must hold PRODOS and BASIC.SYSTEM"}
+	KIND_FILE_EXISTS ErrorKind = ErrorKind{"file_exists", "remove the file from the destination image first"}
+	KIND_DISK_FULL ErrorKind = ErrorKind{"disk_full", "make room on the destination image"}
+	KIND_NO_DOS_IMAGE ErrorKind = ErrorKind{"no_dos_image", "take the DOS image from a bootable DOS 3.3 disk, such as the System Master"}
+	KIND_DOS_TRACKS_IN_USE ErrorKind = ErrorKind{"dos_tracks_in_use", "move the file off tracks 0 through 2 first"}
+	KIND_IMAGE_CHANGED ErrorKind = ErrorKind{"image_changed", "the image was written again after the operation, undo that first"}
+	KIND_LOCKED_IMAGE ErrorKind = ErrorKind{"locked_image", "the image is write protected, change a copy of it converted to a .po image, or bootify it with -force"}
+)
+
+// The kinds of failures of the transfers to the apple ][, and of checking them.
+var (
+	KIND_TERMINAL_QUIT ErrorKind = ErrorKind{"transfer_cancelled", "press ctrl-] in the terminal to send the commands once the monitor is ready"}
+	KIND_WRITE_FAILED ErrorKind = ErrorKind{"transfer_failed", "check the serial link, and run the same command again with -resume to continue from the last completed track"}
+	KIND_SERIAL_PORT_CLOSED ErrorKind = ErrorKind{"transfer_failed", "check the serial link, and run the same command again"}
+	KIND_CONNECTION_FAILED ErrorKind = ErrorKind{"connection_failed", "check the bridge is listening at the -tcp host:port"}
+	KIND_NOT_ACKNOWLEDGED ErrorKind = ErrorKind{"transfer_failed", "check the apple ][ output is redirected to the serial port, such as with PR#2"}
+	KIND_NO_MEMORY_DUMP ErrorKind = ErrorKind{"transfer_failed", "check the apple ][ output is redirected to the serial port, such as with PR#2"}
+	KIND_MEMORY_MISMATCH ErrorKind = ErrorKind{"verification_failed", "check the serial link, or lower -baud"}
+	KIND_READ_BACK_FAILED ErrorKind = ErrorKind{"verification_failed", "check the disk and the drive, and send the failed tracks again with -tracks"}
+	KIND_NO_READ_BACK ErrorKind = ErrorKind{"bad_capture", "install with -read-back and capture the serial output of the apple ]["}
+	KIND_DUMP_COUNT ErrorKind = ErrorKind{"bad_capture", "give -tracks as used for dump, and capture every dump"}
+	KIND_DUMP_INCOMPLETE ErrorKind = ErrorKind{"bad_capture", "dump the track again with dump -tracks and undump it into the same image"}
+	KIND_CALIBRATION_FAILED ErrorKind = ErrorKind{"calibration_failed", "check the apple ][ output is redirected to the serial port, such as with PR#2, or lower -baud"}
+	KIND_SIMULATION_FAILED ErrorKind = ErrorKind{"simulation_failed", "lengthen -pad-length or shorten -segment-size until the simulated install succeeds, or leave them to be derived"}
+	KIND_BAD_QUEUE ErrorKind = ErrorKind{"bad_queue", "move the queue file away to start the daemon with an empty queue"}
+	KIND_TRANSFER_NOT_FOUND ErrorKind = ErrorKind{"transfer_not_found", "list the transfers of the queue with GET /transfers"}
+	KIND_TRANSFER_STATE ErrorKind = ErrorKind{"transfer_state", "pause the transfer first, or wait for it to end"}
+	KIND_BAD_TRANSFER_ARGS ErrorKind = ErrorKind{"bad_request", "give the arguments of install as a JSON list, without the flags the daemon gives itself"}
+	KIND_DUPLICATION_FAILED ErrorKind = ErrorKind{"transfer_failed", "check the serial links of the failed copies, and run the same command again with -resume to continue them from their last completed track"}
+	KIND_BAD_SESSION ErrorKind = ErrorKind{"bad_session", "run the command without -resume to start the transfer over"}
+	KIND_ADTPRO ErrorKind = ErrorKind{"adtpro_failed", "check that the ADTPro client on the apple ][ is set to the same serial port speed, then run its command again"}
+	KIND_NO_SOS_IMAGE ErrorKind = ErrorKind{"no_sos_image", "take the SOS files from a bootable Apple /// disk whose SOS.DRIVER holds the .RS232 driver"}
+	KIND_APPLE3 ErrorKind = ErrorKind{"transfer_failed", "check that the .RS232 driver of the client disk is set to the same serial port speed, then run apple3 again with -first-block set to the block which failed"}
+	KIND_APPLE3_WRITE ErrorKind = ErrorKind{"write_failed", "check the disk in the built-in drive is formatted and not write protected, then run apple3 again with -first-block set to the block which failed"}
+	KIND_ENCRYPTION ErrorKind = ErrorKind{"encryption_failed", "give -key-file the key file the image was encrypted with"}
+	KIND_BAD_KEY ErrorKind = ErrorKind{"bad_key", "give -key-file a key file made by encrypt -new-key"}
+	KIND_BAD_SESSION_MANIFEST ErrorKind = ErrorKind{"bad_manifest", "give each step of the manifest either install or run, as the README describes"}
+	KIND_SESSION_STEP_FAILED ErrorKind = ErrorKind{"transfer_failed", "fix what made the step fail, and run the session again with -resume to continue from that step"}
+	KIND_YMODEM ErrorKind = ErrorKind{"transfer_failed", "check the receiver is waiting for a YMODEM batch"}
+	KIND_XMODEM ErrorKind = ErrorKind{"transfer_failed", "check the receiver is waiting for an XMODEM download"}
+	KIND_ZMODEM ErrorKind = ErrorKind{"transfer_failed", "check the receiver is waiting for a ZMODEM download"}
+	KIND_YMODEM_NAME ErrorKind = ErrorKind{"bad_argument", "rename the file, or send it with zmodem, whose header has room for longer names"}
+)
+
+// CodedError is a failure of a kind, concerning the track and sector given, which are -1 when it
+// concerns none. A CodedError of no kind only adds the track and sector to the error it wraps, whose
+// kind is reported.
+type CodedError struct {
+	Kind   ErrorKind
+	track  int
+	sector int
+	err    error
+}
+
+// Error implements error, returning the message of the error e wraps.
+func (e *CodedError) Error() string {
+	return e.err.Error()
+}
+
+// Unwrap returns the error e wraps, for errors.Is and errors.As.
+func (e *CodedError) Unwrap() error {
+	return e.err
+}
+
+// CodedErrorf returns a failure of kind with the message formatted as fmt.Errorf does, wrapping the
+// error of a %w verb.
+func CodedErrorf(kind ErrorKind, format string, args ...interface{}) error {
+	return &CodedError{Kind: kind, track: -1, sector: -1, err: fmt.Errorf(format, args...)}
+}
+
+// TrackErrorf returns a failure of kind concerning track, as CodedErrorf does.
+func TrackErrorf(kind ErrorKind, track int, format string, args ...interface{}) error {
+	return &CodedError{Kind: kind, track: track, sector: -1, err: fmt.Errorf(format, args...)}
+}
+
+// SectorErrorf returns a failure of kind concerning the sector at track,sector, as CodedErrorf does.
+func SectorErrorf(kind ErrorKind, track int, sector int, format string, args ...interface{}) error {
+	return &CodedError{Kind: kind, track: track, sector: sector, err: fmt.Errorf(format, args...)}
+}
+
+// ClassifyFailure finds the code and suggestion for a failure (an error returned by run, or the value
+// recovered from a panic), and the track and sector it concerns. These are taken from the outermost
+// CodedError giving them, then from the errors of the system a failure of no kind may wrap. Any other
+// failure, such as a runtime error, is reported with code "internal".
+func ClassifyFailure(failure interface{}) ErrorReport {
+	var report ErrorReport = ErrorReport{Code: "internal", Message: strings.TrimSpace(fmt.Sprint(failure))}
+	err, isError := failure.(error)
+	if !isError {
+		return report
+	}
+	var kind ErrorKind
+	var coded *CodedError
+	for chain := err; errors.As(chain, &coded); chain = coded.err {
+		if kind.Code == "" {
+			kind = coded.Kind
+		}
+		if report.Track == nil && coded.track >= 0 {
+			var track int = coded.track
+			report.Track = &track
+		}
+		if report.Sector == nil && coded.sector >= 0 {
+			var sector int = coded.sector
+			report.Sector = &sector
+		}
+	}
+	if kind.Code == "" && errors.Is(err, os.ErrNotExist) {
+		kind = KIND_FILE_NOT_FOUND
+	} else if kind.Code == "" && errors.Is(err, strconv.ErrSyntax) {
+		kind = KIND_INVALID_NUMBER
+	}
+	if kind.Code != "" {
+		report.Code = kind.Code
+		report.Suggestion = kind.suggestion
+	}
+	return report
+}
+
+// LocateRuntimeFailure returns failure with the function and line it happened at added when it is a
+// runtime error, such as an index out of range, whose message alone does not tell where it happened,
+// and failure itself otherwise. It must be called directly by the deferred function recovering
+// failure, while the stack still holds the frames of the panic.
+func LocateRuntimeFailure(failure interface{}) interface{} {
+	runtimeError, isRuntimeError := failure.(runtime.Error)
+	if !isRuntimeError {
+		return failure
+	}
+	var callers []uintptr = make([]uintptr, 32)
+	// skipping runtime.Callers, this function and the deferred function
+	var frames *runtime.Frames = runtime.CallersFrames(callers[:runtime.Callers(3, callers)])
+	for {
+		frame, more := frames.Next()
+		if frame.Function != "" && !strings.HasPrefix(frame.Function, "runtime.") {
+			return fmt.Errorf("%w, in %s at line %d", runtimeError, frame.Function, frame.Line)
+		}
+		if !more {
+			return failure
+		}
+	}
+}
+
+// ReportFailure writes a failure (an error returned by run, or the value recovered from a panic) to
+// output as an error message, followed by the suggestion for it when there is one.
+func ReportFailure(output io.Writer, failure interface{}) {
+	var report ErrorReport = ClassifyFailure(failure)
+	fmt.Fprintf(output, "error: %s\n", report.Message)
+	if report.Suggestion != "" {
+		fmt.Fprintf(output, "  (%s)\n", report.Suggestion)
+	}
+}
+
+// ReportErrorAsJson writes a failure (an error returned by run, or the value recovered from a panic) to
+// output as a single line of JSON holding a code, the message, the track and sector named in it, and a
+// suggestion.
+func ReportErrorAsJson(output io.Writer, failure interface{}) {
+	var report ErrorReport = ClassifyFailure(failure)
+	// a struct of strings and numbers always marshals
+	var data []byte
+	data, _ = json.Marshal(report)
+	fmt.Fprintf(output, "%s\n", data)
+}
+
+// Error report section end
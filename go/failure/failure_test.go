@@ -0,0 +1,25 @@
+package failure
+
+import "fmt"
+import "strings"
+import "testing"
+
+// TestLocateRuntimeFailure checks that a runtime error recovered from a panic is given the function
+// it happened in, and that other failures are left as they are.
+func TestLocateRuntimeFailure(t *testing.T) {
+	var located interface{}
+	func() {
+		defer func() {
+			located = LocateRuntimeFailure(recover())
+		}()
+		var sectors []int
+		var sectorNum int = 0x10
+		sectors[sectorNum] = 0
+	}()
+	if !strings.Contains(fmt.Sprint(located), "index out of range") || !strings.Contains(fmt.Sprint(located), "TestLocateRuntimeFailure") {
+		t.Errorf("the runtime error was reported as %v", located)
+	}
+	if LocateRuntimeFailure("illegal track\n") != "illegal track\n" {
+		t.Error("a failure message was changed")
+	}
+}
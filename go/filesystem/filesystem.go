@@ -0,0 +1,2094 @@
+/*
+apple2disk filesystem package
+Copyright (C) 2024 github user bassjack1 <147515670+bassjack1@users.noreply.github.com>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+Communication with the author can be done via tagging @bassjack1 in github.com issues or by composing
+private messages to user bassjack1 on reddit.com : https://www.reddit.com/message/compose/
+*/
+
+// Package filesystem reads and writes the files of DOS 3.3 and ProDOS disk images: catalogs,
+// extraction, addition, name translation and consistency checks.
+package filesystem
+
+import "bytes"
+import "flag"
+import "fmt"
+import "github.com/bassjack1/apple2disk/go/diskimage"
+import "github.com/bassjack1/apple2disk/go/failure"
+import "io"
+import "io/ioutil"
+import "os"
+import "path"
+import "path/filepath"
+import "regexp"
+import "strconv"
+import "strings"
+import "time"
+
+// Partition section begin
+
+// CFFA_PARTITION_SIZE is the size of the slice of a CompactFlash or hard disk image which holds
+// each ProDOS volume (partition) in the CFFA multi-volume layout.
+const CFFA_PARTITION_SIZE = 0x10000 * diskimage.PRODOS_BLOCK_SIZE
+
+// ReadProdosVolumeHeader reads the volume directory header in block 2 of the ProDOS volume starting
+// at offset volumeStartPos of diskImage. When a volume directory header is found, the volume name and
+// total block count are stored into volumeName and totalBlocks, and true is returned.
+func ReadProdosVolumeHeader(volumeName *string, totalBlocks *int, diskImage []byte, volumeStartPos int) bool {
+	var headerPos int = volumeStartPos + 2*diskimage.PRODOS_BLOCK_SIZE + 0x04
+	if headerPos+0x27 > len(diskImage) {
+		return false
+	}
+	var storageType byte = diskImage[headerPos] >> 4
+	var nameLength int = int(diskImage[headerPos] & 0x0F)
+	if storageType != 0x0F || nameLength == 0 {
+		return false
+	}
+	*volumeName = string(diskImage[headerPos+1 : headerPos+1+nameLength])
+	*totalBlocks = int(diskImage[headerPos+0x25]) | int(diskImage[headerPos+0x26])<<8
+	return true
+}
+
+// SelectPartitionOfDiskImage reduces diskImage to the ProDOS volume held in partition partitionNum
+// (counting from 1) of a CFFA style multi-volume image. The volumes found in each partition slot are
+// reported to stderr. The selected volume is trimmed to the total block count in its volume header.
+func SelectPartitionOfDiskImage(diskImage *[]byte, partitionNum int) error {
+	var selectedStartPos int = -1
+	var selectedBlocks int
+	var partitionCount int = 0
+	for partitionStartPos := 0; partitionStartPos < len(*diskImage); partitionStartPos = partitionStartPos + CFFA_PARTITION_SIZE {
+		var volumeName string
+		var totalBlocks int
+		if !ReadProdosVolumeHeader(&volumeName, &totalBlocks, *diskImage, partitionStartPos) {
+			break
+		}
+		partitionCount = partitionCount + 1
+		fmt.Fprintf(os.Stderr, "partition %d: /%s %d blocks\n", partitionCount, volumeName, totalBlocks)
+		if partitionCount == partitionNum {
+			selectedStartPos = partitionStartPos
+			selectedBlocks = totalBlocks
+		}
+	}
+	if selectedStartPos < 0 {
+		return failure.CodedErrorf(failure.KIND_PARTITION_NOT_FOUND, "partition %d not found, image holds %d ProDOS partitions", partitionNum, partitionCount)
+	}
+	var selectedEndPos int = selectedStartPos + selectedBlocks*diskimage.PRODOS_BLOCK_SIZE
+	if selectedEndPos > len(*diskImage) {
+		// a truncated final partition keeps whatever blocks are present
+		selectedEndPos = len(*diskImage)
+	}
+	*diskImage = (*diskImage)[selectedStartPos:selectedEndPos]
+	return nil
+}
+
+// Partition section end
+
+// DOS master section begin
+
+// ValidateDos33Vtoc returns an error unless diskImage (in DOS3.3 sector order) holds a DOS 3.3 VTOC
+// for a 35 track, 16 sector disk at track 0x11 sector 0x00.
+func ValidateDos33Vtoc(diskImage []byte) error {
+	var vtoc []byte = diskimage.Dos33SectorOfImage(diskImage, 0x11, 0x00)
+	if vtoc[0x03] != 0x03 || vtoc[0x34] != 0x23 || vtoc[0x35] != 0x10 {
+		return failure.CodedErrorf(failure.KIND_NO_VTOC, "image does not hold a DOS 3.3 VTOC for 35 tracks of 16 sectors at track 17 sector 0")
+	}
+	return nil
+}
+
+// findDos33FileOnTracks walks the catalog and track/sector lists of the DOS 3.3 diskImage (in DOS3.3
+// sector order) and returns the name of the first file having a track/sector list or data sector on a
+// track below trackLimit, or an empty string when there is none.
+func findDos33FileOnTracks(diskImage []byte, trackLimit int) string {
+	var vtoc []byte = diskimage.Dos33SectorOfImage(diskImage, 0x11, 0x00)
+	var catalogTrack int = int(vtoc[0x01])
+	var catalogSector int = int(vtoc[0x02])
+	var visitedSectors int = 0
+	for catalogTrack != 0 && catalogTrack < 0x23 && visitedSectors < 0x23*0x10 {
+		var catalogSectorData []byte = diskimage.Dos33SectorOfImage(diskImage, catalogTrack, catalogSector&0x0F)
+		for entryPos := 0x0B; entryPos+0x23 <= 0x0100; entryPos = entryPos + 0x23 {
+			var tsListTrack int = int(catalogSectorData[entryPos])
+			var tsListSector int = int(catalogSectorData[entryPos+0x01])
+			if tsListTrack == 0x00 || tsListTrack == 0xFF {
+				// unused or deleted entry
+				continue
+			}
+			var fileName string = strings.TrimRight(string(StripHighBits(catalogSectorData[entryPos+0x03:entryPos+0x21])), " ")
+			for tsListTrack != 0 && tsListTrack < 0x23 && visitedSectors < 0x23*0x10 {
+				if tsListTrack < trackLimit {
+					return fileName
+				}
+				var tsList []byte = diskimage.Dos33SectorOfImage(diskImage, tsListTrack, tsListSector&0x0F)
+				for pairPos := 0x0C; pairPos < 0x0100; pairPos = pairPos + 2 {
+					if tsList[pairPos] != 0 && int(tsList[pairPos]) < trackLimit {
+						return fileName
+					}
+				}
+				tsListTrack = int(tsList[0x01])
+				tsListSector = int(tsList[0x02])
+				visitedSectors = visitedSectors + 1
+			}
+		}
+		catalogTrack = int(catalogSectorData[0x01])
+		catalogSector = int(catalogSectorData[0x02]) & 0x0F
+		visitedSectors = visitedSectors + 1
+	}
+	return ""
+}
+
+// StripHighBits returns a copy of text with the high bit of each character cleared, as DOS 3.3
+// stores names in high ascii.
+func StripHighBits(text []byte) []byte {
+	var stripped []byte = make([]byte, len(text))
+	for i, b := range text {
+		stripped[i] = b & 0x7F
+	}
+	return stripped
+}
+
+// DOS33_BOOT_SECTOR_START is the start of the boot sector (track 0 sector 0) of a bootable DOS 3.3
+// disk: the count of sectors the boot ROM reads, 1, followed by the first instructions of the boot
+// code, LDA $27 and CMP #$09.
+var DOS33_BOOT_SECTOR_START []byte = []byte{0x01, 0xA5, 0x27, 0xC9, 0x09}
+
+// DOS33_MASTER_BOOT_PAGE is the memory page the boot sector of a DOS 3.3 master disk loads the next
+// stage of DOS into, below the relocator. The boot sector of a slave disk gives instead the page DOS
+// ends up at on the machine which initialized it, such as 0xB6 for 48K.
+const DOS33_MASTER_BOOT_PAGE = 0x36
+
+// validateDos33BootImage returns an error unless dosImage (in DOS3.3 sector order) holds a bootable DOS
+// 3.3 disk: a DOS 3.3 VTOC marking tracks 0 through 2 in use, and the DOS 3.3 boot sector on track 0.
+func validateDos33BootImage(dosImage []byte) error {
+	var err error = ValidateDos33Vtoc(dosImage)
+	if err != nil {
+		return err
+	}
+	var vtoc []byte = diskimage.Dos33SectorOfImage(dosImage, 0x11, 0x00)
+	for track := 0x00; track < 0x03; track = track + 1 {
+		if vtoc[0x38+4*track] != 0x00 || vtoc[0x38+4*track+1] != 0x00 {
+			return failure.CodedErrorf(failure.KIND_NO_DOS_IMAGE, "track %d is free in the VTOC, so tracks 0 through 2 hold no DOS image", track)
+		}
+	}
+	var bootSector []byte = diskimage.Dos33SectorOfImage(dosImage, 0x00, 0x00)
+	if !bytes.Equal(bootSector[:len(DOS33_BOOT_SECTOR_START)], DOS33_BOOT_SECTOR_START) {
+		return failure.CodedErrorf(failure.KIND_NO_DOS_IMAGE, "track 0 sector 0 does not hold the DOS 3.3 boot sector")
+	}
+	return nil
+}
+
+// InstallDos33ImageOnDataDisk copies tracks 0 through 2 of dosImage onto dataImage, and marks all
+// sectors of those tracks as in use in the free sector bitmap of the data disk VTOC. Both images
+// must be in DOS3.3 sector order. It returns an error if dosImage is not a bootable DOS 3.3 disk (see
+// validateDos33BootImage), if dataImage is not a DOS 3.3 disk, or if a file on the data disk would be
+// overwritten. The DOS image is copied as it is, so a slave disk gives a data disk loading DOS at the
+// fixed addresses of the machine which initialized the slave disk, and only a master disk gives one
+// which relocates DOS to the memory size.
+func InstallDos33ImageOnDataDisk(dataImage []byte, dosImage []byte) error {
+	const DOS_TRACK_COUNT = 0x03
+	var err error = validateDos33BootImage(dosImage)
+	if err != nil {
+		return fmt.Errorf("DOS image: %w", err)
+	}
+	err = ValidateDos33Vtoc(dataImage)
+	if err != nil {
+		return fmt.Errorf("data image: %w", err)
+	}
+	var fileName string = findDos33FileOnTracks(dataImage, DOS_TRACK_COUNT)
+	if fileName != "" {
+		return failure.CodedErrorf(failure.KIND_DOS_TRACKS_IN_USE, "file %s on the data disk uses the DOS tracks", fileName)
+	}
+	copy(dataImage[:DOS_TRACK_COUNT*0x1000], dosImage[:DOS_TRACK_COUNT*0x1000])
+	var vtoc []byte = diskimage.Dos33SectorOfImage(dataImage, 0x11, 0x00)
+	for track := 0x00; track < DOS_TRACK_COUNT; track = track + 1 {
+		// a set bit marks a free sector, 4 bytes per track starting at 0x38
+		vtoc[0x38+4*track] = 0x00
+		vtoc[0x38+4*track+1] = 0x00
+	}
+	return nil
+}
+
+// DOS master section end
+
+// ProDOS bootify section begin
+
+// PRODOS_DIRECTORY_ENTRY_LENGTH is the length of the directory entries ProDOS writes. A directory
+// header may give longer entries, but the fields of an entry do not fit into shorter ones.
+const PRODOS_DIRECTORY_ENTRY_LENGTH = 0x27
+
+// ForEachProdosDirectoryEntry calls visit with each entry of the directory with key block keyBlockNum
+// of the ProDOS ordered diskImage, in catalog order, until visit returns false. The entry is a slice of
+// the directory block holding it, numbered blockNum, so visit may change it in place; the directory
+// header and the unused entries are visited as well. An error is returned, after visiting the entries
+// before it, when the header gives entries too short or too many to fit into a block, or when a block
+// of the directory lies outside the image or is linked twice into the directory.
+func ForEachProdosDirectoryEntry(diskImage []byte, keyBlockNum int, visit func(entry []byte, blockNum int) bool) error {
+	if keyBlockNum == 0 || (keyBlockNum+1)*diskimage.PRODOS_BLOCK_SIZE > len(diskImage) {
+		return fmt.Errorf("directory key block %d is outside the image of %d blocks", keyBlockNum, len(diskImage)/diskimage.PRODOS_BLOCK_SIZE)
+	}
+	var keyBlock []byte = diskimage.ProdosBlockOfImage(diskImage, keyBlockNum)
+	var entryLength int = int(keyBlock[0x04+0x1F])
+	var entriesPerBlock int = int(keyBlock[0x04+0x20])
+	if entryLength < PRODOS_DIRECTORY_ENTRY_LENGTH || entriesPerBlock == 0 || 0x04+entriesPerBlock*entryLength > diskimage.PRODOS_BLOCK_SIZE {
+		return fmt.Errorf("directory in block %d has a damaged header (%d entries of %d bytes per block)", keyBlockNum, entriesPerBlock, entryLength)
+	}
+	// a damaged image can link the directory blocks into a loop or point past its end
+	var visitedBlocks map[int]bool = make(map[int]bool)
+	var blockNum int = keyBlockNum
+	for blockNum != 0 {
+		if (blockNum+1)*diskimage.PRODOS_BLOCK_SIZE > len(diskImage) {
+			return fmt.Errorf("directory block %d is outside the image of %d blocks", blockNum, len(diskImage)/diskimage.PRODOS_BLOCK_SIZE)
+		}
+		if visitedBlocks[blockNum] {
+			return fmt.Errorf("directory block %d is linked twice, the directory chain loops", blockNum)
+		}
+		visitedBlocks[blockNum] = true
+		var directoryBlock []byte = diskimage.ProdosBlockOfImage(diskImage, blockNum)
+		for i := 0; i < entriesPerBlock; i = i + 1 {
+			if !visit(directoryBlock[0x04+i*entryLength:0x04+(i+1)*entryLength], blockNum) {
+				return nil
+			}
+		}
+		blockNum = int(directoryBlock[0x02]) | int(directoryBlock[0x03])<<8
+	}
+	return nil
+}
+
+// ProdosEntryName returns the file name held by the directory entry entry.
+func ProdosEntryName(entry []byte) string {
+	return string(entry[0x01 : 0x01+entry[0x00]&0x0F])
+}
+
+// ProdosEntryKeyBlockNum returns the key block number held by the directory entry entry.
+func ProdosEntryKeyBlockNum(entry []byte) int {
+	return int(entry[0x11]) | int(entry[0x12])<<8
+}
+
+// FindProdosVolumeDirectoryEntry searches the volume directory of the ProDOS ordered diskImage for
+// the file named fileName. When found, the entry (a slice of the directory block holding it) is
+// stored into entry, which is otherwise set to nil. An error is returned when the directory is
+// damaged.
+func FindProdosVolumeDirectoryEntry(entry *[]byte, diskImage []byte, fileName string) error {
+	return findProdosDirectoryEntry(entry, diskImage, 0x02, fileName)
+}
+
+// findProdosDirectoryEntry searches the directory with key block keyBlockNum in the ProDOS ordered
+// diskImage for the file named fileName, in the same way as FindProdosVolumeDirectoryEntry.
+func findProdosDirectoryEntry(entry *[]byte, diskImage []byte, keyBlockNum int, fileName string) error {
+	*entry = nil
+	return ForEachProdosDirectoryEntry(diskImage, keyBlockNum, func(directoryEntry []byte, blockNum int) bool {
+		var storageType byte = directoryEntry[0x00] >> 4
+		if storageType == 0x00 || storageType >= 0x0E {
+			// deleted entry, or the directory header
+			return true
+		}
+		if ProdosEntryName(directoryEntry) == fileName {
+			*entry = directoryEntry
+			return false
+		}
+		return true
+	})
+}
+
+// allocateProdosBlock finds a free block in the volume bitmap of the ProDOS ordered diskImage,
+// marks it as in use, zero fills it and stores its number into blockNum.
+func allocateProdosBlock(blockNum *int, diskImage []byte) error {
+	var volumeName string
+	var totalBlocks int
+	if !ReadProdosVolumeHeader(&volumeName, &totalBlocks, diskImage, 0) {
+		return failure.CodedErrorf(failure.KIND_NO_VOLUME_DIRECTORY, "image does not hold a ProDOS volume directory")
+	}
+	if totalBlocks*diskimage.PRODOS_BLOCK_SIZE > len(diskImage) {
+		return failure.CodedErrorf(failure.KIND_UNRECOGNIZED_FILESYSTEM, "volume /%s of %d blocks does not fit the image of %d blocks", volumeName, totalBlocks, len(diskImage)/diskimage.PRODOS_BLOCK_SIZE)
+	}
+	var bitmapBlockNum int
+	var err error = readProdosBitmapBlockNum(&bitmapBlockNum, diskImage, totalBlocks)
+	if err != nil {
+		return err
+	}
+	for freeBlockNum := 0; freeBlockNum < totalBlocks; freeBlockNum = freeBlockNum + 1 {
+		// a set bit marks a free block, 4096 blocks per bitmap block, high bit first
+		var bitmapBlock []byte = diskimage.ProdosBlockOfImage(diskImage, bitmapBlockNum+freeBlockNum/0x1000)
+		var bytePos int = (freeBlockNum % 0x1000) / 8
+		var mask byte = 0x80 >> uint(freeBlockNum%8)
+		if bitmapBlock[bytePos]&mask != 0 {
+			bitmapBlock[bytePos] = bitmapBlock[bytePos] &^ mask
+			copy(diskimage.ProdosBlockOfImage(diskImage, freeBlockNum), make([]byte, diskimage.PRODOS_BLOCK_SIZE))
+			*blockNum = freeBlockNum
+			return nil
+		}
+	}
+	return failure.CodedErrorf(failure.KIND_DISK_FULL, "no free blocks left on volume /%s", volumeName)
+}
+
+// copyProdosFileBlocks copies block sourceBlockNum of sourceImage into a newly allocated block of
+// destinationImage and returns the new block number. indexLevel is 0 for a data block, 1 for an index
+// block and 2 for a master index block; the blocks referenced by index blocks are copied as well,
+// keeping sparse (zero) pointers sparse. The count of allocated blocks is added to blocksUsed.
+func copyProdosFileBlocks(destinationBlockNum *int, destinationImage []byte, sourceImage []byte, sourceBlockNum int, indexLevel int, blocksUsed *int) error {
+	if (sourceBlockNum+1)*diskimage.PRODOS_BLOCK_SIZE > len(sourceImage) {
+		return fmt.Errorf("block %d is beyond the end of the source image", sourceBlockNum)
+	}
+	var err error = allocateProdosBlock(destinationBlockNum, destinationImage)
+	if err != nil {
+		return err
+	}
+	*blocksUsed = *blocksUsed + 1
+	var sourceBlock []byte = diskimage.ProdosBlockOfImage(sourceImage, sourceBlockNum)
+	var destinationBlock []byte = diskimage.ProdosBlockOfImage(destinationImage, *destinationBlockNum)
+	if indexLevel == 0 {
+		copy(destinationBlock, sourceBlock)
+		return nil
+	}
+	// index blocks hold the low bytes of 256 block pointers followed by the high bytes
+	for i := 0; i < 0x0100; i = i + 1 {
+		var pointer int = int(sourceBlock[i]) | int(sourceBlock[0x0100+i])<<8
+		if pointer != 0 {
+			err = copyProdosFileBlocks(&pointer, destinationImage, sourceImage, pointer, indexLevel-1, blocksUsed)
+			if err != nil {
+				return err
+			}
+		}
+		destinationBlock[i] = byte(pointer & 0xFF)
+		destinationBlock[0x0100+i] = byte(pointer >> 8)
+	}
+	return nil
+}
+
+// copyProdosFileToVolumeDirectory copies the file named fileName from the volume directory of
+// sourceImage into a free entry of the volume directory of destinationImage, allocating new blocks
+// for its data. The entry keeps the file type, dates, access and aux type of the original file.
+func copyProdosFileToVolumeDirectory(destinationImage []byte, sourceImage []byte, fileName string) error {
+	var sourceEntry []byte
+	var err error = FindProdosVolumeDirectoryEntry(&sourceEntry, sourceImage, fileName)
+	if err != nil {
+		return fmt.Errorf("source volume directory: %w", err)
+	}
+	if sourceEntry == nil {
+		return failure.CodedErrorf(failure.KIND_SYSTEM_FILE_NOT_FOUND, "file %s not found in the source volume directory", fileName)
+	}
+	var existingEntry []byte
+	err = FindProdosVolumeDirectoryEntry(&existingEntry, destinationImage, fileName)
+	if err != nil {
+		return fmt.Errorf("destination volume directory: %w", err)
+	}
+	if existingEntry != nil {
+		return failure.CodedErrorf(failure.KIND_FILE_EXISTS, "file %s is already in the destination volume directory", fileName)
+	}
+	var storageType byte = sourceEntry[0x00] >> 4
+	if storageType < 0x01 || storageType > 0x03 {
+		return fmt.Errorf("file %s is not a standard file (storage type %d)", fileName, storageType)
+	}
+	var freeEntry []byte
+	err = ForEachProdosDirectoryEntry(destinationImage, 0x02, func(entry []byte, blockNum int) bool {
+		if entry[0x00]>>4 != 0x00 {
+			return true
+		}
+		freeEntry = entry
+		return false
+	})
+	if err != nil {
+		return fmt.Errorf("destination volume directory: %w", err)
+	}
+	if freeEntry == nil {
+		return failure.CodedErrorf(failure.KIND_DISK_FULL, "no free entry left in the destination volume directory for file %s", fileName)
+	}
+	var blocksUsed int = 0
+	var keyBlockNum int
+	// seedling, sapling and tree files have 0, 1 and 2 levels of index blocks
+	err = copyProdosFileBlocks(&keyBlockNum, destinationImage, sourceImage, ProdosEntryKeyBlockNum(sourceEntry), int(storageType)-1, &blocksUsed)
+	if err != nil {
+		return fmt.Errorf("copying file %s: %w", fileName, err)
+	}
+	copy(freeEntry, sourceEntry)
+	freeEntry[0x11] = byte(keyBlockNum & 0xFF)
+	freeEntry[0x12] = byte(keyBlockNum >> 8)
+	freeEntry[0x13] = byte(blocksUsed & 0xFF)
+	freeEntry[0x14] = byte(blocksUsed >> 8)
+	freeEntry[0x25] = 0x02
+	freeEntry[0x26] = 0x00
+	var keyBlock []byte = diskimage.ProdosBlockOfImage(destinationImage, 0x02)
+	var fileCount int = int(keyBlock[0x04+0x21]) | int(keyBlock[0x04+0x22])<<8
+	fileCount = fileCount + 1
+	keyBlock[0x04+0x21] = byte(fileCount & 0xFF)
+	keyBlock[0x04+0x22] = byte(fileCount >> 8)
+	fmt.Fprintf(os.Stderr, "copied file %s using %d blocks\n", fileName, blocksUsed)
+	return nil
+}
+
+// BootifyProdosImage makes the ProDOS ordered dataImage bootable by copying the boot blocks and the
+// PRODOS and BASIC.SYSTEM files of the bootable ProDOS ordered systemImage onto it.
+func BootifyProdosImage(dataImage []byte, systemImage []byte) error {
+	var volumeName string
+	var totalBlocks int
+	if !ReadProdosVolumeHeader(&volumeName, &totalBlocks, systemImage, 0) {
+		return failure.CodedErrorf(failure.KIND_NO_VOLUME_DIRECTORY, "system image does not hold a ProDOS volume directory")
+	}
+	if !ReadProdosVolumeHeader(&volumeName, &totalBlocks, dataImage, 0) {
+		return failure.CodedErrorf(failure.KIND_NO_VOLUME_DIRECTORY, "data image does not hold a ProDOS volume directory")
+	}
+	copy(dataImage[:2*diskimage.PRODOS_BLOCK_SIZE], systemImage[:2*diskimage.PRODOS_BLOCK_SIZE])
+	var err error = copyProdosFileToVolumeDirectory(dataImage, systemImage, "PRODOS")
+	if err != nil {
+		return err
+	}
+	return copyProdosFileToVolumeDirectory(dataImage, systemImage, "BASIC.SYSTEM")
+}
+
+// ProDOS bootify section end
+
+// File compare section begin
+
+// readProdosFileBlocks appends the data of block blockNum of the ProDOS ordered diskImage to
+// fileData. indexLevel is 0 for a data block, 1 for an index block and 2 for a master index block,
+// in which case the data of the referenced blocks is appended in order. Sparse (zero) pointers
+// append zero filled data.
+func readProdosFileBlocks(fileData *[]byte, diskImage []byte, blockNum int, indexLevel int) error {
+	if blockNum == 0 {
+		var sparseSize int = diskimage.PRODOS_BLOCK_SIZE
+		for level := 0; level < indexLevel; level = level + 1 {
+			sparseSize = sparseSize * 0x0100
+		}
+		*fileData = append(*fileData, make([]byte, sparseSize)...)
+		return nil
+	}
+	if (blockNum+1)*diskimage.PRODOS_BLOCK_SIZE > len(diskImage) {
+		return fmt.Errorf("block %d is beyond the end of the image", blockNum)
+	}
+	var block []byte = diskimage.ProdosBlockOfImage(diskImage, blockNum)
+	if indexLevel == 0 {
+		*fileData = append(*fileData, block...)
+		return nil
+	}
+	for i := 0; i < 0x0100; i = i + 1 {
+		var err error = readProdosFileBlocks(fileData, diskImage, int(block[i])|int(block[0x0100+i])<<8, indexLevel-1)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReadProdosFile fills fileData with the contents of the file at filePath (names separated by "/",
+// starting from the volume directory) in the ProDOS ordered diskImage, and stores its ProDOS file
+// type into fileType.
+func ReadProdosFile(fileData *[]byte, fileType *byte, diskImage []byte, filePath string) error {
+	var entry []byte
+	var err error = findProdosFileEntry(&entry, diskImage, filePath)
+	if err != nil {
+		return err
+	}
+	var storageType byte = entry[0x00] >> 4
+	if storageType < 0x01 || storageType > 0x03 {
+		return fmt.Errorf("file %s is not a standard file (storage type %d)", filePath, storageType)
+	}
+	var eof int = int(entry[0x15]) | int(entry[0x16])<<8 | int(entry[0x17])<<16
+	*fileData = nil
+	err = readProdosFileBlocks(fileData, diskImage, ProdosEntryKeyBlockNum(entry), int(storageType)-1)
+	if err != nil {
+		return fmt.Errorf("reading %s: %w", filePath, err)
+	}
+	if eof < len(*fileData) {
+		*fileData = (*fileData)[:eof]
+	}
+	*fileType = entry[0x10]
+	return nil
+}
+
+// findProdosFileEntry stores into entry the directory entry of the file at filePath (names separated
+// by "/", starting from the volume directory) in the ProDOS ordered diskImage. It returns an error
+// when a directory of the path or the file is not found.
+func findProdosFileEntry(entry *[]byte, diskImage []byte, filePath string) error {
+	*entry = nil
+	var keyBlockNum int = 0x02
+	for _, fileName := range strings.Split(strings.Trim(filePath, "/"), "/") {
+		if *entry != nil {
+			if (*entry)[0x00]>>4 != 0x0D {
+				return fmt.Errorf("%s is not a directory in path %s", ProdosEntryName(*entry), filePath)
+			}
+			keyBlockNum = ProdosEntryKeyBlockNum(*entry)
+		}
+		var err error = findProdosDirectoryEntry(entry, diskImage, keyBlockNum, strings.ToUpper(fileName))
+		if err != nil {
+			return fmt.Errorf("reading %s: %w", filePath, err)
+		}
+		if *entry == nil {
+			return failure.CodedErrorf(failure.KIND_FILE_NOT_IN_IMAGE, "file %s not found in the image", filePath)
+		}
+	}
+	return nil
+}
+
+// FindDos33CatalogEntry searches the catalog of the DOS 3.3 diskImage (in DOS3.3 sector order) for
+// the file named fileName. When found, the 35 byte entry is stored into entry and true is returned.
+func FindDos33CatalogEntry(entry *[]byte, diskImage []byte, fileName string) bool {
+	var vtoc []byte = diskimage.Dos33SectorOfImage(diskImage, 0x11, 0x00)
+	var catalogTrack int = int(vtoc[0x01])
+	var catalogSector int = int(vtoc[0x02]) & 0x0F
+	var visitedSectors int = 0
+	for catalogTrack != 0 && catalogTrack < 0x23 && visitedSectors < 0x23*0x10 {
+		var catalogSectorData []byte = diskimage.Dos33SectorOfImage(diskImage, catalogTrack, catalogSector)
+		for entryPos := 0x0B; entryPos+0x23 <= 0x0100; entryPos = entryPos + 0x23 {
+			if catalogSectorData[entryPos] == 0x00 || catalogSectorData[entryPos] == 0xFF {
+				// unused or deleted entry
+				continue
+			}
+			var entryName string = strings.TrimRight(string(StripHighBits(catalogSectorData[entryPos+0x03:entryPos+0x21])), " ")
+			if entryName == fileName {
+				*entry = catalogSectorData[entryPos : entryPos+0x23]
+				return true
+			}
+		}
+		catalogTrack = int(catalogSectorData[0x01])
+		catalogSector = int(catalogSectorData[0x02]) & 0x0F
+		visitedSectors = visitedSectors + 1
+	}
+	return false
+}
+
+// readDos33File fills fileData with the sectors listed in the track/sector lists of the file named
+// fileName in the DOS 3.3 diskImage (in DOS3.3 sector order), and stores the DOS 3.3 file type byte
+// (without the lock bit) into fileType. Sparse (zero) track/sector pairs append zero filled sectors.
+// The data ends with the last sector listed, whatever it holds, so that the length in the header of a
+// binary or BASIC file is covered even when its last sectors are all zeros.
+func readDos33File(fileData *[]byte, fileType *byte, diskImage []byte, fileName string) error {
+	var entry []byte
+	if !FindDos33CatalogEntry(&entry, diskImage, fileName) {
+		return failure.CodedErrorf(failure.KIND_FILE_NOT_IN_IMAGE, "file %s not found in the image", fileName)
+	}
+	*fileData = nil
+	var tsListTrack int = int(entry[0x00])
+	var tsListSector int = int(entry[0x01]) & 0x0F
+	var visitedSectors int = 0
+	// the unused pairs at the end of the last track/sector list are left out
+	var listedLength int = 0
+	for tsListTrack != 0 && tsListTrack < 0x23 && visitedSectors < 0x23*0x10 {
+		var tsList []byte = diskimage.Dos33SectorOfImage(diskImage, tsListTrack, tsListSector)
+		for pairPos := 0x0C; pairPos < 0x0100; pairPos = pairPos + 2 {
+			if tsList[pairPos] == 0 || tsList[pairPos] >= 0x23 {
+				*fileData = append(*fileData, make([]byte, 0x0100)...)
+			} else {
+				*fileData = append(*fileData, diskimage.Dos33SectorOfImage(diskImage, int(tsList[pairPos]), int(tsList[pairPos+1])&0x0F)...)
+				listedLength = len(*fileData)
+			}
+		}
+		tsListTrack = int(tsList[0x01])
+		tsListSector = int(tsList[0x02]) & 0x0F
+		visitedSectors = visitedSectors + 1
+	}
+	*fileData = (*fileData)[:listedLength]
+	*fileType = entry[0x02] & 0x7F
+	return nil
+}
+
+// translateDos33FileForHost converts fileData read by readDos33File to its host form according to
+// the DOS 3.3 fileType: text files lose their high bits and end at the first zero byte, with carriage
+// returns becoming line feeds; binary files lose their address and length header; BASIC programs lose
+// their length header. Other file types are left unchanged.
+func translateDos33FileForHost(fileData *[]byte, fileType byte) {
+	var data []byte = *fileData
+	if fileType == 0x00 {
+		var end int = 0
+		for end < len(data) && data[end] != 0x00 {
+			end = end + 1
+		}
+		data = StripHighBits(data[:end])
+		*fileData = []byte(strings.Replace(string(data), "\r", "\n", -1))
+	} else if fileType == 0x04 && len(data) >= 4 {
+		var length int = int(data[2]) | int(data[3])<<8
+		if length+4 <= len(data) {
+			*fileData = data[4 : 4+length]
+		}
+	} else if (fileType == 0x01 || fileType == 0x02) && len(data) >= 2 {
+		var length int = int(data[0]) | int(data[1])<<8
+		if length+2 <= len(data) {
+			*fileData = data[2 : 2+length]
+		}
+	}
+}
+
+// translateProdosFileForHost converts fileData read by ReadProdosFile to its host form according to
+// the ProDOS fileType: text files have carriage returns become line feeds. Other file types are left
+// unchanged.
+func translateProdosFileForHost(fileData *[]byte, fileType byte) {
+	if fileType == 0x04 {
+		*fileData = []byte(strings.Replace(string(StripHighBits(*fileData)), "\r", "\n", -1))
+	}
+}
+
+// ReadFileFromDiskImage fills fileData with the host form of the file at filePath in diskImage (in
+// ProDOS sector order). A ProDOS volume is searched when the image holds one, and otherwise the image
+// is treated as a DOS 3.3 disk.
+func ReadFileFromDiskImage(fileData *[]byte, diskImage []byte, filePath string) error {
+	var volumeName string
+	var totalBlocks int
+	var fileType byte
+	if ReadProdosVolumeHeader(&volumeName, &totalBlocks, diskImage, 0) {
+		var err error = ReadProdosFile(fileData, &fileType, diskImage, filePath)
+		if err != nil {
+			return err
+		}
+		translateProdosFileForHost(fileData, fileType)
+		return nil
+	}
+	if len(diskImage) != diskimage.FLOPPY_IMAGE_SIZE {
+		return failure.CodedErrorf(failure.KIND_UNRECOGNIZED_FILESYSTEM, "image holds neither a ProDOS volume nor a DOS 3.3 disk")
+	}
+	var dos33Image []byte
+	var err error = diskimage.ReorderedDiskImageSectors(&dos33Image, diskImage, diskimage.SECTOR_ORDER_PRODOS, diskimage.SECTOR_ORDER_DOS)
+	if err != nil {
+		return err
+	}
+	err = ValidateDos33Vtoc(dos33Image)
+	if err != nil {
+		return err
+	}
+	err = readDos33File(fileData, &fileType, dos33Image, filePath)
+	if err != nil {
+		return err
+	}
+	translateDos33FileForHost(fileData, fileType)
+	return nil
+}
+
+// CompareFileData writes a report of the differences between imageFileData and hostFileData to
+// stdout, and returns true when they are identical.
+func CompareFileData(imageFileData []byte, hostFileData []byte, imageFileName string, hostFileName string) bool {
+	var differenceCount int = 0
+	var firstDifferencePos int = -1
+	for i := 0; i < len(imageFileData) && i < len(hostFileData); i = i + 1 {
+		if imageFileData[i] != hostFileData[i] {
+			if firstDifferencePos < 0 {
+				firstDifferencePos = i
+			}
+			differenceCount = differenceCount + 1
+		}
+	}
+	if differenceCount == 0 && len(imageFileData) == len(hostFileData) {
+		fmt.Printf("%s and %s are identical (%d bytes)\n", imageFileName, hostFileName, len(imageFileData))
+		return true
+	}
+	if len(imageFileData) != len(hostFileData) {
+		fmt.Printf("%s holds %d bytes, %s holds %d bytes\n", imageFileName, len(imageFileData), hostFileName, len(hostFileData))
+	}
+	if differenceCount > 0 {
+		fmt.Printf("%d bytes differ, first at offset %d (0x%04X): %02X != %02X\n", differenceCount, firstDifferencePos, firstDifferencePos,
+			imageFileData[firstDifferencePos], hostFileData[firstDifferencePos])
+	}
+	return false
+}
+
+// File compare section end
+
+// File name translation section begin
+
+// FileNameFlags holds the flags of add and extract setting how host file names are translated to
+// the names a DOS 3.3 or ProDOS disk accepts, and back.
+type FileNameFlags struct {
+	length      *int
+	replacement *string
+	hostCase    *string
+	hostCharset *string
+	forHost     bool
+}
+
+// AddFileNameFlags adds to flags those translating file names, with -name-case and -name-charset
+// only for the names of host files written (when forHost is set).
+func AddFileNameFlags(flags *flag.FlagSet, forHost bool) *FileNameFlags {
+	var names *FileNameFlags = &FileNameFlags{forHost: forHost}
+	if forHost {
+		names.length = flags.Int("name-length", 0, "longest host file name written, keeping its extension, or 0 for no limit")
+		names.replacement = flags.String("name-replacement", "_", "character put in place of those host file names may not hold")
+		names.hostCase = flags.String("name-case", "keep", "case of the host file names written: keep, upper or lower")
+		names.hostCharset = flags.String("name-charset", "any", "characters host file names may hold: any (all but / \\ and control characters), or portable (letters, digits, . _ and -)")
+	} else {
+		names.length = flags.Int("name-length", 0, "longest file name written to the disk, keeping its extension, or 0 for the 15 characters of ProDOS or the 30 of DOS 3.3")
+		names.replacement = flags.String("name-replacement", ".", "character put in place of those the names of the disk may not hold")
+		var keep, any string = "keep", "any"
+		names.hostCase = &keep
+		names.hostCharset = &any
+	}
+	return names
+}
+
+// check returns an error when the flags of names take values they may not.
+func (names *FileNameFlags) Check() error {
+	if *names.length < 0 {
+		return failure.CodedErrorf(failure.KIND_BAD_NAME_LENGTH, "illegal name length encountered: %d", *names.length)
+	}
+	if len(*names.replacement) != 1 {
+		return failure.CodedErrorf(failure.KIND_BAD_NAME_REPLACEMENT, "the name replacement must be one character, not %q", *names.replacement)
+	}
+	if (names.forHost && strings.ContainsAny(*names.replacement, "/\\.")) || (*names.replacement)[0] < ' ' {
+		return failure.CodedErrorf(failure.KIND_BAD_NAME_REPLACEMENT, "host file names cannot be given %q in place of other characters", *names.replacement)
+	}
+	if *names.hostCase != "keep" && *names.hostCase != "upper" && *names.hostCase != "lower" {
+		return failure.CodedErrorf(failure.KIND_UNKNOWN_VALUE, "unknown name case: %s", *names.hostCase)
+	}
+	if *names.hostCharset != "any" && *names.hostCharset != "portable" {
+		return failure.CodedErrorf(failure.KIND_UNKNOWN_VALUE, "unknown name charset: %s", *names.hostCharset)
+	}
+	return nil
+}
+
+// shortenFileName returns name cut to at most length characters, keeping its extension (from the
+// last period) when the rest of the name can keep a character besides.
+func shortenFileName(name string, length int) string {
+	if length <= 0 || len(name) <= length {
+		return name
+	}
+	var extensionPos int = strings.LastIndex(name, ".")
+	if extensionPos > 0 && len(name)-extensionPos < length {
+		var stemLength int = length - (len(name) - extensionPos)
+		if stemLength > extensionPos {
+			stemLength = extensionPos
+		}
+		return name[:stemLength] + name[extensionPos:]
+	}
+	return name[:length]
+}
+
+// TranslateHostFileName stores into fileName the name of a file of a ProDOS volume (when isProdos is
+// set) or of a DOS 3.3 disk for the host file name hostFileName, upper cased, with the characters the
+// disk does not accept replaced as names sets, up to the first letter dropped, and shortened to the
+// limit of the disk or to the length names sets. A name changed other than by its case is reported to
+// stderr.
+func TranslateHostFileName(fileName *string, hostFileName string, isProdos bool, names *FileNameFlags) error {
+	var replacement string = strings.ToUpper(*names.replacement)
+	var accepted func(c byte) bool = func(c byte) bool {
+		// DOS 3.3 takes any character the apple ][ keyboard types, but a comma ends the name
+		return c >= ' ' && c <= '^' && c != ','
+	}
+	var length int = 0x1E
+	if isProdos {
+		accepted = func(c byte) bool {
+			return (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9') || c == '.'
+		}
+		length = 0x0F
+	}
+	if !accepted(replacement[0]) {
+		return failure.CodedErrorf(failure.KIND_BAD_NAME_REPLACEMENT, "the names of the disk cannot hold %q", replacement)
+	}
+	if *names.length > 0 && *names.length < length {
+		length = *names.length
+	}
+	var name []byte
+	for _, c := range []byte(strings.ToUpper(hostFileName)) {
+		if !accepted(c) {
+			c = replacement[0]
+		}
+		if len(name) > 0 || (c >= 'A' && c <= 'Z') {
+			name = append(name, c)
+		}
+	}
+	if len(name) == 0 {
+		return fmt.Errorf("%s holds no letter to start a file name of the disk with", hostFileName)
+	}
+	*fileName = shortenFileName(string(name), length)
+	if *fileName != strings.ToUpper(hostFileName) {
+		fmt.Fprintf(os.Stderr, "renamed host file %s to %s\n", hostFileName, *fileName)
+	}
+	return nil
+}
+
+// TranslateDiskFileName stores into hostFileName the name of a host file for the name fileName of a
+// file of a disk, with the characters host file names may not hold replaced, in the case, and
+// shortened to the length names sets. A name made only of periods, which names a directory on the
+// host, has them replaced as well. A name changed other than by its case is reported to stderr.
+func TranslateDiskFileName(hostFileName *string, fileName string, names *FileNameFlags) {
+	var name []byte
+	for _, c := range []byte(fileName) {
+		var accepted bool = c != '/' && c != '\\' && c >= ' '
+		if *names.hostCharset == "portable" {
+			accepted = (c >= 'A' && c <= 'Z') || (c >= 'a' && c <= 'z') || (c >= '0' && c <= '9') || c == '.' || c == '_' || c == '-'
+		}
+		if !accepted {
+			c = (*names.replacement)[0]
+		}
+		name = append(name, c)
+	}
+	if strings.Trim(string(name), ".") == "" {
+		name = []byte(strings.Repeat(*names.replacement, len(name)))
+	}
+	*hostFileName = shortenFileName(string(name), *names.length)
+	if *names.hostCase == "upper" {
+		*hostFileName = strings.ToUpper(*hostFileName)
+	} else if *names.hostCase == "lower" {
+		*hostFileName = strings.ToLower(*hostFileName)
+	}
+	if !strings.EqualFold(*hostFileName, fileName) {
+		fmt.Fprintf(os.Stderr, "renamed %s to host file %s\n", fileName, *hostFileName)
+	}
+}
+
+// File name translation section end
+
+// File extract section begin
+
+// uniqueHostFilepath returns hostFilepath when no file is there, and otherwise the first of the
+// names with _2, _3 and so on added before its extension which is free, so that extracting never
+// overwrites a host file, nor one extracted before under the same host name.
+func uniqueHostFilepath(hostFilepath string) string {
+	var extension string = filepath.Ext(hostFilepath)
+	var stem string = strings.TrimSuffix(hostFilepath, extension)
+	var candidate string = hostFilepath
+	for n := 2; ; n = n + 1 {
+		_, err := os.Lstat(candidate)
+		if os.IsNotExist(err) {
+			return candidate
+		}
+		candidate = fmt.Sprintf("%s_%d%s", stem, n, extension)
+	}
+}
+
+// writeExtractedFile writes fileData to the file hostFilepath, or under another name from
+// uniqueHostFilepath when a file is already there, reporting it to stderr. The modification time of
+// the host file is set to modTime, unless it is the zero time.
+func writeExtractedFile(hostFilepath string, fileData []byte, modTime time.Time) error {
+	var uniqueFilepath string = uniqueHostFilepath(hostFilepath)
+	var err error = ioutil.WriteFile(uniqueFilepath, fileData, 0644)
+	if err != nil {
+		return err
+	}
+	if !modTime.IsZero() {
+		err = os.Chtimes(uniqueFilepath, modTime, modTime)
+		if err != nil {
+			return err
+		}
+	}
+	if uniqueFilepath != hostFilepath {
+		fmt.Fprintf(os.Stderr, "extracted %d bytes to file %s, as %s already exists\n", len(fileData), uniqueFilepath, hostFilepath)
+	} else {
+		fmt.Fprintf(os.Stderr, "extracted %d bytes to file %s\n", len(fileData), hostFilepath)
+	}
+	return nil
+}
+
+// isFileNamePattern returns whether the file name filePath given for extract is a pattern, holding
+// one of the characters * ? or [ of a glob.
+func isFileNamePattern(filePath string) bool {
+	return strings.ContainsAny(filePath, "*?[")
+}
+
+// matchDiskImageFiles stores into fileNames the paths of the files of diskImage (in ProDOS sector
+// order) matching pattern, a glob as for host file names in which * and ? do not match the / between
+// ProDOS directories, compared without regard to case. It returns an error when the pattern is
+// malformed, or matches no file.
+func matchDiskImageFiles(fileNames *[]string, diskImage []byte, pattern string) error {
+	var title string
+	var allFileNames []string
+	var err error = ListDiskImageFiles(&title, &allFileNames, diskImage)
+	if err != nil {
+		return err
+	}
+	*fileNames = nil
+	for _, fileName := range allFileNames {
+		if strings.HasSuffix(fileName, "/") {
+			// a directory
+			continue
+		}
+		matched, err := path.Match(strings.ToUpper(pattern), strings.ToUpper(fileName))
+		if err != nil {
+			return failure.CodedErrorf(failure.KIND_BAD_PATTERN, "malformed file name pattern %s: %w", pattern, err)
+		}
+		if matched {
+			*fileNames = append(*fileNames, fileName)
+		}
+	}
+	if len(*fileNames) == 0 {
+		return failure.CodedErrorf(failure.KIND_FILE_NOT_IN_IMAGE, "no file of the image matches %s", pattern)
+	}
+	return nil
+}
+
+// extractProdosDirectory writes the host form of every file in the directory with key block
+// keyBlockNum (named directoryPath) of the ProDOS ordered diskImage into the host directory
+// hostDirectory, creating a host directory for each subdirectory and extracting it as well. Files
+// which are not standard files (such as the forked files of GS/OS), entries whose names ProDOS
+// would not accept (which could otherwise name a host path outside hostDirectory), and
+// subdirectories whose key block is in visitedKeyBlocks, the directories already extracted (which a
+// damaged image can link into a loop), are skipped and reported. Host file names are translated as
+// names sets.
+func extractProdosDirectory(diskImage []byte, keyBlockNum int, directoryPath string, hostDirectory string, names *FileNameFlags, visitedKeyBlocks map[int]bool) error {
+	visitedKeyBlocks[keyBlockNum] = true
+	var err error
+	var walkErr error = ForEachProdosDirectoryEntry(diskImage, keyBlockNum, func(entry []byte, blockNum int) bool {
+		var storageType byte = entry[0x00] >> 4
+		var name string = ProdosEntryName(entry)
+		if ((storageType >= 0x01 && storageType <= 0x05) || storageType == 0x0D) && !PRODOS_FILE_NAME_PATTERN.MatchString(name) {
+			// a damaged or crafted entry could name a path outside hostDirectory, such as ..
+			fmt.Fprintf(os.Stderr, "skipped %s%q, which is not a valid ProDOS name\n", directoryPath, name)
+			return true
+		}
+		var hostFileName string
+		TranslateDiskFileName(&hostFileName, name, names)
+		var hostFilepath string = filepath.Join(hostDirectory, hostFileName)
+		if storageType == 0x0D && visitedKeyBlocks[ProdosEntryKeyBlockNum(entry)] {
+			fmt.Fprintf(os.Stderr, "skipped %s%s, which is a directory already extracted\n", directoryPath, name)
+		} else if storageType == 0x0D {
+			err = os.MkdirAll(hostFilepath, 0755)
+			if err == nil {
+				err = extractProdosDirectory(diskImage, ProdosEntryKeyBlockNum(entry), directoryPath+name+"/", hostFilepath, names, visitedKeyBlocks)
+			}
+		} else if storageType >= 0x01 && storageType <= 0x03 {
+			var fileData []byte
+			var fileType byte
+			err = ReadProdosFile(&fileData, &fileType, diskImage, directoryPath+name)
+			if err == nil {
+				translateProdosFileForHost(&fileData, fileType)
+				err = writeExtractedFile(hostFilepath, fileData, prodosEntryModTime(entry))
+			}
+		} else if storageType == 0x04 || storageType == 0x05 {
+			fmt.Fprintf(os.Stderr, "skipped %s%s, which is not a standard file (storage type %d)\n", directoryPath, name, storageType)
+		}
+		return err == nil
+	})
+	if err != nil {
+		return err
+	}
+	if walkErr != nil {
+		return fmt.Errorf("extracting directory /%s: %w", directoryPath, walkErr)
+	}
+	return nil
+}
+
+// ExtractDiskImageFiles writes the host form (as for cmp) of the file at filePath in diskImage (in
+// ProDOS sector order) into the host directory hostDirectory, of every file matching filePath when it
+// is a pattern (see matchDiskImageFiles), or of every file of the image when filePath is empty.
+// ProDOS subdirectories become host directories when every file is extracted, while the files
+// matching a pattern all go into hostDirectory itself. Host file names are translated as names sets
+// (see TranslateDiskFileName), and host files are never overwritten (see writeExtractedFile).
+func ExtractDiskImageFiles(diskImage []byte, filePath string, hostDirectory string, names *FileNameFlags) error {
+	var err error = os.MkdirAll(hostDirectory, 0755)
+	if err != nil {
+		return err
+	}
+	var volumeName string
+	var totalBlocks int
+	var isProdos bool = ReadProdosVolumeHeader(&volumeName, &totalBlocks, diskImage, 0)
+	var fileNames []string
+	if isFileNamePattern(filePath) {
+		err = matchDiskImageFiles(&fileNames, diskImage, filePath)
+		if err != nil {
+			return err
+		}
+	} else if filePath != "" {
+		fileNames = []string{filePath}
+	} else if isProdos {
+		return extractProdosDirectory(diskImage, 0x02, "", hostDirectory, names, make(map[int]bool))
+	} else {
+		var title string
+		err = ListDiskImageFiles(&title, &fileNames, diskImage)
+		if err != nil {
+			return err
+		}
+	}
+	for _, fileName := range fileNames {
+		var fileData []byte
+		err = ReadFileFromDiskImage(&fileData, diskImage, fileName)
+		if err != nil {
+			return err
+		}
+		var hostFileName string
+		var modTime time.Time
+		if isProdos {
+			TranslateDiskFileName(&hostFileName, path.Base(fileName), names)
+			var entry []byte
+			err = findProdosFileEntry(&entry, diskImage, fileName)
+			if err != nil {
+				return err
+			}
+			modTime = prodosEntryModTime(entry)
+		} else {
+			TranslateDiskFileName(&hostFileName, fileName, names)
+		}
+		err = writeExtractedFile(filepath.Join(hostDirectory, hostFileName), fileData, modTime)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ListProdosDirectoryFiles appends to fileNames the path of every file in the directory with key block
+// keyBlockNum (named directoryPath) of the ProDOS ordered diskImage, and in its subdirectories, in
+// catalog order. Subdirectories are listed with a trailing "/", and their files follow unless their
+// key block is in visitedKeyBlocks, the directories already listed (which a damaged image can link
+// into a loop).
+func ListProdosDirectoryFiles(fileNames *[]string, diskImage []byte, keyBlockNum int, directoryPath string, visitedKeyBlocks map[int]bool) error {
+	visitedKeyBlocks[keyBlockNum] = true
+	var err error
+	var walkErr error = ForEachProdosDirectoryEntry(diskImage, keyBlockNum, func(entry []byte, blockNum int) bool {
+		var storageType byte = entry[0x00] >> 4
+		var entryPath string = directoryPath + ProdosEntryName(entry)
+		if storageType == 0x0D {
+			*fileNames = append(*fileNames, entryPath+"/")
+			if !visitedKeyBlocks[ProdosEntryKeyBlockNum(entry)] {
+				err = ListProdosDirectoryFiles(fileNames, diskImage, ProdosEntryKeyBlockNum(entry), entryPath+"/", visitedKeyBlocks)
+			}
+		} else if storageType >= 0x01 && storageType <= 0x05 {
+			*fileNames = append(*fileNames, entryPath)
+		}
+		return err == nil
+	})
+	if err != nil {
+		return err
+	}
+	if walkErr != nil {
+		return fmt.Errorf("listing directory /%s: %w", directoryPath, walkErr)
+	}
+	return nil
+}
+
+// listDos33CatalogFiles appends to fileNames the name of every file in the catalog of the DOS 3.3
+// diskImage (in DOS3.3 sector order), in catalog order.
+func listDos33CatalogFiles(fileNames *[]string, diskImage []byte) {
+	var vtoc []byte = diskimage.Dos33SectorOfImage(diskImage, 0x11, 0x00)
+	var catalogTrack int = int(vtoc[0x01])
+	var catalogSector int = int(vtoc[0x02]) & 0x0F
+	var visitedSectors int = 0
+	for catalogTrack != 0 && catalogTrack < 0x23 && visitedSectors < 0x23*0x10 {
+		var catalogSectorData []byte = diskimage.Dos33SectorOfImage(diskImage, catalogTrack, catalogSector)
+		for entryPos := 0x0B; entryPos+0x23 <= 0x0100; entryPos = entryPos + 0x23 {
+			if catalogSectorData[entryPos] == 0x00 || catalogSectorData[entryPos] == 0xFF {
+				// unused or deleted entry
+				continue
+			}
+			*fileNames = append(*fileNames, strings.TrimRight(string(StripHighBits(catalogSectorData[entryPos+0x03:entryPos+0x21])), " "))
+		}
+		catalogTrack = int(catalogSectorData[0x01])
+		catalogSector = int(catalogSectorData[0x02]) & 0x0F
+		visitedSectors = visitedSectors + 1
+	}
+}
+
+// ListDiskImageFiles fills fileNames with the files of diskImage (in ProDOS sector order) and stores a
+// title for the disk into title: the volume name of a ProDOS volume, or the volume number of a DOS 3.3
+// disk.
+func ListDiskImageFiles(title *string, fileNames *[]string, diskImage []byte) error {
+	var volumeName string
+	var totalBlocks int
+	*fileNames = nil
+	if ReadProdosVolumeHeader(&volumeName, &totalBlocks, diskImage, 0) {
+		*title = "/" + volumeName
+		return ListProdosDirectoryFiles(fileNames, diskImage, 0x02, "", make(map[int]bool))
+	}
+	if len(diskImage) != diskimage.FLOPPY_IMAGE_SIZE {
+		return failure.CodedErrorf(failure.KIND_UNRECOGNIZED_FILESYSTEM, "image holds neither a ProDOS volume nor a DOS 3.3 disk")
+	}
+	var dos33Image []byte
+	var err error = diskimage.ReorderedDiskImageSectors(&dos33Image, diskImage, diskimage.SECTOR_ORDER_PRODOS, diskimage.SECTOR_ORDER_DOS)
+	if err != nil {
+		return err
+	}
+	err = ValidateDos33Vtoc(dos33Image)
+	if err != nil {
+		return err
+	}
+	*title = fmt.Sprintf("DOS 3.3 VOLUME %03d", diskimage.Dos33SectorOfImage(dos33Image, 0x11, 0x00)[0x06])
+	listDos33CatalogFiles(fileNames, dos33Image)
+	return nil
+}
+
+// File extract section end
+
+// File add section begin
+
+// FILE_TYPE_ALIASES maps the DOS 3.3 type letters to the ProDOS file type names of the same kind of
+// file, and back, so that -file-type may be given either way for both kinds of disk.
+var FILE_TYPE_ALIASES map[string]string = map[string]string{
+	"T": "TXT", "I": "INT", "A": "BAS", "B": "BIN", "TXT": "T", "INT": "I", "BAS": "A", "BIN": "B"}
+
+// parseFileTypeName stores into fileType the ProDOS file type (when isProdos is set) or the DOS 3.3
+// file type named typeName: a name such as BIN or TXT, a DOS 3.3 type letter such as B or T, or for
+// ProDOS a $ followed by the type in hexadecimal.
+func parseFileTypeName(fileType *byte, typeName string, isProdos bool) error {
+	typeName = strings.ToUpper(typeName)
+	if isProdos && strings.HasPrefix(typeName, "$") {
+		var value uint64
+		value, err := strconv.ParseUint(typeName[1:], 16, 8)
+		if err != nil {
+			return failure.CodedErrorf(failure.KIND_UNKNOWN_VALUE, "unknown file type: %s", typeName)
+		}
+		*fileType = byte(value)
+		return nil
+	}
+	var names map[byte]string = DOS33_FILE_TYPE_LETTERS
+	if isProdos {
+		names = PRODOS_FILE_TYPE_NAMES
+	}
+	var found bool = false
+	for value, name := range names {
+		// the new A and B types of DOS 3.3 share their letters with the old ones, which are chosen
+		if (name == typeName || name == FILE_TYPE_ALIASES[typeName]) && (!found || value < *fileType) {
+			*fileType = value
+			found = true
+		}
+	}
+	if !found {
+		return failure.CodedErrorf(failure.KIND_UNKNOWN_VALUE, "unknown file type: %s", typeName)
+	}
+	return nil
+}
+
+// defaultAuxType returns the load address (or aux type) a file of ProDOS file type fileType is given
+// when -load-address is not: 0x2000 for binary and system files, 0x0801 for Applesoft programs, and 0
+// for other files.
+func defaultAuxType(fileType byte) int {
+	if fileType == 0x06 || fileType == 0xFF {
+		return 0x2000
+	} else if fileType == 0xFC {
+		return 0x0801
+	}
+	return 0
+}
+
+// translateHostFileForDos33 converts fileData from its host form to the form a file of the DOS 3.3
+// fileType holds on the disk, reversing translateDos33FileForHost: text files get line feeds turned
+// into carriage returns and the high bit set, binary files get their loadAddress and length header,
+// and BASIC programs their length header.
+func translateHostFileForDos33(fileData *[]byte, fileType byte, loadAddress int) error {
+	var data []byte = *fileData
+	if fileType != 0x00 && len(data) > 0xFFFF {
+		return fmt.Errorf("a DOS 3.3 file of type %s holds at most 65535 bytes, not %d", DOS33_FILE_TYPE_LETTERS[fileType], len(data))
+	}
+	if fileType == 0x00 {
+		var text []byte = []byte(strings.Replace(string(data), "\n", "\r", -1))
+		for i := range text {
+			text[i] = text[i] | 0x80
+		}
+		*fileData = text
+	} else if fileType == 0x04 {
+		*fileData = append([]byte{byte(loadAddress & 0xFF), byte(loadAddress >> 8), byte(len(data) & 0xFF), byte(len(data) >> 8)}, data...)
+	} else if fileType == 0x01 || fileType == 0x02 {
+		*fileData = append([]byte{byte(len(data) & 0xFF), byte(len(data) >> 8)}, data...)
+	}
+	return nil
+}
+
+// allocateDos33Sector finds a free sector in the VTOC bitmap of the DOS 3.3 diskImage (in DOS3.3
+// sector order), marks it as in use, zero fills it and returns its track and sector. Like DOS, the
+// tracks after the catalog track are used first, then those before it.
+func allocateDos33Sector(track *int, sector *int, diskImage []byte) error {
+	var vtoc []byte = diskimage.Dos33SectorOfImage(diskImage, 0x11, 0x00)
+	for i := 0x01; i < 0x23; i = i + 1 {
+		var candidateTrack int = 0x11 + i
+		if candidateTrack >= 0x23 {
+			candidateTrack = 0x11 - (candidateTrack - 0x22)
+		}
+		for candidateSector := 0x0F; candidateSector >= 0x00; candidateSector = candidateSector - 1 {
+			// a set bit marks a free sector, sectors 15 to 8 in the first byte and 7 to 0 in the second
+			var bytePos int = 0x38 + 4*candidateTrack + 1 - candidateSector/8
+			var mask byte = 1 << uint(candidateSector%8)
+			if vtoc[bytePos]&mask != 0 {
+				vtoc[bytePos] = vtoc[bytePos] &^ mask
+				copy(diskimage.Dos33SectorOfImage(diskImage, candidateTrack, candidateSector), make([]byte, 0x0100))
+				*track = candidateTrack
+				*sector = candidateSector
+				return nil
+			}
+		}
+	}
+	return failure.CodedErrorf(failure.KIND_DISK_FULL, "no free sectors left on the DOS 3.3 disk")
+}
+
+// addDos33File writes fileData (in its disk form) as a new file named fileName of DOS 3.3 fileType
+// into the DOS 3.3 diskImage (in DOS3.3 sector order), allocating its track/sector lists and data
+// sectors and adding its entry to the first unused catalog entry.
+func addDos33File(diskImage []byte, fileName string, fileType byte, fileData []byte) error {
+	var existingEntry []byte
+	if FindDos33CatalogEntry(&existingEntry, diskImage, fileName) {
+		return failure.CodedErrorf(failure.KIND_FILE_EXISTS, "file %s is already in the destination image", fileName)
+	}
+	var catalogEntry []byte
+	var vtoc []byte = diskimage.Dos33SectorOfImage(diskImage, 0x11, 0x00)
+	var catalogTrack int = int(vtoc[0x01])
+	var catalogSector int = int(vtoc[0x02]) & 0x0F
+	var visitedSectors int = 0
+	for catalogEntry == nil && catalogTrack != 0 && catalogTrack < 0x23 && visitedSectors < 0x23*0x10 {
+		var catalogSectorData []byte = diskimage.Dos33SectorOfImage(diskImage, catalogTrack, catalogSector)
+		for entryPos := 0x0B; catalogEntry == nil && entryPos+0x23 <= 0x0100; entryPos = entryPos + 0x23 {
+			if catalogSectorData[entryPos] == 0x00 {
+				catalogEntry = catalogSectorData[entryPos : entryPos+0x23]
+			}
+		}
+		catalogTrack = int(catalogSectorData[0x01])
+		catalogSector = int(catalogSectorData[0x02]) & 0x0F
+		visitedSectors = visitedSectors + 1
+	}
+	if catalogEntry == nil {
+		return failure.CodedErrorf(failure.KIND_DISK_FULL, "no free catalog entry left for file %s", fileName)
+	}
+	// each track/sector list holds the pairs of 122 data sectors
+	var sectorCount int = 0
+	var tsList []byte
+	var firstTsListTrack, firstTsListSector int
+	for pos := 0; pos < len(fileData) || pos == 0; pos = pos + 0x0100 {
+		var pairIndex int = (pos / 0x0100) % 122
+		if pairIndex == 0 {
+			var tsListTrack, tsListSector int
+			var err error = allocateDos33Sector(&tsListTrack, &tsListSector, diskImage)
+			if err != nil {
+				return fmt.Errorf("adding file %s: %w", fileName, err)
+			}
+			sectorCount = sectorCount + 1
+			if tsList == nil {
+				firstTsListTrack = tsListTrack
+				firstTsListSector = tsListSector
+			} else {
+				tsList[0x01] = byte(tsListTrack)
+				tsList[0x02] = byte(tsListSector)
+			}
+			tsList = diskimage.Dos33SectorOfImage(diskImage, tsListTrack, tsListSector)
+			var sectorOffset int = pos / 0x0100
+			tsList[0x05] = byte(sectorOffset & 0xFF)
+			tsList[0x06] = byte(sectorOffset >> 8)
+		}
+		var dataTrack, dataSector int
+		var err error = allocateDos33Sector(&dataTrack, &dataSector, diskImage)
+		if err != nil {
+			return fmt.Errorf("adding file %s: %w", fileName, err)
+		}
+		sectorCount = sectorCount + 1
+		tsList[0x0C+2*pairIndex] = byte(dataTrack)
+		tsList[0x0D+2*pairIndex] = byte(dataSector)
+		if pos < len(fileData) {
+			copy(diskimage.Dos33SectorOfImage(diskImage, dataTrack, dataSector), fileData[pos:])
+		}
+	}
+	catalogEntry[0x00] = byte(firstTsListTrack)
+	catalogEntry[0x01] = byte(firstTsListSector)
+	catalogEntry[0x02] = fileType
+	for i := 0; i < 0x1E; i = i + 1 {
+		catalogEntry[0x03+i] = ' ' | 0x80
+		if i < len(fileName) {
+			catalogEntry[0x03+i] = fileName[i] | 0x80
+		}
+	}
+	catalogEntry[0x21] = byte(sectorCount & 0xFF)
+	catalogEntry[0x22] = byte(sectorCount >> 8)
+	fmt.Fprintf(os.Stderr, "added file %s using %d sectors\n", fileName, sectorCount)
+	return nil
+}
+
+// PRODOS_FILE_NAME_PATTERN matches the names ProDOS accepts for files.
+var PRODOS_FILE_NAME_PATTERN *regexp.Regexp = regexp.MustCompile(`^[A-Z][A-Z0-9.]{0,14}$`)
+
+// writeProdosFileBlocks writes fileData into newly allocated blocks of the ProDOS ordered diskImage,
+// as a seedling (one data block), sapling (an index block of up to 256 data blocks) or tree (a master
+// index block of up to 128 index blocks) file, storing the number of its key block, its storage type
+// and the count of allocated blocks into keyBlockNum, storageType and blocksUsed.
+func writeProdosFileBlocks(keyBlockNum *int, storageType *byte, blocksUsed *int, diskImage []byte, fileData []byte) error {
+	var dataBlockCount int = (len(fileData) + diskimage.PRODOS_BLOCK_SIZE - 1) / diskimage.PRODOS_BLOCK_SIZE
+	if dataBlockCount == 0 {
+		dataBlockCount = 1
+	}
+	if dataBlockCount > 0x80*0x0100 {
+		return fmt.Errorf("a file of %d bytes is larger than ProDOS allows", len(fileData))
+	}
+	var err error
+	var dataBlockNums []int
+	for i := 0; i < dataBlockCount; i = i + 1 {
+		var blockNum int
+		err = allocateProdosBlock(&blockNum, diskImage)
+		if err != nil {
+			return err
+		}
+		if i*diskimage.PRODOS_BLOCK_SIZE < len(fileData) {
+			copy(diskimage.ProdosBlockOfImage(diskImage, blockNum), fileData[i*diskimage.PRODOS_BLOCK_SIZE:])
+		}
+		dataBlockNums = append(dataBlockNums, blockNum)
+	}
+	*blocksUsed = dataBlockCount
+	if dataBlockCount == 1 {
+		*storageType = 0x01
+		*keyBlockNum = dataBlockNums[0]
+		return nil
+	}
+	// index blocks hold the low bytes of 256 block pointers followed by the high bytes
+	var indexBlockNums []int
+	for i := 0; i < dataBlockCount; i = i + 0x0100 {
+		var indexBlockNum int
+		err = allocateProdosBlock(&indexBlockNum, diskImage)
+		if err != nil {
+			return err
+		}
+		var indexBlock []byte = diskimage.ProdosBlockOfImage(diskImage, indexBlockNum)
+		for j := 0; j < 0x0100 && i+j < dataBlockCount; j = j + 1 {
+			indexBlock[j] = byte(dataBlockNums[i+j] & 0xFF)
+			indexBlock[0x0100+j] = byte(dataBlockNums[i+j] >> 8)
+		}
+		indexBlockNums = append(indexBlockNums, indexBlockNum)
+	}
+	*blocksUsed = *blocksUsed + len(indexBlockNums)
+	if len(indexBlockNums) == 1 {
+		*storageType = 0x02
+		*keyBlockNum = indexBlockNums[0]
+		return nil
+	}
+	var masterIndexBlockNum int
+	err = allocateProdosBlock(&masterIndexBlockNum, diskImage)
+	if err != nil {
+		return err
+	}
+	var masterIndexBlock []byte = diskimage.ProdosBlockOfImage(diskImage, masterIndexBlockNum)
+	for i, indexBlockNum := range indexBlockNums {
+		masterIndexBlock[i] = byte(indexBlockNum & 0xFF)
+		masterIndexBlock[0x0100+i] = byte(indexBlockNum >> 8)
+	}
+	*blocksUsed = *blocksUsed + 1
+	*storageType = 0x03
+	*keyBlockNum = masterIndexBlockNum
+	return nil
+}
+
+// ProDOS stores the year in two digits, those from 40 standing for 1940 to 1999 and those below for
+// 2000 to 2039, as ProDOS 2 reads them.
+const PRODOS_FIRST_YEAR = 1940
+const PRODOS_LAST_YEAR = 2039
+
+// prodosDateTime returns dateTime as the 4 bytes ProDOS stores in a directory entry, to the minute.
+func prodosDateTime(dateTime time.Time) []byte {
+	var date int = dateTime.Day() | int(dateTime.Month())<<5 | (dateTime.Year()%100)<<9
+	return []byte{byte(date & 0xFF), byte(date >> 8), byte(dateTime.Minute()), byte(dateTime.Hour())}
+}
+
+// parseProdosDateTime stores into dateTime the local time of the 4 byte ProDOS date and time in
+// entryDateTime, returning false when it holds no valid date.
+func parseProdosDateTime(dateTime *time.Time, entryDateTime []byte) bool {
+	var date int = int(entryDateTime[0x00]) | int(entryDateTime[0x01])<<8
+	var day int = date & 0x1F
+	var month int = (date >> 5) & 0x0F
+	var year int = 1900 + date>>9
+	if year < PRODOS_FIRST_YEAR {
+		year = year + 100
+	}
+	var hour int = int(entryDateTime[0x03] & 0x1F)
+	var minute int = int(entryDateTime[0x02] & 0x3F)
+	if day < 1 || day > 31 || month < 1 || month > 12 || hour > 23 || minute > 59 {
+		return false
+	}
+	*dateTime = time.Date(year, time.Month(month), day, hour, minute, 0, 0, time.Local)
+	return true
+}
+
+// prodosEntryModTime returns the modification time of the ProDOS directory entry, or its creation
+// time when it holds no modification date, or the zero time when it holds neither.
+func prodosEntryModTime(entry []byte) time.Time {
+	var modTime time.Time
+	if !parseProdosDateTime(&modTime, entry[0x21:0x25]) {
+		parseProdosDateTime(&modTime, entry[0x18:0x1C])
+	}
+	return modTime
+}
+
+// AddProdosFile writes fileData as a new file at filePath (names separated by "/", starting from the
+// volume directory) of ProDOS fileType and auxType into the ProDOS ordered diskImage, allocating its
+// blocks and adding its entry to the first free entry of the directory holding it, created and
+// modified at dateTime.
+func AddProdosFile(diskImage []byte, filePath string, fileType byte, auxType int, fileData []byte, dateTime time.Time) error {
+	var names []string = strings.Split(strings.ToUpper(strings.Trim(filePath, "/")), "/")
+	var fileName string = names[len(names)-1]
+	if !PRODOS_FILE_NAME_PATTERN.MatchString(fileName) {
+		return fmt.Errorf("%s is not a ProDOS file name, which has up to 15 letters, digits and periods, starting with a letter", fileName)
+	}
+	var keyBlockNum int = 0x02
+	var err error
+	for _, directoryName := range names[:len(names)-1] {
+		var directoryEntry []byte
+		err = findProdosDirectoryEntry(&directoryEntry, diskImage, keyBlockNum, directoryName)
+		if err != nil {
+			return fmt.Errorf("adding %s: %w", filePath, err)
+		}
+		if directoryEntry == nil || directoryEntry[0x00]>>4 != 0x0D {
+			return failure.CodedErrorf(failure.KIND_FILE_NOT_IN_IMAGE, "directory %s not found in the image", directoryName)
+		}
+		keyBlockNum = ProdosEntryKeyBlockNum(directoryEntry)
+	}
+	var existingEntry []byte
+	err = findProdosDirectoryEntry(&existingEntry, diskImage, keyBlockNum, fileName)
+	if err != nil {
+		return fmt.Errorf("adding %s: %w", filePath, err)
+	}
+	if existingEntry != nil {
+		return failure.CodedErrorf(failure.KIND_FILE_EXISTS, "file %s is already in the destination directory", filePath)
+	}
+	var entry []byte
+	err = ForEachProdosDirectoryEntry(diskImage, keyBlockNum, func(directoryEntry []byte, blockNum int) bool {
+		if directoryEntry[0x00]>>4 != 0x00 {
+			return true
+		}
+		entry = directoryEntry
+		return false
+	})
+	if err != nil {
+		return fmt.Errorf("adding %s: %w", filePath, err)
+	}
+	if entry == nil {
+		return failure.CodedErrorf(failure.KIND_DISK_FULL, "no free entry left in the directory for file %s", filePath)
+	}
+	var storageType byte
+	var blocksUsed int
+	var fileKeyBlockNum int
+	err = writeProdosFileBlocks(&fileKeyBlockNum, &storageType, &blocksUsed, diskImage, fileData)
+	if err != nil {
+		return fmt.Errorf("adding %s: %w", filePath, err)
+	}
+	copy(entry, make([]byte, len(entry)))
+	entry[0x00] = storageType<<4 | byte(len(fileName))
+	copy(entry[0x01:0x10], fileName)
+	entry[0x10] = fileType
+	entry[0x11] = byte(fileKeyBlockNum & 0xFF)
+	entry[0x12] = byte(fileKeyBlockNum >> 8)
+	entry[0x13] = byte(blocksUsed & 0xFF)
+	entry[0x14] = byte(blocksUsed >> 8)
+	entry[0x15] = byte(len(fileData) & 0xFF)
+	entry[0x16] = byte((len(fileData) >> 8) & 0xFF)
+	entry[0x17] = byte(len(fileData) >> 16)
+	copy(entry[0x18:0x1C], prodosDateTime(dateTime))
+	// destroy, rename, write and read enabled
+	entry[0x1E] = 0xE3
+	entry[0x1F] = byte(auxType & 0xFF)
+	entry[0x20] = byte(auxType >> 8)
+	copy(entry[0x21:0x25], prodosDateTime(dateTime))
+	entry[0x25] = byte(keyBlockNum & 0xFF)
+	entry[0x26] = byte(keyBlockNum >> 8)
+	var keyBlock []byte = diskimage.ProdosBlockOfImage(diskImage, keyBlockNum)
+	var fileCount int = int(keyBlock[0x04+0x21]) | int(keyBlock[0x04+0x22])<<8
+	fileCount = fileCount + 1
+	keyBlock[0x04+0x21] = byte(fileCount & 0xFF)
+	keyBlock[0x04+0x22] = byte(fileCount >> 8)
+	fmt.Fprintf(os.Stderr, "added file %s using %d blocks\n", filePath, blocksUsed)
+	return nil
+}
+
+// AddHostFileToDiskImage writes the host file hostFileData as the file filePath of diskImage (in
+// ProDOS sector order), of the file type named typeName loading at loadAddress (or the default
+// address of its type when negative). The file is added to the ProDOS volume when the image holds
+// one, created and modified at dateTime, and otherwise to the DOS 3.3 disk, which keeps no dates,
+// after converting it from its host form.
+func AddHostFileToDiskImage(diskImage []byte, filePath string, hostFileData []byte, typeName string, loadAddress int, dateTime time.Time) error {
+	var volumeName string
+	var totalBlocks int
+	var fileType byte
+	var err error
+	if ReadProdosVolumeHeader(&volumeName, &totalBlocks, diskImage, 0) {
+		err = parseFileTypeName(&fileType, typeName, true)
+		if err != nil {
+			return err
+		}
+		if loadAddress < 0 {
+			loadAddress = defaultAuxType(fileType)
+		}
+		if fileType == 0x04 {
+			hostFileData = []byte(strings.Replace(string(hostFileData), "\n", "\r", -1))
+		}
+		return AddProdosFile(diskImage, filePath, fileType, loadAddress, hostFileData, dateTime)
+	}
+	if len(diskImage) != diskimage.FLOPPY_IMAGE_SIZE {
+		return failure.CodedErrorf(failure.KIND_UNRECOGNIZED_FILESYSTEM, "image holds neither a ProDOS volume nor a DOS 3.3 disk")
+	}
+	if len(filePath) > 0x1E {
+		return fmt.Errorf("%s is longer than the 30 characters of a DOS 3.3 file name", filePath)
+	}
+	err = parseFileTypeName(&fileType, typeName, false)
+	if err != nil {
+		return err
+	}
+	if loadAddress < 0 {
+		loadAddress = 0x2000
+	}
+	var dos33Image []byte
+	err = diskimage.ReorderedDiskImageSectors(&dos33Image, diskImage, diskimage.SECTOR_ORDER_PRODOS, diskimage.SECTOR_ORDER_DOS)
+	if err != nil {
+		return err
+	}
+	err = ValidateDos33Vtoc(dos33Image)
+	if err != nil {
+		return err
+	}
+	err = translateHostFileForDos33(&hostFileData, fileType, loadAddress)
+	if err != nil {
+		return err
+	}
+	err = addDos33File(dos33Image, strings.ToUpper(filePath), fileType, hostFileData)
+	if err != nil {
+		return err
+	}
+	err = diskimage.ConvertDiskImageFromDos33OrderToProdosOrder(dos33Image)
+	if err != nil {
+		return err
+	}
+	copy(diskImage, dos33Image)
+	return nil
+}
+
+// File add section end
+
+// DOS 3.3 catalog section begin
+
+// DOS33_FILE_TYPE_LETTERS maps the DOS 3.3 file type bits (without the lock bit) to the letter the
+// CATALOG command shows for them.
+var DOS33_FILE_TYPE_LETTERS map[byte]string = map[byte]string{
+	0x00: "T", 0x01: "I", 0x02: "A", 0x04: "B", 0x08: "S", 0x10: "R", 0x20: "A", 0x40: "B"}
+
+// dos33FileByteLength returns the length in bytes recorded at the start of the first data sector of
+// a DOS 3.3 Integer BASIC, Applesoft BASIC or binary file described by the catalog entry entry of the
+// diskImage (in DOS3.3 sector order), or -1 for the other file types, which record no length.
+func dos33FileByteLength(entry []byte, diskImage []byte) int {
+	var fileType byte = entry[0x02] & 0x7F
+	if fileType != 0x01 && fileType != 0x02 && fileType != 0x04 {
+		return -1
+	}
+	var tsListTrack int = int(entry[0x00])
+	if tsListTrack == 0 || tsListTrack >= 0x23 {
+		return -1
+	}
+	var tsList []byte = diskimage.Dos33SectorOfImage(diskImage, tsListTrack, int(entry[0x01])&0x0F)
+	if tsList[0x0C] == 0 || tsList[0x0C] >= 0x23 {
+		return -1
+	}
+	var firstSector []byte = diskimage.Dos33SectorOfImage(diskImage, int(tsList[0x0C]), int(tsList[0x0D])&0x0F)
+	if fileType == 0x04 {
+		// a binary file starts with its load address and then its length
+		return int(firstSector[0x02]) | int(firstSector[0x03])<<8
+	}
+	return int(firstSector[0x00]) | int(firstSector[0x01])<<8
+}
+
+// countDos33FreeSectors returns the count of sectors marked free in the free sector bitmap of the
+// VTOC of diskImage (in DOS3.3 sector order).
+func countDos33FreeSectors(diskImage []byte) int {
+	var vtoc []byte = diskimage.Dos33SectorOfImage(diskImage, 0x11, 0x00)
+	var freeCount int = 0
+	for track := 0x00; track < 0x23; track = track + 1 {
+		// a set bit marks a free sector, 4 bytes per track starting at 0x38
+		var bitmap int = int(vtoc[0x38+4*track])<<8 | int(vtoc[0x39+4*track])
+		for bitmap != 0 {
+			freeCount = freeCount + bitmap&1
+			bitmap = bitmap >> 1
+		}
+	}
+	return freeCount
+}
+
+// printDos33Catalog writes to output the catalog of the DOS 3.3 diskImage (in ProDOS sector order) as
+// the CATALOG command shows it: a line for each file with an asterisk when it is locked, its type
+// letter, its size in sectors and its name. The length in bytes is added for the file types which
+// record it, and the count of free sectors follows the files.
+func printDos33Catalog(output io.Writer, diskImage []byte) error {
+	if len(diskImage) != diskimage.FLOPPY_IMAGE_SIZE {
+		return failure.CodedErrorf(failure.KIND_DOS33_CATALOG, "the catalog of a DOS 3.3 disk needs a 140K floppy image")
+	}
+	var dos33Image []byte
+	var err error = diskimage.ReorderedDiskImageSectors(&dos33Image, diskImage, diskimage.SECTOR_ORDER_PRODOS, diskimage.SECTOR_ORDER_DOS)
+	if err != nil {
+		return err
+	}
+	err = ValidateDos33Vtoc(dos33Image)
+	if err != nil {
+		return err
+	}
+	var vtoc []byte = diskimage.Dos33SectorOfImage(dos33Image, 0x11, 0x00)
+	fmt.Fprintf(output, "DISK VOLUME %d\n\n", vtoc[0x06])
+	var catalogTrack int = int(vtoc[0x01])
+	var catalogSector int = int(vtoc[0x02]) & 0x0F
+	var visitedSectors int = 0
+	for catalogTrack != 0 && catalogTrack < 0x23 && visitedSectors < 0x23*0x10 {
+		var catalogSectorData []byte = diskimage.Dos33SectorOfImage(dos33Image, catalogTrack, catalogSector)
+		for entryPos := 0x0B; entryPos+0x23 <= 0x0100; entryPos = entryPos + 0x23 {
+			if catalogSectorData[entryPos] == 0x00 || catalogSectorData[entryPos] == 0xFF {
+				// unused or deleted entry
+				continue
+			}
+			var entry []byte = catalogSectorData[entryPos : entryPos+0x23]
+			var lockMark string = " "
+			if entry[0x02]&0x80 != 0 {
+				lockMark = "*"
+			}
+			var typeLetter string
+			var found bool
+			typeLetter, found = DOS33_FILE_TYPE_LETTERS[entry[0x02]&0x7F]
+			if !found {
+				typeLetter = "?"
+			}
+			var sectorCount int = int(entry[0x21]) | int(entry[0x22])<<8
+			var name string = strings.TrimRight(string(StripHighBits(entry[0x03:0x21])), " ")
+			var byteLength int = dos33FileByteLength(entry, dos33Image)
+			if byteLength >= 0 {
+				fmt.Fprintf(output, "%s%s %03d %-30s %6d bytes\n", lockMark, typeLetter, sectorCount%1000, name, byteLength)
+			} else {
+				fmt.Fprintf(output, "%s%s %03d %s\n", lockMark, typeLetter, sectorCount%1000, name)
+			}
+		}
+		catalogTrack = int(catalogSectorData[0x01])
+		catalogSector = int(catalogSectorData[0x02]) & 0x0F
+		visitedSectors = visitedSectors + 1
+	}
+	fmt.Fprintf(output, "\n%d sectors free\n", countDos33FreeSectors(dos33Image))
+	return nil
+}
+
+// DOS 3.3 catalog section end
+
+// ProDOS catalog section begin
+
+// PRODOS_FILE_TYPE_NAMES maps the common ProDOS file types to the names the CAT command shows for
+// them. Other file types are shown as $ and the type in hexadecimal.
+var PRODOS_FILE_TYPE_NAMES map[byte]string = map[byte]string{
+	0x00: "UNK", 0x01: "BAD", 0x04: "TXT", 0x06: "BIN", 0x0F: "DIR", 0x19: "ADB", 0x1A: "AWP", 0x1B: "ASP",
+	0xB3: "S16", 0xEF: "PAS", 0xF0: "CMD", 0xFA: "INT", 0xFB: "IVR", 0xFC: "BAS", 0xFD: "VAR", 0xFE: "REL",
+	0xFF: "SYS"}
+
+// MONTH_NAMES holds the month abbreviations used in ProDOS dates.
+var MONTH_NAMES [12]string = [12]string{"JAN", "FEB", "MAR", "APR", "MAY", "JUN", "JUL", "AUG", "SEP", "OCT", "NOV", "DEC"}
+
+// formatProdosDateTime formats the 4 byte ProDOS date and time dateTime (day, month and year packed
+// into the first two bytes, then the minute and hour) like 16-OCT-26 14:05, or as <NO DATE>.
+func formatProdosDateTime(dateTime []byte) string {
+	var date int = int(dateTime[0x00]) | int(dateTime[0x01])<<8
+	var day int = date & 0x1F
+	var month int = (date >> 5) & 0x0F
+	var year int = date >> 9
+	if date == 0 || month < 1 || month > 12 {
+		return "<NO DATE>      "
+	}
+	return fmt.Sprintf("%2d-%s-%02d %2d:%02d", day, MONTH_NAMES[month-1], year%100, dateTime[0x03]&0x1F, dateTime[0x02]&0x3F)
+}
+
+// limitProdosTotalBlocks limits totalBlocks, the block count of the volume header of the ProDOS ordered
+// diskImage, to the blocks the image holds, reporting to stderr a header counting more.
+func limitProdosTotalBlocks(totalBlocks *int, diskImage []byte) {
+	if *totalBlocks*diskimage.PRODOS_BLOCK_SIZE <= len(diskImage) {
+		return
+	}
+	fmt.Fprintf(os.Stderr, "volume header counts %d blocks, more than the %d blocks of the image\n", *totalBlocks, len(diskImage)/diskimage.PRODOS_BLOCK_SIZE)
+	*totalBlocks = len(diskImage) / diskimage.PRODOS_BLOCK_SIZE
+}
+
+// readProdosBitmapBlockNum stores into bitmapBlockNum the first block of the volume bitmap of the
+// ProDOS ordered diskImage, a volume of totalBlocks blocks. It returns an error when the bitmap, a
+// block for every 4096 blocks of the volume, does not lie within the image.
+func readProdosBitmapBlockNum(bitmapBlockNum *int, diskImage []byte, totalBlocks int) error {
+	var keyBlock []byte = diskimage.ProdosBlockOfImage(diskImage, 0x02)
+	*bitmapBlockNum = int(keyBlock[0x04+0x23]) | int(keyBlock[0x04+0x24])<<8
+	var bitmapBlockCount int = (totalBlocks + 0x0FFF) / 0x1000
+	if *bitmapBlockNum+bitmapBlockCount > len(diskImage)/diskimage.PRODOS_BLOCK_SIZE {
+		return failure.CodedErrorf(failure.KIND_UNRECOGNIZED_FILESYSTEM, "the volume bitmap at %s lies beyond the end of the %d block image", formatBlockRange("block", *bitmapBlockNum, *bitmapBlockNum+bitmapBlockCount-1), len(diskImage)/diskimage.PRODOS_BLOCK_SIZE)
+	}
+	return nil
+}
+
+// countProdosFreeBlocks stores into freeCount the count of blocks marked free in the volume bitmap of
+// the ProDOS ordered diskImage, a volume of totalBlocks blocks (no more than the image holds). It
+// returns an error when the bitmap does not lie within the image.
+func countProdosFreeBlocks(freeCount *int, diskImage []byte, totalBlocks int) error {
+	var bitmapBlockNum int
+	var err error = readProdosBitmapBlockNum(&bitmapBlockNum, diskImage, totalBlocks)
+	if err != nil {
+		return err
+	}
+	*freeCount = 0
+	for blockNum := 0; blockNum < totalBlocks; blockNum = blockNum + 1 {
+		// a set bit marks a free block, 4096 blocks per bitmap block, high bit first
+		var bitmapBlock []byte = diskimage.ProdosBlockOfImage(diskImage, bitmapBlockNum+blockNum/0x1000)
+		if bitmapBlock[(blockNum%0x1000)/8]&(0x80>>uint(blockNum%8)) != 0 {
+			*freeCount = *freeCount + 1
+		}
+	}
+	return nil
+}
+
+// PrintProdosDirectory writes to output the listing of the directory with key block keyBlockNum
+// (named directoryPath) of the ProDOS ordered diskImage as the CAT command shows it: a line for each
+// file with an asterisk when it is locked (not write enabled), its name, file type, blocks used,
+// modification and creation times, length in bytes (end of file) and aux type. The listings of its
+// subdirectories follow, each headed by its path, except for those whose key block is in
+// visitedKeyBlocks, the directories already listed (which a damaged image can link into a loop).
+func PrintProdosDirectory(output io.Writer, diskImage []byte, keyBlockNum int, directoryPath string, visitedKeyBlocks map[int]bool) error {
+	visitedKeyBlocks[keyBlockNum] = true
+	fmt.Fprintf(output, "%s\n\n NAME            TYPE  BLOCKS  MODIFIED         CREATED           ENDFILE SUBTYPE\n\n", directoryPath)
+	var subdirectoryPaths []string
+	var subdirectoryBlockNums []int
+	var err error = ForEachProdosDirectoryEntry(diskImage, keyBlockNum, func(entry []byte, blockNum int) bool {
+		var storageType byte = entry[0x00] >> 4
+		if storageType == 0x00 || storageType >= 0x0E {
+			// deleted entry, or the directory header
+			return true
+		}
+		var name string = ProdosEntryName(entry)
+		var lockMark string = " "
+		if entry[0x1E]&0x02 == 0 {
+			lockMark = "*"
+		}
+		var typeName string
+		var found bool
+		typeName, found = PRODOS_FILE_TYPE_NAMES[entry[0x10]]
+		if !found {
+			typeName = fmt.Sprintf("$%02X", entry[0x10])
+		}
+		var blocksUsed int = int(entry[0x13]) | int(entry[0x14])<<8
+		var eof int = int(entry[0x15]) | int(entry[0x16])<<8 | int(entry[0x17])<<16
+		var auxType int = int(entry[0x1F]) | int(entry[0x20])<<8
+		fmt.Fprintf(output, "%s%-15s %s %7d  %s  %s %8d  $%04X\n", lockMark, name, typeName, blocksUsed,
+			formatProdosDateTime(entry[0x21:0x25]), formatProdosDateTime(entry[0x18:0x1C]), eof, auxType)
+		if storageType == 0x0D && visitedKeyBlocks[ProdosEntryKeyBlockNum(entry)] {
+			fmt.Fprintf(os.Stderr, "not listing %s/%s, which is a directory already listed\n", directoryPath, name)
+		} else if storageType == 0x0D {
+			subdirectoryPaths = append(subdirectoryPaths, directoryPath+"/"+name)
+			subdirectoryBlockNums = append(subdirectoryBlockNums, ProdosEntryKeyBlockNum(entry))
+		}
+		return true
+	})
+	if err != nil {
+		return fmt.Errorf("listing directory %s: %w", directoryPath, err)
+	}
+	for i, subdirectoryPath := range subdirectoryPaths {
+		if visitedKeyBlocks[subdirectoryBlockNums[i]] {
+			// listed already by a subdirectory listed before it
+			continue
+		}
+		fmt.Fprintf(output, "\n")
+		err = PrintProdosDirectory(output, diskImage, subdirectoryBlockNums[i], subdirectoryPath, visitedKeyBlocks)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// PrintDiskImageCatalog writes to output the listing of the files of diskImage (in ProDOS sector
+// order): the directories of a ProDOS volume followed by its free and used block counts, or the
+// catalog of a DOS 3.3 disk.
+func PrintDiskImageCatalog(output io.Writer, diskImage []byte) error {
+	var volumeName string
+	var totalBlocks int
+	if !ReadProdosVolumeHeader(&volumeName, &totalBlocks, diskImage, 0) {
+		return printDos33Catalog(output, diskImage)
+	}
+	var err error = PrintProdosDirectory(output, diskImage, 0x02, "/"+volumeName, make(map[int]bool))
+	if err != nil {
+		return err
+	}
+	limitProdosTotalBlocks(&totalBlocks, diskImage)
+	var freeBlocks int
+	err = countProdosFreeBlocks(&freeBlocks, diskImage, totalBlocks)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(output, "\nBLOCKS FREE:%5d     BLOCKS USED:%5d     TOTAL BLOCKS:%5d\n", freeBlocks, totalBlocks-freeBlocks, totalBlocks)
+	return nil
+}
+
+// ProDOS catalog section end
+
+// ProDOS volume check section begin
+
+// formatBlockRange returns the text naming the blocks (or sectors, as unitName tells) from first to
+// last, such as "block 7" or "blocks 7-12".
+func formatBlockRange(unitName string, first int, last int) string {
+	if first == last {
+		return fmt.Sprintf("%s %d", unitName, first)
+	}
+	return fmt.Sprintf("%ss %d-%d", unitName, first, last)
+}
+
+// markProdosBlockUse records owner as the user of block blockNum of the ProDOS volume diskImage in
+// blockOwners, and the blocks referenced by it when indexLevel is 1 (index block) or 2 (master index
+// block). A block already used by another owner, or beyond the end of the volume, is added to
+// problems instead. It returns whether the block was newly recorded.
+func markProdosBlockUse(blockOwners []string, problems *[]string, diskImage []byte, blockNum int, indexLevel int, owner string) bool {
+	if blockNum >= len(blockOwners) || (blockNum+1)*diskimage.PRODOS_BLOCK_SIZE > len(diskImage) {
+		*problems = append(*problems, fmt.Sprintf("%s refers to block %d beyond the end of the volume", owner, blockNum))
+		return false
+	}
+	if blockOwners[blockNum] != "" {
+		*problems = append(*problems, fmt.Sprintf("block %d is used by both %s and %s", blockNum, blockOwners[blockNum], owner))
+		return false
+	}
+	blockOwners[blockNum] = owner
+	if indexLevel == 0 {
+		return true
+	}
+	var block []byte = diskimage.ProdosBlockOfImage(diskImage, blockNum)
+	for i := 0; i < 0x0100; i = i + 1 {
+		var pointedBlockNum int = int(block[i]) | int(block[0x0100+i])<<8
+		// a zero pointer is a hole in a sparse file
+		if pointedBlockNum != 0 {
+			markProdosBlockUse(blockOwners, problems, diskImage, pointedBlockNum, indexLevel-1, owner)
+		}
+	}
+	return true
+}
+
+// markProdosDirectoryUse records in blockOwners the blocks of the directory with key block keyBlockNum
+// (named directoryPath) of the ProDOS volume diskImage, and those of every file and subdirectory listed
+// in it, adding to problems the blocks used twice, a damaged directory, and a file count in the
+// directory header which does not match the files listed.
+func markProdosDirectoryUse(blockOwners []string, problems *[]string, diskImage []byte, keyBlockNum int, directoryPath string) {
+	var fileCount int = 0
+	var markedBlockNum int = 0
+	var err error = ForEachProdosDirectoryEntry(diskImage, keyBlockNum, func(entry []byte, blockNum int) bool {
+		if blockNum != markedBlockNum {
+			// a block already used, such as the key block of a directory linking back to one above it,
+			// is not walked again
+			if !markProdosBlockUse(blockOwners, problems, diskImage, blockNum, 0, directoryPath) {
+				return false
+			}
+			markedBlockNum = blockNum
+		}
+		var storageType byte = entry[0x00] >> 4
+		var entryKeyBlockNum int = ProdosEntryKeyBlockNum(entry)
+		var entryPath string = directoryPath + "/" + ProdosEntryName(entry)
+		if storageType == 0x00 || storageType >= 0x0E {
+			// unused entry, or the directory header
+			return true
+		}
+		fileCount = fileCount + 1
+		if storageType >= 0x01 && storageType <= 0x03 {
+			markProdosBlockUse(blockOwners, problems, diskImage, entryKeyBlockNum, int(storageType)-1, entryPath)
+		} else if storageType == 0x05 && markProdosBlockUse(blockOwners, problems, diskImage, entryKeyBlockNum, 0, entryPath) {
+			// extended file: the key block holds the entries of the data fork and the resource fork
+			var extendedKeyBlock []byte = diskimage.ProdosBlockOfImage(diskImage, entryKeyBlockNum)
+			for _, forkPos := range []int{0x00, 0x0100} {
+				var forkStorageType int = int(extendedKeyBlock[forkPos] & 0x0F)
+				var forkKeyBlockNum int = int(extendedKeyBlock[forkPos+0x01]) | int(extendedKeyBlock[forkPos+0x02])<<8
+				if forkStorageType >= 0x01 && forkStorageType <= 0x03 {
+					markProdosBlockUse(blockOwners, problems, diskImage, forkKeyBlockNum, forkStorageType-1, entryPath)
+				}
+			}
+		} else if storageType == 0x0D {
+			markProdosDirectoryUse(blockOwners, problems, diskImage, entryKeyBlockNum, entryPath)
+		}
+		return true
+	})
+	if err != nil {
+		*problems = append(*problems, fmt.Sprintf("directory %s: %v", directoryPath, err))
+		return
+	}
+	var keyBlock []byte = diskimage.ProdosBlockOfImage(diskImage, keyBlockNum)
+	var headerFileCount int = int(keyBlock[0x04+0x21]) | int(keyBlock[0x04+0x22])<<8
+	if fileCount != headerFileCount {
+		*problems = append(*problems, fmt.Sprintf("directory %s lists %d files but its header counts %d", directoryPath, fileCount, headerFileCount))
+	}
+}
+
+// CheckProdosVolume writes to output a report of the ProDOS volume diskImage cross-referencing its
+// volume bitmap against the blocks actually used by the boot blocks, the bitmap itself, and every
+// directory and file: blocks used twice, blocks in use but marked free (which the next file written
+// would overwrite), and blocks marked used which nothing uses. It returns whether no problem was found.
+func CheckProdosVolume(output io.Writer, diskImage []byte) bool {
+	var volumeName string
+	var totalBlocks int
+	if !ReadProdosVolumeHeader(&volumeName, &totalBlocks, diskImage, 0) {
+		return ReportCheckProblems(output, []string{"image holds no ProDOS volume directory"})
+	}
+	var problems []string
+	if totalBlocks*diskimage.PRODOS_BLOCK_SIZE > len(diskImage) {
+		problems = append(problems, fmt.Sprintf("volume header counts %d blocks, more than the %d blocks of the image", totalBlocks, len(diskImage)/diskimage.PRODOS_BLOCK_SIZE))
+		totalBlocks = len(diskImage) / diskimage.PRODOS_BLOCK_SIZE
+	}
+	var blockOwners []string = make([]string, totalBlocks)
+	markProdosBlockUse(blockOwners, &problems, diskImage, 0x00, 0, "the boot blocks")
+	markProdosBlockUse(blockOwners, &problems, diskImage, 0x01, 0, "the boot blocks")
+	var bitmapBlockNum int
+	var err error = readProdosBitmapBlockNum(&bitmapBlockNum, diskImage, totalBlocks)
+	if err != nil {
+		// the files are still checked, but not against a bitmap which cannot be read
+		problems = append(problems, err.Error())
+		markProdosDirectoryUse(blockOwners, &problems, diskImage, 0x02, "/"+volumeName)
+		fmt.Fprintf(output, "/%s: %d blocks, volume bitmap unreadable\n", volumeName, totalBlocks)
+		return ReportCheckProblems(output, problems)
+	}
+	for i := 0; i < (totalBlocks+0x0FFF)/0x1000; i = i + 1 {
+		markProdosBlockUse(blockOwners, &problems, diskImage, bitmapBlockNum+i, 0, "the volume bitmap")
+	}
+	markProdosDirectoryUse(blockOwners, &problems, diskImage, 0x02, "/"+volumeName)
+	// consecutive blocks in the same state are reported together
+	var rangeStart int = 0
+	for blockNum := 0; blockNum < totalBlocks; blockNum = blockNum + 1 {
+		var bitmapBlock []byte = diskimage.ProdosBlockOfImage(diskImage, bitmapBlockNum+blockNum/0x1000)
+		var isFree bool = bitmapBlock[(blockNum%0x1000)/8]&(0x80>>uint(blockNum%8)) != 0
+		var isRangeEnd bool = blockNum+1 == totalBlocks || blockOwners[blockNum+1] != blockOwners[blockNum]
+		if !isRangeEnd {
+			var nextBitmapBlock []byte = diskimage.ProdosBlockOfImage(diskImage, bitmapBlockNum+(blockNum+1)/0x1000)
+			isRangeEnd = (nextBitmapBlock[((blockNum+1)%0x1000)/8]&(0x80>>uint((blockNum+1)%8)) != 0) != isFree
+		}
+		if !isRangeEnd {
+			continue
+		}
+		if isFree && blockOwners[blockNum] != "" {
+			problems = append(problems, fmt.Sprintf("%s used by %s marked free in the volume bitmap", formatBlockRange("block", rangeStart, blockNum), blockOwners[blockNum]))
+		} else if !isFree && blockOwners[blockNum] == "" {
+			problems = append(problems, fmt.Sprintf("%s marked used in the volume bitmap but used by nothing", formatBlockRange("block", rangeStart, blockNum)))
+		}
+		rangeStart = blockNum + 1
+	}
+	var freeBlocks int
+	// the bitmap was found within the image above
+	countProdosFreeBlocks(&freeBlocks, diskImage, totalBlocks)
+	fmt.Fprintf(output, "/%s: %d blocks, %d free in the volume bitmap\n", volumeName, totalBlocks, freeBlocks)
+	return ReportCheckProblems(output, problems)
+}
+
+// ReportCheckProblems writes problems to output, one per line followed by their count, or that no
+// problems were found, and returns whether there were none.
+func ReportCheckProblems(output io.Writer, problems []string) bool {
+	for _, problem := range problems {
+		fmt.Fprintf(output, "  %s\n", problem)
+	}
+	if len(problems) > 0 {
+		fmt.Fprintf(output, "%d problems found\n", len(problems))
+		return false
+	}
+	fmt.Fprintf(output, "no problems found\n")
+	return true
+}
+
+// ProDOS volume check section end
+
+// DOS 3.3 disk check section begin
+
+// markDos33SectorUse records owner as the user of sector sector of track track in sectorOwners
+// (indexed by track * 16 + sector), adding to problems a sector off the disk or already used by
+// another owner instead. It returns whether the sector was newly recorded.
+func markDos33SectorUse(sectorOwners []string, problems *[]string, track int, sector int, owner string) bool {
+	if track < 0 || track >= 0x23 || sector < 0 || sector > 0x0F {
+		*problems = append(*problems, fmt.Sprintf("%s refers to track %d sector %d off the disk", owner, track, sector))
+		return false
+	}
+	if sectorOwners[track*0x10+sector] != "" {
+		*problems = append(*problems, fmt.Sprintf("track %d sector %d is used by both %s and %s", track, sector, sectorOwners[track*0x10+sector], owner))
+		return false
+	}
+	sectorOwners[track*0x10+sector] = owner
+	return true
+}
+
+// markDos33FileUse records in sectorOwners the track/sector lists and data sectors of the file named
+// fileName of the DOS 3.3 diskImage (in DOS3.3 sector order), whose first track/sector list is at
+// track tsListTrack sector tsListSector, and returns the count of sectors it uses. Entries off the
+// disk, sectors used twice and loops in the chain of track/sector lists are added to problems.
+func markDos33FileUse(sectorOwners []string, problems *[]string, diskImage []byte, tsListTrack int, tsListSector int, fileName string) int {
+	var sectorCount int = 0
+	for tsListTrack != 0 && markDos33SectorUse(sectorOwners, problems, tsListTrack, tsListSector, fileName) {
+		sectorCount = sectorCount + 1
+		var tsList []byte = diskimage.Dos33SectorOfImage(diskImage, tsListTrack, tsListSector)
+		for pairPos := 0x0C; pairPos < 0x0100; pairPos = pairPos + 2 {
+			// a zero track is a hole in a random access text file
+			if tsList[pairPos] != 0 && markDos33SectorUse(sectorOwners, problems, int(tsList[pairPos]), int(tsList[pairPos+1]), fileName) {
+				sectorCount = sectorCount + 1
+			}
+		}
+		tsListTrack = int(tsList[0x01])
+		tsListSector = int(tsList[0x02])
+	}
+	return sectorCount
+}
+
+// CheckDos33Disk writes to output a report of the DOS 3.3 diskImage (in DOS3.3 sector order): the
+// sanity of its VTOC, loops in its catalog, track/sector list entries off the disk, sectors used by two
+// files, sector counts in the catalog which do not match the files, and the VTOC free-sector bitmap
+// against the sectors actually used. Only the geometry is reported for a VTOC which does not describe
+// a 35 track, 16 sector disk. Tracks 0 to 2, which hold the DOS image on bootable disks, are not
+// checked against the bitmap. It returns whether no problem was found.
+func CheckDos33Disk(output io.Writer, diskImage []byte) bool {
+	var problems []string
+	var vtoc []byte = diskimage.Dos33SectorOfImage(diskImage, 0x11, 0x00)
+	if vtoc[0x34] != 0x23 || vtoc[0x35] != 0x10 || vtoc[0x36] != 0x00 || vtoc[0x37] != 0x01 {
+		problems = append(problems, fmt.Sprintf("VTOC describes %d tracks of %d sectors of %d bytes, not 35 tracks of 16 sectors of 256 bytes", vtoc[0x34], vtoc[0x35], int(vtoc[0x36])|int(vtoc[0x37])<<8))
+		// the rest of the VTOC, and the catalog it leads to, cannot be trusted either
+		fmt.Fprintf(output, "DISK VOLUME %d: not a DOS 3.3 disk\n", vtoc[0x06])
+		return ReportCheckProblems(output, problems)
+	}
+	if vtoc[0x03] != 0x03 {
+		problems = append(problems, fmt.Sprintf("VTOC holds DOS release %d, not 3", vtoc[0x03]))
+	}
+	if vtoc[0x27] != 0x7A {
+		problems = append(problems, fmt.Sprintf("VTOC holds %d track/sector pairs per list, not 122", vtoc[0x27]))
+	}
+	var sectorOwners []string = make([]string, 0x23*0x10)
+	markDos33SectorUse(sectorOwners, &problems, 0x11, 0x00, "the VTOC")
+	var catalogTrack int = int(vtoc[0x01])
+	var catalogSector int = int(vtoc[0x02])
+	var fileCount int = 0
+	for catalogTrack != 0 {
+		if catalogTrack < 0x23 && catalogSector <= 0x0F && sectorOwners[catalogTrack*0x10+catalogSector] == "the catalog" {
+			problems = append(problems, fmt.Sprintf("the catalog loops back to track %d sector %d", catalogTrack, catalogSector))
+			break
+		}
+		if !markDos33SectorUse(sectorOwners, &problems, catalogTrack, catalogSector, "the catalog") {
+			break
+		}
+		var catalogSectorData []byte = diskimage.Dos33SectorOfImage(diskImage, catalogTrack, catalogSector)
+		for entryPos := 0x0B; entryPos+0x23 <= 0x0100; entryPos = entryPos + 0x23 {
+			var tsListTrack int = int(catalogSectorData[entryPos])
+			if tsListTrack == 0x00 || tsListTrack == 0xFF {
+				// unused or deleted entry
+				continue
+			}
+			fileCount = fileCount + 1
+			var fileName string = strings.TrimRight(string(StripHighBits(catalogSectorData[entryPos+0x03:entryPos+0x21])), " ")
+			var sectorCount int = markDos33FileUse(sectorOwners, &problems, diskImage, tsListTrack, int(catalogSectorData[entryPos+0x01]), fileName)
+			var catalogSectorCount int = int(catalogSectorData[entryPos+0x21]) | int(catalogSectorData[entryPos+0x22])<<8
+			if sectorCount != catalogSectorCount {
+				problems = append(problems, fmt.Sprintf("%s uses %d sectors but its catalog entry counts %d", fileName, sectorCount, catalogSectorCount))
+			}
+		}
+		catalogTrack = int(catalogSectorData[0x01])
+		catalogSector = int(catalogSectorData[0x02])
+	}
+	// consecutive sectors of a track in the same state are reported together
+	for track := 0x03; track < 0x23; track = track + 1 {
+		var bitmap int = int(vtoc[0x38+4*track])<<8 | int(vtoc[0x39+4*track])
+		var rangeStart int = 0
+		for sector := 0x00; sector < 0x10; sector = sector + 1 {
+			var owner string = sectorOwners[track*0x10+sector]
+			var isFree bool = bitmap>>uint(sector)&1 != 0
+			if sector < 0x0F && sectorOwners[track*0x10+sector+1] == owner && (bitmap>>uint(sector+1)&1 != 0) == isFree {
+				continue
+			}
+			if isFree && owner != "" {
+				problems = append(problems, fmt.Sprintf("track %d %s used by %s marked free in the VTOC", track, formatBlockRange("sector", rangeStart, sector), owner))
+			} else if !isFree && owner == "" {
+				problems = append(problems, fmt.Sprintf("track %d %s marked used in the VTOC but used by nothing", track, formatBlockRange("sector", rangeStart, sector)))
+			}
+			rangeStart = sector + 1
+		}
+	}
+	fmt.Fprintf(output, "DISK VOLUME %d: %d files, %d sectors free in the VTOC\n", vtoc[0x06], fileCount, countDos33FreeSectors(diskImage))
+	return ReportCheckProblems(output, problems)
+}
+
+// CheckDiskImage writes to output the report of CheckProdosVolume when diskImage (in ProDOS sector
+// order) holds a ProDOS volume, and otherwise that of CheckDos33Disk, or the problem that it holds
+// neither. It returns whether no problem was found.
+func CheckDiskImage(output io.Writer, diskImage []byte) bool {
+	var volumeName string
+	var totalBlocks int
+	if ReadProdosVolumeHeader(&volumeName, &totalBlocks, diskImage, 0) {
+		return CheckProdosVolume(output, diskImage)
+	}
+	if len(diskImage) != diskimage.FLOPPY_IMAGE_SIZE {
+		return ReportCheckProblems(output, []string{"image holds neither a ProDOS volume nor a DOS 3.3 disk"})
+	}
+	var dos33Image []byte
+	var err error = diskimage.ReorderedDiskImageSectors(&dos33Image, diskImage, diskimage.SECTOR_ORDER_PRODOS, diskimage.SECTOR_ORDER_DOS)
+	if err != nil {
+		return ReportCheckProblems(output, []string{err.Error()})
+	}
+	return CheckDos33Disk(output, dos33Image)
+}
+
+// DOS 3.3 disk check section end
@@ -0,0 +1,719 @@
+/*
+apple2disk gcr package
+Copyright (C) 2024 github user bassjack1 <147515670+bassjack1@users.noreply.github.com>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+Communication with the author can be done via tagging @bassjack1 in github.com issues or by composing
+private messages to user bassjack1 on reddit.com : https://www.reddit.com/message/compose/
+*/
+
+// Package gcr encodes and decodes the GCR nibbles of 16-sector (6-and-2) and 13-sector (5-and-3)
+// Disk II tracks, and reads nibble (.nib) and WOZ images.
+package gcr
+
+import "bytes"
+import "encoding/binary"
+import "fmt"
+import "github.com/bassjack1/apple2disk/go/diskimage"
+import "github.com/bassjack1/apple2disk/go/failure"
+import "hash/crc32"
+import "os"
+
+// Nibble image section begin
+
+// A .NIB image holds the 35 tracks of a disk as 0x1A00 disk bytes (nibbles) each, just as they
+// were read from the disk, so the 16 sectors of each track are still 6-and-2 encoded.
+const NIB_TRACK_SIZE = 0x1A00
+const NIB_IMAGE_SIZE = 0x23 * NIB_TRACK_SIZE
+
+// GCR_62_WRITE_TABLE holds the 64 valid disk bytes, which encode the 6 bit values 0x00-0x3F.
+var GCR_62_WRITE_TABLE [0x40]byte = [0x40]byte{
+	'\x96', '\x97', '\x9A', '\x9B', '\x9D', '\x9E', '\x9F', '\xA6', '\xA7', '\xAB', '\xAC', '\xAD', '\xAE', '\xAF', '\xB2', '\xB3',
+	'\xB4', '\xB5', '\xB6', '\xB7', '\xB9', '\xBA', '\xBB', '\xBC', '\xBD', '\xBE', '\xBF', '\xCB', '\xCD', '\xCE', '\xCF', '\xD3',
+	'\xD6', '\xD7', '\xD9', '\xDA', '\xDB', '\xDC', '\xDD', '\xDE', '\xDF', '\xE5', '\xE6', '\xE7', '\xE9', '\xEA', '\xEB', '\xEC',
+	'\xED', '\xEE', '\xEF', '\xF2', '\xF3', '\xF4', '\xF5', '\xF6', '\xF7', '\xF9', '\xFA', '\xFB', '\xFC', '\xFD', '\xFE', '\xFF',
+}
+
+// DOS33_SECTOR_OF_PHYSICAL_SECTOR maps the physical sector numbers found in the address fields to
+// the logical sectors of a DOS3.3 sector order image.
+var DOS33_SECTOR_OF_PHYSICAL_SECTOR [0x10]int = [0x10]int{
+	0x00, 0x07, 0x0E, 0x06, 0x0D, 0x05, 0x0C, 0x04, 0x0B, 0x03, 0x0A, 0x02, 0x09, 0x01, 0x08, 0x0F,
+}
+
+// decode44 returns the byte stored in an address field as a pair of 4-and-4 encoded disk bytes.
+func decode44(oddBits byte, evenBits byte) byte {
+	return ((oddBits << 1) | '\x01') & evenBits
+}
+
+// DecodeGcr62Sector fills sectorBuffer from the 343 disk bytes of a data field (the 342 6-and-2
+// encoded bytes followed by the checksum byte). It returns false, storing the reason into failure,
+// when a disk byte is not valid or the checksum does not match.
+func DecodeGcr62Sector(sectorBuffer *[0x0100]byte, failure *string, nibbles []byte) bool {
+	var readTable [0x0100]int
+	for i := 0; i < 0x0100; i = i + 1 {
+		readTable[i] = -1
+	}
+	for i := 0; i < 0x40; i = i + 1 {
+		readTable[GCR_62_WRITE_TABLE[i]] = i
+	}
+	// each 6 bit value is stored exclusive or'ed with the one before it
+	var values [0x0156]byte
+	var previous byte = '\x00'
+	for i := 0; i < 0x0156; i = i + 1 {
+		var sixBits int = readTable[nibbles[i]]
+		if sixBits < 0 {
+			*failure = fmt.Sprintf("its data field holds the invalid disk byte 0x%02X", nibbles[i])
+			return false
+		}
+		previous = previous ^ byte(sixBits)
+		values[i] = previous
+	}
+	if readTable[nibbles[0x0156]] != int(previous) {
+		*failure = "its data field failed its checksum"
+		return false
+	}
+	unpackGcr62Sector(sectorBuffer, values[:])
+	return true
+}
+
+// unpackGcr62Sector fills sectorBuffer from the 342 decoded 6 bit values of a data field. The first
+// 0x56 values hold the low 2 bits of the sector bytes (with the 2 bits swapped), and the following
+// 0x0100 values hold the high 6 bits.
+func unpackGcr62Sector(sectorBuffer *[0x0100]byte, values []byte) {
+	for i := 0; i < 0x0100; i = i + 1 {
+		var lowBits byte = (values[i%0x56] >> uint(2*(i/0x56))) & '\x03'
+		sectorBuffer[i] = (values[0x56+i] << 2) | ((lowBits & '\x01') << 1) | ((lowBits & '\x02') >> 1)
+	}
+}
+
+// decodeTrackNibbles copies the 16 sectors found in the disk bytes of one track into diskImage (in
+// DOS3.3 sector order) at track trackNum. The disk bytes are searched twice around so that a sector
+// which wraps from the end of the track to its start is found too. Each sector which cannot be
+// recovered is added to unrecoverableSectors with the reason: an address field which fails its
+// checksum or holds another track, no data field after it, a data field holding an invalid disk byte
+// or failing its checksum, or no address field at all. Its place in diskImage is left unchanged. It
+// returns an error when the track is not in diskImage.
+func decodeTrackNibbles(unrecoverableSectors *[]string, diskImage []byte, trackNum int, trackNibbles []byte) error {
+	var nibbles []byte = append(append([]byte{}, trackNibbles...), trackNibbles...)
+	var sectorFound [0x10]bool
+	var sectorFailures [0x10]string
+	var sectorBuffer [0x0100]byte
+	var pos int = 0
+	for pos+0x0E < len(nibbles) {
+		if nibbles[pos] != '\xD5' || nibbles[pos+1] != '\xAA' || nibbles[pos+2] != '\x96' {
+			pos = pos + 1
+			continue
+		}
+		var volume byte = decode44(nibbles[pos+3], nibbles[pos+4])
+		var track byte = decode44(nibbles[pos+5], nibbles[pos+6])
+		var sector byte = decode44(nibbles[pos+7], nibbles[pos+8])
+		var checksum byte = decode44(nibbles[pos+9], nibbles[pos+10])
+		pos = pos + 11
+		if sector > 0x0F {
+			continue
+		}
+		if volume^track^sector != checksum {
+			// the sector number itself may be damaged, so this is only the likely sector
+			sectorFailures[sector] = "its address field failed its checksum"
+			continue
+		}
+		if int(track) != trackNum {
+			sectorFailures[sector] = fmt.Sprintf("its address field holds track %d", track)
+			continue
+		}
+		// the data field follows the address field within a few dozen disk bytes
+		var dataPos int = pos
+		for dataPos+3 < len(nibbles) && dataPos < pos+0x40 && !(nibbles[dataPos] == '\xD5' && nibbles[dataPos+1] == '\xAA' && nibbles[dataPos+2] == '\xAD') {
+			dataPos = dataPos + 1
+		}
+		if sectorFound[sector] {
+			continue
+		}
+		if dataPos >= pos+0x40 || dataPos+3+0x0157 > len(nibbles) {
+			sectorFailures[sector] = "no data field follows its address field"
+			continue
+		}
+		if !DecodeGcr62Sector(&sectorBuffer, &sectorFailures[sector], nibbles[dataPos+3:dataPos+3+0x0157]) {
+			continue
+		}
+		var err error = diskimage.WriteSectorDataFromBuffer(&sectorBuffer, diskImage, trackNum, DOS33_SECTOR_OF_PHYSICAL_SECTOR[sector])
+		if err != nil {
+			return err
+		}
+		sectorFound[sector] = true
+		pos = dataPos + 3 + 0x0157
+	}
+	for sector := 0; sector < 0x10; sector = sector + 1 {
+		if sectorFound[sector] {
+			continue
+		}
+		if sectorFailures[sector] == "" {
+			sectorFailures[sector] = "no address field holds it"
+		}
+		*unrecoverableSectors = append(*unrecoverableSectors, fmt.Sprintf("track %d physical sector %d (DOS3.3 sector %d): %s",
+			trackNum, sector, DOS33_SECTOR_OF_PHYSICAL_SECTOR[sector], sectorFailures[sector]))
+	}
+	return nil
+}
+
+// reportUnrecoverableSectors reports each of the unrecoverableSectors of a nibble or WOZ image to
+// stderr, and returns an error when there are any unless skipBadSectors is set.
+func reportUnrecoverableSectors(unrecoverableSectors []string, skipBadSectors bool) error {
+	for _, unrecoverableSector := range unrecoverableSectors {
+		fmt.Fprintf(os.Stderr, "unrecoverable sector: %s\n", unrecoverableSector)
+	}
+	if len(unrecoverableSectors) == 0 {
+		return nil
+	}
+	if !skipBadSectors {
+		return failure.CodedErrorf(failure.KIND_UNDECODABLE_SECTORS, "%d sectors could not be decoded from the disk bytes", len(unrecoverableSectors))
+	}
+	fmt.Fprintf(os.Stderr, "left %d unrecoverable sectors filled with zeros (-skip-bad-sectors)\n", len(unrecoverableSectors))
+	return nil
+}
+
+// encode44 stores into oddBits and evenBits the pair of 4-and-4 encoded disk bytes storing value in an
+// address field, the first holding its odd bits and the second its even bits, interleaved with set bits.
+func encode44(oddBits *byte, evenBits *byte, value byte) {
+	*oddBits = (value >> 1) | '\xAA'
+	*evenBits = value | '\xAA'
+}
+
+// packGcr62Sector fills values with the 342 6 bit values of the data field storing the 256 bytes of
+// sector, reversing unpackGcr62Sector.
+func packGcr62Sector(values *[0x0156]byte, sector []byte) {
+	for i := 0; i < 0x0156; i = i + 1 {
+		values[i] = '\x00'
+	}
+	for i := 0; i < 0x0100; i = i + 1 {
+		var lowBits byte = ((sector[i] & '\x01') << 1) | ((sector[i] & '\x02') >> 1)
+		values[i%0x56] = values[i%0x56] | (lowBits << uint(2*(i/0x56)))
+		values[0x56+i] = sector[i] >> 2
+	}
+}
+
+// EncodeGcr62Sector returns the 343 disk bytes of the data field storing the 256 bytes of sector: the
+// 342 6-and-2 encoded bytes followed by the checksum byte, as DecodeGcr62Sector reads them.
+func EncodeGcr62Sector(sector []byte) []byte {
+	var values [0x0156]byte
+	packGcr62Sector(&values, sector)
+	var nibbles []byte = make([]byte, 0x0157)
+	// each 6 bit value is stored exclusive or'ed with the one before it
+	var previous byte = '\x00'
+	for i := 0; i < 0x0156; i = i + 1 {
+		nibbles[i] = GCR_62_WRITE_TABLE[values[i]^previous]
+		previous = values[i]
+	}
+	nibbles[0x0156] = GCR_62_WRITE_TABLE[previous]
+	return nibbles
+}
+
+// EncodeSectorFields fills addressField with the 14 disk bytes of the address field (prologue, 4-and-4
+// encoded volume, track, sector and checksum, epilogue) of physical sector physicalSector of track
+// trackNum of volume volume, and dataField with the 349 disk bytes of its data field (prologue, 6-and-2
+// encoded data and checksum, epilogue) storing that sector of the diskImage (in DOS3.3 sector order).
+// It returns an error when the sector is not in diskImage.
+func EncodeSectorFields(addressField *[]byte, dataField *[]byte, diskImage []byte, trackNum int, physicalSector int, volume byte) error {
+	var sectorBuffer [0x0100]byte
+	var oddBits, evenBits byte
+	*addressField = []byte{'\xD5', '\xAA', '\x96'}
+	for _, value := range []byte{volume, byte(trackNum), byte(physicalSector), volume ^ byte(trackNum) ^ byte(physicalSector)} {
+		encode44(&oddBits, &evenBits, value)
+		*addressField = append(*addressField, oddBits, evenBits)
+	}
+	*addressField = append(*addressField, '\xDE', '\xAA', '\xEB')
+	var err error = diskimage.ReadSectorDataToBuffer(&sectorBuffer, diskImage, trackNum, DOS33_SECTOR_OF_PHYSICAL_SECTOR[physicalSector])
+	if err != nil {
+		return err
+	}
+	*dataField = []byte{'\xD5', '\xAA', '\xAD'}
+	*dataField = append(*dataField, EncodeGcr62Sector(sectorBuffer[:])...)
+	*dataField = append(*dataField, '\xDE', '\xAA', '\xEB')
+	return nil
+}
+
+// EncodeTrackNibbles fills trackNibbles with the NIB_TRACK_SIZE disk bytes of track trackNum of the
+// diskImage (in DOS3.3 sector order) as DOS 3.3 formats a track of volume volume: a gap of sync bytes,
+// then for each physical sector in turn its address field, a gap, its data field and another gap (see
+// EncodeSectorFields), the rest of the track being filled with sync bytes. It returns an error when the
+// track is not in diskImage.
+func EncodeTrackNibbles(trackNibbles []byte, diskImage []byte, trackNum int, volume byte) error {
+	var nibbles []byte = make([]byte, 0, NIB_TRACK_SIZE)
+	var addressField, dataField []byte
+	nibbles = append(nibbles, bytes.Repeat([]byte{'\xFF'}, 0x30)...)
+	for sector := 0; sector < 0x10; sector = sector + 1 {
+		var err error = EncodeSectorFields(&addressField, &dataField, diskImage, trackNum, sector, volume)
+		if err != nil {
+			return err
+		}
+		nibbles = append(nibbles, addressField...)
+		nibbles = append(nibbles, bytes.Repeat([]byte{'\xFF'}, 0x06)...)
+		nibbles = append(nibbles, dataField...)
+		nibbles = append(nibbles, bytes.Repeat([]byte{'\xFF'}, 0x1B)...)
+	}
+	nibbles = append(nibbles, bytes.Repeat([]byte{'\xFF'}, NIB_TRACK_SIZE-len(nibbles))...)
+	copy(trackNibbles, nibbles)
+	return nil
+}
+
+// ConvertDos33OrderImageToNibbleImage replaces the 140K diskImage (in DOS3.3 sector order) with the
+// .NIB image of its 35 tracks, encoded as a DOS 3.3 disk of volume 254.
+func ConvertDos33OrderImageToNibbleImage(diskImage *[]byte) error {
+	var nibbleImage []byte = make([]byte, NIB_IMAGE_SIZE)
+	for trackNum := 0; trackNum < 0x23; trackNum = trackNum + 1 {
+		var err error = EncodeTrackNibbles(nibbleImage[trackNum*NIB_TRACK_SIZE:(trackNum+1)*NIB_TRACK_SIZE], *diskImage, trackNum, '\xFE')
+		if err != nil {
+			return err
+		}
+	}
+	*diskImage = nibbleImage
+	return nil
+}
+
+// ConvertNibbleImageToDos33Order replaces the content of a .NIB image in diskImage with the 256 byte
+// sectors decoded from it, in DOS3.3 sector order. Unrecoverable sectors are left filled with zeros
+// when skipBadSectors is set, and are an error otherwise.
+func ConvertNibbleImageToDos33Order(diskImage *[]byte, skipBadSectors bool) error {
+	if len(*diskImage) != NIB_IMAGE_SIZE {
+		return failure.CodedErrorf(failure.KIND_NIBBLE_IMAGE_SIZE, "nibble images must hold %d bytes, not %d", NIB_IMAGE_SIZE, len(*diskImage))
+	}
+	var sectorImage []byte = make([]byte, diskimage.FLOPPY_IMAGE_SIZE)
+	var unrecoverableSectors []string
+	for trackNum := 0; trackNum < 0x23; trackNum = trackNum + 1 {
+		var err error = decodeTrackNibbles(&unrecoverableSectors, sectorImage, trackNum, (*diskImage)[trackNum*NIB_TRACK_SIZE:(trackNum+1)*NIB_TRACK_SIZE])
+		if err != nil {
+			return err
+		}
+	}
+	var err error = reportUnrecoverableSectors(unrecoverableSectors, skipBadSectors)
+	if err != nil {
+		return err
+	}
+	*diskImage = sectorImage
+	fmt.Fprintf(os.Stderr, "decoded %d sectors from nibble image\n", 0x23*0x10-len(unrecoverableSectors))
+	return nil
+}
+
+// Nibble image section end
+
+// 13-sector section begin
+
+// A 13-sector (.D13) image holds the 35 tracks of a disk formatted by DOS 3.1 or DOS 3.2, as 13 sectors
+// of 256 bytes per track in the order of the sector numbers in their address fields. On the disk the
+// sectors are 5-and-3 encoded, and their address fields start with D5 AA B5 instead of D5 AA 96.
+const D13_SECTOR_COUNT = 0x0D
+const D13_IMAGE_SIZE = 0x23 * D13_SECTOR_COUNT * 0x0100
+
+// GCR_53_WRITE_TABLE holds the 32 valid disk bytes of a 13-sector disk, which encode the 5 bit values
+// 0x00-0x1F.
+var GCR_53_WRITE_TABLE [0x20]byte = [0x20]byte{
+	'\xAB', '\xAD', '\xAE', '\xAF', '\xB5', '\xB6', '\xB7', '\xBA', '\xBB', '\xBD', '\xBE', '\xBF', '\xD6', '\xD7', '\xDA', '\xDB',
+	'\xDD', '\xDE', '\xDF', '\xEA', '\xEB', '\xED', '\xEE', '\xEF', '\xF5', '\xF6', '\xF7', '\xFA', '\xFB', '\xFD', '\xFE', '\xFF',
+}
+
+// DOS32_SECTOR_SKEW lists the sectors of a track in the order DOS 3.2 formats them around the track.
+var DOS32_SECTOR_SKEW [D13_SECTOR_COUNT]int = [D13_SECTOR_COUNT]int{
+	0x00, 0x0A, 0x07, 0x04, 0x01, 0x0B, 0x08, 0x05, 0x02, 0x0C, 0x09, 0x06, 0x03,
+}
+
+// D13ImageStartPosOfTrackSector returns the offset of the start of sector sectorNum (in [0,12]) of
+// track trackNum (in [0,34]) in a 13-sector image.
+func D13ImageStartPosOfTrackSector(trackNum int, sectorNum int) int {
+	return (trackNum*D13_SECTOR_COUNT + sectorNum) * 0x0100
+}
+
+// packGcr53Sector fills values with the 410 5 bit values of the data field storing the 256 bytes of
+// sector, in the order they are written: the 154 values holding the low 3 bits of the bytes, last one
+// first, then the 256 values holding their high 5 bits. The bytes are taken 5 at a time, the low bits
+// of the 4th and 5th byte of each group being spread over the 3 low bit values of the group.
+func packGcr53Sector(values *[0x019A]byte, sector []byte) {
+	var threes [0x9A]byte
+	var tops []byte = values[0x9A:]
+	for group := 0; group < 0x33; group = group + 1 {
+		var pos int = 0x32 - group
+		var b []byte = sector[group*5 : group*5+5]
+		tops[pos] = b[0] >> 3
+		tops[pos+0x33] = b[1] >> 3
+		tops[pos+0x66] = b[2] >> 3
+		tops[pos+0x99] = b[3] >> 3
+		tops[pos+0xCC] = b[4] >> 3
+		threes[pos] = ((b[0] & '\x07') << 2) | ((b[3] & '\x04') >> 1) | ((b[4] & '\x04') >> 2)
+		threes[pos+0x33] = ((b[1] & '\x07') << 2) | (b[3] & '\x02') | ((b[4] & '\x02') >> 1)
+		threes[pos+0x66] = ((b[2] & '\x07') << 2) | ((b[3] & '\x01') << 1) | (b[4] & '\x01')
+	}
+	tops[0xFF] = sector[0xFF] >> 3
+	threes[0x99] = sector[0xFF] & '\x07'
+	for i := 0; i < 0x9A; i = i + 1 {
+		values[i] = threes[0x99-i]
+	}
+}
+
+// unpackGcr53Sector fills sectorBuffer from the 410 decoded 5 bit values of a data field, reversing
+// packGcr53Sector.
+func unpackGcr53Sector(sectorBuffer *[0x0100]byte, values []byte) {
+	var threes [0x9A]byte
+	for i := 0; i < 0x9A; i = i + 1 {
+		threes[0x99-i] = values[i]
+	}
+	var tops []byte = values[0x9A:]
+	for group := 0; group < 0x33; group = group + 1 {
+		var pos int = 0x32 - group
+		var b []byte = sectorBuffer[group*5 : group*5+5]
+		b[0] = (tops[pos] << 3) | (threes[pos] >> 2)
+		b[1] = (tops[pos+0x33] << 3) | (threes[pos+0x33] >> 2)
+		b[2] = (tops[pos+0x66] << 3) | (threes[pos+0x66] >> 2)
+		b[3] = (tops[pos+0x99] << 3) | ((threes[pos] & '\x02') << 1) | (threes[pos+0x33] & '\x02') | ((threes[pos+0x66] & '\x02') >> 1)
+		b[4] = (tops[pos+0xCC] << 3) | ((threes[pos] & '\x01') << 2) | ((threes[pos+0x33] & '\x01') << 1) | (threes[pos+0x66] & '\x01')
+	}
+	sectorBuffer[0xFF] = (tops[0xFF] << 3) | (threes[0x99] & '\x07')
+}
+
+// EncodeGcr53Sector returns the 411 disk bytes of the data field storing the 256 bytes of sector: the
+// 410 5-and-3 encoded bytes followed by the checksum byte, as DecodeGcr53Sector reads them.
+func EncodeGcr53Sector(sector []byte) []byte {
+	var values [0x019A]byte
+	packGcr53Sector(&values, sector)
+	var nibbles []byte = make([]byte, 0x019B)
+	// each 5 bit value is stored exclusive or'ed with the one before it
+	var previous byte = '\x00'
+	for i := 0; i < 0x019A; i = i + 1 {
+		nibbles[i] = GCR_53_WRITE_TABLE[values[i]^previous]
+		previous = values[i]
+	}
+	nibbles[0x019A] = GCR_53_WRITE_TABLE[previous]
+	return nibbles
+}
+
+// DecodeGcr53Sector fills sectorBuffer from the 411 disk bytes of a data field of a 13-sector disk. It
+// returns false, storing the reason into failure, when a disk byte is not valid or the checksum does
+// not match.
+func DecodeGcr53Sector(sectorBuffer *[0x0100]byte, failure *string, nibbles []byte) bool {
+	var readTable [0x0100]int
+	for i := 0; i < 0x0100; i = i + 1 {
+		readTable[i] = -1
+	}
+	for i := 0; i < 0x20; i = i + 1 {
+		readTable[GCR_53_WRITE_TABLE[i]] = i
+	}
+	var values [0x019A]byte
+	var previous byte = '\x00'
+	for i := 0; i < 0x019A; i = i + 1 {
+		var fiveBits int = readTable[nibbles[i]]
+		if fiveBits < 0 {
+			*failure = fmt.Sprintf("its data field holds the invalid disk byte 0x%02X", nibbles[i])
+			return false
+		}
+		previous = previous ^ byte(fiveBits)
+		values[i] = previous
+	}
+	if readTable[nibbles[0x019A]] != int(previous) {
+		*failure = "its data field failed its checksum"
+		return false
+	}
+	unpackGcr53Sector(sectorBuffer, values[:])
+	return true
+}
+
+// Encode13SectorFields fills addressField with the 14 disk bytes of the address field of sector sector
+// of track trackNum of volume volume on a 13-sector disk, and dataField with the 417 disk bytes of its
+// data field (prologue, 5-and-3 encoded data and checksum, epilogue) storing that sector of the
+// 13-sector diskImage.
+func Encode13SectorFields(addressField *[]byte, dataField *[]byte, diskImage []byte, trackNum int, sector int, volume byte) {
+	var oddBits, evenBits byte
+	*addressField = []byte{'\xD5', '\xAA', '\xB5'}
+	for _, value := range []byte{volume, byte(trackNum), byte(sector), volume ^ byte(trackNum) ^ byte(sector)} {
+		encode44(&oddBits, &evenBits, value)
+		*addressField = append(*addressField, oddBits, evenBits)
+	}
+	*addressField = append(*addressField, '\xDE', '\xAA', '\xEB')
+	var sectorStartPos int = D13ImageStartPosOfTrackSector(trackNum, sector)
+	*dataField = []byte{'\xD5', '\xAA', '\xAD'}
+	*dataField = append(*dataField, EncodeGcr53Sector(diskImage[sectorStartPos:sectorStartPos+0x0100])...)
+	*dataField = append(*dataField, '\xDE', '\xAA', '\xEB')
+}
+
+// encode13SectorTrackNibbles fills trackNibbles with the NIB_TRACK_SIZE disk bytes of track trackNum of
+// the 13-sector diskImage as DOS 3.2 formats a track of volume volume: as EncodeTrackNibbles does, with
+// the sectors in the order of DOS32_SECTOR_SKEW.
+func encode13SectorTrackNibbles(trackNibbles []byte, diskImage []byte, trackNum int, volume byte) {
+	var nibbles []byte = make([]byte, 0, NIB_TRACK_SIZE)
+	var addressField, dataField []byte
+	nibbles = append(nibbles, bytes.Repeat([]byte{'\xFF'}, 0x30)...)
+	for _, sector := range DOS32_SECTOR_SKEW {
+		Encode13SectorFields(&addressField, &dataField, diskImage, trackNum, sector, volume)
+		nibbles = append(nibbles, addressField...)
+		nibbles = append(nibbles, bytes.Repeat([]byte{'\xFF'}, 0x06)...)
+		nibbles = append(nibbles, dataField...)
+		nibbles = append(nibbles, bytes.Repeat([]byte{'\xFF'}, 0x1B)...)
+	}
+	nibbles = append(nibbles, bytes.Repeat([]byte{'\xFF'}, NIB_TRACK_SIZE-len(nibbles))...)
+	copy(trackNibbles, nibbles)
+}
+
+// decode13SectorTrackNibbles copies the 13 sectors found in the disk bytes of one track of a 13-sector
+// disk into the 13-sector diskImage at track trackNum, as decodeTrackNibbles does for 16 sectors.
+func decode13SectorTrackNibbles(unrecoverableSectors *[]string, diskImage []byte, trackNum int, trackNibbles []byte) {
+	var nibbles []byte = append(append([]byte{}, trackNibbles...), trackNibbles...)
+	var sectorFound [D13_SECTOR_COUNT]bool
+	var sectorFailures [D13_SECTOR_COUNT]string
+	var sectorBuffer [0x0100]byte
+	var pos int = 0
+	for pos+0x0E < len(nibbles) {
+		if nibbles[pos] != '\xD5' || nibbles[pos+1] != '\xAA' || nibbles[pos+2] != '\xB5' {
+			pos = pos + 1
+			continue
+		}
+		var volume byte = decode44(nibbles[pos+3], nibbles[pos+4])
+		var track byte = decode44(nibbles[pos+5], nibbles[pos+6])
+		var sector byte = decode44(nibbles[pos+7], nibbles[pos+8])
+		var checksum byte = decode44(nibbles[pos+9], nibbles[pos+10])
+		pos = pos + 11
+		if sector >= D13_SECTOR_COUNT {
+			continue
+		}
+		if volume^track^sector != checksum {
+			sectorFailures[sector] = "its address field failed its checksum"
+			continue
+		}
+		if int(track) != trackNum {
+			sectorFailures[sector] = fmt.Sprintf("its address field holds track %d", track)
+			continue
+		}
+		var dataPos int = pos
+		for dataPos+3 < len(nibbles) && dataPos < pos+0x40 && !(nibbles[dataPos] == '\xD5' && nibbles[dataPos+1] == '\xAA' && nibbles[dataPos+2] == '\xAD') {
+			dataPos = dataPos + 1
+		}
+		if sectorFound[sector] {
+			continue
+		}
+		if dataPos >= pos+0x40 || dataPos+3+0x019B > len(nibbles) {
+			sectorFailures[sector] = "no data field follows its address field"
+			continue
+		}
+		if !DecodeGcr53Sector(&sectorBuffer, &sectorFailures[sector], nibbles[dataPos+3:dataPos+3+0x019B]) {
+			continue
+		}
+		copy(diskImage[D13ImageStartPosOfTrackSector(trackNum, int(sector)):], sectorBuffer[:])
+		sectorFound[sector] = true
+		pos = dataPos + 3 + 0x019B
+	}
+	for sector := 0; sector < D13_SECTOR_COUNT; sector = sector + 1 {
+		if sectorFound[sector] {
+			continue
+		}
+		if sectorFailures[sector] == "" {
+			sectorFailures[sector] = "no address field holds it"
+		}
+		*unrecoverableSectors = append(*unrecoverableSectors, fmt.Sprintf("track %d 13-sector sector %d: %s", trackNum, sector, sectorFailures[sector]))
+	}
+}
+
+// NibbleImageIs13Sector returns true when the first track of the nibble diskImage holds the address
+// field prologue of a 13-sector disk (D5 AA B5) and not that of a 16-sector disk (D5 AA 96).
+func NibbleImageIs13Sector(diskImage []byte) bool {
+	if len(diskImage) < NIB_TRACK_SIZE {
+		return false
+	}
+	var trackNibbles []byte = diskImage[0:NIB_TRACK_SIZE]
+	return bytes.Contains(trackNibbles, []byte{'\xD5', '\xAA', '\xB5'}) && !bytes.Contains(trackNibbles, []byte{'\xD5', '\xAA', '\x96'})
+}
+
+// Convert13SectorImageToNibbleImage replaces the 13-sector diskImage with the .NIB image of its 35
+// tracks, encoded as a DOS 3.2 disk of volume 254.
+func Convert13SectorImageToNibbleImage(diskImage *[]byte) {
+	var nibbleImage []byte = make([]byte, NIB_IMAGE_SIZE)
+	for trackNum := 0; trackNum < 0x23; trackNum = trackNum + 1 {
+		encode13SectorTrackNibbles(nibbleImage[trackNum*NIB_TRACK_SIZE:(trackNum+1)*NIB_TRACK_SIZE], *diskImage, trackNum, '\xFE')
+	}
+	*diskImage = nibbleImage
+}
+
+// ConvertNibbleImageTo13SectorImage replaces the content of a .NIB image of a 13-sector disk in
+// diskImage with the 256 byte sectors decoded from it, as a 13-sector image, handling unrecoverable
+// sectors as ConvertNibbleImageToDos33Order does.
+func ConvertNibbleImageTo13SectorImage(diskImage *[]byte, skipBadSectors bool) error {
+	if len(*diskImage) != NIB_IMAGE_SIZE {
+		return failure.CodedErrorf(failure.KIND_NIBBLE_IMAGE_SIZE, "nibble images must hold %d bytes, not %d", NIB_IMAGE_SIZE, len(*diskImage))
+	}
+	var sectorImage []byte = make([]byte, D13_IMAGE_SIZE)
+	var unrecoverableSectors []string
+	for trackNum := 0; trackNum < 0x23; trackNum = trackNum + 1 {
+		decode13SectorTrackNibbles(&unrecoverableSectors, sectorImage, trackNum, (*diskImage)[trackNum*NIB_TRACK_SIZE:(trackNum+1)*NIB_TRACK_SIZE])
+	}
+	var err error = reportUnrecoverableSectors(unrecoverableSectors, skipBadSectors)
+	if err != nil {
+		return err
+	}
+	*diskImage = sectorImage
+	fmt.Fprintf(os.Stderr, "decoded %d sectors from 13-sector nibble image\n", 0x23*D13_SECTOR_COUNT-len(unrecoverableSectors))
+	return nil
+}
+
+// Check13SectorInstall returns an error when the 13-sector image read from diskImageFilepath is to be
+// installed other than by the bootstrap writer, as the RWTS of DOS 3.3 only writes 16-sector tracks.
+func Check13SectorInstall(diskImageFilepath string, profile string) error {
+	if profile != "bootstrap" {
+		return failure.CodedErrorf(failure.KIND_13_SECTOR_INSTALL, "%s is a 13-sector image, which needs -profile bootstrap to be installed", diskImageFilepath)
+	}
+	return nil
+}
+
+// 13-sector section end
+
+// WOZ image section begin
+
+// A WOZ image starts with a 12 byte header (WOZ1 or WOZ2, FF 0A 0D 0A, and the CRC32 of the rest of
+// the file), followed by chunks of a 4 character id, a 4 byte length and the chunk data. TMAP maps
+// each quarter track to a track of TRKS, which holds the bits read from the disk for each track.
+const WOZ_HEADER_SIZE = 12
+const WOZ_CHUNK_HEADER_SIZE = 8
+const WOZ1_TRACK_SIZE = 0x1A00
+const WOZ1_TRACK_BITS_SIZE = 0x19F6
+const WOZ2_TRACK_ENTRY_SIZE = 8
+const WOZ2_BLOCK_SIZE = 0x0200
+
+// readWozChunks fills chunks with the data of each chunk in the WOZ image wozImage, by chunk id, and
+// stores the WOZ version (1 or 2) into version. The CRC32 of the image is checked when it is not zero.
+func readWozChunks(version *int, chunks map[string][]byte, wozImage []byte) error {
+	if len(wozImage) < WOZ_HEADER_SIZE || !bytes.Equal(wozImage[4:8], []byte("\xFF\x0A\x0D\x0A")) {
+		return failure.CodedErrorf(failure.KIND_WOZ, "not a WOZ image")
+	}
+	if string(wozImage[0:4]) == "WOZ1" {
+		*version = 1
+	} else if string(wozImage[0:4]) == "WOZ2" {
+		*version = 2
+	} else {
+		return failure.CodedErrorf(failure.KIND_WOZ, "not a WOZ image")
+	}
+	var checksum uint32 = binary.LittleEndian.Uint32(wozImage[8:12])
+	if checksum != 0 && checksum != crc32.ChecksumIEEE(wozImage[WOZ_HEADER_SIZE:]) {
+		return failure.CodedErrorf(failure.KIND_WOZ_CRC32, "WOZ image failed its CRC32 check")
+	}
+	var pos int = WOZ_HEADER_SIZE
+	for pos+WOZ_CHUNK_HEADER_SIZE <= len(wozImage) {
+		var id string = string(wozImage[pos : pos+4])
+		var size int = int(binary.LittleEndian.Uint32(wozImage[pos+4 : pos+8]))
+		pos = pos + WOZ_CHUNK_HEADER_SIZE
+		if size > len(wozImage)-pos {
+			return failure.CodedErrorf(failure.KIND_WOZ, "WOZ image chunk %s is truncated", id)
+		}
+		chunks[id] = wozImage[pos : pos+size]
+		pos = pos + size
+	}
+	if chunks["TMAP"] == nil || chunks["TRKS"] == nil {
+		return failure.CodedErrorf(failure.KIND_WOZ, "WOZ image has no TMAP or TRKS chunk")
+	}
+	return nil
+}
+
+// wozTrackBits stores into bits the bitstream of whole track trackNum of the WOZ image wozImage, and
+// into bitCount the count of bits in it. An error is returned when the TMAP chunk does not map the
+// track, or the TRKS chunk (or the image, for WOZ2) is too short to hold it.
+func wozTrackBits(bits *[]byte, bitCount *int, wozImage []byte, chunks map[string][]byte, version int, trackNum int) error {
+	var trackMap []byte = chunks["TMAP"]
+	// the map has an entry per quarter track
+	if trackNum*4 >= len(trackMap) {
+		return failure.TrackErrorf(failure.KIND_WOZ, trackNum, "WOZ image TMAP chunk of %d bytes does not map track %d", len(trackMap), trackNum)
+	}
+	var trackIndex int = int(trackMap[trackNum*4])
+	if trackIndex == 0xFF {
+		return failure.TrackErrorf(failure.KIND_WOZ_TRACK_MISSING, trackNum, "track %d is not in the WOZ image", trackNum)
+	}
+	var tracks []byte = chunks["TRKS"]
+	if version == 1 {
+		if (trackIndex+1)*WOZ1_TRACK_SIZE > len(tracks) {
+			return failure.TrackErrorf(failure.KIND_WOZ, trackNum, "WOZ image TRKS chunk of %d bytes does not hold track %d (TRKS track %d)", len(tracks), trackNum, trackIndex)
+		}
+		var track []byte = tracks[trackIndex*WOZ1_TRACK_SIZE : (trackIndex+1)*WOZ1_TRACK_SIZE]
+		*bits = track[:WOZ1_TRACK_BITS_SIZE]
+		*bitCount = int(binary.LittleEndian.Uint16(track[WOZ1_TRACK_BITS_SIZE+2:]))
+		return nil
+	}
+	if (trackIndex+1)*WOZ2_TRACK_ENTRY_SIZE > len(tracks) {
+		return failure.TrackErrorf(failure.KIND_WOZ, trackNum, "WOZ image TRKS chunk of %d bytes has no entry for track %d (TRKS track %d)", len(tracks), trackNum, trackIndex)
+	}
+	var entry []byte = tracks[trackIndex*WOZ2_TRACK_ENTRY_SIZE : (trackIndex+1)*WOZ2_TRACK_ENTRY_SIZE]
+	var startPos int = int(binary.LittleEndian.Uint16(entry[0:2])) * WOZ2_BLOCK_SIZE
+	var size int = int(binary.LittleEndian.Uint16(entry[2:4])) * WOZ2_BLOCK_SIZE
+	if startPos+size > len(wozImage) {
+		return failure.TrackErrorf(failure.KIND_WOZ, trackNum, "track %d lies past the end of the WOZ image, at %d bytes of %d", trackNum, startPos+size, len(wozImage))
+	}
+	*bits = wozImage[startPos : startPos+size]
+	*bitCount = int(binary.LittleEndian.Uint32(entry[4:8]))
+	return nil
+}
+
+// bitsToNibbles stores into nibbles the disk bytes read from a track bitstream of bitCount bits by
+// shifting its bits into a latch until the high bit of the latch is set, as the disk controller does.
+// The track is read around twice so that the controller is in step with the disk bytes when the track
+// start comes round again.
+func bitsToNibbles(nibbles *[]byte, bits []byte, bitCount int) error {
+	if bitCount <= 0 {
+		return failure.CodedErrorf(failure.KIND_WOZ, "WOZ track holds no bits")
+	}
+	if bitCount > len(bits)*8 {
+		return failure.CodedErrorf(failure.KIND_WOZ, "WOZ track of %d bits holds only %d bytes", bitCount, len(bits))
+	}
+	*nibbles = nil
+	var latch byte = '\x00'
+	for i := 0; i < 2*bitCount; i = i + 1 {
+		var bitPos int = i % bitCount
+		latch = (latch << 1) | ((bits[bitPos/8] >> uint(7-bitPos%8)) & '\x01')
+		if latch&'\x80' != 0 {
+			*nibbles = append(*nibbles, latch)
+			latch = '\x00'
+		}
+	}
+	return nil
+}
+
+// ConvertWozImageToDos33Order replaces the content of a WOZ 1.0 or 2.0 image in diskImage with the
+// 256 byte sectors decoded from its tracks, in DOS3.3 sector order, handling unrecoverable sectors as
+// ConvertNibbleImageToDos33Order does.
+func ConvertWozImageToDos33Order(diskImage *[]byte, skipBadSectors bool) error {
+	var chunks map[string][]byte = make(map[string][]byte)
+	var version int
+	var err error = readWozChunks(&version, chunks, *diskImage)
+	if err != nil {
+		return err
+	}
+	var sectorImage []byte = make([]byte, diskimage.FLOPPY_IMAGE_SIZE)
+	var unrecoverableSectors []string
+	for trackNum := 0; trackNum < 0x23; trackNum = trackNum + 1 {
+		var bits []byte
+		var bitCount int
+		var nibbles []byte
+		err = wozTrackBits(&bits, &bitCount, *diskImage, chunks, version, trackNum)
+		if err == nil {
+			err = bitsToNibbles(&nibbles, bits, bitCount)
+		}
+		if err != nil {
+			return failure.TrackErrorf(failure.ErrorKind{}, trackNum, "track %d: %w", trackNum, err)
+		}
+		err = decodeTrackNibbles(&unrecoverableSectors, sectorImage, trackNum, nibbles)
+		if err != nil {
+			return err
+		}
+	}
+	err = reportUnrecoverableSectors(unrecoverableSectors, skipBadSectors)
+	if err != nil {
+		return err
+	}
+	*diskImage = sectorImage
+	fmt.Fprintf(os.Stderr, "decoded %d sectors from WOZ%d image\n", 0x23*0x10-len(unrecoverableSectors), version)
+	return nil
+}
+
+// WOZ image section end
@@ -0,0 +1,1452 @@
+/*
+apple2disk protocol package
+Copyright (C) 2024 github user bassjack1 <147515670+bassjack1@users.noreply.github.com>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+Communication with the author can be done via tagging @bassjack1 in github.com issues or by composing
+private messages to user bassjack1 on reddit.com : https://www.reddit.com/message/compose/
+*/
+
+// Package protocol implements the file transfer protocols of the apple2disk tools: XMODEM, YMODEM,
+// ZMODEM, the ADTPro host and the Apple /// client.
+package protocol
+
+import "bufio"
+import "encoding/binary"
+import "encoding/hex"
+import "errors"
+import "fmt"
+import "github.com/bassjack1/apple2disk/go/diskimage"
+import "github.com/bassjack1/apple2disk/go/failure"
+import "github.com/bassjack1/apple2disk/go/filesystem"
+import "hash/crc32"
+import "io"
+import "io/ioutil"
+import "os"
+import "path/filepath"
+import "strings"
+import "time"
+
+// YMODEM section begin
+
+// YMODEM control characters.
+const YMODEM_SOH = 0x01
+const YMODEM_STX = 0x02
+const YMODEM_EOT = 0x04
+const YMODEM_ACK = 0x06
+const YMODEM_NAK = 0x15
+const YMODEM_CAN = 0x18
+const YMODEM_CRC = 'C'
+
+// YMODEM_MAX_RETRIES is the number of times a block is sent before giving up on the receiver.
+const YMODEM_MAX_RETRIES = 10
+
+// YmodemFile is one file of a YMODEM batch.
+type YmodemFile struct {
+	Name    string
+	Data    []byte
+	ModTime time.Time
+}
+
+// YmodemLink is the connection to the receiver: characters received from it arrive on input, and
+// characters for it are written to output. protocol names the protocol (YMODEM, XMODEM or ZMODEM) in
+// messages, and checksum is set when an XMODEM receiver asked for blocks with an arithmetic checksum
+// rather than a CRC. canReceived is set when the last character received was a CAN passed on to a
+// ZMODEM sender.
+type YmodemLink struct {
+	Input       chan byte
+	Output      io.Writer
+	Protocol    string
+	checksum    bool
+	canReceived bool
+}
+
+// errorKind returns the kind of the failures of transfers with the protocol of l.
+func (l *YmodemLink) errorKind() failure.ErrorKind {
+	if l.Protocol == "XMODEM" {
+		return failure.KIND_XMODEM
+	}
+	if l.Protocol == "ZMODEM" {
+		return failure.KIND_ZMODEM
+	}
+	return failure.KIND_YMODEM
+}
+
+// ReadYmodemLinkInput sends each byte read from r to the input channel, closing it at the end of r.
+func ReadYmodemLinkInput(input chan byte, r io.Reader) {
+	var bufr *bufio.Reader = bufio.NewReader(r)
+	for {
+		var b byte
+		b, err := bufr.ReadByte()
+		if err != nil {
+			if !errors.Is(err, io.EOF) {
+				serialInputFailure = err
+			}
+			close(input)
+			return
+		}
+		input <- b
+	}
+}
+
+// serialInputFailure is the read error which stopped ReadYmodemLinkInput, when it was not the end of
+// its input. It is set before the input is closed.
+var serialInputFailure error
+
+// ClosedInputError returns the failure of kind for finding the input of the serial line closed while
+// doing what message tells: message, wrapping serialInputFailure when that is what closed it.
+func ClosedInputError(kind failure.ErrorKind, message string) error {
+	if serialInputFailure == nil {
+		return failure.CodedErrorf(kind, "%s", message)
+	}
+	return failure.CodedErrorf(kind, "%s: %w", message, serialInputFailure)
+}
+
+// receive waits up to timeout for a character from the receiver, storing it into b. It returns false
+// on timeout, and an error when the receiver has gone away or cancels the transfer with two CAN
+// characters in a row. A single CAN, which line noise can give, is dropped, except with ZMODEM, which
+// escapes characters with it (as ZDLE).
+func (l *YmodemLink) receive(b *byte, timeout time.Duration) (bool, error) {
+	var deadline <-chan time.Time = time.After(timeout)
+	var cancelCount int = 0
+	for {
+		select {
+		case received, ok := <-l.Input:
+			if !ok {
+				return false, ClosedInputError(l.errorKind(), fmt.Sprintf("%s receiver closed the connection", l.Protocol))
+			}
+			if received != YMODEM_CAN {
+				l.canReceived = false
+				*b = received
+				return true, nil
+			}
+			cancelCount = cancelCount + 1
+			if cancelCount == 2 || l.canReceived {
+				return false, failure.CodedErrorf(l.errorKind(), "%s transfer cancelled by the receiver", l.Protocol)
+			}
+			if l.Protocol == "ZMODEM" {
+				l.canReceived = true
+				*b = received
+				return true, nil
+			}
+		case <-deadline:
+			return false, nil
+		}
+	}
+}
+
+// waitForCrcRequest waits for the receiver to request a block with CRC checking, which it does to
+// start each file and the end of the batch.
+func (l *YmodemLink) waitForCrcRequest() error {
+	for tries := 0; tries < 6*YMODEM_MAX_RETRIES; tries = tries + 1 {
+		var b byte
+		ok, err := l.receive(&b, time.Second)
+		if err != nil {
+			return err
+		}
+		if ok && b == YMODEM_CRC {
+			return nil
+		}
+	}
+	return failure.CodedErrorf(l.errorKind(), "%s receiver did not request a transfer", l.Protocol)
+}
+
+// waitForTransferRequest waits for an XMODEM receiver to request the first block, either with CRC
+// checking (XMODEM-CRC) or, from older receivers, with NAK for an arithmetic checksum.
+func (l *YmodemLink) waitForTransferRequest() error {
+	for tries := 0; tries < 6*YMODEM_MAX_RETRIES; tries = tries + 1 {
+		var b byte
+		ok, err := l.receive(&b, time.Second)
+		if err != nil {
+			return err
+		}
+		if ok && (b == YMODEM_CRC || b == YMODEM_NAK) {
+			l.checksum = b == YMODEM_NAK
+			return nil
+		}
+	}
+	return failure.CodedErrorf(l.errorKind(), "%s receiver did not request a transfer", l.Protocol)
+}
+
+// crc16Xmodem returns the CRC-16 (polynomial 0x1021, initial value 0) of data used by XMODEM and YMODEM.
+func crc16Xmodem(data []byte) uint16 {
+	var crc uint16 = 0
+	for _, b := range data {
+		crc = crc ^ uint16(b)<<8
+		for bit := 0; bit < 8; bit = bit + 1 {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc = crc << 1
+			}
+		}
+	}
+	return crc
+}
+
+// sendBlock sends data (128 or 1024 bytes) as block blockNum with a CRC (or with checksum, the sum of
+// its bytes), repeating it until the receiver acknowledges it.
+func (l *YmodemLink) sendBlock(blockNum int, data []byte) error {
+	var block []byte
+	if len(data) == 0x80 {
+		block = append(block, YMODEM_SOH)
+	} else {
+		block = append(block, YMODEM_STX)
+	}
+	block = append(block, byte(blockNum), byte(^blockNum))
+	block = append(block, data...)
+	if l.checksum {
+		var sum byte = 0
+		for _, b := range data {
+			sum = sum + b
+		}
+		block = append(block, sum)
+	} else {
+		var crc uint16 = crc16Xmodem(data)
+		block = append(block, byte(crc>>8), byte(crc))
+	}
+	for tries := 0; tries < YMODEM_MAX_RETRIES; tries = tries + 1 {
+		_, err := l.Output.Write(block)
+		if err != nil {
+			return failure.CodedErrorf(l.errorKind(), "sending %s block %d: %w", l.Protocol, blockNum, err)
+		}
+		var b byte
+		ok, err := l.receive(&b, 10*time.Second)
+		if err != nil {
+			return failure.CodedErrorf(l.errorKind(), "sending %s block %d: %w", l.Protocol, blockNum, err)
+		}
+		if ok && b == YMODEM_ACK {
+			return nil
+		}
+	}
+	return failure.CodedErrorf(l.errorKind(), "%s block %d was not acknowledged after %d tries", l.Protocol, blockNum, YMODEM_MAX_RETRIES)
+}
+
+// sendEndOfFile sends EOT until the receiver acknowledges it.
+func (l *YmodemLink) sendEndOfFile() error {
+	for tries := 0; tries < YMODEM_MAX_RETRIES; tries = tries + 1 {
+		_, err := l.Output.Write([]byte{YMODEM_EOT})
+		if err != nil {
+			return failure.CodedErrorf(l.errorKind(), "sending %s end of file: %w", l.Protocol, err)
+		}
+		var b byte
+		ok, err := l.receive(&b, 10*time.Second)
+		if err != nil {
+			return failure.CodedErrorf(l.errorKind(), "sending %s end of file: %w", l.Protocol, err)
+		}
+		if ok && b == YMODEM_ACK {
+			return nil
+		}
+	}
+	return failure.CodedErrorf(l.errorKind(), "%s end of file was not acknowledged", l.Protocol)
+}
+
+// SendYmodemBatch sends files to a YMODEM receiver over link, each as a header block 0 holding the
+// name, length and modification time, followed by 1024 byte blocks of data (a final short remainder
+// goes in a 128 byte block) padded with 0x1A. An empty block 0 ends the batch. Before anything is
+// sent, it returns a KIND_YMODEM_NAME error when the header of a file does not fit its 128 byte block,
+// rather than send a cut name or length.
+func SendYmodemBatch(link *YmodemLink, files []YmodemFile) error {
+	var headers [][]byte = make([][]byte, len(files))
+	for i, file := range files {
+		var headerText string = fmt.Sprintf("%s\x00%d %o", file.Name, len(file.Data), file.ModTime.Unix())
+		if len(headerText) > 0x80 {
+			return failure.CodedErrorf(failure.KIND_YMODEM_NAME, "the name %s is too long for the 128 byte YMODEM header, by %d characters", file.Name, len(headerText)-0x80)
+		}
+		headers[i] = make([]byte, 0x80)
+		copy(headers[i], headerText)
+	}
+	var err error
+	for i, file := range files {
+		err = link.waitForCrcRequest()
+		if err != nil {
+			return err
+		}
+		err = link.sendBlock(0, headers[i])
+		if err == nil {
+			err = link.waitForCrcRequest()
+		}
+		var blockNum int = 1
+		for dataPos := 0; err == nil && dataPos < len(file.Data); blockNum = blockNum + 1 {
+			var blockSize int = 0x0400
+			if len(file.Data)-dataPos <= 0x80 {
+				blockSize = 0x80
+			}
+			var blockData []byte = make([]byte, blockSize)
+			var copied int = copy(blockData, file.Data[dataPos:])
+			for i := copied; i < blockSize; i = i + 1 {
+				blockData[i] = 0x1A
+			}
+			err = link.sendBlock(blockNum&0xFF, blockData)
+			dataPos = dataPos + copied
+		}
+		if err == nil {
+			err = link.sendEndOfFile()
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", file.Name, err)
+		}
+		fmt.Fprintf(os.Stderr, "sent %s (%d bytes) by YMODEM\n", file.Name, len(file.Data))
+	}
+	err = link.waitForCrcRequest()
+	if err != nil {
+		return err
+	}
+	return link.sendBlock(0, make([]byte, 0x80))
+}
+
+// SendXmodemFile sends file to an XMODEM receiver over link, as 128 byte blocks numbered from 1 and
+// padded with 0x1A, with a CRC or a checksum as the receiver asks. XMODEM carries neither the name nor
+// the exact length of the file, which the receiver is told separately.
+func SendXmodemFile(link *YmodemLink, file YmodemFile) error {
+	var err error = link.waitForTransferRequest()
+	if err != nil {
+		return err
+	}
+	var blockNum int = 1
+	for dataPos := 0; dataPos < len(file.Data); blockNum = blockNum + 1 {
+		var blockData []byte = make([]byte, 0x80)
+		var copied int = copy(blockData, file.Data[dataPos:])
+		for i := copied; i < len(blockData); i = i + 1 {
+			blockData[i] = 0x1A
+		}
+		err = link.sendBlock(blockNum&0xFF, blockData)
+		if err != nil {
+			return fmt.Errorf("%s: %w", file.Name, err)
+		}
+		dataPos = dataPos + copied
+	}
+	err = link.sendEndOfFile()
+	if err != nil {
+		return fmt.Errorf("%s: %w", file.Name, err)
+	}
+	var check string = "CRC"
+	if link.checksum {
+		check = "checksum"
+	}
+	fmt.Fprintf(os.Stderr, "sent %s (%d bytes in %d blocks) by XMODEM with %s\n", file.Name, len(file.Data), blockNum-1, check)
+	return nil
+}
+
+// YMODEM section end
+
+// ZMODEM section begin
+
+// ZMODEM framing characters: ZPAD starts a header, ZDLE (the CAN character) escapes the character
+// following it, and ZBIN, ZHEX and ZBIN32 give the format of the header which follows.
+const ZMODEM_ZPAD = '*'
+const ZMODEM_ZDLE = 0x18
+const ZMODEM_ZBIN = 'A'
+const ZMODEM_ZHEX = 'B'
+const ZMODEM_ZBIN32 = 'C'
+
+// ZMODEM frame types.
+const ZMODEM_ZRQINIT = 0x00
+const ZMODEM_ZRINIT = 0x01
+const ZMODEM_ZACK = 0x03
+const ZMODEM_ZFILE = 0x04
+const ZMODEM_ZSKIP = 0x05
+const ZMODEM_ZNAK = 0x06
+const ZMODEM_ZABORT = 0x07
+const ZMODEM_ZFIN = 0x08
+const ZMODEM_ZRPOS = 0x09
+const ZMODEM_ZDATA = 0x0A
+const ZMODEM_ZEOF = 0x0B
+const ZMODEM_ZFERR = 0x0C
+
+// ZMODEM_ZCRCW ends a data subpacket and its frame, and asks the receiver to acknowledge it with ZACK.
+const ZMODEM_ZCRCW = 'k'
+
+// ZMODEM_ZCBIN is the conversion option of ZFILE headers asking the receiver to store the file as it
+// is sent, without converting line ends.
+const ZMODEM_ZCBIN = 0x01
+
+// ZMODEM_SUBPACKET_SIZE is the largest count of file bytes sent in a data subpacket.
+const ZMODEM_SUBPACKET_SIZE = 0x0400
+
+// ZMODEM_XON and ZMODEM_XOFF are the software flow control characters, which are escaped in what is
+// sent and dropped from what is received.
+const ZMODEM_XON = 0x11
+const ZMODEM_XOFF = 0x13
+
+// zmodemHeader is a ZMODEM header: the frame type and four bytes holding a file position (the least
+// significant byte first) or flags.
+type zmodemHeader struct {
+	frameType byte
+	data      [4]byte
+}
+
+// zmodemPositionHeader returns the header of frameType holding the file position position.
+func zmodemPositionHeader(frameType byte, position int) zmodemHeader {
+	var header zmodemHeader = zmodemHeader{frameType: frameType}
+	binary.LittleEndian.PutUint32(header.data[:], uint32(position))
+	return header
+}
+
+// position returns the file position held in header.
+func (header zmodemHeader) position() int {
+	return int(binary.LittleEndian.Uint32(header.data[:]))
+}
+
+// appendZdleEscaped appends data to frame, with ZDLE, DLE, XON and XOFF (with and without their high
+// bit) sent as ZDLE followed by the character with bit 6 inverted, so that neither the receiver nor
+// the flow control of the serial line takes them for control characters.
+func appendZdleEscaped(frame []byte, data []byte) []byte {
+	for _, b := range data {
+		switch b & 0x7F {
+		case ZMODEM_ZDLE, 0x10, ZMODEM_XON, ZMODEM_XOFF:
+			frame = append(frame, ZMODEM_ZDLE, b^0x40)
+		default:
+			frame = append(frame, b)
+		}
+	}
+	return frame
+}
+
+// sendZmodemHexHeader sends header in hex, the format of the headers not followed by data subpackets.
+func (l *YmodemLink) sendZmodemHexHeader(header zmodemHeader) error {
+	var content []byte = append([]byte{header.frameType}, header.data[:]...)
+	var crc uint16 = crc16Xmodem(content)
+	content = append(content, byte(crc>>8), byte(crc))
+	var frame []byte = []byte{ZMODEM_ZPAD, ZMODEM_ZPAD, ZMODEM_ZDLE, ZMODEM_ZHEX}
+	frame = append(frame, hex.EncodeToString(content)...)
+	frame = append(frame, '\r', '\n'|0x80)
+	if header.frameType != ZMODEM_ZACK && header.frameType != ZMODEM_ZFIN {
+		frame = append(frame, ZMODEM_XON)
+	}
+	_, err := l.Output.Write(frame)
+	if err != nil {
+		return failure.CodedErrorf(l.errorKind(), "sending ZMODEM header: %w", err)
+	}
+	return nil
+}
+
+// sendZmodemFrame sends header in binary with a CRC-16, followed by data as a single data subpacket
+// ending the frame with ZCRCW.
+func (l *YmodemLink) sendZmodemFrame(header zmodemHeader, data []byte) error {
+	var content []byte = append([]byte{header.frameType}, header.data[:]...)
+	var crc uint16 = crc16Xmodem(content)
+	var frame []byte = []byte{ZMODEM_ZPAD, ZMODEM_ZDLE, ZMODEM_ZBIN}
+	frame = appendZdleEscaped(frame, append(content, byte(crc>>8), byte(crc)))
+	frame = appendZdleEscaped(frame, data)
+	// the CRC of a subpacket covers the character ending it
+	crc = crc16Xmodem(append(append([]byte{}, data...), ZMODEM_ZCRCW))
+	frame = append(frame, ZMODEM_ZDLE, ZMODEM_ZCRCW)
+	frame = appendZdleEscaped(frame, []byte{byte(crc >> 8), byte(crc)})
+	frame = append(frame, ZMODEM_XON)
+	_, err := l.Output.Write(frame)
+	if err != nil {
+		return failure.CodedErrorf(l.errorKind(), "sending ZMODEM frame: %w", err)
+	}
+	return nil
+}
+
+// receiveZdleEscaped stores into b the next character from the receiver before deadline, taking a
+// character escaped with ZDLE back and dropping XON and XOFF. It returns false on timeout, and the
+// error of receive.
+func (l *YmodemLink) receiveZdleEscaped(b *byte, deadline time.Time) (bool, error) {
+	var escaped bool = false
+	for {
+		ok, err := l.receive(b, time.Until(deadline))
+		if err != nil || !ok {
+			return false, err
+		}
+		if *b&0x7F == ZMODEM_XON || *b&0x7F == ZMODEM_XOFF {
+			continue
+		}
+		if *b == ZMODEM_ZDLE && !escaped {
+			escaped = true
+			continue
+		}
+		if escaped {
+			*b = *b ^ 0x40
+		}
+		return true, nil
+	}
+}
+
+// receiveZmodemHeader waits up to timeout for a header from the receiver, in hex or in binary with a
+// CRC-16 or a CRC-32, storing it into header. Characters before the header are skipped. It returns
+// false on timeout or when the header is garbled, and the error of receive.
+func (l *YmodemLink) receiveZmodemHeader(header *zmodemHeader, timeout time.Duration) (bool, error) {
+	var deadline time.Time = time.Now().Add(timeout)
+	var b byte
+	var padCount int = 0
+	for padCount == 0 || b != ZMODEM_ZDLE {
+		ok, err := l.receive(&b, time.Until(deadline))
+		if err != nil || !ok {
+			return false, err
+		}
+		if b == ZMODEM_ZPAD {
+			padCount = padCount + 1
+		} else if b != ZMODEM_ZDLE {
+			padCount = 0
+		}
+	}
+	var format byte
+	ok, err := l.receive(&format, time.Until(deadline))
+	if err != nil || !ok {
+		return false, err
+	}
+	var content []byte
+	switch format {
+	case ZMODEM_ZHEX:
+		var digits []byte = make([]byte, 14)
+		for i := range digits {
+			ok, err = l.receive(&digits[i], time.Until(deadline))
+			if err != nil || !ok {
+				return false, err
+			}
+		}
+		content, err = hex.DecodeString(string(digits))
+		if err != nil || crc16Xmodem(content) != 0 {
+			return false, nil
+		}
+	case ZMODEM_ZBIN, ZMODEM_ZBIN32:
+		var length int = 7
+		if format == ZMODEM_ZBIN32 {
+			length = 9
+		}
+		content = make([]byte, length)
+		for i := range content {
+			ok, err = l.receiveZdleEscaped(&content[i], deadline)
+			if err != nil || !ok {
+				return false, err
+			}
+		}
+		if format == ZMODEM_ZBIN && crc16Xmodem(content) != 0 {
+			return false, nil
+		}
+		if format == ZMODEM_ZBIN32 && crc32.ChecksumIEEE(content[:5]) != binary.LittleEndian.Uint32(content[5:]) {
+			return false, nil
+		}
+	default:
+		return false, nil
+	}
+	header.frameType = content[0]
+	copy(header.data[:], content[1:5])
+	return true, nil
+}
+
+// exchangeZmodemHeader sends the header given by send, repeating it until the receiver answers with a
+// header of one of the frame types in expected, which is stored into reply. Other headers, garbled
+// ones and silence make it send again, up to YMODEM_MAX_RETRIES times. It returns an error when the
+// receiver aborts, or never answers as expected.
+func (l *YmodemLink) exchangeZmodemHeader(reply *zmodemHeader, send func() error, expected ...byte) error {
+	for tries := 0; tries < YMODEM_MAX_RETRIES; tries = tries + 1 {
+		var err error = send()
+		if err != nil {
+			return err
+		}
+		ok, err := l.receiveZmodemHeader(reply, 10*time.Second)
+		if err != nil {
+			return err
+		}
+		if ok && (reply.frameType == ZMODEM_ZABORT || reply.frameType == ZMODEM_ZFERR) {
+			return failure.CodedErrorf(l.errorKind(), "ZMODEM receiver aborted the transfer")
+		}
+		for _, frameType := range expected {
+			if ok && reply.frameType == frameType {
+				return nil
+			}
+		}
+	}
+	return failure.CodedErrorf(l.errorKind(), "ZMODEM receiver did not answer after %d tries", YMODEM_MAX_RETRIES)
+}
+
+// sendZmodemFile sends file to a ZMODEM receiver over link, which has answered ZRINIT with a buffer
+// of bufferSize bytes (0 for no limit): a ZFILE frame holding its name, length and modification time,
+// then a ZDATA frame for each piece of up to ZMODEM_SUBPACKET_SIZE bytes, starting at the position the
+// receiver asks for with ZRPOS and going back to where it asks again. The receiver acknowledges each
+// piece before the next is sent. ZEOF ends the file, and the receiver may skip it with ZSKIP.
+func sendZmodemFile(link *YmodemLink, file YmodemFile, bufferSize int) error {
+	var pieceSize int = ZMODEM_SUBPACKET_SIZE
+	if bufferSize > 0 && bufferSize < pieceSize {
+		pieceSize = bufferSize
+	}
+	var fileInfo []byte = []byte(fmt.Sprintf("%s\x00%d %o", file.Name, len(file.Data), file.ModTime.Unix()))
+	fileInfo = append(fileInfo, 0x00)
+	var reply zmodemHeader
+	var err error = link.exchangeZmodemHeader(&reply, func() error {
+		var header zmodemHeader = zmodemHeader{frameType: ZMODEM_ZFILE}
+		header.data[3] = ZMODEM_ZCBIN
+		return link.sendZmodemFrame(header, fileInfo)
+	}, ZMODEM_ZRPOS, ZMODEM_ZSKIP)
+	if err != nil {
+		return err
+	}
+	if reply.frameType == ZMODEM_ZSKIP {
+		fmt.Fprintf(os.Stderr, "skipped %s, which the ZMODEM receiver refused\n", file.Name)
+		return nil
+	}
+	var position int = reply.position()
+	for {
+		if position > len(file.Data) {
+			return failure.CodedErrorf(link.errorKind(), "ZMODEM receiver asked for position %d, past the %d bytes of the file", position, len(file.Data))
+		}
+		if position == len(file.Data) {
+			err = link.exchangeZmodemHeader(&reply, func() error {
+				return link.sendZmodemHexHeader(zmodemPositionHeader(ZMODEM_ZEOF, len(file.Data)))
+			}, ZMODEM_ZRINIT, ZMODEM_ZRPOS)
+			if err != nil {
+				return err
+			}
+			if reply.frameType == ZMODEM_ZRINIT {
+				return nil
+			}
+			position = reply.position()
+			continue
+		}
+		var piece []byte = file.Data[position:]
+		if len(piece) > pieceSize {
+			piece = piece[:pieceSize]
+		}
+		err = link.exchangeZmodemHeader(&reply, func() error {
+			return link.sendZmodemFrame(zmodemPositionHeader(ZMODEM_ZDATA, position), piece)
+		}, ZMODEM_ZACK, ZMODEM_ZRPOS, ZMODEM_ZSKIP)
+		if err != nil {
+			return err
+		}
+		switch reply.frameType {
+		case ZMODEM_ZACK:
+			position = position + len(piece)
+		case ZMODEM_ZRPOS:
+			position = reply.position()
+		case ZMODEM_ZSKIP:
+			fmt.Fprintf(os.Stderr, "skipped %s at byte %d, as the ZMODEM receiver asked\n", file.Name, position)
+			return nil
+		}
+	}
+}
+
+// SendZmodemBatch sends files to a ZMODEM receiver over link. It starts the receiver with "rz" and a
+// ZRQINIT header, as terminal programs starting their download on them expect, sends each file with
+// sendZmodemFile, and ends the session with ZFIN and "OO".
+func SendZmodemBatch(link *YmodemLink, files []YmodemFile) error {
+	_, err := link.Output.Write([]byte("rz\r"))
+	if err != nil {
+		return failure.CodedErrorf(link.errorKind(), "starting the ZMODEM receiver: %w", err)
+	}
+	var reply zmodemHeader
+	err = link.exchangeZmodemHeader(&reply, func() error {
+		return link.sendZmodemHexHeader(zmodemHeader{frameType: ZMODEM_ZRQINIT})
+	}, ZMODEM_ZRINIT)
+	if err != nil {
+		return err
+	}
+	var bufferSize int = int(reply.data[0]) | int(reply.data[1])<<8
+	for _, file := range files {
+		err = sendZmodemFile(link, file, bufferSize)
+		if err != nil {
+			return fmt.Errorf("%s: %w", file.Name, err)
+		}
+		fmt.Fprintf(os.Stderr, "sent %s (%d bytes) by ZMODEM\n", file.Name, len(file.Data))
+	}
+	err = link.exchangeZmodemHeader(&reply, func() error {
+		return link.sendZmodemHexHeader(zmodemHeader{frameType: ZMODEM_ZFIN})
+	}, ZMODEM_ZFIN)
+	if err != nil {
+		return err
+	}
+	_, err = link.Output.Write([]byte("OO"))
+	if err != nil {
+		return failure.CodedErrorf(link.errorKind(), "ending the ZMODEM session: %w", err)
+	}
+	return nil
+}
+
+// ZMODEM section end
+
+// ADTPro section begin
+
+// ADTPro client commands, in apple ][ text (with the high bit set).
+const ADTPRO_COMMAND_DIRECTORY = 0xC4        // "D"
+const ADTPRO_COMMAND_CHANGE_DIRECTORY = 0xC3 // "C"
+const ADTPRO_COMMAND_SIZE = 0xDA             // "Z"
+const ADTPRO_COMMAND_GET = 0xC7              // "G", the client receiving an image
+const ADTPRO_COMMAND_PUT = 0xD0              // "P", the client sending a disk
+const ADTPRO_COMMAND_BATCH = 0xC2            // "B", the client sending disks named by the host
+
+// ADTPro return codes, answering the commands naming a file or directory.
+const ADTPRO_REPLY_OK = 0x00
+const ADTPRO_REPLY_NOT_FOUND = 0x02
+const ADTPRO_REPLY_EXISTS = 0x04
+const ADTPRO_REPLY_UNREADABLE = 0x06
+const ADTPRO_REPLY_CANNOT_WRITE = 0x08
+
+// ADTPRO_HALF_BLOCK_SIZE is the byte count of the half blocks the disks are sent in, each run-length
+// encoded and followed by its CRC-16.
+const ADTPRO_HALF_BLOCK_SIZE = 0x0100
+
+// ADTPRO_DIRECTORY_PAGE_LINES is the count of file names sent in a page of the directory, fitting the
+// 24 line screen of the client with its menu.
+const ADTPRO_DIRECTORY_PAGE_LINES = 18
+
+// ADTPRO_TIMEOUT is how long the host waits for each character of the client within a command, and
+// ADTPRO_MAX_RETRIES the count of times a half block is sent before giving up on the client.
+const ADTPRO_TIMEOUT = 10 * time.Second
+const ADTPRO_MAX_RETRIES = 10
+
+// AdtproHost is the host side of the ADTPro client on the serial line: characters from the client
+// arrive on input, characters for it are written to output, and directory is the host directory the
+// client sees, changed by its change directory command. The image files are read with ReadImage, which
+// gives them in ProDOS block order, and the disks received written with WriteImage.
+type AdtproHost struct {
+	Input      chan byte
+	Output     io.Writer
+	Directory  string
+	ReadImage  func(diskImage *[]byte, imageFilepath string) error
+	WriteImage func(diskImage []byte, imageFilepath string) error
+}
+
+// receive waits up to ADTPRO_TIMEOUT for a character from the client, storing it into b. Unlike the
+// YMODEM receive, every character is data, CAN included.
+func (h *AdtproHost) Receive(b *byte) error {
+	select {
+	case received, ok := <-h.Input:
+		if !ok {
+			return ClosedInputError(failure.KIND_ADTPRO, "ADTPro client closed the connection")
+		}
+		*b = received
+		return nil
+	case <-time.After(ADTPRO_TIMEOUT):
+		return failure.CodedErrorf(failure.KIND_ADTPRO, "ADTPro client sent nothing for %s", ADTPRO_TIMEOUT)
+	}
+}
+
+// receiveName stores into name the file name the client sends after a command, in apple ][ text
+// ended by a zero.
+func (h *AdtproHost) receiveName(name *string) error {
+	var sb strings.Builder
+	for {
+		var b byte
+		var err error = h.Receive(&b)
+		if err != nil {
+			return err
+		}
+		if b == 0x00 {
+			*name = sb.String()
+			return nil
+		}
+		sb.WriteByte(b & 0x7F)
+	}
+}
+
+// receiveBlockCount stores into blockCount the count of blocks the client sends, low byte first.
+func (h *AdtproHost) receiveBlockCount(blockCount *int) error {
+	var low, high byte
+	var err error = h.Receive(&low)
+	if err == nil {
+		err = h.Receive(&high)
+	}
+	*blockCount = int(low) | int(high)<<8
+	return err
+}
+
+// hostFilepath returns the path on the host of the file name the client gave.
+func (h *AdtproHost) hostFilepath(name string) string {
+	if filepath.IsAbs(name) {
+		return name
+	}
+	return filepath.Join(h.Directory, name)
+}
+
+// EncodeAdtproHalfBlock returns data, a half block, run-length encoded as the client takes it: each
+// byte is sent EORed with the byte before it (0 before the first), except that a run of bytes equal
+// to the byte before them is sent as a 0 followed by the length of the run (0 for 256).
+func EncodeAdtproHalfBlock(data []byte) []byte {
+	var encoded []byte
+	var previous byte = 0
+	for i := 0; i < len(data); {
+		var runLength int = 0
+		for i+runLength < len(data) && data[i+runLength] == previous && runLength < 0x0100 {
+			runLength = runLength + 1
+		}
+		if runLength > 0 {
+			encoded = append(encoded, 0x00, byte(runLength))
+			i = i + runLength
+			continue
+		}
+		encoded = append(encoded, data[i]^previous)
+		previous = data[i]
+		i = i + 1
+	}
+	return encoded
+}
+
+// ReceiveHalfBlock fills data with a half block the client sends run-length encoded as by
+// EncodeAdtproHalfBlock, and stores into ok whether the CRC-16 following it, low byte first, matches.
+func (h *AdtproHost) ReceiveHalfBlock(data *[ADTPRO_HALF_BLOCK_SIZE]byte, ok *bool) error {
+	var previous byte = 0
+	for i := 0; i < ADTPRO_HALF_BLOCK_SIZE; {
+		var b byte
+		var err error = h.Receive(&b)
+		if err != nil {
+			return err
+		}
+		if b != 0x00 {
+			previous = previous ^ b
+			data[i] = previous
+			i = i + 1
+			continue
+		}
+		err = h.Receive(&b)
+		if err != nil {
+			return err
+		}
+		var runLength int = int(b)
+		if runLength == 0 {
+			runLength = 0x0100
+		}
+		for ; runLength > 0 && i < ADTPRO_HALF_BLOCK_SIZE; runLength = runLength - 1 {
+			data[i] = previous
+			i = i + 1
+		}
+	}
+	var low, high byte
+	var err error = h.Receive(&low)
+	if err == nil {
+		err = h.Receive(&high)
+	}
+	*ok = uint16(low)|uint16(high)<<8 == crc16Xmodem(data[:])
+	return err
+}
+
+// SendHalfBlock sends data, a half block, run-length encoded with its CRC-16, until the client
+// acknowledges it.
+func (h *AdtproHost) SendHalfBlock(data []byte) error {
+	var crc uint16 = crc16Xmodem(data)
+	var packet []byte = append(EncodeAdtproHalfBlock(data), byte(crc), byte(crc>>8))
+	for tries := 0; tries < ADTPRO_MAX_RETRIES; tries = tries + 1 {
+		_, err := h.Output.Write(packet)
+		if err != nil {
+			return err
+		}
+		var b byte
+		err = h.Receive(&b)
+		if err != nil {
+			return err
+		}
+		if b == YMODEM_ACK {
+			return nil
+		}
+	}
+	return failure.CodedErrorf(failure.KIND_ADTPRO, "ADTPro client refused a half block %d times", ADTPRO_MAX_RETRIES)
+}
+
+// sendDirectory sends the names of the files of the host directory, with their sizes in blocks, a
+// page at a time. Each page is a line per file in apple ][ text, ended by a zero, and followed by 1
+// when another page follows, which the client asks for with ACK, or by 0.
+func (h *AdtproHost) sendDirectory() error {
+	// an unreadable directory is listed empty
+	var fileInfos []os.FileInfo
+	fileInfos, _ = ioutil.ReadDir(h.Directory)
+	var err error
+	var lines []string = []string{fmt.Sprintf("DIRECTORY %s", h.Directory)}
+	for _, fileInfo := range fileInfos {
+		if fileInfo.IsDir() {
+			lines = append(lines, fileInfo.Name()+"/")
+		} else {
+			lines = append(lines, fmt.Sprintf("%-30s %5d", fileInfo.Name(), (fileInfo.Size()+diskimage.PRODOS_BLOCK_SIZE-1)/diskimage.PRODOS_BLOCK_SIZE))
+		}
+	}
+	for len(lines) > 0 {
+		var pageLineCount int = ADTPRO_DIRECTORY_PAGE_LINES
+		if pageLineCount > len(lines) {
+			pageLineCount = len(lines)
+		}
+		var page []byte
+		for _, line := range lines[:pageLineCount] {
+			for _, c := range []byte(line) {
+				page = append(page, c|0x80)
+			}
+			page = append(page, 0x8D)
+		}
+		lines = lines[pageLineCount:]
+		var more byte = 0x00
+		if len(lines) > 0 {
+			more = 0x01
+		}
+		_, err = h.Output.Write(append(page, 0x00, more))
+		if err != nil || more == 0x00 {
+			return err
+		}
+		var b byte
+		err = h.Receive(&b)
+		if err != nil || b != YMODEM_ACK {
+			return err
+		}
+	}
+	return nil
+}
+
+// changeDirectory makes the directory the client names the host directory, answering with a return
+// code.
+func (h *AdtproHost) changeDirectory() error {
+	var name string
+	var err error = h.receiveName(&name)
+	if err != nil {
+		return err
+	}
+	var newDirectory string = filepath.Clean(h.hostFilepath(name))
+	fileInfo, err := os.Stat(newDirectory)
+	if err != nil || !fileInfo.IsDir() {
+		_, err = h.Output.Write([]byte{ADTPRO_REPLY_NOT_FOUND})
+		return err
+	}
+	h.Directory = newDirectory
+	fmt.Fprintf(os.Stderr, "ADTPro: directory %s\n", h.Directory)
+	_, err = h.Output.Write([]byte{ADTPRO_REPLY_OK})
+	return err
+}
+
+// readImageFile reads the image file the client names into diskImage, in ProDOS block order as
+// ReadImage gives it, storing into replyCode the return code answering the client.
+func (h *AdtproHost) readImageFile(diskImage *[]byte, replyCode *byte, imageFilepath string) {
+	*replyCode = ADTPRO_REPLY_OK
+	_, err := os.Stat(imageFilepath)
+	if err != nil {
+		*replyCode = ADTPRO_REPLY_NOT_FOUND
+		return
+	}
+	err = h.ReadImage(diskImage, imageFilepath)
+	if err == nil && (len(*diskImage)%diskimage.PRODOS_BLOCK_SIZE != 0 || len(*diskImage)/diskimage.PRODOS_BLOCK_SIZE > 0xFFFF) {
+		err = failure.CodedErrorf(failure.KIND_ADTPRO, "%s does not hold up to 65535 whole blocks", imageFilepath)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ADTPro: %s\n", err)
+		*replyCode = ADTPRO_REPLY_UNREADABLE
+	}
+}
+
+// sendSize answers the size command with the count of blocks of the image file the client names, low
+// byte first, and a return code.
+func (h *AdtproHost) sendSize() error {
+	var name string
+	var err error = h.receiveName(&name)
+	if err != nil {
+		return err
+	}
+	var diskImage []byte
+	var replyCode byte
+	h.readImageFile(&diskImage, &replyCode, h.hostFilepath(name))
+	var blockCount int = len(diskImage) / diskimage.PRODOS_BLOCK_SIZE
+	_, err = h.Output.Write([]byte{byte(blockCount), byte(blockCount >> 8), replyCode})
+	return err
+}
+
+// sendImage answers the get command with a return code, and then sends the blocks of the image file
+// the client names, in ProDOS block order, each as two half blocks, so that the client writes them to
+// its disk.
+func (h *AdtproHost) sendImage() error {
+	var name string
+	var err error = h.receiveName(&name)
+	if err != nil {
+		return err
+	}
+	var diskImage []byte
+	var replyCode byte
+	h.readImageFile(&diskImage, &replyCode, h.hostFilepath(name))
+	_, err = h.Output.Write([]byte{replyCode})
+	if err != nil || replyCode != ADTPRO_REPLY_OK {
+		return err
+	}
+	var blockCount int = len(diskImage) / diskimage.PRODOS_BLOCK_SIZE
+	for blockNum := 0; blockNum < blockCount; blockNum = blockNum + 1 {
+		for half := 0; half < 2; half = half + 1 {
+			var start int = blockNum*diskimage.PRODOS_BLOCK_SIZE + half*ADTPRO_HALF_BLOCK_SIZE
+			err = h.SendHalfBlock(diskImage[start : start+ADTPRO_HALF_BLOCK_SIZE])
+			if err != nil {
+				return fmt.Errorf("sending block %d of %s: %w", blockNum, name, err)
+			}
+		}
+	}
+	fmt.Fprintf(os.Stderr, "ADTPro: sent %d blocks of file %s\n", blockCount, h.hostFilepath(name))
+	return nil
+}
+
+// receiveImage answers the put command, or with batch the batch command, with a return code, and then
+// receives the blocks of the client disk, each as two half blocks, answering each with ACK, or NAK to
+// have it sent again. The disk is written to the image file the client names, or with batch to the
+// first file named after it with a number which does not exist.
+func (h *AdtproHost) receiveImage(batch bool) error {
+	var name string
+	var err error = h.receiveName(&name)
+	if err != nil {
+		return err
+	}
+	var blockCount int
+	err = h.receiveBlockCount(&blockCount)
+	if err != nil {
+		return err
+	}
+	var imageFilepath string = h.hostFilepath(name)
+	if batch {
+		for imageNum := 1; ; imageNum = imageNum + 1 {
+			imageFilepath = h.hostFilepath(fmt.Sprintf("%s%04d.po", name, imageNum))
+			_, err = os.Stat(imageFilepath)
+			if os.IsNotExist(err) {
+				break
+			}
+		}
+	}
+	_, err = os.Stat(imageFilepath)
+	var replyCode byte = ADTPRO_REPLY_OK
+	if err == nil {
+		replyCode = ADTPRO_REPLY_EXISTS
+	} else if blockCount == 0 {
+		replyCode = ADTPRO_REPLY_CANNOT_WRITE
+	}
+	_, err = h.Output.Write([]byte{replyCode})
+	if err != nil || replyCode != ADTPRO_REPLY_OK {
+		return err
+	}
+	var diskImage []byte = make([]byte, 0, blockCount*diskimage.PRODOS_BLOCK_SIZE)
+	for len(diskImage) < blockCount*diskimage.PRODOS_BLOCK_SIZE {
+		var halfBlock [ADTPRO_HALF_BLOCK_SIZE]byte
+		var ok bool
+		var tries int = 0
+		for !ok {
+			if tries == ADTPRO_MAX_RETRIES {
+				return failure.CodedErrorf(failure.KIND_ADTPRO, "ADTPro client sent block %d of %s damaged %d times", len(diskImage)/diskimage.PRODOS_BLOCK_SIZE, imageFilepath, ADTPRO_MAX_RETRIES)
+			}
+			err = h.ReceiveHalfBlock(&halfBlock, &ok)
+			if err != nil {
+				return fmt.Errorf("receiving block %d of %s: %w", len(diskImage)/diskimage.PRODOS_BLOCK_SIZE, imageFilepath, err)
+			}
+			var answer byte = YMODEM_NAK
+			if ok {
+				answer = YMODEM_ACK
+			}
+			_, err = h.Output.Write([]byte{answer})
+			if err != nil {
+				return err
+			}
+			tries = tries + 1
+		}
+		diskImage = append(diskImage, halfBlock[:]...)
+	}
+	return h.WriteImage(diskImage, imageFilepath)
+}
+
+// ServeAdtproClient carries out the commands of the ADTPro client until its connection closes,
+// reporting the commands which fail and going on with the next one. Characters other than commands,
+// such as those the client sends while starting, are ignored.
+func ServeAdtproClient(h *AdtproHost) error {
+	for {
+		var command byte
+		var ok bool
+		command, ok = <-h.Input
+		if !ok {
+			if serialInputFailure != nil {
+				return ClosedInputError(failure.KIND_ADTPRO, "ADTPro client closed the connection")
+			}
+			return nil
+		}
+		var err error
+		switch command {
+		case ADTPRO_COMMAND_DIRECTORY:
+			err = h.sendDirectory()
+		case ADTPRO_COMMAND_CHANGE_DIRECTORY:
+			err = h.changeDirectory()
+		case ADTPRO_COMMAND_SIZE:
+			err = h.sendSize()
+		case ADTPRO_COMMAND_GET:
+			err = h.sendImage()
+		case ADTPRO_COMMAND_PUT:
+			err = h.receiveImage(false)
+		case ADTPRO_COMMAND_BATCH:
+			err = h.receiveImage(true)
+		default:
+			continue
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "ADTPro: %s\n", err)
+		}
+	}
+}
+
+// ADTPro section end
+
+// Apple /// section begin
+
+// SOS_CLIENT_ADDRESS is the address the Apple /// client is loaded at by SOS, as its interpreter: its
+// code and data take the first two pages, and the buffer of the block being written the next two,
+// ending at 0xB7FF below the SOS kernel.
+const SOS_CLIENT_ADDRESS = 0xB400
+const SOS_CLIENT_SIZE = 0x0400
+
+// SOS_INTERPRETER_FILE_TYPE is the ProDOS file type of SOS.INTERP, the interpreter SOS loads at boot.
+const SOS_INTERPRETER_FILE_TYPE = 0x0C
+
+// SOS_CLIENT_PROMPT is shown on the console of the Apple /// by the client, which then waits for a key:
+// the client disk can then be taken out of the built-in drive.
+const SOS_CLIENT_PROMPT = "APPLE /// CLIENT: PUT THE DISK TO WRITE IN THE BUILT-IN DRIVE, THEN PRESS A KEY"
+
+// Commands of the host to the Apple /// client, and its answers. A block is sent as the write command,
+// the block number (low byte first), the 512 bytes of the block and their 16-bit sum (low byte first);
+// the client writes it to the built-in drive and answers ACK, NAK when the sum differs, or CAN followed
+// by the SOS error code when the block could not be written. The client answers the hello command with
+// ready, and the quit command with ACK before ending.
+const APPLE3_COMMAND_HELLO = 'H'
+const APPLE3_COMMAND_WRITE = 'W'
+const APPLE3_COMMAND_QUIT = 'Q'
+const APPLE3_READY = 'R'
+const APPLE3_ACK = 0x06
+const APPLE3_NAK = 0x15
+const APPLE3_CAN = 0x18
+
+// SOS_ERROR_WRITE_PROTECTED is the SOS error code of a write to a write protected disk.
+const SOS_ERROR_WRITE_PROTECTED = 0x2B
+
+// APPLE3_TIMEOUT is how long the host waits for the answer of the client to a block, APPLE3_MAX_RETRIES
+// the count of times a block is sent before giving up on the client, and APPLE3_HELLO_INTERVAL and
+// APPLE3_READY_TIMEOUT how often and how long the host says hello while waiting for the client to be
+// booted.
+const APPLE3_TIMEOUT = 10 * time.Second
+const APPLE3_MAX_RETRIES = 10
+const APPLE3_HELLO_INTERVAL = 2 * time.Second
+const APPLE3_READY_TIMEOUT = 10 * time.Minute
+
+// GenerateSosClientInterpreter fills interpreter with the SOS.INTERP file of the Apple /// client: the
+// interpreter header ("SOS NTRP", the length of the optional header, the load address and the code
+// length) followed by a machine language program which opens the .RS232 serial port and writes the
+// blocks the host sends to the built-in drive .D1 with the D_WRITE call of the SOS device driver.
+func GenerateSosClientInterpreter(interpreter *[]byte) {
+	var codePage byte = byte(SOS_CLIENT_ADDRESS >> 8)
+	var code []byte = []byte{
+		'\x00',           // SOS call: open the console
+		'\xC8',           // OPEN
+		'\xE6', codePage, // parameter list
+		'\xA8',        // error code
+		'\xD0', '\x31', // terminate on error
+		'\xAD', '\xE9', codePage, // console reference number
+		'\x8D', '\xEE', codePage,
+		'\x8D', '\xF4', codePage,
+		'\x00', // show the prompt
+		'\xCB', // WRITE
+		'\xED', codePage,
+		'\x00', // wait for a key
+		'\xCA', // READ
+		'\xF3', codePage,
+		'\x00', // open the serial port
+		'\xC8', // OPEN
+		'\xFB', codePage,
+		'\xA8',
+		'\xD0', '\x19',
+		'\xAD', '\xFE', codePage, // serial port reference number
+		'\x8D', '\x03', codePage + 1,
+		'\x8D', '\x0B', codePage + 1,
+		'\x00', // find the built-in drive
+		'\x84', // GET_DEV_NUM
+		'\x10', codePage + 1,
+		'\xA8',
+		'\xD0', '\x09',
+		'\xAD', '\x13', codePage + 1, // device number
+		'\x8D', '\x15', codePage + 1,
+		'\x4C', '\x3C', codePage,
+		'\x00', // end the interpreter
+		'\x65', // TERMINATE
+		'\x1C', codePage + 1,
+		'\x20', '\xCA', codePage, // command
+		'\xC9', '\x51', // Q: quit
+		'\xF0', '\x72',
+		'\xC9', '\x48', // H: hello
+		'\xF0', '\x4F',
+		'\xC9', '\x57', // W: write a block
+		'\xD0', '\xF1', // ignore anything else
+		'\x20', '\xCA', codePage, // block number
+		'\x8D', '\x1A', codePage + 1,
+		'\x20', '\xCA', codePage,
+		'\x8D', '\x1B', codePage + 1,
+		'\xA2', '\x00', // clear the sum
+		'\x8E', '\x20', codePage + 1,
+		'\x8E', '\x21', codePage + 1,
+		'\x20', '\xCA', codePage, // receive the first page of the block
+		'\x9D', '\x00', codePage + 2,
+		'\x20', '\xBD', codePage,
+		'\xE8',
+		'\xD0', '\xF4',
+		'\x20', '\xCA', codePage, // receive the second page of the block
+		'\x9D', '\x00', codePage + 3,
+		'\x20', '\xBD', codePage,
+		'\xE8',
+		'\xD0', '\xF4',
+		'\x20', '\xCA', codePage, // sum low byte
+		'\xCD', '\x20', codePage + 1,
+		'\xD0', '\x1F',
+		'\x20', '\xCA', codePage, // sum high byte
+		'\xCD', '\x21', codePage + 1,
+		'\xD0', '\x1A',
+		'\x00', // write the block
+		'\x83', // D_WRITE
+		'\x14', codePage + 1,
+		'\xA8',
+		'\xD0', '\x1B',
+		'\xA9', '\x06', // send ACK
+		'\x20', '\xD8', codePage,
+		'\x4C', '\x3C', codePage,
+		'\xA9', '\x52', // send R: ready
+		'\x20', '\xD8', codePage,
+		'\x4C', '\x3C', codePage,
+		'\x20', '\xCA', codePage, // skip the sum high byte
+		'\xA9', '\x15', // send NAK
+		'\x20', '\xD8', codePage,
+		'\x4C', '\x3C', codePage,
+		'\xA9', '\x18', // send CAN and the SOS error code
+		'\x20', '\xD8', codePage,
+		'\x98',
+		'\x20', '\xD8', codePage,
+		'\x4C', '\x3C', codePage,
+		'\xA9', '\x06', // send ACK
+		'\x20', '\xD8', codePage,
+		'\x4C', '\x38', codePage,
+		'\x18', // add the byte to the sum
+		'\x6D', '\x20', codePage + 1,
+		'\x8D', '\x20', codePage + 1,
+		'\x90', '\x03',
+		'\xEE', '\x21', codePage + 1,
+		'\x60',
+		'\x8E', '\x1F', codePage + 1, // read a byte from the serial port, keeping X
+		'\x00',
+		'\xCA', // READ
+		'\x02', codePage + 1,
+		'\xAE', '\x1F', codePage + 1,
+		'\xAD', '\x1D', codePage + 1,
+		'\x60',
+		'\x8E', '\x1F', codePage + 1, // write a byte to the serial port, keeping X
+		'\x8D', '\x1E', codePage + 1,
+		'\x00',
+		'\xCB', // WRITE
+		'\x0A', codePage + 1,
+		'\xAE', '\x1F', codePage + 1,
+		'\x60',
+		'\x04', // OPEN parameters: count, path, reference number, options, length
+		'\x22', codePage + 1,
+		'\x00', '\x00', '\x00', '\x00',
+		'\x03', // WRITE parameters: count, reference number, buffer, byte count
+		'\x00',
+		'\x36', codePage + 1,
+		byte(len(SOS_CLIENT_PROMPT) + 1), '\x00', // the prompt and a return
+		'\x04', // READ parameters: count, reference number, buffer, byte count, bytes read
+		'\x00',
+		'\x1D', codePage + 1,
+		'\x01', '\x00', '\x00', '\x00',
+		'\x04', // OPEN parameters
+		'\x2B', codePage + 1,
+		'\x00', '\x00', '\x00', '\x00',
+		'\x04', // READ parameters
+		'\x00',
+		'\x1D', codePage + 1,
+		'\x01', '\x00', '\x00', '\x00',
+		'\x03', // WRITE parameters
+		'\x00',
+		'\x1E', codePage + 1,
+		'\x01', '\x00',
+		'\x02', // GET_DEV_NUM parameters: count, device name, device number
+		'\x32', codePage + 1,
+		'\x00',
+		'\x04', // D_WRITE parameters: count, device number, buffer, byte count, block number
+		'\x00',
+		'\x00', codePage + 2,
+		'\x00', '\x02',
+		'\x00', '\x00',
+		'\x00', // TERMINATE parameters: count
+		'\x00', // byte read, byte to write, saved X, sum
+		'\x00',
+		'\x00',
+		'\x00',
+		'\x00',
+		'\x08', '.', 'C', 'O', 'N', 'S', 'O', 'L', 'E', // device names
+		'\x06', '.', 'R', 'S', '2', '3', '2',
+		'\x03', '.', 'D', '1',
+	}
+	code = append(code, SOS_CLIENT_PROMPT...)
+	code = append(code, '\x0D')
+	code = append(code, make([]byte, SOS_CLIENT_SIZE-len(code))...)
+	*interpreter = append([]byte("SOS NTRP"),
+		'\x00', '\x00', // optional header length
+		byte(SOS_CLIENT_ADDRESS&0xFF), byte(SOS_CLIENT_ADDRESS>>8), // load address
+		byte(SOS_CLIENT_SIZE&0xFF), byte(SOS_CLIENT_SIZE>>8)) // code length
+	*interpreter = append(*interpreter, code...)
+}
+
+// MakeSosClientImage turns the ProDOS ordered sosImage, a copy of a bootable Apple /// disk, into the
+// boot disk of the Apple /// client: its boot blocks, SOS.KERNEL and SOS.DRIVER (which must hold the
+// .RS232 driver) are kept, and the client is added as its SOS.INTERP. An interpreter already on the
+// disk is kept as SOS.INTERP.OLD.
+func MakeSosClientImage(sosImage []byte) error {
+	var volumeName string
+	var totalBlocks int
+	if !filesystem.ReadProdosVolumeHeader(&volumeName, &totalBlocks, sosImage, 0) {
+		return failure.CodedErrorf(failure.KIND_NO_VOLUME_DIRECTORY, "SOS image does not hold a ProDOS volume directory")
+	}
+	var entry []byte
+	var err error
+	for _, fileName := range []string{"SOS.KERNEL", "SOS.DRIVER"} {
+		err = filesystem.FindProdosVolumeDirectoryEntry(&entry, sosImage, fileName)
+		if err != nil {
+			return err
+		}
+		if entry == nil {
+			return failure.CodedErrorf(failure.KIND_NO_SOS_IMAGE, "volume /%s holds no %s, so it does not boot an Apple ///", volumeName, fileName)
+		}
+	}
+	err = filesystem.FindProdosVolumeDirectoryEntry(&entry, sosImage, "SOS.INTERP")
+	if err != nil {
+		return err
+	}
+	if entry != nil {
+		var oldEntry []byte
+		err = filesystem.FindProdosVolumeDirectoryEntry(&oldEntry, sosImage, "SOS.INTERP.OLD")
+		if err != nil {
+			return err
+		}
+		if oldEntry != nil {
+			return failure.CodedErrorf(failure.KIND_FILE_EXISTS, "volume /%s holds both SOS.INTERP and SOS.INTERP.OLD, so its interpreter cannot be kept", volumeName)
+		}
+		var oldName string = "SOS.INTERP.OLD"
+		entry[0x00] = entry[0x00]&0xF0 | byte(len(oldName))
+		copy(entry[0x01:0x10], oldName)
+	}
+	var interpreter []byte
+	GenerateSosClientInterpreter(&interpreter)
+	return filesystem.AddProdosFile(sosImage, "SOS.INTERP", SOS_INTERPRETER_FILE_TYPE, 0x0000, interpreter, time.Now())
+}
+
+// receiveApple3Answer waits up to timeout for a character from the Apple /// client on input, storing
+// it into answer.
+func receiveApple3Answer(input chan byte, answer *byte, timeout time.Duration) error {
+	select {
+	case received, ok := <-input:
+		if !ok {
+			return ClosedInputError(failure.KIND_APPLE3, "Apple /// client closed the connection")
+		}
+		*answer = received
+		return nil
+	case <-time.After(timeout):
+		return failure.CodedErrorf(failure.KIND_APPLE3, "Apple /// client sent nothing for %s", timeout)
+	}
+}
+
+// waitForApple3Client says hello to the Apple /// client on link every APPLE3_HELLO_INTERVAL until it
+// answers ready, which it does once booted and given the disk to write, or while still running after
+// an earlier disk.
+func waitForApple3Client(link *YmodemLink) error {
+	var deadline <-chan time.Time = time.After(APPLE3_READY_TIMEOUT)
+	for {
+		_, err := link.Output.Write([]byte{APPLE3_COMMAND_HELLO})
+		if err != nil {
+			return err
+		}
+		var nextHello <-chan time.Time = time.After(APPLE3_HELLO_INTERVAL)
+		var waiting bool = true
+		for waiting {
+			select {
+			case received, ok := <-link.Input:
+				if !ok {
+					return ClosedInputError(failure.KIND_APPLE3, "Apple /// client closed the connection")
+				}
+				if received == APPLE3_READY {
+					return nil
+				}
+			case <-nextHello:
+				waiting = false
+			case <-deadline:
+				return failure.CodedErrorf(failure.KIND_APPLE3, "Apple /// client did not answer for %s", APPLE3_READY_TIMEOUT)
+			}
+		}
+	}
+}
+
+// sendBlockToApple3Client sends block blockNum of the ProDOS ordered diskImage to the Apple /// client
+// on link, and waits for it to be written, sending it again each time the client finds its sum wrong.
+// The ready answers to further hellos, which the client may still be sending, are skipped.
+func sendBlockToApple3Client(link *YmodemLink, diskImage []byte, blockNum int) error {
+	var block []byte = diskimage.ProdosBlockOfImage(diskImage, blockNum)
+	var sum int = 0
+	for _, b := range block {
+		sum = sum + int(b)
+	}
+	var packet []byte = []byte{APPLE3_COMMAND_WRITE, byte(blockNum), byte(blockNum >> 8)}
+	packet = append(packet, block...)
+	packet = append(packet, byte(sum), byte(sum>>8))
+	var err error
+	for tries := 0; tries < APPLE3_MAX_RETRIES; tries = tries + 1 {
+		_, err = link.Output.Write(packet)
+		if err != nil {
+			return err
+		}
+		var answer byte = APPLE3_READY
+		for answer == APPLE3_READY {
+			err = receiveApple3Answer(link.Input, &answer, APPLE3_TIMEOUT)
+			if err != nil {
+				return fmt.Errorf("block %d: %w", blockNum, err)
+			}
+		}
+		if answer == APPLE3_ACK {
+			return nil
+		}
+		if answer == APPLE3_CAN {
+			var sosError byte
+			err = receiveApple3Answer(link.Input, &sosError, APPLE3_TIMEOUT)
+			if err != nil {
+				return fmt.Errorf("block %d: %w", blockNum, err)
+			}
+			if sosError == SOS_ERROR_WRITE_PROTECTED {
+				return failure.CodedErrorf(failure.KIND_APPLE3_WRITE, "block %d could not be written, the disk in the built-in drive is write protected", blockNum)
+			}
+			return failure.CodedErrorf(failure.KIND_APPLE3_WRITE, "block %d could not be written to the built-in drive, SOS error $%02X", blockNum, sosError)
+		}
+	}
+	return failure.CodedErrorf(failure.KIND_APPLE3, "block %d was refused %d times by the Apple /// client", blockNum, APPLE3_MAX_RETRIES)
+}
+
+// WriteApple3Disk writes the blocks of the ProDOS ordered diskImage from block firstBlock on to the
+// built-in drive of the Apple /// through the client on link, reporting each track written, and then
+// ends the client.
+func WriteApple3Disk(link *YmodemLink, diskImage []byte, firstBlock int) error {
+	var err error = waitForApple3Client(link)
+	if err != nil {
+		return err
+	}
+	var blockCount int = len(diskImage) / diskimage.PRODOS_BLOCK_SIZE
+	for blockNum := firstBlock; blockNum < blockCount; blockNum = blockNum + 1 {
+		err = sendBlockToApple3Client(link, diskImage, blockNum)
+		if err != nil {
+			return err
+		}
+		if blockNum%8 == 7 || blockNum == blockCount-1 {
+			fmt.Fprintf(os.Stderr, "Apple ///: wrote blocks %d through %d\n", blockNum-blockNum%8, blockNum)
+		}
+	}
+	_, err = link.Output.Write([]byte{APPLE3_COMMAND_QUIT})
+	if err != nil {
+		return err
+	}
+	var answer byte = APPLE3_READY
+	for answer == APPLE3_READY {
+		err = receiveApple3Answer(link.Input, &answer, APPLE3_TIMEOUT)
+		if err != nil {
+			return err
+		}
+	}
+	if answer != APPLE3_ACK {
+		return failure.CodedErrorf(failure.KIND_APPLE3, "Apple /// client answered the quit command with %02X", answer)
+	}
+	return nil
+}
+
+// Apple /// section end
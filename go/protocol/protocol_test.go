@@ -0,0 +1,241 @@
+package protocol
+
+import "bytes"
+import "encoding/hex"
+import "io/ioutil"
+import "strings"
+import "testing"
+import "time"
+
+// checkXmodemBlock checks that the block at the start of sent is block blockNum holding data, framed
+// with SOH (128 bytes) or STX (1024 bytes), the block number and its complement, and a CRC-16 (or the
+// checksum, when checksum is set), and returns what follows it.
+func checkXmodemBlock(t *testing.T, sent []byte, blockNum int, data []byte, checksum bool) []byte {
+	var start byte = YMODEM_STX
+	if len(data) == 0x80 {
+		start = YMODEM_SOH
+	}
+	var check []byte
+	if checksum {
+		var sum byte = 0
+		for _, b := range data {
+			sum = sum + b
+		}
+		check = []byte{sum}
+	} else {
+		var crc uint16 = crc16Xmodem(data)
+		check = []byte{byte(crc >> 8), byte(crc)}
+	}
+	var block []byte = append(append([]byte{start, byte(blockNum), byte(^blockNum)}, data...), check...)
+	if len(sent) < len(block) || !bytes.Equal(sent[:len(block)], block) {
+		t.Fatalf("block %d is not framed as expected: % X", blockNum, sent[:3])
+	}
+	return sent[len(block):]
+}
+
+// TestYmodemBatchFraming checks the blocks sent for a file of 1100 bytes: the header block 0 holding
+// its name, length and modification time, a 1024 byte block, a 128 byte block padded with 0x1A, EOT
+// and the empty block 0 ending the batch.
+func TestYmodemBatchFraming(t *testing.T) {
+	var sent bytes.Buffer
+	var link *YmodemLink = &YmodemLink{Input: make(chan byte, 0x10), Output: &sent, Protocol: "YMODEM"}
+	for _, b := range []byte{YMODEM_CRC, YMODEM_ACK, YMODEM_CRC, YMODEM_ACK, YMODEM_ACK, YMODEM_ACK, YMODEM_CRC, YMODEM_ACK} {
+		link.Input <- b
+	}
+	var data []byte = bytes.Repeat([]byte("0123456789"), 110)
+	var err error = SendYmodemBatch(link, []YmodemFile{{Name: "GAME.PO", Data: data, ModTime: time.Unix(0o1234, 0)}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var header []byte = make([]byte, 0x80)
+	copy(header, "GAME.PO\x001100 1234")
+	var rest []byte = checkXmodemBlock(t, sent.Bytes(), 0, header, false)
+	rest = checkXmodemBlock(t, rest, 1, data[:0x0400], false)
+	rest = checkXmodemBlock(t, rest, 2, append(append([]byte{}, data[0x0400:]...), bytes.Repeat([]byte{0x1A}, 0x80-(len(data)-0x0400))...), false)
+	if len(rest) == 0 || rest[0] != YMODEM_EOT {
+		t.Fatalf("no EOT after the last block")
+	}
+	rest = checkXmodemBlock(t, rest[1:], 0, make([]byte, 0x80), false)
+	if len(rest) != 0 {
+		t.Errorf("%d bytes sent after the end of the batch", len(rest))
+	}
+}
+
+// TestXmodemFraming checks the CRC-16 against its check value, and the blocks sent for a file of 200
+// bytes: two 128 byte blocks numbered from 1, the second padded with 0x1A and sent again when the
+// receiver answers NAK, then EOT; with a CRC when the receiver asks with C, and with the arithmetic
+// checksum when it asks with NAK.
+func TestXmodemFraming(t *testing.T) {
+	if crc16Xmodem([]byte("123456789")) != 0x31C3 {
+		t.Errorf("the CRC-16 of 123456789 is %04X, not 31C3", crc16Xmodem([]byte("123456789")))
+	}
+	var data []byte = bytes.Repeat([]byte("0123456789"), 20)
+	var lastBlock []byte = append(append([]byte{}, data[0x80:]...), bytes.Repeat([]byte{0x1A}, 0x0100-len(data))...)
+	var tests = []struct {
+		name     string
+		answers  []byte
+		checksum bool
+		resent   bool
+	}{
+		{"XMODEM-CRC", []byte{YMODEM_CRC, YMODEM_ACK, YMODEM_NAK, YMODEM_ACK, YMODEM_ACK}, false, true},
+		{"XMODEM checksum", []byte{YMODEM_NAK, YMODEM_ACK, YMODEM_ACK, YMODEM_ACK}, true, false},
+	}
+	for _, test := range tests {
+		var sent bytes.Buffer
+		var link *YmodemLink = &YmodemLink{Input: make(chan byte, 0x10), Output: &sent, Protocol: "XMODEM"}
+		for _, b := range test.answers {
+			link.Input <- b
+		}
+		var err error = SendXmodemFile(link, YmodemFile{Name: "GAME.PO", Data: data})
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		var rest []byte = checkXmodemBlock(t, sent.Bytes(), 1, data[:0x80], test.checksum)
+		rest = checkXmodemBlock(t, rest, 2, lastBlock, test.checksum)
+		if test.resent {
+			rest = checkXmodemBlock(t, rest, 2, lastBlock, test.checksum)
+		}
+		if len(rest) != 1 || rest[0] != YMODEM_EOT {
+			t.Errorf("%s: % X sent after the last block instead of EOT", test.name, rest)
+		}
+	}
+}
+
+// TestYmodemCancel checks that a single CAN from the receiver is dropped, and that two in a row cancel
+// the transfer.
+func TestYmodemCancel(t *testing.T) {
+	var link *YmodemLink = &YmodemLink{Input: make(chan byte, 0x10), Output: ioutil.Discard, Protocol: "YMODEM"}
+	link.Input <- YMODEM_CAN
+	link.Input <- YMODEM_ACK
+	var b byte
+	ok, err := link.receive(&b, time.Second)
+	if err != nil || !ok || b != YMODEM_ACK {
+		t.Errorf("a single CAN before ACK gave %02X, %v, %v", b, ok, err)
+	}
+	link.Input <- YMODEM_CAN
+	link.Input <- YMODEM_CAN
+	_, err = link.receive(&b, time.Second)
+	if err == nil || !strings.Contains(err.Error(), "cancelled by the receiver") {
+		t.Errorf("two CANs gave %v", err)
+	}
+}
+
+// TestYmodemLongName checks that a file whose name does not fit the YMODEM header block is refused
+// before anything is sent.
+func TestYmodemLongName(t *testing.T) {
+	var sent bytes.Buffer
+	var link *YmodemLink = &YmodemLink{Input: make(chan byte, 0x10), Output: &sent, Protocol: "YMODEM"}
+	var err error = SendYmodemBatch(link, []YmodemFile{{Name: strings.Repeat("N", 0x80), Data: []byte("data")}})
+	if err == nil || !strings.Contains(err.Error(), "too long") || sent.Len() != 0 {
+		t.Errorf("a long name gave %v after sending %d bytes", err, sent.Len())
+	}
+}
+
+// zmodemTestFrame is a header sent by the ZMODEM sender, with the data of the subpacket following it
+// when it was sent in binary.
+type zmodemTestFrame struct {
+	header zmodemHeader
+	data   []byte
+}
+
+// decodeZmodemFrames returns the frames in what a ZMODEM sender sent, checking their CRCs.
+func decodeZmodemFrames(t *testing.T, sent []byte) []zmodemTestFrame {
+	var frames []zmodemTestFrame
+	var pos int = 0
+	// unescape returns the next character, taking back one escaped with ZDLE
+	var unescape = func() byte {
+		var b byte = sent[pos]
+		pos = pos + 1
+		if b != ZMODEM_ZDLE {
+			return b
+		}
+		pos = pos + 1
+		return sent[pos-1] ^ 0x40
+	}
+	for pos < len(sent) {
+		if sent[pos] != ZMODEM_ZPAD {
+			pos = pos + 1
+			continue
+		}
+		var content []byte
+		var frame zmodemTestFrame
+		if bytes.HasPrefix(sent[pos:], []byte{ZMODEM_ZPAD, ZMODEM_ZPAD, ZMODEM_ZDLE, ZMODEM_ZHEX}) {
+			var err error
+			content, err = hex.DecodeString(string(sent[pos+4 : pos+18]))
+			if err != nil {
+				t.Fatalf("hex header at %d: %v", pos, err)
+			}
+			pos = pos + 18
+		} else if bytes.HasPrefix(sent[pos:], []byte{ZMODEM_ZPAD, ZMODEM_ZDLE, ZMODEM_ZBIN}) {
+			pos = pos + 3
+			for len(content) < 7 {
+				content = append(content, unescape())
+			}
+			for !(sent[pos] == ZMODEM_ZDLE && sent[pos+1] == ZMODEM_ZCRCW) {
+				frame.data = append(frame.data, unescape())
+			}
+			pos = pos + 2
+			var crc []byte = []byte{unescape(), unescape()}
+			if crc16Xmodem(append(append(append([]byte{}, frame.data...), ZMODEM_ZCRCW), crc...)) != 0 {
+				t.Fatalf("wrong CRC of the subpacket ending at %d", pos)
+			}
+		} else {
+			t.Fatalf("unknown header at %d: % X", pos, sent[pos:pos+4])
+		}
+		if crc16Xmodem(content) != 0 {
+			t.Fatalf("wrong CRC of the header before %d", pos)
+		}
+		frame.header.frameType = content[0]
+		copy(frame.header.data[:], content[1:5])
+		frames = append(frames, frame)
+	}
+	return frames
+}
+
+// TestZmodemFraming checks the frames sent for a file of 1100 bytes holding every character: ZRQINIT,
+// ZFILE with its name, length and modification time, a ZDATA frame for the first 1024 bytes, sent
+// again when the receiver asks for that position with ZRPOS, one for the rest, ZEOF and ZFIN.
+func TestZmodemFraming(t *testing.T) {
+	var replies bytes.Buffer
+	var receiver *YmodemLink = &YmodemLink{Output: &replies, Protocol: "ZMODEM"}
+	for _, header := range []zmodemHeader{{frameType: ZMODEM_ZRINIT}, zmodemPositionHeader(ZMODEM_ZRPOS, 0), zmodemPositionHeader(ZMODEM_ZRPOS, 0), zmodemPositionHeader(ZMODEM_ZACK, 0x0400), zmodemPositionHeader(ZMODEM_ZACK, 1100), {frameType: ZMODEM_ZRINIT}, {frameType: ZMODEM_ZFIN}} {
+		var err error = receiver.sendZmodemHexHeader(header)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	var sent bytes.Buffer
+	var link *YmodemLink = &YmodemLink{Input: make(chan byte, replies.Len()), Output: &sent, Protocol: "ZMODEM"}
+	for _, b := range replies.Bytes() {
+		link.Input <- b
+	}
+	var data []byte = make([]byte, 1100)
+	for i := range data {
+		data[i] = byte(i * 7)
+	}
+	var err error = SendZmodemBatch(link, []YmodemFile{{Name: "GAME.PO", Data: data, ModTime: time.Unix(0o1234, 0)}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(sent.Bytes(), []byte("rz\r")) || !bytes.HasSuffix(sent.Bytes(), []byte("OO")) {
+		t.Errorf("the session does not start with rz and end with OO")
+	}
+	var expected []zmodemTestFrame = []zmodemTestFrame{
+		{zmodemHeader{frameType: ZMODEM_ZRQINIT}, nil},
+		{zmodemHeader{ZMODEM_ZFILE, [4]byte{0, 0, 0, ZMODEM_ZCBIN}}, []byte("GAME.PO\x001100 1234\x00")},
+		{zmodemPositionHeader(ZMODEM_ZDATA, 0), data[:0x0400]},
+		{zmodemPositionHeader(ZMODEM_ZDATA, 0), data[:0x0400]},
+		{zmodemPositionHeader(ZMODEM_ZDATA, 0x0400), data[0x0400:]},
+		{zmodemPositionHeader(ZMODEM_ZEOF, 1100), nil},
+		{zmodemHeader{frameType: ZMODEM_ZFIN}, nil},
+	}
+	var frames []zmodemTestFrame = decodeZmodemFrames(t, sent.Bytes())
+	if len(frames) != len(expected) {
+		t.Fatalf("%d frames sent instead of %d: %v", len(frames), len(expected), frames)
+	}
+	for i, frame := range frames {
+		if frame.header != expected[i].header || !bytes.Equal(frame.data, expected[i].data) {
+			t.Errorf("frame %d is %v with %q, expected %v with %q", i, frame.header, frame.data, expected[i].header, expected[i].data)
+		}
+	}
+}
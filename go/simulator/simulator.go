@@ -0,0 +1,380 @@
+/*
+apple2disk simulator package
+Copyright (C) 2024 github user bassjack1 <147515670+bassjack1@users.noreply.github.com>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+Communication with the author can be done via tagging @bassjack1 in github.com issues or by composing
+private messages to user bassjack1 on reddit.com : https://www.reddit.com/message/compose/
+*/
+
+// Package simulator simulates the Apple ][ system monitor receiving an install over serial,
+// reporting the time the transfer takes and the faults the line introduces.
+package simulator
+
+import "fmt"
+import "github.com/bassjack1/apple2disk/go/diskimage"
+import "github.com/bassjack1/apple2disk/go/emulator"
+import "github.com/bassjack1/apple2disk/go/failure"
+import "io/ioutil"
+import "math/rand"
+import "os"
+import "strings"
+import "time"
+
+// Monitor simulation section begin
+
+// MONITOR_INPUT_LINE_LIMIT is the count of characters the monitor input buffer (at 0x0200) holds. The
+// monitor cancels a line reaching it, printing a backslash, as though the line were never received.
+const MONITOR_INPUT_LINE_LIMIT = 0x0100
+
+// MONITOR_INPUT_BUFFER_ADDRESS is the address of the monitor input buffer, which the simulated monitor
+// fills like the real one.
+const MONITOR_INPUT_BUFFER_ADDRESS = 0x0200
+
+// simulatedExecution is the state of the memory when the simulated monitor executed the client: the
+// track and sector in its IOB, and the 4KB track buffer.
+type simulatedExecution struct {
+	trackNum  int
+	sectorNum int
+	buffer    []byte
+}
+
+// MonitorSimulator receives the command stream in place of the apple ][, parsing each line as the
+// monitor does into a 64K memory map. The characters arrive one every charTime. While the monitor
+// processes a line (lineProcessingTime, plus the time of a move or memory dump, or clientTime for each
+// program executed) or scrolls the screen as the echoed line wraps, the serial card holds the first
+// character arriving and the rest are lost, unless flowControl holds them off. A lost character is
+// counted as padding when it is a space at the start of a line as sent, or the end of a line of spaces,
+// and as a command character otherwise. The client is the program at clientAddress, and its track
+// buffer is at bufferAddress.
+type MonitorSimulator struct {
+	memory               [0x10000]byte
+	clientAddress        int
+	bufferAddress        int
+	charTime             time.Duration
+	lineProcessingTime   time.Duration
+	clientTime           time.Duration
+	flowControl          bool
+	clock                time.Duration
+	busyUntil            time.Duration
+	heldChar             byte
+	holding              bool
+	sentAtLineStart      bool
+	line                 []byte
+	column               int
+	storeAddress         int
+	lineCount            int
+	cancelledLineCount   int
+	lostPadCharCount     int
+	lostCommandCharCount int
+	executions           []simulatedExecution
+	faults               *CharacterFaults
+	memoryFilepath       string
+}
+
+// CharacterFaults is a model of a noisy serial line, for the simulated monitor to receive characters
+// through: a fault starts at each character with the chance DropRate of dropping it, or CorruptRate of
+// corrupting it by flipping one of its 7 bits, and goes on for BurstLength characters. The faults are
+// drawn from random, so that the same seed gives the same faults.
+type CharacterFaults struct {
+	DropRate           float64
+	CorruptRate        float64
+	BurstLength        int
+	Random             *rand.Rand
+	burstCharsLeft     int
+	burstDrops         bool
+	droppedCharCount   int
+	corruptedCharCount int
+}
+
+// pass passes the character c through the faults, corrupting it in place, and tells whether it arrives
+// at all.
+func (f *CharacterFaults) pass(c *byte) bool {
+	if f.burstCharsLeft == 0 {
+		var chance float64 = f.Random.Float64()
+		if chance < f.DropRate {
+			f.burstCharsLeft = f.BurstLength
+			f.burstDrops = true
+		} else if chance < f.DropRate+f.CorruptRate {
+			f.burstCharsLeft = f.BurstLength
+			f.burstDrops = false
+		} else {
+			return true
+		}
+	}
+	f.burstCharsLeft = f.burstCharsLeft - 1
+	if f.burstDrops {
+		f.droppedCharCount = f.droppedCharCount + 1
+		return false
+	}
+	*c = *c ^ byte(1<<uint(f.Random.Intn(7)))
+	f.corruptedCharCount = f.corruptedCharCount + 1
+	return true
+}
+
+// NewMonitorSimulator returns a simulated monitor receiving the command stream at baud bits per second
+// with bitsPerChar bits per character, through faults when not nil, for the client at clientAddress
+// with its track buffer at bufferAddress, writing its memory to memoryFilepath (when not empty) once
+// the simulation is reported.
+func NewMonitorSimulator(baud int, bitsPerChar int, lineProcessingTime time.Duration, clientTime time.Duration, flowControl bool, faults *CharacterFaults, memoryFilepath string, clientAddress int, bufferAddress int) *MonitorSimulator {
+	return &MonitorSimulator{
+		clientAddress:      clientAddress,
+		bufferAddress:      bufferAddress,
+		charTime:           time.Duration(float64(bitsPerChar) / float64(baud) * float64(time.Second)),
+		lineProcessingTime: lineProcessingTime,
+		clientTime:         clientTime,
+		flowControl:        flowControl,
+		sentAtLineStart:    true,
+		column:             1,
+		faults:             faults,
+		memoryFilepath:     memoryFilepath}
+}
+
+// Write implements io.Writer, receiving each character of p as it would arrive over the serial line.
+func (s *MonitorSimulator) Write(p []byte) (int, error) {
+	for _, b := range p {
+		s.receive(b & 0x7F)
+	}
+	return len(p), nil
+}
+
+// receive takes the character c arriving one character time after the one before it, keeping it in
+// the serial card, losing it or passing it to the monitor, depending on whether the monitor is busy.
+// With faults, the character may instead be dropped or corrupted on the way.
+func (s *MonitorSimulator) receive(c byte) {
+	var isPad bool = (c == ' ' || c == '\r') && s.sentAtLineStart
+	if c == '\r' {
+		s.sentAtLineStart = true
+	} else if c != ' ' {
+		s.sentAtLineStart = false
+	}
+	s.clock = s.clock + s.charTime
+	for s.holding && s.busyUntil <= s.clock {
+		s.holding = false
+		s.accept(s.heldChar, s.busyUntil)
+	}
+	if s.faults != nil && !s.faults.pass(&c) {
+		return
+	}
+	if s.clock < s.busyUntil {
+		if s.flowControl {
+			// the character is held off until the monitor is ready for it
+			s.clock = s.busyUntil
+		} else if !s.holding {
+			s.heldChar = c
+			s.holding = true
+			return
+		} else {
+			if isPad {
+				s.lostPadCharCount = s.lostPadCharCount + 1
+			} else {
+				s.lostCommandCharCount = s.lostCommandCharCount + 1
+			}
+			return
+		}
+	}
+	s.accept(c, s.clock)
+}
+
+// accept passes the character c to the monitor at time at: it is stored in the input buffer and
+// echoed, and a carriage return has the line executed.
+func (s *MonitorSimulator) accept(c byte, at time.Duration) {
+	s.memory[MONITOR_INPUT_BUFFER_ADDRESS+len(s.line)] = c | 0x80
+	if c == '\r' {
+		s.lineCount = s.lineCount + 1
+		s.busyUntil = at + s.lineProcessingTime + s.executeLine(s.line)
+		s.line = s.line[:0]
+		s.column = 1
+		return
+	}
+	s.line = append(s.line, c)
+	if len(s.line) == MONITOR_INPUT_LINE_LIMIT {
+		s.cancelledLineCount = s.cancelledLineCount + 1
+		s.busyUntil = at + s.lineProcessingTime
+		s.line = s.line[:0]
+		s.column = 1
+		return
+	}
+	s.column = s.column + 1
+	if s.column == emulator.SCREEN_COLUMNS {
+		// the echoed line wraps, scrolling the screen as the end of a line does
+		s.busyUntil = at + s.lineProcessingTime
+		s.column = 0
+	}
+}
+
+// executeLine carries out the monitor commands of line on the memory, and returns the time they take
+// beyond that of processing any line. A number followed by a colon starts storing at its address, and
+// a colon alone continues storing after the last byte stored; the numbers after it are stored until N.
+// A range A1.A2 is displayed, or with DEST<A1.A2M moved, and a number followed by G is executed.
+func (s *MonitorSimulator) executeLine(line []byte) time.Duration {
+	var extraTime time.Duration
+	var storing bool = false
+	var number int = 0
+	var digitCount int = 0
+	var rangeStart int = -1
+	var destination int = 0
+	for pos := 0; pos <= len(line); pos = pos + 1 {
+		var c byte = '\r'
+		if pos < len(line) {
+			c = line[pos]
+		}
+		var digit int = strings.IndexByte("0123456789ABCDEF", c)
+		if digit >= 0 {
+			number = (number<<4 | digit) & 0xFFFF
+			digitCount = digitCount + 1
+			continue
+		}
+		var haveNumber bool = digitCount > 0
+		digitCount = 0
+		switch {
+		case c == ':':
+			if haveNumber {
+				s.storeAddress = number
+			}
+			storing = true
+			rangeStart = -1
+		case c == '.' && haveNumber:
+			rangeStart = number
+		case c == '<' && haveNumber:
+			destination = number
+		case c == 'M' && haveNumber && rangeStart >= 0:
+			for address := rangeStart; address <= number; address = address + 1 {
+				s.memory[(destination+address-rangeStart)&0xFFFF] = s.memory[address]
+			}
+			extraTime = extraTime + time.Duration(number-rangeStart+1)*emulator.MONITOR_MOVE_TIME_PER_BYTE
+			rangeStart = -1
+		case c == 'G' && haveNumber:
+			s.execute(number)
+			extraTime = extraTime + s.clientTime
+		case c == 'N':
+			storing = false
+		case haveNumber && storing:
+			s.memory[s.storeAddress&0xFFFF] = byte(number)
+			s.storeAddress = s.storeAddress + 1
+		case haveNumber && rangeStart >= 0:
+			// each line of the memory dump is printed and scrolled like a command line
+			extraTime = extraTime + time.Duration((number-rangeStart)/8+1)*s.lineProcessingTime
+			rangeStart = -1
+		}
+		number = 0
+	}
+	return extraTime
+}
+
+// execute records the memory seen by the client when the program at address is executed. Other
+// programs, such as the read back program, are taken to leave the memory unchanged.
+func (s *MonitorSimulator) execute(address int) {
+	if address != s.clientAddress {
+		return
+	}
+	var execution simulatedExecution = simulatedExecution{
+		trackNum:  int(s.memory[s.clientAddress+0x20]),
+		sectorNum: int(s.memory[s.clientAddress+0x21]),
+		buffer:    make([]byte, 0x1000)}
+	copy(execution.buffer, s.memory[s.bufferAddress:s.bufferAddress+0x1000])
+	s.executions = append(s.executions, execution)
+}
+
+// countDifferingBytes returns the count of bytes of actual differing from expected, storing the
+// position of the first of them into firstPos.
+func countDifferingBytes(firstPos *int, actual []byte, expected []byte) int {
+	var differingCount int = 0
+	for i := range expected {
+		if actual[i] != expected[i] {
+			if differingCount == 0 {
+				*firstPos = i
+			}
+			differingCount = differingCount + 1
+		}
+	}
+	return differingCount
+}
+
+// report reports to stderr the characters the simulated monitor lost and, for each time it executed
+// the client, whether the memory held the track (or with the sector clientStrategy, the sector) of the
+// diskImage slice named in the IOB. With dataOnly, the track buffer is compared at the end against the
+// first of trackNums instead. The memory is then written to the memory file, if any. A client executed
+// a different number of times than trackNums need, or a buffer differing, is returned as an error.
+// Command characters lost alone are not, as those of the ramp-up are expected to be.
+func (s *MonitorSimulator) Report(diskImage []byte, trackNums []int, clientStrategy string, dataOnly bool) error {
+	if s.holding {
+		s.holding = false
+		s.accept(s.heldChar, s.busyUntil)
+	}
+	fmt.Fprintf(os.Stderr, "simulated monitor received %d lines in %s: %d padding characters lost, %d command characters lost, %d lines cancelled\n",
+		s.lineCount, s.clock.Round(time.Millisecond), s.lostPadCharCount, s.lostCommandCharCount, s.cancelledLineCount)
+	if s.faults != nil {
+		fmt.Fprintf(os.Stderr, "the line faults dropped %d characters and corrupted %d\n", s.faults.droppedCharCount, s.faults.corruptedCharCount)
+	}
+	var failedCheckCount int = 0
+	var firstPos int
+	if dataOnly {
+		var trackStartPos int = diskimage.DiskImageStartPosOfTrackSector(trackNums[0], 0)
+		var differingCount int = countDifferingBytes(&firstPos, s.memory[s.bufferAddress:s.bufferAddress+0x1000], diskImage[trackStartPos:trackStartPos+0x1000])
+		if differingCount == 0 {
+			fmt.Fprintf(os.Stderr, "track %d loaded: memory matches\n", trackNums[0])
+		} else {
+			fmt.Fprintf(os.Stderr, "track %d loaded: %d bytes differ, the first at %04X\n", trackNums[0], differingCount, s.bufferAddress+firstPos)
+			failedCheckCount = failedCheckCount + 1
+		}
+	} else {
+		var executionCount int = len(trackNums)
+		if clientStrategy == "sector" {
+			executionCount = 0x10 * len(trackNums)
+		}
+		if len(s.executions) != executionCount {
+			fmt.Fprintf(os.Stderr, "client executed %d times instead of %d\n", len(s.executions), executionCount)
+			failedCheckCount = failedCheckCount + 1
+		}
+		for _, execution := range s.executions {
+			var subject string = fmt.Sprintf("track %d", execution.trackNum)
+			var actual []byte = execution.buffer
+			var expected []byte
+			if execution.trackNum > 0x22 || execution.sectorNum > 0x0F {
+				fmt.Fprintf(os.Stderr, "client executed for illegal track %d sector %d\n", execution.trackNum, execution.sectorNum)
+				failedCheckCount = failedCheckCount + 1
+				continue
+			} else if clientStrategy == "sector" {
+				subject = fmt.Sprintf("track %d sector %d", execution.trackNum, execution.sectorNum)
+				var sectorStartPos int = diskimage.DiskImageStartPosOfTrackSector(execution.trackNum, execution.sectorNum)
+				expected = diskImage[sectorStartPos : sectorStartPos+0x0100]
+			} else {
+				var trackStartPos int = diskimage.DiskImageStartPosOfTrackSector(execution.trackNum, 0)
+				expected = diskImage[trackStartPos : trackStartPos+0x1000]
+			}
+			var differingCount int = countDifferingBytes(&firstPos, actual, expected)
+			if differingCount == 0 {
+				fmt.Fprintf(os.Stderr, "client executed for %s: memory matches\n", subject)
+			} else {
+				fmt.Fprintf(os.Stderr, "client executed for %s: %d bytes differ, the first at %04X\n", subject, differingCount, s.bufferAddress+firstPos)
+				failedCheckCount = failedCheckCount + 1
+			}
+		}
+	}
+	if s.memoryFilepath != "" {
+		var err error = ioutil.WriteFile(s.memoryFilepath, s.memory[:], 0644)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "wrote the simulated memory to file %s\n", s.memoryFilepath)
+	}
+	if failedCheckCount > 0 {
+		return failure.CodedErrorf(failure.KIND_SIMULATION_FAILED, "simulated install failed %d memory checks, with %d command characters lost", failedCheckCount, s.lostCommandCharCount)
+	}
+	return nil
+}
+
+// Monitor simulation section end
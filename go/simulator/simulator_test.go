@@ -0,0 +1,39 @@
+package simulator
+
+import "math/rand"
+import "testing"
+
+// TestCharacterFaults checks that the fault model drops or corrupts the characters as often as asked,
+// in bursts, flipping a single bit of each corrupted character, and gives the same faults for a seed.
+func TestCharacterFaults(t *testing.T) {
+	var message []byte = []byte("0800:A9 00 85 06 A2 10 AD 8B C0 29 08\r")
+	var received = func(faults *CharacterFaults) string {
+		var arrived []byte
+		for _, c := range message {
+			if faults.pass(&c) {
+				arrived = append(arrived, c)
+			}
+		}
+		return string(arrived)
+	}
+	var dropping *CharacterFaults = &CharacterFaults{DropRate: 1, BurstLength: 3, Random: rand.New(rand.NewSource(1))}
+	if received(dropping) != "" || dropping.droppedCharCount != len(message) {
+		t.Errorf("dropping every character left %d characters", len(message)-dropping.droppedCharCount)
+	}
+	var corrupting *CharacterFaults = &CharacterFaults{CorruptRate: 1, BurstLength: 1, Random: rand.New(rand.NewSource(1))}
+	var corrupted string = received(corrupting)
+	for i := range message {
+		var flipped byte = corrupted[i] ^ message[i]
+		if flipped == 0 || flipped&(flipped-1) != 0 || flipped >= 0x80 {
+			t.Errorf("character %d was corrupted from %02X into %02X", i, message[i], corrupted[i])
+		}
+	}
+	var first string = received(&CharacterFaults{DropRate: 0.2, CorruptRate: 0.2, BurstLength: 2, Random: rand.New(rand.NewSource(7))})
+	var second string = received(&CharacterFaults{DropRate: 0.2, CorruptRate: 0.2, BurstLength: 2, Random: rand.New(rand.NewSource(7))})
+	if first != second || first == string(message) {
+		t.Errorf("the faults of the same seed gave %q and %q", first, second)
+	}
+	if received(&CharacterFaults{BurstLength: 1, Random: rand.New(rand.NewSource(1))}) != string(message) {
+		t.Errorf("a line without faults changed the characters")
+	}
+}